@@ -19,6 +19,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -35,6 +36,7 @@ import (
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/logfile"
 	"golang.org/x/oauth2/jws"
 )
 
@@ -77,8 +79,40 @@ const (
 	restartFileLinux    = cacheDirLinux + "/osconfig_agent_restart_required"
 	oldRestartFileLinux = oldConfigDirLinux + "/osconfig_agent_restart_required"
 
+	execPolicyFileLinux = oldConfigDirLinux + "/exec_policy.json"
+
+	inventoryPluginDirLinux = oldConfigDirLinux + "/inventory.d"
+
+	resourceProvidersFileLinux = oldConfigDirLinux + "/resource_providers.json"
+
+	repoOptionsFileLinux = oldConfigDirLinux + "/repo_options.json"
+
+	changeFreezeFileLinux = oldConfigDirLinux + "/change_freeze.json"
+
+	distroEOLFileLinux = oldConfigDirLinux + "/distro_eol.json"
+
+	guestPolicyCheckpointFileLinux = cacheDirLinux + "/guest_policy.checkpoint"
+
+	guestPolicyCacheFileLinux = cacheDirLinux + "/guest_policy.cache"
+
+	managedFilesRegistryLinux = cacheDirLinux + "/managed_files.json"
+
+	historyFileLinux = cacheDirLinux + "/history.jsonl"
+
+	driftStateFileLinux = cacheDirLinux + "/osconfig_drift.state"
+
+	localAPISocketLinux = cacheDirLinux + "/control.sock"
+
 	osConfigPollIntervalDefault = 10
 	osConfigMetadataPollTimeout = 60
+
+	checkStateConcurrencyDefault = 4
+
+	googetRetriesDefault = 2
+
+	packageQueryConcurrencyDefault = 4
+
+	packageQueryCacheTTLDefault = 2 * time.Minute
 )
 
 var (
@@ -86,6 +120,8 @@ var (
 	debug               = flag.Bool("debug", false, "set debug log verbosity")
 	stdout              = flag.Bool("stdout", false, "log to stdout")
 	disableLocalLogging = flag.Bool("disable_local_logging", false, "disable logging using event log or syslog")
+	logFile             = flag.String("log_file", "", "also write logs to this file, rotated according to osconfig-log-rotation")
+	privilegeHelper     = flag.Bool("privilege_helper", false, "run as an unprivileged user, running commands that need root through sudo and the privhelper command")
 
 	agentConfig   = &config{}
 	agentConfigMx sync.RWMutex
@@ -129,6 +165,36 @@ type config struct {
 	guestPoliciesEnabled    bool
 	osInventoryEnabled      bool
 	guestAttributesEnabled  bool
+	postPatchCleanup        []string
+	aptHold                 []string
+	checkStateConcurrency   int
+	historyRetention        time.Duration
+	uploadBucket            string
+	inventoryWorkerUser     string
+	windowsUpdateCatalog    bool
+	logRotation             logfile.Options
+	patchGuestEnvironment   bool
+	localAPIEnabled         bool
+	verificationBudget      time.Duration
+	userAgentSuffix         string
+	requestLabels           map[string]string
+	disableNpmInventory     bool
+	taskStagger             time.Duration
+	packageTimeouts         map[string]time.Duration
+	rpmdbDirect             bool
+	packageQueryCacheTTL    time.Duration
+	wslPackageInventory     bool
+	changeFreeze            string
+	microsoftUpdate         string
+	googetRetries           int
+	crashReportUpload       bool
+	crashCoreDump           bool
+	packageQueryConcurrency int
+	aptDeb822               bool
+	checkStateRate          int
+	packageRepositories     bool
+	verifySignatures        bool
+	signatureKeyrings       []string
 }
 
 func (c *config) parseFeatures(features string, enabled bool) {
@@ -141,10 +207,221 @@ func (c *config) parseFeatures(features string, enabled bool) {
 			c.guestPoliciesEnabled = enabled
 		case "osinventory":
 			c.osInventoryEnabled = enabled
+		case "rpmdb":
+			c.rpmdbDirect = enabled
 		}
 	}
 }
 
+// parseList parses a comma separated metadata value.
+func parseList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// parsePaths parses a comma separated list of absolute paths, relative
+// paths are dropped.
+func parsePaths(s string) []string {
+	var paths []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); filepath.IsAbs(e) {
+			paths = append(paths, e)
+		}
+	}
+	return paths
+}
+
+// parseRetention parses a duration metadata value, invalid or negative
+// values disable the feature.
+func parseRetention(s string) time.Duration {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// parseBucket parses a bucket metadata value, both "bucket" and
+// "gs://bucket/" are accepted.
+func parseBucket(s string) string {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "gs://")
+	return strings.TrimSuffix(s, "/")
+}
+
+// parseLogRotation parses a comma separated list of key=value log rotation
+// settings, for example "max-size=50M,max-backups=10,compress=false".
+// Unset or invalid settings keep their default.
+func parseLogRotation(s string) logfile.Options {
+	opts := logfile.DefaultOptions
+	for _, e := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "max-size":
+			if n, err := parseSize(v); err == nil {
+				opts.MaxSize = n
+			}
+		case "interval":
+			if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+				opts.Interval = d
+			}
+		case "max-backups":
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				opts.MaxBackups = n
+			}
+		case "max-age":
+			if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+				opts.MaxAge = d
+			}
+		case "compress":
+			if b, err := strconv.ParseBool(v); err == nil {
+				opts.Compress = b
+			}
+		}
+	}
+	return opts
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	u := strings.TrimSuffix(strings.ToUpper(s), "B")
+	switch {
+	case strings.HasSuffix(u, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(u, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(u, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		u = u[:len(u)-1]
+	}
+	n, err := strconv.ParseInt(u, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+const (
+	maxUserAgentSuffix = 128
+	maxRequestLabels   = 16
+	maxLabelLength     = 63
+)
+
+// printableASCII reports whether s only contains printable ASCII, which is
+// all that may be sent in a header.
+func printableASCII(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// parseUserAgentSuffix parses a user agent suffix metadata value, values
+// that are too long or not printable ASCII are ignored.
+func parseUserAgentSuffix(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxUserAgentSuffix || !printableASCII(s) {
+		return ""
+	}
+	return s
+}
+
+// validLabelKey reports whether k can be used in a gRPC metadata key, it
+// must be lowercase letters, digits, dashes and underscores.
+func validLabelKey(k string) bool {
+	if k == "" || len(k) > maxLabelLength {
+		return false
+	}
+	for _, r := range k {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRequestLabels parses a comma separated list of key=value request
+// labels, for example "fleet=web,env=prod". Keys are lowercased, invalid
+// labels are ignored.
+func parseRequestLabels(s string) map[string]string {
+	labels := map[string]string{}
+	for _, e := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok {
+			continue
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if !validLabelKey(k) || len(v) > maxLabelLength || !printableASCII(v) {
+			continue
+		}
+		if _, ok := labels[k]; !ok && len(labels) == maxRequestLabels {
+			continue
+		}
+		labels[k] = v
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// parsePackageTimeouts parses a comma separated list of package operation
+// timeouts, for example "download=30m,apt.install=2h". Keys are an
+// operation class, optionally prefixed by apt, yum, zypper or googet. The
+// resolve timeout can also be set for the other package managers queried
+// for inventory, e.g. "pip.resolve=5m". Invalid entries are ignored.
+func parsePackageTimeouts(s string) map[string]time.Duration {
+	timeouts := map[string]time.Duration{}
+	for _, e := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok {
+			continue
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		manager, class, ok := strings.Cut(k, ".")
+		if !ok {
+			manager, class = "", k
+		}
+		switch manager {
+		case "", "apt", "yum", "zypper", "googet":
+		case "rpm", "dnf", "deb", "cos", "apk", "pacman", "portage", "flatpak", "brew", "gem", "pip", "winget", "wua", "qfe", "apps":
+			if class != "resolve" {
+				continue
+			}
+		default:
+			continue
+		}
+		switch class {
+		case "refresh", "resolve", "download", "install":
+		default:
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d < 0 {
+			continue
+		}
+		timeouts[k] = d
+	}
+	if len(timeouts) == 0 {
+		return nil
+	}
+	return timeouts
+}
+
 func (c *config) asSha256() string {
 	h := sha256.New()
 	h.Write([]byte(fmt.Sprintf("%v", c)))
@@ -184,6 +461,16 @@ func parseBool(s string) bool {
 	return enabled
 }
 
+// parseOptionalBool returns "true" or "false", or "" for a bad entry so that
+// the setting is treated as unset.
+func parseOptionalBool(s string) string {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatBool(b)
+}
+
 type metadataJSON struct {
 	Instance instanceJSON
 	Project  projectJSON
@@ -216,6 +503,35 @@ type attributesJSON struct {
 	OSConfigEnabled       string       `json:"enable-osconfig"`
 	DisabledFeatures      string       `json:"osconfig-disabled-features"`
 	EnableGuestAttributes string       `json:"enable-guest-attributes"`
+	PostPatchCleanup      *string      `json:"osconfig-post-patch-cleanup"`
+	AptHold               *string      `json:"osconfig-apt-hold"`
+	CheckStateConcurrency *json.Number `json:"osconfig-check-state-concurrency"`
+	HistoryRetention      *string      `json:"osconfig-history-retention"`
+	UploadBucket          *string      `json:"osconfig-upload-bucket"`
+	InventoryWorkerUser   *string      `json:"osconfig-inventory-worker-user"`
+	WindowsUpdateCatalog  *string      `json:"osconfig-windows-update-catalog"`
+	LogRotation           *string      `json:"osconfig-log-rotation"`
+	PatchGuestEnvironment *string      `json:"osconfig-patch-guest-environment"`
+	LocalAPIEnabled       *string      `json:"osconfig-local-api-enabled"`
+	VerificationBudget    *string      `json:"osconfig-package-verification-budget"`
+	UserAgentSuffix       *string      `json:"osconfig-user-agent-suffix"`
+	RequestLabels         *string      `json:"osconfig-request-labels"`
+	DisableNpmInventory   *string      `json:"osconfig-disable-npm-inventory"`
+	TaskStagger           *string      `json:"osconfig-task-stagger"`
+	PackageTimeouts       *string      `json:"osconfig-package-timeouts"`
+	PackageQueryCacheTTL  *string      `json:"osconfig-package-query-cache-ttl"`
+	WSLPackageInventory   *string      `json:"osconfig-wsl-package-inventory"`
+	ChangeFreeze          *string      `json:"osconfig-change-freeze"`
+	MicrosoftUpdate       *string      `json:"osconfig-microsoft-update"`
+	GooGetRetries         *json.Number `json:"osconfig-googet-retries"`
+	CrashReportUpload     *string      `json:"osconfig-crash-report-upload"`
+	CrashCoreDump         *string      `json:"osconfig-crash-core-dump"`
+	PackageConcurrency    *json.Number `json:"osconfig-package-query-concurrency"`
+	AptDeb822             *string      `json:"osconfig-apt-deb822"`
+	CheckStateRate        *json.Number `json:"osconfig-check-state-rate"`
+	PackageRepositories   *string      `json:"osconfig-package-repositories"`
+	VerifySignatures      *string      `json:"osconfig-verify-package-signatures"`
+	SignatureKeyrings     *string      `json:"osconfig-package-signature-keyrings"`
 }
 
 func createConfigFromMetadata(md metadataJSON) *config {
@@ -227,6 +543,11 @@ func createConfigFromMetadata(md metadataJSON) *config {
 		debugEnabled:            debugEnabledDefault,
 		svcEndpoint:             prodEndpoint,
 		osConfigPollInterval:    osConfigPollIntervalDefault,
+		checkStateConcurrency:   checkStateConcurrencyDefault,
+		logRotation:             logfile.DefaultOptions,
+		packageQueryCacheTTL:    packageQueryCacheTTLDefault,
+		googetRetries:           googetRetriesDefault,
+		packageQueryConcurrency: packageQueryConcurrencyDefault,
 
 		googetRepoFilePath: googetRepoFilePath,
 		zypperRepoFilePath: zypperRepoFilePath,
@@ -341,6 +662,225 @@ func createConfigFromMetadata(md metadataJSON) *config {
 		c.guestAttributesEnabled = parseBool(md.Instance.Attributes.EnableGuestAttributes)
 	}
 
+	switch {
+	case md.Instance.Attributes.PostPatchCleanup != nil:
+		c.postPatchCleanup = parseList(*md.Instance.Attributes.PostPatchCleanup)
+	case md.Project.Attributes.PostPatchCleanup != nil:
+		c.postPatchCleanup = parseList(*md.Project.Attributes.PostPatchCleanup)
+	}
+
+	switch {
+	case md.Instance.Attributes.AptHold != nil:
+		c.aptHold = parseList(*md.Instance.Attributes.AptHold)
+	case md.Project.Attributes.AptHold != nil:
+		c.aptHold = parseList(*md.Project.Attributes.AptHold)
+	}
+
+	switch {
+	case md.Instance.Attributes.CheckStateConcurrency != nil:
+		if val, err := md.Instance.Attributes.CheckStateConcurrency.Int64(); err == nil {
+			c.checkStateConcurrency = int(val)
+		}
+	case md.Project.Attributes.CheckStateConcurrency != nil:
+		if val, err := md.Project.Attributes.CheckStateConcurrency.Int64(); err == nil {
+			c.checkStateConcurrency = int(val)
+		}
+	}
+
+	switch {
+	case md.Instance.Attributes.HistoryRetention != nil:
+		c.historyRetention = parseRetention(*md.Instance.Attributes.HistoryRetention)
+	case md.Project.Attributes.HistoryRetention != nil:
+		c.historyRetention = parseRetention(*md.Project.Attributes.HistoryRetention)
+	}
+
+	switch {
+	case md.Instance.Attributes.UploadBucket != nil:
+		c.uploadBucket = parseBucket(*md.Instance.Attributes.UploadBucket)
+	case md.Project.Attributes.UploadBucket != nil:
+		c.uploadBucket = parseBucket(*md.Project.Attributes.UploadBucket)
+	}
+
+	switch {
+	case md.Instance.Attributes.InventoryWorkerUser != nil:
+		c.inventoryWorkerUser = strings.TrimSpace(*md.Instance.Attributes.InventoryWorkerUser)
+	case md.Project.Attributes.InventoryWorkerUser != nil:
+		c.inventoryWorkerUser = strings.TrimSpace(*md.Project.Attributes.InventoryWorkerUser)
+	}
+
+	switch {
+	case md.Instance.Attributes.WindowsUpdateCatalog != nil:
+		c.windowsUpdateCatalog = parseBool(*md.Instance.Attributes.WindowsUpdateCatalog)
+	case md.Project.Attributes.WindowsUpdateCatalog != nil:
+		c.windowsUpdateCatalog = parseBool(*md.Project.Attributes.WindowsUpdateCatalog)
+	}
+
+	switch {
+	case md.Instance.Attributes.LogRotation != nil:
+		c.logRotation = parseLogRotation(*md.Instance.Attributes.LogRotation)
+	case md.Project.Attributes.LogRotation != nil:
+		c.logRotation = parseLogRotation(*md.Project.Attributes.LogRotation)
+	}
+
+	switch {
+	case md.Instance.Attributes.PatchGuestEnvironment != nil:
+		c.patchGuestEnvironment = parseBool(*md.Instance.Attributes.PatchGuestEnvironment)
+	case md.Project.Attributes.PatchGuestEnvironment != nil:
+		c.patchGuestEnvironment = parseBool(*md.Project.Attributes.PatchGuestEnvironment)
+	}
+
+	switch {
+	case md.Instance.Attributes.LocalAPIEnabled != nil:
+		c.localAPIEnabled = parseBool(*md.Instance.Attributes.LocalAPIEnabled)
+	case md.Project.Attributes.LocalAPIEnabled != nil:
+		c.localAPIEnabled = parseBool(*md.Project.Attributes.LocalAPIEnabled)
+	}
+
+	switch {
+	case md.Instance.Attributes.VerificationBudget != nil:
+		c.verificationBudget = parseRetention(*md.Instance.Attributes.VerificationBudget)
+	case md.Project.Attributes.VerificationBudget != nil:
+		c.verificationBudget = parseRetention(*md.Project.Attributes.VerificationBudget)
+	}
+
+	switch {
+	case md.Instance.Attributes.UserAgentSuffix != nil:
+		c.userAgentSuffix = parseUserAgentSuffix(*md.Instance.Attributes.UserAgentSuffix)
+	case md.Project.Attributes.UserAgentSuffix != nil:
+		c.userAgentSuffix = parseUserAgentSuffix(*md.Project.Attributes.UserAgentSuffix)
+	}
+
+	switch {
+	case md.Instance.Attributes.RequestLabels != nil:
+		c.requestLabels = parseRequestLabels(*md.Instance.Attributes.RequestLabels)
+	case md.Project.Attributes.RequestLabels != nil:
+		c.requestLabels = parseRequestLabels(*md.Project.Attributes.RequestLabels)
+	}
+
+	switch {
+	case md.Instance.Attributes.DisableNpmInventory != nil:
+		c.disableNpmInventory = parseBool(*md.Instance.Attributes.DisableNpmInventory)
+	case md.Project.Attributes.DisableNpmInventory != nil:
+		c.disableNpmInventory = parseBool(*md.Project.Attributes.DisableNpmInventory)
+	}
+
+	switch {
+	case md.Instance.Attributes.TaskStagger != nil:
+		c.taskStagger = parseRetention(*md.Instance.Attributes.TaskStagger)
+	case md.Project.Attributes.TaskStagger != nil:
+		c.taskStagger = parseRetention(*md.Project.Attributes.TaskStagger)
+	}
+
+	switch {
+	case md.Instance.Attributes.PackageTimeouts != nil:
+		c.packageTimeouts = parsePackageTimeouts(*md.Instance.Attributes.PackageTimeouts)
+	case md.Project.Attributes.PackageTimeouts != nil:
+		c.packageTimeouts = parsePackageTimeouts(*md.Project.Attributes.PackageTimeouts)
+	}
+
+	switch {
+	case md.Instance.Attributes.PackageQueryCacheTTL != nil:
+		c.packageQueryCacheTTL = parseRetention(*md.Instance.Attributes.PackageQueryCacheTTL)
+	case md.Project.Attributes.PackageQueryCacheTTL != nil:
+		c.packageQueryCacheTTL = parseRetention(*md.Project.Attributes.PackageQueryCacheTTL)
+	}
+
+	switch {
+	case md.Instance.Attributes.WSLPackageInventory != nil:
+		c.wslPackageInventory = parseBool(*md.Instance.Attributes.WSLPackageInventory)
+	case md.Project.Attributes.WSLPackageInventory != nil:
+		c.wslPackageInventory = parseBool(*md.Project.Attributes.WSLPackageInventory)
+	}
+
+	switch {
+	case md.Instance.Attributes.ChangeFreeze != nil:
+		c.changeFreeze = *md.Instance.Attributes.ChangeFreeze
+	case md.Project.Attributes.ChangeFreeze != nil:
+		c.changeFreeze = *md.Project.Attributes.ChangeFreeze
+	}
+
+	switch {
+	case md.Instance.Attributes.MicrosoftUpdate != nil:
+		c.microsoftUpdate = parseOptionalBool(*md.Instance.Attributes.MicrosoftUpdate)
+	case md.Project.Attributes.MicrosoftUpdate != nil:
+		c.microsoftUpdate = parseOptionalBool(*md.Project.Attributes.MicrosoftUpdate)
+	}
+
+	switch {
+	case md.Instance.Attributes.GooGetRetries != nil:
+		if val, err := md.Instance.Attributes.GooGetRetries.Int64(); err == nil && val >= 0 {
+			c.googetRetries = int(val)
+		}
+	case md.Project.Attributes.GooGetRetries != nil:
+		if val, err := md.Project.Attributes.GooGetRetries.Int64(); err == nil && val >= 0 {
+			c.googetRetries = int(val)
+		}
+	}
+
+	switch {
+	case md.Instance.Attributes.CrashReportUpload != nil:
+		c.crashReportUpload = parseBool(*md.Instance.Attributes.CrashReportUpload)
+	case md.Project.Attributes.CrashReportUpload != nil:
+		c.crashReportUpload = parseBool(*md.Project.Attributes.CrashReportUpload)
+	}
+
+	switch {
+	case md.Instance.Attributes.CrashCoreDump != nil:
+		c.crashCoreDump = parseBool(*md.Instance.Attributes.CrashCoreDump)
+	case md.Project.Attributes.CrashCoreDump != nil:
+		c.crashCoreDump = parseBool(*md.Project.Attributes.CrashCoreDump)
+	}
+
+	switch {
+	case md.Instance.Attributes.PackageConcurrency != nil:
+		if val, err := md.Instance.Attributes.PackageConcurrency.Int64(); err == nil {
+			c.packageQueryConcurrency = int(val)
+		}
+	case md.Project.Attributes.PackageConcurrency != nil:
+		if val, err := md.Project.Attributes.PackageConcurrency.Int64(); err == nil {
+			c.packageQueryConcurrency = int(val)
+		}
+	}
+
+	switch {
+	case md.Instance.Attributes.AptDeb822 != nil:
+		c.aptDeb822 = parseBool(*md.Instance.Attributes.AptDeb822)
+	case md.Project.Attributes.AptDeb822 != nil:
+		c.aptDeb822 = parseBool(*md.Project.Attributes.AptDeb822)
+	}
+
+	switch {
+	case md.Instance.Attributes.CheckStateRate != nil:
+		if val, err := md.Instance.Attributes.CheckStateRate.Int64(); err == nil && val >= 0 {
+			c.checkStateRate = int(val)
+		}
+	case md.Project.Attributes.CheckStateRate != nil:
+		if val, err := md.Project.Attributes.CheckStateRate.Int64(); err == nil && val >= 0 {
+			c.checkStateRate = int(val)
+		}
+	}
+
+	switch {
+	case md.Instance.Attributes.PackageRepositories != nil:
+		c.packageRepositories = parseBool(*md.Instance.Attributes.PackageRepositories)
+	case md.Project.Attributes.PackageRepositories != nil:
+		c.packageRepositories = parseBool(*md.Project.Attributes.PackageRepositories)
+	}
+
+	switch {
+	case md.Instance.Attributes.VerifySignatures != nil:
+		c.verifySignatures = parseBool(*md.Instance.Attributes.VerifySignatures)
+	case md.Project.Attributes.VerifySignatures != nil:
+		c.verifySignatures = parseBool(*md.Project.Attributes.VerifySignatures)
+	}
+
+	switch {
+	case md.Instance.Attributes.SignatureKeyrings != nil:
+		c.signatureKeyrings = parsePaths(*md.Instance.Attributes.SignatureKeyrings)
+	case md.Project.Attributes.SignatureKeyrings != nil:
+		c.signatureKeyrings = parsePaths(*md.Project.Attributes.SignatureKeyrings)
+	}
+
 	// Flags take precedence over metadata.
 	if *debug {
 		c.debugEnabled = true
@@ -383,7 +923,8 @@ func formatMetadataError(err error) error {
 	return err
 }
 
-func getMetadata(suffix string) ([]byte, string, error) {
+// MetadataHost is the host the metadata server is reached at.
+func MetadataHost() string {
 	host := os.Getenv(metadataHostEnv)
 	if host == "" {
 		// Using 169.254.169.254 instead of "metadata" here because Go
@@ -393,7 +934,11 @@ func getMetadata(suffix string) ([]byte, string, error) {
 		// being stable anyway.
 		host = metadataIP
 	}
-	computeMetadataURL := "http://" + host + "/computeMetadata/v1/" + suffix
+	return host
+}
+
+func getMetadata(suffix string) ([]byte, string, error) {
+	computeMetadataURL := "http://" + MetadataHost() + "/computeMetadata/v1/" + suffix
 	req, err := http.NewRequest("GET", computeMetadataURL, nil)
 	if err != nil {
 		return nil, "", err
@@ -498,6 +1043,27 @@ func WatchConfig(ctx context.Context) error {
 	return webError
 }
 
+// ReadConfig reads the agent config from metadata once, without waiting for
+// a change. The config keeps its defaults if metadata can't be read.
+func ReadConfig() error {
+	md, _, err := getMetadata("?recursive=true&alt=json")
+	if err != nil {
+		return formatMetadataError(err)
+	}
+	if len(md) == 0 {
+		return errors.New("no metadata returned by the metadata server")
+	}
+	var metadataConfig metadataJSON
+	if err := json.Unmarshal(md, &metadataConfig); err != nil {
+		return err
+	}
+	newAgentConfig := createConfigFromMetadata(metadataConfig)
+	agentConfigMx.Lock()
+	agentConfig = newAgentConfig
+	agentConfigMx.Unlock()
+	return nil
+}
+
 // LogFeatures logs the osconfig feature status.
 func LogFeatures(ctx context.Context) {
 	clog.Infof(ctx, "OSConfig enabled features status:{GuestPolicies: %t, OSInventory: %t, PatchManagement: %t}.", GuestPoliciesEnabled(), OSInventoryEnabled(), TaskNotificationEnabled())
@@ -532,6 +1098,13 @@ func DisableLocalLogging() bool {
 	return *disableLocalLogging
 }
 
+// PrivilegeHelper reports whether the agent runs as an unprivileged user and
+// runs commands that need root through the privilege helper, set with the
+// privilege_helper flag. Only supported on Linux.
+func PrivilegeHelper() bool {
+	return *privilegeHelper
+}
+
 // SvcEndpoint is the OS Config service endpoint.
 func SvcEndpoint() string {
 	return getAgentConfig().svcEndpoint
@@ -582,6 +1155,25 @@ func AptRepoFilePath() string {
 	return getAgentConfig().aptRepoFilePath
 }
 
+// AptSourcesFormat is the format of the deb822 apt repo files.
+func AptSourcesFormat() string {
+	return filepath.Join(aptRepoDir, "osconfig_managed_%s.sources")
+}
+
+// AptSourcesFilePath is the location where the deb822 apt repo file will be
+// created.
+func AptSourcesFilePath() string {
+	return strings.TrimSuffix(getAgentConfig().aptRepoFilePath, ".list") + ".sources"
+}
+
+// AptDeb822 reports whether apt repositories are written as deb822 .sources
+// files that name their own keyring with Signed-By, instead of one-line
+// .list files with keys added to trusted.gpg.d, enabled with
+// osconfig-apt-deb822.
+func AptDeb822() bool {
+	return getAgentConfig().aptDeb822
+}
+
 // GooGetRepoDir is the location of the googet repo files.
 func GooGetRepoDir() string {
 	return googetRepoDir
@@ -612,6 +1204,135 @@ func TaskNotificationEnabled() bool {
 	return getAgentConfig().taskNotificationEnabled
 }
 
+// CheckStateConcurrency is the max number of file and repository resources
+// whose state is checked concurrently during an apply config task, set with
+// osconfig-check-state-concurrency. A value of 1 or less checks resources one
+// at a time.
+func CheckStateConcurrency() int {
+	return getAgentConfig().checkStateConcurrency
+}
+
+// CheckStateRate is the max number of resources whose state is checked per
+// minute during an apply config task, set with osconfig-check-state-rate.
+// Checks are spread evenly over each minute in the background before the
+// task is run, instead of running back to back, 0 does not limit the rate.
+func CheckStateRate() int {
+	return getAgentConfig().checkStateRate
+}
+
+// PackageRepositories reports whether the repository installed deb and rpm
+// packages came from is looked up and reported, enabled with
+// osconfig-package-repositories.
+func PackageRepositories() bool {
+	return getAgentConfig().packageRepositories
+}
+
+// PackageSignatures reports whether deb and rpm package artifacts must carry
+// a valid signature before they are installed, set with
+// osconfig-verify-package-signatures, and the keyrings they are verified
+// against, set with osconfig-package-signature-keyrings. Setting keyrings
+// implies verification.
+func PackageSignatures() (verify bool, keyrings []string) {
+	c := getAgentConfig()
+	return c.verifySignatures || len(c.signatureKeyrings) > 0, c.signatureKeyrings
+}
+
+// HistoryRetention is how long inventory and compliance records are kept in
+// the local history file, set with osconfig-history-retention (e.g. "168h").
+// Zero, the default, disables recording history.
+func HistoryRetention() time.Duration {
+	return getAgentConfig().historyRetention
+}
+
+// UploadBucket is the GCS bucket support bundles, patch reports and large
+// exec outputs are uploaded to, set with osconfig-upload-bucket. Uploads are
+// disabled if empty.
+func UploadBucket() string {
+	return getAgentConfig().uploadBucket
+}
+
+// InventoryWorkerUser is the unprivileged user inventory is collected as,
+// set with osconfig-inventory-worker-user. If empty inventory is collected
+// by the agent process itself. Only supported on Linux.
+func InventoryWorkerUser() string {
+	return getAgentConfig().inventoryWorkerUser
+}
+
+// MicrosoftUpdate reports whether patch jobs register the Microsoft Update
+// service with Windows Update, so that updates for other Microsoft products
+// are installed, set with osconfig-microsoft-update. ok is false if it is not
+// set, the registration is then left as it is.
+func MicrosoftUpdate() (register, ok bool) {
+	v := getAgentConfig().microsoftUpdate
+	return v == "true", v != ""
+}
+
+// GooGetRetries is how often GooGet commands that fail with a network error,
+// or queries that time out, are retried, set with osconfig-googet-retries.
+func GooGetRetries() int {
+	return getAgentConfig().googetRetries
+}
+
+// WindowsUpdateCatalog reports whether patch jobs may download exclusive
+// patches from the Microsoft Update Catalog when Windows Update does not
+// offer them, set with osconfig-windows-update-catalog.
+func WindowsUpdateCatalog() bool {
+	return getAgentConfig().windowsUpdateCatalog
+}
+
+// LogFile is the local file to write agent logs to, set with the log_file
+// flag. Empty means no log file is written.
+func LogFile() string {
+	return *logFile
+}
+
+// LogRotation returns the rotation settings for LogFile, set with the
+// osconfig-log-rotation metadata key.
+func LogRotation() logfile.Options {
+	return getAgentConfig().logRotation
+}
+
+// PatchGuestEnvironment reports whether patch jobs update the GCE guest
+// environment packages, including this agent, before applying other
+// patches, set with osconfig-patch-guest-environment.
+func PatchGuestEnvironment() bool {
+	return getAgentConfig().patchGuestEnvironment
+}
+
+// LocalAPIEnabled reports whether the local control API is served on
+// LocalAPISocket, set with osconfig-local-api-enabled.
+func LocalAPIEnabled() bool {
+	return getAgentConfig().localAPIEnabled
+}
+
+// LocalAPISocket is the location of the local control API socket.
+func LocalAPISocket() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "control.sock")
+	}
+	return localAPISocketLinux
+}
+
+// PackageVerificationBudget is the time each inventory run may spend
+// verifying installed package files, set with
+// osconfig-package-verification-budget. Zero disables verification.
+func PackageVerificationBudget() time.Duration {
+	return getAgentConfig().verificationBudget
+}
+
+// PostPatchCleanup returns the cleanup steps to run after patching, set with
+// the osconfig-post-patch-cleanup metadata key. A patch job can override it
+// with its post-patch-cleanup label.
+func PostPatchCleanup() []string {
+	return getAgentConfig().postPatchCleanup
+}
+
+// AptHoldPackages returns the apt packages that OS policies keep held once
+// installed, set with the osconfig-apt-hold metadata key.
+func AptHoldPackages() []string {
+	return getAgentConfig().aptHold
+}
+
 // Instance is the URI of the instance the agent is running on.
 func Instance() string {
 	// Zone contains 'projects/project-id/zones' as a prefix.
@@ -733,6 +1454,109 @@ func OldRestartFile() string {
 	return oldRestartFileLinux
 }
 
+// ExecPolicyFile is the location of the local exec policy file.
+func ExecPolicyFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "exec_policy.json")
+	}
+
+	return execPolicyFileLinux
+}
+
+// InventoryPluginDir is the location of the inventory provider plugins.
+func InventoryPluginDir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "inventory.d")
+	}
+
+	return inventoryPluginDirLinux
+}
+
+// ResourceProvidersFile is the location of the external resource provider registry.
+func ResourceProvidersFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "resource_providers.json")
+	}
+
+	return resourceProvidersFileLinux
+}
+
+// RepoOptionsFile is the location of the local yum and zypper repository
+// options.
+func RepoOptionsFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "repo_options.json")
+	}
+
+	return repoOptionsFileLinux
+}
+
+// ChangeFreezeFile is the location of the local change freeze calendar.
+func ChangeFreezeFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "change_freeze.json")
+	}
+
+	return changeFreezeFileLinux
+}
+
+// DistroEOLFile is the location of the local table of end of life distro
+// releases.
+func DistroEOLFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "distro_eol.json")
+	}
+
+	return distroEOLFileLinux
+}
+
+// GuestPolicyCheckpointFile is the location of the guest policy run checkpoint.
+func GuestPolicyCheckpointFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "guest_policy.checkpoint")
+	}
+
+	return guestPolicyCheckpointFileLinux
+}
+
+// GuestPolicyCacheFile is the location of the record of the last applied
+// guest policy.
+func GuestPolicyCacheFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "guest_policy.cache")
+	}
+
+	return guestPolicyCacheFileLinux
+}
+
+// ManagedFilesRegistry is the location of the registry of checksums of the
+// files written by the agent.
+func ManagedFilesRegistry() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "managed_files.json")
+	}
+
+	return managedFilesRegistryLinux
+}
+
+// HistoryFile is the location of the local inventory and compliance history.
+func HistoryFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "history.jsonl")
+	}
+
+	return historyFileLinux
+}
+
+// DriftStateFile is the location of the OS policy resource drift counters.
+func DriftStateFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(GetCacheDirWindows(), "osconfig_drift.state")
+	}
+
+	return driftStateFileLinux
+}
+
 // CacheDir is the location of the cache directory.
 func CacheDir() string {
 	if runtime.GOOS == "windows" {
@@ -742,9 +1566,88 @@ func CacheDir() string {
 	return cacheDirLinux
 }
 
-// UserAgent for creating http/grpc clients.
+// UserAgent for creating http/grpc clients, including the suffix set with
+// osconfig-user-agent-suffix.
 func UserAgent() string {
-	return "google-osconfig-agent/" + Version()
+	ua := "google-osconfig-agent/" + Version()
+	if s := getAgentConfig().userAgentSuffix; s != "" {
+		ua += " " + s
+	}
+	return ua
+}
+
+// RequestLabels are the labels sent with every agent endpoint RPC, set with
+// osconfig-request-labels.
+func RequestLabels() map[string]string {
+	return getAgentConfig().requestLabels
+}
+
+// NpmInventoryEnabled reports whether globally installed npm packages are
+// listed in inventory, disabled with osconfig-disable-npm-inventory.
+func NpmInventoryEnabled() bool {
+	return !getAgentConfig().disableNpmInventory
+}
+
+// TaskStagger is the window over which the start of patch and apply config
+// tasks is spread on this instance, set with osconfig-task-stagger. The
+// server's stagger hint is used if it is larger.
+func TaskStagger() time.Duration {
+	return getAgentConfig().taskStagger
+}
+
+// PackageTimeouts are the timeouts of apt, yum, zypper and googet operations
+// and of inventory queries, keyed by operation class or manager and class,
+// set with osconfig-package-timeouts.
+func PackageTimeouts() map[string]time.Duration {
+	return getAgentConfig().packageTimeouts
+}
+
+// PackageQueryConcurrency is the max number of package managers queried at
+// once for installed packages and updates, set with
+// osconfig-package-query-concurrency. A value of 1 or less queries them one
+// at a time.
+func PackageQueryConcurrency() int {
+	return getAgentConfig().packageQueryConcurrency
+}
+
+// PackageQueryCacheTTL is how long installed package and available update
+// queries are reused, set with osconfig-package-query-cache-ttl. Zero
+// disables caching.
+func PackageQueryCacheTTL() time.Duration {
+	return getAgentConfig().packageQueryCacheTTL
+}
+
+// WSLPackageInventoryEnabled reports whether the packages installed in WSL
+// distributions are listed in inventory, enabled with
+// osconfig-wsl-package-inventory.
+func WSLPackageInventoryEnabled() bool {
+	return getAgentConfig().wslPackageInventory
+}
+
+// ChangeFreeze is the change freeze calendar set with osconfig-change-freeze,
+// it is parsed by the changefreeze package.
+func ChangeFreeze() string {
+	return getAgentConfig().changeFreeze
+}
+
+// CrashReportUpload reports whether crash reports left by a previous run are
+// sent to Cloud Logging when the agent starts, enabled with
+// osconfig-crash-report-upload.
+func CrashReportUpload() bool {
+	return getAgentConfig().crashReportUpload
+}
+
+// CrashCoreDump reports whether the agent aborts with a core dump when it
+// crashes, enabled with osconfig-crash-core-dump.
+func CrashCoreDump() bool {
+	return getAgentConfig().crashCoreDump
+}
+
+// RPMDBDirect reports whether installed rpm packages are read from the rpm
+// database files instead of rpmquery, enabled with the rpmdb prerelease
+// feature.
+func RPMDBDirect() bool {
+	return getAgentConfig().rpmdbDirect
 }
 
 // DisableInventoryWrite returns true if the DisableInventoryWrite setting is set.
@@ -16,6 +16,7 @@ package agentconfig
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -26,6 +27,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/logfile"
 )
 
 func TestWatchConfig(t *testing.T) {
@@ -88,6 +91,30 @@ func TestWatchConfig(t *testing.T) {
 	}
 }
 
+func TestReadConfig(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait_for_change") != "" {
+			t.Errorf("ReadConfig waited for a change: %s", r.URL)
+		}
+		fmt.Fprintln(w, `{"project":{"projectId":"projectId"},"instance":{"name":"name","zone":"zone","attributes":{"enable-os-inventory":"true"}}}`)
+	}))
+	defer ts.Close()
+
+	if err := os.Setenv("GCE_METADATA_HOST", strings.Trim(ts.URL, "http://")); err != nil {
+		t.Fatalf("Error running os.Setenv: %v", err)
+	}
+
+	if err := ReadConfig(); err != nil {
+		t.Fatalf("Error running ReadConfig: %v", err)
+	}
+	if got := Instance(); got != "zone/instances/name" {
+		t.Errorf("Instance() = %q, want %q", got, "zone/instances/name")
+	}
+	if !OSInventoryEnabled() {
+		t.Error("OSInventoryEnabled() = false, want true")
+	}
+}
+
 func TestSetConfigEnabled(t *testing.T) {
 	var request int
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -261,3 +288,686 @@ func TestSetConfigError(t *testing.T) {
 		t.Errorf("Unexpected output %+v", err)
 	}
 }
+
+func TestPostPatchCleanup(t *testing.T) {
+	clean := "clean"
+	steps := " autoremove, Clean,"
+	empty := ""
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    []string
+	}{
+		{"unset", nil, nil, nil},
+		{"project", &clean, nil, []string{"clean"}},
+		{"instance overrides project", &clean, &steps, []string{"autoremove", "clean"}},
+		{"instance disables", &clean, &empty, nil},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.PostPatchCleanup = tt.project
+		md.Instance.Attributes.PostPatchCleanup = tt.inst
+		if got := createConfigFromMetadata(md).postPatchCleanup; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got(%q) != want(%q)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestAptHold(t *testing.T) {
+	nginx := "nginx"
+	pkgs := " nginx, Redis-Server,"
+	empty := ""
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    []string
+	}{
+		{"unset", nil, nil, nil},
+		{"project", &nginx, nil, []string{"nginx"}},
+		{"instance overrides project", &nginx, &pkgs, []string{"nginx", "redis-server"}},
+		{"instance disables", &nginx, &empty, nil},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.AptHold = tt.project
+		md.Instance.Attributes.AptHold = tt.inst
+		if got := createConfigFromMetadata(md).aptHold; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got(%q) != want(%q)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestCheckStateConcurrency(t *testing.T) {
+	two := json.Number("2")
+	eight := json.Number("8")
+	tests := []struct {
+		desc    string
+		project *json.Number
+		inst    *json.Number
+		want    int
+	}{
+		{"unset", nil, nil, checkStateConcurrencyDefault},
+		{"project", &two, nil, 2},
+		{"instance overrides project", &two, &eight, 8},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.CheckStateConcurrency = tt.project
+		md.Instance.Attributes.CheckStateConcurrency = tt.inst
+		if got := createConfigFromMetadata(md).checkStateConcurrency; got != tt.want {
+			t.Errorf("%s: got(%d) != want(%d)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestCheckStateRate(t *testing.T) {
+	thirty := json.Number("30")
+	zero := json.Number("0")
+	negative := json.Number("-1")
+	tests := []struct {
+		desc    string
+		project *json.Number
+		inst    *json.Number
+		want    int
+	}{
+		{"unset", nil, nil, 0},
+		{"project", &thirty, nil, 30},
+		{"instance overrides project", &thirty, &zero, 0},
+		{"negative is ignored", nil, &negative, 0},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.CheckStateRate = tt.project
+		md.Instance.Attributes.CheckStateRate = tt.inst
+		if got := createConfigFromMetadata(md).checkStateRate; got != tt.want {
+			t.Errorf("%s: got(%d) != want(%d)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestGooGetRetries(t *testing.T) {
+	zero := json.Number("0")
+	five := json.Number("5")
+	negative := json.Number("-1")
+	tests := []struct {
+		desc    string
+		project *json.Number
+		inst    *json.Number
+		want    int
+	}{
+		{"unset", nil, nil, googetRetriesDefault},
+		{"project", &five, nil, 5},
+		{"instance overrides project", &five, &zero, 0},
+		{"negative is ignored", nil, &negative, googetRetriesDefault},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.GooGetRetries = tt.project
+		md.Instance.Attributes.GooGetRetries = tt.inst
+		if got := createConfigFromMetadata(md).googetRetries; got != tt.want {
+			t.Errorf("%s: got(%d) != want(%d)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestPackageQueryConcurrency(t *testing.T) {
+	one := json.Number("1")
+	eight := json.Number("8")
+	tests := []struct {
+		desc    string
+		project *json.Number
+		inst    *json.Number
+		want    int
+	}{
+		{"unset", nil, nil, packageQueryConcurrencyDefault},
+		{"project", &eight, nil, 8},
+		{"instance overrides project", &eight, &one, 1},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.PackageConcurrency = tt.project
+		md.Instance.Attributes.PackageConcurrency = tt.inst
+		if got := createConfigFromMetadata(md).packageQueryConcurrency; got != tt.want {
+			t.Errorf("%s: got(%d) != want(%d)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	week := "168h"
+	day := " 24h "
+	invalid := "forever"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    time.Duration
+	}{
+		{"unset", nil, nil, 0},
+		{"project", &week, nil, 168 * time.Hour},
+		{"instance overrides project", &week, &day, 24 * time.Hour},
+		{"invalid disables", &week, &invalid, 0},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.HistoryRetention = tt.project
+		md.Instance.Attributes.HistoryRetention = tt.inst
+		if got := createConfigFromMetadata(md).historyRetention; got != tt.want {
+			t.Errorf("%s: got(%v) != want(%v)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestUploadBucket(t *testing.T) {
+	bucket := "my-bucket"
+	url := " gs://other-bucket/ "
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    string
+	}{
+		{"unset", nil, nil, ""},
+		{"project", &bucket, nil, "my-bucket"},
+		{"instance overrides project", &bucket, &url, "other-bucket"},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.UploadBucket = tt.project
+		md.Instance.Attributes.UploadBucket = tt.inst
+		if got := createConfigFromMetadata(md).uploadBucket; got != tt.want {
+			t.Errorf("%s: got(%q) != want(%q)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestInventoryWorkerUser(t *testing.T) {
+	nobody := "nobody"
+	inventory := " osconfig-inventory "
+	empty := ""
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    string
+	}{
+		{"unset", nil, nil, ""},
+		{"project", &nobody, nil, "nobody"},
+		{"instance overrides project", &nobody, &inventory, "osconfig-inventory"},
+		{"instance disables", &nobody, &empty, ""},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.InventoryWorkerUser = tt.project
+		md.Instance.Attributes.InventoryWorkerUser = tt.inst
+		if got := createConfigFromMetadata(md).inventoryWorkerUser; got != tt.want {
+			t.Errorf("%s: got(%q) != want(%q)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestWindowsUpdateCatalog(t *testing.T) {
+	on := "true"
+	off := "false"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    bool
+	}{
+		{"unset", nil, nil, false},
+		{"project", &on, nil, true},
+		{"instance overrides project", &on, &off, false},
+		{"instance", nil, &on, true},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.WindowsUpdateCatalog = tt.project
+		md.Instance.Attributes.WindowsUpdateCatalog = tt.inst
+		if got := createConfigFromMetadata(md).windowsUpdateCatalog; got != tt.want {
+			t.Errorf("%s: got(%t) != want(%t)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	custom := "max-size=50M, max-backups=10,compress=false,interval=1h,max-age=48h"
+	bad := "max-size=lots,max-backups=-1,compress=maybe,unknown=1"
+	override := "max-size=1024"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    logfile.Options
+	}{
+		{"unset", nil, nil, logfile.DefaultOptions},
+		{"project", &custom, nil, logfile.Options{MaxSize: 50 << 20, Interval: time.Hour, MaxBackups: 10, MaxAge: 48 * time.Hour}},
+		{"invalid keeps defaults", nil, &bad, logfile.DefaultOptions},
+		{"instance overrides project", &custom, &override, logfile.Options{MaxSize: 1024, Interval: logfile.DefaultOptions.Interval, MaxBackups: logfile.DefaultOptions.MaxBackups, MaxAge: logfile.DefaultOptions.MaxAge, Compress: true}},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.LogRotation = tt.project
+		md.Instance.Attributes.LogRotation = tt.inst
+		if got := createConfigFromMetadata(md).logRotation; got != tt.want {
+			t.Errorf("%s: got(%+v) != want(%+v)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"100", 100},
+		{"10K", 10 << 10},
+		{"10kb", 10 << 10},
+		{"5M", 5 << 20},
+		{"1G", 1 << 30},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "M", "-1", "1T"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) expected error", in)
+		}
+	}
+}
+
+func TestPatchGuestEnvironment(t *testing.T) {
+	on := "true"
+	off := "false"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    bool
+	}{
+		{"unset", nil, nil, false},
+		{"project", &on, nil, true},
+		{"instance overrides project", &on, &off, false},
+		{"instance", nil, &on, true},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.PatchGuestEnvironment = tt.project
+		md.Instance.Attributes.PatchGuestEnvironment = tt.inst
+		if got := createConfigFromMetadata(md).patchGuestEnvironment; got != tt.want {
+			t.Errorf("%s: got(%t) != want(%t)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestLocalAPIEnabled(t *testing.T) {
+	on := "true"
+	off := "false"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    bool
+	}{
+		{"unset", nil, nil, false},
+		{"project", &on, nil, true},
+		{"instance overrides project", &on, &off, false},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.LocalAPIEnabled = tt.project
+		md.Instance.Attributes.LocalAPIEnabled = tt.inst
+		if got := createConfigFromMetadata(md).localAPIEnabled; got != tt.want {
+			t.Errorf("%s: got(%t) != want(%t)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestPackageVerificationBudget(t *testing.T) {
+	tenMinutes := "10m"
+	minute := " 1m "
+	invalid := "always"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    time.Duration
+	}{
+		{"unset", nil, nil, 0},
+		{"project", &tenMinutes, nil, 10 * time.Minute},
+		{"instance overrides project", &tenMinutes, &minute, time.Minute},
+		{"invalid disables", &tenMinutes, &invalid, 0},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.VerificationBudget = tt.project
+		md.Instance.Attributes.VerificationBudget = tt.inst
+		if got := createConfigFromMetadata(md).verificationBudget; got != tt.want {
+			t.Errorf("%s: got(%v) != want(%v)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestUserAgentSuffix(t *testing.T) {
+	fleet := "fleet/web"
+	padded := " team/infra (prod) "
+	invalid := "bad\nheader"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    string
+	}{
+		{"unset", nil, nil, ""},
+		{"project", &fleet, nil, "fleet/web"},
+		{"instance overrides project", &fleet, &padded, "team/infra (prod)"},
+		{"invalid ignored", &fleet, &invalid, ""},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.UserAgentSuffix = tt.project
+		md.Instance.Attributes.UserAgentSuffix = tt.inst
+		if got := createConfigFromMetadata(md).userAgentSuffix; got != tt.want {
+			t.Errorf("%s: got(%q) != want(%q)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestParseRequestLabels(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{"", nil},
+		{"fleet=web, Env = prod", map[string]string{"fleet": "web", "env": "prod"}},
+		{"cost_center=1234,nolabel,bad key=x,ok=", map[string]string{"cost_center": "1234", "ok": ""}},
+		{"a=\x01", nil},
+	}
+	for _, tt := range tests {
+		if got := parseRequestLabels(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRequestLabels(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNpmInventoryEnabled(t *testing.T) {
+	on := "true"
+	off := "false"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    bool
+	}{
+		{"unset", nil, nil, true},
+		{"project", &on, nil, false},
+		{"instance overrides project", &on, &off, true},
+		{"instance", nil, &on, false},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.DisableNpmInventory = tt.project
+		md.Instance.Attributes.DisableNpmInventory = tt.inst
+		if got := !createConfigFromMetadata(md).disableNpmInventory; got != tt.want {
+			t.Errorf("%s: got(%t) != want(%t)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestWSLPackageInventoryEnabled(t *testing.T) {
+	on := "true"
+	off := "false"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    bool
+	}{
+		{"unset", nil, nil, false},
+		{"project", &on, nil, true},
+		{"instance overrides project", &on, &off, false},
+		{"instance", nil, &on, true},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.WSLPackageInventory = tt.project
+		md.Instance.Attributes.WSLPackageInventory = tt.inst
+		if got := createConfigFromMetadata(md).wslPackageInventory; got != tt.want {
+			t.Errorf("%s: got(%t) != want(%t)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestMicrosoftUpdate(t *testing.T) {
+	on := "true"
+	off := "false"
+	bad := "yes please"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    string
+	}{
+		{"unset", nil, nil, ""},
+		{"project", &on, nil, "true"},
+		{"instance overrides project", &on, &off, "false"},
+		{"instance", nil, &on, "true"},
+		{"bad entry is unset", nil, &bad, ""},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.MicrosoftUpdate = tt.project
+		md.Instance.Attributes.MicrosoftUpdate = tt.inst
+		if got := createConfigFromMetadata(md).microsoftUpdate; got != tt.want {
+			t.Errorf("%s: got(%q) != want(%q)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestRPMDBDirect(t *testing.T) {
+	tests := []struct {
+		desc             string
+		projectEnabled   string
+		instanceEnabled  string
+		instanceDisabled string
+		want             bool
+	}{
+		{"unset", "", "", "", false},
+		{"project", "osinventory,rpmdb", "", "", true},
+		{"instance", "", "rpmdb", "", true},
+		{"instance disables", "rpmdb", "", "rpmdb", false},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.PreReleaseFeatures = tt.projectEnabled
+		md.Instance.Attributes.PreReleaseFeatures = tt.instanceEnabled
+		md.Instance.Attributes.DisabledFeatures = tt.instanceDisabled
+		if got := createConfigFromMetadata(md).rpmdbDirect; got != tt.want {
+			t.Errorf("%s: got(%t) != want(%t)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestPackageQueryCacheTTL(t *testing.T) {
+	minute := "1m"
+	zero := "0s"
+	invalid := "often"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    time.Duration
+	}{
+		{"unset", nil, nil, packageQueryCacheTTLDefault},
+		{"project", &minute, nil, time.Minute},
+		{"instance overrides project", &minute, &zero, 0},
+		{"invalid", nil, &invalid, 0},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.PackageQueryCacheTTL = tt.project
+		md.Instance.Attributes.PackageQueryCacheTTL = tt.inst
+		if got := createConfigFromMetadata(md).packageQueryCacheTTL; got != tt.want {
+			t.Errorf("%s: got(%v) != want(%v)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestTaskStagger(t *testing.T) {
+	hour := "1h"
+	tenMinutes := "10m"
+	invalid := "soon"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    time.Duration
+	}{
+		{"unset", nil, nil, 0},
+		{"project", &hour, nil, time.Hour},
+		{"instance overrides project", &hour, &tenMinutes, 10 * time.Minute},
+		{"invalid", nil, &invalid, 0},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.TaskStagger = tt.project
+		md.Instance.Attributes.TaskStagger = tt.inst
+		if got := createConfigFromMetadata(md).taskStagger; got != tt.want {
+			t.Errorf("%s: got(%v) != want(%v)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestPackageTimeouts(t *testing.T) {
+	project := "download=30m"
+	inst := "refresh=5m, APT.install=2h,yum.download=1h,googet.install=20m,brew.install=1m,pip.resolve=5m,build=1m,resolve=later,install=-1s,resolve"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    map[string]time.Duration
+	}{
+		{"unset", nil, nil, nil},
+		{"project", &project, nil, map[string]time.Duration{"download": 30 * time.Minute}},
+		{"instance overrides project", &project, &inst, map[string]time.Duration{"refresh": 5 * time.Minute, "apt.install": 2 * time.Hour, "yum.download": time.Hour, "googet.install": 20 * time.Minute, "pip.resolve": 5 * time.Minute}},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.PackageTimeouts = tt.project
+		md.Instance.Attributes.PackageTimeouts = tt.inst
+		if got := createConfigFromMetadata(md).packageTimeouts; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got(%v) != want(%v)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestAptDeb822(t *testing.T) {
+	on := "true"
+	off := "false"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    bool
+	}{
+		{"unset", nil, nil, false},
+		{"project", &on, nil, true},
+		{"instance overrides project", &on, &off, false},
+		{"instance", nil, &on, true},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.AptDeb822 = tt.project
+		md.Instance.Attributes.AptDeb822 = tt.inst
+		if got := createConfigFromMetadata(md).aptDeb822; got != tt.want {
+			t.Errorf("%s: got(%t) != want(%t)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestPackageRepositories(t *testing.T) {
+	on := "true"
+	off := "false"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    bool
+	}{
+		{"unset", nil, nil, false},
+		{"project", &on, nil, true},
+		{"instance overrides project", &on, &off, false},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.PackageRepositories = tt.project
+		md.Instance.Attributes.PackageRepositories = tt.inst
+		if got := createConfigFromMetadata(md).packageRepositories; got != tt.want {
+			t.Errorf("%s: got(%t) != want(%t)", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestPackageSignatures(t *testing.T) {
+	on := "true"
+	off := "false"
+	keyrings := " /etc/keys/vendor.asc, keys/relative.gpg,/etc/keys/other.gpg"
+	tests := []struct {
+		desc         string
+		verify       *string
+		keyrings     *string
+		wantVerify   bool
+		wantKeyrings []string
+	}{
+		{"unset", nil, nil, false, nil},
+		{"verify", &on, nil, true, nil},
+		{"keyrings imply verify", &off, &keyrings, true, []string{"/etc/keys/vendor.asc", "/etc/keys/other.gpg"}},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Instance.Attributes.VerifySignatures = tt.verify
+		md.Project.Attributes.SignatureKeyrings = tt.keyrings
+		c := createConfigFromMetadata(md)
+		if got := c.verifySignatures || len(c.signatureKeyrings) > 0; got != tt.wantVerify {
+			t.Errorf("%s: verify = %t, want %t", tt.desc, got, tt.wantVerify)
+		}
+		if !reflect.DeepEqual(c.signatureKeyrings, tt.wantKeyrings) {
+			t.Errorf("%s: keyrings = %q, want %q", tt.desc, c.signatureKeyrings, tt.wantKeyrings)
+		}
+	}
+}
+
+func TestCrashReportSettings(t *testing.T) {
+	on := "true"
+	off := "false"
+	tests := []struct {
+		desc    string
+		project *string
+		inst    *string
+		want    bool
+	}{
+		{"unset", nil, nil, false},
+		{"project", &on, nil, true},
+		{"instance overrides project", &on, &off, false},
+		{"instance", nil, &on, true},
+	}
+	for _, tt := range tests {
+		var md metadataJSON
+		md.Project.Attributes.CrashReportUpload = tt.project
+		md.Instance.Attributes.CrashReportUpload = tt.inst
+		md.Project.Attributes.CrashCoreDump = tt.project
+		md.Instance.Attributes.CrashCoreDump = tt.inst
+		c := createConfigFromMetadata(md)
+		if c.crashReportUpload != tt.want {
+			t.Errorf("%s: crashReportUpload got(%t) != want(%t)", tt.desc, c.crashReportUpload, tt.want)
+		}
+		if c.crashCoreDump != tt.want {
+			t.Errorf("%s: crashCoreDump got(%t) != want(%t)", tt.desc, c.crashCoreDump, tt.want)
+		}
+	}
+}
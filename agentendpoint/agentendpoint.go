@@ -24,12 +24,16 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	agentendpoint "cloud.google.com/go/osconfig/agentendpoint/apiv1"
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/crashloop"
+	"github.com/GoogleCloudPlatform/osconfig/crashreport"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/retryutil"
 	"github.com/GoogleCloudPlatform/osconfig/tasker"
@@ -52,32 +56,73 @@ var (
 	taskStateFile        = agentconfig.TaskStateFile()
 	oldTaskStateFile     = agentconfig.OldTaskStateFile()
 	sameStateTimeWindow  = -5 * time.Second
+
+	// taskNotificationDebounce is how long to wait after a task notification
+	// before running tasks so that bursts of notifications result in one run.
+	taskNotificationDebounce = 2 * time.Second
+
+	// beginFeature marks a feature as running for crash loop detection.
+	beginFeature = crashloop.Begin
 )
 
+// taskFeatures are the crash loop feature names of each task type.
+var taskFeatures = map[agentendpointpb.TaskType]string{
+	agentendpointpb.TaskType_APPLY_PATCHES:     "patch task",
+	agentendpointpb.TaskType_EXEC_STEP_TASK:    "exec task",
+	agentendpointpb.TaskType_APPLY_CONFIG_TASK: "config task",
+}
+
+// taskSubsystems are the log subsystem names of each task type.
+var taskSubsystems = map[agentendpointpb.TaskType]string{
+	agentendpointpb.TaskType_APPLY_PATCHES:     "patch",
+	agentendpointpb.TaskType_EXEC_STEP_TASK:    "exec",
+	agentendpointpb.TaskType_APPLY_CONFIG_TASK: "config",
+}
+
+func taskFeature(t agentendpointpb.TaskType) string {
+	if f, ok := taskFeatures[t]; ok {
+		return f
+	}
+	return t.String()
+}
+
 // Client is a an agentendpoint client.
 type Client struct {
-	raw    *agentendpoint.Client
-	cancel context.CancelFunc
-	noti   chan struct{}
-	closed bool
-	mx     sync.Mutex
+	raw      *agentendpoint.Client
+	cancel   context.CancelFunc
+	noti     chan struct{}
+	endpoint string
+	closed   bool
+	mx       sync.Mutex
+
+	// coalesced counts notifications folded into the queued run.
+	coalesced int32
+	// pending counts notifications received since a running ApplyConfigTask
+	// last checked whether it has been superseded.
+	pending int32
+
+	// driftStateFile is where ApplyConfigTasks persist drift counters.
+	driftStateFile string
 }
 
 // NewClient a new agentendpoint Client.
 func NewClient(ctx context.Context) (*Client, error) {
+	endpoint := agentconfig.SvcEndpoint()
 	opts := []option.ClientOption{
 		option.WithoutAuthentication(), // Do not use oauth.
 		option.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(nil))), // Because we disabled Auth we need to specifically enable TLS.
-		option.WithEndpoint(agentconfig.SvcEndpoint()),
+		option.WithEndpoint(endpoint),
 		option.WithUserAgent(agentconfig.UserAgent()),
 	}
+	opts = append(opts, requestLabelOptions...)
+	opts = append(opts, faultInjectionOptions(ctx)...)
 	clog.Debugf(ctx, "Creating new agentendpoint client.")
 	c, err := agentendpoint.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{raw: c, noti: make(chan struct{}, 1)}, nil
+	return &Client{raw: c, noti: make(chan struct{}, 1), endpoint: endpoint, driftStateFile: agentconfig.DriftStateFile()}, nil
 }
 
 // Close cancels WaitForTaskNotification and closes the underlying ClientConn.
@@ -242,25 +287,82 @@ func (c *Client) runTask(ctx context.Context) {
 			return
 		}
 
-		clog.Debugf(ctx, "Received task: %s.", task.GetTaskType())
-		ctx := clog.WithLabels(ctx, map[string]string{"task_type": task.GetTaskType().String()})
-		switch task.GetTaskType() {
-		case agentendpointpb.TaskType_APPLY_PATCHES:
-			if err := c.RunApplyPatches(ctx, task); err != nil {
-				clog.Errorf(ctx, "Error running TaskType_APPLY_PATCHES: %v", err)
-			}
-		case agentendpointpb.TaskType_EXEC_STEP_TASK:
-			if err := c.RunExecStep(ctx, task); err != nil {
-				clog.Errorf(ctx, "Error running TaskType_EXEC_STEP_TASK: %v", err)
-			}
-		case agentendpointpb.TaskType_APPLY_CONFIG_TASK:
-			if err := c.RunApplyConfig(ctx, task); err != nil {
-				clog.Errorf(ctx, "Error running TaskType_APPLY_CONFIG_TASK: %v", err)
-			}
-		default:
-			clog.Errorf(ctx, "Unknown task type: %v", task.GetTaskType())
+		if isDeferred(task.GetTaskId()) {
+			clog.Debugf(ctx, "Task %q waits for its start time, ending run task loop.", task.GetTaskId())
+			return
+		}
+		if c.deferTask(ctx, task) || c.paceTask(ctx, task) {
+			// The deferred task continues the loop once it has run.
+			return
+		}
+		if !c.runStartedTask(ctx, task) {
+			return
+		}
+	}
+}
+
+// runStartedTask runs a task returned by StartNextTask and reports whether
+// the run task loop should continue.
+func (c *Client) runStartedTask(ctx context.Context, task *agentendpointpb.Task) bool {
+	clog.Debugf(ctx, "Received task: %s.", task.GetTaskType())
+	ctx = clog.WithLabels(ctx, map[string]string{"task_type": task.GetTaskType().String()})
+	ctx = clog.WithSubsystem(ctx, taskSubsystems[task.GetTaskType()])
+	end, err := beginFeature(ctx, taskFeature(task.GetTaskType()))
+	if err != nil {
+		clog.Errorf(ctx, "Failing task %q: %v", task.GetTaskId(), err)
+		if err := c.reportQuarantined(ctx, task, err); err != nil {
+			// Stop here, StartNextTask would return the same task.
+			clog.Errorf(ctx, "%v", err)
+			return false
+		}
+		return true
+	}
+	defer end()
+	switch task.GetTaskType() {
+	case agentendpointpb.TaskType_APPLY_PATCHES:
+		if err := c.RunApplyPatches(ctx, task); err == errAgentRestart {
+			// Don't start other tasks, the agent is about to restart.
+			return false
+		} else if err != nil {
+			clog.Errorf(ctx, "Error running TaskType_APPLY_PATCHES: %v", err)
+		}
+	case agentendpointpb.TaskType_EXEC_STEP_TASK:
+		if err := c.RunExecStep(ctx, task); err != nil {
+			clog.Errorf(ctx, "Error running TaskType_EXEC_STEP_TASK: %v", err)
 		}
+	case agentendpointpb.TaskType_APPLY_CONFIG_TASK:
+		if err := c.RunApplyConfig(ctx, task); err != nil {
+			clog.Errorf(ctx, "Error running TaskType_APPLY_CONFIG_TASK: %v", err)
+		}
+	default:
+		clog.Errorf(ctx, "Unknown task type: %v", task.GetTaskType())
 	}
+	return true
+}
+
+// reportQuarantined fails a task whose task type has been quarantined for
+// crashing the agent.
+func (c *Client) reportQuarantined(ctx context.Context, task *agentendpointpb.Task, qerr error) error {
+	req := &agentendpointpb.ReportTaskCompleteRequest{
+		TaskId:       task.GetTaskId(),
+		TaskType:     task.GetTaskType(),
+		ErrorMessage: qerr.Error(),
+	}
+	switch task.GetTaskType() {
+	case agentendpointpb.TaskType_APPLY_PATCHES:
+		req.Output = &agentendpointpb.ReportTaskCompleteRequest_ApplyPatchesTaskOutput{
+			ApplyPatchesTaskOutput: &agentendpointpb.ApplyPatchesTaskOutput{State: agentendpointpb.ApplyPatchesTaskOutput_FAILED},
+		}
+	case agentendpointpb.TaskType_EXEC_STEP_TASK:
+		req.Output = &agentendpointpb.ReportTaskCompleteRequest_ExecStepTaskOutput{
+			ExecStepTaskOutput: &agentendpointpb.ExecStepTaskOutput{State: agentendpointpb.ExecStepTaskOutput_COMPLETED, ExitCode: -1},
+		}
+	case agentendpointpb.TaskType_APPLY_CONFIG_TASK:
+		req.Output = &agentendpointpb.ReportTaskCompleteRequest_ApplyConfigTaskOutput{
+			ApplyConfigTaskOutput: &agentendpointpb.ApplyConfigTaskOutput{State: agentendpointpb.ApplyConfigTaskOutput_FAILED},
+		}
+	}
+	return c.reportTaskComplete(ctx, req)
 }
 
 func (c *Client) handleStream(ctx context.Context, stream agentendpointpb.AgentEndpointService_ReceiveTaskNotificationClient) error {
@@ -272,6 +374,7 @@ func (c *Client) handleStream(ctx context.Context, stream agentendpointpb.AgentE
 			return err
 		}
 		clog.Debugf(ctx, "Received task notification.")
+		atomic.AddInt32(&c.pending, 1)
 
 		// Only queue up one notifcation at a time. We should only ever
 		// have one active task being worked on and one in the queue.
@@ -280,26 +383,45 @@ func (c *Client) handleStream(ctx context.Context, stream agentendpointpb.AgentE
 			// We have been canceled.
 			return nil
 		case c.noti <- struct{}{}:
-			tasker.Enqueue(ctx, "TaskNotification", func() {
-				// We lock so that this task will complete before the client can get canceled.
-				c.mx.Lock()
-				defer c.mx.Unlock()
-				select {
-				case <-ctx.Done():
-					// We have been canceled.
-				default:
-					// Take this task off the notification queue so another can be
-					// queued up.
-					<-c.noti
-					c.runTask(ctx)
-				}
-			})
+			c.scheduleTaskRun(ctx)
 		default:
-			// Ignore the notificaction as we already have one queued.
+			// Ignore the notificaction as we already have one queued, the
+			// queued run will pick up its task.
+			atomic.AddInt32(&c.coalesced, 1)
 		}
 	}
 }
 
+// scheduleTaskRun queues a runTask after taskNotificationDebounce. Any
+// notifications received until the run starts are coalesced into it, every
+// task is still started and reported on its own by runTask.
+func (c *Client) scheduleTaskRun(ctx context.Context) {
+	enqueue := func() {
+		tasker.Enqueue(ctx, "TaskNotification", func() {
+			// We lock so that this task will complete before the client can get canceled.
+			c.mx.Lock()
+			defer c.mx.Unlock()
+			select {
+			case <-ctx.Done():
+				// We have been canceled.
+			default:
+				// Take this task off the notification queue so another can be
+				// queued up.
+				<-c.noti
+				if n := atomic.SwapInt32(&c.coalesced, 0); n > 0 {
+					clog.Debugf(ctx, "Coalesced %d task notifications into this run.", n)
+				}
+				c.runTask(ctx)
+			}
+		})
+	}
+	if taskNotificationDebounce <= 0 {
+		enqueue()
+		return
+	}
+	time.AfterFunc(taskNotificationDebounce, enqueue)
+}
+
 func (c *Client) receiveTaskNotification(ctx context.Context) (agentendpointpb.AgentEndpointService_ReceiveTaskNotificationClient, error) {
 	token, err := agentconfig.IDToken()
 	if err != nil {
@@ -320,14 +442,31 @@ func (c *Client) receiveTaskNotification(ctx context.Context) (agentendpointpb.A
 
 func (c *Client) loadTaskFromState(ctx context.Context) error {
 	st, err := loadState(taskStateFile)
+	if errors.Is(err, integrity.ErrTampered) {
+		// A forged state could resume a patch job with any configuration,
+		// there is no task to resume.
+		clog.Errorf(ctx, "Discarding saved task state: %v", err)
+		if err := (&taskState{}).save(taskStateFile); err != nil {
+			clog.Errorf(ctx, "Error clearing task state: %v", err)
+		}
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("loadState error: %w", err)
 	}
 	if st != nil && st.PatchTask != nil {
 		st.PatchTask.client = c
 		st.PatchTask.state = st
+		ctx := clog.WithSubsystem(ctx, taskSubsystems[agentendpointpb.TaskType_APPLY_PATCHES])
 		tasker.Enqueue(ctx, "PatchRun", func() {
+			end, err := beginFeature(ctx, taskFeature(agentendpointpb.TaskType_APPLY_PATCHES))
+			if err != nil {
+				st.PatchTask.reportFailed(ctx, fmt.Sprintf("Failing task %q: %v", st.PatchTask.TaskID, err))
+				st.PatchTask.complete(ctx)
+				return
+			}
 			st.PatchTask.run(ctx)
+			end()
 		})
 	}
 
@@ -376,6 +515,7 @@ func (c *Client) WaitForTaskNotification(ctx context.Context) {
 
 	clog.Debugf(ctx, "Setting up ReceiveTaskNotification stream watcher.")
 	go func() {
+		defer crashreport.Recover(ctx, "task notification watcher")
 		var resourceExhausted int
 		var errs int
 		var sleep time.Duration
@@ -35,28 +35,31 @@ import (
 
 // BetaClient is a an agentendpoint client.
 type BetaClient struct {
-	raw    *agentendpoint.Client
-	cancel context.CancelFunc
-	noti   chan struct{}
-	closed bool
-	mx     sync.Mutex
+	raw      *agentendpoint.Client
+	cancel   context.CancelFunc
+	noti     chan struct{}
+	endpoint string
+	closed   bool
+	mx       sync.Mutex
 }
 
 // NewBetaClient a new agentendpoint Client.
 func NewBetaClient(ctx context.Context) (*BetaClient, error) {
+	endpoint := agentconfig.SvcEndpoint()
 	opts := []option.ClientOption{
 		option.WithoutAuthentication(), // Do not use oauth.
 		option.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(nil))), // Because we disabled Auth we need to specifically enable TLS.
-		option.WithEndpoint(agentconfig.SvcEndpoint()),
+		option.WithEndpoint(endpoint),
 		option.WithUserAgent(agentconfig.UserAgent()),
 	}
+	opts = append(opts, requestLabelOptions...)
 	clog.Debugf(ctx, "Creating new agentendpoint beta client.")
 	c, err := agentendpoint.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &BetaClient{raw: c, noti: make(chan struct{}, 1)}, nil
+	return &BetaClient{raw: c, noti: make(chan struct{}, 1), endpoint: endpoint}, nil
 }
 
 // Close cancels WaitForTaskNotification and closes the underlying ClientConn.
@@ -28,7 +28,9 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -73,6 +75,9 @@ func TestMain(m *testing.M) {
 	opts := logger.LogOpts{LoggerName: "OSConfigAgent", Debug: true, Writers: []io.Writer{os.Stdout}}
 	logger.Init(context.Background(), opts)
 
+	// Keep crash loop state out of the agent cache directory.
+	beginFeature = func(context.Context, string) (func(), error) { return func() {}, nil }
+
 	out := m.Run()
 	ts.Close()
 	os.Exit(out)
@@ -91,6 +96,10 @@ func (c *testClient) close() {
 }
 
 func newTestClient(ctx context.Context, srv agentendpointpb.AgentEndpointServiceServer) (*testClient, error) {
+	return newTestClientWithOptions(ctx, srv)
+}
+
+func newTestClientWithOptions(ctx context.Context, srv agentendpointpb.AgentEndpointServiceServer, opts ...grpc.DialOption) (*testClient, error) {
 	lis := bufconn.Listen(bufSize)
 	s := grpc.NewServer()
 	agentendpointpb.RegisterAgentEndpointServiceServer(s, srv)
@@ -105,7 +114,8 @@ func newTestClient(ctx context.Context, srv agentendpointpb.AgentEndpointService
 		return lis.Dial()
 	}
 
-	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithDialer(bufDialer), grpc.WithInsecure())
+	opts = append([]grpc.DialOption{grpc.WithDialer(bufDialer), grpc.WithInsecure()}, opts...)
+	conn, err := grpc.DialContext(ctx, "bufnet", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -133,6 +143,16 @@ type agentEndpointServiceTestServer struct {
 	patchTaskComplete       bool
 	applyConfigTaskComplete bool
 	runTaskIDs              []string
+	startNextTaskCalls      int
+	// mu guards the task state, the RPCs run on server goroutines.
+	mu sync.Mutex
+}
+
+// calls returns the number of StartNextTask calls and the completed task ids.
+func (s *agentEndpointServiceTestServer) calls() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.startNextTaskCalls, append([]string(nil), s.runTaskIDs...)
 }
 
 func newAgentEndpointServiceTestServer() *agentEndpointServiceTestServer {
@@ -159,7 +179,10 @@ func (s *agentEndpointServiceTestServer) ReceiveTaskNotification(req *agentendpo
 func (s *agentEndpointServiceTestServer) StartNextTask(ctx context.Context, req *agentendpointpb.StartNextTaskRequest) (*agentendpointpb.StartNextTaskResponse, error) {
 	// We first return an TaskType_EXEC_STEP_TASK, then TaskType_APPLY_PATCHES, then TaskType_APPLY_CONFIG_TASK.
 	// After all tasks complete, we return nothing signalling the end to tasks.
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.taskStart = true
+	s.startNextTaskCalls++
 	switch {
 	case s.applyConfigTaskComplete && s.execTaskComplete && s.patchTaskComplete:
 		return &agentendpointpb.StartNextTaskResponse{}, nil
@@ -176,6 +199,8 @@ func (s *agentEndpointServiceTestServer) StartNextTask(ctx context.Context, req
 
 func (s *agentEndpointServiceTestServer) ReportTaskProgress(ctx context.Context, req *agentendpointpb.ReportTaskProgressRequest) (*agentendpointpb.ReportTaskProgressResponse, error) {
 	// Simply record and send STOP.
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	switch req.GetTaskType() {
 	case agentendpointpb.TaskType_EXEC_STEP_TASK:
 		s.execTaskProgress = true
@@ -191,6 +216,8 @@ func (s *agentEndpointServiceTestServer) ReportTaskProgress(ctx context.Context,
 
 func (s *agentEndpointServiceTestServer) ReportTaskComplete(ctx context.Context, req *agentendpointpb.ReportTaskCompleteRequest) (*agentendpointpb.ReportTaskCompleteResponse, error) {
 	// Record what task types we have seen, when the complete is called for TaskType_APPLY_CONFIG_TASK, close the stream.
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.runTaskIDs = append(s.runTaskIDs, req.GetTaskId())
 	switch req.GetTaskType() {
 	case agentendpointpb.TaskType_EXEC_STEP_TASK:
@@ -344,3 +371,70 @@ func TestLoadPatchTaskFromState(t *testing.T) {
 		t.Errorf("first entry in runTaskIDs does not match taskID, %q, %q", srv.runTaskIDs, taskID)
 	}
 }
+
+type fakeNotificationStream struct {
+	agentendpointpb.AgentEndpointService_ReceiveTaskNotificationClient
+	notifications chan struct{}
+}
+
+func (s *fakeNotificationStream) Recv() (*agentendpointpb.ReceiveTaskNotificationResponse, error) {
+	if _, ok := <-s.notifications; !ok {
+		return nil, io.EOF
+	}
+	return &agentendpointpb.ReceiveTaskNotificationResponse{}, nil
+}
+
+func TestHandleStreamCoalescesNotifications(t *testing.T) {
+	ctx := context.Background()
+	srv := newAgentEndpointServiceTestServer()
+	tc, err := newTestClient(ctx, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.close()
+
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	oldStateFile := taskStateFile
+	defer func() { taskStateFile = oldStateFile }()
+	taskStateFile = filepath.Join(td, "testState")
+
+	oldDebounce := taskNotificationDebounce
+	defer func() { taskNotificationDebounce = oldDebounce }()
+	taskNotificationDebounce = 100 * time.Millisecond
+
+	// A burst of notifications inside the debounce window.
+	stream := &fakeNotificationStream{notifications: make(chan struct{}, 3)}
+	for i := 0; i < 3; i++ {
+		stream.notifications <- struct{}{}
+	}
+	close(stream.notifications)
+	if err := tc.client.handleStream(ctx, stream); err != io.EOF {
+		t.Fatalf("expected io.EOF, got: %v", err)
+	}
+
+	select {
+	case <-srv.streamClose:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for tasks to run")
+	}
+	// Wait for the final StartNextTask that ends the run.
+	deadline := time.Now().Add(10 * time.Second)
+	for n, _ := srv.calls(); n < 4 && time.Now().Before(deadline); n, _ = srv.calls() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Any further run would start within the debounce window.
+	time.Sleep(3 * taskNotificationDebounce)
+
+	// One run: three tasks plus the empty response that ends the run.
+	n, runTaskIDs := srv.calls()
+	if n != 4 {
+		t.Errorf("expected notifications to be coalesced into one run with 4 StartNextTask calls, got %d", n)
+	}
+	if want := []string{"TaskType_EXEC_STEP_TASK", "TaskType_APPLY_PATCHES", "TaskType_APPLY_CONFIG_TASK"}; !reflect.DeepEqual(runTaskIDs, want) {
+		t.Errorf("expected every task to be reported, got %q, want %q", runTaskIDs, want)
+	}
+}
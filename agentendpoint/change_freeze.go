@@ -0,0 +1,23 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import "github.com/GoogleCloudPlatform/osconfig/changefreeze"
+
+// freezeCheck returns an error during a change freeze. Tasks still check
+// and report state during a freeze but make no changes: OS policies are
+// only checked, patch tasks fail before patching and exec steps are not
+// run.
+var freezeCheck = changefreeze.Check
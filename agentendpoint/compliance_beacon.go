@@ -0,0 +1,208 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/attributes"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// The compliance beacon is a compact summary of OS policy compliance written
+// to guest attributes after each ApplyConfigTask so that external
+// orchestration, such as MIG rolling updates, can gate on compliance without
+// calling the OS Config API.
+
+var (
+	complianceBeaconURL = agentconfig.ReportURL + "/osconfig/compliance"
+	// beaconMinInterval is the minimum time between two beacon writes.
+	beaconMinInterval = time.Minute
+	// beaconRefreshInterval is how often an unchanged beacon is rewritten so
+	// its timestamp shows the agent is still evaluating policies.
+	beaconRefreshInterval = 30 * time.Minute
+	// beaconMaxSize bounds the size of the beacon value in bytes.
+	beaconMaxSize = 4096
+
+	postBeacon = attributes.PostAttribute
+	beaconNow  = time.Now
+
+	beaconMx   sync.Mutex
+	lastBeacon *complianceBeacon
+)
+
+const (
+	beaconCompliant    = "compliant"
+	beaconNonCompliant = "noncompliant"
+	beaconUnknown      = "unknown"
+)
+
+type complianceBeacon struct {
+	// Time is the unix time of the run this beacon was generated from.
+	Time int64 `json:"t"`
+	// Compliant is true only if every policy is compliant.
+	Compliant bool `json:"compliant"`
+	// Policies is keyed by "<assignment>/<policy id>", where assignment is
+	// the OS policy assignment id without the project, location or revision.
+	Policies map[string]*policyBeacon `json:"policies"`
+	// Omitted is the number of policies left out to bound the beacon size,
+	// compliant policies are left out first.
+	Omitted int `json:"omitted,omitempty"`
+
+	// written is when this beacon was last posted.
+	written time.Time
+}
+
+type policyBeacon struct {
+	State string `json:"state"`
+	// Since is the unix time the policy was first seen in this state.
+	Since int64 `json:"since"`
+}
+
+func beaconPolicyKey(assignment, policyID string) string {
+	if i := strings.LastIndex(assignment, "/"); i != -1 {
+		assignment = assignment[i+1:]
+	}
+	if i := strings.Index(assignment, "@"); i != -1 {
+		assignment = assignment[:i]
+	}
+	return assignment + "/" + policyID
+}
+
+func policyBeaconState(results *agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult) string {
+	rcs := results.GetOsPolicyResourceCompliances()
+	if len(rcs) == 0 {
+		return beaconUnknown
+	}
+	state := beaconCompliant
+	for _, rc := range rcs {
+		switch rc.GetState() {
+		case agentendpointpb.OSPolicyComplianceState_COMPLIANT:
+		case agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT:
+			return beaconNonCompliant
+		default:
+			state = beaconUnknown
+		}
+	}
+	return state
+}
+
+// newComplianceBeacon builds the beacon for a run, carrying over the Since
+// time of policies whose state has not changed since prev.
+func newComplianceBeacon(osPolicies []*agentendpointpb.ApplyConfigTask_OSPolicy, results []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult, prev *complianceBeacon, now time.Time) *complianceBeacon {
+	b := &complianceBeacon{Time: now.Unix(), Compliant: true, Policies: map[string]*policyBeacon{}}
+	for i, osPolicy := range osPolicies {
+		key := beaconPolicyKey(osPolicy.GetOsPolicyAssignment(), osPolicy.GetId())
+		p := &policyBeacon{State: policyBeaconState(results[i]), Since: now.Unix()}
+		if prev != nil {
+			if old, ok := prev.Policies[key]; ok && old.State == p.State {
+				p.Since = old.Since
+			}
+		}
+		if p.State != beaconCompliant {
+			b.Compliant = false
+		}
+		b.Policies[key] = p
+	}
+	return b
+}
+
+// sameStates reports whether b and o have the same per policy states.
+func (b *complianceBeacon) sameStates(o *complianceBeacon) bool {
+	if o == nil || b.Compliant != o.Compliant || len(b.Policies) != len(o.Policies) {
+		return false
+	}
+	for k, p := range b.Policies {
+		if op, ok := o.Policies[k]; !ok || op.State != p.State {
+			return false
+		}
+	}
+	return true
+}
+
+// marshal encodes the beacon, dropping policies until it fits in max bytes.
+// Compliant policies are dropped before unknown and noncompliant ones.
+func (b *complianceBeacon) marshal(max int) ([]byte, error) {
+	rank := map[string]int{beaconNonCompliant: 0, beaconUnknown: 1, beaconCompliant: 2}
+	keys := make([]string, 0, len(b.Policies))
+	for k := range b.Policies {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := rank[b.Policies[keys[i]].State], rank[b.Policies[keys[j]].State]
+		if ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+
+	out := *b
+	for n := len(keys); ; n-- {
+		out.Policies = make(map[string]*policyBeacon, n)
+		for _, k := range keys[:n] {
+			out.Policies[k] = b.Policies[k]
+		}
+		out.Omitted = len(keys) - n
+		data, err := json.Marshal(out)
+		if err != nil || len(data) <= max || n == 0 {
+			return data, err
+		}
+	}
+}
+
+// publishComplianceBeacon writes the compliance beacon for this run to guest
+// attributes. Writes are rate limited: a beacon is written at most once every
+// beaconMinInterval, and an unchanged beacon only once every
+// beaconRefreshInterval. A change skipped by the rate limit is written by a
+// later run.
+func (c *configTask) publishComplianceBeacon(ctx context.Context) {
+	beaconMx.Lock()
+	defer beaconMx.Unlock()
+
+	now := beaconNow()
+	b := newComplianceBeacon(c.Task.GetOsPolicies(), c.results, lastBeacon, now)
+	if lastBeacon != nil {
+		since := now.Sub(lastBeacon.written)
+		if since < beaconMinInterval || (b.sameStates(lastBeacon) && since < beaconRefreshInterval) {
+			clog.Debugf(ctx, "Skipping compliance beacon, last written %s ago.", since)
+			return
+		}
+	}
+
+	data, err := b.marshal(beaconMaxSize)
+	if err != nil {
+		clog.Errorf(ctx, "Error encoding compliance beacon: %v", err)
+		return
+	}
+	if len(data) > beaconMaxSize {
+		clog.Warningf(ctx, "Compliance beacon is %d bytes, larger than the %d byte limit, not writing it.", len(data), beaconMaxSize)
+		return
+	}
+	if err := postBeacon(complianceBeaconURL, bytes.NewReader(data)); err != nil {
+		clog.Warningf(ctx, "Error writing compliance beacon: %v", err)
+		return
+	}
+	b.written = now
+	lastBeacon = b
+}
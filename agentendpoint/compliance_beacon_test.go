@@ -0,0 +1,129 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func beaconTask(states ...agentendpointpb.OSPolicyComplianceState) *configTask {
+	c := &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{}}}
+	for i, s := range states {
+		id := string(rune('a' + i))
+		c.Task.OsPolicies = append(c.Task.OsPolicies, &agentendpointpb.ApplyConfigTask_OSPolicy{
+			Id:                 id,
+			OsPolicyAssignment: "projects/1/locations/us-central1-a/osPolicyAssignments/assign@rev",
+		})
+		c.results = append(c.results, &agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{
+			OsPolicyResourceCompliances: []*agentendpointpb.OSPolicyResourceCompliance{{OsPolicyResourceId: "r", State: s}},
+		})
+	}
+	return c
+}
+
+func TestComplianceBeacon(t *testing.T) {
+	ctx := context.Background()
+	compliant := agentendpointpb.OSPolicyComplianceState_COMPLIANT
+	nonCompliant := agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+
+	now := time.Unix(1000, 0)
+	var posted []*complianceBeacon
+	defer func(p func(string, io.Reader) error, n func() time.Time) {
+		postBeacon, beaconNow, lastBeacon = p, n, nil
+	}(postBeacon, beaconNow)
+	postBeacon = func(url string, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		b := &complianceBeacon{}
+		if err := json.Unmarshal(data, b); err != nil {
+			return err
+		}
+		posted = append(posted, b)
+		return nil
+	}
+	beaconNow = func() time.Time { return now }
+	lastBeacon = nil
+
+	runs := []struct {
+		desc      string
+		after     time.Duration
+		states    []agentendpointpb.OSPolicyComplianceState
+		wantPost  bool
+		compliant bool
+		sinceB    int64
+	}{
+		{"first run", 0, []agentendpointpb.OSPolicyComplianceState{compliant, nonCompliant}, true, false, 1000},
+		{"changed within min interval", 30 * time.Second, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}, false, false, 0},
+		{"changed after min interval", time.Minute, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}, true, true, 1090},
+		{"unchanged", 10 * time.Minute, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}, false, false, 0},
+		{"unchanged refresh", 30 * time.Minute, []agentendpointpb.OSPolicyComplianceState{compliant, compliant}, true, true, 1090},
+	}
+	for _, r := range runs {
+		now = now.Add(r.after)
+		n := len(posted)
+		beaconTask(r.states...).publishComplianceBeacon(ctx)
+		if got := len(posted) > n; got != r.wantPost {
+			t.Fatalf("%s: posted = %t, want %t", r.desc, got, r.wantPost)
+		}
+		if !r.wantPost {
+			continue
+		}
+		b := posted[len(posted)-1]
+		if b.Time != now.Unix() || b.Compliant != r.compliant {
+			t.Errorf("%s: got beacon time %d compliant %t, want %d %t", r.desc, b.Time, b.Compliant, now.Unix(), r.compliant)
+		}
+		if p := b.Policies["assign/b"]; p == nil || p.Since != r.sinceB {
+			t.Errorf("%s: got policy b %+v, want since %d", r.desc, p, r.sinceB)
+		}
+		if p := b.Policies["assign/a"]; p == nil || p.Since != 1000 {
+			t.Errorf("%s: got policy a %+v, want since 1000", r.desc, p)
+		}
+	}
+}
+
+func TestComplianceBeaconSize(t *testing.T) {
+	compliant := agentendpointpb.OSPolicyComplianceState_COMPLIANT
+	nonCompliant := agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+	c := beaconTask(compliant, compliant, nonCompliant, agentendpointpb.OSPolicyComplianceState_UNKNOWN)
+	b := newComplianceBeacon(c.Task.GetOsPolicies(), c.results, nil, time.Unix(1000, 0))
+
+	full, err := b.marshal(beaconMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.marshal(len(full) - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &complianceBeacon{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Omitted != 1 || len(got.Policies) != 3 || got.Policies["assign/b"] != nil {
+		t.Errorf("expected the last compliant policy to be omitted, got %s", data)
+	}
+	if got.Policies["assign/c"].State != beaconNonCompliant || got.Policies["assign/d"].State != beaconUnknown {
+		t.Errorf("unexpected policy states: %s", data)
+	}
+}
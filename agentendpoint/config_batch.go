@@ -0,0 +1,180 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/config"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// packageBatcher is implemented by resources that can be enforced in one
+// package manager transaction with other resources of the same batch key.
+type packageBatcher interface {
+	PackageBatchKey() string
+}
+
+var enforcePackageBatch = func(ctx context.Context, rs []*resource) error {
+	batch := make([]*config.OSPolicyResource, 0, len(rs))
+	for _, r := range rs {
+		cr, ok := r.resourceIface.(*config.OSPolicyResource)
+		if !ok {
+			return fmt.Errorf("unexpected resource type %T in package batch", r.resourceIface)
+		}
+		batch = append(batch, cr)
+	}
+	return config.EnforcePackageBatch(ctx, batch)
+}
+
+// packageBatchKey returns the batch key of a validated resource, "" if it
+// can not be batched.
+func packageBatchKey(res *resource) string {
+	b, ok := res.resourceIface.(packageBatcher)
+	if !ok {
+		return ""
+	}
+	return b.PackageBatchKey()
+}
+
+// batchPackages is run before res, the resource at index resIdx of policy
+// polIdx, is enforced. It looks ahead for package resources of later
+// policies, and later in the same policy, that install or remove packages
+// with the same package manager, and enforces them all in one transaction.
+//
+// A resource only joins the batch if every resource before it in its policy
+// that has not run yet is either in the batch or already in its desired
+// state, so the order within a policy is kept. The batched resources keep
+// their pre-enforcement check result and report their enforcement step
+// when the apply loop reaches them. If the transaction fails nothing is
+// marked and each resource is enforced on its own, so errors are reported
+// on the resource that caused them.
+func (c *configTask) batchPackages(ctx context.Context, polIdx, resIdx int, res *resource) {
+	key := packageBatchKey(res)
+	if key == "" || (res.prefetch != nil && res.prefetch.batched) {
+		return
+	}
+
+	osPolicies := c.Task.GetOsPolicies()
+	members := []*resource{res}
+	for i := polIdx; i < len(osPolicies); i++ {
+		osPolicy := osPolicies[i]
+		if osPolicy.GetMode() != agentendpointpb.OSPolicy_ENFORCEMENT {
+			continue
+		}
+		start := 0
+		if i == polIdx {
+			start = resIdx + 1
+		}
+		ctx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": osPolicy.GetOsPolicyAssignment(), "os_policy_id": osPolicy.GetId()})
+		for _, configResource := range osPolicy.GetResources()[start:] {
+			r, ok := c.batchCandidate(ctx, osPolicy.GetId(), configResource, key)
+			if !ok {
+				break
+			}
+			if r != nil {
+				members = append(members, r)
+			}
+		}
+	}
+	if len(members) < 2 {
+		return
+	}
+
+	mark := markTransactions(ctx)
+	err := enforcePackageBatch(ctx, members)
+	txs := mark.Transactions(ctx)
+	if err != nil {
+		clog.Warningf(ctx, "Error enforcing %d package resources in one transaction, enforcing them one at a time: %v", len(members), err)
+		c.invalidatePrefetchedChecks()
+		return
+	}
+	recordTransactions(ctx, &transactionRecord{TaskID: c.TaskID, TaskType: "ApplyConfig", PolicyID: osPolicies[polIdx].GetId(), ResourceID: osPolicies[polIdx].GetResources()[resIdx].GetId(), Transactions: txs})
+	for _, r := range members {
+		if r.prefetch == nil {
+			r.prefetch = &prefetchResult{}
+		}
+		r.prefetch.batched = true
+	}
+	// The transaction may have changed files checked ahead of time, the
+	// batched resources keep their check from before it.
+	c.invalidatePrefetchedChecks()
+}
+
+// batchCandidate returns the resource for configResource if it joins a
+// batch with key. It returns nil, true if the resource is already in its
+// desired state and does not stop later resources of its policy from
+// joining, and false if it does.
+func (c *configTask) batchCandidate(ctx context.Context, policyID string, configResource *agentendpointpb.OSPolicy_Resource, key string) (*resource, bool) {
+	resources := c.prefetched[policyID]
+	if resources == nil {
+		resources = map[string]*resource{}
+		c.prefetched[policyID] = resources
+	}
+	res, ok := resources[configResource.GetId()]
+	if ok {
+		p := res.prefetch
+		if p == nil || p.validateErr != nil || !p.checked || p.checkErr != nil {
+			return nil, false
+		}
+		if res.resourceIface.InDesiredState() {
+			return nil, true
+		}
+		if p.batched || packageBatchKey(res) != key {
+			return nil, false
+		}
+		return res, true
+	}
+	if configResource.GetPkg() == nil {
+		return nil, false
+	}
+
+	// Check the package resource now, the apply loop uses this result.
+	ctx = clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()})
+	res = newResource(configResource)
+	p := &prefetchResult{validated: true}
+	res.prefetch = p
+	resources[configResource.GetId()] = res
+	if p.validateErr = res.resourceIface.Validate(ctx); p.validateErr != nil {
+		return nil, false
+	}
+	if packageBatchKey(res) != key {
+		// Leave the resource to be checked in the apply loop.
+		return nil, false
+	}
+	p.checkErr = res.resourceIface.CheckState(ctx)
+	p.checked = true
+	if p.checkErr != nil {
+		return nil, false
+	}
+	if res.resourceIface.InDesiredState() {
+		return nil, true
+	}
+	return res, true
+}
+
+// EnforceState skips enforcement of a resource that was enforced as part of
+// a package batch.
+func (r *resource) EnforceState(ctx context.Context) error {
+	if p := r.prefetch; p != nil && p.batched {
+		p.batched = false
+		clog.Infof(ctx, "Resource was enforced in a package batch.")
+		return nil
+	}
+	return r.resourceIface.EnforceState(ctx)
+}
@@ -0,0 +1,165 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/config"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+type batchResource struct {
+	id             string
+	key            string
+	inDesiredState bool
+	checks         int
+	enforcements   int
+}
+
+func (r *batchResource) Validate(ctx context.Context) error { return nil }
+
+func (r *batchResource) CheckState(ctx context.Context) error {
+	r.checks++
+	return nil
+}
+
+func (r *batchResource) EnforceState(ctx context.Context) error {
+	r.enforcements++
+	return nil
+}
+
+func (r *batchResource) PopulateOutput(*agentendpointpb.OSPolicyResourceCompliance) error {
+	return nil
+}
+
+func (r *batchResource) Cleanup(ctx context.Context) error { return nil }
+
+func (r *batchResource) InDesiredState() bool { return r.inDesiredState }
+
+func (r *batchResource) ManagedResources() *config.ManagedResources { return nil }
+
+func (r *batchResource) PackageBatchKey() string { return r.key }
+
+func TestBatchPackages(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name     string
+		batchErr error
+	}{
+		{"success", nil},
+		{"transaction error", errors.New("transaction error")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fakes := map[string]*batchResource{}
+			oldNewResource, oldConcurrency, oldEnforce := newResource, checkStateConcurrency, enforcePackageBatch
+			defer func() {
+				newResource, checkStateConcurrency, enforcePackageBatch = oldNewResource, oldConcurrency, oldEnforce
+			}()
+			newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+				f := &batchResource{id: r.GetId()}
+				switch r.GetId() {
+				case "a", "b", "c", "d", "e", "g", "i":
+					f.key = "apt/INSTALLED"
+				case "f":
+					f.key = "apt/REMOVED"
+				case "h":
+					f.key = "apt/INSTALLED"
+					f.inDesiredState = true
+				case "file":
+					f.inDesiredState = true
+				}
+				fakes[r.GetId()] = f
+				return &resource{resourceIface: f}
+			}
+			checkStateConcurrency = func() int { return 2 }
+			var batched []string
+			enforcePackageBatch = func(ctx context.Context, rs []*resource) error {
+				for _, r := range rs {
+					batched = append(batched, r.resourceIface.(*batchResource).id)
+				}
+				return tc.batchErr
+			}
+
+			pkg := &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: &agentendpointpb.OSPolicy_Resource_PackageResource{}}
+			file := &agentendpointpb.OSPolicy_Resource_File_{File: &agentendpointpb.OSPolicy_Resource_FileResource{}}
+			exec := &agentendpointpb.OSPolicy_Resource_Exec{Exec: &agentendpointpb.OSPolicy_Resource_ExecResource{}}
+			enforce := agentendpointpb.OSPolicy_ENFORCEMENT
+			c := &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{
+				OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{
+					// c runs after an exec resource that may depend on the others.
+					{Id: "p1", Mode: enforce, Resources: []*agentendpointpb.OSPolicy_Resource{{Id: "a", ResourceType: pkg}, {Id: "b", ResourceType: pkg}, {Id: "exec", ResourceType: exec}, {Id: "c", ResourceType: pkg}}},
+					// The file resource is already in its desired state.
+					{Id: "p2", Mode: enforce, Resources: []*agentendpointpb.OSPolicy_Resource{{Id: "file", ResourceType: file}, {Id: "d", ResourceType: pkg}}},
+					{Id: "p3", Mode: agentendpointpb.OSPolicy_VALIDATION, Resources: []*agentendpointpb.OSPolicy_Resource{{Id: "e", ResourceType: pkg}}},
+					// A removal is a different transaction that has to run first.
+					{Id: "p4", Mode: enforce, Resources: []*agentendpointpb.OSPolicy_Resource{{Id: "f", ResourceType: pkg}, {Id: "g", ResourceType: pkg}}},
+					{Id: "p5", Mode: enforce, Resources: []*agentendpointpb.OSPolicy_Resource{{Id: "h", ResourceType: pkg}, {Id: "i", ResourceType: pkg}}},
+				},
+			}}}
+			c.prefetchChecks(ctx)
+
+			policies := c.Task.GetOsPolicies()
+			a := c.prefetchedResource("p1", policies[0].GetResources()[0])
+			if err := a.Validate(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.CheckState(ctx); err != nil {
+				t.Fatal(err)
+			}
+			c.batchPackages(ctx, 0, 0, a)
+
+			if want := []string{"a", "b", "d", "i"}; !reflect.DeepEqual(batched, want) {
+				t.Fatalf("batched resources = %q, want %q", batched, want)
+			}
+			for _, id := range []string{"c", "e", "g"} {
+				if _, ok := fakes[id]; ok {
+					t.Errorf("resource %q should not have been checked ahead", id)
+				}
+			}
+
+			// The apply loop reaches a batched resource later.
+			b := c.prefetchedResource("p1", policies[0].GetResources()[1])
+			b.Validate(ctx)
+			b.CheckState(ctx)
+			if err := a.EnforceState(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.EnforceState(ctx); err != nil {
+				t.Fatal(err)
+			}
+			wantChecks, wantEnforcements := 1, 0
+			if tc.batchErr != nil {
+				// Each resource is checked again and enforced on its own.
+				wantChecks, wantEnforcements = 2, 1
+			}
+			if fakes["b"].checks != wantChecks {
+				t.Errorf("b checked %d times, want %d", fakes["b"].checks, wantChecks)
+			}
+			if fakes["a"].enforcements != wantEnforcements || fakes["b"].enforcements != wantEnforcements {
+				t.Errorf("a and b enforced %d and %d times, want %d", fakes["a"].enforcements, fakes["b"].enforcements, wantEnforcements)
+			}
+			// A resource is only skipped for the batch that enforced it.
+			b.EnforceState(ctx)
+			if fakes["b"].enforcements != wantEnforcements+1 {
+				t.Errorf("b enforced %d times, want %d", fakes["b"].enforcements, wantEnforcements+1)
+			}
+		})
+	}
+}
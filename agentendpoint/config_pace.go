@@ -0,0 +1,187 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/crashreport"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+var (
+	checkStateRate = agentconfig.CheckStateRate
+	// paceAfter is replaced in tests so paced checks do not sleep.
+	paceAfter = time.After
+
+	// pacedTasks are the apply config tasks whose resources were checked in
+	// the background, by task ID, until the tasker runs them.
+	pacedTasks   = map[string]*configTask{}
+	pacedTasksMx sync.Mutex
+)
+
+// checkPacer spreads the resource checks of an apply config task evenly
+// over time, so a large policy set checks a steady number of resources each
+// minute instead of all of them in a burst. A nil checkPacer does not wait.
+// Paced checks run in the background, outside of the tasker and of
+// configTaskMx, so only resources whose checks only read from the host are
+// paced, see paceTask.
+type checkPacer struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newCheckPacer returns a checkPacer for the osconfig-check-state-rate
+// setting, or nil if the rate is not limited.
+func newCheckPacer(ctx context.Context, resources int) *checkPacer {
+	rate := checkStateRate()
+	if rate <= 0 {
+		return nil
+	}
+	p := &checkPacer{interval: time.Minute / time.Duration(rate)}
+	clog.Infof(ctx, "Checking resources at %d per minute, checking %d resources takes at least %s.", rate, resources, time.Duration(resources)*p.interval)
+	return p
+}
+
+// wait blocks until the next check is due or ctx is done.
+func (p *checkPacer) wait(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	at := p.next
+	p.next = p.next.Add(p.interval)
+	p.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		select {
+		case <-ctx.Done():
+		case <-paceAfter(d):
+		}
+	}
+}
+
+// pacedChecks validates and checks the state of the file and repository
+// resources of the task at the pace of p, the apply loop then uses these
+// results as it does for prefetched checks. Other resources run exec
+// scripts or query the package manager and are checked by the apply loop.
+// A result older than one pacer interval that found its resource in the
+// desired state is checked again before enforcement is skipped.
+func (c *configTask) pacedChecks(ctx context.Context, p *checkPacer) {
+	c.prefetched = map[string]map[string]*resource{}
+	for _, osPolicy := range c.Task.GetOsPolicies() {
+		resources := map[string]*resource{}
+		c.prefetched[osPolicy.GetId()] = resources
+		ctx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": osPolicy.GetOsPolicyAssignment(), "os_policy_id": osPolicy.GetId()})
+		for _, configResource := range osPolicy.GetResources() {
+			if ctx.Err() != nil {
+				return
+			}
+			if !prefetchable(configResource) {
+				continue
+			}
+			if _, ok := resources[configResource.GetId()]; ok {
+				continue
+			}
+			ctx := clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()})
+			res := newResource(configResource)
+			pr := &prefetchResult{}
+			if p != nil {
+				pr.maxAge = p.interval
+			}
+			res.prefetch = pr
+			resources[configResource.GetId()] = res
+
+			pr.validateErr = res.resourceIface.Validate(ctx)
+			pr.validated = true
+			if pr.validateErr != nil {
+				continue
+			}
+			p.wait(ctx)
+			pr.checkErr = res.resourceIface.CheckState(ctx)
+			pr.checked = true
+			pr.checkedAt = time.Now()
+		}
+	}
+}
+
+// paceTask checks the resources of an apply config task in the background
+// when checks are paced, and reports whether it did. The task stays STARTED
+// while its resources are checked and is enqueued once they all are, so a
+// large policy set does not hold up other tasks.
+func (c *Client) paceTask(ctx context.Context, task *agentendpointpb.Task) bool {
+	if task.GetTaskType() != agentendpointpb.TaskType_APPLY_CONFIG_TASK {
+		return false
+	}
+	e := c.newConfigTask(task)
+	n := e.prefetchableCount()
+	if n == 0 {
+		return false
+	}
+	p := newCheckPacer(ctx, n)
+	if p == nil {
+		return false
+	}
+	taskID := task.GetTaskId()
+	setDeferred(taskID, true)
+	go func() {
+		defer crashreport.Recover(ctx, "paced config task")
+		pctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer crashreport.Recover(ctx, "paced resource checks")
+			e.pacedChecks(pctx, p)
+		}()
+		err := waitFor(ctx, done, c.deferredKeepAlive(ctx, task))
+		cancel()
+		<-done
+		if err != nil {
+			// The task checks its resources again if it still runs.
+			e.cleanup(ctx)
+			if err != errServerCancel {
+				setDeferred(taskID, false)
+				return
+			}
+		} else {
+			pacedTasksMx.Lock()
+			pacedTasks[taskID] = e
+			pacedTasksMx.Unlock()
+		}
+		c.enqueueDeferred(ctx, task)
+	}()
+	return true
+}
+
+// takePacedTask returns the apply config task whose resources were checked
+// by paceTask, or nil.
+func takePacedTask(taskID string) *configTask {
+	pacedTasksMx.Lock()
+	defer pacedTasksMx.Unlock()
+	e := pacedTasks[taskID]
+	delete(pacedTasks, taskID)
+	return e
+}
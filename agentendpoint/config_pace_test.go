@@ -0,0 +1,154 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestCheckPacer(t *testing.T) {
+	ctx := context.Background()
+	oldRate, oldAfter := checkStateRate, paceAfter
+	defer func() { checkStateRate, paceAfter = oldRate, oldAfter }()
+
+	var waits []time.Duration
+	paceAfter = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		c := make(chan time.Time, 1)
+		c <- time.Now()
+		return c
+	}
+
+	checkStateRate = func() int { return 0 }
+	p := newCheckPacer(ctx, 10)
+	if p != nil {
+		t.Fatalf("newCheckPacer() = %+v, want nil when the rate is not limited", p)
+	}
+	// A nil pacer does not wait.
+	p.wait(ctx)
+	if len(waits) != 0 {
+		t.Errorf("nil pacer waited %v", waits)
+	}
+
+	checkStateRate = func() int { return 60 }
+	p = newCheckPacer(ctx, 10)
+	for i := 0; i < 3; i++ {
+		p.wait(ctx)
+	}
+	// The first check runs right away, the others one interval apart.
+	if len(waits) != 2 {
+		t.Fatalf("pacer waited %d times, want 2", len(waits))
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second} {
+		if waits[i] > want || waits[i] < want-100*time.Millisecond {
+			t.Errorf("wait %d = %s, want about %s", i, waits[i], want)
+		}
+	}
+}
+
+func TestPacedChecks(t *testing.T) {
+	ctx := context.Background()
+	var running, maxRunning int32
+	fakes := map[string]*countingResource{}
+	oldNewResource, oldAfter := newResource, paceAfter
+	defer func() { newResource, paceAfter = oldNewResource, oldAfter }()
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		f := &countingResource{running: &running, maxRunning: &maxRunning}
+		fakes[r.GetId()] = f
+		return &resource{resourceIface: f}
+	}
+	var waits int
+	paceAfter = func(d time.Duration) <-chan time.Time {
+		waits++
+		c := make(chan time.Time, 1)
+		c <- time.Now()
+		return c
+	}
+
+	file := &agentendpointpb.OSPolicy_Resource_File_{File: &agentendpointpb.OSPolicy_Resource_FileResource{}}
+	c := &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{
+		OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{{
+			Id: "p1",
+			Resources: []*agentendpointpb.OSPolicy_Resource{
+				{Id: "f1", ResourceType: file},
+				{Id: "exec", ResourceType: &agentendpointpb.OSPolicy_Resource_Exec{}},
+				{Id: "f2", ResourceType: file},
+			},
+		}},
+	}}}
+	c.pacedChecks(ctx, &checkPacer{interval: time.Second})
+
+	// Only the file and repository resources are checked in the
+	// background, in turn, the exec resource is left to the apply loop.
+	if maxRunning != 1 {
+		t.Errorf("max concurrent checks = %d, want 1", maxRunning)
+	}
+	if waits != 1 {
+		t.Errorf("pacer waited %d times, want 1", waits)
+	}
+	if _, ok := fakes["exec"]; ok {
+		t.Error("exec resource checked in the background")
+	}
+	for _, r := range c.Task.GetOsPolicies()[0].GetResources() {
+		res := c.prefetchedResource("p1", r)
+		if err := res.Validate(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := res.CheckState(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if f := fakes[r.GetId()]; f.validates != 1 || f.checks != 1 {
+			t.Errorf("%s validated %d and checked %d times, want once each", r.GetId(), f.validates, f.checks)
+		}
+	}
+
+	// A canceled context stops the checks.
+	fakes = map[string]*countingResource{}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	c.pacedChecks(cctx, &checkPacer{interval: time.Second})
+	if len(fakes) != 0 {
+		t.Errorf("checked %d resources after the context was canceled, want none", len(fakes))
+	}
+}
+
+func TestPacedCheckStale(t *testing.T) {
+	ctx := context.Background()
+	var running, maxRunning int32
+	for _, tc := range []struct {
+		desc           string
+		age            time.Duration
+		inDesiredState bool
+		wantChecks     int
+	}{
+		{"fresh compliant", 0, true, 1},
+		{"stale non compliant", time.Hour, false, 1},
+		{"stale compliant", time.Hour, true, 2},
+	} {
+		f := &countingResource{running: &running, maxRunning: &maxRunning, inDesiredState: tc.inDesiredState}
+		f.CheckState(ctx)
+		res := &resource{resourceIface: f, prefetch: &prefetchResult{checked: true, checkedAt: time.Now().Add(-tc.age), maxAge: time.Minute}}
+		if err := res.CheckState(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if f.checks != tc.wantChecks {
+			t.Errorf("%s: checked %d times, want %d", tc.desc, f.checks, tc.wantChecks)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/crashreport"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+var checkStateConcurrency = agentconfig.CheckStateConcurrency
+
+// prefetchResult holds the result of validating and checking a resource
+// ahead of the apply loop, each result is consumed at most once.
+type prefetchResult struct {
+	validated   bool
+	validateErr error
+	checked     bool
+	checkErr    error
+	checkedAt   time.Time
+	// maxAge is how long a check that found the resource in its desired
+	// state is used for, it is used regardless of its age if zero.
+	maxAge time.Duration
+	// batched is set when the resource was enforced in a package batch.
+	batched bool
+}
+
+// Validate returns the prefetched validation result if there is one and it
+// has not been invalidated by an enforcement.
+func (r *resource) Validate(ctx context.Context) error {
+	if p := r.prefetch; p != nil && p.validated {
+		p.validated = false
+		return p.validateErr
+	}
+	return r.resourceIface.Validate(ctx)
+}
+
+// CheckState returns the prefetched check state result if there is one and
+// it has not been invalidated by an enforcement. A result older than its
+// maxAge that found the resource in its desired state is checked again, the
+// resource may have drifted since and would not be enforced.
+func (r *resource) CheckState(ctx context.Context) error {
+	if p := r.prefetch; p != nil && p.checked {
+		p.checked = false
+		stale := p.maxAge > 0 && time.Since(p.checkedAt) > p.maxAge
+		if !stale || p.checkErr != nil || !r.resourceIface.InDesiredState() {
+			return p.checkErr
+		}
+		clog.Debugf(ctx, "Checking resource again, the check from %s is stale.", p.checkedAt.Format(time.RFC3339))
+	}
+	return r.resourceIface.CheckState(ctx)
+}
+
+// prefetchable reports whether checking the state of r only reads from the
+// host, so it can run concurrently with other checks.
+func prefetchable(r *agentendpointpb.OSPolicy_Resource) bool {
+	return r.GetFile() != nil || r.GetRepository() != nil
+}
+
+// prefetchChecks validates and checks the state of the file and repository
+// resources of every policy concurrently, the sequential apply loop then
+// uses these results instead of checking each resource in turn. Enforcement
+// remains sequential and any enforcement action invalidates the prefetched
+// results that have not been used yet.
+func (c *configTask) prefetchChecks(ctx context.Context) {
+	c.prefetched = map[string]map[string]*resource{}
+	limit := checkStateConcurrency()
+	if limit <= 1 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+	for _, osPolicy := range c.Task.GetOsPolicies() {
+		resources := map[string]*resource{}
+		c.prefetched[osPolicy.GetId()] = resources
+		ctx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": osPolicy.GetOsPolicyAssignment(), "os_policy_id": osPolicy.GetId()})
+		for _, configResource := range osPolicy.GetResources() {
+			if !prefetchable(configResource) {
+				continue
+			}
+			if _, ok := resources[configResource.GetId()]; ok {
+				continue
+			}
+			res := newResource(configResource)
+			p := &prefetchResult{}
+			resources[configResource.GetId()] = res
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(ctx context.Context, res *resource, p *prefetchResult) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				defer crashreport.Recover(ctx, "resource prefetch")
+				p.validateErr = res.resourceIface.Validate(ctx)
+				p.validated = true
+				if p.validateErr != nil {
+					return
+				}
+				p.checkErr = res.resourceIface.CheckState(ctx)
+				p.checked = true
+			}(clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()}), res, p)
+			res.prefetch = p
+		}
+	}
+	wg.Wait()
+}
+
+// prefetchedResource returns the prefetched resource for a policy resource,
+// or a new one if it was not prefetched.
+func (c *configTask) prefetchedResource(policyID string, configResource *agentendpointpb.OSPolicy_Resource) *resource {
+	if res, ok := c.prefetched[policyID][configResource.GetId()]; ok {
+		delete(c.prefetched[policyID], configResource.GetId())
+		return res
+	}
+	return newResource(configResource)
+}
+
+// invalidatePrefetchedChecks discards prefetched validation and check
+// results after the host has been changed by an enforcement, except for
+// resources enforced in a package batch whose check result is from before
+// the batch.
+func (c *configTask) invalidatePrefetchedChecks() {
+	for _, resources := range c.prefetched {
+		for _, res := range resources {
+			if res.prefetch.batched {
+				continue
+			}
+			res.prefetch.validated = false
+			res.prefetch.checked = false
+		}
+	}
+}
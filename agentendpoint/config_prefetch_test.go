@@ -0,0 +1,136 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/config"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+type countingResource struct {
+	mu             sync.Mutex
+	validates      int
+	checks         int
+	inDesiredState bool
+	running        *int32
+	maxRunning     *int32
+}
+
+func (r *countingResource) Validate(ctx context.Context) error {
+	r.mu.Lock()
+	r.validates++
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *countingResource) CheckState(ctx context.Context) error {
+	n := atomic.AddInt32(r.running, 1)
+	for {
+		max := atomic.LoadInt32(r.maxRunning)
+		if n <= max || atomic.CompareAndSwapInt32(r.maxRunning, max, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	atomic.AddInt32(r.running, -1)
+	r.mu.Lock()
+	r.checks++
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *countingResource) EnforceState(ctx context.Context) error { return nil }
+
+func (r *countingResource) PopulateOutput(*agentendpointpb.OSPolicyResourceCompliance) error {
+	return nil
+}
+
+func (r *countingResource) Cleanup(ctx context.Context) error { return nil }
+
+func (r *countingResource) InDesiredState() bool { return r.inDesiredState }
+
+func (r *countingResource) ManagedResources() *config.ManagedResources { return nil }
+
+func TestPrefetchChecks(t *testing.T) {
+	ctx := context.Background()
+	var running, maxRunning int32
+	fakes := map[string]*countingResource{}
+	oldNewResource, oldConcurrency := newResource, checkStateConcurrency
+	defer func() { newResource, checkStateConcurrency = oldNewResource, oldConcurrency }()
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		f := &countingResource{running: &running, maxRunning: &maxRunning}
+		fakes[r.GetId()] = f
+		return &resource{resourceIface: f}
+	}
+	checkStateConcurrency = func() int { return 2 }
+
+	file := &agentendpointpb.OSPolicy_Resource_File_{File: &agentendpointpb.OSPolicy_Resource_FileResource{}}
+	c := &configTask{Task: &applyConfigTask{&agentendpointpb.ApplyConfigTask{
+		OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{{
+			Id: "p1",
+			Resources: []*agentendpointpb.OSPolicy_Resource{
+				{Id: "f1", ResourceType: file},
+				{Id: "f2", ResourceType: file},
+				{Id: "f3", ResourceType: file},
+				{Id: "exec", ResourceType: &agentendpointpb.OSPolicy_Resource_Exec{}},
+			},
+		}},
+	}}}
+	c.prefetchChecks(ctx)
+
+	if _, ok := fakes["exec"]; ok {
+		t.Error("exec resource should not be prefetched")
+	}
+	if maxRunning != 2 {
+		t.Errorf("max concurrent checks = %d, want 2", maxRunning)
+	}
+
+	// The first use of a prefetched check does not run the check again.
+	f1 := c.prefetchedResource("p1", c.Task.GetOsPolicies()[0].GetResources()[0])
+	if err := f1.Validate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := f1.CheckState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fakes["f1"].checks != 1 {
+		t.Errorf("f1 checked %d times, want 1", fakes["f1"].checks)
+	}
+	// Later checks, such as post enforcement, run again.
+	f1.CheckState(ctx)
+	if fakes["f1"].checks != 2 {
+		t.Errorf("f1 checked %d times, want 2", fakes["f1"].checks)
+	}
+
+	// After an enforcement prefetched validations and checks are
+	// discarded.
+	c.invalidatePrefetchedChecks()
+	f2 := c.prefetchedResource("p1", c.Task.GetOsPolicies()[0].GetResources()[1])
+	f2.Validate(ctx)
+	if fakes["f2"].validates != 2 {
+		t.Errorf("f2 validated %d times, want 2", fakes["f2"].validates)
+	}
+	f2.CheckState(ctx)
+	if fakes["f2"].checks != 2 {
+		t.Errorf("f2 checked %d times, want 2", fakes["f2"].checks)
+	}
+}
@@ -0,0 +1,59 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// errSuperseded is reported when the service stops an ApplyConfigTask after
+// a newer task was queued behind it.
+var errSuperseded = errors.New("task superseded by a newer task")
+
+// configTaskMx is held while an ApplyConfigTask runs. The tasker already
+// runs tasks one at a time, configTaskMx guarantees that two tasks never
+// interleave their checks and enforcement even if one is started outside
+// of the tasker. A newer task waits for the running one, which stops early
+// if the service supersedes it.
+var configTaskMx sync.Mutex
+
+// checkSuperseded asks the service whether to continue when a task
+// notification arrived since the last check, the newer task stays queued
+// unless the service stops this one. Checks only happen between resources
+// so a resource is never left partially enforced.
+func (c *configTask) checkSuperseded(ctx context.Context) error {
+	if atomic.SwapInt32(&c.client.pending, 0) == 0 {
+		return nil
+	}
+	clog.Infof(ctx, "Task notification received while running ApplyConfigTask, checking task directive.")
+	// Bypass the duplicate state window, the directive may have changed.
+	delete(c.lastProgressState, agentendpointpb.ApplyConfigTaskProgress_APPLYING_CONFIG)
+	err := c.reportContinuingState(ctx, agentendpointpb.ApplyConfigTaskProgress_APPLYING_CONFIG)
+	switch {
+	case err == errServerCancel:
+		return errSuperseded
+	case err != nil:
+		// Keep going, the newer task will run once this one completes.
+		clog.Warningf(ctx, "Error checking task directive: %v", err)
+	}
+	return nil
+}
@@ -16,15 +16,24 @@ package agentendpoint
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/config"
+	"github.com/GoogleCloudPlatform/osconfig/history"
+	"github.com/GoogleCloudPlatform/osconfig/managedfiles"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"github.com/GoogleCloudPlatform/osconfig/pretty"
+	"github.com/GoogleCloudPlatform/osconfig/progress"
+	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
 )
@@ -37,8 +46,11 @@ var newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
 	return &resource{resourceIface: resourceIface(&config.OSPolicyResource{OSPolicy_Resource: r})}
 }
 
-var repoFormats = []string{agentconfig.AptRepoFormat(), agentconfig.YumRepoFormat(), agentconfig.ZypperRepoFormat(), agentconfig.GooGetRepoFormat()}
+var repoFormats = []string{agentconfig.AptRepoFormat(), agentconfig.AptSourcesFormat(), agentconfig.YumRepoFormat(), agentconfig.ZypperRepoFormat(), agentconfig.GooGetRepoFormat()}
 
+// configTask runs an ApplyConfigTask. Only one configTask runs at a time,
+// see configTaskMx; the background checks of a paced task may run while
+// another task runs, they only check file and repository resources.
 type configTask struct {
 	StartedAt         time.Time `json:",omitempty"`
 	client            *Client
@@ -48,6 +60,17 @@ type configTask struct {
 	TaskID            string
 	results           []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult
 	managedResources  []*config.ManagedResources
+	drift             *driftTracker
+	prefetched        map[string]map[string]*resource
+	// superseded is set when the service stopped this task for a newer one,
+	// the remaining policies are not run.
+	superseded error
+	// driftStateFile is where drift counters are persisted, they are not
+	// persisted if it is empty.
+	driftStateFile string
+	// labels are the service labels of the task, they carry its scheduling
+	// hints.
+	labels map[string]string
 }
 
 type applyConfigTask struct {
@@ -62,6 +85,10 @@ type resource struct {
 	resourceIface
 	needsPostCheck       bool
 	validateOrCheckError bool
+	prefetch             *prefetchResult
+	// enforcedAt is when the resource was enforced in this run, zero if it
+	// was not.
+	enforcedAt time.Time
 }
 
 type resourceIface interface {
@@ -74,6 +101,15 @@ type resourceIface interface {
 	ManagedResources() *config.ManagedResources
 }
 
+// NotEnforceable returns why the resource cannot be enforced on this system,
+// as probed when it was validated, or nil if it can be.
+func (r *resource) NotEnforceable() error {
+	if ne, ok := r.resourceIface.(interface{ NotEnforceable() error }); ok {
+		return ne.NotEnforceable()
+	}
+	return nil
+}
+
 func (c *configTask) reportCompletedState(ctx context.Context, errMsg string, state agentendpointpb.ApplyConfigTaskOutput_State) error {
 	req := &agentendpointpb.ReportTaskCompleteRequest{
 		TaskId:       c.TaskID,
@@ -143,6 +179,24 @@ func truncateMessage(msg string, size int) string {
 	return msg
 }
 
+// failureMessage formats err for a task or config step result. Package
+// manager failures lead with their kind and exit code, followed by the tail
+// of their output unless err already carries it.
+func failureMessage(err error) string {
+	var cerr *packages.CommandError
+	if !errors.As(err, &cerr) {
+		return err.Error()
+	}
+	msg := err.Error()
+	excerpt := cerr.Excerpt()
+	// CommandError quotes the command output.
+	quoted := strconv.Quote(excerpt)
+	if excerpt == "" || strings.Contains(msg, excerpt) || strings.Contains(msg, quoted[1:len(quoted)-1]) {
+		return fmt.Sprintf("%s failure (exit code %d): %s", cerr.Kind, cerr.ExitCode, msg)
+	}
+	return fmt.Sprintf("%s failure (exit code %d): %s: %s", cerr.Kind, cerr.ExitCode, excerpt, msg)
+}
+
 func validateConfigResource(ctx context.Context, res *resource, policyMR *config.ManagedResources, rCompliance *agentendpointpb.OSPolicyResourceCompliance, configResource *agentendpointpb.OSPolicy_Resource) (hasError bool) {
 	ctx = clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()})
 	clog.Debugf(ctx, "Running step 'validate' on resource %q.", configResource.GetId())
@@ -213,15 +267,28 @@ func enforceConfigResourceState(ctx context.Context, res *resource, rCompliance
 		return false, false
 	}
 
+	// The filesystems the resource writes to were probed when it was
+	// validated, a resource on a read-only root is not attempted.
+	if err := res.NotEnforceable(); err != nil {
+		notEnforceable(ctx, rCompliance, fmt.Sprintf("Enforce state: resource %q skipped, it cannot be enforced on this system: %v", configResource.GetId(), err))
+		return false, true
+	}
+
 	var errMessage string
 	outcome := agentendpointpb.OSPolicyResourceConfigStep_SUCCEEDED
 	err := res.EnforceState(ctx)
+	if util.IsReadOnlyFilesystem(err) {
+		// A write the probe did not cover failed, nothing was changed.
+		notEnforceable(ctx, rCompliance, fmt.Sprintf("Enforce state: resource %q cannot be enforced on this platform: %v", configResource.GetId(), err))
+		return false, true
+	}
 	if err != nil {
 		outcome = agentendpointpb.OSPolicyResourceConfigStep_FAILED
 		hasError = true
-		errMessage = truncateMessage(fmt.Sprintf("Enforce state: resource %q error: %v", configResource.GetId(), err), maxErrorMessage)
+		errMessage = truncateMessage(fmt.Sprintf("Enforce state: resource %q error: %s", configResource.GetId(), failureMessage(err)), maxErrorMessage)
 		clog.Errorf(ctx, errMessage)
 	} else {
+		res.enforcedAt = time.Now()
 		clog.Infof(ctx, "Enforce state: resource %q enforcement successful.", configResource.GetId())
 	}
 
@@ -236,6 +303,19 @@ func enforceConfigResourceState(ctx context.Context, res *resource, rCompliance
 	return true, hasError
 }
 
+// notEnforceable records a failed enforcement step that changed nothing, the
+// resource keeps the non compliant state from the check.
+func notEnforceable(ctx context.Context, rCompliance *agentendpointpb.OSPolicyResourceCompliance, msg string) {
+	errMessage := truncateMessage(msg, maxErrorMessage)
+	clog.Errorf(ctx, errMessage)
+	rCompliance.ConfigSteps = append(rCompliance.GetConfigSteps(), &agentendpointpb.OSPolicyResourceConfigStep{
+		Type:         agentendpointpb.OSPolicyResourceConfigStep_DESIRED_STATE_ENFORCEMENT,
+		Outcome:      agentendpointpb.OSPolicyResourceConfigStep_FAILED,
+		ErrorMessage: errMessage,
+	})
+	rCompliance.State = agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+}
+
 func postCheckConfigResourceState(ctx context.Context, res *resource, rCompliance *agentendpointpb.OSPolicyResourceCompliance, configResource *agentendpointpb.OSPolicy_Resource) {
 	ctx = clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()})
 	clog.Debugf(ctx, "Running step 'check state post enforcement' on resource %q.", configResource.GetId())
@@ -273,6 +353,10 @@ func (c *configTask) postCheckState(ctx context.Context) {
 	for i, osPolicy := range c.Task.GetOsPolicies() {
 		ctx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": osPolicy.GetOsPolicyAssignment(), "os_policy_id": osPolicy.GetId()})
 		plcy, ok := c.policies[osPolicy.GetId()]
+		// A superseded task stops before evaluating every policy.
+		if !ok && c.superseded != nil {
+			continue
+		}
 		// This should not happen in the normal code flow since we only run postCheckState after
 		// all policies have been evaluated.
 		if !ok {
@@ -311,13 +395,19 @@ func (c *configTask) generateBaseResults() {
 	}
 }
 
-func removeFileIfNoMatch(a string, s []string) error {
+// removeFileIfNoMatch removes a if it is not in s, and forgets it as a
+// managed file.
+func removeFileIfNoMatch(ctx context.Context, a string, s []string) error {
 	for _, b := range s {
 		if a == b {
 			return nil
 		}
 	}
-	return os.Remove(a)
+	if err := os.Remove(a); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	managedfiles.Forget(ctx, a)
+	return nil
 }
 
 func (c *configTask) cleanupRepos(ctx context.Context) {
@@ -336,7 +426,7 @@ func (c *configTask) cleanupRepos(ctx context.Context) {
 			clog.Errorf(ctx, "Error globing directory: %v", err)
 		}
 		for _, match := range matches {
-			if err := removeFileIfNoMatch(match, managedRepos); err != nil {
+			if err := removeFileIfNoMatch(ctx, match, managedRepos); err != nil {
 				clog.Errorf(ctx, "Error cleaning up old repo: %v", err)
 			}
 		}
@@ -351,7 +441,10 @@ func (c *configTask) cleanup(ctx context.Context) {
 	// Cleanup any policy specific resources.
 	for _, osPolicy := range c.Task.GetOsPolicies() {
 		ctx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": osPolicy.GetOsPolicyAssignment(), "os_policy_id": osPolicy.GetId()})
-		plcy := c.policies[osPolicy.GetId()]
+		plcy, ok := c.policies[osPolicy.GetId()]
+		if !ok {
+			continue
+		}
 		for _, configResource := range osPolicy.GetResources() {
 			ctx := clog.WithLabels(ctx, map[string]string{"resource_id": configResource.GetId()})
 			res, ok := plcy.resources[configResource.GetId()]
@@ -363,12 +456,25 @@ func (c *configTask) cleanup(ctx context.Context) {
 			}
 		}
 	}
+	// Cleanup prefetched resources that were never reached.
+	for _, resources := range c.prefetched {
+		for _, res := range resources {
+			if err := res.Cleanup(ctx); err != nil {
+				clog.Warningf(ctx, "Error running resource cleanup:%v", err)
+			}
+		}
+	}
 }
 
 func (c *configTask) run(ctx context.Context) error {
+	configTaskMx.Lock()
+	defer configTaskMx.Unlock()
 	clog.Infof(ctx, "Beginning ApplyConfigTask.")
 	clog.Debugf(ctx, "ApplyConfigTask:\n%s", pretty.Format(c.Task.ApplyConfigTask))
 	c.StartedAt = time.Now()
+	// Notifications received before this task started are for this task, or
+	// tasks queued with it, and don't supersede it.
+	atomic.StoreInt32(&c.client.pending, 0)
 
 	rcsErrMsg := "Error reporting continuing state"
 	if err := c.reportContinuingState(ctx, agentendpointpb.ApplyConfigTaskProgress_STARTED); err != nil {
@@ -377,9 +483,21 @@ func (c *configTask) run(ctx context.Context) error {
 
 	if len(c.Task.GetOsPolicies()) == 0 {
 		clog.Infof(ctx, "No OSPolicies to apply.")
+		c.writeEffectivePolicies(ctx)
 		return c.reportCompletedState(ctx, "", agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED)
 	}
 
+	if err := checkScheduleWindow(ctx, c.labels); err != nil {
+		return c.reportCompletedState(ctx, err.Error(), agentendpointpb.ApplyConfigTaskOutput_CANCELLED)
+	}
+
+	// During a change freeze resources are checked and reported as in
+	// VALIDATION mode.
+	frozen := freezeCheck(ctx)
+	if frozen != nil {
+		clog.Warningf(ctx, "Not enforcing OS policies: %v.", frozen)
+	}
+
 	// We need to generate base results first thing, each execution step
 	// just adds on.
 	c.generateBaseResults()
@@ -390,11 +508,18 @@ func (c *configTask) run(ctx context.Context) error {
 	}
 
 	c.policies = map[string]*policy{}
+	c.drift = loadDriftTracker(ctx, c.driftStateFile)
+	// Resources of a paced task were already checked in the background.
+	if c.prefetched == nil {
+		c.prefetchChecks(ctx)
+	}
+policies:
 	for i, osPolicy := range c.Task.GetOsPolicies() {
 		ctx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": osPolicy.GetOsPolicyAssignment(), "os_policy_id": osPolicy.GetId()})
 		clog.Infof(ctx, "Executing policy %q", osPolicy.GetId())
 
 		pResult := c.results[i]
+		polIdx := i
 		plcy := &policy{resources: map[string]*resource{}}
 		c.policies[osPolicy.GetId()] = plcy
 		var policyMR *config.ManagedResources
@@ -403,11 +528,17 @@ func (c *configTask) run(ctx context.Context) error {
 		if osPolicy.GetMode() == agentendpointpb.OSPolicy_VALIDATION {
 			clog.Infof(ctx, "Policy running in VALIDATION mode, not running enforcement action for any resources.")
 			validateOnly = true
+		} else if frozen != nil {
+			validateOnly = true
 		}
 
 		for i, configResource := range osPolicy.GetResources() {
+			if err := c.checkSuperseded(ctx); err != nil {
+				c.superseded = err
+				break policies
+			}
 			rCompliance := pResult.GetOsPolicyResourceCompliances()[i]
-			plcy.resources[configResource.GetId()] = newResource(configResource)
+			plcy.resources[configResource.GetId()] = c.prefetchedResource(osPolicy.GetId(), configResource)
 			res := plcy.resources[configResource.GetId()]
 			if hasError := validateConfigResource(ctx, res, policyMR, rCompliance, configResource); hasError {
 				res.validateOrCheckError = true
@@ -417,20 +548,39 @@ func (c *configTask) run(ctx context.Context) error {
 				res.validateOrCheckError = true
 				break
 			}
+			if c.drift.recordCheck(osPolicy.GetOsPolicyAssignment(), osPolicy.GetId(), configResource.GetId(), rCompliance.GetState()) {
+				clog.Infof(ctx, "Resource %q drifted out of compliance since the last run.", configResource.GetId())
+			}
 
 			// Skip enforcement actions in VALIDATION mode.
 			if validateOnly {
+				if frozen != nil && !res.InDesiredState() {
+					clog.Infof(ctx, "Skipped enforcing resource %q: %v.", configResource.GetId(), frozen)
+				}
 				continue
 			}
 
+			if !res.InDesiredState() {
+				c.batchPackages(ctx, polIdx, i, res)
+			}
 			// Only errors in validate and check state constitute a serious error,
 			// for enforce if any action is taken we still want to run post check.
 			// We do however stop further execution of this polcy on enforce error.
+			// Only take the history mark when the resource is about to be
+			// enforced, it costs a yum history call.
+			var mark *packages.TransactionMark
+			if configResource.GetPkg() != nil && !res.InDesiredState() && res.NotEnforceable() == nil {
+				mark = markTransactions(ctx)
+			}
 			enforcementActionTaken, hasError := enforceConfigResourceState(ctx, res, rCompliance, configResource)
+			if enforcementActionTaken && mark != nil {
+				recordTransactions(ctx, &transactionRecord{TaskID: c.TaskID, TaskType: "ApplyConfig", PolicyID: osPolicy.GetId(), ResourceID: configResource.GetId(), Transactions: mark.Transactions(ctx)})
+			}
 			if enforcementActionTaken {
 				// On any change we trigger post check for all previous resouces,
 				// even if there was an error.
 				c.markPostCheckRequired()
+				c.invalidatePrefetchedChecks()
 			}
 			// Still record output even if there was an error during enforcement.
 			res.PopulateOutput(rCompliance)
@@ -444,6 +594,21 @@ func (c *configTask) run(ctx context.Context) error {
 
 	// Run any post checks that we need to.
 	c.postCheckState(ctx)
+	c.recordDrift(ctx)
+	// The newer task writes the effective policies.
+	if c.superseded == nil {
+		c.writeEffectivePolicies(ctx)
+	}
+	c.recordHistory(ctx)
+	if agentconfig.GuestAttributesEnabled() {
+		c.publishComplianceBeacon(ctx)
+		c.publishExecResults(ctx)
+	}
+
+	if c.superseded != nil {
+		clog.Infof(ctx, "Stopping ApplyConfigTask: %v", c.superseded)
+		return c.reportCompletedState(ctx, c.superseded.Error(), agentendpointpb.ApplyConfigTaskOutput_CANCELLED)
+	}
 
 	if err := c.reportCompletedState(ctx, "", agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED); err != nil {
 		return err
@@ -452,6 +617,86 @@ func (c *configTask) run(ctx context.Context) error {
 	return nil
 }
 
+// prefetchableCount returns the number of file and repository resources in
+// the task.
+func (c *configTask) prefetchableCount() int {
+	var n int
+	for _, osPolicy := range c.Task.GetOsPolicies() {
+		for _, r := range osPolicy.GetResources() {
+			if prefetchable(r) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// recordDrift records the final state of each resource and persists and
+// reports the drift counters.
+func (c *configTask) recordDrift(ctx context.Context) {
+	for i, osPolicy := range c.Task.GetOsPolicies() {
+		for _, rCompliance := range c.results[i].GetOsPolicyResourceCompliances() {
+			c.drift.recordFinal(osPolicy.GetOsPolicyAssignment(), osPolicy.GetId(), rCompliance.GetOsPolicyResourceId(), rCompliance.GetState())
+		}
+	}
+	c.drift.report(ctx)
+	if err := c.drift.save(); err != nil {
+		clog.Warningf(ctx, "Error saving drift state: %v", err)
+	}
+}
+
+type resourceComplianceRecord struct {
+	Assignment string `json:"assignment,omitempty"`
+	PolicyID   string `json:"policyId"`
+	ResourceID string `json:"resourceId"`
+	State      string `json:"state"`
+	// Results are the structured results reported by exec resource scripts.
+	Results map[string]string `json:"results,omitempty"`
+	// Checks and Drifts are the drift counters of the resource, see
+	// resourceDrift.
+	Checks int64 `json:"checks,omitempty"`
+	Drifts int64 `json:"drifts,omitempty"`
+}
+
+// recordHistory records the final compliance state of each resource in the
+// local history.
+func (c *configTask) recordHistory(ctx context.Context) {
+	var records []resourceComplianceRecord
+	for i, osPolicy := range c.Task.GetOsPolicies() {
+		for _, rCompliance := range c.results[i].GetOsPolicyResourceCompliances() {
+			record := resourceComplianceRecord{
+				Assignment: osPolicy.GetOsPolicyAssignment(),
+				PolicyID:   osPolicy.GetId(),
+				ResourceID: rCompliance.GetOsPolicyResourceId(),
+				State:      rCompliance.GetState().String(),
+				Results:    c.resourceResults(osPolicy.GetId(), rCompliance.GetOsPolicyResourceId()),
+			}
+			if r := c.drift.counters(osPolicy.GetOsPolicyAssignment(), osPolicy.GetId(), rCompliance.GetOsPolicyResourceId()); r != nil {
+				record.Checks, record.Drifts = r.Checks, r.Drifts
+			}
+			records = append(records, record)
+		}
+	}
+	history.Add(ctx, history.Compliance, records)
+}
+
+// resourceResults returns the structured results reported by a resource, if
+// it reports any.
+func (c *configTask) resourceResults(policyID, resourceID string) map[string]string {
+	plcy, ok := c.policies[policyID]
+	if !ok {
+		return nil
+	}
+	res, ok := plcy.resources[resourceID]
+	if !ok || res == nil {
+		return nil
+	}
+	if r, ok := res.resourceIface.(interface{ Results() map[string]string }); ok {
+		return r.Results()
+	}
+	return nil
+}
+
 // Mark all resources that have already completed as "needs post check".
 func (c *configTask) markPostCheckRequired() {
 	for _, osPolicy := range c.Task.GetOsPolicies() {
@@ -475,11 +720,22 @@ func (c *configTask) markPostCheckRequired() {
 // RunApplyConfig runs an ApplyConfigTask.
 func (c *Client) RunApplyConfig(ctx context.Context, task *agentendpointpb.Task) error {
 	ctx = clog.WithLabels(ctx, task.GetServiceLabels())
-	e := &configTask{
-		TaskID: task.GetTaskId(),
-		client: c,
-		Task:   &applyConfigTask{task.GetApplyConfigTask()},
+	ctx = clog.WithLabels(ctx, map[string]string{"task_id": task.GetTaskId()})
+	ctx = progress.WithTask(ctx, task.GetTaskId())
+	e := takePacedTask(task.GetTaskId())
+	if e == nil {
+		e = c.newConfigTask(task)
 	}
 
 	return e.run(ctx)
 }
+
+func (c *Client) newConfigTask(task *agentendpointpb.Task) *configTask {
+	return &configTask{
+		TaskID:         task.GetTaskId(),
+		client:         c,
+		Task:           &applyConfigTask{task.GetApplyConfigTask()},
+		labels:         task.GetServiceLabels(),
+		driftStateFile: c.driftStateFile,
+	}
+}
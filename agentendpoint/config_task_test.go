@@ -19,11 +19,20 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/GoogleCloudPlatform/osconfig/changefreeze"
 	"github.com/GoogleCloudPlatform/osconfig/config"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -84,6 +93,8 @@ type agentEndpointServiceConfigTestServer struct {
 	lastReportTaskCompleteRequest *agentendpointpb.ReportTaskCompleteRequest
 	progressError                 chan struct{}
 	progressCancel                chan struct{}
+	// onProgress is called for every progress report.
+	onProgress func()
 }
 
 func (*agentEndpointServiceConfigTestServer) ReceiveTaskNotification(req *agentendpointpb.ReceiveTaskNotificationRequest, srv agentendpointpb.AgentEndpointService_ReceiveTaskNotificationServer) error {
@@ -95,6 +106,9 @@ func (*agentEndpointServiceConfigTestServer) StartNextTask(ctx context.Context,
 }
 
 func (s *agentEndpointServiceConfigTestServer) ReportTaskProgress(ctx context.Context, req *agentendpointpb.ReportTaskProgressRequest) (*agentendpointpb.ReportTaskProgressResponse, error) {
+	if s.onProgress != nil {
+		s.onProgress()
+	}
 	select {
 	case s.progressError <- struct{}{}:
 	default:
@@ -241,6 +255,13 @@ func genTestPolicyResult(id string, steps int, inDesiredState bool) *agentendpoi
 func TestRunApplyConfig(t *testing.T) {
 	ctx := context.Background()
 	sameStateTimeWindow = 0
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	driftStateFile := filepath.Join(td, "drift.state")
+	effectivePoliciesFile = filepath.Join(td, "effective_policies.json")
 	res := &testResource{}
 	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
 		return &resource{resourceIface: resourceIface(res)}
@@ -407,6 +428,7 @@ func TestRunApplyConfig(t *testing.T) {
 				t.Fatal(err)
 			}
 			defer tc.close()
+			tc.client.driftStateFile = driftStateFile
 
 			res.inDesiredState = tt.startInDesiredState
 			res.steps = tt.stepsBeforeErr
@@ -473,3 +495,311 @@ func TestTruncateMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestRunApplyConfigSuperseded(t *testing.T) {
+	ctx := context.Background()
+	sameStateTimeWindow = 0
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	driftStateFile := filepath.Join(td, "drift.state")
+	effectivePoliciesFile = filepath.Join(td, "effective_policies.json")
+	res := &testResource{inDesiredState: true, steps: 5}
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(res)}
+	}
+
+	tests := []struct {
+		name              string
+		callsBeforeCancel int
+		want              *agentendpointpb.ReportTaskCompleteRequest
+	}{
+		{
+			"Stopped",
+			2,
+			// Resources not reached are reported without a state.
+			configOutputGen(errSuperseded.Error(), agentendpointpb.ApplyConfigTaskOutput_CANCELLED,
+				[]*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{
+					{
+						OsPolicyId:                  "p1",
+						OsPolicyResourceCompliances: []*agentendpointpb.OSPolicyResourceCompliance{{OsPolicyResourceId: "r1"}},
+					},
+				},
+			),
+		},
+		{
+			"Continued",
+			5,
+			configOutputGen("", agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED,
+				[]*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{
+					genTestPolicyResult("p1", 2, true),
+				},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &agentEndpointServiceConfigTestServer{
+				progressError:  make(chan struct{}, 5),
+				progressCancel: make(chan struct{}, tt.callsBeforeCancel),
+			}
+			tc, err := newTestClient(ctx, srv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tc.close()
+			tc.client.driftStateFile = driftStateFile
+			// A notification queued before the task started is ignored,
+			// one arriving once it runs triggers a directive check.
+			tc.client.pending = 1
+			var once sync.Once
+			srv.onProgress = func() { once.Do(func() { atomic.StoreInt32(&tc.client.pending, 1) }) }
+
+			task := &agentendpointpb.ApplyConfigTask{OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1")}}
+			if err := tc.client.RunApplyConfig(ctx, &agentendpointpb.Task{TaskDetails: &agentendpointpb.Task_ApplyConfigTask{ApplyConfigTask: task}}); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, srv.lastReportTaskCompleteRequest, protocmp.Transform()); diff != "" {
+				t.Fatalf("ReportTaskCompleteRequest mismatch (-want +got):\n%s", diff)
+			}
+			if tc.client.pending != 0 {
+				t.Errorf("pending = %d, want 0", tc.client.pending)
+			}
+		})
+	}
+}
+
+// concurrencyResource records how many of its checks and enforcements run
+// at once.
+type concurrencyResource struct {
+	testResource
+	active, max *int32
+}
+
+func (r *concurrencyResource) track() {
+	n := atomic.AddInt32(r.active, 1)
+	defer atomic.AddInt32(r.active, -1)
+	for {
+		m := atomic.LoadInt32(r.max)
+		if n <= m || atomic.CompareAndSwapInt32(r.max, m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func (r *concurrencyResource) CheckState(ctx context.Context) error {
+	r.track()
+	return nil
+}
+
+func (r *concurrencyResource) EnforceState(ctx context.Context) error {
+	r.track()
+	return nil
+}
+
+func TestRunApplyConfigSerialized(t *testing.T) {
+	ctx := context.Background()
+	sameStateTimeWindow = 0
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	effectivePoliciesFile = filepath.Join(td, "effective_policies.json")
+	var active, max int32
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(&concurrencyResource{testResource: testResource{steps: 5}, active: &active, max: &max})}
+	}
+
+	srv := &agentEndpointServiceConfigTestServer{
+		progressError:  make(chan struct{}, 20),
+		progressCancel: make(chan struct{}, 20),
+	}
+	tc, err := newTestClient(ctx, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.close()
+
+	// Two tasks started outside of the tasker still run one at a time.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			task := &agentendpointpb.ApplyConfigTask{OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1")}}
+			if err := tc.client.RunApplyConfig(ctx, &agentendpointpb.Task{TaskId: fmt.Sprint(i), TaskDetails: &agentendpointpb.Task_ApplyConfigTask{ApplyConfigTask: task}}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if max != 1 {
+		t.Errorf("%d resource checks or enforcements ran at once, want 1", max)
+	}
+}
+
+func TestRunApplyConfigChangeFreeze(t *testing.T) {
+	ctx := context.Background()
+	sameStateTimeWindow = 0
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	driftStateFile := filepath.Join(td, "drift.state")
+	effectivePoliciesFile = filepath.Join(td, "effective_policies.json")
+	res := &testResource{steps: 5}
+	newResource = func(r *agentendpointpb.OSPolicy_Resource) *resource {
+		return &resource{resourceIface: resourceIface(res)}
+	}
+	defer func() { freezeCheck = changefreeze.Check }()
+	freezeCheck = func(context.Context) error { return errors.New("change freeze in effect") }
+
+	srv := &agentEndpointServiceConfigTestServer{
+		progressError:  make(chan struct{}, 5),
+		progressCancel: make(chan struct{}, 5),
+	}
+	tc, err := newTestClient(ctx, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.close()
+	tc.client.driftStateFile = driftStateFile
+
+	task := &agentendpointpb.ApplyConfigTask{OsPolicies: []*agentendpointpb.ApplyConfigTask_OSPolicy{genTestPolicy("p1")}}
+	if err := tc.client.RunApplyConfig(ctx, &agentendpointpb.Task{TaskDetails: &agentendpointpb.Task_ApplyConfigTask{ApplyConfigTask: task}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The resource is checked and reported but not enforced.
+	want := configOutputGen("", agentendpointpb.ApplyConfigTaskOutput_SUCCEEDED,
+		[]*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{
+			{
+				OsPolicyId: "p1",
+				OsPolicyResourceCompliances: []*agentendpointpb.OSPolicyResourceCompliance{
+					{
+						State:              agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT,
+						OsPolicyResourceId: "r1",
+						ConfigSteps: []*agentendpointpb.OSPolicyResourceConfigStep{
+							{
+								Type:    agentendpointpb.OSPolicyResourceConfigStep_VALIDATION,
+								Outcome: agentendpointpb.OSPolicyResourceConfigStep_SUCCEEDED,
+							},
+							{
+								Type:    agentendpointpb.OSPolicyResourceConfigStep_DESIRED_STATE_CHECK,
+								Outcome: agentendpointpb.OSPolicyResourceConfigStep_SUCCEEDED,
+							},
+						},
+					},
+				},
+			},
+		},
+	)
+	if diff := cmp.Diff(want, srv.lastReportTaskCompleteRequest, protocmp.Transform()); diff != "" {
+		t.Fatalf("ReportTaskCompleteRequest mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFailureMessage(t *testing.T) {
+	if got := failureMessage(errTest); got != errTest.Error() {
+		t.Errorf("failureMessage(%v) = %q, want %q", errTest, got, errTest.Error())
+	}
+
+	cerr := &packages.CommandError{Cmd: "apt-get", ExitCode: 100, Stderr: []byte("E: Unable to locate package nginx2\n"), Kind: packages.ErrorNotFound, Err: errTest}
+	got := failureMessage(fmt.Errorf("error installing apt package %q: %w", "nginx2", cerr))
+	want := `not_found failure (exit code 100): error installing apt package "nginx2": `
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("failureMessage() = %q, want prefix %q", got, want)
+	}
+	if n := strings.Count(got, "Unable to locate package"); n != 1 {
+		t.Errorf("failureMessage() = %q, want the command output once, got it %d times", got, n)
+	}
+
+	// The excerpt is added when the error does not carry the output.
+	got = failureMessage(&summaryError{cerr})
+	want = `not_found failure (exit code 100): E: Unable to locate package nginx2: apt-get failed`
+	if got != want {
+		t.Errorf("failureMessage() = %q, want %q", got, want)
+	}
+}
+
+type summaryError struct {
+	err error
+}
+
+func (e *summaryError) Error() string { return "apt-get failed" }
+func (e *summaryError) Unwrap() error { return e.err }
+
+type readOnlyResource struct {
+	testResource
+	enforced bool
+}
+
+func (r *readOnlyResource) EnforceState(ctx context.Context) error {
+	r.enforced = true
+	return &util.ReadOnlyFilesystemError{Path: "/usr"}
+}
+
+func (r *readOnlyResource) NotEnforceable() error {
+	return &util.ReadOnlyFilesystemError{Path: "/usr"}
+}
+
+type erofsResource struct {
+	testResource
+}
+
+func (r *erofsResource) EnforceState(ctx context.Context) error {
+	return &os.PathError{Op: "open", Path: "/etc/foo", Err: syscall.EROFS}
+}
+
+func TestEnforceConfigResourceStateReadOnly(t *testing.T) {
+	// A write that fails with EROFS although the probe found nothing.
+	rCompliance := &agentendpointpb.OSPolicyResourceCompliance{OsPolicyResourceId: "file"}
+	actionTaken, hasError := enforceConfigResourceState(context.Background(), &resource{resourceIface: &erofsResource{}}, rCompliance, &agentendpointpb.OSPolicy_Resource{Id: "file"})
+	if actionTaken || !hasError {
+		t.Errorf("enforceConfigResourceState() = %t, %t, want false, true", actionTaken, hasError)
+	}
+	want := `Enforce state: resource "file" cannot be enforced on this platform: open /etc/foo: read-only file system`
+	if steps := rCompliance.GetConfigSteps(); len(steps) != 1 || steps[0].GetErrorMessage() != want {
+		t.Errorf("config steps = %v, want one step with message %q", steps, want)
+	}
+
+	// A resource the probe found on a read-only root is not enforced.
+	ro := &readOnlyResource{}
+	res := &resource{resourceIface: ro}
+	rCompliance = &agentendpointpb.OSPolicyResourceCompliance{OsPolicyResourceId: "pkg"}
+	actionTaken, hasError = enforceConfigResourceState(context.Background(), res, rCompliance, &agentendpointpb.OSPolicy_Resource{Id: "pkg"})
+	if actionTaken || !hasError {
+		t.Errorf("enforceConfigResourceState() = %t, %t, want false, true", actionTaken, hasError)
+	}
+	if ro.enforced {
+		t.Error("EnforceState called on a resource that is not enforceable")
+	}
+	if rCompliance.GetState() != agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT {
+		t.Errorf("compliance state = %s, want %s", rCompliance.GetState(), agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT)
+	}
+	want = `Enforce state: resource "pkg" skipped, it cannot be enforced on this system: /usr is on a read-only filesystem`
+	if steps := rCompliance.GetConfigSteps(); len(steps) != 1 || steps[0].GetErrorMessage() != want {
+		t.Errorf("config steps = %v, want one step with message %q", steps, want)
+	}
+
+	c := &configTask{
+		policies: map[string]*policy{"p": {resources: map[string]*resource{"pkg": res}}},
+		results: []*agentendpointpb.ApplyConfigTaskOutput_OSPolicyResult{{
+			OsPolicyId:                  "p",
+			OsPolicyResourceCompliances: []*agentendpointpb.OSPolicyResourceCompliance{rCompliance},
+		}},
+	}
+	e := &effectivePolicies{Policies: []*effectivePolicy{{ID: "p", Resources: []*effectiveResource{{ID: "pkg"}}}}}
+	c.setResults(e)
+	if got := e.Policies[0].Resources[0].State; got != notEnforceableState {
+		t.Errorf("effective resource state = %q, want %q", got, notEnforceableState)
+	}
+}
@@ -0,0 +1,161 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// resourceDrift tracks how often a resource has been found out of its desired
+// state after previously being compliant.
+type resourceDrift struct {
+	OSPolicyAssignment string
+	OSPolicyID         string
+	ResourceID         string
+	LastState          string
+	// Checks is the number of pre-enforcement state checks recorded.
+	Checks int64
+	// Drifts is the number of COMPLIANT to NON_COMPLIANT transitions.
+	Drifts    int64
+	LastDrift time.Time `json:",omitempty"`
+}
+
+// driftTracker persists drift counters between ApplyConfigTask runs.
+type driftTracker struct {
+	Resources map[string]*resourceDrift
+	seen      map[string]bool
+	// path is the state file, the counters are not persisted if it is empty.
+	path string
+}
+
+// driftKey identifies a resource across runs, the counters of a resource are
+// kept when its assignment gets a new revision.
+func driftKey(assignment, policyID, resourceID string) string {
+	return assignmentWithoutRevision(assignment) + "/" + policyID + "/" + resourceID
+}
+
+func loadDriftTracker(ctx context.Context, path string) *driftTracker {
+	d := &driftTracker{Resources: map[string]*resourceDrift{}, seen: map[string]bool{}, path: path}
+	if path == "" {
+		return d
+	}
+	data, err := integrity.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			clog.Warningf(ctx, "Error reading drift state file, resetting drift counters: %v", err)
+		}
+		return d
+	}
+	if err := json.Unmarshal(data, d); err != nil {
+		clog.Warningf(ctx, "Error parsing drift state file, resetting drift counters: %v", err)
+		return &driftTracker{Resources: map[string]*resourceDrift{}, seen: map[string]bool{}, path: path}
+	}
+	if d.Resources == nil {
+		d.Resources = map[string]*resourceDrift{}
+	}
+	return d
+}
+
+// recordCheck records the pre-enforcement state of a resource and reports
+// whether the resource drifted out of compliance since the last run.
+func (d *driftTracker) recordCheck(assignment, policyID, resourceID string, state agentendpointpb.OSPolicyComplianceState) bool {
+	key := driftKey(assignment, policyID, resourceID)
+	d.seen[key] = true
+	r, ok := d.Resources[key]
+	if !ok {
+		r = &resourceDrift{OSPolicyID: policyID, ResourceID: resourceID}
+		d.Resources[key] = r
+	}
+	r.OSPolicyAssignment = assignment
+	r.Checks++
+
+	drifted := r.LastState == agentendpointpb.OSPolicyComplianceState_COMPLIANT.String() && state == agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+	if drifted {
+		r.Drifts++
+		r.LastDrift = time.Now().UTC()
+	}
+	r.LastState = state.String()
+	return drifted
+}
+
+// recordFinal records the final state of a resource at the end of a run, this
+// is the state that the next run is compared against. Resources that failed
+// or were not reached in this run keep their counters and last state.
+func (d *driftTracker) recordFinal(assignment, policyID, resourceID string, state agentendpointpb.OSPolicyComplianceState) {
+	key := driftKey(assignment, policyID, resourceID)
+	d.seen[key] = true
+	r, ok := d.Resources[key]
+	if !ok || state == agentendpointpb.OSPolicyComplianceState_UNKNOWN {
+		return
+	}
+	r.LastState = state.String()
+}
+
+// counters returns the drift counters of a resource, nil if it has none.
+func (d *driftTracker) counters(assignment, policyID, resourceID string) *resourceDrift {
+	if d == nil {
+		return nil
+	}
+	return d.Resources[driftKey(assignment, policyID, resourceID)]
+}
+
+// save writes the drift state, dropping any resources that were not part of
+// this run.
+func (d *driftTracker) save() error {
+	if d.path == "" {
+		return nil
+	}
+	for k := range d.Resources {
+		if !d.seen[k] {
+			delete(d.Resources, k)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return integrity.WriteFile(d.path, data, 0600)
+}
+
+// report logs the drift counters for each resource that has drifted, in key
+// order, the structured payload can be used to build log based metrics.
+func (d *driftTracker) report(ctx context.Context) {
+	keys := make([]string, 0, len(d.Resources))
+	for k := range d.Resources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		r := d.Resources[k]
+		if !d.seen[k] || r.Drifts == 0 {
+			continue
+		}
+		ctx := clog.WithLabels(ctx, map[string]string{"os_policy_assignment": r.OSPolicyAssignment, "os_policy_id": r.OSPolicyID, "resource_id": r.ResourceID})
+		clog.InfoStructured(ctx, r, "Resource %q has drifted out of compliance %d times in %d checks, last drift at %s.", r.ResourceID, r.Drifts, r.Checks, r.LastDrift.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,130 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestDriftTracker(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	path := filepath.Join(td, "drift.state")
+
+	compliant := agentendpointpb.OSPolicyComplianceState_COMPLIANT
+	nonCompliant := agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+
+	// Each run: pre-enforcement check state and final state.
+	runs := []struct {
+		check, final agentendpointpb.OSPolicyComplianceState
+		wantDrift    bool
+	}{
+		{nonCompliant, compliant, false},
+		{compliant, compliant, false},
+		{nonCompliant, compliant, true},
+		{nonCompliant, nonCompliant, true},
+		{nonCompliant, compliant, false},
+	}
+	for i, r := range runs {
+		d := loadDriftTracker(ctx, path)
+		if got := d.recordCheck("a1", "p1", "r1", r.check); got != r.wantDrift {
+			t.Errorf("run %d: recordCheck() = %t, want %t", i, got, r.wantDrift)
+		}
+		d.recordFinal("a1", "p1", "r1", r.final)
+		if err := d.save(); err != nil {
+			t.Fatalf("run %d: save() error: %v", i, err)
+		}
+	}
+
+	d := loadDriftTracker(ctx, path)
+	got, ok := d.Resources[driftKey("a1", "p1", "r1")]
+	if !ok {
+		t.Fatal("resource missing from drift state")
+	}
+	if got.Drifts != 2 || got.Checks != int64(len(runs)) {
+		t.Errorf("unexpected counters, Drifts: %d, Checks: %d", got.Drifts, got.Checks)
+	}
+
+	// A resource whose check failed keeps its counters.
+	d.recordFinal("a1", "p1", "r1", agentendpointpb.OSPolicyComplianceState_UNKNOWN)
+	if err := d.save(); err != nil {
+		t.Fatalf("save() error: %v", err)
+	}
+	d = loadDriftTracker(ctx, path)
+	if got := d.counters("a1", "p1", "r1"); got == nil || got.Drifts != 2 || got.LastState != compliant.String() {
+		t.Errorf("counters after a failed check = %+v, want Drifts: 2, LastState: %s", got, compliant)
+	}
+
+	// Resources not part of a run are pruned on save.
+	d.recordCheck("a1", "p1", "r2", compliant)
+	if err := d.save(); err != nil {
+		t.Fatalf("save() error: %v", err)
+	}
+	d = loadDriftTracker(ctx, path)
+	if _, ok := d.Resources[driftKey("a1", "p1", "r1")]; ok {
+		t.Error("expected stale resource to be pruned")
+	}
+	if _, ok := d.Resources[driftKey("a1", "p1", "r2")]; !ok {
+		t.Error("expected resource r2 in drift state")
+	}
+
+	// Without a state file nothing is persisted.
+	d = loadDriftTracker(ctx, "")
+	d.recordCheck("a1", "p1", "r1", compliant)
+	if err := d.save(); err != nil {
+		t.Fatalf("save() error: %v", err)
+	}
+}
+
+func TestDriftTrackerRevisionBump(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "drift.state")
+	compliant := agentendpointpb.OSPolicyComplianceState_COMPLIANT
+	nonCompliant := agentendpointpb.OSPolicyComplianceState_NON_COMPLIANT
+	const assignment = "projects/1/locations/us-central1-a/osPolicyAssignments/a1"
+
+	for i, rev := range []string{"@rev1", "@rev2"} {
+		d := loadDriftTracker(ctx, path)
+		check := compliant
+		if i > 0 {
+			check = nonCompliant
+		}
+		d.recordCheck(assignment+rev, "p1", "r1", check)
+		d.recordFinal(assignment+rev, "p1", "r1", compliant)
+		if err := d.save(); err != nil {
+			t.Fatalf("save() error: %v", err)
+		}
+	}
+
+	// The counters survive the new revision and the drift is counted.
+	d := loadDriftTracker(ctx, path)
+	got := d.counters(assignment+"@rev2", "p1", "r1")
+	if got == nil || got.Checks != 2 || got.Drifts != 1 {
+		t.Fatalf("counters after a revision bump = %+v, want Checks: 2, Drifts: 1", got)
+	}
+	if got.OSPolicyAssignment != assignment+"@rev2" {
+		t.Errorf("OSPolicyAssignment = %q, want the latest revision", got.OSPolicyAssignment)
+	}
+}
@@ -0,0 +1,436 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	"google.golang.org/protobuf/proto"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// The effective policy file lists the OS policies the agent was last asked to
+// apply so that operators can see what is assigned to an instance without
+// read access to the OS Config API. It is rewritten after each apply.
+
+var effectivePoliciesFile = filepath.Join(agentconfig.CacheDir(), "osconfig_effective_policies.json")
+
+// notEnforceableState is the local compliance state of a non compliant
+// resource that cannot be enforced on this system, for example because it
+// writes to a read-only root filesystem.
+const notEnforceableState = "NOT_ENFORCEABLE"
+
+// pendingRebootState is the local compliance state of a non compliant
+// resource whose desired state is already enforced in a snapshot that
+// becomes active on the next boot.
+const pendingRebootState = "PENDING_REBOOT"
+
+type effectivePolicies struct {
+	TaskID    string             `json:"taskId,omitempty"`
+	AppliedAt time.Time          `json:"appliedAt"`
+	Policies  []*effectivePolicy `json:"policies"`
+}
+
+type effectivePolicy struct {
+	ID string `json:"id"`
+	// Assignment is the full OS policy assignment name the policy comes from,
+	// Revision is the assignment revision id taken from it.
+	Assignment string               `json:"assignment"`
+	Revision   string               `json:"revision,omitempty"`
+	Mode       string               `json:"mode"`
+	Resources  []*effectiveResource `json:"resources"`
+}
+
+type effectiveResource struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Hash is a sha256 of the resource definition, it changes whenever the
+	// resource is edited even if the assignment revision does not.
+	Hash string `json:"hash"`
+
+	// The package or repository the resource manages, guest policies use
+	// these to detect conflicts with OS policies. Repository URLs are
+	// recorded without credentials.
+	Package       string `json:"package,omitempty"`
+	Manager       string `json:"manager,omitempty"`
+	DesiredState  string `json:"desiredState,omitempty"`
+	Repository    string `json:"repository,omitempty"`
+	RepositoryURL string `json:"repositoryUrl,omitempty"`
+	Components    string `json:"components,omitempty"`
+
+	// Paths are the files the resource writes, State is its compliance state
+	// at the end of the run, notEnforceableState for a non compliant
+	// resource that cannot be enforced on this system, or pendingRebootState
+	// for one that takes effect on the next boot.
+	Paths []string `json:"paths,omitempty"`
+	State string   `json:"state,omitempty"`
+	// EnforcedAt is when the agent last successfully enforced the resource,
+	// carried over from earlier runs while the resource is unchanged.
+	EnforcedAt *time.Time `json:"enforcedAt,omitempty"`
+}
+
+// setManaged sets the package or repository r manages on e. Only packages
+// installed by name and repositories with an id are recorded, apt
+// repositories are identified by their archive type and distribution.
+func (e *effectiveResource) setManaged(r *agentendpointpb.OSPolicy_Resource) {
+	if pkg := r.GetPkg(); pkg != nil {
+		switch {
+		case pkg.GetApt() != nil:
+			e.Manager, e.Package = "apt", pkg.GetApt().GetName()
+		case pkg.GetYum() != nil:
+			e.Manager, e.Package = "yum", pkg.GetYum().GetName()
+		case pkg.GetZypper() != nil:
+			e.Manager, e.Package = "zypper", pkg.GetZypper().GetName()
+		case pkg.GetGooget() != nil:
+			e.Manager, e.Package = "googet", pkg.GetGooget().GetName()
+		default:
+			return
+		}
+		e.DesiredState = pkg.GetDesiredState().String()
+		return
+	}
+	repo := r.GetRepository()
+	switch {
+	case repo.GetApt() != nil:
+		apt := repo.GetApt()
+		archive := "deb"
+		if apt.GetArchiveType() == agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository_DEB_SRC {
+			archive = "deb-src"
+		}
+		e.Manager, e.Repository, e.RepositoryURL = "apt", archive+" "+apt.GetDistribution(), apt.GetUri()
+		e.Components = strings.Join(apt.GetComponents(), " ")
+	case repo.GetYum() != nil:
+		e.Manager, e.Repository, e.RepositoryURL = "yum", repo.GetYum().GetId(), repo.GetYum().GetBaseUrl()
+	case repo.GetZypper() != nil:
+		e.Manager, e.Repository, e.RepositoryURL = "zypper", repo.GetZypper().GetId(), repo.GetZypper().GetBaseUrl()
+	case repo.GetGoo() != nil:
+		e.Manager, e.Repository, e.RepositoryURL = "googet", repo.GetGoo().GetName(), repo.GetGoo().GetUrl()
+	}
+	e.RepositoryURL = util.StripURLCredentials(e.RepositoryURL)
+}
+
+func assignmentRevision(assignment string) string {
+	if i := strings.LastIndex(assignment, "@"); i != -1 {
+		return assignment[i+1:]
+	}
+	return ""
+}
+
+// assignmentWithoutRevision returns the assignment name without its
+// revision, it is the same across revisions of an assignment.
+func assignmentWithoutRevision(assignment string) string {
+	if i := strings.LastIndex(assignment, "@"); i != -1 {
+		return assignment[:i]
+	}
+	return assignment
+}
+
+func resourceType(r *agentendpointpb.OSPolicy_Resource) string {
+	switch {
+	case r.GetPkg() != nil:
+		return "pkg"
+	case r.GetRepository() != nil:
+		return "repository"
+	case r.GetExec() != nil:
+		return "exec"
+	case r.GetFile() != nil:
+		return "file"
+	}
+	return "unknown"
+}
+
+func resourceHash(r *agentendpointpb.OSPolicy_Resource) string {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(r)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func newEffectivePolicies(taskID string, osPolicies []*agentendpointpb.ApplyConfigTask_OSPolicy, now time.Time) *effectivePolicies {
+	e := &effectivePolicies{TaskID: taskID, AppliedAt: now.UTC(), Policies: []*effectivePolicy{}}
+	for _, osPolicy := range osPolicies {
+		p := &effectivePolicy{
+			ID:         osPolicy.GetId(),
+			Assignment: osPolicy.GetOsPolicyAssignment(),
+			Revision:   assignmentRevision(osPolicy.GetOsPolicyAssignment()),
+			Mode:       osPolicy.GetMode().String(),
+			Resources:  []*effectiveResource{},
+		}
+		for _, r := range osPolicy.GetResources() {
+			er := &effectiveResource{ID: r.GetId(), Type: resourceType(r), Hash: resourceHash(r)}
+			er.setManaged(r)
+			p.Resources = append(p.Resources, er)
+		}
+		e.Policies = append(e.Policies, p)
+	}
+	return e
+}
+
+// writeEffectivePolicies writes the policies of this task to the effective
+// policy file, an empty task clears the previous set.
+func (c *configTask) writeEffectivePolicies(ctx context.Context) {
+	e := newEffectivePolicies(c.TaskID, c.Task.GetOsPolicies(), time.Now())
+	c.setResults(e)
+	if prev, err := readEffectivePolicies(); err == nil {
+		carryEnforcedAt(e, prev)
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		clog.Warningf(ctx, "Error marshaling effective policies: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(effectivePoliciesFile), 0755); err != nil {
+		clog.Warningf(ctx, "Error writing effective policy file: %v", err)
+		return
+	}
+	// The file holds no resource contents and repository URLs are written
+	// without credentials, it is still only readable by root.
+	if err := util.AtomicWrite(effectivePoliciesFile, data, 0600); err != nil {
+		clog.Warningf(ctx, "Error writing effective policy file: %v", err)
+	}
+}
+
+// setResults sets the files each resource writes, as resolved when it was
+// validated, and its final compliance state on e.
+func (c *configTask) setResults(e *effectivePolicies) {
+	for i, p := range e.Policies {
+		states := map[string]string{}
+		if i < len(c.results) {
+			for _, rCompliance := range c.results[i].GetOsPolicyResourceCompliances() {
+				states[rCompliance.GetOsPolicyResourceId()] = rCompliance.GetState().String()
+			}
+		}
+		plcy := c.policies[p.ID]
+		for _, r := range p.Resources {
+			r.State = states[r.ID]
+			if plcy == nil {
+				continue
+			}
+			res, ok := plcy.resources[r.ID]
+			if !ok {
+				continue
+			}
+			if !res.enforcedAt.IsZero() {
+				t := res.enforcedAt.UTC()
+				r.EnforcedAt = &t
+			}
+			if r.State != agentendpointpb.OSPolicyComplianceState_COMPLIANT.String() {
+				if res.NotEnforceable() != nil {
+					r.State = notEnforceableState
+				}
+				if pr, ok := res.resourceIface.(interface{ PendingReboot() string }); ok && pr.PendingReboot() != "" {
+					r.State = pendingRebootState
+				}
+			}
+			mr := res.ManagedResources()
+			if mr == nil {
+				continue
+			}
+			for _, f := range mr.Files {
+				r.Paths = append(r.Paths, f.Path)
+				if r.DesiredState == "" {
+					r.DesiredState = f.State.String()
+				}
+			}
+			for _, repo := range mr.Repositories {
+				if repo.RepoFilePath != "" {
+					r.Paths = append(r.Paths, repo.RepoFilePath)
+				}
+			}
+		}
+	}
+}
+
+// carryEnforcedAt sets the enforcement time of resources not enforced in
+// this run from prev, if their definition has not changed since. Resources
+// are matched across assignment revisions, the hash tells whether they were
+// edited.
+func carryEnforcedAt(e, prev *effectivePolicies) {
+	key := func(p *effectivePolicy, r *effectiveResource) string {
+		return assignmentWithoutRevision(p.Assignment) + "/" + p.ID + "/" + r.ID
+	}
+	enforced := map[string]*effectiveResource{}
+	for _, p := range prev.Policies {
+		for _, r := range p.Resources {
+			if r.EnforcedAt != nil {
+				enforced[key(p, r)] = r
+			}
+		}
+	}
+	for _, p := range e.Policies {
+		for _, r := range p.Resources {
+			if old, ok := enforced[key(p, r)]; ok && r.EnforcedAt == nil && old.Hash == r.Hash {
+				r.EnforcedAt = old.EnforcedAt
+			}
+		}
+	}
+}
+
+func readEffectivePolicies() (*effectivePolicies, error) {
+	data, err := os.ReadFile(effectivePoliciesFile)
+	if err != nil {
+		return nil, err
+	}
+	var e effectivePolicies
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("error parsing effective policy file: %v", err)
+	}
+	return &e, nil
+}
+
+// ManagedResource is a package or repository managed by an OS policy in
+// enforcement mode.
+type ManagedResource struct {
+	Assignment string
+	PolicyID   string
+	ResourceID string
+	// Manager is apt, yum, zypper or googet.
+	Manager string
+	// Package and DesiredState are set for packages, DesiredState is
+	// INSTALLED or REMOVED.
+	Package      string
+	DesiredState string
+	// Repository is the repository id, the name for googet or the archive
+	// type and distribution for apt, such as "deb bookworm". RepositoryURL
+	// is its base URL without credentials, Components are the apt
+	// components.
+	Repository    string
+	RepositoryURL string
+	Components    string
+}
+
+// ManagedResources returns the packages and repositories managed by the OS
+// policies in enforcement mode the agent last applied, read from the
+// effective policy file. It returns nil if no OS policies were applied.
+func ManagedResources() ([]*ManagedResource, error) {
+	e, err := readEffectivePolicies()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var managed []*ManagedResource
+	for _, p := range e.Policies {
+		if p.Mode != agentendpointpb.OSPolicy_ENFORCEMENT.String() {
+			continue
+		}
+		for _, r := range p.Resources {
+			if r.Package == "" && r.Repository == "" {
+				continue
+			}
+			managed = append(managed, &ManagedResource{
+				Assignment:    p.Assignment,
+				PolicyID:      p.ID,
+				ResourceID:    r.ID,
+				Manager:       r.Manager,
+				Package:       r.Package,
+				DesiredState:  r.DesiredState,
+				Repository:    r.Repository,
+				RepositoryURL: r.RepositoryURL,
+				Components:    r.Components,
+			})
+		}
+	}
+	return managed, nil
+}
+
+// Explanation is an OS policy resource that manages a file or package.
+type Explanation struct {
+	Assignment string `json:"assignment"`
+	PolicyID   string `json:"policyId"`
+	ResourceID string `json:"resourceId"`
+	Type       string `json:"type"`
+	Mode       string `json:"mode"`
+	// Manager and Package are set for packages, Path for files.
+	Manager      string `json:"manager,omitempty"`
+	Package      string `json:"package,omitempty"`
+	Path         string `json:"path,omitempty"`
+	DesiredState string `json:"desiredState,omitempty"`
+	// State is the compliance state of the resource at the end of the last
+	// run, CheckedAt is when that run finished. AppliedAt is when the agent
+	// last enforced the resource, unset if it never had to.
+	State     string     `json:"state,omitempty"`
+	CheckedAt time.Time  `json:"checkedAt"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+func samePath(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Clean(a), filepath.Clean(b))
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
+}
+
+// Explain returns the OS policy resources, in any mode, that manage the
+// package named target or the file at the absolute path target, read from
+// the effective policy file. It returns nil if no OS policies were applied.
+func Explain(target string) ([]*Explanation, error) {
+	e, err := readEffectivePolicies()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	isPath := filepath.IsAbs(target)
+	var found []*Explanation
+	for _, p := range e.Policies {
+		for _, r := range p.Resources {
+			x := &Explanation{
+				Assignment:   p.Assignment,
+				PolicyID:     p.ID,
+				ResourceID:   r.ID,
+				Type:         r.Type,
+				Mode:         p.Mode,
+				DesiredState: r.DesiredState,
+				State:        r.State,
+				CheckedAt:    e.AppliedAt,
+				AppliedAt:    r.EnforcedAt,
+			}
+			if !isPath && r.Package == target {
+				x.Manager, x.Package = r.Manager, r.Package
+				found = append(found, x)
+				continue
+			}
+			if !isPath {
+				continue
+			}
+			for _, path := range r.Paths {
+				if samePath(path, target) {
+					x.Path = path
+					found = append(found, x)
+					break
+				}
+			}
+		}
+	}
+	return found, nil
+}
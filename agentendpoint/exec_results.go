@@ -0,0 +1,108 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/attributes"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// Exec resource results are written to guest attributes after each
+// ApplyConfigTask, the task report has no field for them.
+
+var (
+	execResultsURL = agentconfig.ReportURL + "/osconfig/exec_results"
+	// execResultsMaxSize bounds the size of the results value in bytes.
+	execResultsMaxSize = 16 * 1024
+
+	postExecResults = attributes.PostAttribute
+
+	execResultsMx   sync.Mutex
+	lastExecResults []byte
+)
+
+type execResultsReport struct {
+	// Resources is keyed by "<assignment>/<policy id>/<resource id>", see
+	// beaconPolicyKey.
+	Resources map[string]map[string]string `json:"resources"`
+	// Omitted is the number of resources left out to bound the size.
+	Omitted int `json:"omitted,omitempty"`
+}
+
+// marshal encodes the report, dropping resources in key order until it fits
+// in max bytes.
+func (r *execResultsReport) marshal(max int) ([]byte, error) {
+	keys := make([]string, 0, len(r.Resources))
+	for k := range r.Resources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := *r
+	for n := len(keys); ; n-- {
+		out.Resources = make(map[string]map[string]string, n)
+		for _, k := range keys[:n] {
+			out.Resources[k] = r.Resources[k]
+		}
+		out.Omitted = len(keys) - n
+		data, err := json.Marshal(out)
+		if err != nil || len(data) <= max || n == 0 {
+			return data, err
+		}
+	}
+}
+
+// publishExecResults writes the results reported by exec resource scripts in
+// this run to guest attributes. Unchanged results are not written again.
+func (c *configTask) publishExecResults(ctx context.Context) {
+	r := &execResultsReport{Resources: map[string]map[string]string{}}
+	for i, osPolicy := range c.Task.GetOsPolicies() {
+		for _, rCompliance := range c.results[i].GetOsPolicyResourceCompliances() {
+			results := c.resourceResults(osPolicy.GetId(), rCompliance.GetOsPolicyResourceId())
+			if len(results) == 0 {
+				continue
+			}
+			key := beaconPolicyKey(osPolicy.GetOsPolicyAssignment(), osPolicy.GetId()) + "/" + rCompliance.GetOsPolicyResourceId()
+			r.Resources[key] = results
+		}
+	}
+
+	execResultsMx.Lock()
+	defer execResultsMx.Unlock()
+	// Nothing to report and nothing to clear.
+	if len(r.Resources) == 0 && lastExecResults == nil {
+		return
+	}
+	data, err := r.marshal(execResultsMaxSize)
+	if err != nil {
+		clog.Errorf(ctx, "Error encoding exec resource results: %v", err)
+		return
+	}
+	if bytes.Equal(data, lastExecResults) {
+		return
+	}
+	if err := postExecResults(execResultsURL, bytes.NewReader(data)); err != nil {
+		clog.Warningf(ctx, "Error writing exec resource results: %v", err)
+		return
+	}
+	lastExecResults = data
+}
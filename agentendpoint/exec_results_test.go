@@ -0,0 +1,110 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+type resultsResource struct {
+	resourceIface
+	results map[string]string
+}
+
+func (r *resultsResource) Results() map[string]string { return r.results }
+
+func TestPublishExecResults(t *testing.T) {
+	ctx := context.Background()
+	var posted []*execResultsReport
+	defer func(p func(string, io.Reader) error) {
+		postExecResults, lastExecResults = p, nil
+	}(postExecResults)
+	postExecResults = func(url string, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		rep := &execResultsReport{}
+		if err := json.Unmarshal(data, rep); err != nil {
+			return err
+		}
+		posted = append(posted, rep)
+		return nil
+	}
+	lastExecResults = nil
+
+	task := func(results map[string]string) *configTask {
+		c := beaconTask(agentendpointpb.OSPolicyComplianceState_COMPLIANT)
+		c.policies = map[string]*policy{"a": {resources: map[string]*resource{
+			"r": {resourceIface: &resultsResource{results: results}},
+		}}}
+		return c
+	}
+
+	// No results are never written.
+	task(nil).publishExecResults(ctx)
+	if len(posted) != 0 {
+		t.Fatalf("posted %d reports without results, want 0", len(posted))
+	}
+
+	task(map[string]string{"version": "1.2"}).publishExecResults(ctx)
+	if len(posted) != 1 {
+		t.Fatalf("posted %d reports, want 1", len(posted))
+	}
+	if got := posted[0].Resources["assign/a/r"]["version"]; got != "1.2" {
+		t.Errorf("reported version = %q, want 1.2", got)
+	}
+
+	// Unchanged results are not written again.
+	task(map[string]string{"version": "1.2"}).publishExecResults(ctx)
+	if len(posted) != 1 {
+		t.Fatalf("posted %d reports for unchanged results, want 1", len(posted))
+	}
+
+	// Results that are gone are cleared.
+	task(nil).publishExecResults(ctx)
+	if len(posted) != 2 || len(posted[1].Resources) != 0 {
+		t.Fatalf("posted %+v, want the results to be cleared", posted)
+	}
+}
+
+func TestExecResultsSize(t *testing.T) {
+	r := &execResultsReport{Resources: map[string]map[string]string{
+		"a/p/r1": {"k": strings.Repeat("x", 100)},
+		"a/p/r2": {"k": strings.Repeat("y", 100)},
+	}}
+	full, err := r.marshal(execResultsMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := r.marshal(len(full) - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &execResultsReport{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Omitted != 1 || len(got.Resources) != 1 || got.Resources["a/p/r1"] == nil {
+		t.Errorf("marshal() = %s, want a/p/r1 kept and one resource omitted", data)
+	}
+}
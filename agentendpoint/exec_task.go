@@ -15,6 +15,7 @@
 package agentendpoint
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -28,6 +29,7 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/external"
+	"github.com/GoogleCloudPlatform/osconfig/upload"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
@@ -90,7 +92,7 @@ func executeCommand(ctx context.Context, path string, args []string) (int32, err
 	var exitCode int32
 	if cmd.ProcessState != nil {
 		exitCode = int32(cmd.ProcessState.ExitCode())
-		clog.Infof(ctx, "Command exit code: %d, out:\n%s", exitCode, out)
+		clog.Infof(ctx, "Command exit code: %d, out:\n%s", exitCode, logExecOutput(ctx, path, out))
 	}
 	if err != nil {
 		if _, ok := err.(*exec.ExitError); !ok {
@@ -101,6 +103,24 @@ func executeCommand(ctx context.Context, path string, args []string) (int32, err
 	return exitCode, nil
 }
 
+// maxLoggedExecOutput is the max size of exec output that is logged, larger
+// outputs are uploaded to the upload bucket if one is configured.
+var maxLoggedExecOutput = 64 * 1024
+
+// logExecOutput returns out for logging, outputs over maxLoggedExecOutput
+// are truncated and the full output is uploaded.
+func logExecOutput(ctx context.Context, path string, out []byte) []byte {
+	if len(out) <= maxLoggedExecOutput || !upload.Enabled() {
+		return out
+	}
+	url, err := upload.Upload(ctx, upload.ExecOutput, filepath.Base(path)+".log", bytes.NewReader(out))
+	if err != nil {
+		clog.Warningf(ctx, "Error uploading exec output: %v", err)
+		return out
+	}
+	return append(out[:maxLoggedExecOutput:maxLoggedExecOutput], fmt.Sprintf("\n[truncated, full output uploaded to %s]", url)...)
+}
+
 type execTask struct {
 	StartedAt time.Time `json:",omitempty"`
 	client    *Client
@@ -161,6 +181,17 @@ func (e *execTask) run(ctx context.Context) error {
 		})
 	}
 
+	// The step is not run during a change freeze. There is no skipped
+	// state, the step is reported as cancelled before it started with the
+	// freeze as the reason.
+	if err := freezeCheck(ctx); err != nil {
+		msg := fmt.Sprintf("Skipped exec step: %v", err)
+		clog.Warningf(ctx, msg)
+		return e.reportCompletedState(ctx, msg, &agentendpointpb.ReportTaskCompleteRequest_ExecStepTaskOutput{
+			ExecStepTaskOutput: &agentendpointpb.ExecStepTaskOutput{State: agentendpointpb.ExecStepTaskOutput_CANCELLED},
+		})
+	}
+
 	localPath := stepConfig.GetLocalPath()
 	if gcsObject := stepConfig.GetGcsObject(); gcsObject != nil {
 		var err error
@@ -16,9 +16,11 @@ package agentendpoint
 
 import (
 	"context"
+	"errors"
 	"os/exec"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/osconfig/changefreeze"
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -133,3 +135,35 @@ func TestRunExecStep(t *testing.T) {
 		})
 	}
 }
+
+func TestRunExecStepChangeFreeze(t *testing.T) {
+	ctx := context.Background()
+	srv := &agentEndpointServiceExecTestServer{}
+	tc, err := newTestClient(ctx, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.close()
+
+	defer func() { freezeCheck = changefreeze.Check }()
+	freezeCheck = func(context.Context) error { return errors.New("change freeze in effect") }
+	var ran bool
+	run = func(cmd *exec.Cmd) ([]byte, error) {
+		ran = true
+		return nil, nil
+	}
+	goos = "linux"
+
+	step := &agentendpointpb.ExecStep{LinuxExecStepConfig: &agentendpointpb.ExecStepConfig{Executable: &agentendpointpb.ExecStepConfig_LocalPath{LocalPath: "foo"}}}
+	if err := tc.client.RunExecStep(ctx, &agentendpointpb.Task{TaskDetails: &agentendpointpb.Task_ExecStepTask{ExecStepTask: &agentendpointpb.ExecStepTask{ExecStep: step}}}); err != nil {
+		t.Fatal(err)
+	}
+	want := outputGen("", "", agentendpointpb.ExecStepTaskOutput_CANCELLED, 0)
+	want.ErrorMessage = "Skipped exec step: change freeze in effect"
+	if diff := cmp.Diff(want, srv.lastReportTaskCompleteRequest, protocmp.Transform()); diff != "" {
+		t.Errorf("ReportTaskCompleteRequest mismatch (-want +got):\n%s", diff)
+	}
+	if ran {
+		t.Error("the exec step ran during a change freeze")
+	}
+}
@@ -0,0 +1,49 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build chaos
+// +build chaos
+
+package agentendpoint
+
+import (
+	"context"
+	"os"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/faultinject"
+	"google.golang.org/api/option"
+)
+
+// faultInjectionEnv holds the faultinject.Parse spec of the faults a chaos
+// build injects into its agentendpoint calls.
+const faultInjectionEnv = "OSCONFIG_FAULT_INJECTION"
+
+func faultInjectionOptions(ctx context.Context) []option.ClientOption {
+	spec := os.Getenv(faultInjectionEnv)
+	if spec == "" {
+		return nil
+	}
+	faults, err := faultinject.Parse(spec)
+	if err != nil {
+		clog.Errorf(ctx, "Ignoring %s: %v", faultInjectionEnv, err)
+		return nil
+	}
+	clog.Warningf(ctx, "Injecting faults into agentendpoint calls: %s", spec)
+	var opts []option.ClientOption
+	for _, o := range faultinject.New(faults...).DialOptions() {
+		opts = append(opts, option.WithGRPCDialOption(o))
+	}
+	return opts
+}
@@ -0,0 +1,153 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/faultinject"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// faultTestServer closes its notification streams when the client goes
+// away, so a stream reset by the client does not swallow later sends.
+type faultTestServer struct {
+	*agentEndpointServiceTestServer
+}
+
+func (s *faultTestServer) ReceiveTaskNotification(req *agentendpointpb.ReceiveTaskNotificationRequest, srv agentendpointpb.AgentEndpointService_ReceiveTaskNotificationServer) error {
+	for {
+		select {
+		case <-srv.Context().Done():
+			return nil
+		case <-s.streamClose:
+			return nil
+		case <-s.streamSend:
+			srv.Send(&agentendpointpb.ReceiveTaskNotificationResponse{})
+		}
+	}
+}
+
+func newFaultTestClient(t *testing.T, faults ...faultinject.Fault) (*testClient, *agentEndpointServiceTestServer, *faultinject.Injector) {
+	t.Helper()
+	oldDebounce := taskNotificationDebounce
+	t.Cleanup(func() { taskNotificationDebounce = oldDebounce })
+	taskNotificationDebounce = 0
+
+	oldStateFile := taskStateFile
+	t.Cleanup(func() { taskStateFile = oldStateFile })
+	taskStateFile = filepath.Join(t.TempDir(), "testState")
+
+	srv := newAgentEndpointServiceTestServer()
+	inj := faultinject.New(faults...)
+	tc, err := newTestClientWithOptions(context.Background(), &faultTestServer{srv}, inj.DialOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tc.close)
+	return tc, srv, inj
+}
+
+var wantRunTaskIDs = []string{"TaskType_EXEC_STEP_TASK", "TaskType_APPLY_PATCHES", "TaskType_APPLY_CONFIG_TASK"}
+
+func TestFaultInjectionRetriesUnavailable(t *testing.T) {
+	tc, srv, inj := newFaultTestClient(t, faultinject.Fault{Method: "StartNextTask", Kind: faultinject.Error, Code: codes.Unavailable, Count: 1})
+
+	srv.streamSend <- struct{}{}
+	if err := tc.client.waitForTask(context.Background()); err != nil {
+		t.Fatalf("waitForTask() error: %v", err)
+	}
+	if got := inj.Injected("StartNextTask"); got != 1 {
+		t.Errorf("injected %d StartNextTask faults, want 1", got)
+	}
+	if !reflect.DeepEqual(srv.runTaskIDs, wantRunTaskIDs) {
+		t.Errorf("completed tasks = %q, want %q", srv.runTaskIDs, wantRunTaskIDs)
+	}
+}
+
+func TestFaultInjectionStreamReset(t *testing.T) {
+	tc, srv, inj := newFaultTestClient(t, faultinject.Fault{Method: "ReceiveTaskNotification", Kind: faultinject.Reset, Count: 1})
+
+	// The reset stream is reported as retryable, not as a disabled service.
+	ctx, cancel := context.WithCancel(context.Background())
+	err := tc.client.waitForTask(ctx)
+	cancel()
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("waitForTask() error = %v, want code %s", err, codes.Unavailable)
+	}
+	if inj.Injected("ReceiveTaskNotification") != 1 {
+		t.Errorf("stream reset was not injected")
+	}
+
+	// The reconnected stream receives notifications and runs tasks.
+	srv.streamSend <- struct{}{}
+	if err := tc.client.waitForTask(context.Background()); err != nil {
+		t.Fatalf("waitForTask() error after reconnect: %v", err)
+	}
+	if !reflect.DeepEqual(srv.runTaskIDs, wantRunTaskIDs) {
+		t.Errorf("completed tasks = %q, want %q", srv.runTaskIDs, wantRunTaskIDs)
+	}
+}
+
+func TestFaultInjectionSlowResponse(t *testing.T) {
+	tc, srv, _ := newFaultTestClient(t,
+		faultinject.Fault{Method: "StartNextTask", Kind: faultinject.Delay, Delay: time.Minute, Count: 1},
+		faultinject.Fault{Method: "StartNextTask", Kind: faultinject.Delay, Delay: 50 * time.Millisecond},
+	)
+
+	// A response slower than the caller's deadline fails without retrying
+	// for the full retry window.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := tc.client.startNextTask(ctx); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("startNextTask() error = %v, want code %s", err, codes.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("startNextTask() took %s after its deadline expired", d)
+	}
+
+	// Slow but timely responses still run every task.
+	srv.streamSend <- struct{}{}
+	if err := tc.client.waitForTask(context.Background()); err != nil {
+		t.Fatalf("waitForTask() error: %v", err)
+	}
+	if !reflect.DeepEqual(srv.runTaskIDs, wantRunTaskIDs) {
+		t.Errorf("completed tasks = %q, want %q", srv.runTaskIDs, wantRunTaskIDs)
+	}
+}
+
+func TestFaultInjectionMalformedTask(t *testing.T) {
+	tc, srv, inj := newFaultTestClient(t, faultinject.Fault{Method: "StartNextTask", Kind: faultinject.Malform, Count: 1})
+
+	// A task of an unknown type is skipped and the remaining tasks run.
+	srv.streamSend <- struct{}{}
+	if err := tc.client.waitForTask(context.Background()); err != nil {
+		t.Fatalf("waitForTask() error: %v", err)
+	}
+	if inj.Injected("StartNextTask") != 1 {
+		t.Errorf("malformed task was not injected")
+	}
+	if !reflect.DeepEqual(srv.runTaskIDs, wantRunTaskIDs) {
+		t.Errorf("completed tasks = %q, want %q", srv.runTaskIDs, wantRunTaskIDs)
+	}
+}
@@ -0,0 +1,152 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// errAgentRestart is returned by a patch task that stopped so an updated
+// agent can resume it from the saved task state.
+var errAgentRestart = errors.New("agent restart required to resume task")
+
+// restartRequested is signaled when a task needs the agent to restart
+// without waiting for the next periodic restart file check.
+var restartRequested = make(chan struct{}, 1)
+
+// RestartRequested returns a channel that receives when a task asks for an
+// agent restart.
+func RestartRequested() <-chan struct{} {
+	return restartRequested
+}
+
+func requestRestart() {
+	select {
+	case restartRequested <- struct{}{}:
+	default:
+	}
+}
+
+// guestEnvironmentPackages are the GCE guest environment packages updated
+// before the rest of a patch job, including the agent itself.
+var guestEnvironmentPackages = map[string]bool{
+	"google-guest-agent":                     true,
+	"google-osconfig-agent":                  true,
+	"google-compute-engine":                  true,
+	"google-compute-engine-oslogin":          true,
+	"google-guest-configs":                   true,
+	"google-compute-engine-windows":          true,
+	"google-compute-engine-sysprep":          true,
+	"google-compute-engine-metadata-scripts": true,
+	"google-compute-engine-powershell":       true,
+	"certgen":                                true,
+}
+
+type guestEnvironmentManager struct {
+	name    string
+	exists  func() bool
+	updates func(context.Context) ([]*packages.PkgInfo, error)
+	install func(context.Context, []string) error
+}
+
+var guestEnvironmentManagers = []guestEnvironmentManager{
+	{
+		name:    "apt",
+		exists:  func() bool { return packages.AptExists },
+		updates: func(ctx context.Context) ([]*packages.PkgInfo, error) { return packages.AptUpdates(ctx) },
+		install: packages.InstallAptPackages,
+	},
+	{
+		name:    "yum",
+		exists:  func() bool { return packages.YumExists },
+		updates: func(ctx context.Context) ([]*packages.PkgInfo, error) { return packages.YumUpdates(ctx) },
+		install: packages.InstallYumPackages,
+	},
+	{
+		name:    "zypper",
+		exists:  func() bool { return packages.ZypperExists },
+		updates: func(ctx context.Context) ([]*packages.PkgInfo, error) { return packages.ZypperUpdates(ctx) },
+		install: packages.InstallZypperPackages,
+	},
+	{
+		name:    "googet",
+		exists:  func() bool { return packages.GooGetExists },
+		updates: packages.GooGetUpdates,
+		install: packages.InstallGooGetPackages,
+	},
+}
+
+// guestEnvironmentUpdates returns the names of guest environment packages
+// with an available update.
+func guestEnvironmentUpdates(ctx context.Context, m guestEnvironmentManager) ([]string, error) {
+	updates, err := m.updates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, pkg := range updates {
+		if guestEnvironmentPackages[pkg.Name] {
+			names = append(names, pkg.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// updateGuestEnvironment updates the installed guest environment packages
+// with every available package manager.
+func (r *patchTask) updateGuestEnvironment(ctx context.Context) error {
+	var errs []string
+	for _, m := range guestEnvironmentManagers {
+		if !m.exists() {
+			continue
+		}
+		names, err := guestEnvironmentUpdates(ctx, m)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("error listing %s updates: %v", m.name, err))
+			continue
+		}
+		if len(names) == 0 {
+			continue
+		}
+		if r.Task.GetDryRun() {
+			clog.Infof(ctx, "Dry run - not updating guest environment packages %q with %s.", names, m.name)
+			continue
+		}
+		clog.Infof(ctx, "Updating guest environment packages %q with %s.", names, m.name)
+		if err := m.install(ctx, names); err != nil {
+			errs = append(errs, fmt.Sprintf("error updating %s packages: %v", m.name, err))
+		}
+	}
+	if errs != nil {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// agentRestartRequired reports whether a package update has asked for the
+// agent to be restarted.
+var agentRestartRequired = func() bool {
+	return util.Exists(agentconfig.RestartFile())
+}
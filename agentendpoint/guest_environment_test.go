@@ -0,0 +1,121 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestUpdateGuestEnvironment(t *testing.T) {
+	var installed map[string][]string
+	fake := func(name string, exists bool, updates []string, listErr, installErr error) guestEnvironmentManager {
+		return guestEnvironmentManager{
+			name:   name,
+			exists: func() bool { return exists },
+			updates: func(context.Context) ([]*packages.PkgInfo, error) {
+				var pkgs []*packages.PkgInfo
+				for _, u := range updates {
+					pkgs = append(pkgs, &packages.PkgInfo{Name: u})
+				}
+				return pkgs, listErr
+			},
+			install: func(_ context.Context, pkgs []string) error {
+				installed[name] = pkgs
+				return installErr
+			},
+		}
+	}
+
+	tests := []struct {
+		desc     string
+		dryRun   bool
+		managers []guestEnvironmentManager
+		want     map[string][]string
+		wantErr  string
+	}{
+		{
+			desc: "only guest environment packages",
+			managers: []guestEnvironmentManager{
+				fake("apt", true, []string{"google-osconfig-agent", "bash", "google-guest-agent"}, nil, nil),
+				fake("yum", false, []string{"google-guest-agent"}, nil, nil),
+			},
+			want: map[string][]string{"apt": {"google-guest-agent", "google-osconfig-agent"}},
+		},
+		{
+			desc:     "nothing to update",
+			managers: []guestEnvironmentManager{fake("apt", true, []string{"bash"}, nil, nil)},
+			want:     map[string][]string{},
+		},
+		{
+			desc:     "dry run",
+			dryRun:   true,
+			managers: []guestEnvironmentManager{fake("apt", true, []string{"google-guest-agent"}, nil, nil)},
+			want:     map[string][]string{},
+		},
+		{
+			desc: "errors continue with other managers",
+			managers: []guestEnvironmentManager{
+				fake("apt", true, nil, errors.New("list failed"), nil),
+				fake("googet", true, []string{"google-compute-engine-windows"}, nil, errors.New("install failed")),
+			},
+			want:    map[string][]string{"googet": {"google-compute-engine-windows"}},
+			wantErr: "error listing apt updates: list failed\nerror updating googet packages: install failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			installed = map[string][]string{}
+			old := guestEnvironmentManagers
+			guestEnvironmentManagers = tt.managers
+			defer func() { guestEnvironmentManagers = old }()
+
+			r := &patchTask{Task: &applyPatchesTask{&agentendpointpb.ApplyPatchesTask{DryRun: tt.dryRun}}}
+			err := r.updateGuestEnvironment(context.Background())
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(installed, tt.want) {
+				t.Errorf("installed = %v, want %v", installed, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestRestart(t *testing.T) {
+	// Multiple requests never block and collapse into one signal.
+	requestRestart()
+	requestRestart()
+	select {
+	case <-RestartRequested():
+	default:
+		t.Fatal("expected a restart request")
+	}
+	select {
+	case <-RestartRequested():
+		t.Fatal("expected a single restart request")
+	default:
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/attributes"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/history"
 	"github.com/GoogleCloudPlatform/osconfig/inventory"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"github.com/GoogleCloudPlatform/osconfig/retryutil"
@@ -23,16 +24,39 @@ const (
 	inventoryURL = agentconfig.ReportURL + "/guestInventory"
 )
 
-// ReportInventory writes inventory to guest attributes and reports it to agent endpoint.
-func (c *Client) ReportInventory(ctx context.Context) {
-	state := inventory.Get(ctx)
+// ReportInventory writes inventory to guest attributes and reports it to agent endpoint,
+// an error is returned if it could not be reported.
+func (c *Client) ReportInventory(ctx context.Context) error {
+	ctx = clog.WithSubsystem(ctx, "inventory")
+	end, err := beginFeature(ctx, "inventory")
+	if err != nil {
+		clog.Errorf(ctx, "Skipping inventory: %v", err)
+		return err
+	}
+	defer end()
+
+	state, err := getInventory(ctx)
+	if err != nil {
+		return err
+	}
+	history.Add(ctx, history.Inventory, state)
 
 	if agentconfig.GuestAttributesEnabled() && !agentconfig.DisableInventoryWrite() {
 		clog.Infof(ctx, "Writing inventory to guest attributes")
 		write(ctx, state, inventoryURL)
 	}
 
-	c.report(ctx, state)
+	return c.report(ctx, state)
+}
+
+// getInventory collects inventory, in an unprivileged worker process if one
+// is configured and the agent itself runs as root.
+func getInventory(ctx context.Context) (*inventory.InstanceInventory, error) {
+	if user := agentconfig.InventoryWorkerUser(); user != "" && !agentconfig.PrivilegeHelper() {
+		clog.Debugf(ctx, "Collecting inventory as user %q.", user)
+		return inventory.GetFromWorker(ctx, user)
+	}
+	return inventory.Get(ctx), nil
 }
 
 func write(ctx context.Context, state *inventory.InstanceInventory, url string) {
@@ -61,7 +85,7 @@ func write(ctx context.Context, state *inventory.InstanceInventory, url string)
 	}
 }
 
-func (c *Client) report(ctx context.Context, state *inventory.InstanceInventory) {
+func (c *Client) report(ctx context.Context, state *inventory.InstanceInventory) error {
 	clog.Debugf(ctx, "Reporting instance inventory to agent endpoint.")
 	inventory := formatInventory(ctx, state)
 
@@ -78,16 +102,17 @@ func (c *Client) report(ctx context.Context, state *inventory.InstanceInventory)
 
 	if err = retryutil.RetryAPICall(ctx, apiRetrySec*time.Second, "ReportInventory", f); err != nil {
 		clog.Errorf(ctx, "Error reporting inventory checksum: %v", err)
-		return
+		return err
 	}
 
 	if res.GetReportFullInventory() {
 		reportFull = true
 		if err = retryutil.RetryAPICall(ctx, apiRetrySec*time.Second, "ReportInventory", f); err != nil {
 			clog.Errorf(ctx, "Error reporting full inventory: %v", err)
-			return
+			return err
 		}
 	}
+	return nil
 }
 
 func formatInventory(ctx context.Context, state *inventory.InstanceInventory) *agentendpointpb.Inventory {
@@ -102,6 +127,7 @@ func formatInventory(ctx context.Context, state *inventory.InstanceInventory) *a
 		OsconfigAgentVersion: state.OSConfigAgentVersion,
 	}
 	installedPackages := formatPackages(ctx, state.InstalledPackages, state.ShortName)
+	installedPackages = append(installedPackages, formatWSLDistributions(state.WSLInventory)...)
 	availablePackages := formatPackages(ctx, state.PackageUpdates, state.ShortName)
 
 	return &agentendpointpb.Inventory{OsInfo: osInfo, InstalledPackages: installedPackages, AvailablePackages: availablePackages}
@@ -219,11 +245,41 @@ func formatPackages(ctx context.Context, pkgs *packages.Packages, shortName stri
 		}
 		softwarePackages = append(softwarePackages, temp...)
 	}
-	// Ignore Pip and Gem packages.
+	// Gem, Pip, Npm, Apk, Pacman, Portage, Flatpak and Brew packages and dnf
+	// modules are not reported, the Inventory proto has no package type for
+	// them, they are only written to guest attributes. Winget packages are
+	// also reported as Windows applications.
 
 	return softwarePackages
 }
 
+// formatWSLDistributions reports each WSL distribution as a Windows
+// application, distributions registered by more than one user are reported
+// once. The packages inside the distributions are only written to guest
+// attributes, the inventory has no way to tell them apart from the host's.
+func formatWSLDistributions(inv *inventory.WSLInventory) []*agentendpointpb.Inventory_SoftwarePackage {
+	if inv == nil {
+		return nil
+	}
+	var softwarePackages []*agentendpointpb.Inventory_SoftwarePackage
+	seen := map[string]bool{}
+	for _, d := range inv.Distributions {
+		version := fmt.Sprintf("WSL %d", d.Version)
+		if seen[d.Name+"/"+version] {
+			continue
+		}
+		seen[d.Name+"/"+version] = true
+		softwarePackages = append(softwarePackages, &agentendpointpb.Inventory_SoftwarePackage{
+			Details: &agentendpointpb.Inventory_SoftwarePackage_WindowsApplication{
+				WindowsApplication: &agentendpointpb.Inventory_WindowsApplication{
+					DisplayName:    d.Name,
+					DisplayVersion: version,
+					Publisher:      "Windows Subsystem for Linux",
+				}}})
+	}
+	return softwarePackages
+}
+
 func formatAptPackage(pkg *packages.PkgInfo) *agentendpointpb.Inventory_SoftwarePackage_AptPackage {
 	return &agentendpointpb.Inventory_SoftwarePackage_AptPackage{
 		AptPackage: &agentendpointpb.Inventory_VersionedPackage{
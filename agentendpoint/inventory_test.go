@@ -280,6 +280,7 @@ func decodePackages(str string) *packages.Packages {
 func TestWrite(t *testing.T) {
 	inv := &inventory.InstanceInventory{
 		Hostname:      "Hostname",
+		FQDN:          "Hostname.example.com",
 		LongName:      "LongName",
 		ShortName:     "ShortName",
 		Architecture:  "Architecture",
@@ -300,6 +301,7 @@ func TestWrite(t *testing.T) {
 
 	want := map[string]bool{
 		"Hostname":             false,
+		"FQDN":                 false,
 		"LongName":             false,
 		"ShortName":            false,
 		"Architecture":         false,
@@ -324,6 +326,11 @@ func TestWrite(t *testing.T) {
 				t.Errorf("did not get expected Hostname, got: %q, want: %q", buf.String(), inv.Hostname)
 			}
 			want["Hostname"] = true
+		case "/FQDN":
+			if buf.String() != inv.FQDN {
+				t.Errorf("did not get expected FQDN, got: %q, want: %q", buf.String(), inv.FQDN)
+			}
+			want["FQDN"] = true
 		case "/LongName":
 			if buf.String() != inv.LongName {
 				t.Errorf("did not get expected LongName, got: %q, want: %q", buf.String(), inv.LongName)
@@ -426,3 +433,53 @@ func TestReport(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatWSLDistributions(t *testing.T) {
+	inv := &inventory.WSLInventory{Distributions: []*inventory.WSLDistribution{
+		{Name: "Ubuntu", User: "S-1-5-21-1", Version: 2},
+		{Name: "Ubuntu", User: "S-1-5-21-2", Version: 2},
+		{Name: "Debian", User: "S-1-5-21-2", Version: 1},
+	}}
+	app := func(name, version string) *agentendpointpb.Inventory_SoftwarePackage {
+		return &agentendpointpb.Inventory_SoftwarePackage{
+			Details: &agentendpointpb.Inventory_SoftwarePackage_WindowsApplication{
+				WindowsApplication: &agentendpointpb.Inventory_WindowsApplication{
+					DisplayName:    name,
+					DisplayVersion: version,
+					Publisher:      "Windows Subsystem for Linux",
+				}}}
+	}
+	want := []*agentendpointpb.Inventory_SoftwarePackage{app("Ubuntu", "WSL 2"), app("Debian", "WSL 1")}
+	if diff := cmp.Diff(want, formatWSLDistributions(inv), protocmp.Transform()); diff != "" {
+		t.Errorf("formatWSLDistributions() mismatch (-want +got):\n%s", diff)
+	}
+	if got := formatWSLDistributions(nil); got != nil {
+		t.Errorf("formatWSLDistributions(nil) = %v, want nil", got)
+	}
+}
+
+func BenchmarkFormatInventory(b *testing.B) {
+	ctx := context.Background()
+	installed := &packages.Packages{}
+	for i := 0; i < 50000; i++ {
+		installed.Deb = append(installed.Deb, &packages.PkgInfo{Name: fmt.Sprintf("package-%d", i), Arch: "x86_64", Version: fmt.Sprintf("%d.%d-1", i%7, i%13)})
+	}
+	for i := 0; i < 5000; i++ {
+		installed.WUA = append(installed.WUA, &packages.WUAPackage{
+			Title:        fmt.Sprintf("Update for Windows (KB%d)", 5000000+i),
+			UpdateID:     fmt.Sprintf("%08x-0000-0000-0000-%012x", i, i),
+			KBArticleIDs: []string{fmt.Sprint(5000000 + i)},
+		})
+	}
+	updates := &packages.Packages{}
+	for i := 0; i < 10000; i++ {
+		updates.Apt = append(updates.Apt, &packages.PkgInfo{Name: fmt.Sprintf("package-%d", i), Arch: "x86_64", Version: fmt.Sprintf("%d.%d-2", i%7, i%13)})
+	}
+	state := &inventory.InstanceInventory{ShortName: "debian", InstalledPackages: installed, PackageUpdates: updates}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		formatInventory(ctx, state)
+	}
+}
@@ -0,0 +1,40 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+)
+
+// microsoftUpdateLabel lets a patch job opt instances into, or out of,
+// Microsoft Update, WindowsUpdateSettings has no field for it.
+const microsoftUpdateLabel = "windows-microsoft-update"
+
+var microsoftUpdateConfig = agentconfig.MicrosoftUpdate
+
+// wantMicrosoftUpdate returns whether a patch task registers Microsoft Update
+// with Windows Update, the task label takes precedence over the agent config.
+// ok is false if neither sets it.
+func wantMicrosoftUpdate(labels map[string]string) (register, ok bool) {
+	if v, found := labels[microsoftUpdateLabel]; found {
+		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return b, true
+		}
+	}
+	return microsoftUpdateConfig()
+}
@@ -0,0 +1,42 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import "testing"
+
+func TestWantMicrosoftUpdate(t *testing.T) {
+	old := microsoftUpdateConfig
+	defer func() { microsoftUpdateConfig = old }()
+
+	tests := []struct {
+		desc                 string
+		labels               map[string]string
+		config, configSet    bool
+		wantRegister, wantOk bool
+	}{
+		{"unset", nil, false, false, false, false},
+		{"agent config", nil, true, true, true, true},
+		{"label", map[string]string{microsoftUpdateLabel: "true"}, false, false, true, true},
+		{"label overrides agent config", map[string]string{microsoftUpdateLabel: "false"}, true, true, false, true},
+		{"bad label", map[string]string{microsoftUpdateLabel: "maybe"}, true, true, true, true},
+	}
+	for _, tt := range tests {
+		microsoftUpdateConfig = func() (bool, bool) { return tt.config, tt.configSet }
+		register, ok := wantMicrosoftUpdate(tt.labels)
+		if register != tt.wantRegister || ok != tt.wantOk {
+			t.Errorf("%s: wantMicrosoftUpdate() = (%t, %t), want (%t, %t)", tt.desc, register, ok, tt.wantRegister, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/ospatch"
+)
+
+// postPatchCleanupLabel sets the post patch cleanup steps of a patch job as a
+// comma separated list, PatchConfig has no field for them. An empty value
+// disables cleanup for the job.
+const postPatchCleanupLabel = "post-patch-cleanup"
+
+var (
+	postPatchCleanupConfig = agentconfig.PostPatchCleanup
+	runPostPatchCleanup    = ospatch.RunPostPatchCleanup
+)
+
+// cleanupReport is the outcome of the post patch cleanup steps.
+type cleanupReport struct {
+	Steps          []string `json:"steps"`
+	BytesReclaimed int64    `json:"bytesReclaimed"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// postPatchCleanupSteps returns the cleanup steps of a patch task, the task
+// label takes precedence over the agent config.
+func postPatchCleanupSteps(labels map[string]string) []string {
+	v, ok := labels[postPatchCleanupLabel]
+	if !ok {
+		return postPatchCleanupConfig()
+	}
+	var steps []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			steps = append(steps, s)
+		}
+	}
+	return steps
+}
+
+// postPatchCleanup runs the cleanup steps of the task, it returns nil if
+// there are none. Cleanup only reclaims disk space, failures do not fail the
+// patch job.
+func (r *patchTask) postPatchCleanup(ctx context.Context) *cleanupReport {
+	steps := postPatchCleanupSteps(r.state.Labels)
+	if len(steps) == 0 || r.Task.GetDryRun() {
+		return nil
+	}
+	reclaimed, err := runPostPatchCleanup(ctx, steps)
+	report := &cleanupReport{Steps: steps, BytesReclaimed: reclaimed}
+	if err != nil {
+		clog.Warningf(ctx, "Error running post patch cleanup: %v", err)
+		report.Error = err.Error()
+	}
+	return report
+}
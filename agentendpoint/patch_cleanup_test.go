@@ -0,0 +1,70 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestPostPatchCleanupSteps(t *testing.T) {
+	old := postPatchCleanupConfig
+	defer func() { postPatchCleanupConfig = old }()
+	postPatchCleanupConfig = func() []string { return []string{"clean"} }
+
+	tests := []struct {
+		desc   string
+		labels map[string]string
+		want   []string
+	}{
+		{"agent config", nil, []string{"clean"}},
+		{"label overrides agent config", map[string]string{postPatchCleanupLabel: "Autoremove, clean"}, []string{"autoremove", "clean"}},
+		{"empty label disables cleanup", map[string]string{postPatchCleanupLabel: ""}, nil},
+	}
+	for _, tt := range tests {
+		if got := postPatchCleanupSteps(tt.labels); !cmp.Equal(got, tt.want) {
+			t.Errorf("%s: postPatchCleanupSteps() = %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestPostPatchCleanup(t *testing.T) {
+	ctx := context.Background()
+	old := runPostPatchCleanup
+	defer func() { runPostPatchCleanup = old }()
+	runPostPatchCleanup = func(ctx context.Context, steps []string) (int64, error) {
+		return 1024, errors.New("clean failed")
+	}
+
+	r := &patchTask{
+		Task:  &applyPatchesTask{&agentendpointpb.ApplyPatchesTask{}},
+		state: &taskState{Labels: map[string]string{postPatchCleanupLabel: "clean"}},
+	}
+	want := &cleanupReport{Steps: []string{"clean"}, BytesReclaimed: 1024, Error: "clean failed"}
+	if diff := cmp.Diff(want, r.postPatchCleanup(ctx)); diff != "" {
+		t.Errorf("postPatchCleanup() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Dry runs do not clean up.
+	r.Task.DryRun = true
+	if got := r.postPatchCleanup(ctx); got != nil {
+		t.Errorf("postPatchCleanup() of a dry run = %+v, want nil", got)
+	}
+}
@@ -32,6 +32,9 @@ import (
 func (r *patchTask) runUpdates(ctx context.Context) error {
 	var errs []string
 	const retryPeriod = 3 * time.Minute
+	// Repositories of end of life releases are often moved to an archive,
+	// the hint points there when patching fails.
+	eolHint := ospatch.DistroEOLHint(ctx)
 	// Check for both apt-get and dpkg-query to give us a clean signal.
 	if packages.AptExists && packages.DpkgQueryExists {
 		excludes, err := convertInputToExcludes(r.Task.GetPatchConfig().GetApt().GetExcludes())
@@ -42,6 +45,7 @@ func (r *patchTask) runUpdates(ctx context.Context) error {
 			ospatch.AptGetDryRun(r.Task.GetDryRun()),
 			ospatch.AptGetExcludes(excludes),
 			ospatch.AptGetExclusivePackages(r.Task.GetPatchConfig().GetApt().GetExclusivePackages()),
+			ospatch.AptGetDiskSpaceCheck(packages.CheckInstallSpace),
 		}
 		switch r.Task.GetPatchConfig().GetApt().GetType() {
 		case agentendpointpb.AptSettings_DIST:
@@ -49,7 +53,7 @@ func (r *patchTask) runUpdates(ctx context.Context) error {
 		}
 		clog.Debugf(ctx, "Installing APT package updates.")
 		if err := retryutil.RetryFunc(ctx, retryPeriod, "installing APT package updates", func() error { return ospatch.RunAptGetUpgrade(ctx, opts...) }); err != nil {
-			errs = append(errs, err.Error())
+			errs = append(errs, ospatch.ClassifyRepoError(err, eolHint).Error())
 		}
 	}
 	if packages.YumExists && packages.RPMQueryExists {
@@ -63,10 +67,11 @@ func (r *patchTask) runUpdates(ctx context.Context) error {
 			ospatch.YumUpdateExcludes(excludes),
 			ospatch.YumExclusivePackages(r.Task.GetPatchConfig().GetYum().GetExclusivePackages()),
 			ospatch.YumDryRun(r.Task.GetDryRun()),
+			ospatch.YumDiskSpaceCheck(packages.CheckInstallSpace),
 		}
 		clog.Debugf(ctx, "Installing YUM package updates.")
 		if err := retryutil.RetryFunc(ctx, retryPeriod, "installing YUM package updates", func() error { return ospatch.RunYumUpdate(ctx, opts...) }); err != nil {
-			errs = append(errs, err.Error())
+			errs = append(errs, ospatch.ClassifyRepoError(err, eolHint).Error())
 		}
 	}
 	if packages.ZypperExists && packages.RPMQueryExists {
@@ -82,10 +87,11 @@ func (r *patchTask) runUpdates(ctx context.Context) error {
 			ospatch.ZypperUpdateWithExcludes(excludes),
 			ospatch.ZypperUpdateWithExclusivePatches(r.Task.GetPatchConfig().GetZypper().GetExclusivePatches()),
 			ospatch.ZypperUpdateDryrun(r.Task.GetDryRun()),
+			ospatch.ZypperDiskSpaceCheck(packages.CheckInstallSpace),
 		}
 		clog.Debugf(ctx, "Installing Zypper updates.")
 		if err := retryutil.RetryFunc(ctx, retryPeriod, "installing Zypper updates", func() error { return ospatch.RunZypperPatch(ctx, opts...) }); err != nil {
-			errs = append(errs, err.Error())
+			errs = append(errs, ospatch.ClassifyRepoError(err, eolHint).Error())
 		}
 	}
 	if errs == nil {
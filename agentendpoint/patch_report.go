@@ -0,0 +1,182 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/history"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/upload"
+)
+
+var (
+	installedPackages = packages.GetInstalledPackages
+	markTransactions  = packages.MarkTransactions
+	yumVersionLocks   = packages.YumVersionLocks
+)
+
+// patchReport lists the package changes made by a patch task.
+type patchReport struct {
+	TaskID  string           `json:"taskId"`
+	DryRun  bool             `json:"dryRun,omitempty"`
+	Changes []*packageChange `json:"changes"`
+	// Reboots lists the reboots before the patches were applied.
+	Reboots []*rebootAttribution `json:"reboots,omitempty"`
+	// Transactions are the package manager transactions made by the patches.
+	Transactions []*packages.Transaction `json:"transactions,omitempty"`
+	// VersionLocked are the packages yum did not update because of a
+	// versionlock entry.
+	VersionLocked []string `json:"versionLocked,omitempty"`
+	// Cleanup is the outcome of the post patch cleanup steps, if any ran.
+	Cleanup *cleanupReport `json:"cleanup,omitempty"`
+}
+
+// versionLockedPackages returns the names of the packages locked by a yum
+// versionlock entry, failures are logged.
+func versionLockedPackages(ctx context.Context) []string {
+	if !packages.YumExists {
+		return nil
+	}
+	locks, err := yumVersionLocks(ctx)
+	if err != nil {
+		clog.Warningf(ctx, "Error listing yum versionlock entries for patch report: %v", err)
+		return nil
+	}
+	var names []string
+	for _, l := range locks {
+		if !l.Exclude && !slices.Contains(names, l.Name) {
+			names = append(names, l.Name)
+		}
+	}
+	return names
+}
+
+// transactionRecord is the local history record of the package manager
+// transactions made by a task.
+type transactionRecord struct {
+	TaskID       string                  `json:"taskId"`
+	TaskType     string                  `json:"taskType"`
+	PolicyID     string                  `json:"policyId,omitempty"`
+	ResourceID   string                  `json:"resourceId,omitempty"`
+	Transactions []*packages.Transaction `json:"transactions"`
+}
+
+// recordTransactions adds the transactions made by a task to the local
+// history.
+func recordTransactions(ctx context.Context, rec *transactionRecord) {
+	if len(rec.Transactions) == 0 {
+		return
+	}
+	for _, tx := range rec.Transactions {
+		if tx.ID != "" {
+			clog.Infof(ctx, "Packages changed in %s transaction %s.", tx.Manager, tx.ID)
+		}
+	}
+	history.Add(ctx, history.Transaction, rec)
+}
+
+// packageChange is a package that was added, removed or changed version.
+type packageChange struct {
+	Manager string `json:"manager"`
+	Name    string `json:"name"`
+	Arch    string `json:"arch,omitempty"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+}
+
+func packagesByManager(pkgs *packages.Packages) map[string][]*packages.PkgInfo {
+	if pkgs == nil {
+		return nil
+	}
+	return map[string][]*packages.PkgInfo{
+		"apt":     pkgs.Apt,
+		"deb":     pkgs.Deb,
+		"yum":     pkgs.Yum,
+		"rpm":     pkgs.Rpm,
+		"zypper":  pkgs.Zypper,
+		"googet":  pkgs.GooGet,
+		"cos":     pkgs.COS,
+		"apk":     pkgs.Apk,
+		"pacman":  pkgs.Pacman,
+		"portage": pkgs.Portage,
+	}
+}
+
+// diffPackages returns the changes between two package lists, sorted by
+// manager and name.
+func diffPackages(before, after *packages.Packages) []*packageChange {
+	type key struct{ manager, name, arch string }
+	changes := map[key]*packageChange{}
+	for mgr, pkgs := range packagesByManager(before) {
+		for _, p := range pkgs {
+			changes[key{mgr, p.Name, p.Arch}] = &packageChange{Manager: mgr, Name: p.Name, Arch: p.Arch, From: p.Version}
+		}
+	}
+	for mgr, pkgs := range packagesByManager(after) {
+		for _, p := range pkgs {
+			k := key{mgr, p.Name, p.Arch}
+			if c, ok := changes[k]; ok {
+				c.To = p.Version
+				continue
+			}
+			changes[k] = &packageChange{Manager: mgr, Name: p.Name, Arch: p.Arch, To: p.Version}
+		}
+	}
+
+	var diff []*packageChange
+	for _, c := range changes {
+		if c.From != c.To {
+			diff = append(diff, c)
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		if diff[i].Manager != diff[j].Manager {
+			return diff[i].Manager < diff[j].Manager
+		}
+		if diff[i].Name != diff[j].Name {
+			return diff[i].Name < diff[j].Name
+		}
+		return diff[i].Arch < diff[j].Arch
+	})
+	return diff
+}
+
+// uploadPatchReport uploads the package changes made since before was
+// taken, failures are logged and do not fail the patch task.
+func (r *patchTask) uploadPatchReport(ctx context.Context, before *packages.Packages, txs []*packages.Transaction, cleanup *cleanupReport) {
+	after, err := installedPackages(ctx)
+	if err != nil {
+		clog.Warningf(ctx, "Error listing installed packages for patch report: %v", err)
+		return
+	}
+	report := &patchReport{TaskID: r.TaskID, DryRun: r.Task.GetDryRun(), Changes: diffPackages(before, after), Reboots: r.Reboots, Transactions: txs, VersionLocked: versionLockedPackages(ctx), Cleanup: cleanup}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		clog.Warningf(ctx, "Error formatting patch report: %v", err)
+		return
+	}
+	url, err := upload.Upload(ctx, upload.PatchReport, r.TaskID+".json", bytes.NewReader(data))
+	if err != nil {
+		clog.Warningf(ctx, "Error uploading patch report: %v", err)
+		return
+	}
+	clog.Infof(ctx, "Uploaded patch report with %d package changes to %s", len(report.Changes), url)
+}
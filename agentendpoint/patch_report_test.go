@@ -0,0 +1,70 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffPackages(t *testing.T) {
+	before := &packages.Packages{
+		Apt: []*packages.PkgInfo{
+			{Name: "openssl", Arch: "x86_64", Version: "1.1.1f-1ubuntu2.19"},
+			{Name: "curl", Arch: "x86_64", Version: "7.68.0-1ubuntu2.20"},
+			{Name: "old-lib", Arch: "x86_64", Version: "1.0"},
+		},
+	}
+	after := &packages.Packages{
+		Apt: []*packages.PkgInfo{
+			{Name: "openssl", Arch: "x86_64", Version: "1.1.1f-1ubuntu2.20"},
+			{Name: "curl", Arch: "x86_64", Version: "7.68.0-1ubuntu2.20"},
+			{Name: "linux-image", Arch: "x86_64", Version: "5.15.0-91"},
+		},
+	}
+
+	want := []*packageChange{
+		{Manager: "apt", Name: "linux-image", Arch: "x86_64", To: "5.15.0-91"},
+		{Manager: "apt", Name: "old-lib", Arch: "x86_64", From: "1.0"},
+		{Manager: "apt", Name: "openssl", Arch: "x86_64", From: "1.1.1f-1ubuntu2.19", To: "1.1.1f-1ubuntu2.20"},
+	}
+	if diff := cmp.Diff(want, diffPackages(before, after)); diff != "" {
+		t.Errorf("diffPackages() mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := diffPackages(before, before); len(got) != 0 {
+		t.Errorf("diffPackages(before, before) = %v, want no changes", got)
+	}
+}
+
+func TestVersionLockedPackages(t *testing.T) {
+	oldExists, oldLocks := packages.YumExists, yumVersionLocks
+	defer func() { packages.YumExists, yumVersionLocks = oldExists, oldLocks }()
+	packages.YumExists = true
+	yumVersionLocks = func(context.Context) ([]*packages.VersionLock, error) {
+		return []*packages.VersionLock{
+			{Name: "bash", Entry: "0:bash-4.2.46-34.el7.*"},
+			{Name: "bash", Entry: "0:bash-4.2.46-35.el7.*"},
+			{Name: "kernel", Entry: "!0:kernel-3.10.0-1160.el7.*", Exclude: true},
+		}, nil
+	}
+
+	if diff := cmp.Diff([]string{"bash"}, versionLockedPackages(context.Background())); diff != "" {
+		t.Errorf("versionLockedPackages() mismatch (-want +got):\n%s", diff)
+	}
+}
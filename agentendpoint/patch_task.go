@@ -21,7 +21,11 @@ import (
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/crashreport"
 	"github.com/GoogleCloudPlatform/osconfig/ospatch"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/progress"
+	"github.com/GoogleCloudPlatform/osconfig/upload"
 	"google.golang.org/protobuf/encoding/protojson"
 
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
@@ -34,9 +38,10 @@ func systemRebootRequired(ctx context.Context) (bool, error) {
 type patchStep string
 
 const (
-	prePatch  = "PrePatch"
-	patching  = "Patching"
-	postPatch = "PostPatch"
+	prePatch         = "PrePatch"
+	guestEnvironment = "GuestEnvironment"
+	patching         = "Patching"
+	postPatch        = "PostPatch"
 )
 
 type patchTask struct {
@@ -50,6 +55,12 @@ type patchTask struct {
 	StartedAt   time.Time `json:",omitempty"`
 	PatchStep   patchStep `json:",omitempty"`
 	RebootCount int
+	// Reboots lists the reboots that interrupted this task.
+	Reboots []*rebootAttribution `json:",omitempty"`
+	// UpdateCatalog is whether exclusive patches Windows Update did not
+	// install are installed from the Microsoft Update Catalog, it is fixed
+	// when the task starts.
+	UpdateCatalog bool `json:",omitempty"`
 
 	// TODO: add Attempts and track number of retries with backoff, jitter, etc.
 }
@@ -204,6 +215,9 @@ func (r *patchTask) rebootIfNeeded(ctx context.Context, prePatch bool) error {
 	if err := r.saveState(); err != nil {
 		return fmt.Errorf("error saving state: %v", err)
 	}
+	if err := markAgentReboot(r.TaskID); err != nil {
+		clog.Warningf(ctx, "Error recording reboot: %v", err)
+	}
 	if err := rebootSystem(); err != nil {
 		return fmt.Errorf("failed to reboot system: %v", err)
 	}
@@ -217,7 +231,9 @@ func (r *patchTask) rebootIfNeeded(ctx context.Context, prePatch bool) error {
 
 func (r *patchTask) run(ctx context.Context) (err error) {
 	ctx = clog.WithLabels(ctx, r.state.Labels)
+	ctx = progress.WithTask(ctx, r.TaskID)
 	clog.Infof(ctx, "Beginning ApplyPatchesTask")
+	r.recordReboot(ctx)
 	defer func() {
 		// This should not happen but the WUA libraries are complicated and
 		// recovering with an error is better than crashing.
@@ -226,9 +242,16 @@ func (r *patchTask) run(ctx context.Context) (err error) {
 			r.reportFailed(ctx, err.Error())
 			return
 		}
+		if err == errAgentRestart {
+			// Keep the task state so the restarted agent resumes the task.
+			return
+		}
 		r.complete(ctx)
 		if agentconfig.OSInventoryEnabled() {
-			go r.client.ReportInventory(ctx)
+			go func() {
+				defer crashreport.Recover(ctx, "Report OSInventory")
+				r.client.ReportInventory(ctx)
+			}()
 		}
 	}()
 
@@ -238,8 +261,18 @@ func (r *patchTask) run(ctx context.Context) (err error) {
 		default:
 			return r.reportFailed(ctx, fmt.Sprintf("unknown step: %q", r.PatchStep))
 		case prePatch:
+			if err := checkScheduleWindow(ctx, r.state.Labels); err != nil {
+				return r.handleErrorState(ctx, err.Error(), err)
+			}
+			if err := freezeCheck(ctx); err != nil {
+				return r.reportFailed(ctx, fmt.Sprintf("Skipped patching: %v", err))
+			}
 			r.StartedAt = time.Now()
-			if err := r.setStep(patching); err != nil {
+			var next patchStep = patching
+			if agentconfig.PatchGuestEnvironment() {
+				next = guestEnvironment
+			}
+			if err := r.setStep(next); err != nil {
 				return r.reportFailed(ctx, fmt.Sprintf("Error saving agent step: %v", err))
 			}
 
@@ -249,12 +282,50 @@ func (r *patchTask) run(ctx context.Context) (err error) {
 			if err := r.prePatchReboot(ctx); err != nil {
 				return r.handleErrorState(ctx, fmt.Sprintf("Error running prePatchReboot: %v", err), err)
 			}
+		case guestEnvironment:
+			// Move on first so a restarted agent does not update again.
+			if err := r.setStep(patching); err != nil {
+				return r.reportFailed(ctx, fmt.Sprintf("Error saving agent step: %v", err))
+			}
+			if err := r.reportContinuingState(ctx, agentendpointpb.ApplyPatchesTaskProgress_APPLYING_PATCHES); err != nil {
+				return r.handleErrorState(ctx, err.Error(), err)
+			}
+			// The guest environment is updated on a best effort basis, failures
+			// do not stop the remaining patches from being applied.
+			if err := r.updateGuestEnvironment(ctx); err != nil {
+				clog.Warningf(ctx, "Error updating guest environment: %v", err)
+			}
+			if agentRestartRequired() {
+				clog.Infof(ctx, "Agent was updated, ApplyPatchesTask will resume after the agent restarts.")
+				requestRestart()
+				return errAgentRestart
+			}
 		case patching:
 			if err := r.reportContinuingState(ctx, agentendpointpb.ApplyPatchesTaskProgress_APPLYING_PATCHES); err != nil {
 				return r.handleErrorState(ctx, err.Error(), err)
 			}
-			if err := r.runUpdates(ctx); err != nil {
-				return r.handleErrorState(ctx, fmt.Sprintf("Failed to apply patches: %v", err), err)
+			// The package list is only needed for the patch report.
+			var before *packages.Packages
+			if upload.Enabled() {
+				var err error
+				if before, err = installedPackages(ctx); err != nil {
+					clog.Warningf(ctx, "Error listing installed packages for patch report: %v", err)
+				}
+			}
+			var mark *packages.TransactionMark
+			if !r.Task.GetDryRun() {
+				mark = markTransactions(ctx)
+			}
+			err := r.runUpdates(ctx)
+			// Transactions of a partly failed run are recorded too.
+			txs := mark.Transactions(ctx)
+			recordTransactions(ctx, &transactionRecord{TaskID: r.TaskID, TaskType: "ApplyPatches", Transactions: txs})
+			if err != nil {
+				return r.handleErrorState(ctx, fmt.Sprintf("Failed to apply patches: %s", failureMessage(err)), err)
+			}
+			cleanup := r.postPatchCleanup(ctx)
+			if before != nil {
+				r.uploadPatchReport(ctx, before, txs, cleanup)
 			}
 			if err := r.postPatchReboot(ctx); err != nil {
 				return r.handleErrorState(ctx, fmt.Sprintf("Error running postPatchReboot: %v", err), err)
@@ -292,6 +363,8 @@ func (c *Client) RunApplyPatches(ctx context.Context, task *agentendpointpb.Task
 		TaskID: task.GetTaskId(),
 		client: c,
 		Task:   &applyPatchesTask{task.GetApplyPatchesTask()},
+
+		UpdateCatalog: agentconfig.WindowsUpdateCatalog(),
 	}
 	r.setStep(prePatch)
 
@@ -20,12 +20,17 @@ package agentendpoint
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/ospatch"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/progress"
 	"github.com/GoogleCloudPlatform/osconfig/retryutil"
+	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
 )
@@ -85,7 +90,14 @@ func (r *patchTask) installWUAUpdates(ctx context.Context, cf []string) (int32,
 		clog.Infof(ctx, "Running in dryrun mode, not updating.")
 		return 0, nil
 	}
+	if err := ospatch.SystemDriveSpaceCheck(); err != nil {
+		return 0, err
+	}
 
+	// WUA installs each update synchronously, progress is counted in
+	// whole updates.
+	t := progress.Start(ctx, "wua", fmt.Sprintf("%d Windows updates", count))
+	defer t.Done()
 	for i := int32(0); i < count; i++ {
 		if err := r.reportContinuingState(ctx, agentendpointpb.ApplyPatchesTaskProgress_APPLYING_PATCHES); err != nil {
 			return i, err
@@ -96,6 +108,12 @@ func (r *patchTask) installWUAUpdates(ctx context.Context, cf []string) (int32,
 		}
 		defer updt.Release()
 
+		phase := progress.PhaseApplying
+		if title, err := updt.GetProperty("Title"); err == nil {
+			phase = title.ToString()
+		}
+		t.Update(float64(i)*100/float64(count), phase)
+
 		if err := session.InstallWUAUpdate(ctx, updt); err != nil {
 			return i, fmt.Errorf(`installUpdate(updt): %v`, err)
 		}
@@ -118,6 +136,9 @@ func (r *patchTask) wuaUpdates(ctx context.Context) error {
 			return err
 		}
 		count, err := r.installWUAUpdates(ctx, cf)
+		if util.IsInsufficientDiskSpace(err) {
+			return err
+		}
 		if err != nil {
 			clog.Errorf(ctx, "Error installing Windows updates (attempt %d): %v", i, err)
 			time.Sleep(60 * time.Second)
@@ -147,10 +168,78 @@ func (r *patchTask) runUpdates(ctx context.Context) error {
 		}
 	}
 
+	if register, ok := wantMicrosoftUpdate(r.state.Labels); ok {
+		if r.Task.GetDryRun() {
+			clog.Infof(ctx, "Running in dryrun mode, not changing the Microsoft Update registration.")
+		} else if err := packages.SetMicrosoftUpdate(ctx, register); err != nil {
+			return err
+		}
+	}
+
 	// Don't use retry function as wuaUpdates handles it's own retries.
 	if err := r.wuaUpdates(ctx); err != nil {
 		return err
 	}
 
+	if r.UpdateCatalog {
+		if err := r.catalogUpdates(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// catalogUpdates installs exclusive patches that Windows Update did not
+// install, for example because they are superseded, from the Microsoft Update
+// Catalog.
+func (r *patchTask) catalogUpdates(ctx context.Context) error {
+	for _, e := range r.Task.GetPatchConfig().GetWindowsUpdate().GetExclusivePatches() {
+		kb := "KB" + strings.TrimLeft(e, "KkBb")
+		installed, err := packages.HotFixInstalled(ctx, kb)
+		if err != nil {
+			return err
+		}
+		if installed {
+			continue
+		}
+		if r.Task.GetDryRun() {
+			clog.Infof(ctx, "Running in dryrun mode, not installing %s from the Microsoft Update Catalog.", kb)
+			continue
+		}
+		if err := r.reportContinuingState(ctx, agentendpointpb.ApplyPatchesTaskProgress_APPLYING_PATCHES); err != nil {
+			return err
+		}
+		if err := ospatch.SystemDriveSpaceCheck(); err != nil {
+			return err
+		}
+		clog.Infof(ctx, "%s was not installed by Windows Update, installing it from the Microsoft Update Catalog.", kb)
+		if err := installCatalogUpdate(ctx, kb); err != nil {
+			return fmt.Errorf("error installing %s from the Microsoft Update Catalog: %v", kb, err)
+		}
+	}
+	return nil
+}
+
+func installCatalogUpdate(ctx context.Context, kb string) error {
+	dir, err := ioutil.TempDir("", "osconfig_catalog_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	paths, err := packages.DownloadCatalogUpdate(ctx, kb, dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		install := packages.InstallMSUPackage
+		if _, isCAB := packages.IsUpdatePackage(path); isCAB {
+			install = packages.InstallCABPackage
+		}
+		if err := install(ctx, path); err != nil {
+			return err
+		}
+	}
 	return nil
 }
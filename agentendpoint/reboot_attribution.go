@@ -0,0 +1,167 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+const (
+	rebootByAgent    = "agent"
+	rebootByExternal = "external"
+)
+
+var (
+	// rebootMarkerFile is written just before the agent reboots the system.
+	rebootMarkerFile = filepath.Join(agentconfig.CacheDir(), "reboot_marker.json")
+	// lastBootFile records the boot the agent last started in.
+	lastBootFile = filepath.Join(agentconfig.CacheDir(), "last_boot_id")
+
+	currentBootID = util.BootID
+
+	lastRebootMx sync.Mutex
+	lastReboot   *rebootAttribution
+)
+
+// rebootMarker records a reboot requested by a patch task.
+type rebootMarker struct {
+	BootID string    `json:"bootId"`
+	TaskID string    `json:"taskId"`
+	Time   time.Time `json:"time"`
+}
+
+// rebootAttribution describes the reboot that preceded the current boot.
+type rebootAttribution struct {
+	// Initiator is "agent" or "external".
+	Initiator   string    `json:"initiator"`
+	TaskID      string    `json:"taskId,omitempty"`
+	RequestedAt time.Time `json:"requestedAt,omitempty"`
+	DetectedAt  time.Time `json:"detectedAt"`
+}
+
+// markAgentReboot records that taskID is about to reboot the system.
+func markAgentReboot(taskID string) error {
+	id, err := currentBootID()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&rebootMarker{BootID: id, TaskID: taskID, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(rebootMarkerFile), 0755); err != nil {
+		return err
+	}
+	return writeFile(rebootMarkerFile, data)
+}
+
+// CheckReboot logs whether the system rebooted since the agent last ran,
+// and whether the agent initiated that reboot. It should be called once
+// at startup, before any task resumes.
+func CheckReboot(ctx context.Context) {
+	id, err := currentBootID()
+	if err != nil {
+		clog.Warningf(ctx, "Unable to identify the current boot: %v", err)
+		return
+	}
+	last, err := ioutil.ReadFile(lastBootFile)
+	if err != nil && !os.IsNotExist(err) {
+		clog.Warningf(ctx, "Error reading last boot: %v", err)
+	}
+	lastID := strings.TrimSpace(string(last))
+
+	var marker *rebootMarker
+	if data, err := ioutil.ReadFile(rebootMarkerFile); err == nil {
+		marker = &rebootMarker{}
+		if err := json.Unmarshal(data, marker); err != nil {
+			clog.Warningf(ctx, "Error parsing reboot marker: %v", err)
+			marker = nil
+		}
+	}
+
+	switch {
+	case lastID == id:
+		// The agent restarted without a reboot.
+	case lastID == "":
+		// First start, or the agent state was cleared.
+	case marker != nil && marker.BootID == lastID:
+		clog.Infof(ctx, "System was rebooted by the OS Config agent for task %q at %s.", marker.TaskID, marker.Time.Format(time.RFC3339))
+		setLastReboot(&rebootAttribution{Initiator: rebootByAgent, TaskID: marker.TaskID, RequestedAt: marker.Time, DetectedAt: time.Now()})
+	default:
+		clog.Warningf(ctx, "System was rebooted since the OS Config agent last ran, the OS Config agent did not initiate this reboot.")
+		setLastReboot(&rebootAttribution{Initiator: rebootByExternal, DetectedAt: time.Now()})
+	}
+
+	if err := os.Remove(rebootMarkerFile); err != nil && !os.IsNotExist(err) {
+		clog.Warningf(ctx, "Error removing reboot marker: %v", err)
+	}
+	if lastID != id {
+		if err := os.MkdirAll(filepath.Dir(lastBootFile), 0755); err != nil {
+			clog.Warningf(ctx, "Error saving boot: %v", err)
+			return
+		}
+		if err := writeFile(lastBootFile, []byte(id)); err != nil {
+			clog.Warningf(ctx, "Error saving boot: %v", err)
+		}
+	}
+}
+
+func setLastReboot(a *rebootAttribution) {
+	lastRebootMx.Lock()
+	defer lastRebootMx.Unlock()
+	lastReboot = a
+}
+
+// rebootSince returns the reboot detected after t, if any.
+func rebootSince(t time.Time) *rebootAttribution {
+	lastRebootMx.Lock()
+	defer lastRebootMx.Unlock()
+	if lastReboot == nil || !lastReboot.DetectedAt.After(t) {
+		return nil
+	}
+	return lastReboot
+}
+
+// recordReboot adds the reboot that interrupted this task, if any, to the
+// task's reboots.
+func (r *patchTask) recordReboot(ctx context.Context) {
+	if r.StartedAt.IsZero() {
+		return
+	}
+	a := rebootSince(r.StartedAt)
+	if a == nil {
+		return
+	}
+	for _, known := range r.Reboots {
+		if known.DetectedAt.Equal(a.DetectedAt) {
+			return
+		}
+	}
+	if a.Initiator == rebootByExternal {
+		clog.Warningf(ctx, "ApplyPatchesTask was interrupted by a reboot the OS Config agent did not initiate.")
+	}
+	r.Reboots = append(r.Reboots, a)
+}
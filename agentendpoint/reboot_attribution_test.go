@@ -0,0 +1,100 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckReboot(t *testing.T) {
+	ctx := context.Background()
+	td := t.TempDir()
+	defer func(m, l string, f func() (string, error)) {
+		rebootMarkerFile, lastBootFile, currentBootID = m, l, f
+	}(rebootMarkerFile, lastBootFile, currentBootID)
+	rebootMarkerFile = filepath.Join(td, "reboot_marker.json")
+	lastBootFile = filepath.Join(td, "last_boot_id")
+	defer setLastReboot(nil)
+
+	boot := "boot-1"
+	currentBootID = func() (string, error) { return boot, nil }
+
+	// First start.
+	CheckReboot(ctx)
+	if got := rebootSince(time.Time{}); got != nil {
+		t.Fatalf("first start: got reboot %+v, want nil", got)
+	}
+
+	// Agent restart without a reboot.
+	CheckReboot(ctx)
+	if got := rebootSince(time.Time{}); got != nil {
+		t.Fatalf("restart: got reboot %+v, want nil", got)
+	}
+
+	// Reboot requested by a patch task.
+	if err := markAgentReboot("task-1"); err != nil {
+		t.Fatal(err)
+	}
+	boot = "boot-2"
+	CheckReboot(ctx)
+	got := rebootSince(time.Time{})
+	if got == nil || got.Initiator != rebootByAgent || got.TaskID != "task-1" || got.RequestedAt.IsZero() {
+		t.Fatalf("agent reboot: got %+v, want agent reboot for task-1", got)
+	}
+	if _, err := os.Stat(rebootMarkerFile); !os.IsNotExist(err) {
+		t.Errorf("reboot marker was not removed: %v", err)
+	}
+
+	// A marker left from an earlier boot does not claim this reboot.
+	if err := markAgentReboot("task-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(lastBootFile, []byte("boot-3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	boot = "boot-4"
+	CheckReboot(ctx)
+	if got := rebootSince(time.Time{}); got == nil || got.Initiator != rebootByExternal || got.TaskID != "" {
+		t.Fatalf("external reboot: got %+v, want external reboot", got)
+	}
+}
+
+func TestPatchTaskRecordReboot(t *testing.T) {
+	ctx := context.Background()
+	defer setLastReboot(nil)
+
+	started := time.Now()
+	r := &patchTask{StartedAt: started}
+
+	// A reboot before the task started is not recorded.
+	setLastReboot(&rebootAttribution{Initiator: rebootByExternal, DetectedAt: started.Add(-time.Minute)})
+	r.recordReboot(ctx)
+	if len(r.Reboots) != 0 {
+		t.Fatalf("got reboots %+v, want none", r.Reboots)
+	}
+
+	setLastReboot(&rebootAttribution{Initiator: rebootByAgent, TaskID: "foo", DetectedAt: started.Add(time.Minute)})
+	r.recordReboot(ctx)
+	// Resuming again in the same boot does not record it twice.
+	r.recordReboot(ctx)
+	if len(r.Reboots) != 1 || r.Reboots[0].Initiator != rebootByAgent {
+		t.Fatalf("got reboots %+v, want one agent reboot", r.Reboots)
+	}
+}
@@ -15,9 +15,11 @@
 package agentendpoint
 
 import (
+	"context"
 	"os/exec"
 	"syscall"
 
+	"github.com/GoogleCloudPlatform/osconfig/privhelper"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
@@ -28,15 +30,17 @@ const (
 )
 
 func rebootSystem() error {
-	// Start with systemctl and work down a list of reboot methods.
+	// Start with systemctl and work down a list of reboot methods. When the
+	// agent is not root these run through the privilege helper.
+	ctx := context.Background()
 	if e := util.Exists(systemctl); e {
-		return exec.Command(systemctl, "reboot").Start()
+		return privhelper.Command(ctx, exec.Command(systemctl, "reboot")).Start()
 	}
 	if e := util.Exists(reboot); e {
-		return exec.Command(reboot).Run()
+		return privhelper.Command(ctx, exec.Command(reboot)).Run()
 	}
 	if e := util.Exists(shutdown); e {
-		return exec.Command(shutdown, "-r", "-t", "0").Run()
+		return privhelper.Command(ctx, exec.Command(shutdown, "-r", "-t", "0")).Run()
 	}
 
 	// Fall back to reboot(2) system call
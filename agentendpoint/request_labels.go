@@ -0,0 +1,56 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// requestLabelPrefix prefixes the gRPC metadata key of each request label.
+const requestLabelPrefix = "x-osconfig-label-"
+
+var requestLabels = agentconfig.RequestLabels
+
+// requestLabelOptions add the configured request labels to every RPC, the
+// labels are read per call so metadata changes apply without a new client.
+var requestLabelOptions = []option.ClientOption{
+	option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(unaryRequestLabels)),
+	option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(streamRequestLabels)),
+}
+
+func withRequestLabels(ctx context.Context) context.Context {
+	labels := requestLabels()
+	if len(labels) == 0 {
+		return ctx
+	}
+	kv := make([]string, 0, 2*len(labels))
+	for k, v := range labels {
+		kv = append(kv, requestLabelPrefix+k, v)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+func unaryRequestLabels(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(withRequestLabels(ctx), method, req, reply, cc, opts...)
+}
+
+func streamRequestLabels(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(withRequestLabels(ctx), desc, cc, method, opts...)
+}
@@ -0,0 +1,53 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryRequestLabels(t *testing.T) {
+	defer func(f func() map[string]string) { requestLabels = f }(requestLabels)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   metadata.MD
+	}{
+		{"NoLabels", nil, nil},
+		{"Labels", map[string]string{"fleet": "web", "env": "prod"}, metadata.Pairs("x-osconfig-label-fleet", "web", "x-osconfig-label-env", "prod")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestLabels = func() map[string]string { return tt.labels }
+			var got metadata.MD
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				got, _ = metadata.FromOutgoingContext(ctx)
+				return nil
+			}
+			if err := unaryRequestLabels(context.Background(), "method", nil, nil, nil, invoker); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outgoing metadata = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
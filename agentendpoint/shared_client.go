@@ -0,0 +1,171 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"google.golang.org/grpc/connectivity"
+)
+
+var (
+	sharedClients       = &sharedPool{refs: map[sharedConn]int{}}
+	sharedBetaClients   = &sharedPool{refs: map[sharedConn]int{}}
+	sharedClientMx      sync.Mutex
+	newSharedClient     = NewClient
+	newSharedBetaClient = NewBetaClient
+)
+
+type sharedConn interface {
+	Close() error
+	Closed() bool
+	healthy() bool
+}
+
+// sharedPool holds the current shared client and counts the callers using
+// each client. A client that is replaced while in use is only closed once
+// its last caller releases it.
+type sharedPool struct {
+	current sharedConn
+	refs    map[sharedConn]int
+}
+
+// acquire returns the current client, creating it if there is none or if it
+// is no longer healthy. sharedClientMx must be held.
+func (p *sharedPool) acquire(ctx context.Context, create func(context.Context) (sharedConn, error)) (sharedConn, func(), error) {
+	if p.current != nil && !p.current.healthy() {
+		clog.Debugf(ctx, "Shared agentendpoint client is unhealthy, reconnecting.")
+		old := p.current
+		p.current = nil
+		p.closeIfUnused(old)
+	}
+	if p.current == nil {
+		c, err := create(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.current = c
+	}
+
+	c := p.current
+	p.refs[c]++
+	var once sync.Once
+	return c, func() { once.Do(func() { p.release(c) }) }, nil
+}
+
+func (p *sharedPool) release(c sharedConn) {
+	sharedClientMx.Lock()
+	defer sharedClientMx.Unlock()
+
+	p.refs[c]--
+	p.closeIfUnused(c)
+}
+
+// closeIfUnused closes c if it is no longer the current client and no caller
+// is using it. sharedClientMx must be held.
+func (p *sharedPool) closeIfUnused(c sharedConn) {
+	if c == p.current || p.refs[c] > 0 {
+		return
+	}
+	delete(p.refs, c)
+	if !c.Closed() {
+		c.Close()
+	}
+}
+
+// closeAll closes every client of the pool, in use or not.
+// sharedClientMx must be held.
+func (p *sharedPool) closeAll() {
+	if p.current != nil {
+		p.refs[p.current] = 0
+	}
+	for c := range p.refs {
+		if !c.Closed() {
+			c.Close()
+		}
+	}
+	p.current = nil
+	p.refs = map[sharedConn]int{}
+}
+
+// healthy reports whether the client can continue to be used, a client is
+// unhealthy once closed, if its connection has shut down, or if the
+// service endpoint has changed since it was created.
+func (c *Client) healthy() bool {
+	if c.Closed() || c.endpoint != agentconfig.SvcEndpoint() {
+		return false
+	}
+	if conn := c.raw.Connection(); conn != nil && conn.GetState() == connectivity.Shutdown {
+		return false
+	}
+	return true
+}
+
+func (c *BetaClient) healthy() bool {
+	if c.Closed() || c.endpoint != agentconfig.SvcEndpoint() {
+		return false
+	}
+	if conn := c.raw.Connection(); conn != nil && conn.GetState() == connectivity.Shutdown {
+		return false
+	}
+	return true
+}
+
+// SharedClient returns a lazily created Client that is shared by inventory
+// reporting and agent registration, avoiding a new TLS handshake for each
+// call. The Client is recreated if it is no longer healthy, a replaced Client
+// is closed once all its callers have released it.
+// Callers must call release when done with the Client, must not Close it or
+// use it for WaitForTaskNotification. The task notification client is not
+// shared: Close is how its stream is stopped and how the stream forces a
+// reconnect after repeated errors, which would break the calls of every
+// other user of a shared Client. It is long lived and the tasks it runs
+// report their progress over its connection.
+func SharedClient(ctx context.Context) (client *Client, release func(), err error) {
+	sharedClientMx.Lock()
+	defer sharedClientMx.Unlock()
+
+	c, release, err := sharedClients.acquire(ctx, func(ctx context.Context) (sharedConn, error) { return newSharedClient(ctx) })
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.(*Client), release, nil
+}
+
+// SharedBetaClient is like SharedClient but returns a shared BetaClient,
+// used for guest policy lookups.
+func SharedBetaClient(ctx context.Context) (client *BetaClient, release func(), err error) {
+	sharedClientMx.Lock()
+	defer sharedClientMx.Unlock()
+
+	c, release, err := sharedBetaClients.acquire(ctx, func(ctx context.Context) (sharedConn, error) { return newSharedBetaClient(ctx) })
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.(*BetaClient), release, nil
+}
+
+// CloseSharedClients closes all shared clients, it is only called at
+// shutdown.
+func CloseSharedClients() {
+	sharedClientMx.Lock()
+	defer sharedClientMx.Unlock()
+
+	sharedClients.closeAll()
+	sharedBetaClients.closeAll()
+}
@@ -0,0 +1,185 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestSharedClient(t *testing.T) {
+	ctx := context.Background()
+	srv := newAgentEndpointServiceTestServer()
+
+	var created int
+	newSharedClient = func(ctx context.Context) (*Client, error) {
+		created++
+		tc, err := newTestClient(ctx, srv)
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(tc.s.Stop)
+		return tc.client, nil
+	}
+	defer func() { newSharedClient = NewClient }()
+	defer CloseSharedClients()
+
+	c1, release1, err := SharedClient(ctx)
+	if err != nil {
+		t.Fatalf("SharedClient() error: %v", err)
+	}
+	c2, release2, err := SharedClient(ctx)
+	if err != nil {
+		t.Fatalf("SharedClient() error: %v", err)
+	}
+	if c1 != c2 || created != 1 {
+		t.Errorf("expected a single shared client, created %d clients", created)
+	}
+	release2()
+
+	// An unhealthy client is replaced, but not closed while still in use.
+	c1.endpoint = "changed.example.com"
+	c3, release3, err := SharedClient(ctx)
+	if err != nil {
+		t.Fatalf("SharedClient() error: %v", err)
+	}
+	if c3 == c1 || created != 2 {
+		t.Errorf("expected unhealthy client to be recreated, created %d clients", created)
+	}
+	if c1.Closed() {
+		t.Error("replaced client closed while in use")
+	}
+	release1()
+	release1()
+	if !c1.Closed() {
+		t.Error("expected replaced client to be closed once released")
+	}
+	release3()
+	if c3.Closed() {
+		t.Error("current shared client closed on release")
+	}
+
+	// A closed client is replaced.
+	c3.Close()
+	c4, release4, err := SharedClient(ctx)
+	if err != nil {
+		t.Fatalf("SharedClient() error: %v", err)
+	}
+	if c4 == c3 || created != 3 {
+		t.Errorf("expected closed client to be recreated, created %d clients", created)
+	}
+
+	// Shared clients are closed at shutdown even if still in use.
+	CloseSharedClients()
+	if !c4.Closed() {
+		t.Error("expected CloseSharedClients to close the shared client")
+	}
+	release4()
+}
+
+// inventoryPasses simulates n idle inventory passes, each acquiring a client,
+// making one call and releasing it, and returns the heap bytes allocated per
+// pass.
+func inventoryPasses(ctx context.Context, t *testing.T, n int, acquire func() (*Client, func())) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < n; i++ {
+		c, release := acquire()
+		if _, err := c.raw.StartNextTask(ctx, &agentendpointpb.StartNextTaskRequest{}); err != nil {
+			t.Fatalf("StartNextTask() error: %v", err)
+		}
+		release()
+	}
+	runtime.ReadMemStats(&after)
+	return (after.TotalAlloc - before.TotalAlloc) / uint64(n)
+}
+
+// settledGoroutines waits for exiting goroutines to finish and returns the
+// number still running.
+func settledGoroutines() int {
+	n := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		m := runtime.NumGoroutine()
+		if m == n {
+			break
+		}
+		n = m
+	}
+	return n
+}
+
+func TestSharedClientIdleFootprint(t *testing.T) {
+	ctx := context.Background()
+	srv := newAgentEndpointServiceTestServer()
+	const passes = 20
+
+	tc, err := newTestClient(ctx, srv)
+	if err != nil {
+		t.Fatalf("newTestClient() error: %v", err)
+	}
+	defer tc.s.Stop()
+	var created int
+	newSharedClient = func(ctx context.Context) (*Client, error) {
+		created++
+		return tc.client, nil
+	}
+	defer func() { newSharedClient = NewClient }()
+	defer CloseSharedClients()
+
+	// A client per pass, as the service loop used to do.
+	perPass := inventoryPasses(ctx, t, passes, func() (*Client, func()) {
+		c, err := newTestClient(ctx, srv)
+		if err != nil {
+			t.Fatalf("newTestClient() error: %v", err)
+		}
+		return c.client, func() {
+			c.client.Close()
+			c.s.Stop()
+		}
+	})
+
+	// The first pass dials the shared connection, later passes must reuse it
+	// without starting any goroutines of their own.
+	inventoryPasses(ctx, t, 1, func() (*Client, func()) {
+		c, release, err := SharedClient(ctx)
+		if err != nil {
+			t.Fatalf("SharedClient() error: %v", err)
+		}
+		return c, release
+	})
+	idle := settledGoroutines()
+	shared := inventoryPasses(ctx, t, passes, func() (*Client, func()) {
+		c, release, err := SharedClient(ctx)
+		if err != nil {
+			t.Fatalf("SharedClient() error: %v", err)
+		}
+		return c, release
+	})
+	if got := settledGoroutines(); got > idle {
+		t.Errorf("goroutines grew from %d to %d over %d idle inventory passes", idle, got, passes)
+	}
+	if created != 1 {
+		t.Errorf("expected one shared client for %d passes, created %d", passes, created)
+	}
+	if shared >= perPass {
+		t.Errorf("shared client allocated %d bytes per pass, want less than the %d bytes of a client per pass", shared, perPass)
+	}
+	t.Logf("heap allocated per inventory pass: %d bytes with a client per pass, %d bytes with the shared client; %d goroutines while idle", perPass, shared, idle)
+}
@@ -0,0 +1,293 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/crashreport"
+	"github.com/GoogleCloudPlatform/osconfig/tasker"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// Tasks for large fleets can carry scheduling hints as service labels, the
+// task protos have no scheduling fields. Start times are RFC 3339, the
+// stagger is a Go duration.
+const (
+	scheduleNotBeforeLabel = "schedule-not-before"
+	scheduleNotAfterLabel  = "schedule-not-after"
+	scheduleStaggerLabel   = "schedule-stagger"
+)
+
+var (
+	errOutsideWindow = errors.New("task execution window has ended")
+
+	scheduleNow = time.Now
+	// scheduleKeepAlive is how often task progress is reported while a task
+	// waits to start, so the service does not consider it lost.
+	scheduleKeepAlive = time.Minute
+	taskStagger       = agentconfig.TaskStagger
+	scheduleID        = agentconfig.ID
+
+	// configStaggered is set once an apply config task was scheduled.
+	configStaggered bool
+	// deferredTasks are the IDs of the tasks waiting for their start time.
+	deferredTasks   = map[string]bool{}
+	deferredTasksMx sync.Mutex
+)
+
+// taskSchedule is when a task may start, zero values are unset.
+type taskSchedule struct {
+	notBefore, notAfter time.Time
+	stagger             time.Duration
+}
+
+// parseTaskSchedule reads the scheduling hints of a task, invalid hints are
+// logged and ignored. The client side stagger is used if it is larger than
+// the server's.
+func parseTaskSchedule(ctx context.Context, labels map[string]string) taskSchedule {
+	var s taskSchedule
+	parseTime := func(label string) time.Time {
+		v, ok := labels[label]
+		if !ok {
+			return time.Time{}
+		}
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(v))
+		if err != nil {
+			clog.Warningf(ctx, "Ignoring invalid %s scheduling hint %q: %v", label, v, err)
+		}
+		return t
+	}
+	s.notBefore = parseTime(scheduleNotBeforeLabel)
+	s.notAfter = parseTime(scheduleNotAfterLabel)
+	if v, ok := labels[scheduleStaggerLabel]; ok {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d < 0 {
+			clog.Warningf(ctx, "Ignoring invalid %s scheduling hint %q", scheduleStaggerLabel, v)
+		} else {
+			s.stagger = d
+		}
+	}
+	if d := taskStagger(); d > s.stagger {
+		s.stagger = d
+	}
+	return s
+}
+
+// staggerOffset spreads instances over the stagger window, the offset is
+// stable for a task so a restarted agent waits for the same time.
+func staggerOffset(key string, stagger time.Duration) time.Duration {
+	if stagger <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return time.Duration(h.Sum64() % uint64(stagger))
+}
+
+// start returns when a task may start, the stagger is shrunk to fit in the
+// execution window. errOutsideWindow is returned if the window has ended.
+func (s taskSchedule) start(now time.Time, key string) (time.Time, error) {
+	start := now
+	if s.notBefore.After(start) {
+		start = s.notBefore
+	}
+	if !s.notAfter.IsZero() && !start.Before(s.notAfter) {
+		return time.Time{}, errOutsideWindow
+	}
+	stagger := s.stagger
+	if !s.notAfter.IsZero() && s.notAfter.Sub(start) < stagger {
+		stagger = s.notAfter.Sub(start)
+	}
+	return start.Add(staggerOffset(key, stagger)), nil
+}
+
+// scheduledStart returns when task may start. The service sends apply
+// config tasks periodically, only the first one after the agent starts is
+// spread over the stagger.
+func scheduledStart(ctx context.Context, task *agentendpointpb.Task) (time.Time, taskSchedule, error) {
+	s := parseTaskSchedule(ctx, task.GetServiceLabels())
+	if task.GetTaskType() == agentendpointpb.TaskType_APPLY_CONFIG_TASK {
+		if configStaggered {
+			s.stagger = 0
+		}
+		configStaggered = true
+	}
+	start, err := s.start(scheduleNow(), scheduleID()+"/"+task.GetTaskId())
+	return start, s, err
+}
+
+// checkScheduleWindow returns errOutsideWindow if the execution window of a
+// task has ended.
+func checkScheduleWindow(ctx context.Context, labels map[string]string) error {
+	s := parseTaskSchedule(ctx, labels)
+	if !s.notAfter.IsZero() && !scheduleNow().Before(s.notAfter) {
+		clog.Warningf(ctx, "Not running task, its execution window ended at %s.", s.notAfter.Format(time.RFC3339))
+		return errOutsideWindow
+	}
+	return nil
+}
+
+// startedProgress returns the STARTED progress report of a task that can be
+// deferred, or nil for other tasks.
+func startedProgress(task *agentendpointpb.Task) *agentendpointpb.ReportTaskProgressRequest {
+	req := &agentendpointpb.ReportTaskProgressRequest{TaskId: task.GetTaskId(), TaskType: task.GetTaskType()}
+	switch task.GetTaskType() {
+	case agentendpointpb.TaskType_APPLY_PATCHES:
+		req.Progress = &agentendpointpb.ReportTaskProgressRequest_ApplyPatchesTaskProgress{
+			ApplyPatchesTaskProgress: &agentendpointpb.ApplyPatchesTaskProgress{State: agentendpointpb.ApplyPatchesTaskProgress_STARTED},
+		}
+	case agentendpointpb.TaskType_APPLY_CONFIG_TASK:
+		req.Progress = &agentendpointpb.ReportTaskProgressRequest_ApplyConfigTaskProgress{
+			ApplyConfigTaskProgress: &agentendpointpb.ApplyConfigTaskProgress{State: agentendpointpb.ApplyConfigTaskProgress_STARTED},
+		}
+	default:
+		return nil
+	}
+	return req
+}
+
+// isDeferred reports whether task waits for its start time.
+func isDeferred(taskID string) bool {
+	deferredTasksMx.Lock()
+	defer deferredTasksMx.Unlock()
+	return deferredTasks[taskID]
+}
+
+func setDeferred(taskID string, deferred bool) {
+	deferredTasksMx.Lock()
+	defer deferredTasksMx.Unlock()
+	if deferred {
+		deferredTasks[taskID] = true
+	} else {
+		delete(deferredTasks, taskID)
+	}
+}
+
+// deferTask defers a patch or apply config task that may not start yet
+// because of its scheduling hints and reports whether it did. The task
+// waits outside of the tasker so other tasks keep running, and is enqueued
+// once it may start. A task whose window has ended is not deferred, it
+// reports that when it runs.
+func (c *Client) deferTask(ctx context.Context, task *agentendpointpb.Task) bool {
+	if startedProgress(task) == nil {
+		return false
+	}
+	start, s, err := scheduledStart(ctx, task)
+	if err != nil || !start.After(scheduleNow()) {
+		return false
+	}
+	taskID := task.GetTaskId()
+	clog.Infof(ctx, "Task %q deferred by scheduling constraints (not before: %s, not after: %s, stagger: %s), starting at %s.",
+		taskID, formatScheduleTime(s.notBefore), formatScheduleTime(s.notAfter), s.stagger, start.Format(time.RFC3339))
+	setDeferred(taskID, true)
+	go func() {
+		defer crashreport.Recover(ctx, "deferred task")
+		err := waitUntil(ctx, start, c.deferredKeepAlive(ctx, task))
+		if err != nil && err != errServerCancel {
+			setDeferred(taskID, false)
+			return
+		}
+		// A task stopped by the service runs right away and reports that
+		// it was canceled.
+		if err == nil && c.paceTask(ctx, task) {
+			return
+		}
+		c.enqueueDeferred(ctx, task)
+	}()
+	return true
+}
+
+// deferredKeepAlive returns a keepAlive function that reports a deferred
+// task as STARTED. It returns errServerCancel if the service stopped the
+// task.
+func (c *Client) deferredKeepAlive(ctx context.Context, task *agentendpointpb.Task) func() error {
+	progress := startedProgress(task)
+	return func() error {
+		res, err := c.reportTaskProgress(ctx, progress)
+		if err != nil {
+			clog.Warningf(ctx, "Error reporting progress of deferred task %q: %v", task.GetTaskId(), err)
+			return nil
+		}
+		if res.GetTaskDirective() == agentendpointpb.TaskDirective_STOP {
+			return errServerCancel
+		}
+		return nil
+	}
+}
+
+// enqueueDeferred enqueues a deferred task that may now start, the run task
+// loop continues once it has run.
+func (c *Client) enqueueDeferred(ctx context.Context, task *agentendpointpb.Task) {
+	taskID := task.GetTaskId()
+	tasker.Enqueue(ctx, "DeferredTask", func() {
+		// We lock so that this task will complete before the client can get canceled.
+		c.mx.Lock()
+		defer c.mx.Unlock()
+		setDeferred(taskID, false)
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		clog.Infof(ctx, "Starting deferred task %q.", taskID)
+		if c.runStartedTask(ctx, task) {
+			c.runTask(ctx)
+		}
+	})
+}
+
+// waitUntil blocks until start, see waitFor.
+func waitUntil(ctx context.Context, start time.Time, keepAlive func() error) error {
+	done := make(chan struct{})
+	timer := time.AfterFunc(start.Sub(scheduleNow()), func() { close(done) })
+	defer timer.Stop()
+	return waitFor(ctx, done, keepAlive)
+}
+
+// waitFor blocks until done is closed, calling keepAlive while it waits. An
+// error from keepAlive, such as the service stopping the task, ends the
+// wait.
+func waitFor(ctx context.Context, done <-chan struct{}, keepAlive func() error) error {
+	ticker := time.NewTicker(scheduleKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if err := keepAlive(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func formatScheduleTime(t time.Time) string {
+	if t.IsZero() {
+		return "unset"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,171 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agentendpoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestParseTaskSchedule(t *testing.T) {
+	old := taskStagger
+	defer func() { taskStagger = old }()
+	taskStagger = func() time.Duration { return 0 }
+
+	ctx := context.Background()
+	got := parseTaskSchedule(ctx, map[string]string{
+		scheduleNotBeforeLabel: "2024-05-01T10:00:00Z",
+		scheduleNotAfterLabel:  "2024-05-01T12:00:00Z",
+		scheduleStaggerLabel:   "30m",
+	})
+	want := taskSchedule{
+		notBefore: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		notAfter:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		stagger:   30 * time.Minute,
+	}
+	if !got.notBefore.Equal(want.notBefore) || !got.notAfter.Equal(want.notAfter) || got.stagger != want.stagger {
+		t.Errorf("parseTaskSchedule() = %+v, want %+v", got, want)
+	}
+
+	// Invalid hints are ignored, a larger client side stagger wins.
+	taskStagger = func() time.Duration { return time.Hour }
+	got = parseTaskSchedule(ctx, map[string]string{
+		scheduleNotBeforeLabel: "tomorrow",
+		scheduleStaggerLabel:   "-5m",
+	})
+	if !got.notBefore.IsZero() || !got.notAfter.IsZero() || got.stagger != time.Hour {
+		t.Errorf("parseTaskSchedule() with invalid hints = %+v", got)
+	}
+}
+
+func TestTaskScheduleStart(t *testing.T) {
+	now := time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		s       taskSchedule
+		min     time.Time
+		max     time.Time
+		wantErr error
+	}{
+		{"unset", taskSchedule{}, now, now, nil},
+		{"not before", taskSchedule{notBefore: now.Add(time.Hour)}, now.Add(time.Hour), now.Add(time.Hour), nil},
+		{"stagger", taskSchedule{stagger: time.Hour}, now, now.Add(time.Hour), nil},
+		{"stagger shrunk to window", taskSchedule{notAfter: now.Add(time.Minute), stagger: 24 * time.Hour}, now, now.Add(time.Minute), nil},
+		{"window ended", taskSchedule{notAfter: now.Add(-time.Minute)}, time.Time{}, time.Time{}, errOutsideWindow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.s.start(now, "123/task")
+			if err != tt.wantErr {
+				t.Fatalf("start() error = %v, want %v", err, tt.wantErr)
+			}
+			if got.Before(tt.min) || got.After(tt.max) {
+				t.Errorf("start() = %s, want between %s and %s", got, tt.min, tt.max)
+			}
+			again, _ := tt.s.start(now, "123/task")
+			if !again.Equal(got) {
+				t.Errorf("start() is not stable: %s != %s", again, got)
+			}
+		})
+	}
+}
+
+func TestStaggerOffset(t *testing.T) {
+	if got := staggerOffset("a", 0); got != 0 {
+		t.Errorf("staggerOffset() with no stagger = %s, want 0", got)
+	}
+	seen := map[time.Duration]bool{}
+	for _, key := range []string{"1/task", "2/task", "3/task", "4/task"} {
+		d := staggerOffset(key, time.Hour)
+		if d < 0 || d >= time.Hour {
+			t.Errorf("staggerOffset(%q) = %s, want in [0, 1h)", key, d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("staggerOffset() does not spread instances: %v", seen)
+	}
+}
+
+func TestScheduledStart(t *testing.T) {
+	oldNow, oldStagger, oldID, oldStaggered := scheduleNow, taskStagger, scheduleID, configStaggered
+	defer func() {
+		scheduleNow, taskStagger, scheduleID, configStaggered = oldNow, oldStagger, oldID, oldStaggered
+	}()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	scheduleNow = func() time.Time { return now }
+	taskStagger = func() time.Duration { return time.Hour }
+	scheduleID = func() string { return "123" }
+	configStaggered = false
+	ctx := context.Background()
+
+	// Only the first apply config task is staggered.
+	config := &agentendpointpb.Task{TaskId: "config", TaskType: agentendpointpb.TaskType_APPLY_CONFIG_TASK}
+	want := now.Add(staggerOffset("123/config", time.Hour))
+	if got, _, err := scheduledStart(ctx, config); err != nil || !got.Equal(want) {
+		t.Errorf("scheduledStart() of the first apply config task = %s, %v, want %s", got, err, want)
+	}
+	if got, _, err := scheduledStart(ctx, config); err != nil || !got.Equal(now) {
+		t.Errorf("scheduledStart() of a later apply config task = %s, %v, want %s", got, err, now)
+	}
+
+	// Patch tasks are always staggered.
+	patch := &agentendpointpb.Task{TaskId: "patch", TaskType: agentendpointpb.TaskType_APPLY_PATCHES}
+	want = now.Add(staggerOffset("123/patch", time.Hour))
+	for i := 0; i < 2; i++ {
+		if got, _, err := scheduledStart(ctx, patch); err != nil || !got.Equal(want) {
+			t.Errorf("scheduledStart() of a patch task = %s, %v, want %s", got, err, want)
+		}
+	}
+}
+
+func TestCheckScheduleWindow(t *testing.T) {
+	ctx := context.Background()
+	if err := checkScheduleWindow(ctx, nil); err != nil {
+		t.Errorf("checkScheduleWindow() = %v, want nil", err)
+	}
+	labels := map[string]string{scheduleNotAfterLabel: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}
+	if err := checkScheduleWindow(ctx, labels); err != errOutsideWindow {
+		t.Errorf("checkScheduleWindow() = %v, want %v", err, errOutsideWindow)
+	}
+}
+
+func TestWaitUntil(t *testing.T) {
+	oldKeepAlive := scheduleKeepAlive
+	defer func() { scheduleKeepAlive = oldKeepAlive }()
+	scheduleKeepAlive = 10 * time.Millisecond
+	ctx := context.Background()
+
+	// Progress is reported while waiting.
+	start := time.Now().Add(50 * time.Millisecond)
+	var keepAlives int
+	if err := waitUntil(ctx, start, func() error { keepAlives++; return nil }); err != nil {
+		t.Errorf("waitUntil() = %v, want nil", err)
+	}
+	if time.Now().Before(start) {
+		t.Errorf("waitUntil() returned before the task could start")
+	}
+	if keepAlives == 0 {
+		t.Errorf("no progress reported while waiting")
+	}
+
+	// The service stops the task while it waits.
+	if err := waitUntil(ctx, time.Now().Add(time.Hour), func() error { return errServerCancel }); err != errServerCancel {
+		t.Errorf("waitUntil() = %v, want %v", err, errServerCancel)
+	}
+}
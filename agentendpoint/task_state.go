@@ -20,6 +20,15 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
+)
+
+// The task state decides which patch job, with its pre and post steps, is
+// resumed after a reboot, so it is signed like the other state files.
+var (
+	readStateFile  = integrity.ReadFile
+	writeStateFile = integrity.WriteFile
 )
 
 type taskState struct {
@@ -36,7 +45,7 @@ func (s *taskState) save(path string) error {
 	}
 
 	if s == nil {
-		return writeFile(path, []byte("{}"))
+		return writeStateFile(path, []byte("{}"), 0600)
 	}
 
 	d, err := json.Marshal(s)
@@ -44,17 +53,19 @@ func (s *taskState) save(path string) error {
 		return err
 	}
 
-	return writeFile(path, d)
+	return writeStateFile(path, d, 0600)
 }
 
+// loadState loads the task state, a state file that fails verification is
+// returned as an error wrapping integrity.ErrTampered.
 func loadState(path string) (*taskState, error) {
 	// We load the current state file first, if it does not exist we try to load the old state file.
-	d, err := ioutil.ReadFile(path)
+	d, err := readStateFile(path)
 	if os.IsNotExist(err) {
 		if runtime.GOOS == "windows" {
 			return nil, nil
 		}
-		d, err = ioutil.ReadFile(oldTaskStateFile)
+		d, err = readStateFile(oldTaskStateFile)
 		if os.IsNotExist(err) {
 			return nil, nil
 		}
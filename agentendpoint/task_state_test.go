@@ -15,11 +15,15 @@
 package agentendpoint
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/protobuf/testing/protocmp"
@@ -202,3 +206,32 @@ func TestSaveLoadState(t *testing.T) {
 		t.Errorf("State does not match expectation: (-got +want)\n%s", diff)
 	}
 }
+
+func TestLoadTaskFromTamperedState(t *testing.T) {
+	oldStateFile := taskStateFile
+	defer func() { taskStateFile, readStateFile = oldStateFile, integrity.ReadFile }()
+	taskStateFile = filepath.Join(t.TempDir(), "testState")
+
+	if err := testPatchTaskState.save(taskStateFile); err != nil {
+		t.Fatal(err)
+	}
+	readStateFile = func(path string) ([]byte, error) {
+		return nil, fmt.Errorf("%s: %w", path, integrity.ErrTampered)
+	}
+	if _, err := loadState(taskStateFile); !errors.Is(err, integrity.ErrTampered) {
+		t.Fatalf("loadState() error = %v, want %v", err, integrity.ErrTampered)
+	}
+
+	// A state that does not verify is not resumed and is cleared.
+	if err := (&Client{}).loadTaskFromState(context.Background()); err != nil {
+		t.Fatalf("loadTaskFromState() error = %v, want nil", err)
+	}
+	readStateFile = integrity.ReadFile
+	st, err := loadState(taskStateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st == nil || st.PatchTask != nil {
+		t.Errorf("task state after loadTaskFromState() = %+v, want an empty state", st)
+	}
+}
@@ -0,0 +1,106 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package attributes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/retryutil"
+)
+
+// Helpers for using guest attributes as a simple signaling mechanism, for
+// example a startup script signaling that it has finished.
+
+// ErrNotFound is returned when a guest attribute does not exist.
+var ErrNotFound = errors.New("guest attribute not found")
+
+var (
+	guestAttributesURL = agentconfig.ReportURL
+
+	// signalClient bounds each metadata server request, requests are also
+	// canceled with their ctx.
+	signalClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+func attributeURL(key string) string {
+	return guestAttributesURL + "/" + strings.TrimPrefix(key, "/")
+}
+
+func doAttribute(ctx context.Context, method, url string, body io.Reader) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Metadata-Flavor", "Google")
+
+	resp, err := signalClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return string(b), nil
+	case http.StatusNotFound:
+		return "", ErrNotFound
+	default:
+		return "", fmt.Errorf(`received status code %q for request "%s %s"`+"\n Error response: %s", resp.Status, req.Method, req.URL.String(), string(b))
+	}
+}
+
+// GetAttribute gets the value of a guest attribute, ErrNotFound is returned
+// if it does not exist.
+func GetAttribute(ctx context.Context, url string) (string, error) {
+	return doAttribute(ctx, "GET", url, nil)
+}
+
+// SetAttribute sets the guest attribute key ("<namespace>/<key>") to value,
+// retrying on error for up to timeout.
+func SetAttribute(ctx context.Context, key, value string, timeout time.Duration) error {
+	return retryutil.RetryFunc(ctx, timeout, fmt.Sprintf("setting guest attribute %q", key), func() error {
+		_, err := doAttribute(ctx, "PUT", attributeURL(key), strings.NewReader(value))
+		return err
+	})
+}
+
+// WaitForAttribute polls the guest attribute key every interval until it
+// exists or ctx is done, and returns its value.
+func WaitForAttribute(ctx context.Context, key string, interval time.Duration) (string, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		v, err := GetAttribute(ctx, attributeURL(key))
+		if err == nil {
+			return v, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for guest attribute %q: %v, last error: %v", key, ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
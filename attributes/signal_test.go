@@ -0,0 +1,121 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package attributes
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newFakeGuestAttributes(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	attrs := map[string]string{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "PUT":
+			b, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				// Handlers run on their own goroutine.
+				t.Error(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			attrs[r.URL.Path] = string(b)
+		case "GET":
+			v, ok := attrs[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(v))
+		}
+	}))
+}
+
+func TestSetGetAttribute(t *testing.T) {
+	ctx := context.Background()
+	ts := newFakeGuestAttributes(t)
+	defer ts.Close()
+	old := guestAttributesURL
+	defer func() { guestAttributesURL = old }()
+	guestAttributesURL = ts.URL
+
+	if _, err := GetAttribute(ctx, attributeURL("test/key")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+	if err := SetAttribute(ctx, "test/key", "value", time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := GetAttribute(ctx, attributeURL("/test/key"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "value" {
+		t.Errorf("GetAttribute() = %q, want %q", got, "value")
+	}
+}
+
+func TestWaitForAttribute(t *testing.T) {
+	ctx := context.Background()
+	ts := newFakeGuestAttributes(t)
+	defer ts.Close()
+	old := guestAttributesURL
+	defer func() { guestAttributesURL = old }()
+	guestAttributesURL = ts.URL
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		SetAttribute(ctx, "test/done", "1", time.Second)
+	}()
+	got, err := WaitForAttribute(ctx, "test/done", 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "1" {
+		t.Errorf("WaitForAttribute() = %q, want %q", got, "1")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := WaitForAttribute(ctx, "test/never", 10*time.Millisecond); err == nil {
+		t.Error("expected error waiting for missing attribute")
+	}
+}
+
+func TestGetAttributeCanceled(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := GetAttribute(ctx, ts.URL+"/test/key"); err == nil {
+		t.Error("expected error from a canceled request")
+	}
+}
@@ -0,0 +1,114 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/bootparams"
+	"github.com/GoogleCloudPlatform/osconfig/config"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+const bootParametersUsage = "usage: boot-parameters check|enforce [present=<param>]... [absent=<param>]... [output=<path>]"
+
+// The boot-parameters command follows the ExecResource exit codes so an OS
+// policy can run it as its validate and enforce steps: check exits 100 when
+// the parameters are in the desired state and 101 when they are not,
+// enforce exits 100 on success.
+const (
+	execInDesiredState    = 100
+	execNotInDesiredState = 101
+)
+
+// bootParameters checks or enforces kernel boot parameters and returns the
+// exit code. The bootloader and whether a reboot is required are written to
+// the ExecResource result file, and with output= a summary is written to the
+// given file for use as the enforce OutputFilePath.
+func bootParameters(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	phase, spec, output, err := parseBootParametersArgs(args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+
+	var res *bootparams.Result
+	code := execInDesiredState
+	switch phase {
+	case "check":
+		var inDesiredState bool
+		inDesiredState, res, err = bootparams.Check(ctx, spec)
+		if !inDesiredState {
+			code = execNotInDesiredState
+		}
+	case "enforce":
+		res, err = bootparams.Enforce(ctx, spec)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+
+	summary := fmt.Sprintf("Boot parameters are set in the %s configuration.", res.Bootloader)
+	if code == execNotInDesiredState {
+		summary = fmt.Sprintf("Boot parameters are not set in the %s configuration.", res.Bootloader)
+	} else if res.RebootRequired {
+		summary = fmt.Sprintf("Reboot required: boot parameters are set in the %s configuration but not on the running kernel.", res.Bootloader)
+	}
+	fmt.Fprintln(stdout, summary)
+	if output != "" {
+		if err := util.AtomicWrite(output, []byte(summary+"\n"), 0644); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitFailure
+		}
+	}
+	if path := os.Getenv(config.ExecResultFileEnv); path != "" {
+		data, err := json.Marshal(res)
+		if err == nil {
+			err = util.AtomicWrite(path, data, 0600)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Error writing results: %v\n", err)
+		}
+	}
+	return code
+}
+
+func parseBootParametersArgs(args []string) (string, *bootparams.Spec, string, error) {
+	if len(args) == 0 || (args[0] != "check" && args[0] != "enforce") {
+		return "", nil, "", errors.New(bootParametersUsage)
+	}
+	spec := &bootparams.Spec{}
+	var output string
+	for _, arg := range args[1:] {
+		switch {
+		case strings.HasPrefix(arg, "present="):
+			spec.Present = append(spec.Present, strings.TrimPrefix(arg, "present="))
+		case strings.HasPrefix(arg, "absent="):
+			spec.Absent = append(spec.Absent, strings.TrimPrefix(arg, "absent="))
+		case strings.HasPrefix(arg, "output="):
+			output = strings.TrimPrefix(arg, "output=")
+		default:
+			return "", nil, "", errors.New(bootParametersUsage)
+		}
+	}
+	return args[0], spec, output, nil
+}
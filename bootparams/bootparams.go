@@ -0,0 +1,847 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package bootparams checks and sets kernel command line parameters in the
+// bootloader configuration, GRUB_CMDLINE_LINUX in /etc/default/grub for
+// grub2 or the boot loader entries for systemd-boot.
+//
+// OS policies manage boot parameters with an ExecResource that runs the
+// agent's boot-parameters command, which follows the ExecResource exit code
+// conventions:
+//
+//	validate: /usr/bin/google_osconfig_agent boot-parameters check present=console=ttyS0,115200 absent=quiet
+//	enforce:  /usr/bin/google_osconfig_agent boot-parameters enforce present=console=ttyS0,115200 absent=quiet
+//
+// A present parameter with a value replaces any other value of the same key,
+// an absent parameter without a value removes the key whatever its value.
+// The running kernel only picks up changes after a reboot, which is
+// reported in the command's bootloader and rebootRequired results and, with
+// output=<path> set to the enforce OutputFilePath, in the enforcement output.
+package bootparams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+const (
+	bootloaderGrub2       = "grub2"
+	bootloaderSystemdBoot = "systemd-boot"
+)
+
+var (
+	goos   = runtime.GOOS
+	runner = util.CommandRunner(&util.DefaultRunner{})
+
+	grubDefaultFile   = "/etc/default/grub"
+	grubDefaultDir    = "/etc/default/grub.d"
+	grubConfigFiles   = []string{"/boot/grub2/grub.cfg", "/boot/grub/grub.cfg", "/boot/efi/EFI/*/grub.cfg"}
+	grubMkconfigs     = []string{"/usr/sbin/grub2-mkconfig", "/usr/sbin/grub-mkconfig"}
+	grubby            = "/usr/sbin/grubby"
+	kernelCmdlineFile = "/etc/kernel/cmdline"
+	loaderEntriesDirs = []string{"/boot/loader/entries", "/efi/loader/entries", "/boot/efi/loader/entries"}
+	procCmdline       = "/proc/cmdline"
+
+	grubCmdlineRe = regexp.MustCompile(`^(\s*(?:export\s+)?)(GRUB_CMDLINE_LINUX(?:_DEFAULT)?|GRUB_ENABLE_BLSCFG)=(.*)$`)
+	shellVarRe    = regexp.MustCompile(`^\$(\{[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)$`)
+)
+
+// Spec is the desired state of the kernel command line.
+type Spec struct {
+	Present []string
+	Absent  []string
+}
+
+// Result is the state of the bootloader configuration after a check or
+// enforce.
+type Result struct {
+	Bootloader string `json:"bootloader"`
+	// RebootRequired is set when the configuration is in the desired state
+	// but the running kernel was booted without it.
+	RebootRequired bool `json:"rebootRequired"`
+}
+
+// splitParams splits a kernel command line into its parameters, double
+// quoted values may contain spaces.
+func splitParams(cmdline string) []string {
+	var params []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			cur.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if cur.Len() > 0 {
+				params = append(params, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		params = append(params, cur.String())
+	}
+	return params
+}
+
+func paramKey(p string) string {
+	k, _, _ := strings.Cut(p, "=")
+	return k
+}
+
+// unwanted reports whether param has to be removed to satisfy the spec,
+// either because it is absent or it sets another value for a present key.
+func (s *Spec) unwanted(param string) bool {
+	for _, a := range s.Absent {
+		if param == a || (!strings.Contains(a, "=") && paramKey(param) == a) {
+			return true
+		}
+	}
+	for _, p := range s.Present {
+		if param != p && paramKey(param) == paramKey(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// missing returns the present parameters not in params.
+func (s *Spec) missing(params []string) []string {
+	var missing []string
+	for _, p := range s.Present {
+		found := false
+		for _, param := range params {
+			if param == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// satisfiedBy reports whether the command line params are in the desired
+// state.
+func (s *Spec) satisfiedBy(params []string) bool {
+	for _, param := range params {
+		if s.unwanted(param) {
+			return false
+		}
+	}
+	return len(s.missing(params)) == 0
+}
+
+// apply returns params with the unwanted parameters removed and the missing
+// ones appended.
+func (s *Spec) apply(params []string) []string {
+	var out []string
+	for _, param := range params {
+		if !s.unwanted(param) {
+			out = append(out, param)
+		}
+	}
+	return append(out, s.missing(out)...)
+}
+
+// Validate returns an error if the spec is empty, a parameter is not a
+// single parameter or a key is set more than once.
+func (s *Spec) Validate() error {
+	if len(s.Present) == 0 && len(s.Absent) == 0 {
+		return errors.New("boot parameter resource has no present or absent parameters")
+	}
+	keys := make(map[string]bool)
+	for _, p := range s.Present {
+		if len(splitParams(p)) != 1 || splitParams(p)[0] != p {
+			return fmt.Errorf("invalid boot parameter %q", p)
+		}
+		if keys[paramKey(p)] {
+			return fmt.Errorf("boot parameter %q is set more than once", paramKey(p))
+		}
+		keys[paramKey(p)] = true
+	}
+	for _, a := range s.Absent {
+		if len(splitParams(a)) != 1 || splitParams(a)[0] != a {
+			return fmt.Errorf("invalid boot parameter %q", a)
+		}
+		if keys[paramKey(a)] {
+			return fmt.Errorf("boot parameter %q is both present and absent", paramKey(a))
+		}
+	}
+	return nil
+}
+
+func detectBootloader() (string, error) {
+	if util.Exists(grubDefaultFile) {
+		return bootloaderGrub2, nil
+	}
+	if len(loaderEntries()) > 0 {
+		return bootloaderSystemdBoot, nil
+	}
+	return "", errors.New("no supported bootloader configuration found, only grub2 and systemd-boot are supported")
+}
+
+func bootloader() (string, error) {
+	if goos != "linux" {
+		return "", fmt.Errorf("boot parameters are not supported on %s", goos)
+	}
+	return detectBootloader()
+}
+
+// runningParams returns the command line of the running kernel.
+func runningParams() ([]string, error) {
+	d, err := ioutil.ReadFile(procCmdline)
+	if err != nil {
+		return nil, err
+	}
+	return splitParams(string(d)), nil
+}
+
+// rebootRequired reports whether the running kernel was booted without the
+// desired parameters.
+func rebootRequired(ctx context.Context, spec *Spec) bool {
+	params, err := runningParams()
+	if err != nil {
+		clog.Debugf(ctx, "Error reading the running kernel command line: %v", err)
+		return false
+	}
+	return !spec.satisfiedBy(params)
+}
+
+// Check reports whether the bootloader configuration is in the desired
+// state.
+func Check(ctx context.Context, spec *Spec) (bool, *Result, error) {
+	if err := spec.Validate(); err != nil {
+		return false, nil, err
+	}
+	bl, err := bootloader()
+	if err != nil {
+		return false, nil, err
+	}
+	res := &Result{Bootloader: bl}
+	var inDesiredState bool
+	switch bl {
+	case bootloaderGrub2:
+		inDesiredState, err = checkGrub(ctx, spec)
+	case bootloaderSystemdBoot:
+		inDesiredState, err = checkSystemdBoot(spec)
+	}
+	if err != nil || !inDesiredState {
+		return false, res, err
+	}
+	res.RebootRequired = rebootRequired(ctx, spec)
+	return true, res, nil
+}
+
+// Enforce updates the bootloader configuration to the desired state.
+func Enforce(ctx context.Context, spec *Spec) (*Result, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	bl, err := bootloader()
+	if err != nil {
+		return nil, err
+	}
+	clog.Infof(ctx, "Enforcing boot parameters in the %s configuration.", bl)
+	switch bl {
+	case bootloaderGrub2:
+		err = enforceGrub(ctx, spec)
+	case bootloaderSystemdBoot:
+		err = enforceSystemdBoot(spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Bootloader: bl, RebootRequired: rebootRequired(ctx, spec)}, nil
+}
+
+// grubAssignment is a GRUB_CMDLINE_LINUX style variable assignment in a
+// grub defaults file.
+type grubAssignment struct {
+	line                 int
+	prefix, name, suffix string
+	// words are the words of the value, references to other variables
+	// such as "$GRUB_CMDLINE_LINUX" are kept as written.
+	words []grubWord
+	// opaque is set if the value uses the shell in any other way, such as
+	// a command substitution, so it can not be rewritten safely.
+	opaque  bool
+	changed bool
+}
+
+// grubWord is a kernel parameter or, if raw is set, a variable reference.
+type grubWord struct {
+	param, raw string
+}
+
+// params returns the kernel parameters the assignment sets itself.
+func (a *grubAssignment) params() []string {
+	var params []string
+	for _, w := range a.words {
+		if w.raw == "" {
+			params = append(params, w.param)
+		}
+	}
+	return params
+}
+
+// quoted returns the value double quoted, with variable references left
+// for the shell to expand.
+func (a *grubAssignment) quoted() string {
+	var words []string
+	for _, w := range a.words {
+		if w.raw != "" {
+			words = append(words, w.raw)
+		} else {
+			words = append(words, shellEscaper.Replace(w.param))
+		}
+	}
+	return `"` + strings.Join(words, " ") + `"`
+}
+
+// parseGrubValue parses the value of a grub defaults assignment into words,
+// it also returns whether the value can not be rewritten safely and
+// anything following it, such as a comment.
+func parseGrubValue(v string) ([]grubWord, bool, string, error) {
+	var inner, suffix string
+	dq := false
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := -1
+		for i := 1; i < len(v) && end < 0; i++ {
+			switch v[i] {
+			case '\\':
+				i++
+			case '"':
+				end = i
+			}
+		}
+		if end < 0 {
+			return nil, false, "", fmt.Errorf("unterminated quote in %q", v)
+		}
+		inner, suffix, dq = v[1:end], v[end+1:], true
+	case strings.HasPrefix(v, "'"):
+		// Nothing is expanded in single quotes.
+		value, suffix, err := parseShellValue(v)
+		if err != nil {
+			return nil, false, "", err
+		}
+		var words []grubWord
+		for _, p := range splitParams(value) {
+			words = append(words, grubWord{param: p})
+		}
+		return words, false, suffix, nil
+	default:
+		inner = v
+		if i := strings.IndexAny(v, " \t"); i >= 0 {
+			inner, suffix = v[:i], v[i:]
+		}
+	}
+
+	var words []grubWord
+	var raw, lit strings.Builder
+	opaque, expand, quoted := false, false, false
+	flush := func() {
+		switch {
+		case raw.Len() == 0:
+		case !expand:
+			words = append(words, grubWord{param: lit.String()})
+		default:
+			words = append(words, grubWord{raw: raw.String()})
+			if !shellVarRe.MatchString(raw.String()) {
+				opaque = true
+			}
+		}
+		raw.Reset()
+		lit.Reset()
+		expand = false
+	}
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\\' && i+1 < len(inner) && (!dq || strings.IndexByte("\"\\$`", inner[i+1]) >= 0):
+			i++
+			raw.WriteByte(c)
+			raw.WriteByte(inner[i])
+			lit.WriteByte(inner[i])
+			if inner[i] == '"' {
+				quoted = !quoted
+			}
+		case c == '$' || c == '`':
+			expand = true
+			raw.WriteByte(c)
+		case !dq && (c == '"' || c == '\''):
+			// Quoting inside an unquoted value.
+			opaque = true
+			raw.WriteByte(c)
+		case !quoted && (c == ' ' || c == '\t' || c == '\n'):
+			flush()
+		default:
+			raw.WriteByte(c)
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+	return words, opaque, suffix, nil
+}
+
+// parseShellValue parses the value of a shell variable assignment, it
+// returns the value and anything following it, such as a comment.
+func parseShellValue(v string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		var val strings.Builder
+		for i := 1; i < len(v); i++ {
+			switch v[i] {
+			case '\\':
+				if i+1 < len(v) && strings.ContainsRune("\"\\$`", rune(v[i+1])) {
+					i++
+				}
+				val.WriteByte(v[i])
+			case '"':
+				return val.String(), v[i+1:], nil
+			default:
+				val.WriteByte(v[i])
+			}
+		}
+		return "", "", fmt.Errorf("unterminated quote in %q", v)
+	case strings.HasPrefix(v, "'"):
+		end := strings.Index(v[1:], "'")
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quote in %q", v)
+		}
+		return v[1 : end+1], v[end+2:], nil
+	default:
+		if i := strings.IndexAny(v, " \t"); i >= 0 {
+			return v[:i], v[i:], nil
+		}
+		return v, "", nil
+	}
+}
+
+// shellEscaper escapes the characters that are special in double quotes.
+var shellEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+
+func quoteShellValue(v string) string {
+	return `"` + shellEscaper.Replace(v) + `"`
+}
+
+// grubDefaults is the content of the grub defaults files, in the order
+// grub-mkconfig reads them.
+type grubDefaults struct {
+	paths       []string
+	lines       [][]string
+	assignments [][]*grubAssignment
+}
+
+func grubDefaultFiles() []string {
+	files := []string{grubDefaultFile}
+	cfgs, _ := filepath.Glob(filepath.Join(grubDefaultDir, "*.cfg"))
+	sort.Strings(cfgs)
+	return append(files, cfgs...)
+}
+
+func readGrubDefaults() (*grubDefaults, error) {
+	g := &grubDefaults{}
+	for _, path := range grubDefaultFiles() {
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.Split(string(d), "\n")
+		var assignments []*grubAssignment
+		for i, line := range lines {
+			m := grubCmdlineRe.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			words, opaque, suffix, err := parseGrubValue(m[3])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s in %q: %v", m[2], path, err)
+			}
+			assignments = append(assignments, &grubAssignment{line: i, prefix: m[1], name: m[2], words: words, opaque: opaque, suffix: suffix})
+		}
+		g.paths = append(g.paths, path)
+		g.lines = append(g.lines, lines)
+		g.assignments = append(g.assignments, assignments)
+	}
+	return g, nil
+}
+
+// value returns the last value assigned to name.
+func (g *grubDefaults) value(name string) string {
+	var v string
+	for _, assignments := range g.assignments {
+		for _, a := range assignments {
+			if a.name == name {
+				v = strings.Join(a.params(), " ")
+			}
+		}
+	}
+	return v
+}
+
+// params returns the parameters of every GRUB_CMDLINE_LINUX and
+// GRUB_CMDLINE_LINUX_DEFAULT assignment.
+func (g *grubDefaults) params() []string {
+	var params []string
+	for _, assignments := range g.assignments {
+		for _, a := range assignments {
+			if a.name != "GRUB_ENABLE_BLSCFG" {
+				params = append(params, a.params()...)
+			}
+		}
+	}
+	return params
+}
+
+// apply updates the assignments to satisfy spec and returns the files to
+// write. Unwanted parameters are removed from every assignment, missing
+// parameters are added to the last GRUB_CMDLINE_LINUX assignment, which is
+// the one grub-mkconfig uses. References to other variables are kept, an
+// error is returned if an assignment that uses the shell in any other way
+// has to change.
+func (g *grubDefaults) apply(spec *Spec) ([]util.TxFile, error) {
+	var last *grubAssignment
+	lastFile := 0
+	var kept []string
+	for i, assignments := range g.assignments {
+		for _, a := range assignments {
+			if a.name == "GRUB_ENABLE_BLSCFG" {
+				continue
+			}
+			var words []grubWord
+			for _, w := range a.words {
+				if w.raw == "" && spec.unwanted(w.param) {
+					a.changed = true
+					continue
+				}
+				words = append(words, w)
+			}
+			a.words = words
+			kept = append(kept, a.params()...)
+			if a.name == "GRUB_CMDLINE_LINUX" {
+				last, lastFile = a, i
+			}
+		}
+	}
+	if missing := spec.missing(kept); len(missing) > 0 {
+		if last == nil {
+			last = &grubAssignment{line: -1, name: "GRUB_CMDLINE_LINUX"}
+		}
+		for _, p := range missing {
+			last.words = append(last.words, grubWord{param: p})
+		}
+		last.changed = true
+	}
+
+	for i, assignments := range g.assignments {
+		for _, a := range assignments {
+			if a.changed && a.opaque {
+				return nil, fmt.Errorf("%s in %q uses the shell in a way that can not be edited safely, edit line %d by hand", a.name, g.paths[i], a.line+1)
+			}
+		}
+	}
+
+	var files []util.TxFile
+	for i, path := range g.paths {
+		lines := append([]string(nil), g.lines[i]...)
+		for _, a := range g.assignments[i] {
+			if !a.changed {
+				continue
+			}
+			lines[a.line] = a.prefix + a.name + "=" + a.quoted() + a.suffix
+		}
+		if last != nil && last.line == -1 && i == lastFile {
+			line := last.name + "=" + last.quoted()
+			if n := len(lines); n > 0 && lines[n-1] == "" {
+				lines = append(lines[:n-1], line, "")
+			} else {
+				lines = append(lines, line)
+			}
+		}
+		content := strings.Join(lines, "\n")
+		if content == strings.Join(g.lines[i], "\n") {
+			continue
+		}
+		files = append(files, util.TxFile{Path: path, Content: []byte(content), Mode: fileMode(path)})
+	}
+	return files, nil
+}
+
+func fileMode(path string) os.FileMode {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.Mode().Perm()
+	}
+	return 0644
+}
+
+// blsEnabled reports whether grub2 boots from BootLoaderSpec entries, these
+// are updated with grubby as grub-mkconfig does not rewrite them.
+func (g *grubDefaults) blsEnabled() bool {
+	return g.value("GRUB_ENABLE_BLSCFG") == "true" && util.Exists(grubby)
+}
+
+// grubbyArgs returns the command line of every kernel known to grubby.
+func grubbyArgs(ctx context.Context) ([][]string, error) {
+	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, grubby, "--info=ALL"))
+	if err != nil {
+		return nil, fmt.Errorf("error running %s --info=ALL: %v, stderr: %s", grubby, err, stderr)
+	}
+	var args [][]string
+	for _, line := range strings.Split(string(stdout), "\n") {
+		if !strings.HasPrefix(line, "args=") {
+			continue
+		}
+		v, _, err := parseShellValue(strings.TrimPrefix(line, "args="))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, splitParams(v))
+	}
+	return args, nil
+}
+
+func checkGrub(ctx context.Context, spec *Spec) (bool, error) {
+	g, err := readGrubDefaults()
+	if err != nil {
+		return false, err
+	}
+	if !spec.satisfiedBy(g.params()) {
+		return false, nil
+	}
+	if !g.blsEnabled() {
+		return true, nil
+	}
+	kernels, err := grubbyArgs(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, params := range kernels {
+		if !spec.satisfiedBy(params) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func grubConfigFile() (string, error) {
+	for _, pattern := range grubConfigFiles {
+		matches, _ := filepath.Glob(pattern)
+		if len(matches) > 0 {
+			return matches[0], nil
+		}
+	}
+	return "", errors.New("no grub configuration file found")
+}
+
+func grubMkconfig() (string, error) {
+	for _, path := range grubMkconfigs {
+		if util.Exists(path) {
+			return path, nil
+		}
+	}
+	return "", errors.New("neither grub2-mkconfig nor grub-mkconfig is installed")
+}
+
+// enforceGrub updates the grub defaults files and regenerates the grub
+// configuration. grub-mkconfig only replaces the configuration once the
+// generated one passes grub-script-check, if it fails the defaults files are
+// restored so they never disagree with the configuration that will boot.
+func enforceGrub(ctx context.Context, spec *Spec) error {
+	g, err := readGrubDefaults()
+	if err != nil {
+		return err
+	}
+	mkconfig, err := grubMkconfig()
+	if err != nil {
+		return err
+	}
+	cfg, err := grubConfigFile()
+	if err != nil {
+		return err
+	}
+
+	var original []util.TxFile
+	for i, path := range g.paths {
+		original = append(original, util.TxFile{Path: path, Content: []byte(strings.Join(g.lines[i], "\n")), Mode: fileMode(path)})
+	}
+	files, err := g.apply(spec)
+	if err != nil {
+		return err
+	}
+	if err := util.AtomicWriteFiles(files); err != nil {
+		return fmt.Errorf("error writing grub defaults: %v", err)
+	}
+	if _, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, mkconfig, "-o", cfg)); err != nil {
+		if rerr := util.AtomicWriteFiles(original); rerr != nil {
+			clog.Errorf(ctx, "Error restoring grub defaults: %v", rerr)
+		}
+		return fmt.Errorf("error running %s: %v, stderr: %s", mkconfig, err, stderr)
+	}
+
+	if !g.blsEnabled() {
+		return nil
+	}
+	kernels, err := grubbyArgs(ctx)
+	if err != nil {
+		return err
+	}
+	var remove []string
+	for _, params := range kernels {
+		for _, p := range params {
+			if spec.unwanted(p) && !contains(remove, p) {
+				remove = append(remove, p)
+			}
+		}
+	}
+	args := []string{"--update-kernel=ALL"}
+	if len(spec.Present) > 0 {
+		args = append(args, "--args="+strings.Join(spec.Present, " "))
+	}
+	if len(remove) > 0 {
+		args = append(args, "--remove-args="+strings.Join(remove, " "))
+	}
+	if _, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, grubby, args...)); err != nil {
+		return fmt.Errorf("error running grubby: %v, stderr: %s", err, stderr)
+	}
+	return nil
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// loaderEntries returns the systemd-boot loader entry files.
+func loaderEntries() []string {
+	var entries []string
+	for _, dir := range loaderEntriesDirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.conf"))
+		entries = append(entries, matches...)
+	}
+	return entries
+}
+
+// loaderEntryParams returns the parameters of the options lines in a loader
+// entry.
+func loaderEntryParams(lines []string) []string {
+	var params []string
+	for _, line := range lines {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), " "); ok && k == "options" {
+			params = append(params, splitParams(v)...)
+		}
+	}
+	return params
+}
+
+// updateLoaderEntry removes unwanted parameters from every options line and
+// adds the missing ones to the last one.
+func updateLoaderEntry(lines []string, spec *Spec) []string {
+	out := append([]string(nil), lines...)
+	last := -1
+	var kept []string
+	for i, line := range out {
+		k, v, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if k != "options" {
+			continue
+		}
+		var params []string
+		for _, p := range splitParams(v) {
+			if !spec.unwanted(p) {
+				params = append(params, p)
+			}
+		}
+		out[i] = strings.TrimSpace("options " + strings.Join(params, " "))
+		kept = append(kept, params...)
+		last = i
+	}
+	missing := spec.missing(kept)
+	if len(missing) == 0 {
+		return out
+	}
+	if last == -1 {
+		if n := len(out); n > 0 && out[n-1] == "" {
+			return append(out[:n-1], "options "+strings.Join(missing, " "), "")
+		}
+		return append(out, "options "+strings.Join(missing, " "))
+	}
+	out[last] = out[last] + " " + strings.Join(missing, " ")
+	return out
+}
+
+func checkSystemdBoot(spec *Spec) (bool, error) {
+	if d, err := ioutil.ReadFile(kernelCmdlineFile); err == nil {
+		if !spec.satisfiedBy(splitParams(string(d))) {
+			return false, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	for _, entry := range loaderEntries() {
+		d, err := ioutil.ReadFile(entry)
+		if err != nil {
+			return false, err
+		}
+		if !spec.satisfiedBy(loaderEntryParams(strings.Split(string(d), "\n"))) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// enforceSystemdBoot updates /etc/kernel/cmdline, used by kernel-install for
+// new kernels, and the loader entries of the installed kernels together.
+func enforceSystemdBoot(spec *Spec) error {
+	var files []util.TxFile
+	if d, err := ioutil.ReadFile(kernelCmdlineFile); err == nil {
+		params := spec.apply(splitParams(string(d)))
+		files = append(files, util.TxFile{Path: kernelCmdlineFile, Content: []byte(strings.Join(params, " ") + "\n"), Mode: fileMode(kernelCmdlineFile)})
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range loaderEntries() {
+		d, err := ioutil.ReadFile(entry)
+		if err != nil {
+			return err
+		}
+		lines := updateLoaderEntry(strings.Split(string(d), "\n"), spec)
+		files = append(files, util.TxFile{Path: entry, Content: []byte(strings.Join(lines, "\n")), Mode: fileMode(entry)})
+	}
+	if err := util.AtomicWriteFiles(files); err != nil {
+		return fmt.Errorf("error writing boot loader entries: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package bootparams
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitParams(t *testing.T) {
+	var tests = []struct {
+		cmdline string
+		want    []string
+	}{
+		{"", nil},
+		{"BOOT_IMAGE=/vmlinuz root=UUID=abc ro  quiet\n", []string{"BOOT_IMAGE=/vmlinuz", "root=UUID=abc", "ro", "quiet"}},
+		{`dyndbg="file foo.c +p" console=ttyS0`, []string{`dyndbg="file foo.c +p"`, "console=ttyS0"}},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, splitParams(tt.cmdline)); diff != "" {
+			t.Errorf("splitParams(%q) (-want +got):\n%s", tt.cmdline, diff)
+		}
+	}
+}
+
+func TestSpec(t *testing.T) {
+	spec := &Spec{Present: []string{"console=ttyS0,115200", "nomodeset"}, Absent: []string{"quiet", "mitigations=off"}}
+	var tests = []struct {
+		params        string
+		wantSatisfied bool
+		wantApplied   string
+	}{
+		{"ro console=ttyS0,115200 nomodeset", true, "ro console=ttyS0,115200 nomodeset"},
+		{"ro console=tty0 quiet", false, "ro console=ttyS0,115200 nomodeset"},
+		{"ro quiet=1 mitigations=off nomodeset console=ttyS0,115200", false, "ro nomodeset console=ttyS0,115200"},
+		{"ro mitigations=auto nomodeset console=ttyS0,115200", true, "ro mitigations=auto nomodeset console=ttyS0,115200"},
+	}
+	for _, tt := range tests {
+		params := splitParams(tt.params)
+		if got := spec.satisfiedBy(params); got != tt.wantSatisfied {
+			t.Errorf("satisfiedBy(%q) = %t, want %t", tt.params, got, tt.wantSatisfied)
+		}
+		if got := strings.Join(spec.apply(params), " "); got != tt.wantApplied {
+			t.Errorf("apply(%q) = %q, want %q", tt.params, got, tt.wantApplied)
+		}
+	}
+
+	for _, bad := range []*Spec{
+		{},
+		{Present: []string{""}},
+		{Present: []string{"a b"}},
+		{Present: []string{"console=ttyS0", "console=tty0"}},
+		{Present: []string{"quiet"}, Absent: []string{"quiet"}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want error", bad)
+		}
+	}
+}
+
+func TestParseShellValue(t *testing.T) {
+	var tests = []struct {
+		in, wantValue, wantSuffix string
+		wantErr                   bool
+	}{
+		{`"quiet splash"`, "quiet splash", "", false},
+		{`"a \"b\" \$c" # comment`, `a "b" $c`, " # comment", false},
+		{`'quiet $x'`, "quiet $x", "", false},
+		{`quiet # comment`, "quiet", " # comment", false},
+		{`"quiet`, "", "", true},
+	}
+	for _, tt := range tests {
+		value, suffix, err := parseShellValue(tt.in)
+		if (err != nil) != tt.wantErr || value != tt.wantValue || suffix != tt.wantSuffix {
+			t.Errorf("parseShellValue(%q) = (%q, %q, %v), want (%q, %q, error %t)", tt.in, value, suffix, err, tt.wantValue, tt.wantSuffix, tt.wantErr)
+		}
+		if err == nil && strings.HasPrefix(tt.in, `"`) {
+			if got, _, _ := parseShellValue(quoteShellValue(value)); got != value {
+				t.Errorf("quoteShellValue(%q) does not round trip, got %q", value, got)
+			}
+		}
+	}
+}
+
+// setBootPaths points the bootloader paths at dir for the duration of a
+// test.
+func setBootPaths(t *testing.T, dir string) {
+	oldGoos, oldDefault, oldDefaultDir, oldConfigs, oldMkconfigs, oldGrubby, oldCmdline, oldEntries, oldProc := goos, grubDefaultFile, grubDefaultDir, grubConfigFiles, grubMkconfigs, grubby, kernelCmdlineFile, loaderEntriesDirs, procCmdline
+	t.Cleanup(func() {
+		goos, grubDefaultFile, grubDefaultDir, grubConfigFiles, grubMkconfigs, grubby, kernelCmdlineFile, loaderEntriesDirs, procCmdline = oldGoos, oldDefault, oldDefaultDir, oldConfigs, oldMkconfigs, oldGrubby, oldCmdline, oldEntries, oldProc
+	})
+	goos = "linux"
+	grubDefaultFile = filepath.Join(dir, "default", "grub")
+	grubDefaultDir = filepath.Join(dir, "default", "grub.d")
+	grubConfigFiles = []string{filepath.Join(dir, "boot", "grub.cfg")}
+	grubMkconfigs = []string{filepath.Join(dir, "grub-mkconfig")}
+	grubby = filepath.Join(dir, "grubby")
+	kernelCmdlineFile = filepath.Join(dir, "kernel", "cmdline")
+	loaderEntriesDirs = []string{filepath.Join(dir, "entries")}
+	procCmdline = filepath.Join(dir, "proc_cmdline")
+}
+
+func writeTestFile(t *testing.T, path, content string, mode os.FileMode) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(d)
+}
+
+func TestGrub(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test grub-mkconfig requires a POSIX shell")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	setBootPaths(t, dir)
+
+	writeTestFile(t, grubDefaultFile, "GRUB_TIMEOUT=5\nGRUB_CMDLINE_LINUX=\"ro quiet\" # set by the image\n", 0644)
+	writeTestFile(t, filepath.Join(grubDefaultDir, "50-cloudimg.cfg"), "GRUB_CMDLINE_LINUX_DEFAULT='console=tty1 splash'\n", 0644)
+	writeTestFile(t, grubConfigFiles[0], "old\n", 0600)
+	writeTestFile(t, procCmdline, "BOOT_IMAGE=/vmlinuz ro quiet console=tty1 splash\n", 0644)
+	// The generated configuration records the defaults it was built from.
+	mkconfig := fmt.Sprintf("#!/bin/sh\n[ \"$1\" = \"-o\" ] || exit 1\ncat %s %s/*.cfg > \"$2\"\n", grubDefaultFile, grubDefaultDir)
+	writeTestFile(t, grubMkconfigs[0], mkconfig, 0755)
+
+	spec := &Spec{Present: []string{"console=ttyS0,115200", "nomodeset"}, Absent: []string{"quiet"}}
+	inDesiredState, _, err := Check(ctx, spec)
+	if err != nil {
+		t.Fatalf("unexpected Check error: %v", err)
+	}
+	if inDesiredState {
+		t.Error("expected parameters to not be in desired state")
+	}
+	res, err := Enforce(ctx, spec)
+	if err != nil {
+		t.Fatalf("unexpected Enforce error: %v", err)
+	}
+	if inDesiredState, _, err := Check(ctx, spec); err != nil || !inDesiredState {
+		t.Errorf("Check() after Enforce = %t, %v, want true, nil", inDesiredState, err)
+	}
+
+	wantDefault := "GRUB_TIMEOUT=5\nGRUB_CMDLINE_LINUX=\"ro console=ttyS0,115200 nomodeset\" # set by the image\n"
+	// Other values of a present key are removed from every assignment.
+	wantCfg := "GRUB_CMDLINE_LINUX_DEFAULT=\"splash\"\n"
+	if got := readTestFile(t, grubDefaultFile); got != wantDefault {
+		t.Errorf("grub defaults = %q, want %q", got, wantDefault)
+	}
+	if got := readTestFile(t, filepath.Join(grubDefaultDir, "50-cloudimg.cfg")); got != wantCfg {
+		t.Errorf("grub.d config = %q, want %q", got, wantCfg)
+	}
+	if got := readTestFile(t, grubConfigFiles[0]); got != wantDefault+wantCfg {
+		t.Errorf("grub.cfg = %q, want %q", got, wantDefault+wantCfg)
+	}
+
+	if diff := cmp.Diff(&Result{Bootloader: "grub2", RebootRequired: true}, res); diff != "" {
+		t.Errorf("unexpected result (-want +got):\n%s", diff)
+	}
+
+	// After a reboot the parameters are in the desired state and no reboot
+	// is required.
+	writeTestFile(t, procCmdline, "BOOT_IMAGE=/vmlinuz ro console=ttyS0,115200 nomodeset splash\n", 0644)
+	inDesiredState, res, err = Check(ctx, spec)
+	if err != nil {
+		t.Fatalf("unexpected Check error: %v", err)
+	}
+	if !inDesiredState || res.RebootRequired {
+		t.Errorf("Check() = %t, %+v, want in desired state without a reboot required", inDesiredState, res)
+	}
+}
+
+func TestGrubMkconfigFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test grub-mkconfig requires a POSIX shell")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	setBootPaths(t, dir)
+
+	const defaults = "GRUB_CMDLINE_LINUX=\"ro quiet\"\n"
+	writeTestFile(t, grubDefaultFile, defaults, 0644)
+	writeTestFile(t, grubConfigFiles[0], "old\n", 0600)
+	writeTestFile(t, grubMkconfigs[0], "#!/bin/sh\necho syntax error >&2\nexit 1\n", 0755)
+
+	if _, err := Enforce(ctx, &Spec{Absent: []string{"quiet"}}); err == nil {
+		t.Error("expected Enforce error when grub-mkconfig fails")
+	}
+	if got := readTestFile(t, grubDefaultFile); got != defaults {
+		t.Errorf("grub defaults = %q, want them restored to %q", got, defaults)
+	}
+	if got := readTestFile(t, grubConfigFiles[0]); got != "old\n" {
+		t.Errorf("grub.cfg = %q, want it unchanged", got)
+	}
+}
+
+func TestGrubVariableReferences(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test grub-mkconfig requires a POSIX shell")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	setBootPaths(t, dir)
+
+	cfg := filepath.Join(grubDefaultDir, "50-cloudimg.cfg")
+	writeTestFile(t, grubDefaultFile, "GRUB_CMDLINE_LINUX=\"ro quiet\"\n", 0644)
+	writeTestFile(t, cfg, "GRUB_CMDLINE_LINUX_DEFAULT=\"$GRUB_CMDLINE_LINUX_DEFAULT console=tty1 quiet\"\nGRUB_CMDLINE_LINUX=\"${GRUB_CMDLINE_LINUX} rd.lvm=\\\"a b\\\"\"\n", 0644)
+	writeTestFile(t, grubConfigFiles[0], "old\n", 0600)
+	writeTestFile(t, procCmdline, "BOOT_IMAGE=/vmlinuz ro quiet console=tty1\n", 0644)
+	writeTestFile(t, grubMkconfigs[0], "#!/bin/sh\n[ \"$1\" = \"-o\" ] || exit 1\necho new > \"$2\"\n", 0755)
+
+	if _, err := Enforce(ctx, &Spec{Present: []string{"nomodeset"}, Absent: []string{"quiet"}}); err != nil {
+		t.Fatalf("unexpected Enforce error: %v", err)
+	}
+	want := "GRUB_CMDLINE_LINUX_DEFAULT=\"$GRUB_CMDLINE_LINUX_DEFAULT console=tty1\"\nGRUB_CMDLINE_LINUX=\"${GRUB_CMDLINE_LINUX} rd.lvm=\\\"a b\\\" nomodeset\"\n"
+	if got := readTestFile(t, cfg); got != want {
+		t.Errorf("grub.d config = %q, want %q", got, want)
+	}
+
+	// Values that use the shell in other ways are not rewritten.
+	const opaque = "GRUB_CMDLINE_LINUX=\"ro quiet $(cat /etc/cmdline)\"\n"
+	writeTestFile(t, cfg, opaque, 0644)
+	if _, err := Enforce(ctx, &Spec{Absent: []string{"quiet"}}); err == nil {
+		t.Error("expected Enforce error for a command substitution")
+	}
+	if got := readTestFile(t, cfg); got != opaque {
+		t.Errorf("grub.d config = %q, want it unchanged", got)
+	}
+}
+
+func TestSystemdBoot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	setBootPaths(t, dir)
+
+	entry := filepath.Join(loaderEntriesDirs[0], "abc-6.1.0.conf")
+	writeTestFile(t, kernelCmdlineFile, "root=UUID=abc ro quiet\n", 0644)
+	writeTestFile(t, entry, "title Linux\nlinux /vmlinuz-6.1.0\noptions root=UUID=abc ro quiet\n", 0644)
+	writeTestFile(t, procCmdline, "root=UUID=abc ro quiet\n", 0644)
+
+	spec := &Spec{Present: []string{"console=ttyS0"}, Absent: []string{"quiet"}}
+	inDesiredState, _, err := Check(ctx, spec)
+	if err != nil {
+		t.Fatalf("unexpected Check error: %v", err)
+	}
+	if inDesiredState {
+		t.Error("expected parameters to not be in desired state")
+	}
+	res, err := Enforce(ctx, spec)
+	if err != nil {
+		t.Fatalf("unexpected Enforce error: %v", err)
+	}
+
+	if got, want := readTestFile(t, kernelCmdlineFile), "root=UUID=abc ro console=ttyS0\n"; got != want {
+		t.Errorf("kernel cmdline = %q, want %q", got, want)
+	}
+	if got, want := readTestFile(t, entry), "title Linux\nlinux /vmlinuz-6.1.0\noptions root=UUID=abc ro console=ttyS0\n"; got != want {
+		t.Errorf("loader entry = %q, want %q", got, want)
+	}
+	if res.Bootloader != "systemd-boot" || !res.RebootRequired {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if inDesiredState, _, err := Check(ctx, spec); err != nil || !inDesiredState {
+		t.Errorf("Check() after Enforce = %t, %v, want true, nil", inDesiredState, err)
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	setBootPaths(t, dir)
+
+	// No bootloader configuration.
+	if _, _, err := Check(ctx, &Spec{Absent: []string{"quiet"}}); err == nil {
+		t.Error("expected Check error without a bootloader")
+	}
+
+	writeTestFile(t, grubDefaultFile, "", 0644)
+	for _, spec := range []*Spec{{}, {Present: []string{"a b"}}} {
+		if _, _, err := Check(ctx, spec); err == nil {
+			t.Errorf("expected Check error for spec %+v", spec)
+		}
+	}
+
+	goos = "windows"
+	if _, _, err := Check(ctx, &Spec{Absent: []string{"quiet"}}); err == nil {
+		t.Error("expected Check error on windows")
+	}
+}
@@ -0,0 +1,155 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package changefreeze reads the change freeze calendar. During a freeze,
+// such as a year-end freeze, the agent still checks and reports the state
+// of the instance but does not patch it or enforce configuration.
+//
+// The calendar is read from a local file, controlled by the host
+// administrator, and from the osconfig-change-freeze metadata key. Both use
+// the same format and a freeze in either applies:
+//
+//	{
+//	  "windows": [
+//	    {"start": "2024-12-20T00:00:00Z", "end": "2025-01-06T00:00:00Z", "reason": "year-end freeze", "yearly": true}
+//	  ]
+//	}
+package changefreeze
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+var (
+	calendarFile     = agentconfig.ChangeFreezeFile()
+	calendarMetadata = agentconfig.ChangeFreeze
+
+	now = time.Now
+)
+
+// Window is a period during which no changes are made.
+type Window struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+	// Yearly windows repeat every year on the same dates.
+	Yearly bool `json:"yearly,omitempty"`
+}
+
+type calendar struct {
+	Windows []*Window `json:"windows"`
+}
+
+// FrozenError is returned by Check during a change freeze.
+type FrozenError struct {
+	Window *Window
+}
+
+func (e *FrozenError) Error() string {
+	reason := e.Window.Reason
+	if reason == "" {
+		reason = "no reason given"
+	}
+	return fmt.Sprintf("change freeze in effect until %s (%s)", e.Window.End.Format(time.RFC3339), reason)
+}
+
+func parseCalendar(data []byte) ([]*Window, error) {
+	var c calendar
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	for i, w := range c.Windows {
+		if w.Start.IsZero() || w.End.IsZero() {
+			return nil, fmt.Errorf("window %d: start and end are required", i)
+		}
+		if !w.End.After(w.Start) {
+			return nil, fmt.Errorf("window %d: end %s is not after start %s", i, w.End.Format(time.RFC3339), w.Start.Format(time.RFC3339))
+		}
+		if w.Yearly && w.End.After(w.Start.AddDate(1, 0, 0)) {
+			return nil, fmt.Errorf("window %d: a yearly window can't be longer than a year", i)
+		}
+	}
+	return c.Windows, nil
+}
+
+// occurrence returns the occurrence of w that contains t, if any. A yearly
+// window that contains t started this year or, if it spans the new year,
+// the year before.
+func (w *Window) occurrence(t time.Time) *Window {
+	if !w.Yearly {
+		if !t.Before(w.Start) && t.Before(w.End) {
+			return w
+		}
+		return nil
+	}
+	length := w.End.Sub(w.Start)
+	for _, year := range []int{t.Year() - 1, t.Year()} {
+		start := w.Start.AddDate(year-w.Start.Year(), 0, 0)
+		if end := start.Add(length); !t.Before(start) && t.Before(end) {
+			return &Window{Start: start, End: end, Reason: w.Reason, Yearly: true}
+		}
+	}
+	return nil
+}
+
+// active returns the active window that ends last.
+func active(windows []*Window, t time.Time) *Window {
+	var found *Window
+	for _, w := range windows {
+		if o := w.occurrence(t); o != nil && (found == nil || o.End.After(found.End)) {
+			found = o
+		}
+	}
+	return found
+}
+
+// load reads the local and metadata calendars. A calendar that can't be
+// parsed is logged and ignored, so a typo does not block changes
+// indefinitely.
+func load(ctx context.Context) []*Window {
+	var windows []*Window
+	if data, err := os.ReadFile(calendarFile); err == nil {
+		w, err := parseCalendar(data)
+		if err != nil {
+			clog.Errorf(ctx, "Ignoring invalid change freeze calendar %q: %v", calendarFile, err)
+		}
+		windows = append(windows, w...)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		clog.Errorf(ctx, "Error reading change freeze calendar %q: %v", calendarFile, err)
+	}
+	if md := calendarMetadata(); md != "" {
+		w, err := parseCalendar([]byte(md))
+		if err != nil {
+			clog.Errorf(ctx, "Ignoring invalid osconfig-change-freeze metadata: %v", err)
+		}
+		windows = append(windows, w...)
+	}
+	return windows
+}
+
+// Check returns a *FrozenError if a change freeze is in effect.
+func Check(ctx context.Context) error {
+	if w := active(load(ctx), now()); w != nil {
+		return &FrozenError{Window: w}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package changefreeze
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func TestParseCalendar(t *testing.T) {
+	windows, err := parseCalendar([]byte(`{"windows": [{"start": "2024-12-20T00:00:00Z", "end": "2025-01-06T00:00:00Z", "reason": "year-end", "yearly": true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 1 || windows[0].Reason != "year-end" || !windows[0].Yearly {
+		t.Errorf("parseCalendar() = %+v, want the year-end window", windows)
+	}
+
+	for _, bad := range []string{
+		`not json`,
+		`{"windows": [{"start": "2024-12-20T00:00:00Z"}]}`,
+		`{"windows": [{"start": "2024-12-20T00:00:00Z", "end": "2024-12-19T00:00:00Z"}]}`,
+		`{"windows": [{"start": "2024-12-20T00:00:00Z", "end": "2026-01-01T00:00:00Z", "yearly": true}]}`,
+	} {
+		if _, err := parseCalendar([]byte(bad)); err == nil {
+			t.Errorf("parseCalendar(%q) did not return an error", bad)
+		}
+	}
+}
+
+func TestActive(t *testing.T) {
+	windows := []*Window{
+		{Start: mustTime(t, "2023-12-20T00:00:00Z"), End: mustTime(t, "2024-01-06T00:00:00Z"), Reason: "year-end", Yearly: true},
+		{Start: mustTime(t, "2024-06-01T00:00:00Z"), End: mustTime(t, "2024-06-03T00:00:00Z"), Reason: "migration"},
+		{Start: mustTime(t, "2024-06-02T00:00:00Z"), End: mustTime(t, "2024-06-10T00:00:00Z"), Reason: "audit"},
+	}
+	tests := []struct {
+		now        string
+		wantReason string
+		wantEnd    string
+	}{
+		{"2024-12-19T23:59:59Z", "", ""},
+		{"2024-12-20T00:00:00Z", "year-end", "2025-01-06T00:00:00Z"},
+		{"2026-01-05T12:00:00Z", "year-end", "2026-01-06T00:00:00Z"},
+		{"2026-01-06T00:00:00Z", "", ""},
+		{"2024-06-01T12:00:00Z", "migration", "2024-06-03T00:00:00Z"},
+		// Overlapping windows report the one that ends last.
+		{"2024-06-02T12:00:00Z", "audit", "2024-06-10T00:00:00Z"},
+		{"2025-06-02T12:00:00Z", "", ""},
+	}
+	for _, tt := range tests {
+		got := active(windows, mustTime(t, tt.now))
+		if tt.wantReason == "" {
+			if got != nil {
+				t.Errorf("active(%s) = %+v, want nil", tt.now, got)
+			}
+			continue
+		}
+		if got == nil || got.Reason != tt.wantReason || !got.End.Equal(mustTime(t, tt.wantEnd)) {
+			t.Errorf("active(%s) = %+v, want %q ending %s", tt.now, got, tt.wantReason, tt.wantEnd)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	oldFile, oldMetadata, oldNow := calendarFile, calendarMetadata, now
+	defer func() { calendarFile, calendarMetadata, now = oldFile, oldMetadata, oldNow }()
+	calendarFile = filepath.Join(t.TempDir(), "change_freeze.json")
+	md := ""
+	calendarMetadata = func() string { return md }
+	now = func() time.Time { return mustTime(t, "2024-12-24T00:00:00Z") }
+	ctx := context.Background()
+
+	if err := Check(ctx); err != nil {
+		t.Errorf("Check() without a calendar = %v, want nil", err)
+	}
+
+	if err := os.WriteFile(calendarFile, []byte(`{"windows": [{"start": "2024-12-20T00:00:00Z", "end": "2025-01-06T00:00:00Z", "reason": "year-end"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := Check(ctx)
+	var fe *FrozenError
+	if !errors.As(err, &fe) {
+		t.Fatalf("Check() with a local calendar = %v, want a FrozenError", err)
+	}
+	if want := "change freeze in effect until 2025-01-06T00:00:00Z (year-end)"; err.Error() != want {
+		t.Errorf("Check() = %q, want %q", err, want)
+	}
+
+	// An invalid metadata calendar does not hide the local one.
+	md = "{"
+	if err := Check(ctx); err == nil {
+		t.Error("Check() with invalid metadata did not return the local freeze")
+	}
+
+	if err := os.Remove(calendarFile); err != nil {
+		t.Fatal(err)
+	}
+	md = `{"windows": [{"start": "2024-12-23T00:00:00Z", "end": "2024-12-27T00:00:00Z"}]}`
+	if err := Check(ctx); err == nil || !strings.Contains(err.Error(), "no reason given") {
+		t.Errorf("Check() with a metadata calendar = %v, want a freeze without a reason", err)
+	}
+}
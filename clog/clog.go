@@ -20,14 +20,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 	"github.com/GoogleCloudPlatform/osconfig/pretty"
 	"google.golang.org/protobuf/proto"
 )
 
-// DebugEnabled will log debug messages.
-var DebugEnabled bool
+// debugEnabled will log debug messages, runtime Settings take precedence.
+var debugEnabled atomic.Bool
+
+// DebugEnabled reports whether the metadata driven debug logging is enabled.
+func DebugEnabled() bool {
+	return debugEnabled.Load()
+}
+
+// SetDebugEnabled enables or disables the metadata driven debug logging,
+// runtime Settings take precedence.
+func SetDebugEnabled(enabled bool) {
+	debugEnabled.Store(enabled)
+	ApplyDebugLogging()
+}
 
 // https://golang.org/pkg/context/#WithValue
 type clogKey struct{}
@@ -41,6 +54,9 @@ type log struct {
 }
 
 func (l *log) log(structuredPayload any, msg string, sev logger.Severity) {
+	if sev < minSeverity(l.labels) {
+		return
+	}
 	// Set CallDepth 3, one for logger.Log, one for this function, and one for
 	// the calling clog function.
 	logger.Log(logger.LogEntry{Message: msg, StructuredPayload: structuredPayload, Severity: sev, CallDepth: 3, Labels: l.labels})
@@ -62,7 +78,7 @@ func protoToJSON(p proto.Message) any {
 // DebugRPC logs a completed RPC call.
 func DebugRPC(ctx context.Context, method string, req proto.Message, resp proto.Message) {
 	// Do this here so we don't spend resources building the log message if we don't need to.
-	if !DebugEnabled || (req == nil && resp == nil) {
+	if (req == nil && resp == nil) || !traceRPC(ctx) {
 		return
 	}
 	// The Cloud Logging library doesn't handle proto messages nor structures containing generic JSON.
@@ -91,6 +107,18 @@ func DebugStructured(ctx context.Context, structuredPayload any, format string,
 	fromContext(ctx).log(structuredPayload, fmt.Sprintf(format, args...), logger.Debug)
 }
 
+// InfoStructured is like Infof but sends structuredPayload instead of the text message
+// to Cloud Logging.
+func InfoStructured(ctx context.Context, structuredPayload any, format string, args ...any) {
+	fromContext(ctx).log(structuredPayload, fmt.Sprintf(format, args...), logger.Info)
+}
+
+// ErrorStructured is like Errorf but sends structuredPayload instead of the text message
+// to Cloud Logging.
+func ErrorStructured(ctx context.Context, structuredPayload any, format string, args ...any) {
+	fromContext(ctx).log(structuredPayload, fmt.Sprintf(format, args...), logger.Error)
+}
+
 // Debugf simulates logger.Debugf and adds context labels.
 func Debugf(ctx context.Context, format string, args ...any) {
 	fromContext(ctx).log(nil, fmt.Sprintf(format, args...), logger.Debug)
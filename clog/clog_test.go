@@ -16,8 +16,11 @@ package clog
 
 import (
 	"context"
+	"math/rand"
 	"testing"
+	"time"
 
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -44,3 +47,81 @@ func TestWithLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestSettings(t *testing.T) {
+	defer SetSettings(nil)
+	defer SetDebugEnabled(DebugEnabled())
+	SetDebugEnabled(false)
+	patch := WithSubsystem(context.Background(), "patch")
+	other := WithSubsystem(context.Background(), "inventory")
+
+	if traceRPC(patch) {
+		t.Error("traceRPC() without settings or DebugEnabled = true, want false")
+	}
+	if got := minSeverity(fromContext(patch).labels); got != logger.Debug {
+		t.Errorf("minSeverity() without settings = %v, want Debug", got)
+	}
+
+	if err := SetSettings(&Settings{Subsystems: map[string]string{"patch": "DEBUG", "exec": "error"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !traceRPC(patch) || traceRPC(other) {
+		t.Errorf("traceRPC(patch) = %t, traceRPC(inventory) = %t, want true, false", traceRPC(patch), traceRPC(other))
+	}
+	if got := minSeverity(fromContext(other).labels); got != logger.Info {
+		t.Errorf("minSeverity(inventory) = %v, want Info", got)
+	}
+	if got := minSeverity(map[string]string{subsystemLabel: "exec"}); got != logger.Error {
+		t.Errorf("minSeverity(exec) = %v, want Error", got)
+	}
+	if !debugWanted() {
+		t.Error("debugWanted() with a debug subsystem = false, want true")
+	}
+
+	sampleRand = func() float64 { return 0.6 }
+	defer func() { sampleRand = rand.Float64 }()
+	if err := SetSettings(&Settings{Debug: true, TraceSampleRate: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if traceRPC(other) {
+		t.Error("traceRPC() above the sample rate = true, want false")
+	}
+	sampleRand = func() float64 { return 0.4 }
+	if !traceRPC(other) {
+		t.Error("traceRPC() below the sample rate = false, want true")
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	if err := SetSettings(&Settings{Debug: true, Expires: &expired}); err != nil {
+		t.Fatal(err)
+	}
+	if CurrentSettings() != nil || debugWanted() {
+		t.Error("expired settings still apply")
+	}
+
+	// Debug logging is switched off once the settings expire.
+	logged := make(chan bool, 10)
+	setDebugLogging = func(enabled bool) { logged <- enabled }
+	defer func() { setDebugLogging = logger.SetDebugLogging }()
+	expires := time.Now().Add(20 * time.Millisecond)
+	if err := SetSettings(&Settings{Debug: true, Expires: &expires}); err != nil {
+		t.Fatal(err)
+	}
+	if enabled := <-logged; !enabled {
+		t.Error("debug logging not enabled by the settings")
+	}
+	select {
+	case enabled := <-logged:
+		if enabled {
+			t.Error("debug logging still enabled after the settings expired")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("debug logging not switched off when the settings expired")
+	}
+
+	for _, s := range []*Settings{{TraceSampleRate: 2}, {Subsystems: map[string]string{"patch": "verbose"}}} {
+		if err := SetSettings(s); err == nil {
+			t.Errorf("SetSettings(%+v) = nil, want error", s)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package clog
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
+)
+
+// subsystemLabel is the log label holding the subsystem set by WithSubsystem.
+const subsystemLabel = "subsystem"
+
+// Settings are log settings changed at runtime, usually through the local
+// API, they take precedence over the metadata driven DebugEnabled until they
+// expire or are cleared. Settings in effect must not be modified.
+type Settings struct {
+	// Debug enables debug logging for all subsystems.
+	Debug bool `json:"debug"`
+	// TraceSampleRate is the fraction of RPCs logged by DebugRPC while debug
+	// logging is enabled, 0 is treated as 1.
+	TraceSampleRate float64 `json:"traceSampleRate,omitempty"`
+	// Subsystems sets the minimum level ("debug", "info", "warning" or
+	// "error") of individual subsystems, overriding Debug. The agent uses
+	// the subsystems "patch", "exec", "config", "inventory" and "policies".
+	Subsystems map[string]string `json:"subsystems,omitempty"`
+	// Expires is when the settings stop applying, nil never expires.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+var levels = map[string]logger.Severity{
+	"debug":   logger.Debug,
+	"info":    logger.Info,
+	"warning": logger.Warning,
+	"error":   logger.Error,
+}
+
+var (
+	// settings is read on every log line, it holds an immutable copy.
+	settings atomic.Pointer[Settings]
+	// expiryMx guards expiry, the timer that turns debug logging off once
+	// the settings expire.
+	expiryMx sync.Mutex
+	expiry   *time.Timer
+
+	now             = time.Now
+	sampleRand      = rand.Float64
+	setDebugLogging = logger.SetDebugLogging
+)
+
+// Validate checks the subsystem levels and sample rate.
+func (s *Settings) Validate() error {
+	if s.TraceSampleRate < 0 || s.TraceSampleRate > 1 {
+		return fmt.Errorf("trace sample rate must be between 0 and 1, got %v", s.TraceSampleRate)
+	}
+	for name, lvl := range s.Subsystems {
+		if _, ok := levels[strings.ToLower(lvl)]; !ok {
+			return fmt.Errorf("unknown level %q for subsystem %q", lvl, name)
+		}
+	}
+	return nil
+}
+
+// SetSettings replaces the runtime log settings, nil clears them so that
+// only DebugEnabled applies. The change takes effect immediately.
+func SetSettings(s *Settings) error {
+	if s != nil {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		c := *s
+		c.Subsystems = map[string]string{}
+		for name, lvl := range s.Subsystems {
+			c.Subsystems[name] = strings.ToLower(lvl)
+		}
+		s = &c
+	}
+	expiryMx.Lock()
+	defer expiryMx.Unlock()
+	settings.Store(s)
+	if expiry != nil {
+		expiry.Stop()
+		expiry = nil
+	}
+	if s != nil && s.Expires != nil {
+		if d := s.Expires.Sub(now()); d > 0 {
+			// Logging falls back to DebugEnabled on expiry, which otherwise
+			// only happens when the agent config next changes.
+			expiry = time.AfterFunc(d, ApplyDebugLogging)
+		}
+	}
+	ApplyDebugLogging()
+	return nil
+}
+
+// CurrentSettings returns the runtime log settings in effect, nil if there
+// are none or they have expired. The returned Settings must not be
+// modified.
+func CurrentSettings() *Settings {
+	s := settings.Load()
+	if s == nil || (s.Expires != nil && !now().Before(*s.Expires)) {
+		return nil
+	}
+	return s
+}
+
+// ApplyDebugLogging enables debug output in the underlying logger if
+// DebugEnabled or any runtime setting needs it, messages from subsystems
+// that don't are filtered here. It should be called after DebugEnabled is
+// changed.
+func ApplyDebugLogging() {
+	setDebugLogging(debugWanted())
+}
+
+func debugWanted() bool {
+	s := CurrentSettings()
+	if s == nil {
+		return DebugEnabled()
+	}
+	if s.Debug {
+		return true
+	}
+	for _, lvl := range s.Subsystems {
+		if lvl == "debug" {
+			return true
+		}
+	}
+	return false
+}
+
+// minSeverity returns the lowest severity logged for the given labels,
+// without runtime settings filtering is left to the logger.
+func minSeverity(labels map[string]string) logger.Severity {
+	s := CurrentSettings()
+	if s == nil {
+		return logger.Debug
+	}
+	if lvl, ok := s.Subsystems[labels[subsystemLabel]]; ok {
+		return levels[lvl]
+	}
+	if s.Debug {
+		return logger.Debug
+	}
+	return logger.Info
+}
+
+// traceRPC reports whether an RPC made with ctx should be logged.
+func traceRPC(ctx context.Context) bool {
+	s := CurrentSettings()
+	if s == nil {
+		return DebugEnabled()
+	}
+	if minSeverity(fromContext(ctx).labels) > logger.Debug {
+		return false
+	}
+	if s.TraceSampleRate == 0 || s.TraceSampleRate == 1 {
+		return true
+	}
+	return sampleRand() < s.TraceSampleRate
+}
+
+// WithSubsystem labels the log context with the subsystem name so its level
+// can be set separately through Settings.
+func WithSubsystem(ctx context.Context, name string) context.Context {
+	return WithLabels(ctx, map[string]string{subsystemLabel: name})
+}
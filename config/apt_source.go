@@ -0,0 +1,165 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// aptSourceOptions are the sources.list options that may be set on an apt
+// repository by prefixing its URI with an option block, for example
+// "[arch=amd64,arm64] https://repo1 https://repo2".
+var aptSourceOptions = map[string]bool{
+	"arch":              true,
+	"lang":              true,
+	"target":            true,
+	"pdiffs":            true,
+	"by-hash":           true,
+	"signed-by":         true,
+	"trusted":           true,
+	"check-valid-until": true,
+}
+
+// deb822Fields are the deb822 fields of the aptSourceOptions.
+var deb822Fields = map[string]string{
+	"arch":              "Architectures",
+	"lang":              "Languages",
+	"target":            "Targets",
+	"pdiffs":            "PDiffs",
+	"by-hash":           "By-Hash",
+	"signed-by":         "Signed-By",
+	"trusted":           "Trusted",
+	"check-valid-until": "Check-Valid-Until",
+}
+
+// aptSource is a parsed apt repository URI field.
+type aptSource struct {
+	options []string
+	uris    []string
+}
+
+// splitOptionBlock splits a leading "[...]" option block from a repository
+// URI field, returning the options and the remainder of the field.
+func splitOptionBlock(field string) ([]string, string, error) {
+	field = strings.TrimSpace(field)
+	if !strings.HasPrefix(field, "[") {
+		return nil, field, nil
+	}
+	end := strings.Index(field, "]")
+	if end < 0 {
+		return nil, "", fmt.Errorf("unterminated option block in repository uri %q", field)
+	}
+	return strings.Fields(field[1:end]), strings.TrimSpace(field[end+1:]), nil
+}
+
+// parseAptSource splits an apt repository URI field into its option block
+// and one or more URIs.
+func parseAptSource(field string) (*aptSource, error) {
+	block, field, err := splitOptionBlock(field)
+	if err != nil {
+		return nil, err
+	}
+	src := &aptSource{}
+	for _, opt := range block {
+		key, _, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("apt repository option %q is not of the form key=value", opt)
+		}
+		key = strings.TrimRight(key, "+-")
+		if !aptSourceOptions[key] {
+			return nil, fmt.Errorf("apt repository option %q is not supported", key)
+		}
+		src.options = append(src.options, opt)
+	}
+	src.uris = strings.Fields(field)
+	if len(src.uris) == 0 {
+		return nil, fmt.Errorf("apt repository uri not set")
+	}
+	if src.trusted() {
+		// Skipping signature checks is only allowed for mirrors on this
+		// machine, remote repositories must be signed.
+		for _, uri := range src.uris {
+			if _, ok := localRepoPath(uri); !ok {
+				return nil, fmt.Errorf("apt repository option trusted=yes is only supported for local repositories, not %q", uri)
+			}
+		}
+	}
+	return src, nil
+}
+
+func (s *aptSource) trusted() bool {
+	for _, opt := range s.options {
+		if opt == "trusted=yes" {
+			return true
+		}
+	}
+	return false
+}
+
+// stanza renders the repository as a deb822 stanza. keyring is set as
+// Signed-By unless the signed-by option is set.
+func (s *aptSource) stanza(archiveType, distribution string, components []string, keyring string) []string {
+	lines := []string{
+		"Types: " + archiveType,
+		"URIs: " + strings.Join(s.uris, " "),
+		"Suites: " + strings.Join(strings.Fields(distribution), " "),
+	}
+	if len(components) > 0 {
+		lines = append(lines, "Components: "+strings.Join(components, " "))
+	}
+	signed := false
+	for _, opt := range s.options {
+		key, value, _ := strings.Cut(opt, "=")
+		// arch+=, arch-= and the like add to or remove from the default.
+		var suffix string
+		switch {
+		case strings.HasSuffix(key, "+"):
+			suffix = "-Add"
+		case strings.HasSuffix(key, "-"):
+			suffix = "-Remove"
+		}
+		key = strings.TrimRight(key, "+-")
+		signed = signed || key == "signed-by"
+		lines = append(lines, deb822Fields[key]+suffix+": "+strings.ReplaceAll(value, ",", " "))
+	}
+	if keyring != "" && !signed {
+		lines = append(lines, "Signed-By: "+keyring)
+	}
+	return lines
+}
+
+// lines renders a sources.list line for each uri and suite.
+func (s *aptSource) lines(archiveType, distribution string, components []string) []string {
+	prefix := archiveType
+	if len(s.options) > 0 {
+		prefix += " [" + strings.Join(s.options, " ") + "]"
+	}
+	suites := strings.Fields(distribution)
+	if len(suites) == 0 {
+		suites = []string{""}
+	}
+	var lines []string
+	for _, uri := range s.uris {
+		for _, suite := range suites {
+			line := fmt.Sprintf("%s %s %s", prefix, uri, suite)
+			for _, c := range components {
+				line = fmt.Sprintf("%s %s", line, c)
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
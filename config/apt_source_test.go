@@ -0,0 +1,89 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestAptSourcesContents(t *testing.T) {
+	tests := []struct {
+		name    string
+		uri     string
+		dist    string
+		keyring string
+		want    string
+	}{
+		{"Plain", "http://repo", "stable", "", "Types: deb\nURIs: http://repo\nSuites: stable\nComponents: main\n"},
+		{"Keyring", "http://repo", "stable", "/etc/apt/keyrings/key.gpg", "Types: deb\nURIs: http://repo\nSuites: stable\nComponents: main\nSigned-By: /etc/apt/keyrings/key.gpg\n"},
+		{"Options", "[arch=amd64,arm64 lang-=en] http://repo1 http://repo2", "stable stable-updates", "/etc/apt/keyrings/key.gpg", "Types: deb\nURIs: http://repo1 http://repo2\nSuites: stable stable-updates\nComponents: main\nArchitectures: amd64 arm64\nLanguages-Remove: en\nSigned-By: /etc/apt/keyrings/key.gpg\n"},
+		{"SignedByOption", "[signed-by=/usr/share/keyrings/repo.gpg] http://repo", "stable", "/etc/apt/keyrings/key.gpg", "Types: deb\nURIs: http://repo\nSuites: stable\nComponents: main\nSigned-By: /usr/share/keyrings/repo.gpg\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := parseAptSource(tt.uri)
+			if err != nil {
+				t.Fatalf("parseAptSource(%q) unexpected error: %v", tt.uri, err)
+			}
+			repo := &agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository{Uri: tt.uri, Distribution: tt.dist, Components: []string{"main"}}
+			want := "# Repo file managed by Google OSConfig agent\n" + tt.want
+			if got := string(aptSourcesContents(repo, src, tt.keyring)); got != want {
+				t.Errorf("aptSourcesContents() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAptRepoContentsSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		uri     string
+		dist    string
+		want    string
+		wantErr string
+	}{
+		{"Plain", "http://repo", "stable", "deb http://repo stable main\n", ""},
+		{"Arch", "[arch=amd64,arm64] http://repo", "stable", "deb [arch=amd64,arm64] http://repo stable main\n", ""},
+		{"MultipleURIsAndSuites", "[arch=amd64 lang-=en] http://repo1 http://repo2", "stable stable-updates", "deb [arch=amd64 lang-=en] http://repo1 stable main\ndeb [arch=amd64 lang-=en] http://repo1 stable-updates main\ndeb [arch=amd64 lang-=en] http://repo2 stable main\ndeb [arch=amd64 lang-=en] http://repo2 stable-updates main\n", ""},
+		{"TrustedLocal", "[trusted=yes] file:///mnt/mirror", "./", "deb [trusted=yes] file:///mnt/mirror ./ main\n", ""},
+		{"TrustedRemote", "[trusted=yes] http://repo", "stable", "", "only supported for local repositories"},
+		{"UnknownOption", "[allow-insecure=yes] http://repo", "stable", "", "not supported"},
+		{"BadOption", "[arch] http://repo", "stable", "", "key=value"},
+		{"Unterminated", "[arch=amd64 http://repo", "stable", "", "unterminated"},
+		{"NoURI", "[arch=amd64]", "stable", "", "not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := parseAptSource(tt.uri)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseAptSource(%q) error = %v, want %q", tt.uri, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAptSource(%q) unexpected error: %v", tt.uri, err)
+			}
+			repo := &agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository{Uri: tt.uri, Distribution: tt.dist, Components: []string{"main"}}
+			want := "# Repo file managed by Google OSConfig agent\n" + tt.want
+			if got := string(aptRepoContents(repo, src)); got != want {
+				t.Errorf("aptRepoContents() = %q, want %q", got, want)
+			}
+		})
+	}
+}
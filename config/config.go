@@ -32,6 +32,9 @@ type OSPolicyResource struct {
 
 	managedResources *ManagedResources
 	inDesiredState   bool
+	// notEnforceable is why this resource cannot be enforced on this
+	// system, set by Validate.
+	notEnforceable error
 }
 
 // InDesiredState reports whether this resource is in the desired state.
@@ -71,6 +74,14 @@ func (r *OSPolicyResource) Validate(ctx context.Context) error {
 	case *agentendpointpb.OSPolicy_Resource_File_:
 		r.resource = resource(&fileResource{OSPolicy_Resource_FileResource: x.File})
 	case *agentendpointpb.OSPolicy_Resource_Exec:
+		p, ok, err := newProviderResource(r.GetId(), x.Exec)
+		if err != nil {
+			return err
+		}
+		if ok {
+			r.resource = resource(p)
+			break
+		}
 		r.resource = resource(&execResource{OSPolicy_Resource_ExecResource: x.Exec})
 
 	case nil:
@@ -81,7 +92,11 @@ func (r *OSPolicyResource) Validate(ctx context.Context) error {
 
 	var err error
 	r.managedResources, err = r.validate(ctx)
-	return err
+	if err != nil {
+		return err
+	}
+	r.notEnforceable = r.checkEnforceable(ctx)
+	return nil
 }
 
 // CheckState checks this resources state.
@@ -102,6 +117,12 @@ func (r *OSPolicyResource) EnforceState(ctx context.Context) error {
 	if r.resource == nil {
 		return errors.New("EnforceState run before Validate")
 	}
+	if r.notEnforceable != nil {
+		return r.notEnforceable
+	}
+	if root := r.PendingReboot(); root != "" {
+		return fmt.Errorf("desired state is pending in snapshot %q and takes effect on the next boot", root)
+	}
 
 	inDesiredState, err := r.enforceState(ctx)
 	r.inDesiredState = inDesiredState
@@ -118,6 +139,16 @@ func (r *OSPolicyResource) PopulateOutput(rCompliance *agentendpointpb.OSPolicyR
 	return nil
 }
 
+// Results returns the structured results reported by this resource, only
+// ExecResource scripts report results, by writing a JSON object to the file
+// named by ExecResultFileEnv.
+func (r *OSPolicyResource) Results() map[string]string {
+	if e, ok := r.resource.(*execResource); ok {
+		return e.Results()
+	}
+	return nil
+}
+
 // Cleanup cleans up any temporary files that this resource may have created.
 func (r *OSPolicyResource) Cleanup(ctx context.Context) error {
 	if r.resource == nil {
@@ -0,0 +1,98 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	checkWritable = util.CheckWritable
+
+	// packageWritePaths are the paths that installing or removing a system
+	// package writes to, the package files and the package database.
+	packageWritePaths = []string{"/usr", "/var/lib"}
+)
+
+// NotEnforceable returns why this resource cannot be enforced on this
+// system, such as a read-only root filesystem, or nil if it can be. The
+// state of a resource that cannot be enforced is still checked. Validate
+// must be called prior to running NotEnforceable.
+func (r *OSPolicyResource) NotEnforceable() error {
+	return r.notEnforceable
+}
+
+// PendingReboot returns the root of the snapshot that has this resource in
+// its desired state and becomes active on the next boot, or "" if there is
+// none. EnforceState does not enforce such a resource again. CheckState must
+// be called prior to running PendingReboot.
+func (r *OSPolicyResource) PendingReboot() string {
+	if p, ok := r.resource.(*packageResouce); ok {
+		return p.pendingSnapshot
+	}
+	return ""
+}
+
+// checkEnforceable checks that the paths enforcing this resource writes to
+// are on writable filesystems. Zypper packages on a read-only root are
+// enforced with transactional-update when it is installed.
+func (r *OSPolicyResource) checkEnforceable(ctx context.Context) error {
+	for _, path := range r.writablePaths() {
+		err := checkWritable(path)
+		if err == nil {
+			continue
+		}
+		if !util.IsReadOnlyFilesystem(err) {
+			clog.Debugf(ctx, "Error checking whether %q is writable: %v", path, err)
+			continue
+		}
+		if p, ok := r.resource.(*packageResouce); ok && p.managedPackage.Zypper != nil && packages.TransactionalUpdateExists {
+			clog.Infof(ctx, "%v, zypper package %q will be enforced with transactional-update and take effect on the next boot.", err, p.managedPackage.Zypper.PackageResource.GetName())
+			p.transactional = true
+			return nil
+		}
+		clog.Warningf(ctx, "Resource %q cannot be enforced on this system: %v", r.GetId(), err)
+		return err
+	}
+	return nil
+}
+
+// writablePaths returns the paths that enforcing this resource writes to.
+func (r *OSPolicyResource) writablePaths() []string {
+	mr := r.managedResources
+	if mr == nil {
+		return nil
+	}
+	var paths []string
+	for _, f := range mr.Files {
+		paths = append(paths, f.Path)
+	}
+	for _, repo := range mr.Repositories {
+		if repo.RepoFilePath != "" {
+			paths = append(paths, repo.RepoFilePath)
+		}
+	}
+	for _, p := range mr.Packages {
+		if p.Apt != nil || p.Deb != nil || p.Yum != nil || p.Zypper != nil || p.RPM != nil {
+			paths = append(paths, packageWritePaths...)
+			break
+		}
+	}
+	return paths
+}
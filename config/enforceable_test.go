@@ -0,0 +1,139 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestNotEnforceable(t *testing.T) {
+	ctx := context.Background()
+	tmpFile := filepath.Join(t.TempDir(), "foo")
+
+	oldCheckWritable := checkWritable
+	defer func() { checkWritable = oldCheckWritable }()
+	checkWritable = func(path string) error {
+		if path == tmpFile {
+			return &util.ReadOnlyFilesystemError{Path: path}
+		}
+		return nil
+	}
+
+	pr := &OSPolicyResource{
+		OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+			ResourceType: &agentendpointpb.OSPolicy_Resource_File_{File: &agentendpointpb.OSPolicy_Resource_FileResource{
+				Path:  tmpFile,
+				State: agentendpointpb.OSPolicy_Resource_FileResource_ABSENT,
+			}},
+		},
+	}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+	if err := pr.NotEnforceable(); !util.IsReadOnlyFilesystem(err) {
+		t.Errorf("NotEnforceable() = %v, want a read-only filesystem error", err)
+	}
+	// The state of a resource that cannot be enforced is still checked.
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if !pr.InDesiredState() {
+		t.Error("InDesiredState() = false, want true for an absent file")
+	}
+	if err := pr.EnforceState(ctx); !util.IsReadOnlyFilesystem(err) {
+		t.Errorf("EnforceState() = %v, want a read-only filesystem error", err)
+	}
+}
+
+func TestNotEnforceableTransactional(t *testing.T) {
+	ctx := context.Background()
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	packages.SetCommandRunner(mockCommandRunner)
+
+	oldCheckWritable, oldInstallSpaceCheck, oldTransactionalUpdateExists := checkWritable, installSpaceCheck, packages.TransactionalUpdateExists
+	defer func() {
+		checkWritable, installSpaceCheck, packages.TransactionalUpdateExists = oldCheckWritable, oldInstallSpaceCheck, oldTransactionalUpdateExists
+	}()
+	checkWritable = func(path string) error { return &util.ReadOnlyFilesystemError{Path: path} }
+	installSpaceCheck = func(context.Context, []string) error { return nil }
+
+	newResource := func() *OSPolicyResource {
+		return &OSPolicyResource{
+			OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+				ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: zypperInstalledPR},
+			},
+		}
+	}
+
+	packages.TransactionalUpdateExists = false
+	pr := newResource()
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+	if err := pr.NotEnforceable(); !util.IsReadOnlyFilesystem(err) {
+		t.Errorf("NotEnforceable() without transactional-update = %v, want a read-only filesystem error", err)
+	}
+	if key := pr.PackageBatchKey(); key != "" {
+		t.Errorf("PackageBatchKey() = %q, want no batch for a resource that cannot be enforced", key)
+	}
+
+	packages.TransactionalUpdateExists = true
+	pr = newResource()
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+	if err := pr.NotEnforceable(); err != nil {
+		t.Errorf("NotEnforceable() with transactional-update = %v, want nil", err)
+	}
+	if key := pr.PackageBatchKey(); key != "" {
+		t.Errorf("PackageBatchKey() = %q, want no batch for a transactional resource", key)
+	}
+	zypperInstalled.cache = map[string]struct{}{}
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command("/usr/sbin/transactional-update", "--non-interactive", "--continue", "pkg", "install", "foo")))
+	if err := pr.EnforceState(ctx); err != nil {
+		t.Errorf("Unexpected EnforceState error: %v", err)
+	}
+
+	// The package stays missing from the running system until the next boot,
+	// it must not be installed into another snapshot.
+	zypperInstalled.cache, zypperInstalled.refreshed = map[string]struct{}{}, now()
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command("/usr/bin/snapper", "--no-dbus", "--csv", "list", "--columns", "number,default,active"))).Return([]byte("number,default,active\n0,no,no\n1,no,yes\n2,yes,no\n"), nil, nil)
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command("/usr/bin/rpmquery", "--root", "/.snapshots/2/snapshot", "--queryformat", "%{NAME}\n", "-a"))).Return([]byte("bar\nfoo\n"), nil, nil)
+	if err := pr.CheckState(ctx); err != nil {
+		t.Fatalf("Unexpected CheckState error: %v", err)
+	}
+	if pr.InDesiredState() {
+		t.Error("InDesiredState() = true, want false before the reboot")
+	}
+	if got := pr.PendingReboot(); got != "/.snapshots/2/snapshot" {
+		t.Errorf("PendingReboot() = %q, want %q", got, "/.snapshots/2/snapshot")
+	}
+	if err := pr.EnforceState(ctx); err == nil {
+		t.Error("EnforceState() = nil, want an error for a resource pending a reboot")
+	}
+}
@@ -0,0 +1,195 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+var execPolicyFile = agentconfig.ExecPolicyFile()
+
+// execPolicy is a local, host administrator controlled, policy that restricts
+// which ExecResource scripts may be run by the agent.
+//
+// A script is allowed if its sha256 checksum is in AllowedSHA256 or if a
+// detached signature for it was made by one of TrustedKeys. The signature of
+// a local script is read from "<script path>.sig", the signature of a remote
+// or Cloud Storage script is downloaded from "<uri>.sig" or "<object>.sig".
+// If the policy file does not exist all scripts are allowed.
+//
+// Resources with a blocked script are reported as not in their desired
+// state, their scripts are never run.
+//
+//	{
+//	  "allowedSha256": ["9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"],
+//	  "trustedKeys": ["MCowBQYDK2VwAyEA..."]
+//	}
+type execPolicy struct {
+	// AllowedSHA256 are hex encoded sha256 checksums of allowed scripts.
+	AllowedSHA256 []string `json:"allowedSha256,omitempty"`
+	// TrustedKeys are base64 encoded ed25519 public keys, either the raw 32
+	// byte key or the PKIX (SubjectPublicKeyInfo) DER encoding written by
+	// "openssl pkey -pubout -outform DER".
+	TrustedKeys []string `json:"trustedKeys,omitempty"`
+}
+
+// loadExecPolicy loads the local exec policy, a nil policy is returned if
+// no policy file exists. A policy with a trusted key that can not be parsed
+// is rejected rather than silently trusting fewer keys.
+func loadExecPolicy(path string) (*execPolicy, error) {
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading exec policy %q: %v", path, err)
+	}
+
+	var p execPolicy
+	if err := json.Unmarshal(d, &p); err != nil {
+		return nil, fmt.Errorf("error parsing exec policy %q: %v", path, err)
+	}
+	for _, k := range p.TrustedKeys {
+		if _, err := parseTrustedKey(k); err != nil {
+			return nil, fmt.Errorf("error parsing exec policy %q: invalid trusted key %q: %v", path, k, err)
+		}
+	}
+	return &p, nil
+}
+
+// parseTrustedKey parses a base64 encoded ed25519 public key, either raw or
+// PKIX encoded.
+func parseTrustedKey(k string) (ed25519.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+	if err != nil {
+		return nil, err
+	}
+	if len(der) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(der), nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an ed25519 public key", pub)
+	}
+	return key, nil
+}
+
+// verify returns an error if the script at path is not allowed by this policy.
+func (p *execPolicy) verify(path string) error {
+	if p == nil {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	sum := checksum(f)
+	f.Close()
+
+	for _, allowed := range p.AllowedSHA256 {
+		if strings.EqualFold(allowed, sum) {
+			return nil
+		}
+	}
+
+	if len(p.TrustedKeys) > 0 {
+		if err := p.verifySignature(path); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("execution of %q (sha256: %s) blocked by local exec policy %q", path, sum, execPolicyFile)
+}
+
+// verifyExec is verify for the script of an exec downloaded to path, the
+// detached signature of a remote script is downloaded next to it first.
+func (p *execPolicy) verifyExec(ctx context.Context, path string, execR *agentendpointpb.OSPolicy_Resource_ExecResource_Exec) error {
+	if p == nil {
+		return nil
+	}
+	if sig := signatureSource(execR.GetFile()); sig != nil && len(p.TrustedKeys) > 0 {
+		if _, err := downloadFile(ctx, path+".sig", 0644, sig); err != nil {
+			clog.Debugf(ctx, "Error downloading the signature of %q: %v", path, err)
+		}
+	}
+	return p.verify(path)
+}
+
+// signatureSource returns where the detached signature of a remote or Cloud
+// Storage script is stored, nil for other scripts.
+func signatureSource(file *agentendpointpb.OSPolicy_Resource_File) *agentendpointpb.OSPolicy_Resource_File {
+	switch {
+	case file.GetRemote().GetUri() != "":
+		u, err := url.Parse(file.GetRemote().GetUri())
+		if err != nil {
+			return nil
+		}
+		// Keep the query of signed URLs.
+		u.Path += ".sig"
+		u.RawPath = ""
+		return &agentendpointpb.OSPolicy_Resource_File{Type: &agentendpointpb.OSPolicy_Resource_File_Remote_{
+			Remote: &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: u.String()}}}
+	case file.GetGcs().GetObject() != "":
+		return &agentendpointpb.OSPolicy_Resource_File{Type: &agentendpointpb.OSPolicy_Resource_File_Gcs_{
+			Gcs: &agentendpointpb.OSPolicy_Resource_File_Gcs{Bucket: file.GetGcs().GetBucket(), Object: file.GetGcs().GetObject() + ".sig"}}}
+	}
+	return nil
+}
+
+func (p *execPolicy) verifySignature(path string) error {
+	sig, err := ioutil.ReadFile(path + ".sig")
+	if err != nil {
+		return err
+	}
+	sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("error decoding signature: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range p.TrustedKeys {
+		// Keys are checked when the policy is loaded.
+		key, err := parseTrustedKey(k)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no trusted key matched signature for %q", path)
+}
@@ -0,0 +1,323 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestLoadExecPolicy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	p, err := loadExecPolicy(filepath.Join(tmpDir, "missing.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p != nil {
+		t.Errorf("expected nil policy for missing file, got: %+v", p)
+	}
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(pub)
+
+	policyPath := filepath.Join(tmpDir, "exec_policy.json")
+	if err := ioutil.WriteFile(policyPath, []byte(fmt.Sprintf(`{"allowedSha256": ["abc"], "trustedKeys": [%q]}`, key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err = loadExecPolicy(policyPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(p.AllowedSHA256) != 1 || p.AllowedSHA256[0] != "abc" || len(p.TrustedKeys) != 1 || p.TrustedKeys[0] != key {
+		t.Errorf("unexpected policy: %+v", p)
+	}
+
+	if err := ioutil.WriteFile(policyPath, []byte(`not json`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadExecPolicy(policyPath); err == nil {
+		t.Error("expected error for malformed policy")
+	}
+
+	// A policy with a key that can not be parsed is rejected.
+	for _, k := range []string{"def", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if err := ioutil.WriteFile(policyPath, []byte(fmt.Sprintf(`{"trustedKeys": [%q]}`, k)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadExecPolicy(policyPath); err == nil || !strings.Contains(err.Error(), "invalid trusted key") {
+			t.Errorf("loadExecPolicy() with key %q: error = %v, want an invalid trusted key error", k, err)
+		}
+	}
+}
+
+func TestParseTrustedKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(spki)} {
+		got, err := parseTrustedKey(k)
+		if err != nil {
+			t.Fatalf("parseTrustedKey(%q) error: %v", k, err)
+		}
+		if !got.Equal(pub) {
+			t.Errorf("parseTrustedKey(%q) = %x, want %x", k, got, pub)
+		}
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTrustedKey(base64.StdEncoding.EncodeToString(der)); err == nil {
+		t.Error("parseTrustedKey() with an RSA key returned no error")
+	}
+}
+
+func TestExecPolicyVerify(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	script := filepath.Join(tmpDir, "script.sh")
+	contents := "echo hello"
+	if err := ioutil.WriteFile(script, []byte(contents), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sum := checksum(strings.NewReader(contents))
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(contents)))
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name      string
+		policy    *execPolicy
+		signature string
+		wantErr   bool
+	}{
+		{"no policy", nil, "", false},
+		{"empty policy", &execPolicy{}, "", true},
+		{"checksum allowed", &execPolicy{AllowedSHA256: []string{strings.ToUpper(sum)}}, "", false},
+		{"checksum not allowed", &execPolicy{AllowedSHA256: []string{"abc"}}, "", true},
+		{"signed by trusted key", &execPolicy{TrustedKeys: []string{base64.StdEncoding.EncodeToString(pub)}}, sig, false},
+		{"signed by trusted PKIX key", &execPolicy{TrustedKeys: []string{base64.StdEncoding.EncodeToString(spki)}}, sig, false},
+		{"signed by untrusted key", &execPolicy{TrustedKeys: []string{base64.StdEncoding.EncodeToString(otherPub)}}, sig, true},
+		{"missing signature", &execPolicy{TrustedKeys: []string{base64.StdEncoding.EncodeToString(pub)}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(script + ".sig")
+			if tt.signature != "" {
+				if err := ioutil.WriteFile(script+".sig", []byte(tt.signature), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := tt.policy.verify(script)
+			if err != nil && !tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err == nil && tt.wantErr {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestExecResourceRemoteSignature(t *testing.T) {
+	ctx := context.Background()
+	contents := "echo hello"
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(contents)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/signed.sh", "/unsigned.sh":
+			fmt.Fprint(w, contents)
+		case "/signed.sh.sig":
+			if r.URL.RawQuery != "token=abc" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, sig)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	policyFile := filepath.Join(t.TempDir(), "exec_policy.json")
+	if err := ioutil.WriteFile(policyFile, []byte(fmt.Sprintf(`{"trustedKeys": [%q]}`, base64.StdEncoding.EncodeToString(pub))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := execPolicyFile
+	defer func() { execPolicyFile = old }()
+	execPolicyFile = policyFile
+
+	for _, tt := range []struct {
+		uri         string
+		wantBlocked bool
+	}{
+		{srv.URL + "/signed.sh?token=abc", false},
+		{srv.URL + "/unsigned.sh", true},
+	} {
+		e := &execResource{OSPolicy_Resource_ExecResource: &agentendpointpb.OSPolicy_Resource_ExecResource{
+			Validate: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+				Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+				Source: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_File{File: &agentendpointpb.OSPolicy_Resource_File{
+					Type: &agentendpointpb.OSPolicy_Resource_File_Remote_{Remote: &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: tt.uri}}}},
+			},
+		}}
+		// A blocked script does not fail validation.
+		if _, err := e.validate(ctx); err != nil {
+			t.Fatalf("%s: unexpected validate error: %v", tt.uri, err)
+		}
+		defer os.RemoveAll(e.tempDir)
+		if (e.blocked != nil) != tt.wantBlocked {
+			t.Errorf("%s: blocked = %v, want blocked %t", tt.uri, e.blocked, tt.wantBlocked)
+		}
+		if !tt.wantBlocked {
+			continue
+		}
+		// It is reported as not in its desired state without being run.
+		if inDesiredState, err := e.checkState(ctx); inDesiredState || err != nil {
+			t.Errorf("%s: checkState() = %t, %v, want false, nil", tt.uri, inDesiredState, err)
+		}
+		if _, err := e.enforceState(ctx); err == nil {
+			t.Errorf("%s: enforceState() should return an error", tt.uri)
+		}
+	}
+}
+
+func TestExecResourceLocalScriptSwapped(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "validate.sh")
+	allowed := "exit 100\n"
+	if err := ioutil.WriteFile(script, []byte(allowed), 0755); err != nil {
+		t.Fatal(err)
+	}
+	policyFile := filepath.Join(dir, "exec_policy.json")
+	if err := ioutil.WriteFile(policyFile, []byte(fmt.Sprintf(`{"allowedSha256": [%q]}`, checksum(strings.NewReader(allowed)))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := execPolicyFile
+	defer func() { execPolicyFile = old }()
+	execPolicyFile = policyFile
+
+	e := &execResource{OSPolicy_Resource_ExecResource: &agentendpointpb.OSPolicy_Resource_ExecResource{
+		Validate: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+			Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+			Source: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_File{File: &agentendpointpb.OSPolicy_Resource_File{
+				Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: script}}},
+		},
+	}}
+	if _, err := e.validate(ctx); err != nil {
+		t.Fatalf("unexpected validate error: %v", err)
+	}
+	defer os.RemoveAll(e.tempDir)
+	if e.blocked != nil {
+		t.Fatalf("allowed script was blocked: %v", e.blocked)
+	}
+	if !strings.HasPrefix(e.validatePath, e.tempDir) {
+		t.Errorf("validate path = %q, want a copy in %q", e.validatePath, e.tempDir)
+	}
+
+	// Swapping the script after it was verified does not change what runs.
+	if err := ioutil.WriteFile(script, []byte("exit 101\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if inDesiredState, err := e.checkState(ctx); !inDesiredState || err != nil {
+		t.Errorf("checkState() = %t, %v, want the verified script to run", inDesiredState, err)
+	}
+}
+
+func TestExecResourceLocalScriptNoPolicy(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "validate.sh")
+	// The script reads a file next to it.
+	if err := ioutil.WriteFile(script, []byte("exit $(cat \"$(dirname \"$0\")/code\")\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "code"), []byte("100"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := execPolicyFile
+	defer func() { execPolicyFile = old }()
+	execPolicyFile = filepath.Join(dir, "does_not_exist.json")
+
+	e := &execResource{OSPolicy_Resource_ExecResource: &agentendpointpb.OSPolicy_Resource_ExecResource{
+		Validate: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+			Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+			Source: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_File{File: &agentendpointpb.OSPolicy_Resource_File{
+				Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: script}}},
+		},
+	}}
+	if _, err := e.validate(ctx); err != nil {
+		t.Fatalf("unexpected validate error: %v", err)
+	}
+	defer os.RemoveAll(e.tempDir)
+	if e.validatePath != script {
+		t.Errorf("validate path = %q, want the script to run in place from %q", e.validatePath, script)
+	}
+	if inDesiredState, err := e.checkState(ctx); !inDesiredState || err != nil {
+		t.Errorf("checkState() = %t, %v, want true, nil", inDesiredState, err)
+	}
+}
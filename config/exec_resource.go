@@ -16,6 +16,7 @@ package config
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -26,12 +27,20 @@ import (
 	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
 )
 
-const maxExecOutputSize = 500 * 1024
+const (
+	maxExecOutputSize = 500 * 1024
+
+	// ExecResultFileEnv names the file validate and enforce scripts may write
+	// a JSON object of results to, e.g. {"version": "1.2", "drift": 3}.
+	ExecResultFileEnv = "OSCONFIG_RESULT_FILE"
+	maxExecResultSize = 64 * 1024
+)
 
 var runner = util.CommandRunner(&util.DefaultRunner{})
 
@@ -40,10 +49,15 @@ type execResource struct {
 
 	validatePath, enforcePath, tempDir string
 	enforceOutput                      []byte
+
+	validateResults, enforceResults map[string]string
+	// blocked is set if the local exec policy does not allow a script.
+	blocked error
 }
 
 // TODO: use a persistent cache for downloaded files so we dont need to redownload them each time
-func (e *execResource) download(ctx context.Context, execR *agentendpointpb.OSPolicy_Resource_ExecResource_Exec) (string, error) {
+// A local script is only copied to a private temp dir if private is set.
+func (e *execResource) download(ctx context.Context, execR *agentendpointpb.OSPolicy_Resource_ExecResource_Exec, private bool) (string, error) {
 	tmpDir, err := ioutil.TempDir(e.tempDir, "")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp dir: %s", err)
@@ -79,8 +93,22 @@ func (e *execResource) download(ctx context.Context, execR *agentendpointpb.OSPo
 		}
 
 	case *agentendpointpb.OSPolicy_Resource_ExecResource_Exec_File:
-		if execR.GetFile().GetLocalPath() != "" {
-			return execR.GetFile().GetLocalPath(), nil
+		if execR.GetInterpreter() == agentendpointpb.OSPolicy_Resource_ExecResource_Exec_NONE {
+			perms = os.FileMode(0755)
+		}
+		if src := execR.GetFile().GetLocalPath(); src != "" {
+			// Without an exec policy the script runs in place, it may use
+			// its own location.
+			if !private {
+				return src, nil
+			}
+			// The script is verified and run from a private copy, so it can
+			// not be swapped between the exec policy check and a run.
+			name = filepath.Join(tmpDir, filepath.Base(src))
+			if err := copyLocalScript(src, name, perms); err != nil {
+				return "", err
+			}
+			return name, nil
 		}
 		switch {
 		case execR.GetFile().GetGcs().GetObject() != "":
@@ -90,9 +118,6 @@ func (e *execResource) download(ctx context.Context, execR *agentendpointpb.OSPo
 		default:
 			return "", fmt.Errorf("unsupported File %v", execR.GetFile())
 		}
-		if execR.GetInterpreter() == agentendpointpb.OSPolicy_Resource_ExecResource_Exec_NONE {
-			perms = os.FileMode(0755)
-		}
 		name = filepath.Join(tmpDir, name)
 		if _, err := downloadFile(ctx, name, perms, execR.GetFile()); err != nil {
 			return "", err
@@ -104,6 +129,32 @@ func (e *execResource) download(ctx context.Context, execR *agentendpointpb.OSPo
 	return name, nil
 }
 
+// copyLocalScript copies the local script src, and its detached signature
+// if it has one, to dst.
+func copyLocalScript(src, dst string, perms os.FileMode) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening local script: %v", err)
+	}
+	defer f.Close()
+	if _, err := util.AtomicWriteFileStream(f, "", dst, perms); err != nil {
+		return fmt.Errorf("error copying local script: %v", err)
+	}
+
+	sig, err := os.Open(src + ".sig")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening local script signature: %v", err)
+	}
+	defer sig.Close()
+	if _, err := util.AtomicWriteFileStream(sig, "", dst+".sig", 0644); err != nil {
+		return fmt.Errorf("error copying local script signature: %v", err)
+	}
+	return nil
+}
+
 func (e *execResource) validate(ctx context.Context) (*ManagedResources, error) {
 	tmpDir, err := ioutil.TempDir("", "osconfig_exec_resource_")
 	if err != nil {
@@ -111,23 +162,35 @@ func (e *execResource) validate(ctx context.Context) (*ManagedResources, error)
 	}
 	e.tempDir = tmpDir
 
-	e.validatePath, err = e.download(ctx, e.GetValidate())
+	policy, err := loadExecPolicy(execPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	e.validatePath, err = e.download(ctx, e.GetValidate(), policy != nil)
 	if err != nil {
 		return nil, err
 	}
 
 	// Assume lack of Enforce means policy is in VALIDATE mode.
 	if e.GetEnforce() != nil {
-		e.enforcePath, err = e.download(ctx, e.GetEnforce())
+		e.enforcePath, err = e.download(ctx, e.GetEnforce(), policy != nil)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// A blocked resource is still valid, it is reported as not in its
+	// desired state.
+	e.blocked = policy.verifyExec(ctx, e.validatePath, e.GetValidate())
+	if e.blocked == nil && e.enforcePath != "" {
+		e.blocked = policy.verifyExec(ctx, e.enforcePath, e.GetEnforce())
+	}
+
 	return nil, nil
 }
 
-func (e *execResource) run(ctx context.Context, name string, execR *agentendpointpb.OSPolicy_Resource_ExecResource_Exec) ([]byte, []byte, int, error) {
+func (e *execResource) run(ctx context.Context, name string, execR *agentendpointpb.OSPolicy_Resource_ExecResource_Exec, logf logFunc, results *map[string]string) ([]byte, []byte, int, error) {
 	if execR == nil {
 		return nil, nil, 0, fmt.Errorf("ExecResource Exec cannot be nil")
 	}
@@ -155,7 +218,25 @@ func (e *execResource) run(ctx context.Context, name string, execR *agentendpoin
 	}
 	args = append(args, execR.GetArgs()...)
 
-	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, cmd, args...))
+	c := exec.CommandContext(ctx, cmd, args...)
+	var resultFile string
+	if e.tempDir != "" {
+		resultFile = filepath.Join(e.tempDir, "result.json")
+		// Results from a previous run must not be reported again.
+		if err := os.Remove(resultFile); err != nil && !os.IsNotExist(err) {
+			return nil, nil, 0, fmt.Errorf("error removing result file: %v", err)
+		}
+		c.Env = append(os.Environ(), ExecResultFileEnv+"="+resultFile)
+	}
+	stdout, stderr, err := runStreaming(ctx, c, logf)
+	if resultFile != "" {
+		// Results are informational, a bad result file never changes the outcome.
+		r, rerr := execResults(resultFile)
+		if rerr != nil {
+			clog.Warningf(ctx, "Error reading ExecResource results: %v", rerr)
+		}
+		*results = r
+	}
 	code := 0
 	if err != nil {
 		code = -1
@@ -172,35 +253,47 @@ func (e *execResource) checkState(ctx context.Context) (inDesiredState bool, err
 	// "correct" vs "incorrect" state and errors. Also Powershell will always exit 0 unless "exit"
 	// is explicitly called.
 	// A code of -1 indicates some other error, so we just return err.
-	stdout, stderr, code, err := e.run(ctx, e.validatePath, e.GetValidate())
+	if e.blocked != nil {
+		clog.Warningf(ctx, "Not running ExecResource validate: %v", e.blocked)
+		return false, nil
+	}
+	// Validate runs on every check so only stream its output at debug level.
+	ctx = clog.WithLabels(ctx, map[string]string{"exec_step": "validate"})
+	stdout, stderr, code, err := e.run(ctx, e.validatePath, e.GetValidate(), clog.Debugf, &e.validateResults)
 	switch code {
 	case -1:
-		return false, err
+		return false, annotateMACDenial(ctx, err, e.validatePath)
 	case 100:
 		return true, nil
 	case 101:
 		return false, nil
 	default:
-		return false, fmt.Errorf("unexpected return code from validate: %d, stdout: %s, stderr: %s", code, stdout, stderr)
+		return false, annotateMACDenial(ctx, fmt.Errorf("unexpected return code from validate: %d, stdout: %s, stderr: %s", code, stdout, stderr), e.validatePath)
 	}
 }
 
 func (e *execResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	if e.blocked != nil {
+		return false, e.blocked
+	}
 	clog.Infof(ctx, `Running "Enforce" for ExecResource.`)
+	// Enforce scripts may change packages.
+	defer packages.InvalidateQueryCache()
 	// For enforce we expect an exit code of 100 for "success" and anything positive code is a failure".
 	// 100 was chosen over 0 because we want an explicit indicator of "sucess" vs errors.
 	// Also Powershell will always exit 0 unless "exit" is explicitly called.
 	// A code of -1 indicates some other error, so we just return err.
-	stdout, stderr, code, err := e.run(ctx, e.enforcePath, e.GetEnforce())
+	ctx = clog.WithLabels(ctx, map[string]string{"exec_step": "enforce"})
+	stdout, stderr, code, err := e.run(ctx, e.enforcePath, e.GetEnforce(), clog.Infof, &e.enforceResults)
 	switch code {
 	case -1:
-		return false, err
+		return false, annotateMACDenial(ctx, err, e.enforcePath)
 	case 100:
 		out, err := execOutput(ctx, e.GetEnforce().GetOutputFilePath())
 		e.enforceOutput = out
 		return true, err
 	default:
-		return false, fmt.Errorf("unexpected return code from enforce: %d, stdout: %s, stderr: %s", code, stdout, stderr)
+		return false, annotateMACDenial(ctx, fmt.Errorf("unexpected return code from enforce: %d, stdout: %s, stderr: %s", code, stdout, stderr), e.enforcePath)
 	}
 }
 
@@ -232,6 +325,58 @@ func execOutput(ctx context.Context, outputFilePath string) ([]byte, error) {
 	return output, nil
 }
 
+// execResults reads the JSON object a script wrote to its result file,
+// values that are not strings are kept in their JSON form. A missing file
+// means the script reported no results.
+func execResults(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening result file: %v", err)
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxExecResultSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading result file: %v", err)
+	}
+	if len(data) > maxExecResultSize {
+		return nil, fmt.Errorf("result file greater than %dK", maxExecResultSize/1024)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("result file is not a JSON object: %v", err)
+	}
+	results := make(map[string]string, len(raw))
+	for k, v := range raw {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			results[k] = s
+			continue
+		}
+		results[k] = string(v)
+	}
+	return results, nil
+}
+
+// Results returns the results reported by the last validate and enforce
+// runs, enforce results take precedence.
+func (e *execResource) Results() map[string]string {
+	if e.validateResults == nil && e.enforceResults == nil {
+		return nil
+	}
+	results := make(map[string]string, len(e.validateResults)+len(e.enforceResults))
+	for k, v := range e.validateResults {
+		results[k] = v
+	}
+	for k, v := range e.enforceResults {
+		results[k] = v
+	}
+	return results
+}
+
 func (e *execResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
 	if e.enforceOutput != nil {
 		rCompliance.Output = &agentendpointpb.OSPolicyResourceCompliance_ExecResourceOutput_{
@@ -22,6 +22,8 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
@@ -242,3 +244,88 @@ func TestExecOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestExecResults(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(tmpDir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"missing file", filepath.Join(tmpDir, "DNE"), nil, false},
+		{"object", write("object", `{"version": "1.2", "drift": 3, "ok": true, "tags": ["a"]}`), map[string]string{"version": "1.2", "drift": "3", "ok": "true", "tags": `["a"]`}, false},
+		{"not an object", write("array", `["a"]`), nil, true},
+		{"too large", write("large", `{"a": "`+strings.Repeat("a", maxExecResultSize)+`"}`), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := execResults(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("execResults() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("execResults() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecResourceResults(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	oldGoos := goos
+	goos = "linux"
+	defer func() { goos = oldGoos }()
+	ctx := context.Background()
+	validate := &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+		Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+	}
+	enforce := &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+		Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL,
+	}
+	e := &execResource{
+		OSPolicy_Resource_ExecResource: &agentendpointpb.OSPolicy_Resource_ExecResource{Validate: validate, Enforce: enforce},
+		tempDir:                        t.TempDir(),
+	}
+	e.validatePath = filepath.Join(e.tempDir, "validate.sh")
+	e.enforcePath = filepath.Join(e.tempDir, "enforce.sh")
+	if err := ioutil.WriteFile(e.validatePath, []byte(`echo '{"version": "1.0", "checked": true}' > "$OSCONFIG_RESULT_FILE"; exit 101`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(e.enforcePath, []byte(`echo '{"version": "2.0"}' > "$OSCONFIG_RESULT_FILE"; exit 100`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.checkState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.enforceState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"version": "2.0", "checked": "true"}
+	if got := e.Results(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Results() = %q, want %q", got, want)
+	}
+
+	// A run that writes no results clears its previous results.
+	if err := ioutil.WriteFile(e.validatePath, []byte(`exit 100`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.checkState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{"version": "2.0"}
+	if got := e.Results(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Results() = %q, want %q", got, want)
+	}
+}
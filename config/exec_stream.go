@@ -0,0 +1,79 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// maxStreamLine is the max length of a single streamed log line, longer
+// lines are split.
+const maxStreamLine = 4096
+
+type logFunc func(ctx context.Context, format string, args ...any)
+
+// lineLogger is an io.Writer that logs each complete line written to it,
+// this lets long running scripts be followed while they run.
+type lineLogger struct {
+	ctx  context.Context
+	logf logFunc
+	buf  []byte
+}
+
+func newLineLogger(ctx context.Context, stream string, logf logFunc) *lineLogger {
+	return &lineLogger{ctx: clog.WithLabels(ctx, map[string]string{"exec_stream": stream}), logf: logf}
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			if len(l.buf) >= maxStreamLine {
+				l.logf(l.ctx, "%s", l.buf[:maxStreamLine])
+				l.buf = l.buf[maxStreamLine:]
+				continue
+			}
+			return len(p), nil
+		}
+		l.logf(l.ctx, "%s", bytes.TrimRight(l.buf[:i], "\r"))
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// flush logs any remaining partial line.
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		l.logf(l.ctx, "%s", l.buf)
+		l.buf = nil
+	}
+}
+
+// runStreaming runs cmd logging its output line by line with logf as it is
+// produced, the full stdout and stderr are also returned.
+func runStreaming(ctx context.Context, cmd *exec.Cmd, logf logFunc) ([]byte, []byte, error) {
+	outLog := newLineLogger(ctx, "stdout", logf)
+	errLog := newLineLogger(ctx, "stderr", logf)
+	cmd.Stdout = outLog
+	cmd.Stderr = errLog
+	stdout, stderr, err := runner.Run(ctx, cmd)
+	outLog.flush()
+	errLog.flush()
+	return stdout, stderr, err
+}
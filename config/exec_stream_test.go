@@ -0,0 +1,76 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *recordLog) logf(ctx context.Context, format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, fmt.Sprintf(format, args...))
+}
+
+func TestLineLogger(t *testing.T) {
+	rec := &recordLog{}
+	l := newLineLogger(context.Background(), "stdout", rec.logf)
+
+	l.Write([]byte("first line\nsecond "))
+	l.Write([]byte("line\r\nthird"))
+	if want := []string{"first line", "second line"}; !reflect.DeepEqual(rec.lines, want) {
+		t.Errorf("lines before flush = %q, want %q", rec.lines, want)
+	}
+	l.flush()
+	if want := []string{"first line", "second line", "third"}; !reflect.DeepEqual(rec.lines, want) {
+		t.Errorf("lines after flush = %q, want %q", rec.lines, want)
+	}
+
+	rec.lines = nil
+	l.Write([]byte(strings.Repeat("a", maxStreamLine+10)))
+	l.flush()
+	if len(rec.lines) != 2 || len(rec.lines[0]) != maxStreamLine || len(rec.lines[1]) != 10 {
+		t.Errorf("unexpected split of long line, got %d lines", len(rec.lines))
+	}
+}
+
+func TestRunStreaming(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	rec := &recordLog{}
+	stdout, stderr, err := runStreaming(context.Background(), exec.Command("/bin/sh", "-c", "echo out1; echo err1 >&2; echo out2"), rec.logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdout) != "out1\nout2\n" || string(stderr) != "err1\n" {
+		t.Errorf("unexpected output, stdout: %q, stderr: %q", stdout, stderr)
+	}
+	if len(rec.lines) != 3 {
+		t.Errorf("expected 3 streamed lines, got: %q", rec.lines)
+	}
+}
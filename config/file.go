@@ -25,7 +25,6 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/osconfig/external"
-	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
 )
@@ -65,5 +64,5 @@ func downloadFile(ctx context.Context, path string, perms os.FileMode, file *age
 		return "", fmt.Errorf("unknown remote File type: %+v", file.GetType())
 	}
 	defer reader.Close()
-	return util.AtomicWriteFileStream(reader, wantChecksum, path, perms)
+	return resourceFS.AtomicWriteStream(reader, wantChecksum, path, perms)
 }
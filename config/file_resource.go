@@ -15,17 +15,16 @@
 package config
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
-	"github.com/GoogleCloudPlatform/osconfig/util"
+	"github.com/GoogleCloudPlatform/osconfig/managedfiles"
 
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
 )
@@ -67,7 +66,7 @@ func (f *fileResource) download(ctx context.Context) error {
 		return nil
 	}
 
-	tmpDir, err := ioutil.TempDir("", "osconfig_file_resource_")
+	tmpDir, err := resourceFS.TempDir("", "osconfig_file_resource_")
 	if err != nil {
 		return fmt.Errorf("failed to create working dir: %s", err)
 	}
@@ -79,10 +78,11 @@ func (f *fileResource) download(ctx context.Context) error {
 
 	switch f.GetSource().(type) {
 	case *agentendpointpb.OSPolicy_Resource_FileResource_Content:
-		f.managedFile.checksum, err = util.AtomicWriteFileStream(strings.NewReader(f.GetContent()), "", tmpFile, perms)
-		if err != nil {
+		content := []byte(f.GetContent())
+		if err := resourceFS.AtomicWrite(tmpFile, content, perms); err != nil {
 			return err
 		}
+		f.managedFile.checksum = checksum(bytes.NewReader(content))
 
 	case *agentendpointpb.OSPolicy_Resource_FileResource_File:
 		f.managedFile.checksum, err = downloadFile(ctx, tmpFile, perms, f.GetFile())
@@ -119,7 +119,7 @@ func (f *fileResource) validate(ctx context.Context) (*ManagedResources, error)
 
 	if f.GetFile().GetLocalPath() != "" {
 		f.managedFile.source = f.GetFile().GetLocalPath()
-		file, err := os.Open(f.GetFile().GetLocalPath())
+		file, err := resourceFS.Open(f.GetFile().GetLocalPath())
 		if err != nil {
 			return nil, err
 		}
@@ -131,7 +131,7 @@ func (f *fileResource) validate(ctx context.Context) (*ManagedResources, error)
 	case agentendpointpb.OSPolicy_Resource_FileResource_ABSENT:
 	case agentendpointpb.OSPolicy_Resource_FileResource_PRESENT:
 		// If the file is already present no need to downloaded it.
-		if !util.Exists(f.managedFile.Path) {
+		if !exists(f.managedFile.Path) {
 			if err := f.download(ctx); err != nil {
 				return nil, err
 			}
@@ -150,9 +150,9 @@ func (f *fileResource) validate(ctx context.Context) (*ManagedResources, error)
 func (f *fileResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
 	switch f.managedFile.State {
 	case agentendpointpb.OSPolicy_Resource_FileResource_ABSENT:
-		return !util.Exists(f.managedFile.Path), nil
+		return !exists(f.managedFile.Path), nil
 	case agentendpointpb.OSPolicy_Resource_FileResource_PRESENT:
-		return util.Exists(f.managedFile.Path), nil
+		return exists(f.managedFile.Path), nil
 	case agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH:
 		return contentsMatch(f.managedFile.Path, f.managedFile.checksum)
 	default:
@@ -160,13 +160,15 @@ func (f *fileResource) checkState(ctx context.Context) (inDesiredState bool, err
 	}
 }
 
+// copyFile copies src to dst, if the copy fails part way the partially
+// written dst is removed so it is not mistaken for a complete file.
 func copyFile(dst, src string, perms os.FileMode) (retErr error) {
-	reader, err := os.Open(src)
+	reader, err := resourceFS.Open(src)
 	if err != nil {
 		return fmt.Errorf("error opening source file: %v", err)
 	}
 	defer reader.Close()
-	writer, err := os.OpenFile(dst, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, perms)
+	writer, err := resourceFS.Create(dst, perms)
 	if err != nil {
 		return fmt.Errorf("error opening destination file: %v", err)
 	}
@@ -176,6 +178,9 @@ func copyFile(dst, src string, perms os.FileMode) (retErr error) {
 				retErr = fmt.Errorf("error closing destination file: %v", err)
 			}
 		}
+		if retErr != nil {
+			resourceFS.Remove(dst)
+		}
 	}()
 
 	if _, err := io.Copy(writer, reader); err != nil {
@@ -189,9 +194,10 @@ func (f *fileResource) enforceState(ctx context.Context) (inDesiredState bool, e
 	clog.Infof(ctx, "Enforcing state %q for file %q.", f.managedFile.State, f.managedFile.Path)
 	switch f.managedFile.State {
 	case agentendpointpb.OSPolicy_Resource_FileResource_ABSENT:
-		if err := os.Remove(f.managedFile.Path); err != nil {
-			return false, fmt.Errorf("error removing %q: %v", f.managedFile.Path, err)
+		if err := resourceFS.Remove(f.managedFile.Path); err != nil {
+			return false, annotateMACDenial(ctx, fmt.Errorf("error removing %q: %v", f.managedFile.Path, err), f.managedFile.Path)
 		}
+		managedfiles.Forget(ctx, f.managedFile.Path)
 	case agentendpointpb.OSPolicy_Resource_FileResource_PRESENT, agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH:
 		// Download now if for some reason we got this point and have not.
 		if f.managedFile.source == "" {
@@ -199,8 +205,16 @@ func (f *fileResource) enforceState(ctx context.Context) (inDesiredState bool, e
 				return false, err
 			}
 		}
+		managedfiles.CheckFile(ctx, f.managedFile.Path)
+		_, statErr := resourceFS.Stat(f.managedFile.Path)
 		if err := copyFile(f.managedFile.Path, f.managedFile.source, f.managedFile.Permisions); err != nil {
-			return false, fmt.Errorf("error copying %q to %q: %v", f.managedFile.source, f.managedFile.Path, err)
+			// The file is now partly written or removed.
+			managedfiles.Forget(ctx, f.managedFile.Path)
+			return false, annotateMACDenial(ctx, fmt.Errorf("error copying %q to %q: %v", f.managedFile.source, f.managedFile.Path, err), f.managedFile.Path)
+		}
+		managedfiles.Record(ctx, f.managedFile.Path)
+		if err := restoreSecurityContext(ctx, f.managedFile.Path, os.IsNotExist(statErr)); err != nil {
+			clog.Warningf(ctx, "Error restoring security context: %v", err)
 		}
 	default:
 		return false, fmt.Errorf("unrecognized DesiredState for FileResource: %q", f.managedFile.State)
@@ -213,7 +227,7 @@ func (f *fileResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResou
 
 func (f *fileResource) cleanup(ctx context.Context) error {
 	if f.managedFile.tempDir != "" {
-		return os.RemoveAll(f.managedFile.tempDir)
+		return resourceFS.RemoveAll(f.managedFile.tempDir)
 	}
 	return nil
 }
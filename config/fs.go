@@ -0,0 +1,100 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// fileSystem is the file IO used by the file and repository resources and by
+// downloadFile, tests replace it to exercise checkState and enforceState
+// without touching the host.
+type fileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	Create(name string, perm os.FileMode) (io.WriteCloser, error)
+	Stat(name string) (os.FileInfo, error)
+	Remove(name string) error
+	RemoveAll(path string) error
+	MkdirAll(path string, perm os.FileMode) error
+	TempDir(dir, pattern string) (string, error)
+	AtomicWrite(name string, data []byte, perm os.FileMode) error
+	AtomicWriteFiles(files []util.TxFile) error
+	AtomicWriteStream(r io.Reader, checksum, path string, perm os.FileMode) (string, error)
+}
+
+var (
+	// resourceFS is not named fs so it does not shadow io/fs.
+	resourceFS fileSystem = osFS{}
+	// now is the clock used by the package caches.
+	now = time.Now
+)
+
+type osFS struct{}
+
+// osPath returns name in extended-length form on Windows, so policies can
+// manage files nested deeper than MAX_PATH allows, including on UNC shares.
+func osPath(name string) string {
+	if runtime.GOOS != "windows" {
+		return name
+	}
+	if p, err := util.NormPath(name); err == nil {
+		return p
+	}
+	return name
+}
+
+func (osFS) Open(name string) (io.ReadCloser, error) { return os.Open(osPath(name)) }
+
+func (osFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(osPath(name), os.O_TRUNC|os.O_WRONLY|os.O_CREATE, perm)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) { return os.Stat(osPath(name)) }
+
+func (osFS) Remove(name string) error { return os.Remove(osPath(name)) }
+
+func (osFS) RemoveAll(path string) error { return os.RemoveAll(osPath(path)) }
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(osPath(path), perm) }
+
+func (osFS) TempDir(dir, pattern string) (string, error) { return ioutil.TempDir(dir, pattern) }
+
+func (osFS) AtomicWrite(name string, data []byte, perm os.FileMode) error {
+	return util.AtomicWrite(name, data, perm)
+}
+
+func (osFS) AtomicWriteFiles(files []util.TxFile) error {
+	return util.AtomicWriteFiles(files)
+}
+
+func (osFS) AtomicWriteStream(r io.Reader, checksum, path string, perm os.FileMode) (string, error) {
+	return util.AtomicWriteFileStream(r, checksum, path, perm)
+}
+
+// exists reports whether name exists on resourceFS, like util.Exists.
+func exists(name string) bool {
+	if strings.TrimSpace(name) == "" {
+		return false
+	}
+	_, err := resourceFS.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,392 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// memFS is an in memory fileSystem, errs injects an error for any operation
+// on a path and failAfter makes writes to a path fail after that many bytes.
+type memFS struct {
+	mu        sync.Mutex
+	files     map[string][]byte
+	errs      map[string]error
+	failAfter map[string]int
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string][]byte{}, errs: map[string]error{}, failAfter: map[string]int{}}
+}
+
+func useFS(t *testing.T, f fileSystem) {
+	old := resourceFS
+	resourceFS = f
+	t.Cleanup(func() { resourceFS = old })
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (m *memFS) get(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.errs[name]; err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (m *memFS) Open(name string) (io.ReadCloser, error) {
+	data, err := m.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+type memWriter struct {
+	m    *memFS
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	if limit, ok := w.m.failAfter[w.name]; ok && w.buf.Len()+len(p) > limit {
+		n := limit - w.buf.Len()
+		w.buf.Write(p[:n])
+		w.m.files[w.name] = w.buf.Bytes()
+		return n, errors.New("no space left on device")
+	}
+	w.buf.Write(p)
+	w.m.files[w.name] = w.buf.Bytes()
+	return len(p), nil
+}
+
+func (w *memWriter) Close() error { return nil }
+
+func (m *memFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.errs[name]; err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	m.files[name] = nil
+	return &memWriter{m: m, name: name}, nil
+}
+
+func (m *memFS) Stat(name string) (os.FileInfo, error) {
+	data, err := m.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return memFileInfo{name: path.Base(name), size: int64(len(data))}, nil
+}
+
+func (m *memFS) Remove(name string) error {
+	if _, err := m.get(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) RemoveAll(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.files {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			delete(m.files, name)
+		}
+	}
+	return nil
+}
+
+func (m *memFS) MkdirAll(dir string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.errs[dir]; err != nil {
+		return &os.PathError{Op: "mkdir", Path: dir, Err: err}
+	}
+	return nil
+}
+
+func (m *memFS) TempDir(dir, pattern string) (string, error) {
+	return "/tmp/" + pattern + "0", nil
+}
+
+// AtomicWrite leaves any existing contents in place on failure.
+func (m *memFS) AtomicWrite(name string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.errs[name]; err != nil {
+		return &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if limit, ok := m.failAfter[name]; ok && len(data) > limit {
+		return errors.New("no space left on device")
+	}
+	m.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// AtomicWriteFiles writes either all or none of files.
+func (m *memFS) AtomicWriteFiles(files []util.TxFile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range files {
+		if err := m.errs[f.Path]; err != nil {
+			return &os.PathError{Op: "open", Path: f.Path, Err: err}
+		}
+	}
+	for _, f := range files {
+		m.files[f.Path] = append([]byte(nil), f.Content...)
+	}
+	return nil
+}
+
+// AtomicWriteStream verifies checksum before anything is written.
+func (m *memFS) AtomicWriteStream(r io.Reader, checksum, name string, perm os.FileMode) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	computed := hex.EncodeToString(sum[:])
+	if checksum != "" && !strings.EqualFold(checksum, computed) {
+		return "", fmt.Errorf("got %q for checksum, expected %q", computed, checksum)
+	}
+	return computed, m.AtomicWrite(name, data, perm)
+}
+
+func TestFileResourceMemFS(t *testing.T) {
+	ctx := context.Background()
+	mfs := newMemFS()
+	useFS(t, mfs)
+
+	const dst = "/etc/foo.conf"
+	content := strings.Repeat("contents", 10)
+	fr := &fileResource{OSPolicy_Resource_FileResource: &agentendpointpb.OSPolicy_Resource_FileResource{
+		Path:   dst,
+		State:  agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH,
+		Source: &agentendpointpb.OSPolicy_Resource_FileResource_Content{Content: content},
+	}}
+	if _, err := fr.validate(ctx); err != nil {
+		t.Fatalf("validate error: %v", err)
+	}
+
+	// Missing file is not in desired state.
+	if in, err := fr.checkState(ctx); err != nil || in {
+		t.Errorf("checkState() = %v, %v, want false, nil", in, err)
+	}
+
+	// Permission errors are surfaced.
+	mfs.errs[dst] = os.ErrPermission
+	if _, err := fr.checkState(ctx); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("checkState() error = %v, want permission error", err)
+	}
+	if _, err := fr.enforceState(ctx); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("enforceState() error = %v, want permission error", err)
+	}
+	delete(mfs.errs, dst)
+
+	// A partial write is removed rather than left in place.
+	mfs.failAfter[dst] = 10
+	if _, err := fr.enforceState(ctx); err == nil {
+		t.Error("expected enforceState error on partial write")
+	}
+	if _, ok := mfs.files[dst]; ok {
+		t.Errorf("partially written file %q was not removed", dst)
+	}
+	delete(mfs.failAfter, dst)
+
+	if in, err := fr.enforceState(ctx); err != nil || !in {
+		t.Fatalf("enforceState() = %v, %v, want true, nil", in, err)
+	}
+	if got := string(mfs.files[dst]); got != content {
+		t.Errorf("file contents = %q, want %q", got, content)
+	}
+	if in, err := fr.checkState(ctx); err != nil || !in {
+		t.Errorf("checkState() = %v, %v, want true, nil", in, err)
+	}
+
+	if err := fr.cleanup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs.files) != 1 {
+		t.Errorf("expected temp files to be cleaned up, got %v", mfs.files)
+	}
+}
+
+func TestFileResourceRemoteMemFS(t *testing.T) {
+	ctx := context.Background()
+	mfs := newMemFS()
+	useFS(t, mfs)
+
+	const content = "remote contents"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, content)
+	}))
+	defer ts.Close()
+
+	sum := sha256.Sum256([]byte(content))
+	for _, tc := range []struct {
+		name     string
+		checksum string
+		wantErr  bool
+	}{
+		{"NoChecksum", "", false},
+		{"MatchingChecksum", hex.EncodeToString(sum[:]), false},
+		{"BadChecksum", "deadbeef", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mfs.files = map[string][]byte{}
+			const dst = "/etc/remote.conf"
+			fr := &fileResource{OSPolicy_Resource_FileResource: &agentendpointpb.OSPolicy_Resource_FileResource{
+				Path:  dst,
+				State: agentendpointpb.OSPolicy_Resource_FileResource_PRESENT,
+				Source: &agentendpointpb.OSPolicy_Resource_FileResource_File{File: &agentendpointpb.OSPolicy_Resource_File{
+					Type: &agentendpointpb.OSPolicy_Resource_File_Remote_{Remote: &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: ts.URL, Sha256Checksum: tc.checksum}},
+				}},
+			}}
+			_, err := fr.validate(ctx)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected validate error on checksum mismatch")
+				}
+				if len(mfs.files) != 0 {
+					t.Errorf("nothing should be written on checksum mismatch, got %v", mfs.files)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate error: %v", err)
+			}
+			if in, err := fr.enforceState(ctx); err != nil || !in {
+				t.Fatalf("enforceState() = %v, %v, want true, nil", in, err)
+			}
+			if got := string(mfs.files[dst]); got != content {
+				t.Errorf("file contents = %q, want %q", got, content)
+			}
+		})
+	}
+}
+
+func TestRepositoryResourceMemFS(t *testing.T) {
+	ctx := context.Background()
+	mfs := newMemFS()
+	useFS(t, mfs)
+
+	rr := &repositoryResource{managedRepository: ManagedRepository{
+		Apt: &AptRepository{
+			GpgFilePath:     "/etc/apt/trusted.gpg.d/key.gpg",
+			GpgFileContents: []byte("key"),
+			GpgChecksum:     checksum(strings.NewReader("key")),
+		},
+		RepoFilePath:     "/etc/apt/sources.list.d/repo.list",
+		RepoFileContents: []byte("deb http://repo/ stable main"),
+		RepoChecksum:     checksum(strings.NewReader("deb http://repo/ stable main")),
+	}}
+
+	// A failed repo write leaves the resource out of its desired state.
+	mfs.errs["/etc/apt/sources.list.d"] = os.ErrPermission
+	if _, err := rr.enforceState(ctx); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("enforceState() error = %v, want permission error", err)
+	}
+	if in, err := rr.checkState(ctx); err != nil || in {
+		t.Errorf("checkState() = %v, %v, want false, nil", in, err)
+	}
+	delete(mfs.errs, "/etc/apt/sources.list.d")
+
+	if in, err := rr.enforceState(ctx); err != nil || !in {
+		t.Fatalf("enforceState() = %v, %v, want true, nil", in, err)
+	}
+	if in, err := rr.checkState(ctx); err != nil || !in {
+		t.Errorf("checkState() = %v, %v, want true, nil", in, err)
+	}
+
+	// Neither the key nor the repo file is written if one of them fails.
+	delete(mfs.files, "/etc/apt/trusted.gpg.d/key.gpg")
+	delete(mfs.files, "/etc/apt/sources.list.d/repo.list")
+	mfs.errs["/etc/apt/sources.list.d/repo.list"] = os.ErrPermission
+	if _, err := rr.enforceState(ctx); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("enforceState() error = %v, want permission error", err)
+	}
+	if _, ok := mfs.files["/etc/apt/trusted.gpg.d/key.gpg"]; ok {
+		t.Error("gpg key was written without its repo file")
+	}
+	delete(mfs.errs, "/etc/apt/sources.list.d/repo.list")
+	if _, err := rr.enforceState(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A modified key is detected.
+	mfs.files["/etc/apt/trusted.gpg.d/key.gpg"] = []byte("other")
+	if in, err := rr.checkState(ctx); err != nil || in {
+		t.Errorf("checkState() = %v, %v, want false, nil", in, err)
+	}
+
+	// The repo written in the other format is removed.
+	rr.managedRepository.Apt.StalePaths = []string{"/etc/apt/sources.list.d/repo.sources", "/etc/apt/keyrings/key.gpg"}
+	mfs.files["/etc/apt/sources.list.d/repo.sources"] = []byte("Types: deb")
+	if _, err := rr.enforceState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mfs.files["/etc/apt/sources.list.d/repo.sources"]; ok {
+		t.Error("enforceState() did not remove the repo file in the other format")
+	}
+	if in, err := rr.checkState(ctx); err != nil || !in {
+		t.Errorf("checkState() = %v, %v, want true, nil", in, err)
+	}
+	mfs.files["/etc/apt/sources.list.d/repo.sources"] = []byte("Types: deb")
+	if in, err := rr.checkState(ctx); err != nil || in {
+		t.Errorf("checkState() with a stale repo file = %v, %v, want false, nil", in, err)
+	}
+}
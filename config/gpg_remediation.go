@@ -0,0 +1,182 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// gpgErrorPatterns are yum/zypper output fragments that indicate a package
+// could not be installed because of a missing or untrusted repo GPG key.
+var gpgErrorPatterns = []string{
+	"GPG key retrieval failed",
+	"GPG check FAILED",
+	"Public key for",
+	"NOKEY",
+	"Signature verification failed",
+	"is not signed",
+	"untrusted",
+}
+
+var (
+	yumManagedRepoGlob    = fmt.Sprintf(agentconfig.YumRepoFormat(), "*")
+	zypperManagedRepoGlob = fmt.Sprintf(agentconfig.ZypperRepoFormat(), "*")
+	importGPGKeys         = packages.RPMImportKeys
+)
+
+func isGPGError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, p := range gpgErrorPatterns {
+		if strings.Contains(err.Error(), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// managedRepo is a repo section of an osconfig managed repo file.
+type managedRepo struct {
+	id   string
+	name string
+	keys []string
+}
+
+// managedRepos returns the repos in the osconfig managed repo files
+// matching glob.
+func managedRepos(glob string) ([]*managedRepo, error) {
+	files, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []*managedRepo
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		var repo *managedRepo
+		inKeys := false
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			ln := scanner.Text()
+			var key string
+			switch {
+			case strings.HasPrefix(ln, "[") && strings.HasSuffix(strings.TrimSpace(ln), "]"):
+				inKeys = false
+				repo = &managedRepo{id: strings.Trim(strings.TrimSpace(ln), "[]")}
+				repos = append(repos, repo)
+				continue
+			case repo == nil:
+				continue
+			case strings.HasPrefix(ln, "name="):
+				inKeys = false
+				repo.name = strings.TrimSpace(strings.TrimPrefix(ln, "name="))
+			case strings.HasPrefix(ln, "gpgkey="):
+				inKeys = true
+				key = strings.TrimPrefix(ln, "gpgkey=")
+			case inKeys && strings.HasPrefix(ln, " "):
+				key = ln
+			default:
+				inKeys = false
+			}
+			if key = strings.TrimSpace(key); key != "" {
+				repo.keys = append(repo.keys, key)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return repos, nil
+}
+
+// failingRepoKeys returns the keys of the repos in repos that out, the
+// package manager error, refers to by id, name or key URL. yum and dnf
+// list the keys configured for a failing package, zypper and dnf name the
+// repository.
+func failingRepoKeys(repos []*managedRepo, out string) []string {
+	refersTo := func(r *managedRepo) bool {
+		for _, s := range []string{r.id, r.name} {
+			if s != "" && (strings.Contains(out, "'"+s+"'") || strings.Contains(out, `"`+s+`"`)) {
+				return true
+			}
+		}
+		for _, k := range r.keys {
+			if strings.Contains(out, k) {
+				return true
+			}
+		}
+		return false
+	}
+
+	seen := map[string]bool{}
+	var keys []string
+	for _, r := range repos {
+		if !refersTo(r) {
+			continue
+		}
+		for _, k := range r.keys {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+// installWithGPGRemediation runs install and if it fails because of a GPG
+// key issue imports the keys declared by the osconfig managed repos in glob
+// that the error refers to and retries once. GPG failures are reported
+// distinctly from other package errors.
+func installWithGPGRemediation(ctx context.Context, glob string, install func() error) error {
+	err := install()
+	if err == nil || !isGPGError(err) {
+		return err
+	}
+
+	repos, rerr := managedRepos(glob)
+	if rerr != nil {
+		return fmt.Errorf("GPG key error (error reading managed repos: %v): %v", rerr, err)
+	}
+	keys := failingRepoKeys(repos, err.Error())
+	if len(keys) == 0 {
+		return fmt.Errorf("GPG key error (no policy declared keys to import for the failing repositories): %v", err)
+	}
+	clog.Infof(ctx, "Package install failed with a GPG key error, importing policy declared keys %q and retrying.", keys)
+	if ierr := importGPGKeys(ctx, keys); ierr != nil {
+		return fmt.Errorf("GPG key error, importing policy declared keys failed: %v, original error: %v", ierr, err)
+	}
+	if err := install(); err != nil {
+		if isGPGError(err) {
+			return fmt.Errorf("GPG key error after importing policy declared keys: %v", err)
+		}
+		return err
+	}
+	return nil
+}
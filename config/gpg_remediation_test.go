@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFailingRepoKeys(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repo1 := "[repo1]\nname=repo1\nbaseurl=https://repo1\nenabled=1\ngpgcheck=1\ngpgkey=https://repo1/key1\n       https://repo1/key2\n"
+	repo2 := "[repo2]\nname=Second Repo\ngpgkey=https://repo1/key1\nenabled=1\n\n[repo3]\nname=repo3\ngpgkey=https://repo3/key\n"
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "osconfig_managed_repo1.repo"), []byte(repo1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "osconfig_managed_repo2.repo"), []byte(repo2), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := managedRepos(filepath.Join(tmpDir, "osconfig_managed_*.repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc string
+		out  string
+		want []string
+	}{
+		{"yum configured keys", "Public key for foo.rpm is not installed\n Failing package is: foo\n GPG Keys are configured as: https://repo1/key2", []string{"https://repo1/key1", "https://repo1/key2"}},
+		{"dnf repo name", `The GPG keys listed for the "Second Repo" repository are already installed but they are not correct for this package.`, []string{"https://repo1/key1"}},
+		{"zypper repo alias", "Signature verification failed for file 'repomd.xml' from repository 'repo3'.", []string{"https://repo3/key"}},
+		{"no repo", "GPG check FAILED", nil},
+	}
+	for _, tt := range tests {
+		if got := failingRepoKeys(repos, tt.out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: failingRepoKeys() = %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestInstallWithGPGRemediation(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	glob := filepath.Join(tmpDir, "*.repo")
+
+	var imported []string
+	oldImport := importGPGKeys
+	defer func() { importGPGKeys = oldImport }()
+	importGPGKeys = func(ctx context.Context, keys []string) error {
+		imported = append(imported, keys...)
+		return nil
+	}
+
+	gpgErr := errors.New("Signature verification failed for file 'repomd.xml' from repository 'repo'.")
+
+	// Non GPG errors are returned unchanged.
+	pkgErr := errors.New("No package foo available.")
+	if err := installWithGPGRemediation(ctx, glob, func() error { return pkgErr }); err != pkgErr {
+		t.Errorf("expected unchanged error, got: %v", err)
+	}
+
+	// GPG error with no managed repo keys.
+	if err := installWithGPGRemediation(ctx, glob, func() error { return gpgErr }); err == nil || !strings.Contains(err.Error(), "GPG key error") {
+		t.Errorf("expected GPG key error, got: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "repo.repo"), []byte("[repo]\ngpgkey=https://repo/key\n\n[other]\ngpgkey=https://other/key\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Succeeds after importing keys.
+	calls := 0
+	install := func() error {
+		calls++
+		if len(imported) == 0 {
+			return gpgErr
+		}
+		return nil
+	}
+	if err := installWithGPGRemediation(ctx, glob, install); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 2 || !reflect.DeepEqual(imported, []string{"https://repo/key"}) {
+		t.Errorf("unexpected remediation, calls: %d, imported: %q", calls, imported)
+	}
+
+	// Still failing after importing keys.
+	if err := installWithGPGRemediation(ctx, glob, func() error { return gpgErr }); err == nil || !strings.Contains(err.Error(), "after importing") {
+		t.Errorf("expected GPG key error after import, got: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// localRepoSchemes are the repository URI schemes that refer to a directory
+// on the local machine, such as a mounted ISO or an offline mirror.
+var localRepoSchemes = map[string]bool{
+	"file": true,
+	"copy": true,
+	"dir":  true,
+}
+
+// localRepoPath returns the local directory a repository URI refers to.
+func localRepoPath(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || !localRepoSchemes[u.Scheme] || u.Path == "" {
+		return "", false
+	}
+	return filepath.FromSlash(u.Path), true
+}
+
+// validateLocalRepo checks that a local mirror directory exists, if
+// needMetadata is set it must also contain createrepo metadata.
+func validateLocalRepo(dir string, needMetadata bool) error {
+	if !util.Exists(dir) {
+		return fmt.Errorf("local repository %q does not exist", dir)
+	}
+	if needMetadata && !hasRepoMetadata(dir) {
+		return fmt.Errorf("local repository %q has no repodata, it must be created with createrepo", dir)
+	}
+	return nil
+}
+
+// hasRepoMetadata reports whether dir contains rpm-md repository metadata,
+// local zypper directories without it are added as plaindir repositories.
+func hasRepoMetadata(dir string) bool {
+	return util.Exists(filepath.Join(dir, "repodata", "repomd.xml"))
+}
@@ -0,0 +1,139 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestLocalRepoPath(t *testing.T) {
+	tests := []struct {
+		uri   string
+		want  string
+		local bool
+	}{
+		{"file:///mnt/mirror", filepath.FromSlash("/mnt/mirror"), true},
+		{"file:/mnt/mirror", filepath.FromSlash("/mnt/mirror"), true},
+		{"dir:///srv/rpms", filepath.FromSlash("/srv/rpms"), true},
+		{"copy:///media/cdrom", filepath.FromSlash("/media/cdrom"), true},
+		{"https://mirror/debian", "", false},
+		{"baseurl", "", false},
+	}
+	for _, tt := range tests {
+		got, local := localRepoPath(tt.uri)
+		if got != tt.want || local != tt.local {
+			t.Errorf("localRepoPath(%q) = (%q, %t), want (%q, %t)", tt.uri, got, local, tt.want, tt.local)
+		}
+	}
+}
+
+func TestLocalRepositoryValidate(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	validate := func(rr *agentendpointpb.OSPolicy_Resource_RepositoryResource) (*repositoryResource, error) {
+		pr := &OSPolicyResource{
+			OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+				ResourceType: &agentendpointpb.OSPolicy_Resource_Repository{Repository: rr},
+			},
+		}
+		if err := pr.Validate(ctx); err != nil {
+			return nil, err
+		}
+		return pr.resource.(*repositoryResource), nil
+	}
+	zypperRepo := &agentendpointpb.OSPolicy_Resource_RepositoryResource{
+		Repository: &agentendpointpb.OSPolicy_Resource_RepositoryResource_Zypper{
+			Zypper: &agentendpointpb.OSPolicy_Resource_RepositoryResource_ZypperRepository{Id: "local", BaseUrl: "dir://" + filepath.ToSlash(tmpDir)},
+		},
+	}
+	yumRepo := &agentendpointpb.OSPolicy_Resource_RepositoryResource{
+		Repository: &agentendpointpb.OSPolicy_Resource_RepositoryResource_Yum{
+			Yum: &agentendpointpb.OSPolicy_Resource_RepositoryResource_YumRepository{Id: "local", BaseUrl: "file://" + filepath.ToSlash(tmpDir)},
+		},
+	}
+
+	// A directory of rpms is added as a plaindir zypper repo.
+	r, err := validate(zypperRepo)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(string(r.managedRepository.RepoFileContents), "type=plaindir\n") {
+		t.Errorf("expected plaindir repo, got:\n%s", r.managedRepository.RepoFileContents)
+	}
+
+	// Yum requires createrepo metadata.
+	if _, err := validate(yumRepo); err == nil || !strings.Contains(err.Error(), "createrepo") {
+		t.Errorf("expected missing repodata error, got: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "repodata"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "repodata", "repomd.xml"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validate(yumRepo); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	r, err = validate(zypperRepo)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(string(r.managedRepository.RepoFileContents), "plaindir") {
+		t.Errorf("expected rpm-md repo, got:\n%s", r.managedRepository.RepoFileContents)
+	}
+
+	// Missing mirrors are reported.
+	aptRepo := &agentendpointpb.OSPolicy_Resource_RepositoryResource{
+		Repository: &agentendpointpb.OSPolicy_Resource_RepositoryResource_Apt{
+			Apt: &agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository{Uri: "file://" + filepath.ToSlash(filepath.Join(tmpDir, "missing")), Distribution: "stable"},
+		},
+	}
+	if _, err := validate(aptRepo); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected missing mirror error, got: %v", err)
+	}
+}
+
+func TestReadGPGKeyLocal(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	key := filepath.Join(tmpDir, "key.gpg")
+	if err := ioutil.WriteFile(key, []byte("key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readGPGKey("file://" + filepath.ToSlash(key))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(got) != "key" {
+		t.Errorf("readGPGKey() = %q, want %q", got, "key")
+	}
+}
@@ -0,0 +1,167 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// Mandatory access control (SELinux/AppArmor) helpers for file and exec resources.
+
+const maxMACDenials = 5
+
+var (
+	selinuxEnforceFile  = "/sys/fs/selinux/enforce"
+	apparmorEnabledFile = "/sys/module/apparmor/parameters/enabled"
+	restorecon          = "restorecon"
+	macDenialLogFiles   = []string{"/var/log/audit/audit.log", "/var/log/kern.log", "/var/log/messages"}
+	// macDenialScanBytes is how much of the end of each log is searched for
+	// denials, the denial of a failed step is among the latest entries.
+	macDenialScanBytes int64 = 4 << 20
+
+	auditEventRE = regexp.MustCompile(`audit\(([0-9.:]+)\)`)
+)
+
+// macMode returns the active mandatory access control system, or "" if none is enforcing.
+func macMode() string {
+	if goos != "linux" {
+		return ""
+	}
+	if d, err := ioutil.ReadFile(selinuxEnforceFile); err == nil && strings.TrimSpace(string(d)) == "1" {
+		return "SELinux"
+	}
+	if d, err := ioutil.ReadFile(apparmorEnabledFile); err == nil && strings.TrimSpace(string(d)) == "Y" {
+		return "AppArmor"
+	}
+	return ""
+}
+
+// restoreSecurityContext applies the default SELinux context for its
+// location to path if the file was newly created. Files that existed keep
+// their context, it may have been set on purpose.
+func restoreSecurityContext(ctx context.Context, path string, created bool) error {
+	if !created || macMode() != "SELinux" {
+		return nil
+	}
+	cmd, err := exec.LookPath(restorecon)
+	if err != nil {
+		return nil
+	}
+	_, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, cmd, path))
+	if err != nil {
+		return fmt.Errorf("error running %s on %q: %v, stderr: %q", cmd, path, err, stderr)
+	}
+	return nil
+}
+
+// macDenials returns the most recent SELinux AVC or AppArmor denial log
+// entries for path. AppArmor logs the full path, an AVC only has the base
+// name, it matches through the PATH record or path field of its audit
+// event.
+func macDenials(path string) []string {
+	quoted := strconv.Quote(path)
+	var denials []string
+	for _, logFile := range macDenialLogFiles {
+		for _, ln := range scanMACLog(logFile, quoted) {
+			denials = append(denials, ln)
+			if len(denials) > maxMACDenials {
+				denials = denials[1:]
+			}
+		}
+	}
+	return denials
+}
+
+// scanMACLog streams the end of logFile and returns the denials matching
+// quoted, the quoted full path.
+func scanMACLog(logFile, quoted string) []string {
+	f, err := os.Open(logFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() > macDenialScanBytes {
+		if _, err := f.Seek(-macDenialScanBytes, io.SeekEnd); err != nil {
+			return nil
+		}
+	}
+
+	// An AVC can match through a PATH record that follows it.
+	type denial struct {
+		event string
+		ln    string
+		match bool
+	}
+	var found []denial
+	events := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		ln := scanner.Text()
+		var event string
+		if m := auditEventRE.FindStringSubmatch(ln); m != nil {
+			event = m[1]
+		}
+		switch {
+		case strings.Contains(ln, `apparmor="DENIED"`):
+			if strings.Contains(ln, "name="+quoted) {
+				found = append(found, denial{ln: ln, match: true})
+			}
+		case strings.Contains(ln, "avc:  denied"):
+			match := strings.Contains(ln, "path="+quoted)
+			if match || event != "" {
+				found = append(found, denial{event: event, ln: ln, match: match})
+			}
+		case event != "" && strings.Contains(ln, "type=PATH") && strings.Contains(ln, "name="+quoted):
+			events[event] = true
+		}
+	}
+	var denials []string
+	for _, d := range found {
+		if d.match || events[d.event] {
+			denials = append(denials, d.ln)
+		}
+	}
+	return denials
+}
+
+// annotateMACDenial adds any matching MAC denial information to err, this
+// allows a policy owner to see why a resource could not be enforced.
+func annotateMACDenial(ctx context.Context, err error, path string) error {
+	if err == nil {
+		return nil
+	}
+	mode := macMode()
+	if mode == "" {
+		return err
+	}
+	denials := macDenials(path)
+	if len(denials) == 0 {
+		return err
+	}
+	clog.Debugf(ctx, "%s denials found for %q: %q", mode, path, denials)
+	return fmt.Errorf("%v, possible %s denial: %s", err, mode, strings.Join(denials, "; "))
+}
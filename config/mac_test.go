@@ -0,0 +1,115 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnnotateMACDenial(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldEnforce, oldApparmor, oldLogs, oldGoos := selinuxEnforceFile, apparmorEnabledFile, macDenialLogFiles, goos
+	defer func() {
+		selinuxEnforceFile, apparmorEnabledFile, macDenialLogFiles, goos = oldEnforce, oldApparmor, oldLogs, oldGoos
+	}()
+	goos = "linux"
+	selinuxEnforceFile = filepath.Join(tmpDir, "enforce")
+	apparmorEnabledFile = filepath.Join(tmpDir, "enabled")
+	auditLog := filepath.Join(tmpDir, "audit.log")
+	macDenialLogFiles = []string{auditLog}
+
+	avc := `type=AVC msg=audit(1600000000.123:456): avc:  denied  { write } for  pid=1 comm="osconfig" name="foo.conf" scontext=system_u:system_r:unconfined_service_t:s0 tcontext=system_u:object_r:etc_t:s0 tclass=file permissive=0`
+	sameName := `type=AVC msg=audit(1600000000.123:458): avc:  denied  { write } for  pid=1 comm="osconfig" name="foo.conf" scontext=system_u:system_r:unconfined_service_t:s0 tcontext=system_u:object_r:tmp_t:s0 tclass=file permissive=0`
+	logData := strings.Join([]string{
+		`type=SYSCALL msg=audit(1600000000.123:456): arch=c000003e syscall=257 success=no`,
+		avc,
+		`type=PATH msg=audit(1600000000.123:456): item=0 name="/etc/foo.conf" inode=1 nametype=NORMAL`,
+		`type=AVC msg=audit(1600000000.123:457): avc:  denied  { read } for  pid=1 comm="cat" name="other.conf"`,
+		sameName,
+		`type=PATH msg=audit(1600000000.123:458): item=0 name="/tmp/foo.conf" inode=2 nametype=NORMAL`,
+	}, "\n")
+	if err := ioutil.WriteFile(auditLog, []byte(logData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origErr := errors.New("permission denied")
+
+	// No MAC system enforcing, error is unchanged.
+	if got := annotateMACDenial(ctx, origErr, "/etc/foo.conf"); got != origErr {
+		t.Errorf("expected unchanged error, got: %v", got)
+	}
+
+	if err := ioutil.WriteFile(selinuxEnforceFile, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := macMode(); got != "SELinux" {
+		t.Errorf("macMode() = %q, want SELinux", got)
+	}
+
+	got := annotateMACDenial(ctx, origErr, "/etc/foo.conf")
+	if !strings.Contains(got.Error(), "possible SELinux denial") || !strings.Contains(got.Error(), avc) {
+		t.Errorf("unexpected annotated error: %v", got)
+	}
+	if strings.Contains(got.Error(), "other.conf") || strings.Contains(got.Error(), "tmp_t") {
+		t.Errorf("annotated error contains unrelated denial: %v", got)
+	}
+
+	// Only the end of the log is searched.
+	oldScan := macDenialScanBytes
+	macDenialScanBytes = int64(len(sameName) + 100)
+	if got := annotateMACDenial(ctx, origErr, "/etc/foo.conf"); got != origErr {
+		t.Errorf("expected unchanged error for a denial before the scanned part, got: %v", got)
+	}
+	macDenialScanBytes = oldScan
+
+	// No matching denials, error is unchanged.
+	if got := annotateMACDenial(ctx, origErr, "/etc/bar.conf"); got != origErr {
+		t.Errorf("expected unchanged error, got: %v", got)
+	}
+
+	if got := annotateMACDenial(ctx, nil, "/etc/foo.conf"); got != nil {
+		t.Errorf("expected nil error, got: %v", got)
+	}
+
+	os.Remove(selinuxEnforceFile)
+	if err := ioutil.WriteFile(apparmorEnabledFile, []byte("Y\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := macMode(); got != "AppArmor" {
+		t.Errorf("macMode() = %q, want AppArmor", got)
+	}
+
+	denied := `audit: type=1400 audit(1600000000.123:460): apparmor="DENIED" operation="open" profile="osconfig" name="/etc/foo.conf" requested_mask="w"`
+	other := `audit: type=1400 audit(1600000000.123:461): apparmor="DENIED" operation="open" profile="osconfig" name="/opt/foo.conf" requested_mask="w"`
+	if err := ioutil.WriteFile(auditLog, []byte(denied+"\n"+other+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got = annotateMACDenial(ctx, origErr, "/etc/foo.conf")
+	if !strings.Contains(got.Error(), denied) || strings.Contains(got.Error(), "/opt/foo.conf") {
+		t.Errorf("unexpected annotated error: %v", got)
+	}
+}
@@ -0,0 +1,159 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// PackageBatchKey returns the key of the package transaction this resource
+// can be enforced in, resources with the same key can be installed or
+// removed together. It returns "" for resources that are not packages
+// installed or removed by name, that set package options, or that cannot be
+// enforced in a regular transaction on this system. Validate must be called
+// prior to running PackageBatchKey.
+func (r *OSPolicyResource) PackageBatchKey() string {
+	if r.notEnforceable != nil {
+		return ""
+	}
+	name, key := r.batchPackage()
+	if name == "" {
+		return ""
+	}
+	return key
+}
+
+func (r *OSPolicyResource) batchPackage() (name, key string) {
+	p, ok := r.resource.(*packageResouce)
+	if !ok || p.transactional {
+		return "", ""
+	}
+	var manager string
+	var state agentendpointpb.OSPolicy_Resource_PackageResource_DesiredState
+	switch mp := p.managedPackage; {
+	case mp.Apt != nil:
+		if mp.Apt.hold || mp.Apt.agentHeld || mp.Apt.wsl {
+			return "", ""
+		}
+		manager, name, state = "apt", mp.Apt.name, mp.Apt.DesiredState
+	case mp.GooGet != nil:
+		manager, name, state = "googet", mp.GooGet.PackageResource.GetName(), mp.GooGet.DesiredState
+	case mp.Yum != nil:
+		manager, name, state = "yum", mp.Yum.PackageResource.GetName(), mp.Yum.DesiredState
+	case mp.Zypper != nil:
+		manager, name, state = "zypper", mp.Zypper.PackageResource.GetName(), mp.Zypper.DesiredState
+	default:
+		return "", ""
+	}
+	switch state {
+	case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED, agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
+	default:
+		return "", ""
+	}
+	return name, manager + "/" + state.String()
+}
+
+// EnforcePackageBatch enforces the desired state of package resources with
+// the same PackageBatchKey in a single package manager transaction. The
+// resources are left for the caller to check, on error none of them should
+// be considered enforced.
+func EnforcePackageBatch(ctx context.Context, rs []*OSPolicyResource) error {
+	if len(rs) == 0 {
+		return nil
+	}
+	var names []string
+	var key string
+	seen := map[string]bool{}
+	for _, r := range rs {
+		name, k := r.batchPackage()
+		if name == "" {
+			return fmt.Errorf("resource %q can not be enforced in a package batch", r.GetId())
+		}
+		if key != "" && k != key {
+			return fmt.Errorf("resource %q does not belong to package batch %q", r.GetId(), key)
+		}
+		key = k
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	manager, state, _ := strings.Cut(key, "/")
+	install := state == agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED.String()
+	action := "Removing"
+	if install {
+		action = "Installing"
+	}
+	clog.Infof(ctx, "%s %s packages %q for %d resources in one transaction", action, manager, names, len(rs))
+
+	var err error
+	switch manager {
+	case "apt":
+		aptInstalled.cache = nil
+		if !install {
+			err = packages.RemoveAptPackages(ctx, names)
+			break
+		}
+		if _, err = packages.AptUpdate(ctx); err != nil {
+			break
+		}
+		if err = installSpaceCheck(ctx, names); err != nil {
+			break
+		}
+		err = packages.InstallAptPackages(ctx, names)
+	case "googet":
+		gooInstalled.cache = nil
+		if !install {
+			err = packages.RemoveGooGetPackages(ctx, names)
+			break
+		}
+		err = packages.InstallGooGetPackages(ctx, names)
+	case "yum":
+		yumInstalled.cache = nil
+		if !install {
+			err = packages.RemoveYumPackages(ctx, names)
+			break
+		}
+		if err = installSpaceCheck(ctx, names); err != nil {
+			break
+		}
+		err = installWithGPGRemediation(ctx, yumManagedRepoGlob, func() error { return packages.InstallYumPackages(ctx, names) })
+	case "zypper":
+		zypperInstalled.cache = nil
+		if !install {
+			err = packages.RemoveZypperPackages(ctx, names)
+			break
+		}
+		if err = installSpaceCheck(ctx, names); err != nil {
+			break
+		}
+		err = installWithGPGRemediation(ctx, zypperManagedRepoGlob, func() error { return packages.InstallZypperPackages(ctx, names) })
+	default:
+		return errors.New("unknown package batch " + key)
+	}
+	if err != nil {
+		return fmt.Errorf("error %s %s packages %q: %w", strings.ToLower(action), manager, names, err)
+	}
+	return nil
+}
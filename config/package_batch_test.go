@@ -0,0 +1,115 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func validatedPackage(ctx context.Context, t *testing.T, id string, prpb *agentendpointpb.OSPolicy_Resource_PackageResource) *OSPolicyResource {
+	pr := &OSPolicyResource{
+		OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+			Id:           id,
+			ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: prpb},
+		},
+	}
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+	return pr
+}
+
+func TestPackageBatchKey(t *testing.T) {
+	ctx := context.Background()
+	stubAptHold(t, []string{"held"}, nil)
+	tests := []struct {
+		name string
+		prpb *agentendpointpb.OSPolicy_Resource_PackageResource
+		want string
+	}{
+		{"AptInstalled", aptInstalledPR, "apt/INSTALLED"},
+		{"AptRemoved", aptRemovedPR, "apt/REMOVED"},
+		{"AptHeld", aptHeldPR, ""},
+		{"YumInstalled", yumInstalledPR, "yum/INSTALLED"},
+		{"ZypperRemoved", zypperRemovedPR, "zypper/REMOVED"},
+		{"GooGetInstalled", googetInstalledPR, "googet/INSTALLED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := validatedPackage(ctx, t, tt.name, tt.prpb)
+			if got := pr.PackageBatchKey(); got != tt.want {
+				t.Errorf("PackageBatchKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnforcePackageBatch(t *testing.T) {
+	ctx := context.Background()
+	stubAptHold(t, []string{"held"}, nil)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	packages.SetCommandRunner(mockCommandRunner)
+
+	oldInstallSpaceCheck := installSpaceCheck
+	defer func() { installSpaceCheck = oldInstallSpaceCheck }()
+	var spaceChecked []string
+	installSpaceCheck = func(_ context.Context, pkgs []string) error {
+		spaceChecked = pkgs
+		return nil
+	}
+
+	bar := &agentendpointpb.OSPolicy_Resource_PackageResource{
+		DesiredState:  agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
+		SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Yum{Yum: &agentendpointpb.OSPolicy_Resource_PackageResource_YUM{Name: "bar"}},
+	}
+	rs := []*OSPolicyResource{
+		validatedPackage(ctx, t, "foo", yumInstalledPR),
+		validatedPackage(ctx, t, "bar", bar),
+		// The same package from another policy is installed once.
+		validatedPackage(ctx, t, "foo-again", yumInstalledPR),
+	}
+	yumInstalled.cache = map[string]struct{}{"baz": {}}
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command("/usr/bin/yum", "install", "--assumeyes", "foo", "bar"))).Times(1)
+
+	if err := EnforcePackageBatch(ctx, rs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if yumInstalled.cache != nil {
+		t.Error("EnforcePackageBatch did not reset the installed package cache")
+	}
+	if len(spaceChecked) != 2 {
+		t.Errorf("space checked for %q, want foo and bar", spaceChecked)
+	}
+
+	// Resources from different batches are rejected.
+	rs = append(rs, validatedPackage(ctx, t, "foo-removed", yumRemovedPR))
+	if err := EnforcePackageBatch(ctx, rs); err == nil {
+		t.Error("EnforcePackageBatch() with mixed batch keys should return an error")
+	}
+	if err := EnforcePackageBatch(ctx, []*OSPolicyResource{validatedPackage(ctx, t, "held", aptHeldPR)}); err == nil {
+		t.Error("EnforcePackageBatch() with a held apt package should return an error")
+	}
+}
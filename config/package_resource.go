@@ -20,13 +20,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 
@@ -39,24 +45,62 @@ var (
 	// Clear out the entry if the last lookup is > 7 days ago.
 	packageInfoCacheTimeout = -168 * time.Hour
 	packageInfoCacheStore   packageInfoCache
+
+	installSpaceCheck = packages.CheckInstallSpace
+
+	aptHoldPackages = agentconfig.AptHoldPackages
+	// aptHoldsFile records the apt packages the agent has held, only these
+	// are unheld once they are no longer in aptHoldPackages.
+	aptHoldsFile = filepath.Join(agentconfig.CacheDir(), "config_apt_holds.json")
+	aptHoldsMu   sync.Mutex
+
+	// Apt packages on Windows hosts are checked in the WSL distributions.
+	wslHost          = runtime.GOOS == "windows"
+	wslDistributions = inventory.GetWSLPackages
+
+	verifyDebSignature = packages.VerifyDebSignature
+	verifyRPMSignature = packages.VerifyRPMSignature
+	// packageSignature is the signature verification required of deb and
+	// rpm packages.
+	packageSignature = func() packages.SignatureOptions {
+		verify, keyrings := agentconfig.PackageSignatures()
+		return packages.SignatureOptions{Verify: verify, Keyrings: keyrings}
+	}
 )
 
 type packageResouce struct {
 	*agentendpointpb.OSPolicy_Resource_PackageResource
 
 	managedPackage ManagedPackage
+	// transactional installs and removes zypper packages with
+	// transactional-update, set for systems with a read-only root.
+	transactional bool
+	// pendingSnapshot is the root of the snapshot that already has this
+	// transactional package in its desired state, waiting for a reboot.
+	pendingSnapshot string
 }
 
 // AptPackage describes an apt package resource.
 type AptPackage struct {
 	PackageResource *agentendpointpb.OSPolicy_Resource_PackageResource_APT
 	DesiredState    agentendpointpb.OSPolicy_Resource_PackageResource_DesiredState
+	name            string
+	// hold is set for installed packages in the osconfig-apt-hold list.
+	hold bool
+	// agentHeld is set if the agent has held the package.
+	agentHeld bool
+	// wsl is set on Windows hosts, the package is only checked in the WSL
+	// distributions.
+	wsl bool
 }
 
 // DebPackage describes a deb package resource.
 type DebPackage struct {
 	PackageResource *agentendpointpb.OSPolicy_Resource_PackageResource_Deb
 	name, localPath string
+	// source is the package source without its option block.
+	source    *agentendpointpb.OSPolicy_Resource_File
+	signature packages.SignatureOptions
 }
 
 // GooGetPackage describes a googet package resource.
@@ -67,10 +111,22 @@ type GooGetPackage struct {
 
 // MSIPackage describes an msi package resource.
 type MSIPackage struct {
+	DesiredState                        agentendpointpb.OSPolicy_Resource_PackageResource_DesiredState
 	PackageResource                     *agentendpointpb.OSPolicy_Resource_PackageResource_MSI
 	productName, productCode, localPath string
 }
 
+// MSUPackage describes an msu or cab Windows update package resource, these
+// are managed through the MSI resource type. A catalog package is downloaded
+// from the Microsoft Update Catalog by KB id and may consist of several
+// update packages.
+type MSUPackage struct {
+	PackageResource *agentendpointpb.OSPolicy_Resource_PackageResource_MSI
+	kb, localPath   string
+	cab, catalog    bool
+	catalogPaths    []string
+}
+
 // YumPackage describes a yum package resource.
 type YumPackage struct {
 	PackageResource *agentendpointpb.OSPolicy_Resource_PackageResource_YUM
@@ -87,6 +143,9 @@ type ZypperPackage struct {
 type RPMPackage struct {
 	PackageResource *agentendpointpb.OSPolicy_Resource_PackageResource_RPM
 	name, localPath string
+	// source is the package source without its option block.
+	source    *agentendpointpb.OSPolicy_Resource_File
+	signature packages.SignatureOptions
 }
 
 // ManagedPackage is the package that this PackageResource manages.
@@ -95,6 +154,7 @@ type ManagedPackage struct {
 	Deb    *DebPackage
 	GooGet *GooGetPackage
 	MSI    *MSIPackage
+	MSU    *MSUPackage
 	Yum    *YumPackage
 	Zypper *ZypperPackage
 	RPM    *RPMPackage
@@ -111,6 +171,21 @@ func (p *packageResouce) validateFile(file *agentendpointpb.OSPolicy_Resource_Fi
 	return nil
 }
 
+// sourceFileName returns the file name of the package file source.
+func sourceFileName(file *agentendpointpb.OSPolicy_Resource_File) string {
+	switch {
+	case file.GetLocalPath() != "":
+		return filepath.Base(file.GetLocalPath())
+	case file.GetRemote() != nil:
+		if u, err := url.Parse(file.GetRemote().GetUri()); err == nil {
+			return path.Base(u.Path)
+		}
+		return path.Base(file.GetRemote().GetUri())
+	default:
+		return path.Base(file.GetGcs().GetObject())
+	}
+}
+
 type packageInfoCache map[string]packageInfo
 
 type packageInfo struct {
@@ -167,7 +242,7 @@ func getPackageInfoFromCache(ctx context.Context, pkgFile *agentendpointpb.OSPol
 func updatePackageInfoCache(ctx context.Context, info *packages.PkgInfo, pkgFile *agentendpointpb.OSPolicy_Resource_File) {
 	loadPackageInfoCache(ctx)
 	for k, v := range packageInfoCacheStore {
-		if time.Now().Add(packageInfoCacheTimeout).After(v.LastLookup) {
+		if now().Add(packageInfoCacheTimeout).After(v.LastLookup) {
 			delete(packageInfoCacheStore, k)
 		}
 	}
@@ -178,7 +253,7 @@ func updatePackageInfoCache(ctx context.Context, info *packages.PkgInfo, pkgFile
 		clog.Warningf(ctx, "Error creating the package info cache: %v", err)
 		return
 	}
-	packageInfoCacheStore[key] = packageInfo{PkgInfo: info, LastLookup: time.Now()}
+	packageInfoCacheStore[key] = packageInfo{PkgInfo: info, LastLookup: now()}
 }
 
 func savePackageInfoCache(ctx context.Context) error {
@@ -197,11 +272,24 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 	switch p.GetSystemPackage().(type) {
 	case *agentendpointpb.OSPolicy_Resource_PackageResource_Apt:
 		pr := p.GetApt()
+		if !packages.AptExists && wslHost {
+			p.managedPackage.Apt = &AptPackage{DesiredState: p.GetDesiredState(), PackageResource: pr, name: pr.GetName(), wsl: true}
+			break
+		}
 		if !packages.AptExists {
 			return nil, fmt.Errorf("cannot manage Apt package %q because apt-get does not exist on the system", pr.GetName())
 		}
+		name := pr.GetName()
+		var hold bool
+		if p.GetDesiredState() == agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED {
+			for _, h := range aptHoldPackages() {
+				if h == name {
+					hold = true
+				}
+			}
+		}
 
-		p.managedPackage.Apt = &AptPackage{DesiredState: p.GetDesiredState(), PackageResource: pr}
+		p.managedPackage.Apt = &AptPackage{DesiredState: p.GetDesiredState(), PackageResource: pr, name: name, hold: hold, agentHeld: aptHeldByAgent(ctx, name)}
 
 	case *agentendpointpb.OSPolicy_Resource_PackageResource_Deb_:
 		pr := p.GetDeb()
@@ -211,12 +299,12 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 		if p.GetDesiredState() != agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED {
 			return nil, fmt.Errorf("desired state of %q not applicable for deb package", p.GetDesiredState())
 		}
-		if err := p.validateFile(pr.GetSource()); err != nil {
+		signature, source := packageSignature(), pr.GetSource()
+		if err := p.validateFile(source); err != nil {
 			return nil, err
 		}
 		var localPath string
 		var err error
-		source := p.GetDeb().GetSource()
 		info := getPackageInfoFromCache(ctx, source)
 		if info == nil {
 			localPath, err = p.download(ctx, "pkg.deb", source)
@@ -231,7 +319,7 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 		// Always update the cache to update the timestamps.
 		updatePackageInfoCache(ctx, info, source)
 
-		p.managedPackage.Deb = &DebPackage{PackageResource: pr, localPath: localPath, name: info.Name}
+		p.managedPackage.Deb = &DebPackage{PackageResource: pr, localPath: localPath, name: info.Name, source: source, signature: signature}
 
 	case *agentendpointpb.OSPolicy_Resource_PackageResource_Googet:
 		pr := p.GetGooget()
@@ -246,15 +334,38 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 		if !packages.MSIExists {
 			return nil, fmt.Errorf("cannot manage MSI package because msiexec does not exist on the system")
 		}
-		if p.GetDesiredState() != agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED {
+		kb := packages.CatalogKB(pr.GetSource().GetRemote().GetUri())
+		isUpdate, isCAB := packages.IsUpdatePackage(sourceFileName(pr.GetSource()))
+		switch {
+		case p.GetDesiredState() == agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
+		case p.GetDesiredState() == agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED && kb == "" && !isUpdate:
+		default:
 			return nil, fmt.Errorf("desired state of %q not applicable for MSI package", p.GetDesiredState())
 		}
-		if err := p.validateFile(pr.GetSource()); err != nil {
+		if kb != "" {
+			if err := p.validateFile(pr.GetSource()); err != nil {
+				return nil, err
+			}
+			p.managedPackage.MSU = &MSUPackage{PackageResource: pr, kb: kb, catalog: true}
+			break
+		}
+		if isUpdate {
+			if err := p.validateFile(pr.GetSource()); err != nil {
+				return nil, err
+			}
+			kb := packages.KBFromFileName(sourceFileName(pr.GetSource()))
+			if kb == "" {
+				return nil, fmt.Errorf("cannot determine the KB id of update package %q, the file name must contain it", sourceFileName(pr.GetSource()))
+			}
+			p.managedPackage.MSU = &MSUPackage{PackageResource: pr, kb: kb, cab: isCAB}
+			break
+		}
+		source := pr.GetSource()
+		if err := p.validateFile(source); err != nil {
 			return nil, err
 		}
 		var localPath string
 		var err error
-		source := p.GetMsi().GetSource()
 		info := getPackageInfoFromCache(ctx, source)
 		if info == nil {
 			localPath, err = p.download(ctx, "pkg.msi", source)
@@ -271,7 +382,7 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 		// Always update the cache to update the timestamps.
 		updatePackageInfoCache(ctx, info, source)
 
-		p.managedPackage.MSI = &MSIPackage{PackageResource: pr, localPath: localPath, productName: info.Name, productCode: info.Version}
+		p.managedPackage.MSI = &MSIPackage{DesiredState: p.GetDesiredState(), PackageResource: pr, localPath: localPath, productName: info.Name, productCode: info.Version}
 
 	case *agentendpointpb.OSPolicy_Resource_PackageResource_Yum:
 		pr := p.GetYum()
@@ -297,12 +408,12 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 		if p.GetDesiredState() != agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED {
 			return nil, fmt.Errorf("desired state of %q not applicable for rpm package", p.GetDesiredState())
 		}
-		if err := p.validateFile(pr.GetSource()); err != nil {
+		signature, source := packageSignature(), pr.GetSource()
+		if err := p.validateFile(source); err != nil {
 			return nil, err
 		}
 		var localPath string
 		var err error
-		source := p.GetRpm().GetSource()
 		info := getPackageInfoFromCache(ctx, source)
 		if info == nil {
 			localPath, err = p.download(ctx, "pkg.rpm", source)
@@ -317,7 +428,7 @@ func (p *packageResouce) validate(ctx context.Context) (*ManagedResources, error
 		// Always update the cache to update the timestamps.
 		updatePackageInfoCache(ctx, info, source)
 
-		p.managedPackage.RPM = &RPMPackage{PackageResource: pr, localPath: localPath, name: info.Name}
+		p.managedPackage.RPM = &RPMPackage{PackageResource: pr, localPath: localPath, name: info.Name, source: source, signature: signature}
 
 	default:
 		return nil, fmt.Errorf("SystemPackage field not set or references unknown package manager: %v", p.GetSystemPackage())
@@ -340,6 +451,90 @@ var (
 	rpmInstalled    = &packageCache{}
 )
 
+// aptPackageHeld reports whether an installed apt package is held.
+func aptPackageHeld(ctx context.Context, name string) (bool, error) {
+	held, err := packages.AptHeldPackages(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, h := range held {
+		if h == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func loadAptHolds(ctx context.Context) map[string]bool {
+	holds := map[string]bool{}
+	data, err := ioutil.ReadFile(aptHoldsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			clog.Warningf(ctx, "Error reading the apt holds file: %v", err)
+		}
+		return holds
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		clog.Warningf(ctx, "Error parsing the apt holds file: %v", err)
+		return holds
+	}
+	for _, name := range names {
+		holds[name] = true
+	}
+	return holds
+}
+
+// aptHeldByAgent reports whether the agent has held an apt package.
+func aptHeldByAgent(ctx context.Context, name string) bool {
+	aptHoldsMu.Lock()
+	defer aptHoldsMu.Unlock()
+	return loadAptHolds(ctx)[name]
+}
+
+// recordAptHold adds or removes an apt package from the packages the agent
+// has held.
+func recordAptHold(ctx context.Context, name string, held bool) error {
+	aptHoldsMu.Lock()
+	defer aptHoldsMu.Unlock()
+	holds := loadAptHolds(ctx)
+	if holds[name] == held {
+		return nil
+	}
+	if held {
+		holds[name] = true
+	} else {
+		delete(holds, name)
+	}
+	names := []string{}
+	for name := range holds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	data, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(aptHoldsFile, data, 0644)
+}
+
+// aptHold holds an apt package, it is recorded first so a hold is never
+// left behind without a record.
+func aptHold(ctx context.Context, name string) error {
+	if err := recordAptHold(ctx, name, true); err != nil {
+		return fmt.Errorf("error recording the hold of apt package %q: %v", name, err)
+	}
+	return packages.AptHold(ctx, []string{name})
+}
+
+// aptUnhold removes a hold the agent placed on an apt package.
+func aptUnhold(ctx context.Context, name string) error {
+	if err := packages.AptUnhold(ctx, []string{name}); err != nil {
+		return err
+	}
+	return recordAptHold(ctx, name, false)
+}
+
 func populateInstalledCache(ctx context.Context, mp ManagedPackage) error {
 	var cache *packageCache
 	var refreshFunc func(context.Context) ([]*packages.PkgInfo, error)
@@ -361,6 +556,10 @@ func populateInstalledCache(ctx context.Context, mp ManagedPackage) error {
 		// We just query per each MSI.
 		return nil
 
+	case mp.MSU != nil:
+		// We just query per each update package.
+		return nil
+
 	// TODO: implement yum functions
 	case mp.Yum != nil:
 		cache = yumInstalled
@@ -379,7 +578,7 @@ func populateInstalledCache(ctx context.Context, mp ManagedPackage) error {
 	}
 
 	// Cache already populated within the last 3 min.
-	if cache.cache != nil && cache.refreshed.After(time.Now().Add(-3*time.Minute)) {
+	if cache.cache != nil && cache.refreshed.After(now().Add(-3*time.Minute)) {
 		return nil
 	}
 
@@ -392,7 +591,7 @@ func populateInstalledCache(ctx context.Context, mp ManagedPackage) error {
 	for _, pkg := range pis {
 		cache.cache[pkg.Name] = struct{}{}
 	}
-	cache.refreshed = time.Now()
+	cache.refreshed = now()
 
 	return nil
 }
@@ -419,7 +618,70 @@ func (p *packageResouce) download(ctx context.Context, name string, file *agente
 	return path, nil
 }
 
+// downloadCatalogUpdate downloads a catalog update package resource, if the
+// resource sets a SHA256 checksum the update must consist of a single file
+// matching it.
+func (p *packageResouce) downloadCatalogUpdate(ctx context.Context) error {
+	if p.managedPackage.MSU.catalogPaths != nil {
+		return nil
+	}
+	tmpDir, err := ioutil.TempDir("", "osconfig_package_resource_")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %s", err)
+	}
+	p.managedPackage.tempDir = tmpDir
+	paths, err := packages.DownloadCatalogUpdate(ctx, p.managedPackage.MSU.kb, tmpDir)
+	if err != nil {
+		return err
+	}
+	if want := p.GetMsi().GetSource().GetRemote().GetSha256Checksum(); want != "" {
+		if len(paths) != 1 {
+			return fmt.Errorf("%s consists of %d update packages, a sha256 checksum can only be verified for a single package", p.managedPackage.MSU.kb, len(paths))
+		}
+		f, err := os.Open(paths[0])
+		if err != nil {
+			return err
+		}
+		got := checksum(f)
+		f.Close()
+		if !strings.EqualFold(got, want) {
+			return fmt.Errorf("got %q for checksum of %s, expected %q", got, paths[0], want)
+		}
+	}
+	p.managedPackage.MSU.catalogPaths = paths
+	return nil
+}
+
+// checkWSLState checks an apt package in every WSL distribution whose dpkg
+// database can be read, a package that should be installed must be
+// installed in at least one of them.
+func (p *packageResouce) checkWSLState(ctx context.Context) (inDesiredState bool, err error) {
+	name := p.managedPackage.Apt.name
+	want := p.managedPackage.Apt.DesiredState == agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED
+	var checked bool
+	for _, d := range wslDistributions(ctx) {
+		if d.Packages == nil {
+			continue
+		}
+		checked = true
+		var installed bool
+		for _, pkg := range d.Packages {
+			if pkg.Name == name {
+				installed = true
+			}
+		}
+		if installed != want {
+			clog.Debugf(ctx, "Apt package %q is not in its desired state in WSL distribution %q of %s.", name, d.Name, d.User)
+			return false, nil
+		}
+	}
+	return checked || !want, nil
+}
+
 func (p *packageResouce) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	if p.managedPackage.Apt != nil && p.managedPackage.Apt.wsl {
+		return p.checkWSLState(ctx)
+	}
 	if err := populateInstalledCache(ctx, p.managedPackage); err != nil {
 		return false, err
 	}
@@ -430,7 +692,19 @@ func (p *packageResouce) checkState(ctx context.Context) (inDesiredState bool, e
 	switch {
 	case p.managedPackage.Apt != nil:
 		desiredState = p.managedPackage.Apt.DesiredState
-		_, pkgIns = aptInstalled.cache[p.managedPackage.Apt.PackageResource.GetName()]
+		apt := p.managedPackage.Apt
+		_, pkgIns = aptInstalled.cache[apt.name]
+		if pkgIns && (apt.hold || apt.agentHeld && desiredState == agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED) {
+			held, err := aptPackageHeld(ctx, apt.name)
+			if err != nil {
+				return false, err
+			}
+			// Packages in the hold list must be held, a package the agent
+			// held must be unheld once it is taken out of the list.
+			if held != apt.hold {
+				return false, nil
+			}
+		}
 
 	case p.managedPackage.Deb != nil:
 		desiredState = agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED
@@ -441,12 +715,19 @@ func (p *packageResouce) checkState(ctx context.Context) (inDesiredState bool, e
 		_, pkgIns = gooInstalled.cache[p.managedPackage.GooGet.PackageResource.GetName()]
 
 	case p.managedPackage.MSI != nil:
-		desiredState = agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED
+		desiredState = p.managedPackage.MSI.DesiredState
 		pkgIns, err = packages.MSIInstalled(p.managedPackage.MSI.productCode)
 		if err != nil {
 			return false, err
 		}
 
+	case p.managedPackage.MSU != nil:
+		desiredState = p.GetDesiredState()
+		pkgIns, err = packages.HotFixInstalled(ctx, p.managedPackage.MSU.kb)
+		if err != nil {
+			return false, err
+		}
+
 	case p.managedPackage.Yum != nil:
 		desiredState = p.managedPackage.Yum.DesiredState
 		_, pkgIns = yumInstalled.cache[p.managedPackage.Yum.PackageResource.GetName()]
@@ -473,13 +754,41 @@ func (p *packageResouce) checkState(ctx context.Context) (inDesiredState bool, e
 		return false, fmt.Errorf("DesiredState field not set or references state: %q", desiredState)
 	}
 
+	if p.transactional {
+		p.checkPendingSnapshot(ctx, desiredState)
+	}
 	return false, nil
 }
 
+// checkPendingSnapshot sets pendingSnapshot if transactional-update already
+// brought this package to its desired state in the snapshot for the next
+// boot, so that it is not enforced into yet another snapshot.
+func (p *packageResouce) checkPendingSnapshot(ctx context.Context, desiredState agentendpointpb.OSPolicy_Resource_PackageResource_DesiredState) {
+	p.pendingSnapshot = ""
+	root, pkgs, err := packages.TransactionalPendingPackages(ctx)
+	if err != nil {
+		clog.Debugf(ctx, "Error checking for a pending transactional-update snapshot: %v", err)
+		return
+	}
+	if root == "" {
+		return
+	}
+	_, pkgIns := pkgs[p.managedPackage.Zypper.PackageResource.GetName()]
+	if pkgIns == (desiredState == agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED) {
+		clog.Infof(ctx, "Zypper package %q is in its desired state in snapshot %q, a reboot is required for it to take effect.", p.managedPackage.Zypper.PackageResource.GetName(), root)
+		p.pendingSnapshot = root
+	}
+}
+
 func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool, err error) {
+	if p.managedPackage.Apt != nil && p.managedPackage.Apt.wsl {
+		return false, fmt.Errorf("apt package %q can only be checked in WSL distributions, the agent can't change them", p.managedPackage.Apt.name)
+	}
 	var (
 		installing = "installing"
 		removing   = "removing"
+		holding    = "holding"
+		unholding  = "unholding"
 
 		enforcePackage struct {
 			actionFunc     func() error
@@ -492,19 +801,48 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 
 	switch {
 	case p.managedPackage.Apt != nil:
-		enforcePackage.name = p.managedPackage.Apt.PackageResource.GetName()
+		enforcePackage.name = p.managedPackage.Apt.name
 		enforcePackage.packageType = "apt"
 		enforcePackage.installedCache = aptInstalled
 		switch p.managedPackage.Apt.DesiredState {
 		case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
+			_, installed := aptInstalled.cache[enforcePackage.name]
+			hold := p.managedPackage.Apt.hold
+			if installed && hold {
+				// Only the hold is missing.
+				enforcePackage.action, enforcePackage.actionFunc = holding, func() error { return aptHold(ctx, enforcePackage.name) }
+				break
+			}
+			if installed && p.managedPackage.Apt.agentHeld {
+				// The package was taken out of the hold list.
+				enforcePackage.action, enforcePackage.actionFunc = unholding, func() error { return aptUnhold(ctx, enforcePackage.name) }
+				break
+			}
 			enforcePackage.action, enforcePackage.actionFunc = installing, func() error {
 				if _, err := packages.AptUpdate(ctx); err != nil {
 					return err
 				}
-				return packages.InstallAptPackages(ctx, []string{enforcePackage.name})
+				if err := installSpaceCheck(ctx, []string{enforcePackage.name}); err != nil {
+					return err
+				}
+				if err := packages.InstallAptPackages(ctx, []string{enforcePackage.name}); err != nil {
+					return err
+				}
+				if hold {
+					return aptHold(ctx, enforcePackage.name)
+				}
+				return nil
 			}
 		case agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
-			enforcePackage.action, enforcePackage.actionFunc = removing, func() error { return packages.RemoveAptPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = removing, func() error {
+				// apt-get refuses to remove held packages.
+				if p.managedPackage.Apt.agentHeld {
+					if err := aptUnhold(ctx, enforcePackage.name); err != nil {
+						return err
+					}
+				}
+				return packages.RemoveAptPackages(ctx, []string{enforcePackage.name})
+			}
 		}
 
 	case p.managedPackage.Deb != nil:
@@ -514,12 +852,17 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 		enforcePackage.action = installing
 		// Check if we have not pulled the package yet.
 		if p.managedPackage.Deb.localPath == "" {
-			localPath, err := p.download(ctx, "pkg.deb", p.GetDeb().GetSource())
+			localPath, err := p.download(ctx, "pkg.deb", p.managedPackage.Deb.source)
 			if err != nil {
 				return false, err
 			}
 			p.managedPackage.Deb.localPath = localPath
 		}
+		if sig := p.managedPackage.Deb.signature; sig.Verify {
+			if err := verifyDebSignature(p.managedPackage.Deb.localPath, sig.Keyrings); err != nil {
+				return false, fmt.Errorf("error verifying the signature of deb package %q: %v", enforcePackage.name, err)
+			}
+		}
 		if p.GetDeb().GetPullDeps() {
 			enforcePackage.actionFunc = func() error { return packages.InstallAptPackages(ctx, []string{p.managedPackage.Deb.localPath}) }
 		} else {
@@ -540,8 +883,14 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 	case p.managedPackage.MSI != nil:
 		enforcePackage.name = p.managedPackage.MSI.productName
 		enforcePackage.packageType = "msi"
-		enforcePackage.action = installing
 		enforcePackage.installedCache = &packageCache{} // No package cache for msi.
+		if p.managedPackage.MSI.DesiredState == agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED {
+			enforcePackage.action, enforcePackage.actionFunc = removing, func() error {
+				return packages.MSIUninstall(ctx, p.managedPackage.MSI.productCode, packages.MSIOptions{Properties: p.managedPackage.MSI.PackageResource.GetProperties()})
+			}
+			break
+		}
+		enforcePackage.action = installing
 		// Check if we have not pulled the package yet.
 		if p.managedPackage.MSI.localPath == "" {
 			localPath, err := p.download(ctx, "pkg.msi", p.GetMsi().GetSource())
@@ -551,7 +900,52 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 			p.managedPackage.MSI.localPath = localPath
 		}
 		enforcePackage.actionFunc = func() error {
-			return packages.InstallMSIPackage(ctx, p.managedPackage.MSI.localPath, p.managedPackage.MSI.PackageResource.GetProperties())
+			return packages.MSIInstall(ctx, p.managedPackage.MSI.localPath, packages.MSIOptions{Properties: p.managedPackage.MSI.PackageResource.GetProperties()})
+		}
+
+	case p.managedPackage.MSU != nil:
+		enforcePackage.name = p.managedPackage.MSU.kb
+		enforcePackage.packageType = "msu"
+		enforcePackage.action = installing
+		enforcePackage.installedCache = &packageCache{} // No package cache for update packages.
+		if p.GetDesiredState() != agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED {
+			return false, fmt.Errorf("desired state of %q not applicable for update package %s", p.GetDesiredState(), enforcePackage.name)
+		}
+		if p.managedPackage.MSU.catalog {
+			if err := p.downloadCatalogUpdate(ctx); err != nil {
+				return false, err
+			}
+			enforcePackage.actionFunc = func() error {
+				for _, path := range p.managedPackage.MSU.catalogPaths {
+					install := packages.InstallMSUPackage
+					if _, isCAB := packages.IsUpdatePackage(path); isCAB {
+						install = packages.InstallCABPackage
+					}
+					if err := install(ctx, path); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			break
+		}
+		name := "pkg.msu"
+		if p.managedPackage.MSU.cab {
+			enforcePackage.packageType = "cab"
+			name = "pkg.cab"
+		}
+		if p.managedPackage.MSU.localPath == "" {
+			localPath, err := p.download(ctx, name, p.GetMsi().GetSource())
+			if err != nil {
+				return false, err
+			}
+			p.managedPackage.MSU.localPath = localPath
+		}
+		enforcePackage.actionFunc = func() error {
+			if p.managedPackage.MSU.cab {
+				return packages.InstallCABPackage(ctx, p.managedPackage.MSU.localPath)
+			}
+			return packages.InstallMSUPackage(ctx, p.managedPackage.MSU.localPath)
 		}
 
 	case p.managedPackage.Yum != nil:
@@ -560,7 +954,12 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 		enforcePackage.installedCache = yumInstalled
 		switch p.managedPackage.Yum.DesiredState {
 		case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
-			enforcePackage.action, enforcePackage.actionFunc = installing, func() error { return packages.InstallYumPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = installing, func() error {
+				if err := installSpaceCheck(ctx, []string{enforcePackage.name}); err != nil {
+					return err
+				}
+				return installWithGPGRemediation(ctx, yumManagedRepoGlob, func() error { return packages.InstallYumPackages(ctx, []string{enforcePackage.name}) })
+			}
 		case agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
 			enforcePackage.action, enforcePackage.actionFunc = removing, func() error { return packages.RemoveYumPackages(ctx, []string{enforcePackage.name}) }
 		}
@@ -571,9 +970,22 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 		enforcePackage.installedCache = zypperInstalled
 		switch p.managedPackage.Zypper.DesiredState {
 		case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
-			enforcePackage.action, enforcePackage.actionFunc = installing, func() error { return packages.InstallZypperPackages(ctx, []string{enforcePackage.name}) }
+			enforcePackage.action, enforcePackage.actionFunc = installing, func() error {
+				if err := installSpaceCheck(ctx, []string{enforcePackage.name}); err != nil {
+					return err
+				}
+				install := packages.InstallZypperPackages
+				if p.transactional {
+					install = packages.TransactionalInstallZypperPackages
+				}
+				return installWithGPGRemediation(ctx, zypperManagedRepoGlob, func() error { return install(ctx, []string{enforcePackage.name}) })
+			}
 		case agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
-			enforcePackage.action, enforcePackage.actionFunc = removing, func() error { return packages.RemoveZypperPackages(ctx, []string{enforcePackage.name}) }
+			remove := packages.RemoveZypperPackages
+			if p.transactional {
+				remove = packages.TransactionalRemoveZypperPackages
+			}
+			enforcePackage.action, enforcePackage.actionFunc = removing, func() error { return remove(ctx, []string{enforcePackage.name}) }
 		}
 
 	case p.managedPackage.RPM != nil:
@@ -583,12 +995,17 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 		enforcePackage.action = installing
 		// Check if we have not pulled the package yet.
 		if p.managedPackage.RPM.localPath == "" {
-			localPath, err := p.download(ctx, "pkg.rpm", p.GetRpm().GetSource())
+			localPath, err := p.download(ctx, "pkg.rpm", p.managedPackage.RPM.source)
 			if err != nil {
 				return false, err
 			}
 			p.managedPackage.RPM.localPath = localPath
 		}
+		if sig := p.managedPackage.RPM.signature; sig.Verify {
+			if err := verifyRPMSignature(ctx, p.managedPackage.RPM.localPath, sig.Keyrings); err != nil {
+				return false, fmt.Errorf("error verifying the signature of rpm package %q: %v", enforcePackage.name, err)
+			}
+		}
 		if p.GetRpm().GetPullDeps() {
 			switch {
 			case packages.YumExists:
@@ -607,7 +1024,7 @@ func (p *packageResouce) enforceState(ctx context.Context) (inDesiredState bool,
 	// Reset the cache as we are taking action on.
 	enforcePackage.installedCache.cache = nil
 	if err := enforcePackage.actionFunc(); err != nil {
-		return false, fmt.Errorf("error %s %s package %q", enforcePackage.action, enforcePackage.packageType, enforcePackage.name)
+		return false, fmt.Errorf("error %s %s package %q: %w", enforcePackage.action, enforcePackage.packageType, enforcePackage.name, err)
 	}
 
 	return true, nil
@@ -17,14 +17,17 @@ package config
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
 	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
 	"github.com/golang/mock/gomock"
@@ -43,6 +46,14 @@ var (
 		DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED,
 		SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Apt{
 			Apt: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "foo"}}}
+	aptHeldPR = &agentendpointpb.OSPolicy_Resource_PackageResource{
+		DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
+		SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Apt{
+			Apt: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "held"}}}
+	aptHeldRemovedPR = &agentendpointpb.OSPolicy_Resource_PackageResource{
+		DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED,
+		SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Apt{
+			Apt: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "held"}}}
 	googetInstalledPR = &agentendpointpb.OSPolicy_Resource_PackageResource{
 		DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
 		SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Googet{
@@ -77,6 +88,7 @@ func (m *fakeCommandRunner) Run(_ context.Context, _ *exec.Cmd) ([]byte, []byte,
 
 func TestPackageResourceValidate(t *testing.T) {
 	ctx := context.Background()
+	stubAptHold(t, []string{"held"}, nil)
 	tmpDir, err := ioutil.TempDir("", "")
 	if err != nil {
 		t.Fatal(err)
@@ -115,7 +127,31 @@ func TestPackageResourceValidate(t *testing.T) {
 			aptInstalledPR,
 			ManagedPackage{Apt: &AptPackage{
 				DesiredState:    agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
-				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "foo"}}},
+				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "foo"},
+				name:            "foo"}},
+			nil,
+			nil,
+		},
+		{
+			"AptHeld",
+			false,
+			aptHeldPR,
+			ManagedPackage{Apt: &AptPackage{
+				DesiredState:    agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
+				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "held"},
+				name:            "held",
+				hold:            true}},
+			nil,
+			nil,
+		},
+		{
+			"AptHeldRemoved",
+			false,
+			aptHeldRemovedPR,
+			ManagedPackage{Apt: &AptPackage{
+				DesiredState:    agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED,
+				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "held"},
+				name:            "held"}},
 			nil,
 			nil,
 		},
@@ -125,7 +161,8 @@ func TestPackageResourceValidate(t *testing.T) {
 			aptRemovedPR,
 			ManagedPackage{Apt: &AptPackage{
 				DesiredState:    agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED,
-				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "foo"}}},
+				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: "foo"},
+				name:            "foo"}},
 			nil,
 			nil,
 		},
@@ -141,6 +178,8 @@ func TestPackageResourceValidate(t *testing.T) {
 			ManagedPackage{Deb: &DebPackage{
 				localPath: tmpFile,
 				name:      "foo",
+				source: &agentendpointpb.OSPolicy_Resource_File{
+					Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}},
 				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_Deb{
 					Source: &agentendpointpb.OSPolicy_Resource_File{
 						Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}}}}},
@@ -177,8 +216,29 @@ func TestPackageResourceValidate(t *testing.T) {
 						Source: &agentendpointpb.OSPolicy_Resource_File{
 							Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}}}}},
 			ManagedPackage{MSI: &MSIPackage{
-				localPath: tmpFile,
+				DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
+				localPath:    tmpFile,
+				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_MSI{
+					Source: &agentendpointpb.OSPolicy_Resource_File{
+						Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}}}}},
+			nil,
+			nil,
+		},
+		{
+			"MSIRemoved",
+			false,
+			&agentendpointpb.OSPolicy_Resource_PackageResource{
+				DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED,
+				SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Msi{
+					Msi: &agentendpointpb.OSPolicy_Resource_PackageResource_MSI{
+						Properties: []string{"REMOVE_DATA=1"},
+						Source: &agentendpointpb.OSPolicy_Resource_File{
+							Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}}}}},
+			ManagedPackage{MSI: &MSIPackage{
+				DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED,
+				localPath:    tmpFile,
 				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_MSI{
+					Properties: []string{"REMOVE_DATA=1"},
 					Source: &agentendpointpb.OSPolicy_Resource_File{
 						Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}}}}},
 			nil,
@@ -236,10 +296,12 @@ func TestPackageResourceValidate(t *testing.T) {
 			ManagedPackage{RPM: &RPMPackage{
 				localPath: tmpFile,
 				name:      "gcc",
+				source: &agentendpointpb.OSPolicy_Resource_File{
+					Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}},
 				PackageResource: &agentendpointpb.OSPolicy_Resource_PackageResource_RPM{
 					Source: &agentendpointpb.OSPolicy_Resource_File{
 						Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}}}}},
-			exec.Command("/usr/bin/rpmquery", "--queryformat", "\\{\"architecture\":\"%{ARCH}\",\"package\":\"%{NAME}\",\"source_name\":\"%{SOURCERPM}\",\"version\":\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\"\\}\n", "-p", tmpFile),
+			exec.Command("/usr/bin/rpmquery", "--queryformat", "\\{\"architecture\":\"%{ARCH}\",\"install_time\":\"%{INSTALLTIME}\",\"package\":\"%{NAME}\",\"size\":\"%{LONGSIZE}\",\"source_name\":\"%{SOURCERPM}\",\"version\":\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\"\\}\n", "-p", tmpFile),
 			[]byte("{\"architecture\":\"x86_64\",\"package\":\"gcc\",\"source_name\":\"gcc-11.4.1-3.el9.src.rpm\",\"version\":\"11.4.1-3.el9\"}"),
 		},
 	}
@@ -269,7 +331,7 @@ func TestPackageResourceValidate(t *testing.T) {
 				wantMR = nil
 			}
 
-			opts := []cmp.Option{protocmp.Transform(), cmp.AllowUnexported(ManagedPackage{}), cmp.AllowUnexported(DebPackage{}), cmp.AllowUnexported(RPMPackage{}), cmp.AllowUnexported(MSIPackage{})}
+			opts := []cmp.Option{protocmp.Transform(), cmp.AllowUnexported(ManagedPackage{}), cmp.AllowUnexported(AptPackage{}), cmp.AllowUnexported(DebPackage{}), cmp.AllowUnexported(RPMPackage{}), cmp.AllowUnexported(MSIPackage{})}
 			if diff := cmp.Diff(pr.ManagedResources(), wantMR, opts...); diff != "" {
 				t.Errorf("OSPolicyResource does not match expectation: (-got +want)\n%s", diff)
 			}
@@ -392,6 +454,223 @@ func TestPackageResourceCheckState(t *testing.T) {
 	}
 }
 
+// stubAptHold sets the osconfig-apt-hold list and the apt packages the agent
+// has held.
+func stubAptHold(t *testing.T, hold, agentHeld []string) {
+	oldPackages, oldFile := aptHoldPackages, aptHoldsFile
+	t.Cleanup(func() { aptHoldPackages, aptHoldsFile = oldPackages, oldFile })
+	aptHoldPackages = func() []string { return hold }
+	aptHoldsFile = filepath.Join(t.TempDir(), "config_apt_holds.json")
+	if agentHeld == nil {
+		return
+	}
+	data, err := json.Marshal(agentHeld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(aptHoldsFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPackageResourceCheckStateHold(t *testing.T) {
+	ctx := context.Background()
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	packages.SetCommandRunner(mockCommandRunner)
+
+	var tests = []struct {
+		name               string
+		hold               []string
+		agentHeld          []string
+		showhold           string
+		wantInDesiredState bool
+	}{
+		{"Held", []string{"held"}, nil, "bar\nheld\n", true},
+		{"NotHeld", []string{"held"}, nil, "bar\n", false},
+		{"Unheld", nil, []string{"held"}, "bar\n", true},
+		{"NotUnheld", nil, []string{"held"}, "held\n", false},
+		// Holds the agent did not place are left alone.
+		{"HeldByOthers", nil, nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubAptHold(t, tt.hold, tt.agentHeld)
+			pr := &OSPolicyResource{
+				OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+					ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: aptHeldPR},
+				},
+			}
+			defer pr.Cleanup(ctx)
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected Validate error: %v", err)
+			}
+
+			aptInstalled.cache = map[string]struct{}{"held": {}}
+			aptInstalled.refreshed = time.Now()
+			if tt.showhold != "" {
+				mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command("/usr/bin/apt-mark", "showhold"))).Return([]byte(tt.showhold), nil, nil).Times(1)
+			}
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if tt.wantInDesiredState != pr.InDesiredState() {
+				t.Fatalf("Unexpected InDesiredState, want: %t, got: %t", tt.wantInDesiredState, pr.InDesiredState())
+			}
+		})
+	}
+}
+
+func TestPackageResourceWSL(t *testing.T) {
+	ctx := context.Background()
+	oldAptExists, oldWSLHost, oldDistributions := packages.AptExists, wslHost, wslDistributions
+	defer func() { packages.AptExists, wslHost, wslDistributions = oldAptExists, oldWSLHost, oldDistributions }()
+	packages.AptExists, wslHost = false, true
+
+	var tests = []struct {
+		name               string
+		prpb               *agentendpointpb.OSPolicy_Resource_PackageResource
+		dists              []*inventory.WSLDistribution
+		wantInDesiredState bool
+	}{
+		{"Installed", aptInstalledPR, []*inventory.WSLDistribution{{Name: "Ubuntu", Packages: []*packages.PkgInfo{{Name: "foo"}}}}, true},
+		{"NotInstalledEverywhere", aptInstalledPR, []*inventory.WSLDistribution{{Name: "Ubuntu", Packages: []*packages.PkgInfo{{Name: "foo"}}}, {Name: "Debian", Packages: []*packages.PkgInfo{{Name: "bar"}}}}, false},
+		// Distributions whose packages can't be read are not checked.
+		{"Unreadable", aptInstalledPR, []*inventory.WSLDistribution{{Name: "Ubuntu", Packages: []*packages.PkgInfo{{Name: "foo"}}}, {Name: "Alpine", PackagesError: "no dpkg database"}}, true},
+		{"NoDistributions", aptInstalledPR, nil, false},
+		{"Removed", aptRemovedPR, []*inventory.WSLDistribution{{Name: "Ubuntu", Packages: []*packages.PkgInfo{{Name: "bar"}}}}, true},
+		{"NotRemoved", aptRemovedPR, []*inventory.WSLDistribution{{Name: "Ubuntu", Packages: []*packages.PkgInfo{{Name: "foo"}}}}, false},
+		{"RemovedNoDistributions", aptRemovedPR, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wslDistributions = func(context.Context) []*inventory.WSLDistribution { return tt.dists }
+			pr := &OSPolicyResource{
+				OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+					ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: tt.prpb},
+				},
+			}
+			defer pr.Cleanup(ctx)
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected Validate error: %v", err)
+			}
+			if err := pr.CheckState(ctx); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tt.wantInDesiredState != pr.InDesiredState() {
+				t.Fatalf("Unexpected InDesiredState, want: %t, got: %t", tt.wantInDesiredState, pr.InDesiredState())
+			}
+			// The agent can't change packages inside the distributions.
+			if err := pr.EnforceState(ctx); err == nil {
+				t.Error("EnforceState() should return an error")
+			}
+		})
+	}
+}
+
+func TestPackageResourceEnforceStateHold(t *testing.T) {
+	ctx := context.Background()
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	packages.SetCommandRunner(mockCommandRunner)
+
+	oldInstallSpaceCheck := installSpaceCheck
+	defer func() { installSpaceCheck = oldInstallSpaceCheck }()
+	installSpaceCheck = func(context.Context, []string) error { return nil }
+
+	aptCmd := func(args ...string) *exec.Cmd {
+		cmd := exec.Command("/usr/bin/apt-get", args...)
+		cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		return cmd
+	}
+
+	var tests = []struct {
+		name          string
+		prpb          *agentendpointpb.OSPolicy_Resource_PackageResource
+		hold          []string
+		agentHeld     []string
+		installed     bool
+		expectedCmds  []*exec.Cmd
+		wantAgentHeld bool
+	}{
+		{
+			"Hold",
+			aptHeldPR,
+			[]string{"held"},
+			nil,
+			true,
+			[]*exec.Cmd{exec.Command("/usr/bin/apt-mark", "hold", "held")},
+			true,
+		},
+		{
+			"InstallAndHold",
+			aptHeldPR,
+			[]string{"held"},
+			nil,
+			false,
+			[]*exec.Cmd{aptCmd("update"), aptCmd("install", "-y", "-o", "APT::Status-Fd=1", "held"), exec.Command("/usr/bin/apt-mark", "hold", "held")},
+			true,
+		},
+		{
+			"Unhold",
+			aptHeldPR,
+			nil,
+			[]string{"held"},
+			true,
+			[]*exec.Cmd{exec.Command("/usr/bin/apt-mark", "unhold", "held")},
+			false,
+		},
+		{
+			"RemoveHeld",
+			aptHeldRemovedPR,
+			[]string{"held"},
+			[]string{"held"},
+			true,
+			[]*exec.Cmd{exec.Command("/usr/bin/apt-mark", "unhold", "held"), aptCmd("remove", "-y", "held")},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubAptHold(t, tt.hold, tt.agentHeld)
+			pr := &OSPolicyResource{
+				OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+					ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: tt.prpb},
+				},
+			}
+			defer pr.Cleanup(ctx)
+			if err := pr.Validate(ctx); err != nil {
+				t.Fatalf("Unexpected Validate error: %v", err)
+			}
+
+			aptInstalled.cache = map[string]struct{}{}
+			if tt.installed {
+				aptInstalled.cache["held"] = struct{}{}
+			}
+			var calls []*gomock.Call
+			for _, expectedCmd := range tt.expectedCmds {
+				calls = append(calls, mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(expectedCmd)))
+			}
+			gomock.InOrder(calls...)
+
+			if err := pr.EnforceState(ctx); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := aptHeldByAgent(ctx, "held"); got != tt.wantAgentHeld {
+				t.Errorf("aptHeldByAgent() = %t, want %t", got, tt.wantAgentHeld)
+			}
+		})
+	}
+}
+
 func TestPackageResourceEnforceState(t *testing.T) {
 	ctx := context.Background()
 	mockCtrl := gomock.NewController(t)
@@ -400,6 +679,10 @@ func TestPackageResourceEnforceState(t *testing.T) {
 	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
 	packages.SetCommandRunner(mockCommandRunner)
 
+	oldInstallSpaceCheck := installSpaceCheck
+	defer func() { installSpaceCheck = oldInstallSpaceCheck }()
+	installSpaceCheck = func(context.Context, []string) error { return nil }
+
 	var tests = []struct {
 		name         string
 		prpb         *agentendpointpb.OSPolicy_Resource_PackageResource
@@ -415,7 +698,7 @@ func TestPackageResourceEnforceState(t *testing.T) {
 				cmd1.Env = append(os.Environ(),
 					"DEBIAN_FRONTEND=noninteractive",
 				)
-				cmd2 := exec.Command("/usr/bin/apt-get", "install", "-y", "foo")
+				cmd2 := exec.Command("/usr/bin/apt-get", "install", "-y", "-o", "APT::Status-Fd=1", "foo")
 				cmd2.Env = append(os.Environ(),
 					"DEBIAN_FRONTEND=noninteractive",
 				)
@@ -506,6 +789,59 @@ func TestPackageResourceEnforceState(t *testing.T) {
 	}
 }
 
+func TestPackageResourceEnforceStateSignature(t *testing.T) {
+	ctx := context.Background()
+	tmpFile := filepath.Join(t.TempDir(), "foo.deb")
+	if err := ioutil.WriteFile(tmpFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	packages.SetCommandRunner(mockCommandRunner)
+
+	oldVerifyDebSignature := verifyDebSignature
+	defer func() { verifyDebSignature = oldVerifyDebSignature }()
+	var gotKeyrings []string
+	verifyDebSignature = func(path string, keyrings []string) error {
+		gotKeyrings = keyrings
+		return errors.New("package is not signed")
+	}
+	oldPackageSignature := packageSignature
+	defer func() { packageSignature = oldPackageSignature }()
+	packageSignature = func() packages.SignatureOptions {
+		return packages.SignatureOptions{Verify: true, Keyrings: []string{"/etc/keys/vendor.asc"}}
+	}
+
+	pr := &OSPolicyResource{
+		OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+			ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: &agentendpointpb.OSPolicy_Resource_PackageResource{
+				DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED,
+				SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Deb_{
+					Deb: &agentendpointpb.OSPolicy_Resource_PackageResource_Deb{
+						Source: &agentendpointpb.OSPolicy_Resource_File{
+							Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: tmpFile}}}}}},
+		},
+	}
+	defer pr.Cleanup(ctx)
+
+	mockCommandRunner.EXPECT().Run(ctx, utilmocks.EqCmd(exec.Command("/usr/bin/dpkg-deb", "-I", tmpFile))).Return([]byte("Package: foo\nVersion: 1.0\nArchitecture: amd64"), nil, nil)
+	if err := pr.Validate(ctx); err != nil {
+		t.Fatalf("Unexpected Validate error: %v", err)
+	}
+
+	// No install command is expected, the mock fails the test if dpkg runs.
+	err := pr.EnforceState(ctx)
+	if err == nil || !strings.Contains(err.Error(), "error verifying the signature") {
+		t.Errorf("EnforceState() error = %v, want a signature verification error", err)
+	}
+	if len(gotKeyrings) != 1 || gotKeyrings[0] != "/etc/keys/vendor.asc" {
+		t.Errorf("signature verified against %q, want %q", gotKeyrings, "/etc/keys/vendor.asc")
+	}
+}
+
 func TestPackageInfoCache(t *testing.T) {
 	ctx := context.Background()
 	pkgInfo := &packages.PkgInfo{Name: "name", Arch: "arch", Version: "version"}
@@ -572,3 +908,48 @@ func TestUpdatePackageInfoCacheTimeout(t *testing.T) {
 		t.Errorf("Cache should not contain expired data, cache: %+v", packageInfoCacheStore)
 	}
 }
+
+func TestSourceFileName(t *testing.T) {
+	tests := []struct {
+		file *agentendpointpb.OSPolicy_Resource_File
+		want string
+	}{
+		{&agentendpointpb.OSPolicy_Resource_File{Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: filepath.Join("updates", "windows10.0-kb5005565-x64.msu")}}, "windows10.0-kb5005565-x64.msu"},
+		{&agentendpointpb.OSPolicy_Resource_File{Type: &agentendpointpb.OSPolicy_Resource_File_Remote_{Remote: &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: "https://mirror/kb5005565.cab?token=foo"}}}, "kb5005565.cab"},
+		{&agentendpointpb.OSPolicy_Resource_File{Type: &agentendpointpb.OSPolicy_Resource_File_Gcs_{Gcs: &agentendpointpb.OSPolicy_Resource_File_Gcs{Bucket: "bucket", Object: "updates/kb5005565.msu"}}}, "kb5005565.msu"},
+	}
+	for _, tt := range tests {
+		if got := sourceFileName(tt.file); got != tt.want {
+			t.Errorf("sourceFileName(%v) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestUpdatePackageRemovedNotApplicable(t *testing.T) {
+	ctx := context.Background()
+	oldMSIExists := packages.MSIExists
+	defer func() { packages.MSIExists = oldMSIExists }()
+	packages.MSIExists = true
+	tmpDir := t.TempDir()
+	msu := filepath.Join(tmpDir, "windows10.0-kb5005565-x64.msu")
+	if err := ioutil.WriteFile(msu, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []*agentendpointpb.OSPolicy_Resource_File{
+		{Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: msu}},
+		{Type: &agentendpointpb.OSPolicy_Resource_File_Remote_{Remote: &agentendpointpb.OSPolicy_Resource_File_Remote{Uri: "https://www.catalog.update.microsoft.com/Search.aspx?q=KB5005565"}}},
+	} {
+		pr := &OSPolicyResource{
+			OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+				ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{Pkg: &agentendpointpb.OSPolicy_Resource_PackageResource{
+					DesiredState: agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED,
+					SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Msi{
+						Msi: &agentendpointpb.OSPolicy_Resource_PackageResource_MSI{Source: src}}}},
+			},
+		}
+		if err := pr.Validate(ctx); err == nil {
+			t.Errorf("Validate(%v) = nil, want an error for a REMOVED update package", src)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// HostProfile is a recorded snapshot of the host state that OS policies
+// can be evaluated against on another machine.
+type HostProfile struct {
+	Created           time.Time               `json:"created"`
+	OSInfo            *osinfo.OSInfo          `json:"osInfo,omitempty"`
+	InstalledPackages *packages.Packages      `json:"installedPackages,omitempty"`
+	Files             map[string]*ProfileFile `json:"files,omitempty"`
+	// AptHold is the osconfig-apt-hold list of the host.
+	AptHold []string `json:"aptHold,omitempty"`
+}
+
+// ProfileFile is the recorded state of a file referenced by a policy.
+type ProfileFile struct {
+	Exists bool   `json:"exists"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+var errNotEvaluatedOffline = errors.New("resource type can not be evaluated against a host profile")
+
+// NewHostProfile records the host profile for the files referenced by
+// policies.
+func NewHostProfile(ctx context.Context, policies []*agentendpointpb.ApplyConfigTask_OSPolicy) (*HostProfile, error) {
+	oi, err := osinfo.Get()
+	if err != nil {
+		return nil, fmt.Errorf("error getting osinfo: %v", err)
+	}
+	pkgs, err := packages.GetInstalledPackages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting installed packages: %v", err)
+	}
+
+	profile := &HostProfile{Created: time.Now().UTC(), OSInfo: oi, InstalledPackages: pkgs, Files: map[string]*ProfileFile{}, AptHold: aptHoldPackages()}
+	for _, p := range policies {
+		for _, r := range p.GetResources() {
+			path := r.GetFile().GetPath()
+			if path == "" {
+				continue
+			}
+			pf, err := recordFile(path)
+			if err != nil {
+				return nil, err
+			}
+			profile.Files[path] = pf
+		}
+	}
+	return profile, nil
+}
+
+func recordFile(path string) (*ProfileFile, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &ProfileFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error recording file %q: %v", path, err)
+	}
+	defer f.Close()
+	return &ProfileFile{Exists: true, SHA256: checksum(f)}, nil
+}
+
+// LoadHostProfile reads a host profile from path.
+func LoadHostProfile(path string) (*HostProfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profile HostProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("error parsing host profile %q: %v", path, err)
+	}
+	return &profile, nil
+}
+
+// SimulateProfile evaluates policies against a recorded host profile.
+// Only file and apt, yum, zypper and googet package resources can be
+// evaluated, other resources are reported as NOT_EVALUATED.
+func SimulateProfile(ctx context.Context, policies []*agentendpointpb.ApplyConfigTask_OSPolicy, profile *HostProfile) []*SimulationResult {
+	return simulate(ctx, policies, func(ctx context.Context, res *OSPolicyResource, mode agentendpointpb.OSPolicy_Mode) (string, error) {
+		inDesiredState, err := profile.checkState(res.OSPolicy_Resource)
+		switch {
+		case err == errNotEvaluatedOffline:
+			return SimulationNotEvaluated, err
+		case err != nil:
+			return SimulationError, err
+		case inDesiredState:
+			return SimulationCompliant, nil
+		case mode == agentendpointpb.OSPolicy_VALIDATION:
+			return SimulationNonCompliant, nil
+		default:
+			return SimulationWouldChange, nil
+		}
+	})
+}
+
+func (h *HostProfile) checkState(r *agentendpointpb.OSPolicy_Resource) (bool, error) {
+	switch x := r.GetResourceType().(type) {
+	case *agentendpointpb.OSPolicy_Resource_File_:
+		return h.checkFile(x.File)
+	case *agentendpointpb.OSPolicy_Resource_Pkg:
+		return h.checkPackage(x.Pkg)
+	default:
+		return false, errNotEvaluatedOffline
+	}
+}
+
+func (h *HostProfile) checkFile(f *agentendpointpb.OSPolicy_Resource_FileResource) (bool, error) {
+	pf, ok := h.Files[f.GetPath()]
+	if !ok {
+		return false, fmt.Errorf("file %q not recorded in host profile", f.GetPath())
+	}
+	switch f.GetState() {
+	case agentendpointpb.OSPolicy_Resource_FileResource_ABSENT:
+		return !pf.Exists, nil
+	case agentendpointpb.OSPolicy_Resource_FileResource_PRESENT:
+		return pf.Exists, nil
+	case agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH:
+		var want string
+		switch {
+		case f.GetContent() != "":
+			want = checksum(strings.NewReader(f.GetContent()))
+		case f.GetFile().GetRemote().GetSha256Checksum() != "":
+			want = f.GetFile().GetRemote().GetSha256Checksum()
+		default:
+			return false, errNotEvaluatedOffline
+		}
+		return pf.Exists && strings.EqualFold(pf.SHA256, want), nil
+	default:
+		return false, fmt.Errorf("unrecognized DesiredState for FileResource: %q", f.GetState())
+	}
+}
+
+func (h *HostProfile) checkPackage(p *agentendpointpb.OSPolicy_Resource_PackageResource) (bool, error) {
+	pkgs := h.InstalledPackages
+	if pkgs == nil {
+		pkgs = &packages.Packages{}
+	}
+
+	var name string
+	var hold bool
+	var installed [][]*packages.PkgInfo
+	switch {
+	case p.GetApt() != nil:
+		name, installed = p.GetApt().GetName(), [][]*packages.PkgInfo{pkgs.Deb, pkgs.Apt}
+		for _, held := range h.AptHold {
+			if held == name && p.GetDesiredState() == agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED {
+				hold = true
+			}
+		}
+	case p.GetYum() != nil:
+		name, installed = p.GetYum().GetName(), [][]*packages.PkgInfo{pkgs.Rpm, pkgs.Yum}
+	case p.GetZypper() != nil:
+		name, installed = p.GetZypper().GetName(), [][]*packages.PkgInfo{pkgs.Rpm, pkgs.Zypper}
+	case p.GetGooget() != nil:
+		name, installed = p.GetGooget().GetName(), [][]*packages.PkgInfo{pkgs.GooGet}
+	default:
+		return false, errNotEvaluatedOffline
+	}
+
+	var pkgIns bool
+	for _, list := range installed {
+		for _, pkg := range list {
+			// A package that should be held is only in its desired state
+			// once it is.
+			if pkg.Name == name && (!hold || pkg.Held) {
+				pkgIns = true
+			}
+		}
+	}
+
+	switch p.GetDesiredState() {
+	case agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED:
+		return pkgIns, nil
+	case agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED:
+		return !pkgIns, nil
+	default:
+		return false, fmt.Errorf("DesiredState field not set or references state unknown to this agent: %q", p.GetDesiredState())
+	}
+}
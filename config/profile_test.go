@@ -0,0 +1,133 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/google/go-cmp/cmp"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestRecordFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "file")
+	if err := ioutil.WriteFile(path, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := recordFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ProfileFile{Exists: true, SHA256: checksum(strings.NewReader("foo"))}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("recordFile() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = recordFile(filepath.Join(tmpDir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Exists {
+		t.Errorf("expected missing file to not exist: %+v", got)
+	}
+}
+
+func TestSimulateProfile(t *testing.T) {
+	profile := &HostProfile{
+		InstalledPackages: &packages.Packages{
+			Deb: []*packages.PkgInfo{{Name: "installed"}, {Name: "held", Held: true}, {Name: "unheld"}},
+		},
+		AptHold: []string{"held", "unheld"},
+		Files: map[string]*ProfileFile{
+			"/etc/match":   {Exists: true, SHA256: checksum(strings.NewReader("foo"))},
+			"/etc/nomatch": {Exists: true, SHA256: "abc"},
+			"/etc/missing": {},
+		},
+	}
+	// Round trip the profile to ensure it can be loaded from disk.
+	data, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, profile); err != nil {
+		t.Fatal(err)
+	}
+
+	file := func(id, path string, state agentendpointpb.OSPolicy_Resource_FileResource_DesiredState) *agentendpointpb.OSPolicy_Resource {
+		return &agentendpointpb.OSPolicy_Resource{Id: id, ResourceType: &agentendpointpb.OSPolicy_Resource_File_{
+			File: &agentendpointpb.OSPolicy_Resource_FileResource{Path: path, State: state, Source: &agentendpointpb.OSPolicy_Resource_FileResource_Content{Content: "foo"}},
+		}}
+	}
+	apt := func(id, name string, state agentendpointpb.OSPolicy_Resource_PackageResource_DesiredState) *agentendpointpb.OSPolicy_Resource {
+		return &agentendpointpb.OSPolicy_Resource{Id: id, ResourceType: &agentendpointpb.OSPolicy_Resource_Pkg{
+			Pkg: &agentendpointpb.OSPolicy_Resource_PackageResource{DesiredState: state, SystemPackage: &agentendpointpb.OSPolicy_Resource_PackageResource_Apt{
+				Apt: &agentendpointpb.OSPolicy_Resource_PackageResource_APT{Name: name},
+			}},
+		}}
+	}
+
+	policies := []*agentendpointpb.ApplyConfigTask_OSPolicy{
+		{
+			Id:   "policy",
+			Mode: agentendpointpb.OSPolicy_ENFORCEMENT,
+			Resources: []*agentendpointpb.OSPolicy_Resource{
+				file("match", "/etc/match", agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH),
+				file("nomatch", "/etc/nomatch", agentendpointpb.OSPolicy_Resource_FileResource_CONTENTS_MATCH),
+				file("absent", "/etc/missing", agentendpointpb.OSPolicy_Resource_FileResource_ABSENT),
+				apt("installed", "installed", agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED),
+				apt("removed", "installed", agentendpointpb.OSPolicy_Resource_PackageResource_REMOVED),
+				apt("held", "held", agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED),
+				apt("not-held", "unheld", agentendpointpb.OSPolicy_Resource_PackageResource_INSTALLED),
+				{Id: "exec", ResourceType: &agentendpointpb.OSPolicy_Resource_Exec{}},
+				file("unrecorded", "/etc/unrecorded", agentendpointpb.OSPolicy_Resource_FileResource_PRESENT),
+				file("after-error", "/etc/match", agentendpointpb.OSPolicy_Resource_FileResource_PRESENT),
+			},
+		},
+	}
+
+	got := SimulateProfile(context.Background(), policies, profile)
+	for _, r := range got {
+		r.Error = ""
+	}
+	want := []*SimulationResult{
+		{OSPolicyID: "policy", ResourceID: "match", State: SimulationCompliant},
+		{OSPolicyID: "policy", ResourceID: "nomatch", State: SimulationWouldChange},
+		{OSPolicyID: "policy", ResourceID: "absent", State: SimulationCompliant},
+		{OSPolicyID: "policy", ResourceID: "installed", State: SimulationCompliant},
+		{OSPolicyID: "policy", ResourceID: "removed", State: SimulationWouldChange},
+		{OSPolicyID: "policy", ResourceID: "held", State: SimulationCompliant},
+		{OSPolicyID: "policy", ResourceID: "not-held", State: SimulationWouldChange},
+		{OSPolicyID: "policy", ResourceID: "exec", State: SimulationNotEvaluated},
+		{OSPolicyID: "policy", ResourceID: "unrecorded", State: SimulationError},
+		{OSPolicyID: "policy", ResourceID: "after-error", State: SimulationNotEvaluated},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("SimulateProfile() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,237 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// External resource providers allow site specific resource types to take
+// part in OS policy runs. Providers are registered by the host administrator
+// in the resource providers file:
+//
+//	{
+//	  "providers": {
+//	    "appliance": {"path": "/opt/appliance/osconfig-provider", "timeoutSeconds": 300}
+//	  }
+//	}
+//
+// An OS policy uses a provider through an ExecResource whose validate exec
+// is a local file at the path of a registered provider, with no interpreter.
+// The args of the validate exec are the resource spec. Setting an enforce
+// exec for the same local file enables the enforce phase, its args are
+// ignored.
+//
+// Providers are subject to the local exec policy like any other script, a
+// blocked provider is never run and its resources are reported as not in
+// their desired state.
+//
+// The provider is run with the phase ("validate", "check" or "enforce") as
+// its only argument and a providerRequest JSON document on stdin. Exit codes
+// follow ExecResource: validate exits 0 if the spec is valid, check exits 100
+// for "in desired state" and 101 for "not in desired state", enforce exits
+// 100 on success. Stdout from enforce is reported as the resource output.
+
+var (
+	resourceProvidersFile  = agentconfig.ResourceProvidersFile()
+	defaultProviderTimeout = 10 * time.Minute
+)
+
+type resourceProvider struct {
+	Path           string `json:"path"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+}
+
+type resourceProviders struct {
+	Providers map[string]*resourceProvider `json:"providers"`
+}
+
+// providerRequest is written to the provider's stdin for each phase.
+type providerRequest struct {
+	Phase      string   `json:"phase"`
+	ResourceID string   `json:"resourceId"`
+	Args       []string `json:"args"`
+}
+
+type providerResource struct {
+	name, resourceID string
+	args             []string
+	enforce          bool
+
+	provider      *resourceProvider
+	enforceOutput []byte
+	// blocked is set if the local exec policy does not allow the provider.
+	blocked error
+}
+
+// lookup returns the name and provider registered at path.
+func (p *resourceProviders) lookup(path string) (string, *resourceProvider) {
+	for name, provider := range p.Providers {
+		if provider != nil && provider.Path != "" && filepath.Clean(provider.Path) == filepath.Clean(path) {
+			return name, provider
+		}
+	}
+	return "", nil
+}
+
+// newProviderResource returns a providerResource if the validate exec of e
+// runs a registered resource provider.
+func newProviderResource(resourceID string, e *agentendpointpb.OSPolicy_Resource_ExecResource) (*providerResource, bool, error) {
+	path := e.GetValidate().GetFile().GetLocalPath()
+	if path == "" || e.GetValidate().GetInterpreter() != agentendpointpb.OSPolicy_Resource_ExecResource_Exec_NONE {
+		return nil, false, nil
+	}
+	providers, err := loadResourceProviders(resourceProvidersFile)
+	if err != nil {
+		return nil, false, err
+	}
+	name, provider := providers.lookup(path)
+	if provider == nil {
+		return nil, false, nil
+	}
+	if enforce := e.GetEnforce(); enforce != nil && filepath.Clean(enforce.GetFile().GetLocalPath()) != filepath.Clean(path) {
+		return nil, false, fmt.Errorf("enforce of resource provider %q must run the same provider %q", name, path)
+	}
+	return &providerResource{name: name, resourceID: resourceID, args: e.GetValidate().GetArgs(), enforce: e.GetEnforce() != nil, provider: provider}, true, nil
+}
+
+func loadResourceProviders(path string) (*resourceProviders, error) {
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &resourceProviders{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading resource providers file %q: %v", path, err)
+	}
+
+	var p resourceProviders
+	if err := json.Unmarshal(d, &p); err != nil {
+		return nil, fmt.Errorf("error parsing resource providers file %q: %v", path, err)
+	}
+	return &p, nil
+}
+
+func (p *providerResource) run(ctx context.Context, phase string) ([]byte, []byte, int, error) {
+	timeout := defaultProviderTimeout
+	if p.provider.TimeoutSeconds > 0 {
+		timeout = time.Duration(p.provider.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := json.Marshal(providerRequest{Phase: phase, ResourceID: p.resourceID, Args: p.args})
+	if err != nil {
+		return nil, nil, -1, err
+	}
+
+	cmd := exec.CommandContext(ctx, p.provider.Path, phase)
+	cmd.Stdin = strings.NewReader(string(req))
+	stdout, stderr, err := runner.Run(ctx, cmd)
+	code := 0
+	if err != nil {
+		code = -1
+		if v, ok := err.(*exec.ExitError); ok {
+			code = v.ExitCode()
+		}
+	}
+	return stdout, stderr, code, err
+}
+
+func (p *providerResource) validate(ctx context.Context) (*ManagedResources, error) {
+	policy, err := loadExecPolicy(execPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	// A blocked provider is still valid, it is reported as not in its
+	// desired state.
+	if p.blocked = policy.verify(p.provider.Path); p.blocked != nil {
+		return nil, nil
+	}
+
+	stdout, stderr, code, err := p.run(ctx, "validate")
+	if code != 0 {
+		return nil, fmt.Errorf("resource provider %q failed validation, code: %d, err: %v, stdout: %s, stderr: %s", p.name, code, err, stdout, stderr)
+	}
+	return nil, nil
+}
+
+func (p *providerResource) checkState(ctx context.Context) (bool, error) {
+	if p.blocked != nil {
+		clog.Warningf(ctx, "Not running resource provider %q check: %v", p.name, p.blocked)
+		return false, nil
+	}
+	stdout, stderr, code, err := p.run(ctx, "check")
+	switch code {
+	case -1:
+		return false, err
+	case 100:
+		return true, nil
+	case 101:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected return code from resource provider %q check: %d, stdout: %s, stderr: %s", p.name, code, stdout, stderr)
+	}
+}
+
+func (p *providerResource) enforceState(ctx context.Context) (bool, error) {
+	if !p.enforce {
+		return false, nil
+	}
+	if p.blocked != nil {
+		return false, p.blocked
+	}
+	clog.Infof(ctx, "Running \"Enforce\" for resource provider %q.", p.name)
+	stdout, stderr, code, err := p.run(ctx, "enforce")
+	switch code {
+	case -1:
+		return false, err
+	case 100:
+		if len(stdout) > maxExecOutputSize {
+			p.enforceOutput = stdout[:maxExecOutputSize]
+			return true, fmt.Errorf("resource provider %q output greater than %dK", p.name, maxExecOutputSize/1024)
+		}
+		p.enforceOutput = stdout
+		return true, nil
+	default:
+		return false, fmt.Errorf("unexpected return code from resource provider %q enforce: %d, stdout: %s, stderr: %s", p.name, code, stdout, stderr)
+	}
+}
+
+func (p *providerResource) populateOutput(rCompliance *agentendpointpb.OSPolicyResourceCompliance) {
+	if p.enforceOutput != nil {
+		rCompliance.Output = &agentendpointpb.OSPolicyResourceCompliance_ExecResourceOutput_{
+			ExecResourceOutput: &agentendpointpb.OSPolicyResourceCompliance_ExecResourceOutput{
+				EnforcementOutput: p.enforceOutput,
+			},
+		}
+	}
+}
+
+func (p *providerResource) cleanup(ctx context.Context) error {
+	return nil
+}
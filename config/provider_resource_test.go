@@ -0,0 +1,187 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func localExec(path string, args ...string) *agentendpointpb.OSPolicy_Resource_ExecResource_Exec {
+	return &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+		Source: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_File{
+			File: &agentendpointpb.OSPolicy_Resource_File{
+				Type: &agentendpointpb.OSPolicy_Resource_File_LocalPath{LocalPath: path},
+			},
+		},
+		Interpreter: agentendpointpb.OSPolicy_Resource_ExecResource_Exec_NONE,
+		Args:        args,
+	}
+}
+
+func TestNewProviderResource(t *testing.T) {
+	oldFile := resourceProvidersFile
+	defer func() { resourceProvidersFile = oldFile }()
+	resourceProvidersFile = filepath.Join(t.TempDir(), "resource_providers.json")
+	if err := ioutil.WriteFile(resourceProvidersFile, []byte(`{"providers": {"appliance": {"path": "/opt/appliance/provider"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	script := &agentendpointpb.OSPolicy_Resource_ExecResource_Exec{
+		Source: &agentendpointpb.OSPolicy_Resource_ExecResource_Exec_Script{Script: "#osconfig-provider:appliance"},
+	}
+	shell := localExec("/opt/appliance/provider")
+	shell.Interpreter = agentendpointpb.OSPolicy_Resource_ExecResource_Exec_SHELL
+
+	var tests = []struct {
+		desc     string
+		exec     *agentendpointpb.OSPolicy_Resource_ExecResource
+		wantName string
+		wantOK   bool
+		wantErr  bool
+	}{
+		{"registered provider", &agentendpointpb.OSPolicy_Resource_ExecResource{Validate: localExec("/opt/appliance/provider", "spec")}, "appliance", true, false},
+		{"with enforce", &agentendpointpb.OSPolicy_Resource_ExecResource{Validate: localExec("/opt/appliance/provider"), Enforce: localExec("/opt/appliance/provider")}, "appliance", true, false},
+		{"enforce runs another file", &agentendpointpb.OSPolicy_Resource_ExecResource{Validate: localExec("/opt/appliance/provider"), Enforce: localExec("/tmp/other")}, "", false, true},
+		{"unregistered path", &agentendpointpb.OSPolicy_Resource_ExecResource{Validate: localExec("/opt/other")}, "", false, false},
+		{"interpreter set", &agentendpointpb.OSPolicy_Resource_ExecResource{Validate: shell}, "", false, false},
+		{"script", &agentendpointpb.OSPolicy_Resource_ExecResource{Validate: script}, "", false, false},
+	}
+	for _, tt := range tests {
+		p, ok, err := newProviderResource("id", tt.exec)
+		if (err != nil) != tt.wantErr || ok != tt.wantOK {
+			t.Errorf("%s: newProviderResource() = (%t, %v), want (%t, error %t)", tt.desc, ok, err, tt.wantOK, tt.wantErr)
+			continue
+		}
+		if ok && p.name != tt.wantName {
+			t.Errorf("%s: provider name = %q, want %q", tt.desc, p.name, tt.wantName)
+		}
+	}
+}
+
+func TestProviderResource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test provider requires a POSIX shell")
+	}
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldFile := resourceProvidersFile
+	defer func() { resourceProvidersFile = oldFile }()
+	resourceProvidersFile = filepath.Join(tmpDir, "resource_providers.json")
+
+	// The provider is compliant once enforce has created the state file.
+	state := filepath.Join(tmpDir, "state")
+	provider := filepath.Join(tmpDir, "provider")
+	script := fmt.Sprintf(`#!/bin/sh
+input=$(cat)
+case "$1" in
+  validate) echo "$input" | grep -q '"args":\["want"\]' || exit 1 ;;
+  check) [ -f %[1]s ] && exit 100; exit 101 ;;
+  enforce) touch %[1]s; echo enforced; exit 100 ;;
+esac
+`, state)
+	if err := ioutil.WriteFile(provider, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	newResource := func(spec string) *OSPolicyResource {
+		return &OSPolicyResource{
+			OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+				Id: "provider-resource",
+				ResourceType: &agentendpointpb.OSPolicy_Resource_Exec{
+					Exec: &agentendpointpb.OSPolicy_Resource_ExecResource{
+						Validate: localExec(provider, spec),
+						Enforce:  localExec(provider),
+					},
+				},
+			},
+		}
+	}
+
+	if err := ioutil.WriteFile(resourceProvidersFile, []byte(fmt.Sprintf(`{"providers": {"test": {"path": %q}}}`, provider)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newResource("bad").Validate(ctx); err == nil {
+		t.Error("expected validation error for bad spec")
+	}
+
+	res := newResource("want")
+	if err := res.Validate(ctx); err != nil {
+		t.Fatalf("unexpected Validate error: %v", err)
+	}
+	if err := res.CheckState(ctx); err != nil {
+		t.Fatalf("unexpected CheckState error: %v", err)
+	}
+	if res.InDesiredState() {
+		t.Error("expected resource to not be in desired state")
+	}
+	if err := res.EnforceState(ctx); err != nil {
+		t.Fatalf("unexpected EnforceState error: %v", err)
+	}
+	if !res.InDesiredState() {
+		t.Error("expected resource to be in desired state after enforce")
+	}
+	if err := res.CheckState(ctx); err != nil || !res.InDesiredState() {
+		t.Errorf("expected resource to be in desired state, err: %v", err)
+	}
+
+	rCompliance := &agentendpointpb.OSPolicyResourceCompliance{}
+	if err := res.PopulateOutput(rCompliance); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(rCompliance.GetExecResourceOutput().GetEnforcementOutput()); got != "enforced\n" {
+		t.Errorf("unexpected enforcement output: %q", got)
+	}
+
+	// A provider not allowed by the exec policy is never run.
+	oldPolicy := execPolicyFile
+	defer func() { execPolicyFile = oldPolicy }()
+	execPolicyFile = filepath.Join(tmpDir, "exec_policy.json")
+	if err := ioutil.WriteFile(execPolicyFile, []byte(`{"allowedSha256": ["abc"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(state); err != nil {
+		t.Fatal(err)
+	}
+	res = newResource("want")
+	if err := res.Validate(ctx); err != nil {
+		t.Fatalf("unexpected Validate error: %v", err)
+	}
+	if err := res.CheckState(ctx); err != nil {
+		t.Fatalf("unexpected CheckState error: %v", err)
+	}
+	if res.InDesiredState() {
+		t.Error("expected blocked resource to not be in desired state")
+	}
+	if err := res.EnforceState(ctx); err == nil {
+		t.Error("expected EnforceState error for blocked provider")
+	}
+	if _, err := os.Stat(state); !os.IsNotExist(err) {
+		t.Errorf("blocked provider was run, stat err: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+)
+
+// repoOptionsFile sets .repo file options for yum and zypper repositories by
+// repository id, the OSPolicy repository resources have no fields for them.
+var repoOptionsFile = agentconfig.RepoOptionsFile()
+
+// yumRepoOptions are the optional settings of a yum .repo file.
+type yumRepoOptions struct {
+	Priority          int      `json:"priority,omitempty"`
+	Cost              int      `json:"cost,omitempty"`
+	ModuleHotfixes    *bool    `json:"moduleHotfixes,omitempty"`
+	SSLCACert         string   `json:"sslCACert,omitempty"`
+	SSLClientCert     string   `json:"sslClientCert,omitempty"`
+	SSLClientKey      string   `json:"sslClientKey,omitempty"`
+	SSLVerify         *bool    `json:"sslVerify,omitempty"`
+	Proxy             string   `json:"proxy,omitempty"`
+	Exclude           []string `json:"exclude,omitempty"`
+	IncludePkgs       []string `json:"includePkgs,omitempty"`
+	MetadataExpire    string   `json:"metadataExpire,omitempty"`
+	SkipIfUnavailable *bool    `json:"skipIfUnavailable,omitempty"`
+	RepoGPGCheck      *bool    `json:"repoGpgCheck,omitempty"`
+}
+
+// zypperRepoOptions are the optional settings of a zypper .repo file.
+type zypperRepoOptions struct {
+	Priority     int    `json:"priority,omitempty"`
+	Autorefresh  *bool  `json:"autorefresh,omitempty"`
+	Type         string `json:"type,omitempty"`
+	KeepPackages *bool  `json:"keepPackages,omitempty"`
+	Path         string `json:"path,omitempty"`
+}
+
+type repoOptions struct {
+	Yum    map[string]*yumRepoOptions    `json:"yum,omitempty"`
+	Zypper map[string]*zypperRepoOptions `json:"zypper,omitempty"`
+}
+
+func loadRepoOptions(path string) (*repoOptions, error) {
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &repoOptions{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading repository options file %q: %v", path, err)
+	}
+
+	var o repoOptions
+	dec := json.NewDecoder(bytes.NewReader(d))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o); err != nil {
+		return nil, fmt.Errorf("error parsing repository options file %q: %v", path, err)
+	}
+	return &o, nil
+}
+
+// repoLines renders options as .repo file lines, values may not span lines
+// as they would inject further settings.
+type repoLines struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (l *repoLines) str(key, value string) {
+	if value == "" || l.err != nil {
+		return
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		l.err = fmt.Errorf("repository option %q must not contain a newline", key)
+		return
+	}
+	l.buf.WriteString(fmt.Sprintf("%s=%s\n", key, value))
+}
+
+func (l *repoLines) list(key string, values []string) {
+	l.str(key, strings.Join(values, ","))
+}
+
+func (l *repoLines) bool(key string, value *bool) {
+	if value == nil {
+		return
+	}
+	if *value {
+		l.str(key, "1")
+	} else {
+		l.str(key, "0")
+	}
+}
+
+func (l *repoLines) priority(key string, value int) {
+	if value == 0 || l.err != nil {
+		return
+	}
+	if value < 1 || value > 99 {
+		l.err = fmt.Errorf("repository %s %d is not between 1 and 99", key, value)
+		return
+	}
+	l.str(key, strconv.Itoa(value))
+}
+
+func (o *yumRepoOptions) render() ([]byte, error) {
+	if o == nil {
+		return nil, nil
+	}
+	var l repoLines
+	l.priority("priority", o.Priority)
+	if o.Cost < 0 {
+		return nil, fmt.Errorf("repository cost %d is negative", o.Cost)
+	}
+	if o.Cost > 0 {
+		l.str("cost", strconv.Itoa(o.Cost))
+	}
+	l.bool("module_hotfixes", o.ModuleHotfixes)
+	l.str("sslcacert", o.SSLCACert)
+	l.str("sslclientcert", o.SSLClientCert)
+	l.str("sslclientkey", o.SSLClientKey)
+	l.bool("sslverify", o.SSLVerify)
+	l.str("proxy", o.Proxy)
+	l.list("exclude", o.Exclude)
+	l.list("includepkgs", o.IncludePkgs)
+	l.str("metadata_expire", o.MetadataExpire)
+	l.bool("skip_if_unavailable", o.SkipIfUnavailable)
+	l.bool("repo_gpgcheck", o.RepoGPGCheck)
+	return l.buf.Bytes(), l.err
+}
+
+// repoType returns the repository type, "" when unset.
+func (o *zypperRepoOptions) repoType() string {
+	if o == nil {
+		return ""
+	}
+	return o.Type
+}
+
+func (o *zypperRepoOptions) render() ([]byte, error) {
+	if o == nil {
+		return nil, nil
+	}
+	var l repoLines
+	l.priority("priority", o.Priority)
+	l.bool("autorefresh", o.Autorefresh)
+	l.str("type", o.Type)
+	l.bool("keeppackages", o.KeepPackages)
+	l.str("path", o.Path)
+	return l.buf.Bytes(), l.err
+}
@@ -0,0 +1,84 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestYumZypperRepoOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repo_options.json")
+	if err := ioutil.WriteFile(path, []byte(`{
+  "yum": {"id": {"priority": 10, "moduleHotfixes": true, "exclude": ["kernel*", "grub2*"], "sslVerify": false}},
+  "zypper": {"id": {"autorefresh": true, "type": "rpm-md"}}
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := loadRepoOptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yum := &agentendpointpb.OSPolicy_Resource_RepositoryResource_YumRepository{Id: "id", BaseUrl: "https://repo"}
+	yumOpts, err := opts.Yum["id"].render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Repo file managed by Google OSConfig agent\n[id]\nname=id\nbaseurl=https://repo\nenabled=1\ngpgcheck=1\npriority=10\nmodule_hotfixes=1\nsslverify=0\nexclude=kernel*,grub2*\n"
+	if got := string(yumRepoContents(yum, yumOpts)); got != want {
+		t.Errorf("yumRepoContents() = %q, want %q", got, want)
+	}
+
+	zypper := &agentendpointpb.OSPolicy_Resource_RepositoryResource_ZypperRepository{Id: "id", BaseUrl: "https://repo"}
+	zypperOpts, err := opts.Zypper["id"].render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "# Repo file managed by Google OSConfig agent\n[id]\nname=id\nbaseurl=https://repo\nenabled=1\nautorefresh=1\ntype=rpm-md\n"
+	if got := string(zypperRepoContents(zypper, zypperOpts)); got != want {
+		t.Errorf("zypperRepoContents() = %q, want %q", got, want)
+	}
+
+	// Repositories without options render the minimal template.
+	if got, err := opts.Yum["other"].render(); err != nil || got != nil {
+		t.Errorf("render() of unset options = %q, %v, want nil, nil", got, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts *yumRepoOptions
+		want string
+	}{
+		{"PriorityRange", &yumRepoOptions{Priority: 100}, "between 1 and 99"},
+		{"NegativeCost", &yumRepoOptions{Cost: -1}, "negative"},
+		{"Newline", &yumRepoOptions{Proxy: "http://proxy\nenabled=0"}, "newline"},
+	} {
+		if _, err := tt.opts.render(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: render() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"yum": {"id": {"prioirty": 10}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRepoOptions(path); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("loadRepoOptions() error = %v, want unknown field error", err)
+	}
+}
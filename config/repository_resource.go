@@ -27,6 +27,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/managedfiles"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 	"golang.org/x/crypto/openpgp"
@@ -35,7 +36,15 @@ import (
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
 )
 
-const aptGPGDir = "/etc/apt/trusted.gpg.d"
+const (
+	aptGPGDir = "/etc/apt/trusted.gpg.d"
+	// aptKeyringDir holds the keyrings deb822 repo files refer to, keys in
+	// it are only trusted for those repositories.
+	aptKeyringDir = "/etc/apt/keyrings"
+)
+
+// aptDeb822 reports whether apt repositories are written in deb822 format.
+var aptDeb822 = agentconfig.AptDeb822
 
 type repositoryResource struct {
 	*agentendpointpb.OSPolicy_Resource_RepositoryResource
@@ -49,6 +58,10 @@ type AptRepository struct {
 	GpgFilePath        string
 	GpgChecksum        string
 	GpgFileContents    []byte
+	// StalePaths are the repo and key files this repository is written to
+	// in the format that is not used, they are removed so that apt does not
+	// see the repository twice.
+	StalePaths []string
 }
 
 // GooGetRepository describes an googet repository resource.
@@ -77,7 +90,7 @@ type ManagedRepository struct {
 	RepoFileContents []byte
 }
 
-func aptRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository) []byte {
+func aptRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository, src *aptSource) []byte {
 	var debArchiveTypeMap = map[agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository_ArchiveType]string{
 		agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository_DEB:     "deb",
 		agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository_DEB_SRC: "deb-src",
@@ -86,6 +99,7 @@ func aptRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_
 	/*
 		# Repo file managed by Google OSConfig agent
 		deb http://repo1-url/ repo main
+		deb [arch=amd64] http://repo2-url/ repo main
 	*/
 	var buf bytes.Buffer
 	buf.WriteString("# Repo file managed by Google OSConfig agent\n")
@@ -93,11 +107,31 @@ func aptRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_
 	if !ok {
 		archiveType = "deb"
 	}
-	line := fmt.Sprintf("%s %s %s", archiveType, repo.GetUri(), repo.GetDistribution())
-	for _, c := range repo.GetComponents() {
-		line = fmt.Sprintf("%s %s", line, c)
+	for _, line := range src.lines(archiveType, repo.GetDistribution(), repo.GetComponents()) {
+		buf.WriteString(line + "\n")
+	}
+
+	return buf.Bytes()
+}
+
+func aptSourcesContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository, src *aptSource, keyring string) []byte {
+	/*
+		# Repo file managed by Google OSConfig agent
+		Types: deb
+		URIs: http://repo1-url/ http://repo2-url/
+		Suites: repo
+		Components: main
+		Signed-By: /etc/apt/keyrings/osconfig_added_<checksum>.gpg
+	*/
+	var buf bytes.Buffer
+	buf.WriteString("# Repo file managed by Google OSConfig agent\n")
+	archiveType := "deb"
+	if repo.GetArchiveType() == agentendpointpb.OSPolicy_Resource_RepositoryResource_AptRepository_DEB_SRC {
+		archiveType = "deb-src"
+	}
+	for _, line := range src.stanza(archiveType, repo.GetDistribution(), repo.GetComponents(), keyring) {
+		buf.WriteString(line + "\n")
 	}
-	buf.WriteString(line + "\n")
 
 	return buf.Bytes()
 }
@@ -116,7 +150,7 @@ func googetRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResour
 	return buf.Bytes()
 }
 
-func yumRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_YumRepository) []byte {
+func yumRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_YumRepository, opts []byte) []byte {
 	/*
 		# Repo file managed by Google OSConfig agent
 		[Id]
@@ -124,6 +158,7 @@ func yumRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_
 		baseurl=https://repo-url
 		enabled=1
 		gpgcheck=1
+		priority=10
 		gpgkey=http://repo-url/gpg1
 		       http://repo-url/gpg2
 	*/
@@ -137,6 +172,7 @@ func yumRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_
 	}
 	buf.WriteString(fmt.Sprintf("baseurl=%s\n", repo.BaseUrl))
 	buf.WriteString("enabled=1\ngpgcheck=1\n")
+	buf.Write(opts)
 	if len(repo.GpgKeys) > 0 {
 		buf.WriteString(fmt.Sprintf("gpgkey=%s\n", repo.GpgKeys[0]))
 		for _, k := range repo.GpgKeys[1:] {
@@ -146,13 +182,14 @@ func yumRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_
 	return buf.Bytes()
 }
 
-func zypperRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_ZypperRepository) []byte {
+func zypperRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResource_ZypperRepository, opts []byte) []byte {
 	/*
 		# Repo file managed by Google OSConfig agent
 		[Id]
 		name=DisplayName
 		baseurl=https://repo-url
 		enabled=1
+		autorefresh=1
 		gpgkey=https://repo-url/gpg1
 		       https://repo-url/gpg2
 	*/
@@ -166,6 +203,7 @@ func zypperRepoContents(repo *agentendpointpb.OSPolicy_Resource_RepositoryResour
 	}
 	buf.WriteString(fmt.Sprintf("baseurl=%s\n", repo.BaseUrl))
 	buf.WriteString("enabled=1\n")
+	buf.Write(opts)
 	if len(repo.GpgKeys) > 0 {
 		buf.WriteString(fmt.Sprintf("gpgkey=%s\n", repo.GpgKeys[0]))
 		for _, k := range repo.GpgKeys[1:] {
@@ -198,7 +236,24 @@ func isArmoredGPGKey(keyData []byte) bool {
 	return false
 }
 
-func fetchGPGKey(key string) (openpgp.EntityList, error) {
+func readGPGKey(key string) ([]byte, error) {
+	// Keys shipped alongside an offline mirror are read from disk.
+	if path, ok := localRepoPath(key); ok {
+		fi, err := resourceFS.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Size() > 1024*1024 {
+			return nil, fmt.Errorf("key size of %d too large", fi.Size())
+		}
+		f, err := resourceFS.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ioutil.ReadAll(f)
+	}
+
 	resp, err := http.Get(key)
 	if err != nil {
 		return nil, err
@@ -212,6 +267,14 @@ func fetchGPGKey(key string) (openpgp.EntityList, error) {
 	if err != nil {
 		return nil, fmt.Errorf("can not read response body for key %s, err: %v", key, err)
 	}
+	return responseBody, nil
+}
+
+func fetchGPGKey(key string) (openpgp.EntityList, error) {
+	responseBody, err := readGPGKey(key)
+	if err != nil {
+		return nil, err
+	}
 
 	if isArmoredGPGKey(responseBody) {
 		return openpgp.ReadArmoredKeyRing(bytes.NewBuffer(responseBody))
@@ -229,9 +292,19 @@ func (r *repositoryResource) validate(ctx context.Context) (*ManagedResources, e
 			return nil, errors.New("cannot manage Apt repository because apt-get does not exist on the system")
 		}
 		gpgkey := r.GetApt().GetGpgKey()
+		src, err := parseAptSource(r.GetApt().GetUri())
+		if err != nil {
+			return nil, err
+		}
+		for _, uri := range src.uris {
+			if dir, ok := localRepoPath(uri); ok {
+				if err := validateLocalRepo(dir, false); err != nil {
+					return nil, err
+				}
+			}
+		}
 		r.managedRepository.Apt = &AptRepository{RepositoryResource: r.GetApt()}
-		r.managedRepository.RepoFileContents = aptRepoContents(r.GetApt())
-		repoFormat = agentconfig.AptRepoFormat()
+		var keyName string
 		if gpgkey != "" {
 			entityList, err := fetchGPGKey(gpgkey)
 			if err != nil {
@@ -244,7 +317,35 @@ func (r *repositoryResource) validate(ctx context.Context) (*ManagedResources, e
 
 			r.managedRepository.Apt.GpgFileContents = keyContents
 			r.managedRepository.Apt.GpgChecksum = checksum(bytes.NewReader(keyContents))
-			r.managedRepository.Apt.GpgFilePath = filepath.Join(aptGPGDir, "osconfig_added_"+r.managedRepository.Apt.GpgChecksum+".gpg")
+			keyName = "osconfig_added_" + r.managedRepository.Apt.GpgChecksum + ".gpg"
+		}
+
+		// Both formats are rendered, the files of the one that is not used
+		// are left over from before osconfig-apt-deb822 was changed.
+		listContents := aptRepoContents(r.GetApt(), src)
+		listPath := fmt.Sprintf(agentconfig.AptRepoFormat(), checksum(bytes.NewReader(listContents))[:10])
+		var keyring string
+		if keyName != "" {
+			keyring = filepath.Join(aptKeyringDir, keyName)
+		}
+		sourcesContents := aptSourcesContents(r.GetApt(), src, keyring)
+		sourcesPath := fmt.Sprintf(agentconfig.AptSourcesFormat(), checksum(bytes.NewReader(sourcesContents))[:10])
+		if aptDeb822() {
+			r.managedRepository.RepoFileContents = sourcesContents
+			repoFormat = agentconfig.AptSourcesFormat()
+			r.managedRepository.Apt.StalePaths = []string{listPath}
+			if keyName != "" {
+				r.managedRepository.Apt.GpgFilePath = keyring
+				r.managedRepository.Apt.StalePaths = append(r.managedRepository.Apt.StalePaths, filepath.Join(aptGPGDir, keyName))
+			}
+		} else {
+			r.managedRepository.RepoFileContents = listContents
+			repoFormat = agentconfig.AptRepoFormat()
+			r.managedRepository.Apt.StalePaths = []string{sourcesPath}
+			if keyName != "" {
+				r.managedRepository.Apt.GpgFilePath = filepath.Join(aptGPGDir, keyName)
+				r.managedRepository.Apt.StalePaths = append(r.managedRepository.Apt.StalePaths, keyring)
+			}
 		}
 
 	case *agentendpointpb.OSPolicy_Resource_RepositoryResource_Goo:
@@ -259,16 +360,47 @@ func (r *repositoryResource) validate(ctx context.Context) (*ManagedResources, e
 		if !packages.YumExists {
 			return nil, errors.New("cannot manage yum repository because yum does not exist on the system")
 		}
+		repoOpts, err := loadRepoOptions(repoOptionsFile)
+		if err != nil {
+			return nil, err
+		}
+		opts, err := repoOpts.Yum[r.GetYum().GetId()].render()
+		if err != nil {
+			return nil, fmt.Errorf("invalid options for yum repository %q: %v", r.GetYum().GetId(), err)
+		}
+		if dir, ok := localRepoPath(r.GetYum().GetBaseUrl()); ok {
+			if err := validateLocalRepo(dir, true); err != nil {
+				return nil, err
+			}
+		}
 		r.managedRepository.Yum = &YumRepository{RepositoryResource: r.GetYum()}
-		r.managedRepository.RepoFileContents = yumRepoContents(r.GetYum())
+		r.managedRepository.RepoFileContents = yumRepoContents(r.GetYum(), opts)
 		repoFormat = agentconfig.YumRepoFormat()
 
 	case *agentendpointpb.OSPolicy_Resource_RepositoryResource_Zypper:
 		if !packages.ZypperExists {
 			return nil, errors.New("cannot manage zypper repository because zypper does not exist on the system")
 		}
+		repoOpts, err := loadRepoOptions(repoOptionsFile)
+		if err != nil {
+			return nil, err
+		}
+		zypperOpts := repoOpts.Zypper[r.GetZypper().GetId()]
+		opts, err := zypperOpts.render()
+		if err != nil {
+			return nil, fmt.Errorf("invalid options for zypper repository %q: %v", r.GetZypper().GetId(), err)
+		}
 		r.managedRepository.Zypper = &ZypperRepository{RepositoryResource: r.GetZypper()}
-		r.managedRepository.RepoFileContents = zypperRepoContents(r.GetZypper())
+		r.managedRepository.RepoFileContents = zypperRepoContents(r.GetZypper(), opts)
+		if dir, ok := localRepoPath(r.GetZypper().GetBaseUrl()); ok {
+			if err := validateLocalRepo(dir, false); err != nil {
+				return nil, err
+			}
+			// A directory of rpms without metadata is served as a plaindir repo.
+			if !hasRepoMetadata(dir) && zypperOpts.repoType() == "" {
+				r.managedRepository.RepoFileContents = append(r.managedRepository.RepoFileContents, "type=plaindir\n"...)
+			}
+		}
 		repoFormat = agentconfig.ZypperRepoFormat()
 	default:
 		return nil, fmt.Errorf("Repository field not set or references unknown repository type: %v", r.GetRepository())
@@ -280,7 +412,7 @@ func (r *repositoryResource) validate(ctx context.Context) (*ManagedResources, e
 }
 
 func contentsMatch(path, chksum string) (bool, error) {
-	file, err := os.OpenFile(path, os.O_RDONLY, 0644)
+	file, err := resourceFS.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
@@ -293,6 +425,14 @@ func contentsMatch(path, chksum string) (bool, error) {
 }
 
 func (r *repositoryResource) checkState(ctx context.Context) (inDesiredState bool, err error) {
+	if r.managedRepository.Apt != nil {
+		for _, p := range r.managedRepository.Apt.StalePaths {
+			if _, err := resourceFS.Stat(p); err == nil {
+				return false, nil
+			}
+		}
+	}
+
 	// Check APT gpg key if applicable.
 	if r.managedRepository.Apt != nil && r.managedRepository.Apt.GpgFileContents != nil {
 		match, err := contentsMatch(r.managedRepository.Apt.GpgFilePath, r.managedRepository.Apt.GpgChecksum)
@@ -309,19 +449,35 @@ func (r *repositoryResource) checkState(ctx context.Context) (inDesiredState boo
 
 func (r *repositoryResource) enforceState(ctx context.Context) (inDesiredState bool, err error) {
 	clog.Infof(ctx, "Enforcing repo %s.", r.managedRepository.RepoFilePath)
-	// Set APT gpg key if applicable.
-	if r.managedRepository.Apt != nil && r.managedRepository.Apt.GpgFileContents != nil {
-		if err := ioutil.WriteFile(r.managedRepository.Apt.GpgFilePath, r.managedRepository.Apt.GpgFileContents, 0644); err != nil {
-			return false, err
-		}
+	if err := resourceFS.MkdirAll(filepath.Dir(r.managedRepository.RepoFilePath), 0755); err != nil {
+		return false, err
 	}
 
-	if err := os.MkdirAll(filepath.Dir(r.managedRepository.RepoFilePath), 0755); err != nil {
-		return false, err
+	// The APT gpg key, if applicable, and the repo file are written together
+	// so a repo is never left trusting a stale key or vice versa.
+	var files []util.TxFile
+	if r.managedRepository.Apt != nil && r.managedRepository.Apt.GpgFileContents != nil {
+		files = append(files, util.TxFile{Path: r.managedRepository.Apt.GpgFilePath, Content: r.managedRepository.Apt.GpgFileContents, Mode: 0644})
+	}
+	files = append(files, util.TxFile{Path: r.managedRepository.RepoFilePath, Content: r.managedRepository.RepoFileContents, Mode: 0644})
+	var paths []string
+	for _, f := range files {
+		managedfiles.CheckFile(ctx, f.Path)
+		paths = append(paths, f.Path)
 	}
-	if err := util.AtomicWrite(r.managedRepository.RepoFilePath, r.managedRepository.RepoFileContents, 0644); err != nil {
+	if err := resourceFS.AtomicWriteFiles(files); err != nil {
 		return false, err
 	}
+	managedfiles.Record(ctx, paths...)
+
+	if r.managedRepository.Apt != nil {
+		for _, p := range r.managedRepository.Apt.StalePaths {
+			if err := resourceFS.Remove(p); err != nil && !os.IsNotExist(err) {
+				return false, fmt.Errorf("error removing %q: %v", p, err)
+			}
+			managedfiles.Forget(ctx, p)
+		}
+	}
 	return true, nil
 }
 
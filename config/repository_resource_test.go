@@ -70,6 +70,7 @@ func TestRepositoryResourceValidate(t *testing.T) {
 			ManagedRepository{
 				Apt: &AptRepository{
 					RepositoryResource: aptRepositoryResource,
+					StalePaths:         []string{"/etc/apt/sources.list.d/osconfig_managed_2ef4eff1d0.sources"},
 				},
 				RepoChecksum:     "8faacd43b230b08e7a1da7b670bf6f90fcc59ade1a5e7179a0ccffc9aa3d7cdf",
 				RepoFileContents: []byte("# Repo file managed by Google OSConfig agent\ndeb uri distribution c1 c2\n"),
@@ -145,6 +146,36 @@ func TestRepositoryResourceValidate(t *testing.T) {
 	}
 }
 
+func TestRepositoryResourceValidateDeb822(t *testing.T) {
+	old := aptDeb822
+	defer func() { aptDeb822 = old }()
+	aptDeb822 = func() bool { return true }
+
+	pr := &OSPolicyResource{
+		OSPolicy_Resource: &agentendpointpb.OSPolicy_Resource{
+			ResourceType: &agentendpointpb.OSPolicy_Resource_Repository{Repository: &agentendpointpb.OSPolicy_Resource_RepositoryResource{
+				Repository: &agentendpointpb.OSPolicy_Resource_RepositoryResource_Apt{Apt: aptRepositoryResource},
+			}},
+		},
+	}
+	if err := pr.Validate(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	contents := "# Repo file managed by Google OSConfig agent\nTypes: deb\nURIs: uri\nSuites: distribution\nComponents: c1 c2\n"
+	want := ManagedRepository{
+		Apt: &AptRepository{
+			RepositoryResource: aptRepositoryResource,
+			StalePaths:         []string{"/etc/apt/sources.list.d/osconfig_managed_8faacd43b2.list"},
+		},
+		RepoChecksum:     checksum(strings.NewReader(contents)),
+		RepoFileContents: []byte(contents),
+		RepoFilePath:     "/etc/apt/sources.list.d/osconfig_managed_2ef4eff1d0.sources",
+	}
+	if diff := cmp.Diff(pr.resource.(*repositoryResource).managedRepository, want, protocmp.Transform()); diff != "" {
+		t.Errorf("packageResouce does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
 func TestRepositoryResourceCheckState(t *testing.T) {
 	ctx := context.Background()
 	var tests = []struct {
@@ -0,0 +1,128 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// Simulation states for a resource.
+const (
+	SimulationCompliant    = "COMPLIANT"
+	SimulationWouldChange  = "WOULD_CHANGE"
+	SimulationNonCompliant = "NON_COMPLIANT"
+	SimulationError        = "ERROR"
+	SimulationNotEvaluated = "NOT_EVALUATED"
+)
+
+// SimulationResult is the simulated outcome for a single resource.
+type SimulationResult struct {
+	OSPolicyID string `json:"osPolicyId"`
+	ResourceID string `json:"resourceId"`
+	State      string `json:"state"`
+	Error      string `json:"error,omitempty"`
+}
+
+var errNotEvaluatedExec = errors.New("exec resources are not run in a simulation")
+
+// LoadSimulationPolicies reads an ApplyConfigTask in JSON format, as used by
+// the agent endpoint API, from path.
+func LoadSimulationPolicies(path string) ([]*agentendpointpb.ApplyConfigTask_OSPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var task agentendpointpb.ApplyConfigTask
+	un := &protojson.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}
+	if err := un.Unmarshal(data, &task); err != nil {
+		return nil, fmt.Errorf("error parsing %q: %v", path, err)
+	}
+	return task.GetOsPolicies(), nil
+}
+
+// Simulate evaluates policies against the current host without enforcing
+// any resources, only the validate and check state steps are run.
+// Exec resources, including external resource providers, are reported as
+// NOT_EVALUATED as their scripts may change the host.
+//
+// As no enforcement happens, resources that depend on an earlier resource
+// in the same policy being enforced (e.g. a package from a new repository)
+// may report an error that would not happen in a real run.
+func Simulate(ctx context.Context, policies []*agentendpointpb.ApplyConfigTask_OSPolicy) []*SimulationResult {
+	return simulate(ctx, policies, simulateResource)
+}
+
+type simulateFunc func(context.Context, *OSPolicyResource, agentendpointpb.OSPolicy_Mode) (string, error)
+
+func simulate(ctx context.Context, policies []*agentendpointpb.ApplyConfigTask_OSPolicy, eval simulateFunc) []*SimulationResult {
+	var results []*SimulationResult
+	for _, p := range policies {
+		ctx := clog.WithLabels(ctx, map[string]string{"os_policy_id": p.GetId()})
+		failed := false
+		for _, r := range p.GetResources() {
+			result := &SimulationResult{OSPolicyID: p.GetId(), ResourceID: r.GetId(), State: SimulationNotEvaluated}
+			results = append(results, result)
+			// Like a real run, stop evaluating a policy after the first error.
+			if failed {
+				continue
+			}
+
+			res := &OSPolicyResource{OSPolicy_Resource: r}
+			state, err := eval(ctx, res, p.GetMode())
+			result.State = state
+			if err != nil {
+				result.Error = err.Error()
+			}
+			if state == SimulationError {
+				failed = true
+			}
+		}
+	}
+	return results
+}
+
+func simulateResource(ctx context.Context, res *OSPolicyResource, mode agentendpointpb.OSPolicy_Mode) (string, error) {
+	if res.GetExec() != nil {
+		return SimulationNotEvaluated, errNotEvaluatedExec
+	}
+	if err := res.Validate(ctx); err != nil {
+		return SimulationError, fmt.Errorf("validate: %v", err)
+	}
+	defer func() {
+		if err := res.Cleanup(ctx); err != nil {
+			clog.Warningf(ctx, "Error cleaning up resource %q: %v", res.GetId(), err)
+		}
+	}()
+
+	if err := res.CheckState(ctx); err != nil {
+		return SimulationError, fmt.Errorf("check state: %v", err)
+	}
+	switch {
+	case res.InDesiredState():
+		return SimulationCompliant, nil
+	case mode == agentendpointpb.OSPolicy_VALIDATION:
+		return SimulationNonCompliant, nil
+	default:
+		return SimulationWouldChange, nil
+	}
+}
@@ -0,0 +1,107 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSimulate(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	present := filepath.Join(tmpDir, "present")
+	if err := ioutil.WriteFile(present, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tmpDir, "missing")
+	execRan := filepath.Join(tmpDir, "exec_ran")
+
+	policyFile := filepath.Join(tmpDir, "policy.json")
+	policy := fmt.Sprintf(`{
+  "osPolicies": [
+    {
+      "id": "enforce",
+      "mode": "ENFORCEMENT",
+      "resources": [
+        {"id": "present", "file": {"path": %[1]q, "state": "PRESENT", "content": "foo"}},
+        {"id": "missing", "file": {"path": %[2]q, "state": "PRESENT", "content": "foo"}}
+      ]
+    },
+    {
+      "id": "validate",
+      "mode": "VALIDATION",
+      "resources": [
+        {"id": "missing", "file": {"path": %[2]q, "state": "PRESENT", "content": "foo"}},
+        {"id": "exec", "exec": {"validate": {"script": "touch %[3]s; exit 100", "interpreter": "SHELL"}}}
+      ]
+    },
+    {
+      "id": "error",
+      "mode": "ENFORCEMENT",
+      "resources": [
+        {"id": "bad", "file": {"path": %[2]q, "state": "PRESENT", "content": "foo", "permissions": "bad"}},
+        {"id": "after-bad", "file": {"path": %[1]q, "state": "PRESENT", "content": "foo"}}
+      ]
+    }
+  ]
+}`, present, missing, filepath.ToSlash(execRan))
+	if err := ioutil.WriteFile(policyFile, []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := LoadSimulationPolicies(policyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Simulate(ctx, policies)
+	for _, r := range got {
+		if r.State == SimulationError && r.Error == "" {
+			t.Errorf("resource %q in error state without error message", r.ResourceID)
+		}
+		r.Error = ""
+	}
+
+	want := []*SimulationResult{
+		{OSPolicyID: "enforce", ResourceID: "present", State: SimulationCompliant},
+		{OSPolicyID: "enforce", ResourceID: "missing", State: SimulationWouldChange},
+		{OSPolicyID: "validate", ResourceID: "missing", State: SimulationNonCompliant},
+		{OSPolicyID: "validate", ResourceID: "exec", State: SimulationNotEvaluated},
+		{OSPolicyID: "error", ResourceID: "bad", State: SimulationError},
+		{OSPolicyID: "error", ResourceID: "after-bad", State: SimulationNotEvaluated},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Simulate() mismatch (-want +got):\n%s", diff)
+	}
+
+	if util.Exists(missing) {
+		t.Errorf("simulation created %q", missing)
+	}
+	if util.Exists(execRan) {
+		t.Error("simulation ran an exec resource script")
+	}
+}
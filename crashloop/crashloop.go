@@ -0,0 +1,252 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package crashloop detects agent features that repeatedly crash the agent
+// and quarantines them, so a single crashing feature, such as a failing WUA
+// COM call, does not keep the whole agent in a restart loop.
+//
+// A feature is marked as running in a local state file while it runs. A
+// marker that is still present when the agent starts again during the same
+// boot means the agent died while running that feature. Markers left by a
+// reboot or a clean shutdown are not counted.
+package crashloop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	stateFile = filepath.Join(agentconfig.CacheDir(), "osconfig_crashloop.state")
+	// threshold is the number of consecutive crashes that quarantine a
+	// feature.
+	threshold = 3
+	// baseQuarantine is the first quarantine period, it doubles with every
+	// further crash up to maxQuarantine.
+	baseQuarantine = time.Hour
+	maxQuarantine  = 24 * time.Hour
+
+	now    = time.Now
+	bootID = currentBootID
+
+	mx sync.Mutex
+	st *state
+)
+
+// Status is the crash state of a feature.
+type Status struct {
+	Feature string `json:"feature"`
+	// Crashes is the number of consecutive runs that crashed the agent.
+	Crashes          int       `json:"crashes"`
+	LastCrash        time.Time `json:"lastCrash,omitempty"`
+	QuarantinedUntil time.Time `json:"quarantinedUntil,omitempty"`
+	// Running is the boot the feature was last started in, it is cleared
+	// when the feature finishes.
+	Running string `json:"running,omitempty"`
+}
+
+type state struct {
+	Features map[string]*Status `json:"features"`
+}
+
+// QuarantinedError is returned by Begin for a quarantined feature.
+type QuarantinedError struct {
+	Feature string
+	Crashes int
+	Until   time.Time
+}
+
+func (e *QuarantinedError) Error() string {
+	return fmt.Sprintf("%s is quarantined until %s after crashing the agent %d times in a row", e.Feature, e.Until.Format(time.RFC3339), e.Crashes)
+}
+
+// currentBootID returns the ID of the current boot, "unknown" if it can not
+// be read.
+func currentBootID() string {
+	id, err := util.BootID()
+	if err != nil {
+		return "unknown"
+	}
+	return id
+}
+
+func quarantineFor(crashes int) time.Duration {
+	d := baseQuarantine
+	for i := threshold; i < crashes && d < maxQuarantine; i++ {
+		d *= 2
+	}
+	if d > maxQuarantine {
+		d = maxQuarantine
+	}
+	return d
+}
+
+// load reads the state file and records a crash for every feature that was
+// still running in this boot. Must be called with mx held.
+func load(ctx context.Context) {
+	if st != nil {
+		return
+	}
+	st = &state{Features: map[string]*Status{}}
+	data, err := integrity.ReadFile(stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			clog.Warningf(ctx, "Error reading crash loop state, resetting it: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, st); err != nil || st.Features == nil {
+		clog.Warningf(ctx, "Error parsing crash loop state, resetting it: %v", err)
+		st = &state{Features: map[string]*Status{}}
+		return
+	}
+
+	boot := bootID()
+	changed := false
+	for name, f := range st.Features {
+		if f.Running == "" {
+			continue
+		}
+		changed = true
+		if f.Running != boot {
+			// The system rebooted while the feature was running.
+			f.Running = ""
+			continue
+		}
+		f.Running = ""
+		f.Crashes++
+		f.LastCrash = now()
+		if f.Crashes < threshold {
+			clog.Warningf(ctx, "The agent stopped unexpectedly while running %s (%d consecutive crashes).", name, f.Crashes)
+			continue
+		}
+		f.QuarantinedUntil = f.LastCrash.Add(quarantineFor(f.Crashes))
+		clog.Errorf(ctx, "QUARANTINED: %s crashed the agent %d times in a row and will be skipped until %s.", name, f.Crashes, f.QuarantinedUntil.Format(time.RFC3339))
+	}
+	if changed {
+		if err := save(); err != nil {
+			clog.Errorf(ctx, "Error saving crash loop state: %v", err)
+		}
+	}
+}
+
+func save() error {
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return integrity.WriteFile(stateFile, data, 0600)
+}
+
+// Init loads the crash loop state, recording crashes of features that were
+// running when the agent last stopped, and logs every quarantined feature.
+func Init(ctx context.Context) {
+	mx.Lock()
+	defer mx.Unlock()
+	load(ctx)
+	for name, f := range st.Features {
+		if now().Before(f.QuarantinedUntil) {
+			clog.Errorf(ctx, "QUARANTINED: %s will be skipped until %s after crashing the agent %d times in a row.", name, f.QuarantinedUntil.Format(time.RFC3339), f.Crashes)
+		}
+	}
+}
+
+// Begin marks feature as running. A *QuarantinedError is returned if the
+// feature is quarantined, otherwise the returned func must be called once
+// the feature finishes, whether or not it succeeded.
+func Begin(ctx context.Context, feature string) (func(), error) {
+	mx.Lock()
+	defer mx.Unlock()
+	load(ctx)
+	f, ok := st.Features[feature]
+	if !ok {
+		f = &Status{Feature: feature}
+		st.Features[feature] = f
+	}
+	if now().Before(f.QuarantinedUntil) {
+		return nil, &QuarantinedError{Feature: feature, Crashes: f.Crashes, Until: f.QuarantinedUntil}
+	}
+	f.Running = bootID()
+	if err := save(); err != nil {
+		clog.Errorf(ctx, "Error saving crash loop state: %v", err)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mx.Lock()
+			defer mx.Unlock()
+			// A completed run resets the backoff.
+			f.Running = ""
+			f.Crashes = 0
+			f.QuarantinedUntil = time.Time{}
+			if err := save(); err != nil {
+				clog.Errorf(ctx, "Error saving crash loop state: %v", err)
+			}
+		})
+	}, nil
+}
+
+// Stop clears the running markers on a clean agent shutdown so features
+// interrupted by it are not counted as crashes.
+func Stop() {
+	mx.Lock()
+	defer mx.Unlock()
+	if st == nil {
+		return
+	}
+	changed := false
+	for _, f := range st.Features {
+		if f.Running != "" {
+			f.Running = ""
+			changed = true
+		}
+	}
+	if changed {
+		save()
+	}
+}
+
+// Statuses returns the state of every feature that has crashed the agent,
+// for health reports.
+func Statuses(ctx context.Context) []Status {
+	mx.Lock()
+	defer mx.Unlock()
+	load(ctx)
+	var ret []Status
+	for name, f := range st.Features {
+		if f.Crashes == 0 {
+			continue
+		}
+		s := *f
+		s.Feature = name
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Feature < ret[j].Feature })
+	return ret
+}
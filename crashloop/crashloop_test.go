@@ -0,0 +1,153 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package crashloop
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setup(t *testing.T) func() {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	oldFile, oldNow, oldBootID := stateFile, now, bootID
+	stateFile = filepath.Join(td, "crashloop.state")
+	st = nil
+	return func() {
+		stateFile, now, bootID, st = oldFile, oldNow, oldBootID, nil
+		os.RemoveAll(td)
+	}
+}
+
+// restart simulates an agent restart by dropping the in memory state.
+func restart() { st = nil }
+
+func TestCrashLoop(t *testing.T) {
+	defer setup(t)()
+	ctx := context.Background()
+	clock := time.Unix(100000, 0)
+	now = func() time.Time { return clock }
+	bootID = func() string { return "boot1" }
+
+	// A run that finishes is not a crash.
+	end, err := Begin(ctx, "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	end()
+	restart()
+	if s := Statuses(ctx); len(s) != 0 {
+		t.Fatalf("unexpected crashes: %+v", s)
+	}
+
+	// Crash threshold times in a row.
+	for i := 1; i <= threshold; i++ {
+		if _, err := Begin(ctx, "inventory"); err != nil {
+			t.Fatalf("crash %d: unexpected error: %v", i, err)
+		}
+		restart()
+	}
+	_, err = Begin(ctx, "inventory")
+	var qerr *QuarantinedError
+	if !errors.As(err, &qerr) {
+		t.Fatalf("expected a QuarantinedError, got %v", err)
+	}
+	if want := clock.Add(baseQuarantine); !qerr.Until.Equal(want) || qerr.Crashes != threshold {
+		t.Errorf("got quarantine %+v, want until %s after %d crashes", qerr, want, threshold)
+	}
+	// Other features are not affected.
+	if _, err := Begin(ctx, "guest policies"); err != nil {
+		t.Errorf("unexpected error for another feature: %v", err)
+	}
+
+	// After the quarantine a further crash doubles it.
+	clock = clock.Add(baseQuarantine)
+	if _, err := Begin(ctx, "inventory"); err != nil {
+		t.Fatalf("unexpected error after the quarantine ended: %v", err)
+	}
+	restart()
+	_, err = Begin(ctx, "inventory")
+	if !errors.As(err, &qerr) || !qerr.Until.Equal(clock.Add(2*baseQuarantine)) {
+		t.Errorf("expected a doubled quarantine, got %v", err)
+	}
+
+	// A successful run resets the counters.
+	clock = clock.Add(2 * baseQuarantine)
+	end, err = Begin(ctx, "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	end()
+	restart()
+	for _, s := range Statuses(ctx) {
+		if s.Feature == "inventory" {
+			t.Errorf("expected inventory crashes to be reset, got %+v", s)
+		}
+	}
+}
+
+func TestCrashLoopIgnoresRebootsAndShutdowns(t *testing.T) {
+	defer setup(t)()
+	ctx := context.Background()
+	boot := "boot1"
+	bootID = func() string { return boot }
+
+	for i := 0; i < threshold; i++ {
+		// Interrupted by a reboot.
+		if _, err := Begin(ctx, "patch task"); err != nil {
+			t.Fatal(err)
+		}
+		restart()
+		boot += "x"
+
+		// Interrupted by a clean shutdown.
+		if _, err := Begin(ctx, "patch task"); err != nil {
+			t.Fatal(err)
+		}
+		Stop()
+		restart()
+	}
+	if _, err := Begin(ctx, "patch task"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	restart()
+	if s := Statuses(ctx); len(s) != 1 || s[0].Crashes != 1 {
+		t.Errorf("expected only the last unfinished run to count as a crash, got %+v", s)
+	}
+}
+
+func TestQuarantineFor(t *testing.T) {
+	tests := []struct {
+		crashes int
+		want    time.Duration
+	}{
+		{threshold, baseQuarantine},
+		{threshold + 1, 2 * baseQuarantine},
+		{threshold + 2, 4 * baseQuarantine},
+		{threshold + 20, maxQuarantine},
+	}
+	for _, tt := range tests {
+		if got := quarantineFor(tt.crashes); got != tt.want {
+			t.Errorf("quarantineFor(%d) = %s, want %s", tt.crashes, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package crashreport captures agent panics as structured crash reports in
+// a local crash directory, so intermittent agent crashes can be diagnosed,
+// and reports them when the agent starts again.
+//
+// A report holds the panic value, the stacks of all goroutines, the agent
+// version, the running task and package operations, and the most recent
+// local log lines. A panic is only captured in a goroutine that defers
+// Recover, every long running or task goroutine of the agent does.
+package crashreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/progress"
+)
+
+const (
+	reportPrefix   = "crash-"
+	reportExt      = ".json"
+	reportedSuffix = ".reported"
+	timeFormat     = "20060102T150405.000000000Z"
+
+	// maxStack caps the size of the goroutine dump in a report.
+	maxStack = 1 << 20
+)
+
+var (
+	crashDir = filepath.Join(agentconfig.CacheDir(), "crash")
+	// maxReports is how many reports, reported or not, are kept.
+	maxReports = 10
+
+	now              = time.Now
+	activeOperations = progress.Active
+	getenv           = os.Getenv
+	setTraceback     = debug.SetTraceback
+
+	// coreDump is the last SetCoreDump setting, the runtime starts with the
+	// default traceback level.
+	coreDumpMx sync.Mutex
+	coreDump   bool
+
+	logs = newRing(200)
+
+	// LogWriter keeps the most recent log lines for crash reports, it
+	// should be added to the writers of the logger.
+	LogWriter io.Writer = logs
+)
+
+// Report is a crash report.
+type Report struct {
+	Time       time.Time            `json:"time"`
+	Version    string               `json:"version"`
+	OS         string               `json:"os"`
+	Arch       string               `json:"arch"`
+	Panic      string               `json:"panic"`
+	Task       string               `json:"task,omitempty"`
+	Operations []progress.Operation `json:"operations,omitempty"`
+	Stack      string               `json:"stack"`
+	RecentLogs []string             `json:"recentLogs,omitempty"`
+}
+
+// Recover writes a crash report for a panic in the calling goroutine and
+// then panics again, so the agent still crashes and crash loop detection
+// still sees it. It must be deferred directly:
+//
+//	defer crashreport.Recover(ctx, "task name")
+func Recover(ctx context.Context, task string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	path, err := write(newReport(r, task))
+	if err != nil {
+		clog.Errorf(ctx, "Error writing crash report: %v", err)
+	} else {
+		clog.Errorf(ctx, "Agent panic while running %q, crash report written to %q: %v", task, path, r)
+	}
+	panic(r)
+}
+
+// SetCoreDump makes the runtime abort with a core dump, where the system
+// allows one, instead of exiting when the agent crashes. It can be called
+// whenever the configuration is read, the traceback level only changes when
+// the setting does. A GOTRACEBACK set by the operator always wins.
+func SetCoreDump(enabled bool) {
+	if getenv("GOTRACEBACK") != "" {
+		return
+	}
+	coreDumpMx.Lock()
+	defer coreDumpMx.Unlock()
+	if enabled == coreDump {
+		return
+	}
+	coreDump = enabled
+	if enabled {
+		setTraceback("crash")
+		return
+	}
+	// Back to the runtime default.
+	setTraceback("single")
+}
+
+func newReport(r any, task string) *Report {
+	stack := make([]byte, maxStack)
+	stack = stack[:runtime.Stack(stack, true)]
+	return &Report{
+		Time:       now().UTC(),
+		Version:    agentconfig.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Panic:      fmt.Sprint(r),
+		Task:       task,
+		Operations: activeOperations(),
+		Stack:      string(stack),
+		RecentLogs: logs.lines(),
+	}
+}
+
+func write(r *Report) (string, error) {
+	if err := os.MkdirAll(crashDir, 0700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(crashDir, reportPrefix+r.Time.Format(timeFormat)+reportExt)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	prune()
+	return path, nil
+}
+
+// prune removes the oldest reports beyond maxReports.
+func prune() {
+	entries, err := os.ReadDir(crashDir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), reportPrefix) {
+			names = append(names, e.Name())
+		}
+	}
+	// The timestamp in the name sorts the reports by age.
+	sort.Strings(names)
+	for len(names) > maxReports {
+		os.Remove(filepath.Join(crashDir, names[0]))
+		names = names[1:]
+	}
+}
+
+// ReportPending reports the crash reports written since the agent last
+// started. With upload the full report is sent as a structured log entry,
+// otherwise only a summary pointing at the local file is logged. Each
+// report is reported once.
+func ReportPending(ctx context.Context, upload bool) {
+	matches, err := filepath.Glob(filepath.Join(crashDir, reportPrefix+"*"+reportExt))
+	if err != nil {
+		return
+	}
+	sort.Strings(matches)
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			clog.Errorf(ctx, "Error reading crash report %q: %v", path, err)
+			continue
+		}
+		var r Report
+		if err := json.Unmarshal(data, &r); err != nil {
+			clog.Errorf(ctx, "Error parsing crash report %q: %v", path, err)
+		} else if upload {
+			clog.ErrorStructured(ctx, r, "The agent (version %s) crashed at %s while running %q: %s", r.Version, r.Time.Format(time.RFC3339), r.Task, r.Panic)
+		} else {
+			clog.Warningf(ctx, "The agent (version %s) crashed at %s while running %q: %s. See the crash report %q.", r.Version, r.Time.Format(time.RFC3339), r.Task, r.Panic, path)
+		}
+		if err := os.Rename(path, path+reportedSuffix); err != nil {
+			clog.Errorf(ctx, "Error marking crash report %q as reported: %v", path, err)
+		}
+	}
+}
+
+// ring keeps the last lines written to it.
+type ring struct {
+	mx   sync.Mutex
+	buf  []string
+	next int
+	full bool
+}
+
+func newRing(size int) *ring {
+	return &ring{buf: make([]string, size)}
+}
+
+func (r *ring) Write(p []byte) (int, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, ln := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		r.buf[r.next] = string(ln)
+		r.next = (r.next + 1) % len(r.buf)
+		if r.next == 0 {
+			r.full = true
+		}
+	}
+	return len(p), nil
+}
+
+func (r *ring) lines() []string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if !r.full {
+		return append([]string(nil), r.buf[:r.next]...)
+	}
+	return append(append([]string(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package crashreport
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/progress"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(3)
+	r.Write([]byte("one\n"))
+	if got, want := r.lines(), []string{"one"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lines() = %q, want %q", got, want)
+	}
+	r.Write([]byte("two\nthree\nfour\n"))
+	if got, want := r.lines(), []string{"two", "three", "four"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lines() = %q, want %q", got, want)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	defer func(dir string, max int, l *ring) {
+		crashDir, maxReports, logs = dir, max, l
+		now, activeOperations = time.Now, progress.Active
+	}(crashDir, maxReports, logs)
+	crashDir = t.TempDir()
+	maxReports = 2
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	activeOperations = func() []progress.Operation { return []progress.Operation{{ID: "apt-1", Manager: "apt"}} }
+	logs = newRing(10)
+	logs.Write([]byte("last log line\n"))
+
+	crash := func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want the panic to continue as \"boom\"", r)
+			}
+		}()
+		defer Recover(context.Background(), "PatchRun")
+		panic("boom")
+	}
+	for i := 0; i < 3; i++ {
+		now = func() time.Time { return start.Add(time.Duration(i) * time.Second) }
+		crash()
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(crashDir, reportPrefix+"*"))
+	if len(matches) != 2 {
+		t.Fatalf("reports = %q, want the newest 2", matches)
+	}
+	data, err := os.ReadFile(matches[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Time.Equal(start.Add(2*time.Second)) || r.Panic != "boom" || r.Task != "PatchRun" || len(r.Operations) != 1 {
+		t.Errorf("report = %+v, want the last panic", r)
+	}
+	if !strings.Contains(r.Stack, "TestRecover") {
+		t.Errorf("report stack does not contain the panicking test:\n%s", r.Stack)
+	}
+	if !reflect.DeepEqual(r.RecentLogs, []string{"last log line"}) {
+		t.Errorf("report logs = %q, want the log ring", r.RecentLogs)
+	}
+
+	ReportPending(context.Background(), false)
+	if pending, _ := filepath.Glob(filepath.Join(crashDir, "*"+reportExt)); len(pending) != 0 {
+		t.Errorf("pending reports after ReportPending = %q, want none", pending)
+	}
+	if reported, _ := filepath.Glob(filepath.Join(crashDir, "*"+reportedSuffix)); len(reported) != 2 {
+		t.Errorf("reported reports = %q, want 2", reported)
+	}
+}
+
+func TestSetCoreDump(t *testing.T) {
+	defer func() { getenv, setTraceback, coreDump = os.Getenv, debug.SetTraceback, false }()
+	env := map[string]string{}
+	getenv = func(k string) string { return env[k] }
+	var set []string
+	setTraceback = func(level string) { set = append(set, level) }
+
+	for _, enabled := range []bool{false, true, true, false, false} {
+		SetCoreDump(enabled)
+	}
+	if want := []string{"crash", "single"}; !reflect.DeepEqual(set, want) {
+		t.Errorf("traceback levels set = %q, want %q", set, want)
+	}
+
+	set = nil
+	env["GOTRACEBACK"] = "all"
+	SetCoreDump(true)
+	if set != nil {
+		t.Errorf("traceback levels set with GOTRACEBACK = %q, want none", set)
+	}
+}
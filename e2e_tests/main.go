@@ -35,6 +35,7 @@ import (
 	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_suites/inventory"
 	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_suites/inventoryreporting"
 	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_suites/ospolicies"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_suites/ospolicydrift"
 	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_suites/patch"
 
 	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
@@ -42,6 +43,7 @@ import (
 
 var testFunctions = []func(context.Context, *sync.WaitGroup, chan *junitxml.TestSuite, *log.Logger, *regexp.Regexp, *regexp.Regexp){
 	ospolicies.TestSuite,
+	ospolicydrift.TestSuite,
 	guestpolicies.TestSuite,
 	inventory.TestSuite,
 	inventoryreporting.TestSuite,
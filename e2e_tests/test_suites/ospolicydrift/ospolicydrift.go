@@ -0,0 +1,287 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package ospolicydrift contains e2e tests that introduce drift on
+// instances managed by OS policies and measure how long the agent takes to
+// bring them back into compliance.
+package ospolicydrift
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/go/e2e_test_utils/junitxml"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/compute"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/config"
+	gcpclients "github.com/GoogleCloudPlatform/osconfig/e2e_tests/gcp_clients"
+	osconfig "github.com/GoogleCloudPlatform/osconfig/e2e_tests/internal/cloud.google.com/go/osconfig/apiv1"
+	testconfig "github.com/GoogleCloudPlatform/osconfig/e2e_tests/test_config"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/utils"
+	"github.com/google/go-cmp/cmp"
+	computeApi "google.golang.org/api/compute/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	osconfigpb "github.com/GoogleCloudPlatform/osconfig/e2e_tests/internal/google.golang.org/genproto/googleapis/cloud/osconfig/v1"
+)
+
+var testSuiteName = "OSPolicyDrift"
+
+// TestSuite is a OSPolicy drift remediation test suite.
+func TestSuite(ctx context.Context, tswg *sync.WaitGroup, testSuites chan *junitxml.TestSuite, logger *log.Logger, testSuiteRegex, testCaseRegex *regexp.Regexp) {
+	defer tswg.Done()
+
+	if testSuiteRegex != nil && !testSuiteRegex.MatchString(testSuiteName) {
+		return
+	}
+
+	testSuite := junitxml.NewTestSuite(testSuiteName)
+	defer testSuite.Finish(testSuites)
+
+	logger.Printf("Running TestSuite %q", testSuite.Name)
+	var wg sync.WaitGroup
+	tests := make(chan *junitxml.TestCase)
+	for _, setup := range generateAllTestSetup() {
+		wg.Add(1)
+		go testCase(ctx, setup, tests, &wg, logger, testCaseRegex)
+	}
+
+	go func() {
+		wg.Wait()
+		close(tests)
+	}()
+
+	for ret := range tests {
+		testSuite.TestCase = append(testSuite.TestCase, ret)
+	}
+
+	logger.Printf("Finished TestSuite %q", testSuite.Name)
+}
+
+// Use this lock to limit write QPS.
+var gpMx sync.Mutex
+
+func createOSPolicyAssignment(ctx context.Context, client *osconfig.OsConfigZonalClient, req *osconfigpb.CreateOSPolicyAssignmentRequest, testCase *junitxml.TestCase) (*osconfigpb.OSPolicyAssignment, error) {
+	// Use the lock to slow down write QPS just a bit.
+	gpMx.Lock()
+	op, err := client.CreateOSPolicyAssignment(ctx, req)
+	gpMx.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("error running CreateOSPolicyAssignment: %s", utils.GetStatusFromError(err))
+	}
+	testCase.Logf("OSPolicyAssignment created, waiting for operation %q", op.Name())
+	ctx, cncl := context.WithTimeout(ctx, 10*time.Minute)
+	defer cncl()
+	ospa, err := op.Wait(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for create operation %q, to complete: %s", op.Name(), utils.GetStatusFromError(err))
+	}
+	return ospa, nil
+}
+
+func cleanupOSPolicyAssignment(ctx context.Context, client *osconfig.OsConfigZonalClient, testCase *junitxml.TestCase, name string) {
+	// Use the lock to slow down write QPS just a bit.
+	gpMx.Lock()
+	op, err := client.DeleteOSPolicyAssignment(ctx, &osconfigpb.DeleteOSPolicyAssignmentRequest{Name: name})
+	gpMx.Unlock()
+	if err != nil {
+		testCase.WriteFailure(fmt.Sprintf("Error calling DeleteOSPolicyAssignment: %s", utils.GetStatusFromError(err)))
+		return
+	}
+	ctx, cncl := context.WithTimeout(ctx, 5*time.Minute)
+	defer cncl()
+	op.Wait(ctx)
+}
+
+// guestTimestamp reads a unix timestamp written by the startup script to
+// the given guest attribute.
+func guestTimestamp(inst *compute.Instance, queryPath string, timeout time.Duration) (time.Time, error) {
+	attrs, err := inst.WaitForGuestAttributes(queryPath, 10*time.Second, timeout)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(attrs) == 0 {
+		return time.Time{}, fmt.Errorf("guest attribute %q is empty", queryPath)
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(attrs[0].Value), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("guest attribute %q is not a unix timestamp: %v", queryPath, err)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+func compareCompliances(want []*osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance, got []*osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance) string {
+	return cmp.Diff(want, got, protocmp.Transform(), cmp.FilterPath(func(p cmp.Path) bool {
+		return p.Last().String() == `["os_policy_assignment"]`
+	}, cmp.Ignore()))
+}
+
+// waitForReport polls the OSPolicyAssignmentReport until one from a run
+// that completed after the given time has the wanted compliances. Reports
+// are not there until the first run and a run can overlap the drift, so a
+// single report is not enough to fail on.
+func waitForReport(ctx context.Context, client *osconfig.OsConfigZonalClient, name string, want []*osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance, after time.Time, timeout time.Duration) (*osconfigpb.OSPolicyAssignmentReport, error) {
+	tick := time.NewTicker(15 * time.Second)
+	defer tick.Stop()
+	timedout := time.After(timeout)
+	var last string
+	for {
+		report, err := client.GetOSPolicyAssignmentReport(ctx, &osconfigpb.GetOSPolicyAssignmentReportRequest{Name: name})
+		switch {
+		case err != nil:
+			if st, ok := status.FromError(err); !ok || st.Code() != codes.NotFound {
+				return nil, fmt.Errorf("error running GetOSPolicyAssignmentReport: %s", utils.GetStatusFromError(err))
+			}
+			last = "no report yet"
+		case !report.GetUpdateTime().AsTime().After(after):
+			last = fmt.Sprintf("last report was from %s", report.GetUpdateTime().AsTime().Format(time.RFC3339))
+		default:
+			diff := compareCompliances(want, report.GetOsPolicyCompliances())
+			if diff == "" {
+				return report, nil
+			}
+			last = fmt.Sprintf("last report from %s did not have the expected OsPolicyCompliances (-want +got):\n%s", report.GetUpdateTime().AsTime().Format(time.RFC3339), diff)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timedout:
+			return nil, fmt.Errorf("timed out waiting for a report newer than %s, %s", after.Format(time.RFC3339), last)
+		case <-tick.C:
+		}
+	}
+}
+
+func runTest(ctx context.Context, testCase *junitxml.TestCase, testSetup *driftTestSetup, logger *log.Logger) {
+	computeClient, err := gcpclients.GetComputeClient()
+	if err != nil {
+		testCase.WriteFailure("Error getting compute client: %v", err)
+		return
+	}
+
+	var metadataItems []*computeApi.MetadataItems
+	metadataItems = append(metadataItems, testSetup.startup)
+	metadataItems = append(metadataItems, compute.BuildInstanceMetadataItem("enable-osconfig", "true"))
+	metadataItems = append(metadataItems, compute.BuildInstanceMetadataItem("osconfig-disabled-features", "guestpolicies,osinventory"))
+	testProjectConfig := testconfig.GetProject()
+	zone := testProjectConfig.AcquireZone()
+	defer testProjectConfig.ReleaseZone(zone)
+	// Remediation relies on the periodic re-enforcement, leave room for
+	// the install, the baseline run, the remediation and its report.
+	ctx, cncl := context.WithTimeout(ctx, 75*time.Minute)
+	defer cncl()
+	testCase.Logf("Creating instance %q with image %q", testSetup.instanceName, testSetup.image)
+	inst, err := utils.CreateComputeInstance(metadataItems, computeClient, testSetup.machineType, testSetup.image, testSetup.instanceName, testProjectConfig.TestProjectID, zone, testProjectConfig.ServiceAccountEmail, testProjectConfig.ServiceAccountScopes)
+	if err != nil {
+		testCase.WriteFailure("Error creating instance: %s", utils.GetStatusFromError(err))
+		return
+	}
+	defer inst.Cleanup()
+	defer inst.RecordSerialOutput(ctx, path.Join(*config.OutDir, testSuiteName), 1)
+
+	testCase.Logf("Waiting for agent install to complete")
+	if _, err := inst.WaitForGuestAttributes("osconfig_tests/install_done", 5*time.Second, 10*time.Minute); err != nil {
+		testCase.WriteFailure("Error waiting for osconfig agent install: %v", err)
+		return
+	}
+
+	client, err := gcpclients.GetOsConfigClientV1()
+	if err != nil {
+		testCase.WriteFailure("Error getting osconfig client: %v", err)
+		return
+	}
+
+	req := &osconfigpb.CreateOSPolicyAssignmentRequest{
+		Parent:               fmt.Sprintf("projects/%s/locations/%s", testProjectConfig.TestProjectID, zone),
+		OsPolicyAssignmentId: testSetup.instanceName,
+		OsPolicyAssignment:   testSetup.osPolicyAssignment,
+	}
+
+	testCase.Logf("Creating OSPolicyAssignment: %q", fmt.Sprintf("%s/%s", req.GetParent(), req.GetOsPolicyAssignmentId()))
+	ospa, err := createOSPolicyAssignment(ctx, client, req, testCase)
+	if err != nil {
+		testCase.WriteFailure("Error running createOSPolicyAssignment: %s", err)
+		return
+	}
+	defer cleanupOSPolicyAssignment(ctx, client, testCase, ospa.GetName())
+
+	reportName := fmt.Sprintf("projects/%s/locations/%s/instances/%d/osPolicyAssignments/%s/report", testProjectConfig.TestProjectID, zone, inst.Id, testSetup.instanceName)
+	if _, err := waitForReport(ctx, client, reportName, testSetup.wantCompliances, time.Time{}, 10*time.Minute); err != nil {
+		testCase.WriteFailure("Error waiting for baseline report: %v", err)
+		return
+	}
+
+	// The startup script waits for the baseline state, breaks it and then
+	// records when each resource is restored.
+	driftAt, err := guestTimestamp(inst, driftIntroduced, 10*time.Minute)
+	if err != nil {
+		testCase.WriteFailure("Error waiting for drift to be introduced: %v", err)
+		return
+	}
+	testCase.Logf("Drift introduced at %s", driftAt.Format(time.RFC3339))
+
+	// Every resource has to be restored by the same deadline, waiting the
+	// full timeout for each one would let a late one through. The extra
+	// minute covers the polling of the startup script and of this test.
+	deadline := driftAt.Add(testSetup.remediationTimeout + time.Minute)
+	for _, r := range driftedResources {
+		wait := time.Until(deadline)
+		if wait < time.Minute {
+			wait = time.Minute
+		}
+		restoredAt, err := guestTimestamp(inst, r.queryPath, wait)
+		if err != nil {
+			testCase.WriteFailure("Error waiting for %s to be remediated: %v", r.name, err)
+			return
+		}
+		latency := restoredAt.Sub(driftAt)
+		testCase.Logf("%s remediated after %s", r.name, latency)
+		if latency > testSetup.remediationTimeout {
+			testCase.WriteFailure("%s took %s to be remediated, want at most %s", r.name, latency, testSetup.remediationTimeout)
+			return
+		}
+	}
+
+	// A report from after the drift must show every resource being
+	// enforced again and end up compliant. The remediating run reports
+	// right after it restores the resources.
+	if _, err := waitForReport(ctx, client, reportName, testSetup.wantCompliances, driftAt, 10*time.Minute); err != nil {
+		testCase.WriteFailure("Error waiting for post remediation report: %v", err)
+		return
+	}
+}
+
+func testCase(ctx context.Context, testSetup *driftTestSetup, tests chan *junitxml.TestCase, wg *sync.WaitGroup, logger *log.Logger, regex *regexp.Regexp) {
+	defer wg.Done()
+
+	tc := junitxml.NewTestCase(testSuiteName, fmt.Sprintf("[%s] [%s]", testSetup.testName, testSetup.imageName))
+	if tc.FilterTestCase(regex) {
+		tc.Finish(tests)
+		return
+	}
+	// Unlike the OSPolicies suite failures are not rerun, a slow or missed
+	// remediation is exactly the regression this suite is meant to catch.
+	logger.Printf("Running TestCase %q", tc.Name)
+	runTest(ctx, tc, testSetup, logger)
+	tc.Finish(tests)
+	logger.Printf("TestCase %q finished in %fs", tc.Name, tc.Time)
+}
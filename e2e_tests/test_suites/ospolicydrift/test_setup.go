@@ -0,0 +1,325 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package ospolicydrift
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/compute"
+	"github.com/GoogleCloudPlatform/osconfig/e2e_tests/utils"
+	computeApi "google.golang.org/api/compute/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	osconfigpb "github.com/GoogleCloudPlatform/osconfig/e2e_tests/internal/google.golang.org/genproto/googleapis/cloud/osconfig/v1"
+)
+
+const (
+	driftIntroduced    = "osconfig_tests/drift_introduced"
+	fileRemediated     = "osconfig_tests/file_remediated"
+	packageRemediated  = "osconfig_tests/pkg_remediated"
+	repoRemediated     = "osconfig_tests/repo_remediated"
+	osconfigTestRepo   = "osconfig-agent-test-repository"
+	testPackage        = "osconfig-agent-test"
+	managedFilePath    = "/osconfig_drift_managed_file"
+	yumTestRepoBaseURL = "https://packages.cloud.google.com/yum/repos/osconfig-agent-test-repository"
+	aptTestRepoBaseURL = "http://packages.cloud.google.com/apt"
+	aptRaptureGpgKey   = "https://packages.cloud.google.com/apt/doc/apt-key.gpg"
+
+	// remediationTimeout bounds the time between the drift being introduced
+	// and the agent re-enforcing the policy. Re-enforcement happens on the
+	// periodic config run, so this has to cover one full interval plus the
+	// time it takes to reinstall the package.
+	remediationTimeout = 20 * time.Minute
+)
+
+var yumRaptureGpgKeys = []string{"https://packages.cloud.google.com/yum/doc/yum-key.gpg", "https://packages.cloud.google.com/yum/doc/rpm-package-key.gpg"}
+
+// driftedResources are the guest attributes the startup script sets once
+// each drifted resource has been restored.
+var driftedResources = []struct {
+	name      string
+	queryPath string
+}{
+	{"file", fileRemediated},
+	{"package", packageRemediated},
+	{"repository", repoRemediated},
+}
+
+type driftTestSetup struct {
+	image              string
+	imageName          string
+	instanceName       string
+	testName           string
+	machineType        string
+	osPolicyAssignment *osconfigpb.OSPolicyAssignment
+	startup            *computeApi.MetadataItems
+	remediationTimeout time.Duration
+	wantCompliances    []*osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance
+}
+
+// driftCommands are the shell snippets used to check and break the state of
+// each managed resource for a package manager.
+type driftCommands struct {
+	install         string
+	repoGlob        string
+	repoEnabled     string
+	disableRepo     string
+	pkgInstalled    string
+	removePkg       string
+	repository      *osconfigpb.OSPolicy_Resource_RepositoryResource
+	packageResource *osconfigpb.OSPolicy_Resource_PackageResource
+}
+
+func aptDriftCommands(image string) *driftCommands {
+	glob := "/etc/apt/sources.list.d/osconfig_managed_*.list"
+	return &driftCommands{
+		install:      utils.InstallOSConfigDeb(image),
+		repoGlob:     glob,
+		repoEnabled:  fmt.Sprintf("grep -qs '^deb ' %s", glob),
+		disableRepo:  fmt.Sprintf("sed -i 's/^deb /# deb /' %s", glob),
+		pkgInstalled: fmt.Sprintf("dpkg-query -W -f='${Status}' %s 2>/dev/null | grep -q 'install ok installed'", testPackage),
+		removePkg:    fmt.Sprintf("apt-get -y remove %s", testPackage),
+		repository: &osconfigpb.OSPolicy_Resource_RepositoryResource{
+			Repository: &osconfigpb.OSPolicy_Resource_RepositoryResource_Apt{
+				Apt: &osconfigpb.OSPolicy_Resource_RepositoryResource_AptRepository{
+					ArchiveType:  osconfigpb.OSPolicy_Resource_RepositoryResource_AptRepository_DEB,
+					Uri:          aptTestRepoBaseURL,
+					Distribution: osconfigTestRepo,
+					Components:   []string{"main"},
+					GpgKey:       aptRaptureGpgKey,
+				},
+			},
+		},
+		packageResource: &osconfigpb.OSPolicy_Resource_PackageResource{
+			DesiredState: osconfigpb.OSPolicy_Resource_PackageResource_INSTALLED,
+			SystemPackage: &osconfigpb.OSPolicy_Resource_PackageResource_Apt{
+				Apt: &osconfigpb.OSPolicy_Resource_PackageResource_APT{Name: testPackage},
+			},
+		},
+	}
+}
+
+func yumDriftCommands(image string) *driftCommands {
+	glob := "/etc/yum.repos.d/osconfig_managed_*.repo"
+	return &driftCommands{
+		install:      utils.InstallOSConfigEL(image),
+		repoGlob:     glob,
+		repoEnabled:  fmt.Sprintf("! grep -qs '^enabled=0' %s", glob),
+		disableRepo:  fmt.Sprintf("sed -i -e '/^enabled=/d' -e '$a enabled=0' %s", glob),
+		pkgInstalled: fmt.Sprintf("rpm -q %s > /dev/null", testPackage),
+		removePkg:    fmt.Sprintf("yum -y remove %s", testPackage),
+		repository: &osconfigpb.OSPolicy_Resource_RepositoryResource{
+			Repository: &osconfigpb.OSPolicy_Resource_RepositoryResource_Yum{
+				Yum: &osconfigpb.OSPolicy_Resource_RepositoryResource_YumRepository{
+					Id:          osconfigTestRepo,
+					DisplayName: "Google OSConfig Agent Test Repository",
+					BaseUrl:     yumTestRepoBaseURL,
+					GpgKeys:     yumRaptureGpgKeys,
+				},
+			},
+		},
+		packageResource: &osconfigpb.OSPolicy_Resource_PackageResource{
+			DesiredState: osconfigpb.OSPolicy_Resource_PackageResource_INSTALLED,
+			SystemPackage: &osconfigpb.OSPolicy_Resource_PackageResource_Yum{
+				Yum: &osconfigpb.OSPolicy_Resource_PackageResource_YUM{Name: testPackage},
+			},
+		},
+	}
+}
+
+func zypperDriftCommands(image string) *driftCommands {
+	glob := "/etc/zypp/repos.d/osconfig_managed_*.repo"
+	return &driftCommands{
+		install:      "sleep 10\n# Update zypper since there were older versions with bugs.\nzypper -n install zypper\n" + utils.InstallOSConfigSUSE(),
+		repoGlob:     glob,
+		repoEnabled:  fmt.Sprintf("! grep -qs '^enabled=0' %s", glob),
+		disableRepo:  fmt.Sprintf("sed -i -e '/^enabled=/d' -e '$a enabled=0' %s", glob),
+		pkgInstalled: fmt.Sprintf("rpm -q %s > /dev/null", testPackage),
+		removePkg:    fmt.Sprintf("zypper -n remove %s", testPackage),
+		repository: &osconfigpb.OSPolicy_Resource_RepositoryResource{
+			Repository: &osconfigpb.OSPolicy_Resource_RepositoryResource_Zypper{
+				Zypper: &osconfigpb.OSPolicy_Resource_RepositoryResource_ZypperRepository{
+					Id:          osconfigTestRepo,
+					DisplayName: "Google OSConfig Agent Test Repository",
+					BaseUrl:     yumTestRepoBaseURL,
+					GpgKeys:     yumRaptureGpgKeys,
+				},
+			},
+		},
+		packageResource: &osconfigpb.OSPolicy_Resource_PackageResource{
+			DesiredState: osconfigpb.OSPolicy_Resource_PackageResource_INSTALLED,
+			SystemPackage: &osconfigpb.OSPolicy_Resource_PackageResource_Zypper_{
+				Zypper: &osconfigpb.OSPolicy_Resource_PackageResource_Zypper{Name: testPackage},
+			},
+		},
+	}
+}
+
+// getStartupScriptDrift waits for the policy to be enforced, then deletes
+// the managed file, removes the managed package and disables the managed
+// repository. It records when the drift was introduced and when each
+// resource was restored as unix timestamps in guest attributes.
+func getStartupScriptDrift(cmds *driftCommands) *computeApi.MetadataItems {
+	ss := `set -x
+%[1]s
+put_attr() {
+  curl -X PUT --data "$2" http://metadata.google.internal/computeMetadata/v1/instance/guest-attributes/$1 -H "Metadata-Flavor: Google"
+}
+file_ok() { [[ -f %[2]s ]]; }
+pkg_ok() { %[3]s; }
+repo_ok() { compgen -G "%[4]s" > /dev/null && %[5]s; }
+
+# Wait for the baseline enforcement.
+until file_ok && pkg_ok && repo_ok; do
+  sleep 10
+done
+
+# Introduce drift on every managed resource.
+rm -f %[2]s
+%[6]s
+%[7]s
+put_attr %[8]s $(date +%%s)
+
+file_done=0
+pkg_done=0
+repo_done=0
+until [[ $file_done$pkg_done$repo_done == 111 ]]; do
+  if [[ $file_done == 0 ]] && file_ok; then
+    file_done=1
+    put_attr %[9]s $(date +%%s)
+  fi
+  if [[ $pkg_done == 0 ]] && pkg_ok; then
+    pkg_done=1
+    put_attr %[10]s $(date +%%s)
+  fi
+  if [[ $repo_done == 0 ]] && repo_ok; then
+    repo_done=1
+    put_attr %[11]s $(date +%%s)
+  fi
+  sleep 5
+done`
+	ss = fmt.Sprintf(ss, cmds.install, managedFilePath, cmds.pkgInstalled, cmds.repoGlob, cmds.repoEnabled, cmds.removePkg, cmds.disableRepo, driftIntroduced, fileRemediated, packageRemediated, repoRemediated)
+	return compute.BuildInstanceMetadataItem("startup-script", ss)
+}
+
+func wantResourceCompliance(id string) *osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_OSPolicyResourceCompliance {
+	return &osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_OSPolicyResourceCompliance{
+		OsPolicyResourceId: id,
+		ConfigSteps: []*osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_OSPolicyResourceCompliance_OSPolicyResourceConfigStep{
+			{
+				Type: osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_OSPolicyResourceCompliance_OSPolicyResourceConfigStep_VALIDATION,
+			},
+			{
+				Type: osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_OSPolicyResourceCompliance_OSPolicyResourceConfigStep_DESIRED_STATE_CHECK,
+			},
+			{
+				Type: osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_OSPolicyResourceCompliance_OSPolicyResourceConfigStep_DESIRED_STATE_ENFORCEMENT,
+			},
+			{
+				Type: osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_OSPolicyResourceCompliance_OSPolicyResourceConfigStep_DESIRED_STATE_CHECK_POST_ENFORCEMENT,
+			},
+		},
+		ComplianceState: osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_OSPolicyResourceCompliance_COMPLIANT,
+	}
+}
+
+func buildDriftTestSetup(name, image, pkgManager, key string, cmds *driftCommands) *driftTestSetup {
+	testName := fmt.Sprintf("Drift %s", pkgManager)
+	instanceName := fmt.Sprintf("%s-drift-%s-%s-%s", path.Base(name), pkgManager, key, utils.RandString(3))
+	policyID := "drift-remediation"
+	ospa := &osconfigpb.OSPolicyAssignment{
+		InstanceFilter: &osconfigpb.OSPolicyAssignment_InstanceFilter{
+			InclusionLabels: []*osconfigpb.OSPolicyAssignment_LabelSet{{
+				Labels: map[string]string{"name": instanceName}},
+			},
+		},
+		Rollout: &osconfigpb.OSPolicyAssignment_Rollout{
+			DisruptionBudget: &osconfigpb.FixedOrPercent{Mode: &osconfigpb.FixedOrPercent_Percent{Percent: 100}},
+			MinWaitDuration:  &durationpb.Duration{Seconds: 0},
+		},
+		OsPolicies: []*osconfigpb.OSPolicy{
+			{
+				Id:   policyID,
+				Mode: osconfigpb.OSPolicy_ENFORCEMENT,
+				ResourceGroups: []*osconfigpb.OSPolicy_ResourceGroup{
+					{
+						Resources: []*osconfigpb.OSPolicy_Resource{
+							{
+								Id:           "managed-repo",
+								ResourceType: &osconfigpb.OSPolicy_Resource_Repository{Repository: cmds.repository},
+							},
+							{
+								Id:           "managed-package",
+								ResourceType: &osconfigpb.OSPolicy_Resource_Pkg{Pkg: cmds.packageResource},
+							},
+							{
+								Id: "managed-file",
+								ResourceType: &osconfigpb.OSPolicy_Resource_File_{
+									File: &osconfigpb.OSPolicy_Resource_FileResource{
+										State:  osconfigpb.OSPolicy_Resource_FileResource_PRESENT,
+										Path:   managedFilePath,
+										Source: &osconfigpb.OSPolicy_Resource_FileResource_Content{Content: "managed by osconfig"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	// Every resource is out of compliance both on the first run and on the
+	// run following the drift, so both reports include an enforcement step.
+	wantCompliances := []*osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance{
+		{
+			OsPolicyId:      policyID,
+			ComplianceState: osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_COMPLIANT,
+			OsPolicyResourceCompliances: []*osconfigpb.OSPolicyAssignmentReport_OSPolicyCompliance_OSPolicyResourceCompliance{
+				wantResourceCompliance("managed-repo"),
+				wantResourceCompliance("managed-package"),
+				wantResourceCompliance("managed-file"),
+			},
+		},
+	}
+	return &driftTestSetup{
+		image:              image,
+		imageName:          name,
+		instanceName:       instanceName,
+		testName:           testName,
+		machineType:        "e2-medium",
+		osPolicyAssignment: ospa,
+		startup:            getStartupScriptDrift(cmds),
+		remediationTimeout: remediationTimeout,
+		wantCompliances:    wantCompliances,
+	}
+}
+
+func generateAllTestSetup() []*driftTestSetup {
+	key := utils.RandString(3)
+
+	var setups []*driftTestSetup
+	for name, image := range utils.HeadAptImages {
+		setups = append(setups, buildDriftTestSetup(name, image, "apt", key, aptDriftCommands(image)))
+	}
+	for name, image := range utils.HeadELImages {
+		setups = append(setups, buildDriftTestSetup(name, image, "yum", key, yumDriftCommands(image)))
+	}
+	for name, image := range utils.HeadSUSEImages {
+		setups = append(setups, buildDriftTestSetup(name, image, "zypper", key, zypperDriftCommands(image)))
+	}
+	return setups
+}
@@ -0,0 +1,77 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/GoogleCloudPlatform/osconfig/policies"
+)
+
+// Exit codes for the one-shot modes (inventory, policies, simulate,
+// export-profile, guestattribute) so that wrappers and cron jobs can branch on the outcome:
+//
+//	0 the run succeeded
+//	1 the run failed, for policies every step failed
+//	2 policies are not compliant (simulate)
+//	3 the run partially failed, some steps succeeded
+//	4 the OS Config service could not be reached
+//	5 another agent process holds the agent lock
+//
+// Not every mode can return every code.
+const (
+	exitSuccess        = 0
+	exitFailure        = 1
+	exitNonCompliant   = 2
+	exitPartialFailure = 3
+	exitConnectivity   = 4
+	exitLockHeld       = 5
+)
+
+// errLockHeld is returned by obtainLock if another agent holds the lock.
+var errLockHeld = errors.New("OSConfig agent lock already held, is the agent already running?")
+
+// connectivityError marks errors caused by being unable to reach the
+// OS Config service.
+type connectivityError struct {
+	err error
+}
+
+func (e *connectivityError) Error() string { return e.err.Error() }
+
+func (e *connectivityError) Unwrap() error { return e.err }
+
+// exitStatus is the exit code main exits with once run returns.
+var exitStatus = exitSuccess
+
+// exitCode maps err to one of the documented exit codes.
+func exitCode(err error) int {
+	var pErr *policies.PartialFailureError
+	var cErr *connectivityError
+	switch {
+	case err == nil:
+		return exitSuccess
+	case errors.Is(err, errSimulationChanges):
+		return exitNonCompliant
+	case errors.As(err, &pErr):
+		return exitPartialFailure
+	case errors.As(err, &cErr), errors.Is(err, policies.ErrLookupFailed):
+		return exitConnectivity
+	case errors.Is(err, errLockHeld):
+		return exitLockHeld
+	default:
+		return exitFailure
+	}
+}
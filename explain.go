@@ -0,0 +1,75 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentendpoint"
+	"github.com/GoogleCloudPlatform/osconfig/managedfiles"
+)
+
+const explainUsage = "usage: explain <path|package>"
+
+type explanation struct {
+	Target    string                       `json:"target"`
+	ManagedBy []*agentendpoint.Explanation `json:"managedBy"`
+	// AgentWritten is when the agent last wrote the file, this includes files
+	// written for guest policies which are not listed in ManagedBy.
+	AgentWritten *time.Time `json:"agentWritten,omitempty"`
+}
+
+// explain writes the OS policy resources that manage the file or package
+// named by args to w as JSON, as recorded by the last apply.
+func explain(w io.Writer, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New(explainUsage)
+	}
+	target := args[0]
+	isPath := filepath.IsAbs(target) || strings.ContainsRune(target, '/') || strings.ContainsRune(target, filepath.Separator)
+	if isPath {
+		abs, err := filepath.Abs(target)
+		if err != nil {
+			return err
+		}
+		target = abs
+	}
+
+	managedBy, err := agentendpoint.Explain(target)
+	if err != nil {
+		return err
+	}
+	x := &explanation{Target: target, ManagedBy: managedBy}
+	if x.ManagedBy == nil {
+		x.ManagedBy = []*agentendpoint.Explanation{}
+	}
+	if isPath {
+		written, ok, err := managedfiles.Written(target)
+		if err != nil {
+			return err
+		}
+		if ok {
+			x.AgentWritten = &written
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(x)
+}
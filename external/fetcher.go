@@ -48,5 +48,27 @@ func FetchRemoteObjectHTTP(ctx context.Context, client *http.Client, url string)
 		return nil, fmt.Errorf("got http status %d when attempting to download artifact", resp.StatusCode)
 	}
 
-	return resp.Body, nil
+	return &remoteObject{ReadCloser: resp.Body, size: resp.ContentLength}, nil
+}
+
+// remoteObject is a fetched object that reports its size like a GCS reader.
+type remoteObject struct {
+	io.ReadCloser
+	size int64
+}
+
+// Remain returns the size of the object, or -1 if it is unknown.
+func (o *remoteObject) Remain() int64 {
+	return o.size
+}
+
+// UploadGCSObject uploads the contents of r to a GCS bucket.
+func UploadGCSObject(ctx context.Context, client *storage.Client, bucket, object string, r io.Reader) error {
+	clog.Debugf(ctx, "Uploading GCS object: '%s/%s'", bucket, object)
+	w := client.Bucket(bucket).Object(object).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
 }
@@ -0,0 +1,340 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package faultinject injects faults into the agent's gRPC calls so its
+// retry, resume and reporting behavior can be exercised against a
+// misbehaving service.
+package faultinject
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+// Kind is the kind of fault to inject.
+type Kind int
+
+const (
+	// Error fails the call with Fault.Code.
+	Error Kind = iota
+	// Delay holds the call for Fault.Delay before sending it.
+	Delay
+	// Reset fails a stream on its next receive, as if the connection was
+	// dropped. For unary calls it is the same as an Unavailable Error.
+	Reset
+	// Malform corrupts the response, see Malform.
+	Malform
+)
+
+var kinds = map[string]Kind{
+	"error":   Error,
+	"delay":   Delay,
+	"reset":   Reset,
+	"malform": Malform,
+}
+
+func (k Kind) String() string {
+	for s, v := range kinds {
+		if v == k {
+			return s
+		}
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Fault describes a fault and the calls it is injected into.
+type Fault struct {
+	// Method is the gRPC method name, e.g. "StartNextTask", an empty
+	// Method matches every call.
+	Method string
+	Kind   Kind
+	// Code is the status code for Error faults, Unavailable if unset.
+	Code  codes.Code
+	Delay time.Duration
+	// Count limits how many times the fault is injected, 0 means
+	// every matching call.
+	Count int
+	// Probability is the chance a matching call is faulted, 0 means
+	// every matching call.
+	Probability float64
+}
+
+func (f *Fault) matches(method string) bool {
+	return f.Method == "" || method == f.Method || strings.HasSuffix(method, "/"+f.Method)
+}
+
+// Injector injects Faults into gRPC client calls.
+type Injector struct {
+	mu       sync.Mutex
+	faults   []*Fault
+	used     map[*Fault]int
+	injected map[string]int
+	rnd      *rand.Rand
+}
+
+// New returns an Injector for the given faults.
+func New(faults ...Fault) *Injector {
+	i := &Injector{
+		used:     map[*Fault]int{},
+		injected: map[string]int{},
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, f := range faults {
+		f := f
+		i.faults = append(i.faults, &f)
+	}
+	return i
+}
+
+// Injected returns how many faults were injected into calls of method.
+func (i *Injector) Injected(method string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var n int
+	for m, c := range i.injected {
+		if m == method || strings.HasSuffix(m, "/"+method) {
+			n += c
+		}
+	}
+	return n
+}
+
+// next returns the fault to inject into this call of method, if any.
+func (i *Injector) next(method string) *Fault {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, f := range i.faults {
+		if !f.matches(method) {
+			continue
+		}
+		if f.Count > 0 && i.used[f] >= f.Count {
+			continue
+		}
+		if f.Probability > 0 && i.rnd.Float64() >= f.Probability {
+			continue
+		}
+		i.used[f]++
+		i.injected[method]++
+		return f
+	}
+	return nil
+}
+
+func (f *Fault) err(method string) error {
+	code := f.Code
+	if code == codes.OK || f.Kind == Reset {
+		code = codes.Unavailable
+	}
+	return status.Errorf(code, "fault injected into %s: %s", method, f.Kind)
+}
+
+// delay waits for d or until ctx is done.
+func delay(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// UnaryClientInterceptor injects faults into unary calls.
+func (i *Injector) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		f := i.next(method)
+		if f == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		switch f.Kind {
+		case Delay:
+			if err := delay(ctx, f.Delay); err != nil {
+				return err
+			}
+		case Malform:
+			if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+				return err
+			}
+			malform(reply)
+			return nil
+		default:
+			return f.err(method)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor injects faults into streaming calls. Error
+// faults fail the call, Delay faults hold it, Reset faults fail the first
+// receive and Malform faults corrupt every received message.
+func (i *Injector) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		f := i.next(method)
+		if f == nil {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		switch f.Kind {
+		case Error:
+			return nil, f.err(method)
+		case Delay:
+			if err := delay(ctx, f.Delay); err != nil {
+				return nil, err
+			}
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &faultyStream{ClientStream: s, fault: f, method: method}, nil
+	}
+}
+
+// DialOptions returns the dial options that install the interceptors.
+func (i *Injector) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(i.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(i.StreamClientInterceptor()),
+	}
+}
+
+type faultyStream struct {
+	grpc.ClientStream
+	fault  *Fault
+	method string
+}
+
+func (s *faultyStream) RecvMsg(m interface{}) error {
+	if s.fault.Kind == Reset {
+		return s.fault.err(s.method)
+	}
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		return err
+	}
+	malform(m)
+	return nil
+}
+
+// unknownTaskType is not a TaskType the agent knows how to run.
+const unknownTaskType = agentendpointpb.TaskType(1000)
+
+// malform corrupts a response the way a buggy or newer service might, a
+// task has its type replaced by one the agent does not know and its
+// details dropped. Other messages are left as is.
+func malform(m interface{}) {
+	switch r := m.(type) {
+	case *agentendpointpb.StartNextTaskResponse:
+		if r.GetTask() != nil {
+			r.Task.TaskType = unknownTaskType
+			r.Task.TaskDetails = nil
+		}
+	case *agentendpointpb.ReportTaskProgressResponse:
+		r.TaskDirective = agentendpointpb.TaskDirective(1000)
+	}
+}
+
+// Parse parses a fault spec, a semicolon separated list of
+// method=kind[:arg][@probability] entries. The arg is a count for error,
+// reset and malform faults, optionally prefixed by a status code name as
+// in "error:unavailable:2", and a duration for delay faults. The method may
+// be "*" to match every call, for example:
+//
+//	StartNextTask=error:unavailable:2;ReportTaskProgress=delay:5s@0.5;ReceiveTaskNotification=reset:1
+func Parse(spec string) ([]Fault, error) {
+	var faults []Fault
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		method, rest, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("fault %q is not in method=kind form", entry)
+		}
+		f := Fault{Method: strings.TrimSpace(method)}
+		if f.Method == "*" {
+			f.Method = ""
+		}
+		rest, p, ok := strings.Cut(rest, "@")
+		if ok {
+			prob, err := strconv.ParseFloat(p, 64)
+			if err != nil || prob <= 0 || prob > 1 {
+				return nil, fmt.Errorf("fault %q has invalid probability %q", entry, p)
+			}
+			f.Probability = prob
+		}
+		parts := strings.Split(rest, ":")
+		kind, ok := kinds[strings.ToLower(parts[0])]
+		if !ok {
+			return nil, fmt.Errorf("fault %q has unknown kind %q", method, parts[0])
+		}
+		f.Kind = kind
+		args := parts[1:]
+		if kind == Delay {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("delay fault for %q needs a duration", method)
+			}
+			d, err := time.ParseDuration(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("delay fault for %q: %v", method, err)
+			}
+			f.Delay = d
+			faults = append(faults, f)
+			continue
+		}
+		if kind == Error && len(args) > 0 {
+			if _, err := strconv.Atoi(args[0]); err != nil {
+				code, ok := codeNames[strings.ToLower(strings.ReplaceAll(args[0], "_", ""))]
+				if !ok {
+					return nil, fmt.Errorf("error fault for %q has unknown code %q", method, args[0])
+				}
+				f.Code = code
+				args = args[1:]
+			}
+		}
+		switch len(args) {
+		case 0:
+		case 1:
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("fault for %q has invalid count %q", method, args[0])
+			}
+			f.Count = n
+		default:
+			return nil, fmt.Errorf("fault %q has too many arguments", method)
+		}
+		faults = append(faults, f)
+	}
+	return faults, nil
+}
+
+var codeNames = func() map[string]codes.Code {
+	m := map[string]codes.Code{}
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		m[strings.ToLower(strings.ReplaceAll(c.String(), "_", ""))] = c
+	}
+	return m
+}()
@@ -0,0 +1,168 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package faultinject
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []Fault
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"StartNextTask=error", []Fault{{Method: "StartNextTask", Kind: Error}}, false},
+		{"StartNextTask=error:unavailable:2", []Fault{{Method: "StartNextTask", Kind: Error, Code: codes.Unavailable, Count: 2}}, false},
+		{"StartNextTask=error:DEADLINE_EXCEEDED", []Fault{{Method: "StartNextTask", Kind: Error, Code: codes.DeadlineExceeded}}, false},
+		{"StartNextTask=error:3", []Fault{{Method: "StartNextTask", Kind: Error, Count: 3}}, false},
+		{
+			"ReportTaskProgress=delay:5s@0.5; ReceiveTaskNotification=reset:1;*=malform",
+			[]Fault{
+				{Method: "ReportTaskProgress", Kind: Delay, Delay: 5 * time.Second, Probability: 0.5},
+				{Method: "ReceiveTaskNotification", Kind: Reset, Count: 1},
+				{Kind: Malform},
+			},
+			false,
+		},
+		{"StartNextTask", nil, true},
+		{"StartNextTask=explode", nil, true},
+		{"StartNextTask=delay", nil, true},
+		{"StartNextTask=delay:soon", nil, true},
+		{"StartNextTask=error:bogus", nil, true},
+		{"StartNextTask=reset:-1", nil, true},
+		{"StartNextTask=reset:1:2", nil, true},
+		{"StartNextTask=reset@2", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := Parse(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	const method = "/google.cloud.osconfig.agentendpoint.v1.AgentEndpointService/StartNextTask"
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		reply.(*agentendpointpb.StartNextTaskResponse).Task = &agentendpointpb.Task{
+			TaskType:    agentendpointpb.TaskType_EXEC_STEP_TASK,
+			TaskDetails: &agentendpointpb.Task_ExecStepTask{},
+		}
+		return nil
+	}
+
+	i := New(
+		Fault{Method: "StartNextTask", Kind: Error, Code: codes.Internal, Count: 1},
+		Fault{Method: "StartNextTask", Kind: Malform, Count: 1},
+		Fault{Method: "ReportTaskProgress", Kind: Error},
+	)
+	intercept := i.UnaryClientInterceptor()
+
+	resp := &agentendpointpb.StartNextTaskResponse{}
+	if err := intercept(context.Background(), method, nil, resp, nil, invoker); status.Code(err) != codes.Internal {
+		t.Errorf("first call error = %v, want code %s", err, codes.Internal)
+	}
+	if calls != 0 {
+		t.Errorf("faulted call reached the server")
+	}
+
+	if err := intercept(context.Background(), method, nil, resp, nil, invoker); err != nil {
+		t.Fatalf("second call error: %v", err)
+	}
+	if resp.GetTask().GetTaskType() != unknownTaskType || resp.GetTask().GetTaskDetails() != nil {
+		t.Errorf("second call task = %v, want a malformed task", resp.GetTask())
+	}
+
+	resp = &agentendpointpb.StartNextTaskResponse{}
+	if err := intercept(context.Background(), method, nil, resp, nil, invoker); err != nil {
+		t.Fatalf("third call error: %v", err)
+	}
+	if resp.GetTask().GetTaskType() != agentendpointpb.TaskType_EXEC_STEP_TASK {
+		t.Errorf("third call task = %v, want it untouched", resp.GetTask())
+	}
+
+	if got := i.Injected("StartNextTask"); got != 2 {
+		t.Errorf("Injected(StartNextTask) = %d, want 2", got)
+	}
+}
+
+func TestUnaryClientInterceptorDelay(t *testing.T) {
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		return nil
+	}
+	intercept := New(Fault{Kind: Delay, Delay: time.Minute}).UnaryClientInterceptor()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := intercept(ctx, "/Service/Method", nil, nil, nil, invoker); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("error = %v, want code %s", err, codes.DeadlineExceeded)
+	}
+}
+
+type fakeStream struct {
+	grpc.ClientStream
+	recvs int
+}
+
+func (s *fakeStream) RecvMsg(interface{}) error {
+	s.recvs++
+	return nil
+}
+
+func TestStreamClientInterceptorReset(t *testing.T) {
+	stream := &fakeStream{}
+	streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+		return stream, nil
+	}
+	intercept := New(Fault{Method: "ReceiveTaskNotification", Kind: Reset, Count: 1}).StreamClientInterceptor()
+
+	s, err := intercept(context.Background(), nil, nil, "/Service/ReceiveTaskNotification", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecvMsg(nil); status.Code(err) != codes.Unavailable {
+		t.Errorf("RecvMsg() error = %v, want code %s", err, codes.Unavailable)
+	}
+
+	// The fault is used up, the next stream is not reset.
+	s, err = intercept(context.Background(), nil, nil, "/Service/ReceiveTaskNotification", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecvMsg(nil); err != nil {
+		t.Errorf("RecvMsg() error: %v", err)
+	}
+	if stream.recvs != 1 {
+		t.Errorf("stream received %d times, want 1", stream.recvs)
+	}
+}
@@ -0,0 +1,88 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/osconfig/hardening"
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
+	"github.com/GoogleCloudPlatform/osconfig/privhelper"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+const generateProfileUsage = "usage: generate-profile apparmor [complain] | seccomp | systemd | wdac | sudoers USER"
+
+// generateProfile writes a confinement profile for the agent to w. The
+// AppArmor profile and WDAC hints include the helper commands observed
+// while collecting inventory, in addition to the commands the agent may run
+// for enforcement. The sudoers rule lets the agent run as USER with the
+// privilege_helper flag.
+func generateProfile(ctx context.Context, w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New(generateProfileUsage)
+	}
+	kind := args[0]
+	switch {
+	case kind == "sudoers" && len(args) == 2:
+		o := hardening.Default()
+		if exe, err := os.Executable(); err == nil {
+			o.Agent = exe
+		}
+		_, err := io.WriteString(w, privhelper.Sudoers(args[1], o.Agent))
+		return err
+	case kind == "systemd" && len(args) == 1:
+		_, err := io.WriteString(w, hardening.SystemdDropIn())
+		return err
+	case kind == "seccomp" && len(args) == 1:
+		data, err := hardening.Seccomp()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case kind == "apparmor" && (len(args) == 1 || len(args) == 2 && args[1] == "complain"),
+		kind == "wdac" && len(args) == 1:
+	default:
+		return errors.New(generateProfileUsage)
+	}
+
+	o := hardening.Default()
+	if exe, err := os.Executable(); err == nil {
+		o.Agent = exe
+	}
+	var mx sync.Mutex
+	var observed []string
+	util.SetCommandRecorder(func(path string) {
+		mx.Lock()
+		defer mx.Unlock()
+		observed = append(observed, path)
+	})
+	inventory.Collect(ctx)
+	util.SetCommandRecorder(nil)
+	o.Add(observed...)
+
+	if kind == "wdac" {
+		_, err := io.WriteString(w, hardening.WDACHints(o))
+		return err
+	}
+	_, err := io.WriteString(w, hardening.AppArmor(o, len(args) == 2))
+	return err
+}
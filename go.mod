@@ -12,11 +12,13 @@ require (
 	github.com/go-ole/go-ole v1.2.6
 	github.com/golang/mock v1.6.0
 	github.com/google/go-cmp v0.6.0
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
 	github.com/ulikunitz/xz v0.5.11
 	golang.org/x/crypto v0.22.0
 	golang.org/x/oauth2 v0.7.0
 	golang.org/x/sys v0.19.0
+	golang.org/x/text v0.14.0
 	google.golang.org/api v0.114.0
 	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1
 	google.golang.org/grpc v1.56.3
@@ -42,7 +44,6 @@ require (
 	go.opencensus.io v0.24.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 )
@@ -0,0 +1,59 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/attributes"
+)
+
+const guestAttributeUsage = "usage: guestattribute set <namespace/key> <value> | wait <namespace/key> [timeout]"
+
+// guestAttribute sets or waits on a guest attribute, this gives scripts a
+// retrying implementation instead of hand rolled curl loops.
+func guestAttribute(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errors.New(guestAttributeUsage)
+	}
+	switch args[0] {
+	case "set":
+		if len(args) != 3 {
+			return errors.New(guestAttributeUsage)
+		}
+		return attributes.SetAttribute(ctx, args[1], args[2], 5*time.Minute)
+	case "wait":
+		if len(args) == 3 {
+			timeout, err := time.ParseDuration(args[2])
+			if err != nil {
+				return fmt.Errorf("invalid timeout %q: %v", args[2], err)
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		v, err := attributes.WaitForAttribute(ctx, args[1], 5*time.Second)
+		if err != nil {
+			return err
+		}
+		fmt.Println(v)
+		return nil
+	default:
+		return errors.New(guestAttributeUsage)
+	}
+}
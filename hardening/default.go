@@ -0,0 +1,70 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package hardening
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// linuxCommands are the non package manager commands the agent may run on
+// Linux, for exec steps, reboots and the privilege helper.
+var linuxCommands = []string{"/bin/sh", "/bin/systemctl", "/bin/reboot", "/bin/shutdown", "/usr/bin/sudo"}
+
+// Default returns what the agent needs access to on this OS, without
+// observing a run.
+func Default() *Observation {
+	if runtime.GOOS == "windows" {
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		o := &Observation{
+			Agent:    filepath.Join(os.Getenv("ProgramFiles"), `Google\OSConfig\google_osconfig_agent.exe`),
+			ExecDirs: []string{agentconfig.InventoryPluginDir(), os.TempDir()},
+		}
+		o.Add(packages.Binaries()...)
+		o.Add(
+			filepath.Join(root, `System32\WindowsPowerShell\v1.0\PowerShell.exe`),
+			filepath.Join(root, `System32\cmd.exe`),
+			filepath.Join(root, `System32\msiexec.exe`),
+			filepath.Join(root, `System32\wusa.exe`),
+			filepath.Join(root, `System32\dism.exe`),
+		)
+		return o
+	}
+
+	o := &Observation{
+		Agent:    "/usr/bin/google_osconfig_agent",
+		ExecDirs: []string{agentconfig.InventoryPluginDir(), "/tmp"},
+		WriteDirs: []string{
+			agentconfig.CacheDir(),
+			// The software recipe database.
+			"/var/lib/google",
+			agentconfig.AptRepoDir(),
+			agentconfig.YumRepoDir(),
+			agentconfig.ZypperRepoDir(),
+			"/tmp",
+		},
+		WriteFiles: []string{"/run/lock/osconfig_agent.lock"},
+	}
+	o.Add(linuxCommands...)
+	o.Add(packages.Binaries()...)
+	return o
+}
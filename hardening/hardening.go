@@ -0,0 +1,209 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package hardening generates confinement profiles for the agent: an
+// AppArmor profile and a seccomp filter for Linux, and WDAC file rule hints
+// for Windows. Profiles are built from the helper commands the agent was
+// observed running plus the commands it may run for enforcement.
+package hardening
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProfileName is the name of the AppArmor profile.
+const ProfileName = "google_osconfig_agent"
+
+// Observation is what the agent needs access to.
+type Observation struct {
+	// Agent is the path of the agent executable.
+	Agent string
+	// Commands are the helper commands the agent runs.
+	Commands []string
+	// ExecDirs are directories holding executables the agent runs, such as
+	// inventory plugins and downloaded exec step scripts.
+	ExecDirs []string
+	// WriteDirs are directories the agent writes to.
+	WriteDirs []string
+	// WriteFiles are individual files the agent writes to.
+	WriteFiles []string
+}
+
+// Add adds commands to o, keeping them sorted and unique.
+func (o *Observation) Add(commands ...string) {
+	seen := map[string]bool{}
+	var all []string
+	for _, c := range append(o.Commands, commands...) {
+		if c == "" || seen[c] {
+			continue
+		}
+		seen[c] = true
+		all = append(all, c)
+	}
+	sort.Strings(all)
+	o.Commands = all
+}
+
+// appArmorCapabilities are the capabilities the agent uses for managing
+// files, packages and rebooting.
+var appArmorCapabilities = []string{
+	"chown",
+	"dac_override",
+	"dac_read_search",
+	"fowner",
+	"fsetid",
+	"kill",
+	"setgid",
+	"setuid",
+	"sys_boot",
+}
+
+// AppArmor returns an AppArmor profile for o. Helper commands are allowed
+// to run under their own profile or unconfined, package managers and
+// package scripts need access to the whole system. In complain mode policy
+// violations are logged but not denied.
+func AppArmor(o *Observation, complain bool) string {
+	var b strings.Builder
+	b.WriteString("# AppArmor profile for the OS Config agent.\n")
+	b.WriteString("# Generated by `google_osconfig_agent generate-profile apparmor`, do not edit.\n")
+	b.WriteString("# Local additions, such as paths written by OS policy file resources,\n")
+	b.WriteString("# belong in /etc/apparmor.d/local/" + ProfileName + ".\n\n")
+	b.WriteString("#include <tunables/global>\n\n")
+
+	flags := "attach_disconnected"
+	if complain {
+		flags += ",complain"
+	}
+	fmt.Fprintf(&b, "profile %s %s flags=(%s) {\n", ProfileName, o.Agent, flags)
+	b.WriteString("  #include <abstractions/base>\n")
+	b.WriteString("  #include <abstractions/nameservice>\n")
+	b.WriteString("  #include <abstractions/openssl>\n")
+	b.WriteString("  #include <abstractions/ssl_certs>\n\n")
+
+	for _, c := range appArmorCapabilities {
+		fmt.Fprintf(&b, "  capability %s,\n", c)
+	}
+	b.WriteString("\n")
+	for _, n := range []string{"inet stream", "inet6 stream", "inet dgram", "inet6 dgram", "netlink raw", "unix stream", "unix dgram"} {
+		fmt.Fprintf(&b, "  network %s,\n", n)
+	}
+	b.WriteString("\n")
+
+	b.WriteString("  # Inventory reads package databases, repository files, /proc and /sys.\n")
+	b.WriteString("  / r,\n")
+	b.WriteString("  /** r,\n")
+	fmt.Fprintf(&b, "  %s mrix,\n\n", o.Agent)
+
+	for _, d := range o.WriteDirs {
+		fmt.Fprintf(&b, "  %s/ rw,\n", strings.TrimSuffix(d, "/"))
+		fmt.Fprintf(&b, "  %s/** rwk,\n", strings.TrimSuffix(d, "/"))
+	}
+	for _, f := range o.WriteFiles {
+		fmt.Fprintf(&b, "  %s rwk,\n", f)
+	}
+	b.WriteString("\n")
+
+	for _, d := range o.ExecDirs {
+		fmt.Fprintf(&b, "  %s/** PUx,\n", strings.TrimSuffix(d, "/"))
+	}
+	for _, c := range o.Commands {
+		fmt.Fprintf(&b, "  %s PUx,\n", c)
+	}
+
+	b.WriteString("\n  #include if exists <local/" + ProfileName + ">\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// seccompDenied are syscalls neither the agent nor package manager scripts
+// need, denying them limits what a compromised agent can do. These are the
+// systemd @cpu-emulation, @debug, @obsolete and @raw-io sets plus kexec.
+// Since seccomp filters are inherited by child processes this is a deny
+// list, an allow list of the agent's own syscalls would break package
+// installs.
+var seccompDenied = []string{
+	// @cpu-emulation
+	"modify_ldt", "subpage_prot", "switch_endian", "vm86", "vm86old",
+	// @debug
+	"lookup_dcookie", "perf_event_open", "pidfd_getfd", "ptrace", "rtas", "s390_runtime_instr", "sys_debug_setcontext",
+	// @obsolete
+	"_sysctl", "afs_syscall", "bdflush", "break", "create_module", "ftime", "get_kernel_syms", "getpmsg", "gtty",
+	"idle", "lock", "mpx", "prof", "profil", "putpmsg", "query_module", "security", "sgetmask", "ssetmask",
+	"stty", "sysfs", "tuxcall", "ulimit", "uselib", "ustat", "vserver",
+	// @raw-io
+	"ioperm", "iopl", "pciconfig_iobase", "pciconfig_read", "pciconfig_write", "s390_pci_mmio_read", "s390_pci_mmio_write",
+	// kexec
+	"kexec_file_load", "kexec_load",
+}
+
+type seccompRule struct {
+	Names    []string `json:"names"`
+	Action   string   `json:"action"`
+	ErrnoRet int      `json:"errnoRet"`
+}
+
+type seccompProfile struct {
+	DefaultAction string        `json:"defaultAction"`
+	Syscalls      []seccompRule `json:"syscalls"`
+}
+
+// Seccomp returns a seccomp profile in the OCI runtime format that fails
+// denied syscalls with EPERM.
+func Seccomp() ([]byte, error) {
+	names := append([]string(nil), seccompDenied...)
+	sort.Strings(names)
+	return json.MarshalIndent(seccompProfile{
+		DefaultAction: "SCMP_ACT_ALLOW",
+		Syscalls:      []seccompRule{{Names: names, Action: "SCMP_ACT_ERRNO", ErrnoRet: 1}},
+	}, "", "  ")
+}
+
+// SystemdSyscallFilter is the systemd SystemCallFilter equivalent of the
+// seccomp profile.
+const SystemdSyscallFilter = "~@cpu-emulation @debug @obsolete @raw-io kexec_load kexec_file_load"
+
+// SystemdDropIn returns a systemd drop-in for the agent service that applies
+// SystemdSyscallFilter. The shipped unit does not set it, since the filter
+// is inherited by everything the agent runs, including exec scripts.
+func SystemdDropIn() string {
+	return `# Syscall filter for the OS Config agent, generated by
+# ` + "`google_osconfig_agent generate-profile systemd`" + `. Install it as
+# /etc/systemd/system/google-osconfig-agent.service.d/syscall-filter.conf.
+# The filter also applies to OS policy exec scripts, package manager
+# maintainer scripts and anything else the agent runs.
+[Service]
+SystemCallFilter=` + SystemdSyscallFilter + `
+SystemCallErrorNumber=EPERM
+`
+}
+
+// WDACHints returns a PowerShell snippet creating WDAC file path rules for
+// the agent and the commands it runs, for merging into an existing policy.
+func WDACHints(o *Observation) string {
+	var b strings.Builder
+	b.WriteString("# WDAC file path rule hints for the OS Config agent.\n")
+	b.WriteString("# Generated by `google_osconfig_agent generate-profile wdac`, merge the rules\n")
+	b.WriteString("# into your policy with Merge-CIPolicy.\n")
+	b.WriteString("$rules = @()\n")
+	for _, p := range append([]string{o.Agent}, o.Commands...) {
+		fmt.Fprintf(&b, "$rules += New-CIPolicyRule -FilePathRule '%s'\n", strings.ReplaceAll(p, "'", "''"))
+	}
+	for _, d := range o.ExecDirs {
+		fmt.Fprintf(&b, "$rules += New-CIPolicyRule -FilePathRule '%s\\*'\n", strings.ReplaceAll(strings.TrimSuffix(d, `\`), "'", "''"))
+	}
+	return b.String()
+}
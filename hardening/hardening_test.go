@@ -0,0 +1,115 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package hardening
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestShippedAppArmorProfile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the AppArmor profile is only generated on linux")
+	}
+	shipped, err := ioutil.ReadFile("../google-osconfig-agent.apparmor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := AppArmor(Default(), true); got != string(shipped) {
+		t.Errorf("google-osconfig-agent.apparmor is out of date, regenerate it with `google_osconfig_agent generate-profile apparmor complain`, got:\n%s", got)
+	}
+}
+
+func TestObservationAdd(t *testing.T) {
+	o := &Observation{Commands: []string{"/usr/bin/yum"}}
+	o.Add("/usr/bin/rpmquery", "", "/usr/bin/yum", "/bin/sh")
+	want := []string{"/bin/sh", "/usr/bin/rpmquery", "/usr/bin/yum"}
+	if !reflect.DeepEqual(o.Commands, want) {
+		t.Errorf("Commands = %q, want %q", o.Commands, want)
+	}
+}
+
+func TestAppArmor(t *testing.T) {
+	o := &Observation{Agent: "/usr/bin/google_osconfig_agent", Commands: []string{"/usr/bin/apt-get"}, WriteDirs: []string{"/var/lib/x/"}}
+	got := AppArmor(o, false)
+	for _, want := range []string{
+		"profile google_osconfig_agent /usr/bin/google_osconfig_agent flags=(attach_disconnected) {",
+		"  /usr/bin/apt-get PUx,\n",
+		"  /var/lib/x/** rwk,\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AppArmor() missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestSeccomp(t *testing.T) {
+	data, err := Seccomp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p seccompProfile
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.DefaultAction != "SCMP_ACT_ALLOW" || len(p.Syscalls) != 1 || p.Syscalls[0].Action != "SCMP_ACT_ERRNO" {
+		t.Errorf("unexpected seccomp profile: %s", data)
+	}
+	denied := map[string]bool{}
+	for _, n := range p.Syscalls[0].Names {
+		denied[n] = true
+	}
+	for _, n := range []string{"ptrace", "kexec_load", "iopl"} {
+		if !denied[n] {
+			t.Errorf("%s is not denied", n)
+		}
+	}
+	// The agent falls back to reboot(2) if no reboot command exists.
+	if denied["reboot"] {
+		t.Error("reboot must not be denied")
+	}
+}
+
+func TestWDACHints(t *testing.T) {
+	o := &Observation{Agent: `C:\Program Files\Google\OSConfig\google_osconfig_agent.exe`, Commands: []string{`C:\it's\googet.exe`}, ExecDirs: []string{`C:\ProgramData\Google\OSConfig\inventory.d`}}
+	got := WDACHints(o)
+	for _, want := range []string{
+		`New-CIPolicyRule -FilePathRule 'C:\Program Files\Google\OSConfig\google_osconfig_agent.exe'`,
+		`New-CIPolicyRule -FilePathRule 'C:\it''s\googet.exe'`,
+		`New-CIPolicyRule -FilePathRule 'C:\ProgramData\Google\OSConfig\inventory.d\*'`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("WDACHints() missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestShippedSystemdFilter(t *testing.T) {
+	// The filter is inherited by exec scripts, so it is opt-in.
+	unit, err := ioutil.ReadFile("../google-osconfig-agent.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(unit), "SystemCallFilter=") {
+		t.Error("google-osconfig-agent.service sets SystemCallFilter, it should only be in the generated drop-in")
+	}
+	if !strings.Contains(SystemdDropIn(), "\n[Service]\nSystemCallFilter="+SystemdSyscallFilter+"\n") {
+		t.Errorf("SystemdDropIn() does not set SystemCallFilter=%s:\n%s", SystemdSyscallFilter, SystemdDropIn())
+	}
+}
@@ -0,0 +1,220 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package history keeps a local, size and age limited record of inventory
+// and compliance results so drift can be debugged on the host without
+// central tooling. Agents built with the sqlite tag can also query the
+// records with SQL and export them to a SQLite database.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
+)
+
+// Record kinds.
+const (
+	Inventory   = "inventory"
+	Compliance  = "compliance"
+	Transaction = "transaction"
+)
+
+var (
+	historyFile = agentconfig.HistoryFile
+	retention   = agentconfig.HistoryRetention
+	now         = time.Now
+	// maxRecords is the max number of records kept regardless of retention,
+	// inventory records can be large.
+	maxRecords = 200
+	// maxBytes caps the size of the history file.
+	maxBytes = 16 << 20
+	mx       sync.Mutex
+)
+
+// ErrNoSQLite is returned by SQL and Export in agents built without the
+// sqlite build tag, SQLite support needs cgo.
+var ErrNoSQLite = errors.New("agent built without SQLite support, build it with -tags sqlite")
+
+// Record is a single history entry.
+type Record struct {
+	Time time.Time       `json:"time"`
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Query filters records returned by Read.
+type Query struct {
+	// Kind matches the record kind if set.
+	Kind string
+	// Since excludes records older than this time if set.
+	Since time.Time
+	// Contains matches records whose data contains this string if set.
+	Contains string
+}
+
+func (q Query) match(r *Record) bool {
+	if q.Kind != "" && r.Kind != q.Kind {
+		return false
+	}
+	if !q.Since.IsZero() && r.Time.Before(q.Since) {
+		return false
+	}
+	if q.Contains != "" && !bytes.Contains(r.Data, []byte(q.Contains)) {
+		return false
+	}
+	return true
+}
+
+// Add records v under kind if history is enabled, records past the
+// retention period or over the record limit are pruned.
+//
+// Records are appended to the file. Pruned records are only hidden from
+// Read until the file holds twice the record limit or reaches maxBytes,
+// then it is rewritten with the records Read returns, at most half of
+// maxBytes.
+func Add(ctx context.Context, kind string, v interface{}) {
+	keep := retention()
+	if keep <= 0 {
+		return
+	}
+	if err := add(kind, v, keep); err != nil {
+		clog.Warningf(ctx, "Error recording %s history: %v", kind, err)
+	}
+}
+
+func add(kind string, v interface{}, keep time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r := &Record{Time: now().UTC(), Kind: kind, Data: data}
+	ln, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ln = append(ln, '\n')
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	records, size, err := load()
+	if errors.Is(err, integrity.ErrTampered) {
+		// Keep the modified history for inspection and start a new one.
+		if err := os.Rename(historyFile(), historyFile()+".tampered"); err != nil {
+			return err
+		}
+		records, size, err = nil, 0, nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(records) < 2*maxRecords && size+len(ln) <= maxBytes {
+		return integrity.AppendFile(historyFile(), ln, 0600)
+	}
+
+	// Keep the newest records that fit in half of maxBytes.
+	records = visible(append(records, r), keep)
+	var lines [][]byte
+	n := 0
+	for i := len(records) - 1; i >= 0; i-- {
+		b, err := json.Marshal(records[i])
+		if err != nil {
+			return err
+		}
+		if n+len(b)+1 > maxBytes/2 && len(lines) > 0 {
+			break
+		}
+		n += len(b) + 1
+		lines = append(lines, b)
+	}
+	var buf bytes.Buffer
+	for i := len(lines) - 1; i >= 0; i-- {
+		buf.Write(lines[i])
+		buf.WriteByte('\n')
+	}
+	return integrity.WriteFile(historyFile(), buf.Bytes(), 0600)
+}
+
+// visible returns the records within retention and the record limit.
+func visible(records []*Record, keep time.Duration) []*Record {
+	if keep > 0 {
+		since := now().UTC().Add(-keep)
+		for len(records) > 0 && records[0].Time.Before(since) {
+			records = records[1:]
+		}
+	}
+	if len(records) > maxRecords {
+		records = records[len(records)-maxRecords:]
+	}
+	return records
+}
+
+// Read returns the records matching q, oldest first. Corrupt lines are
+// skipped, as are records Add has pruned but not yet removed from the
+// file.
+func Read(q Query) ([]*Record, error) {
+	records, _, err := load()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Record
+	for _, r := range visible(records, retention()) {
+		if q.match(r) {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+// load returns all records in the history file and its size.
+func load() ([]*Record, int, error) {
+	data, err := integrity.ReadFile(historyFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	var records []*Record
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+		if ln == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal([]byte(ln), &r); err != nil {
+			continue
+		}
+		records = append(records, &r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading history file: %v", err)
+	}
+	return records, len(data), nil
+}
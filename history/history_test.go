@@ -0,0 +1,186 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package history
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setup(t *testing.T, keep time.Duration) *time.Time {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	oldFile, oldRetention, oldNow, oldMax, oldMaxBytes := historyFile, retention, now, maxRecords, maxBytes
+	t.Cleanup(func() {
+		historyFile, retention, now, maxRecords, maxBytes = oldFile, oldRetention, oldNow, oldMax, oldMaxBytes
+	})
+	historyFile = func() string { return path }
+	retention = func() time.Duration { return keep }
+	now = func() time.Time { return clock }
+	return &clock
+}
+
+func TestAddDisabled(t *testing.T) {
+	setup(t, 0)
+	Add(context.Background(), Inventory, map[string]string{"a": "b"})
+	if _, err := ioutil.ReadFile(historyFile()); err == nil {
+		t.Error("history file written with history disabled")
+	}
+}
+
+func TestAddAndRead(t *testing.T) {
+	ctx := context.Background()
+	clock := setup(t, 2*time.Hour)
+	start := *clock
+
+	Add(ctx, Inventory, map[string]string{"openssl": "1.1.1f"})
+	*clock = clock.Add(time.Hour)
+	Add(ctx, Compliance, []string{"COMPLIANT"})
+	*clock = clock.Add(time.Hour)
+	Add(ctx, Inventory, map[string]string{"openssl": "3.0.2"})
+
+	records, err := Read(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+
+	tests := []struct {
+		desc string
+		q    Query
+		want int
+	}{
+		{"kind", Query{Kind: Inventory}, 2},
+		{"since", Query{Since: start.Add(30 * time.Minute)}, 2},
+		{"contains", Query{Contains: "1.1.1"}, 1},
+		{"kind and contains", Query{Kind: Compliance, Contains: "1.1.1"}, 0},
+	}
+	for _, tt := range tests {
+		got, err := Read(tt.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.desc, err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("%s: got %d records, want %d", tt.desc, len(got), tt.want)
+		}
+	}
+
+	// The first record is now past retention.
+	*clock = clock.Add(90 * time.Minute)
+	Add(ctx, Compliance, []string{"NON_COMPLIANT"})
+	records, err = Read(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Kind != Inventory || records[1].Kind != Compliance {
+		t.Errorf("records after pruning = %+v, want [inventory compliance]", records)
+	}
+}
+
+func TestMaxRecords(t *testing.T) {
+	ctx := context.Background()
+	clock := setup(t, time.Hour)
+	maxRecords = 3
+	for i := 0; i < 5; i++ {
+		*clock = clock.Add(time.Second)
+		Add(ctx, Compliance, i)
+	}
+	records, err := Read(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || string(records[0].Data) != "2" {
+		t.Errorf("records = %+v, want the last 3", records)
+	}
+}
+
+func TestAddAppends(t *testing.T) {
+	ctx := context.Background()
+	clock := setup(t, time.Hour)
+	maxRecords = 2
+	var sizes []int
+	for i := 0; i < 5; i++ {
+		*clock = clock.Add(time.Second)
+		Add(ctx, Compliance, i)
+		data, err := ioutil.ReadFile(historyFile())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(data))
+	}
+	// The first 4 records are appended, the 5th compacts the file to the
+	// last 2.
+	for i := 1; i < 4; i++ {
+		if sizes[i] <= sizes[i-1] {
+			t.Errorf("history file did not grow on append %d: %v", i, sizes)
+		}
+	}
+	if sizes[4] >= sizes[3] {
+		t.Errorf("history file was not compacted: %v", sizes)
+	}
+	records, err := Read(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || string(records[0].Data) != "3" {
+		t.Errorf("records = %+v, want the last 2", records)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	clock := setup(t, time.Hour)
+	maxBytes = 400
+	for i := 0; i < 20; i++ {
+		*clock = clock.Add(time.Second)
+		Add(ctx, Compliance, strings.Repeat("x", 50))
+		data, err := ioutil.ReadFile(historyFile())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > maxBytes {
+			t.Fatalf("history file is %d bytes, want at most %d", len(data), maxBytes)
+		}
+	}
+	records, err := Read(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || !records[len(records)-1].Time.Equal(*clock) {
+		t.Errorf("newest record was not kept: %+v", records)
+	}
+}
+
+func TestReadSkipsCorruptLines(t *testing.T) {
+	setup(t, time.Hour)
+	data := "not json\n{\"time\":\"2024-01-01T00:00:00Z\",\"kind\":\"inventory\",\"data\":{}}\n\n"
+	if err := ioutil.WriteFile(historyFile(), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	records, err := Read(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("got %d records, want 1", len(records))
+	}
+}
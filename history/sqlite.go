@@ -0,0 +1,137 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build sqlite && cgo
+// +build sqlite,cgo
+
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+
+	// Registers the sqlite3 database/sql driver.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQL runs a read only SQL query against the history records and returns
+// the column names and rows. The records are loaded into an in-memory
+// database on each call, so queries only ever see records that passed the
+// integrity check and are within retention.
+func SQL(ctx context.Context, query string) ([]string, [][]interface{}, error) {
+	db, err := sql.Open("sqlite3", "file::memory:?mode=memory")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+	// Every connection has its own in-memory database.
+	db.SetMaxOpenConns(1)
+	if err := loadDB(ctx, db); err != nil {
+		return nil, nil, err
+	}
+	if _, err := db.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var ret [][]interface{}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		for i, v := range vals {
+			if b, ok := v.([]byte); ok {
+				vals[i] = string(b)
+			}
+		}
+		ret = append(ret, vals)
+	}
+	return cols, ret, rows.Err()
+}
+
+// Export writes the history records within retention to a new SQLite
+// database at path, replacing any file there.
+func Export(ctx context.Context, path string) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	db, err := sql.Open("sqlite3", (&url.URL{Scheme: "file", Opaque: tmp}).String())
+	if err != nil {
+		return err
+	}
+	err = loadDB(ctx, db)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0600)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error exporting history to %q: %v", path, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// sqlTimeFormat is RFC 3339 with a fixed number of digits, so times in UTC
+// sort and compare as text and are understood by the SQLite date functions.
+const sqlTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// loadDB creates the records table in db and fills it with the history
+// records:
+//
+//	CREATE TABLE records (time TEXT, kind TEXT, data TEXT)
+//
+// time is in sqlTimeFormat and data is the record JSON, for use with the
+// SQLite JSON functions.
+func loadDB(ctx context.Context, db *sql.DB) error {
+	records, err := Read(Query{})
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "CREATE TABLE records (time TEXT, kind TEXT, data TEXT)"); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO records (time, kind, data) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		if _, err := stmt.ExecContext(ctx, r.Time.UTC().Format(sqlTimeFormat), r.Kind, string(r.Data)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,30 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !sqlite || !cgo
+// +build !sqlite !cgo
+
+package history
+
+import "context"
+
+// SQL is only supported in builds with the sqlite tag.
+func SQL(context.Context, string) ([]string, [][]interface{}, error) {
+	return nil, nil, ErrNoSQLite
+}
+
+// Export is only supported in builds with the sqlite tag.
+func Export(context.Context, string) error {
+	return ErrNoSQLite
+}
@@ -0,0 +1,94 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build sqlite && cgo
+// +build sqlite,cgo
+
+package history
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQL(t *testing.T) {
+	ctx := context.Background()
+	clock := setup(t, 2*time.Hour)
+
+	Add(ctx, Inventory, map[string]string{"openssl": "1.1.1f"})
+	*clock = clock.Add(time.Hour)
+	Add(ctx, Compliance, []string{"COMPLIANT"})
+	*clock = clock.Add(time.Hour)
+	Add(ctx, Inventory, map[string]string{"openssl": "3.0.2"})
+
+	cols, rows, err := SQL(ctx, "SELECT time, json_extract(data, '$.openssl') AS openssl FROM records WHERE kind = 'inventory' ORDER BY time")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"time", "openssl"}; !reflect.DeepEqual(cols, want) {
+		t.Errorf("columns = %q, want %q", cols, want)
+	}
+	want := [][]interface{}{
+		{"2024-01-01T00:00:00.000Z", "1.1.1f"},
+		{"2024-01-01T02:00:00.000Z", "3.0.2"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+
+	// Records past retention are not queried.
+	*clock = clock.Add(90 * time.Minute)
+	_, rows, err = SQL(ctx, "SELECT count(*) FROM records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rows[0][0]; got != int64(1) {
+		t.Errorf("count = %v, want 1", got)
+	}
+
+	// Queries can not change the database.
+	if _, _, err := SQL(ctx, "DELETE FROM records"); err == nil {
+		t.Error("SQL() with a DELETE statement returned no error")
+	}
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	setup(t, time.Hour)
+	Add(ctx, Compliance, []string{"COMPLIANT"})
+
+	path := filepath.Join(t.TempDir(), "history.db")
+	for i := 0; i < 2; i++ {
+		// Exporting again replaces the database.
+		if err := Export(ctx, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var kind, data string
+	if err := db.QueryRow("SELECT kind, data FROM records").Scan(&kind, &data); err != nil {
+		t.Fatal(err)
+	}
+	if kind != Compliance || data != `["COMPLIANT"]` {
+		t.Errorf("exported record = %q, %q", kind, data)
+	}
+}
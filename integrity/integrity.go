@@ -0,0 +1,287 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package integrity signs the agent's local state files with an HMAC so
+// that local edits to them, such as marking a recipe as installed or
+// rewriting compliance history, are detected instead of trusted.
+//
+// The key and the signature of each file are kept in a manifest only the
+// agent can read, outside the directories holding the signed files, so
+// access to the state directories is not enough to forge a signature. The
+// key is bound to the machine by mixing in the machine ID, so state copied
+// from another machine does not verify.
+//
+// Signing is off until Enable is called. Files written by older agents have
+// no signature, they are accepted until the manifest is first written, after
+// that an unsigned file is treated as tampered.
+package integrity
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// ErrTampered is returned by ReadFile if a file does not match its
+// signature, or has none once signing is in use.
+var ErrTampered = errors.New("file does not match its signature")
+
+var (
+	mx       sync.Mutex
+	tampered = map[string]bool{}
+
+	// manifestPath is the manifest location, files are not signed or
+	// verified when it is empty.
+	manifestPath string
+)
+
+// Enable turns on signing and verification of state files, it must be
+// called before any state is read.
+func Enable() {
+	manifestPath = defaultManifestPath()
+}
+
+type manifest struct {
+	Key []byte `json:"key"`
+	// Sigs are the accepted signatures of each file by absolute path. A
+	// file has two while it is being written, so a crash between writing
+	// the file and the manifest does not look like tampering.
+	Sigs map[string][]string `json:"sigs"`
+
+	exists bool
+}
+
+func loadManifest() (*manifest, error) {
+	m := &manifest{Sigs: map[string][]string{}}
+	path := manifestPath
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	if err := checkManifestFile(path, fi); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing state integrity manifest: %v", err)
+	}
+	if len(m.Key) == 0 {
+		return nil, errors.New("state integrity manifest has no key")
+	}
+	if m.Sigs == nil {
+		m.Sigs = map[string][]string{}
+	}
+	m.exists = true
+	return m, nil
+}
+
+func (m *manifest) save() error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	path := manifestPath
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return util.AtomicWrite(path, data, 0600)
+}
+
+func (m *manifest) sign(data []byte) (string, error) {
+	if len(m.Key) == 0 {
+		m.Key = make([]byte, 32)
+		if _, err := rand.Read(m.Key); err != nil {
+			return "", err
+		}
+	}
+	// The machine ID is not secret, it only ties the key to this machine.
+	key := hmac.New(sha256.New, m.Key)
+	key.Write([]byte(machineID()))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// WriteFile atomically writes data to path and records its signature. The
+// new signature is recorded before the file is written and the old one is
+// dropped after, so the file verifies at every step.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	if manifestPath == "" {
+		return util.AtomicWrite(path, data, perm)
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	m, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	sig, err := m.sign(data)
+	if err != nil {
+		return err
+	}
+	old := m.Sigs[path]
+	if len(old) > 1 {
+		old = old[:1]
+	}
+	m.Sigs[path] = append(slices.Clone(old), sig)
+	if err := m.save(); err != nil {
+		return err
+	}
+	if err := util.AtomicWrite(path, data, perm); err != nil {
+		return err
+	}
+	m.Sigs[path] = []string{sig}
+	return m.save()
+}
+
+// AppendFile appends data to path, creating it if needed, and records the
+// signature of the whole file. ErrTampered is returned if path does not
+// verify before the append.
+func AppendFile(path string, data []byte, perm os.FileMode) error {
+	if manifestPath == "" {
+		return appendFile(path, data, perm)
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	m, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	old, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Like WriteFile, the old content stays accepted until it is appended to.
+	var sigs []string
+	if err == nil {
+		got, err := m.sign(old)
+		if err != nil {
+			return err
+		}
+		if m.exists && !slices.Contains(m.Sigs[path], got) {
+			tampered[path] = true
+			return fmt.Errorf("%s: %w", path, ErrTampered)
+		}
+		sigs = append(sigs, got)
+	}
+	sig, err := m.sign(append(old, data...))
+	if err != nil {
+		return err
+	}
+	m.Sigs[path] = append(sigs, sig)
+	if err := m.save(); err != nil {
+		return err
+	}
+	if err := appendFile(path, data, perm); err != nil {
+		return err
+	}
+	m.Sigs[path] = []string{sig}
+	return m.save()
+}
+
+func appendFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFile reads path and verifies it against its recorded signature,
+// ErrTampered is returned if it does not match.
+func ReadFile(path string) ([]byte, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || manifestPath == "" {
+		return data, err
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	m, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	if !m.exists {
+		// Nothing has been signed yet, this is state from an older agent.
+		return data, nil
+	}
+	got, err := m.sign(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, want := range m.Sigs[path] {
+		if hmac.Equal([]byte(got), []byte(want)) {
+			return data, nil
+		}
+	}
+	tampered[path] = true
+	return nil, fmt.Errorf("%s: %w", path, ErrTampered)
+}
+
+// Tampered returns the files that failed verification since the agent
+// started.
+func Tampered() []string {
+	mx.Lock()
+	defer mx.Unlock()
+
+	var paths []string
+	for p := range tampered {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
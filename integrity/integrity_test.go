@@ -0,0 +1,169 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package integrity
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// enable turns on signing with a manifest in a temporary directory for
+// the duration of the test.
+func enable(t *testing.T) string {
+	old := manifestPath
+	t.Cleanup(func() { manifestPath = old })
+	manifestPath = filepath.Join(t.TempDir(), "state_integrity.json")
+	return manifestPath
+}
+
+func TestWriteReadFile(t *testing.T) {
+	enable(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "recipedb")
+	data := []byte(`[{"Name":"recipe","Success":true}]`)
+
+	if err := WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("ReadFile() = %q, want %q", got, data)
+	}
+
+	// A local edit is detected.
+	if err := ioutil.WriteFile(path, []byte(`[{"Name":"recipe","Success":false}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); !errors.Is(err, ErrTampered) {
+		t.Errorf("ReadFile() of modified file error = %v, want %v", err, ErrTampered)
+	}
+	if got := Tampered(); !reflect.DeepEqual(got, []string{path}) {
+		t.Errorf("Tampered() = %q, want %q", got, []string{path})
+	}
+
+	// Writing through the agent signs the file again.
+	if err := WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err != nil {
+		t.Errorf("ReadFile() after rewrite error: %v", err)
+	}
+
+	// The manifest is not kept with the files it protects.
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("state directory has %d entries, want only the signed file", len(entries))
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+	enable(t)
+	path := filepath.Join(t.TempDir(), "history")
+
+	for _, ln := range []string{"a\n", "b\n"} {
+		if err := AppendFile(path, []byte(ln), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	if string(got) != "a\nb\n" {
+		t.Errorf("ReadFile() = %q, want %q", got, "a\nb\n")
+	}
+
+	// Appending to a file edited locally fails.
+	if err := ioutil.WriteFile(path, []byte("x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendFile(path, []byte("c\n"), 0600); !errors.Is(err, ErrTampered) {
+		t.Errorf("AppendFile() to modified file error = %v, want %v", err, ErrTampered)
+	}
+}
+
+func TestReadFileUnsigned(t *testing.T) {
+	enable(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "legacy")
+	if err := ioutil.WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err != nil {
+		t.Errorf("ReadFile() of file written by an older agent error: %v", err)
+	}
+	if _, err := ReadFile(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("ReadFile() of missing file error = %v, want not exist", err)
+	}
+
+	// Once anything is signed, unsigned files are no longer trusted.
+	if err := WriteFile(filepath.Join(dir, "signed"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); !errors.Is(err, ErrTampered) {
+		t.Errorf("ReadFile() of unsigned file error = %v, want %v", err, ErrTampered)
+	}
+}
+
+func TestWriteFileInterrupted(t *testing.T) {
+	enable(t)
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	if err := WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Record both signatures as WriteFile does before writing the file, as
+	// if the agent stopped right after.
+	m, err := loadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, _ := filepath.Abs(path)
+	sig, err := m.sign([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Sigs[abs] = append(m.Sigs[abs], sig)
+	if err := m.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err != nil {
+		t.Errorf("ReadFile() of the old file error: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err != nil {
+		t.Errorf("ReadFile() of the new file error: %v", err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recipedb")
+	if err := WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("[]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(path); err != nil || string(got) != "[]" {
+		t.Errorf("ReadFile() with signing off = %q, %v, want %q, nil", got, err, "[]")
+	}
+}
@@ -0,0 +1,43 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package integrity
+
+import (
+	"os/exec"
+	"regexp"
+	"sync"
+)
+
+var (
+	platformUUIDRe = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+	platformUUIDOnce sync.Once
+	platformUUID     string
+)
+
+// machineID returns the hardware UUID of the Mac, macOS has no
+// /etc/machine-id. It does not change so it is only read once.
+func machineID() string {
+	platformUUIDOnce.Do(func() {
+		out, err := exec.Command("/usr/sbin/ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if err != nil {
+			return
+		}
+		if m := platformUUIDRe.FindSubmatch(out); m != nil {
+			platformUUID = string(m[1])
+		}
+	})
+	return platformUUID
+}
@@ -0,0 +1,59 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package integrity
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyIsMachineBound(t *testing.T) {
+	enable(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "drift.state")
+	if err := WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := machineIDFile
+	defer func() { machineIDFile = old }()
+	machineIDFile = filepath.Join(dir, "machine-id")
+	if err := ioutil.WriteFile(machineIDFile, []byte("another-machine\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); !errors.Is(err, ErrTampered) {
+		t.Errorf("ReadFile() on another machine error = %v, want %v", err, ErrTampered)
+	}
+}
+
+func TestManifestPermissions(t *testing.T) {
+	manifest := enable(t)
+	path := filepath.Join(t.TempDir(), "drift.state")
+	if err := WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err == nil {
+		t.Error("ReadFile() with a world readable manifest did not return an error")
+	}
+}
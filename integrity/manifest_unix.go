@@ -0,0 +1,43 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package integrity
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// defaultManifestPath is the state integrity manifest, it is kept with the
+// agent configuration rather than in the state directories it protects.
+func defaultManifestPath() string {
+	return "/etc/google_osconfig_agent/state_integrity.json"
+}
+
+// checkManifestFile returns an error if the manifest may be read or
+// modified by anyone but the agent.
+func checkManifestFile(path string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if int(st.Uid) != os.Geteuid() || fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s may be accessed by users other than the agent", path)
+	}
+	return nil
+}
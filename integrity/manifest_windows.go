@@ -0,0 +1,38 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package integrity
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// defaultManifestPath is the state integrity manifest. It is kept in the
+// configuration directory of the account the agent runs as, which only that
+// account and administrators can access, rather than in the cache
+// directory holding the state it protects.
+func defaultManifestPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.Getenv("SystemRoot")
+	}
+	return filepath.Join(dir, "Google", "OSConfig", "state_integrity.json")
+}
+
+// checkManifestFile is a no-op, access to the manifest is restricted by the
+// ACL of the directory it is in.
+func checkManifestFile(string, os.FileInfo) error {
+	return nil
+}
@@ -18,17 +18,25 @@ package inventory
 
 import (
 	"context"
+	"sort"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
+	"github.com/GoogleCloudPlatform/osconfig/managedfiles"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
+var runner = util.CommandRunner(&util.DefaultRunner{})
+
 // InstanceInventory is an instances inventory data.
 type InstanceInventory struct {
 	Hostname             string
+	ShortHostname        string
+	FQDN                 string
 	LongName             string
 	ShortName            string
 	Version              string
@@ -38,17 +46,82 @@ type InstanceInventory struct {
 	OSConfigAgentVersion string
 	InstalledPackages    *packages.Packages
 	PackageUpdates       *packages.Packages
+	PluginInventory      *PluginInventory
+	Repositories         *RepositoryInventory
+	TamperedFiles        *TamperedFiles
+	PackageVerification  *PackageVerification
+	WindowsInventory     *WindowsInventory
+	RuntimeInventory     *RuntimeInventory
+	PythonInventory      *PythonInventory
+	WSLInventory         *WSLInventory
+	Provisioning         *ProvisioningInventory
 	LastUpdated          string
 }
 
+// TamperedFiles lists the files written by the agent, including its own state
+// files, that have since been changed outside of it.
+type TamperedFiles struct {
+	Files []*managedfiles.TamperedFile `json:"files,omitempty"`
+}
+
+func getTamperedFiles(ctx context.Context) *TamperedFiles {
+	files, err := managedfiles.Check(ctx)
+	if err != nil {
+		clog.Errorf(ctx, "managedfiles.Check() error: %v", err)
+	}
+	// Agent state files that failed signature verification.
+	for _, p := range integrity.Tampered() {
+		files = append(files, &managedfiles.TamperedFile{Path: p, Reason: managedfiles.Modified})
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	for _, f := range files {
+		clog.Warningf(ctx, "Managed file %q was %s outside of the OS Config agent.", f.Path, f.Reason)
+	}
+	return &TamperedFiles{Files: files}
+}
+
+// getNpmPackages lists the globally installed npm packages, it can be
+// disabled for hosts where walking the global node tree is too slow.
+func getNpmPackages(ctx context.Context) []*packages.PkgInfo {
+	if !packages.NpmExists || !agentconfig.NpmInventoryEnabled() {
+		return nil
+	}
+	pkgs, err := packages.InstalledNpmPackages(ctx)
+	if err != nil {
+		clog.Errorf(ctx, "packages.InstalledNpmPackages() error: %v", err)
+		return nil
+	}
+	return pkgs
+}
+
 // Get generates inventory data.
 func Get(ctx context.Context) *InstanceInventory {
+	inv := Collect(ctx)
+	addPrivileged(ctx, inv)
+	return inv
+}
+
+// addPrivileged adds the inventory data that is read from the agent's own
+// root only state.
+func addPrivileged(ctx context.Context, inv *InstanceInventory) {
+	inv.TamperedFiles = getTamperedFiles(ctx)
+	// Verification reads files that may only be readable by root.
+	inv.PackageVerification = getPackageVerification(ctx, inv.InstalledPackages)
+}
+
+// Collect generates the inventory data that does not require root, it is
+// all of the inventory except the agent's own state.
+func Collect(ctx context.Context) *InstanceInventory {
 	clog.Debugf(ctx, "Gathering instance inventory.")
 
 	installedPackages, err := packages.GetInstalledPackages(ctx)
 	if err != nil {
 		clog.Errorf(ctx, "packages.GetInstalledPackages() error: %v", err)
 	}
+	installedPackages.Npm = getNpmPackages(ctx)
 
 	packageUpdates, err := packages.GetPackageUpdates(ctx)
 	if err != nil {
@@ -60,8 +133,14 @@ func Get(ctx context.Context) *InstanceInventory {
 		clog.Errorf(ctx, "osinfo.Get() error: %v", err)
 	}
 
+	// Report packages in a stable order with stable IDs.
+	installedPackages.Normalize()
+	packageUpdates.Normalize()
+
 	return &InstanceInventory{
 		Hostname:             oi.Hostname,
+		ShortHostname:        osinfo.ShortHostname(oi.Hostname),
+		FQDN:                 osinfo.FQDN(ctx, oi.Hostname),
 		LongName:             oi.LongName,
 		ShortName:            oi.ShortName,
 		Version:              oi.Version,
@@ -71,6 +150,13 @@ func Get(ctx context.Context) *InstanceInventory {
 		OSConfigAgentVersion: agentconfig.Version(),
 		InstalledPackages:    installedPackages,
 		PackageUpdates:       packageUpdates,
+		PluginInventory:      GetPluginInventory(ctx),
+		Repositories:         GetRepositories(ctx),
+		WindowsInventory:     GetWindowsInventory(ctx),
+		RuntimeInventory:     GetRuntimeInventory(ctx),
+		PythonInventory:      GetPythonInventory(ctx),
+		WSLInventory:         GetWSLInventory(ctx),
+		Provisioning:         GetProvisioningInventory(ctx),
 		LastUpdated:          time.Now().UTC().Format(time.RFC3339),
 	}
 }
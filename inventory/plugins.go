@@ -0,0 +1,385 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// Inventory provider plugins are admin provided executables placed in the
+// plugin directory. Each plugin is run with no arguments and must write a
+// JSON document to stdout:
+//
+//	{
+//	  "items": [
+//	    {"name": "my-app", "version": "1.2.3", "type": "application", "attributes": {"channel": "stable"}}
+//	  ]
+//	}
+//
+// If a trusted_keys file (one base64 encoded ed25519 public key per line)
+// exists in the plugin directory, each plugin must have a detached base64
+// encoded signature at "<plugin path>.sig" made by one of those keys.
+//
+// The plugin directory, the trusted_keys file and each plugin must be owned
+// by root, or SYSTEM or Administrators on Windows, and must not be writable
+// by anyone else.
+
+const trustedKeysFile = "trusted_keys"
+
+var (
+	pluginDir = agentconfig.InventoryPluginDir()
+	// pluginTimeout is the max time a single plugin may run.
+	pluginTimeout = 30 * time.Second
+	// pluginMaxOutput is the max size of a plugin's stdout.
+	pluginMaxOutput = 1024 * 1024
+	// pluginMaxItems is the max number of items accepted from a single plugin.
+	pluginMaxItems = 1000
+
+	errOutputTooLarge = errors.New("plugin output exceeds size limit")
+
+	checkPluginOwner = ownedBySystem
+
+	// trustedWriters are the SDDL SIDs that may modify plugins on Windows:
+	// SYSTEM, Administrators and TrustedInstaller.
+	trustedWriters = map[string]bool{
+		"SY":           true,
+		"S-1-5-18":     true,
+		"BA":           true,
+		"S-1-5-32-544": true,
+		"S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464": true,
+	}
+
+	// sddlRights are the access masks of SDDL access right strings.
+	sddlRights = map[string]uint32{
+		"GA": 0x10000000, "GR": 0x80000000, "GW": 0x40000000, "GX": 0x20000000,
+		"FA": 0x1f01ff, "FR": 0x120089, "FW": 0x120116, "FX": 0x1200a0,
+		"RC": 0x20000, "SD": 0x10000, "WD": 0x40000, "WO": 0x80000,
+		"CC": 0x1, "DC": 0x2, "LC": 0x4, "SW": 0x8, "RP": 0x10, "WP": 0x20, "DT": 0x40, "LO": 0x80, "CR": 0x100,
+		"KA": 0xf003f, "KR": 0x20019, "KW": 0x20006, "KX": 0x20019,
+	}
+)
+
+// sddlWriteRights are the rights that allow changing a file's content or
+// security: FILE_WRITE_DATA, FILE_APPEND_DATA, DELETE, WRITE_DAC,
+// WRITE_OWNER, GENERIC_ALL and GENERIC_WRITE.
+const sddlWriteRights = 0x2 | 0x4 | 0x10000 | 0x40000 | 0x80000 | 0x10000000 | 0x40000000
+
+// PluginInventory is inventory data contributed by inventory provider plugins.
+type PluginInventory struct {
+	Items []*PluginItem `json:"items,omitempty"`
+}
+
+// PluginItem is a single inventory item reported by a plugin.
+type PluginItem struct {
+	// ID identifies the item across reports, it is set by the agent.
+	ID         string            `json:"id"`
+	Plugin     string            `json:"plugin"`
+	Name       string            `json:"name"`
+	Version    string            `json:"version,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type pluginOutput struct {
+	Items []*PluginItem `json:"items"`
+}
+
+// limitedBuffer is a bytes.Buffer that discards writes once more than max
+// bytes are written, discarding instead of erroring ensures the plugin does
+// not block on a full pipe.
+type limitedBuffer struct {
+	bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.overflow || b.Len()+len(p) > b.max {
+		b.overflow = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// GetPluginInventory runs all inventory provider plugins and returns their
+// combined items, a failing plugin is logged and skipped.
+func GetPluginInventory(ctx context.Context) *PluginInventory {
+	plugins, err := listPlugins(pluginDir)
+	if err != nil {
+		clog.Errorf(ctx, "Error listing inventory plugins in %q: %v", pluginDir, err)
+		return nil
+	}
+	if len(plugins) == 0 {
+		return nil
+	}
+
+	if err := checkPluginOwner(pluginDir); err != nil {
+		clog.Errorf(ctx, "Not running inventory plugins: %v", err)
+		return nil
+	}
+	keys, err := loadTrustedKeys(filepath.Join(pluginDir, trustedKeysFile))
+	if err != nil {
+		clog.Errorf(ctx, "Error loading inventory plugin trusted keys, not running plugins: %v", err)
+		return nil
+	}
+
+	inv := &PluginInventory{}
+	for _, p := range plugins {
+		if err := checkPluginOwner(p); err != nil {
+			clog.Errorf(ctx, "Skipping inventory plugin %q: %v", p, err)
+			continue
+		}
+		if keys != nil {
+			if err := verifyPlugin(p, keys); err != nil {
+				clog.Errorf(ctx, "Skipping inventory plugin %q: %v", p, err)
+				continue
+			}
+		}
+		items, err := runPlugin(ctx, p)
+		if err != nil {
+			clog.Errorf(ctx, "Error running inventory plugin %q: %v", p, err)
+			continue
+		}
+		clog.Debugf(ctx, "Inventory plugin %q returned %d items.", p, len(items))
+		inv.Items = append(inv.Items, items...)
+	}
+	sortPluginItems(inv.Items)
+	return inv
+}
+
+// sortPluginItems sorts items and sets their IDs, of the form
+// "plugin:<plugin>:<type>:<name>" or "plugin:<plugin>:<name>" for items
+// without a type, the version is added if a plugin reports several versions
+// of an item.
+func sortPluginItems(items []*PluginItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		switch {
+		case a.Plugin != b.Plugin:
+			return a.Plugin < b.Plugin
+		case a.Type != b.Type:
+			return a.Type < b.Type
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	count := map[string]int{}
+	for _, item := range items {
+		item.ID = "plugin:" + item.Plugin + ":" + item.Name
+		if item.Type != "" {
+			item.ID = "plugin:" + item.Plugin + ":" + item.Type + ":" + item.Name
+		}
+		count[item.ID]++
+	}
+	for _, item := range items {
+		if count[item.ID] > 1 {
+			item.ID += ":" + item.Version
+		}
+	}
+}
+
+// listPlugins returns the sorted list of runnable plugins in dir.
+func listPlugins(dir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var plugins []string
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || fi.Name() == trustedKeysFile || strings.HasSuffix(fi.Name(), ".sig") {
+			continue
+		}
+		if runtime.GOOS != "windows" && fi.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		plugins = append(plugins, filepath.Join(dir, fi.Name()))
+	}
+	sort.Strings(plugins)
+	return plugins, nil
+}
+
+// loadTrustedKeys loads the plugin trusted keys, nil is returned if the file
+// does not exist.
+func loadTrustedKeys(path string) ([]ed25519.PublicKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if err := checkPluginOwner(path); err != nil {
+		return nil, err
+	}
+
+	keys := []ed25519.PublicKey{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(ln)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid key in %q: %q", path, ln)
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	return keys, scanner.Err()
+}
+
+// sddlWriters returns the SIDs that an SDDL security descriptor string
+// allows to modify the object, as they appear in the string. A missing DACL
+// allows everyone.
+func sddlWriters(sddl string) ([]string, error) {
+	i := strings.Index(sddl, "D:")
+	if i < 0 {
+		return nil, errors.New("no DACL in security descriptor")
+	}
+	dacl := sddl[i+2:]
+	if j := strings.Index(dacl, "S:"); j >= 0 {
+		dacl = dacl[:j]
+	}
+	if strings.HasPrefix(dacl, "NO_ACCESS_CONTROL") {
+		return []string{"WD"}, nil
+	}
+
+	var writers []string
+	for {
+		start := strings.Index(dacl, "(")
+		if start < 0 {
+			return writers, nil
+		}
+		end := strings.Index(dacl[start:], ")")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated ACE in %q", sddl)
+		}
+		ace := strings.Split(dacl[start+1:start+end], ";")
+		dacl = dacl[start+end+1:]
+		if len(ace) < 6 {
+			return nil, fmt.Errorf("invalid ACE %q", ace)
+		}
+		// Only allow ACEs that apply to the object itself grant access.
+		if (ace[0] != "A" && ace[0] != "XA") || strings.Contains(ace[1], "IO") {
+			continue
+		}
+		var mask uint32
+		if strings.HasPrefix(ace[2], "0x") {
+			m, err := strconv.ParseUint(ace[2][2:], 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid access mask in ACE %q", ace)
+			}
+			mask = uint32(m)
+		} else {
+			for r := ace[2]; r != ""; r = r[min(2, len(r)):] {
+				m, ok := sddlRights[r[:min(2, len(r))]]
+				if !ok {
+					return nil, fmt.Errorf("unknown access right in ACE %q", ace)
+				}
+				mask |= m
+			}
+		}
+		if mask&sddlWriteRights != 0 {
+			writers = append(writers, ace[5])
+		}
+	}
+}
+
+func verifyPlugin(path string, keys []ed25519.PublicKey) error {
+	sig, err := ioutil.ReadFile(path + ".sig")
+	if err != nil {
+		return fmt.Errorf("error reading signature: %v", err)
+	}
+	sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("error decoding signature: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if ed25519.Verify(k, data, sig) {
+			return nil
+		}
+	}
+	return errors.New("no trusted key matched signature")
+}
+
+func runPlugin(ctx context.Context, path string) ([]*PluginItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, pluginTimeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{max: pluginMaxOutput}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	// Don't wait on child processes of the plugin holding stdout open.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", pluginTimeout)
+		}
+		return nil, fmt.Errorf("%v, stderr: %q", err, stderr.String())
+	}
+	if stdout.overflow {
+		return nil, errOutputTooLarge
+	}
+
+	var out pluginOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("error parsing output: %v", err)
+	}
+	if len(out.Items) > pluginMaxItems {
+		return nil, fmt.Errorf("plugin returned %d items, max is %d", len(out.Items), pluginMaxItems)
+	}
+
+	name := filepath.Base(path)
+	var items []*PluginItem
+	for _, item := range out.Items {
+		if item == nil || item.Name == "" {
+			continue
+		}
+		item.Plugin = name
+		items = append(items, item)
+	}
+	return items, nil
+}
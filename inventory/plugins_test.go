@@ -0,0 +1,173 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writePlugin(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetPluginInventory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin scripts require a POSIX shell")
+	}
+	ctx := context.Background()
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldDir, oldTimeout, oldMax, oldCheck := pluginDir, pluginTimeout, pluginMaxOutput, checkPluginOwner
+	defer func() {
+		pluginDir, pluginTimeout, pluginMaxOutput, checkPluginOwner = oldDir, oldTimeout, oldMax, oldCheck
+	}()
+	pluginDir = tmpDir
+	// The test files are owned by the test user.
+	checkPluginOwner = func(path string) error {
+		if filepath.Base(path) == "g_untrusted" {
+			return errors.New("untrusted")
+		}
+		return nil
+	}
+	pluginTimeout = 2 * time.Second
+	pluginMaxOutput = 1024
+
+	if got := GetPluginInventory(ctx); got != nil {
+		t.Errorf("expected nil inventory with no plugins, got: %+v", got)
+	}
+
+	good := writePlugin(t, tmpDir, "a_good", `echo '{"items": [{"name": "app", "version": "1.0", "attributes": {"k": "v"}}, {"version": "no name"}]}'`)
+	writePlugin(t, tmpDir, "b_bad_json", `echo 'not json'`)
+	writePlugin(t, tmpDir, "c_fail", `echo oops >&2; exit 1`)
+	writePlugin(t, tmpDir, "d_slow", `sleep 10`)
+	writePlugin(t, tmpDir, "e_large", `head -c 4096 /dev/zero`)
+	writePlugin(t, tmpDir, "g_untrusted", `echo '{"items": [{"name": "untrusted"}]}'`)
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "f_not_executable"), []byte(`echo '{"items": [{"name": "x"}]}'`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*PluginItem{{ID: "plugin:a_good:app", Plugin: "a_good", Name: "app", Version: "1.0", Attributes: map[string]string{"k": "v"}}}
+	got := GetPluginInventory(ctx)
+	if got == nil || !reflect.DeepEqual(got.Items, want) {
+		t.Errorf("GetPluginInventory() = %+v, want items %+v", got, want)
+	}
+
+	// With trusted keys only signed plugins are run.
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, trustedKeysFile), []byte("# comment\n"+base64.StdEncoding.EncodeToString(pub)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetPluginInventory(ctx); len(got.Items) != 0 {
+		t.Errorf("expected no items from unsigned plugins, got: %+v", got.Items)
+	}
+
+	data, err := ioutil.ReadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(good+".sig", []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetPluginInventory(ctx); !reflect.DeepEqual(got.Items, want) {
+		t.Errorf("GetPluginInventory() = %+v, want items %+v", got.Items, want)
+	}
+}
+
+func TestLoadTrustedKeysInvalid(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, trustedKeysFile)
+	if err := ioutil.WriteFile(path, []byte("not a key\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTrustedKeys(path); err == nil || !strings.Contains(err.Error(), "invalid key") {
+		t.Errorf("expected invalid key error, got: %v", err)
+	}
+}
+
+func TestSDDLWriters(t *testing.T) {
+	tests := []struct {
+		sddl string
+		want []string
+	}{
+		// The default DACL of C:\Program Files.
+		{"O:S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464D:PAI(A;;FA;;;S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464)(A;CIIO;GA;;;S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464)(A;;0x1301bf;;;SY)(A;OICIIO;GA;;;SY)(A;;0x1301bf;;;BA)(A;OICIIO;GA;;;BA)(A;;0x1200a9;;;BU)(A;OICIIO;GXGR;;;BU)(A;OICIIO;GA;;;CO)",
+			[]string{"S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464", "SY", "BA"}},
+		{"O:BAD:(A;ID;FA;;;SY)(A;ID;0x1200a9;;;BU)(A;ID;FW;;;AU)(D;;FA;;;WD)S:(ML;;NW;;;LW)", []string{"SY", "AU"}},
+		{"O:BAD:NO_ACCESS_CONTROL", []string{"WD"}},
+	}
+	for _, tt := range tests {
+		got, err := sddlWriters(tt.sddl)
+		if err != nil {
+			t.Errorf("sddlWriters(%q): unexpected error: %v", tt.sddl, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sddlWriters(%q) = %q, want %q", tt.sddl, got, tt.want)
+		}
+	}
+
+	for _, sddl := range []string{"O:BA", "O:BAD:(A;;XY;;;BU)", "O:BAD:(A;;FA;;;SY"} {
+		if _, err := sddlWriters(sddl); err == nil {
+			t.Errorf("sddlWriters(%q): expected an error", sddl)
+		}
+	}
+}
+
+func TestSortPluginItems(t *testing.T) {
+	items := []*PluginItem{
+		{Plugin: "b", Name: "x"},
+		{Plugin: "a", Type: "service", Name: "y", Version: "2"},
+		{Plugin: "a", Type: "service", Name: "y", Version: "1"},
+		{Plugin: "a", Name: "z"},
+	}
+	sortPluginItems(items)
+	var got []string
+	for _, item := range items {
+		got = append(got, item.ID)
+	}
+	want := []string{"plugin:a:z", "plugin:a:service:y:1", "plugin:a:service:y:2", "plugin:b:x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sortPluginItems() IDs = %q, want %q", got, want)
+	}
+}
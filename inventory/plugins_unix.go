@@ -0,0 +1,48 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ownedBySystem returns an error if path may be modified by users other
+// than root.
+func ownedBySystem(path string) error {
+	return ownedBy(path, 0)
+}
+
+func ownedBy(path string, uid uint32) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("can not read the owner of %s", path)
+	}
+	if st.Uid != uid {
+		return fmt.Errorf("%s is owned by uid %d, not %d", path, st.Uid, uid)
+	}
+	if fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is group or world writable", path)
+	}
+	return nil
+}
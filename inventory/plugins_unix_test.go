@@ -0,0 +1,53 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOwnedBy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	uid := uint32(os.Geteuid())
+
+	path := filepath.Join(tmpDir, "plugin")
+	if err := ioutil.WriteFile(path, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ownedBy(path, uid); err != nil {
+		t.Errorf("ownedBy(%q, %d): unexpected error: %v", path, uid, err)
+	}
+	if err := ownedBy(path, uid+1); err == nil {
+		t.Errorf("ownedBy(%q, %d): expected an error for another owner", path, uid+1)
+	}
+	for _, mode := range []os.FileMode{0775, 0757} {
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := ownedBy(path, uid); err == nil {
+			t.Errorf("ownedBy(%q, %d): expected an error for mode %o", path, uid, mode)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// ownedBySystem returns an error if path is not owned by SYSTEM or the
+// Administrators group, or if its DACL lets anyone else modify it.
+func ownedBySystem(path string) error {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("error reading the security descriptor of %s: %v", path, err)
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return fmt.Errorf("error reading the owner of %s: %v", path, err)
+	}
+	if !owner.IsWellKnown(windows.WinLocalSystemSid) && !owner.IsWellKnown(windows.WinBuiltinAdministratorsSid) {
+		return fmt.Errorf("%s is owned by %s, not SYSTEM or Administrators", path, owner)
+	}
+	writers, err := sddlWriters(sd.String())
+	if err != nil {
+		return fmt.Errorf("error reading the DACL of %s: %v", path, err)
+	}
+	for _, w := range writers {
+		if !trustedWriters[w] {
+			return fmt.Errorf("%s may be modified by %s", path, w)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ProvisioningInventory reports whether the first boot initialization of
+// the instance completed, instances where it did not are often the reason a
+// policy applied without the host working.
+type ProvisioningInventory struct {
+	CloudInit *CloudInitStatus `json:"cloudInit,omitempty"`
+	Sysprep   *SysprepStatus   `json:"sysprep,omitempty"`
+}
+
+// CloudInitStatus is the state cloud-init reports with "cloud-init status".
+type CloudInitStatus struct {
+	// Status is "done", "error", "running", "not run" or "disabled".
+	Status     string `json:"status"`
+	Datasource string `json:"datasource,omitempty"`
+	// Finished is when the last stage finished, in RFC 3339 format.
+	Finished string   `json:"finished,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// SysprepStatus is the state of Windows setup, which runs the specialize
+// pass and OOBE on the first boot of an image prepared with sysprep.
+type SysprepStatus struct {
+	// ImageState is the Windows setup image state, such as
+	// "IMAGE_STATE_COMPLETE".
+	ImageState string `json:"imageState,omitempty"`
+	// SetupInProgress is set while Windows setup is running.
+	SetupInProgress bool `json:"setupInProgress,omitempty"`
+	// Complete is set once setup finished and the image is deployed.
+	Complete bool `json:"complete"`
+	// Errors are the last lines of the setup error log, they are only
+	// reported while setup is not complete.
+	Errors []string `json:"errors,omitempty"`
+}
+
+var (
+	// cloudInitRunDir holds the status cloud-init writes during boot.
+	cloudInitRunDir = "/run/cloud-init"
+	// cloudInitDisabledFile disables cloud-init on the next boot.
+	cloudInitDisabledFile = "/etc/cloud/cloud-init.disabled"
+	// cloudInitStages are the boot stages in the order they run.
+	cloudInitStages = []string{"init-local", "init", "modules-config", "modules-final"}
+)
+
+// cloudInitStage is a boot stage in status.json, start and finished are
+// null until the stage starts and finishes.
+type cloudInitStage struct {
+	Errors   []string `json:"errors"`
+	Start    *float64 `json:"start"`
+	Finished *float64 `json:"finished"`
+}
+
+// getCloudInitStatus reads the cloud-init status files in runDir, it returns
+// nil on hosts without cloud-init.
+func getCloudInitStatus(runDir, disabledFile string) (*CloudInitStatus, error) {
+	if _, err := os.Stat(runDir); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(disabledFile); err == nil {
+			return &CloudInitStatus{Status: "disabled"}, nil
+		}
+		return nil, nil
+	}
+	if _, err := os.Stat(filepath.Join(runDir, "disabled")); err == nil {
+		return &CloudInitStatus{Status: "disabled"}, nil
+	}
+
+	st := &CloudInitStatus{Status: "not run"}
+	data, err := os.ReadFile(filepath.Join(runDir, "status.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	/*
+		{
+		 "v1": {
+		  "datasource": "DataSourceGCE",
+		  "init": {"errors": [], "finished": 1700000010.5, "start": 1700000008.1},
+		  ...
+		  "stage": null
+		 }
+		}
+	*/
+	var status struct {
+		V1 struct {
+			Datasource string  `json:"datasource"`
+			Stage      *string `json:"stage"`
+		} `json:"v1"`
+	}
+	var stages struct {
+		V1 map[string]json.RawMessage `json:"v1"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &stages); err != nil {
+		return nil, err
+	}
+	st.Datasource = status.V1.Datasource
+	// stage names the stage that is running.
+	running := status.V1.Stage != nil
+	var finished float64
+	for _, name := range cloudInitStages {
+		raw, ok := stages.V1[name]
+		if !ok {
+			continue
+		}
+		var stage cloudInitStage
+		if err := json.Unmarshal(raw, &stage); err != nil {
+			return nil, err
+		}
+		st.Errors = append(st.Errors, stage.Errors...)
+		switch {
+		case stage.Start != nil && stage.Finished == nil:
+			running = true
+		case stage.Finished != nil && *stage.Finished > finished:
+			finished = *stage.Finished
+		}
+	}
+	if finished > 0 {
+		st.Finished = time.Unix(int64(finished), 0).UTC().Format(time.RFC3339)
+	}
+
+	// result.json is written once the final stage ran.
+	_, err = os.Stat(filepath.Join(runDir, "result.json"))
+	done := err == nil
+	switch {
+	case running || (!done && finished > 0):
+		st.Status = "running"
+	case len(st.Errors) > 0:
+		st.Status = "error"
+	case done:
+		st.Status = "done"
+	}
+	return st, nil
+}
+
+// sysprepImageComplete is the image state of a deployed Windows instance.
+const sysprepImageComplete = "IMAGE_STATE_COMPLETE"
+
+// maxSetupErrors limits how many lines of the setup error log are reported.
+const maxSetupErrors = 10
+
+func newSysprepStatus(imageState string, setupInProgress bool, setupErrors []byte) *SysprepStatus {
+	st := &SysprepStatus{
+		ImageState:      imageState,
+		SetupInProgress: setupInProgress,
+		Complete:        imageState == sysprepImageComplete && !setupInProgress,
+	}
+	if !st.Complete {
+		st.Errors = lastLines(setupErrors, maxSetupErrors)
+	}
+	return st
+}
+
+// lastLines returns the last n non-empty lines of data.
+func lastLines(data []byte, n int) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
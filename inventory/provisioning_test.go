@@ -0,0 +1,128 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetCloudInitStatus(t *testing.T) {
+	const (
+		done    = `{"v1": {"datasource": "DataSourceGCE", "stage": null, "init-local": {"errors": [], "finished": 1700000002.5, "start": 1700000001.0}, "init": {"errors": [], "finished": 1700000005.1, "start": 1700000003.0}, "modules-config": {"errors": [], "finished": 1700000007.0, "start": 1700000006.0}, "modules-final": {"errors": [], "finished": 1700000010.9, "start": 1700000008.0}}}`
+		failed  = `{"v1": {"datasource": "DataSourceGCE", "stage": null, "init": {"errors": [], "finished": 1700000005.1, "start": 1700000003.0}, "modules-final": {"errors": ["('scripts_user', RuntimeError('Runparts: 1 failures in 1 attempted commands'))"], "finished": 1700000010.9, "start": 1700000008.0}}}`
+		running = `{"v1": {"datasource": "DataSourceGCE", "stage": "modules-final", "init": {"errors": [], "finished": 1700000005.1, "start": 1700000003.0}, "modules-final": {"errors": [], "finished": null, "start": 1700000008.0}}}`
+	)
+	tests := []struct {
+		name     string
+		files    map[string]string
+		disabled bool
+		noRunDir bool
+		want     *CloudInitStatus
+	}{
+		{
+			name:     "not installed",
+			noRunDir: true,
+		},
+		{
+			name:     "disabled",
+			noRunDir: true,
+			disabled: true,
+			want:     &CloudInitStatus{Status: "disabled"},
+		},
+		{
+			name:  "disabled by the generator",
+			files: map[string]string{"disabled": ""},
+			want:  &CloudInitStatus{Status: "disabled"},
+		},
+		{
+			name: "not run",
+			want: &CloudInitStatus{Status: "not run"},
+		},
+		{
+			name:  "done",
+			files: map[string]string{"status.json": done, "result.json": `{"v1": {"errors": []}}`},
+			want:  &CloudInitStatus{Status: "done", Datasource: "DataSourceGCE", Finished: "2023-11-14T22:13:30Z"},
+		},
+		{
+			name:  "error",
+			files: map[string]string{"status.json": failed, "result.json": `{"v1": {"errors": []}}`},
+			want:  &CloudInitStatus{Status: "error", Datasource: "DataSourceGCE", Finished: "2023-11-14T22:13:30Z", Errors: []string{"('scripts_user', RuntimeError('Runparts: 1 failures in 1 attempted commands'))"}},
+		},
+		{
+			name:  "running",
+			files: map[string]string{"status.json": running},
+			want:  &CloudInitStatus{Status: "running", Datasource: "DataSourceGCE", Finished: "2023-11-14T22:13:25Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			runDir := filepath.Join(dir, "run")
+			disabledFile := filepath.Join(dir, "cloud-init.disabled")
+			if !tt.noRunDir {
+				if err := os.Mkdir(runDir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, data := range tt.files {
+				if err := os.WriteFile(filepath.Join(runDir, name), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.disabled {
+				if err := os.WriteFile(disabledFile, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := getCloudInitStatus(runDir, disabledFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getCloudInitStatus() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSysprepStatus(t *testing.T) {
+	log := []byte("2024-01-01 Error [0x0601c1] IBS Callback_Productkey_Validate_Unattend:User specified an invalid product key\n\n2024-01-01 Error [0x030124] TOOL Specialize failed\n")
+
+	got := newSysprepStatus("IMAGE_STATE_COMPLETE", false, log)
+	if want := (&SysprepStatus{ImageState: "IMAGE_STATE_COMPLETE", Complete: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("newSysprepStatus(complete) = %+v, want %+v", got, want)
+	}
+
+	got = newSysprepStatus("IMAGE_STATE_SPECIALIZE_RESEAL_TO_OOBE", true, log)
+	want := &SysprepStatus{
+		ImageState:      "IMAGE_STATE_SPECIALIZE_RESEAL_TO_OOBE",
+		SetupInProgress: true,
+		Errors: []string{
+			"2024-01-01 Error [0x0601c1] IBS Callback_Productkey_Validate_Unattend:User specified an invalid product key",
+			"2024-01-01 Error [0x030124] TOOL Specialize failed",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newSysprepStatus(specialize) = %+v, want %+v", got, want)
+	}
+
+	if got := lastLines([]byte("1\n2\n3\n"), 2); !reflect.DeepEqual(got, []string{"2", "3"}) {
+		t.Errorf("lastLines() = %q, want the last 2 lines", got)
+	}
+}
@@ -0,0 +1,37 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package inventory
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// GetProvisioningInventory reports the cloud-init status, it returns nil on
+// hosts without cloud-init.
+func GetProvisioningInventory(ctx context.Context) *ProvisioningInventory {
+	st, err := getCloudInitStatus(cloudInitRunDir, cloudInitDisabledFile)
+	if err != nil {
+		clog.Errorf(ctx, "Error reading the cloud-init status: %v", err)
+	}
+	if st == nil {
+		return nil
+	}
+	return &ProvisioningInventory{CloudInit: st}
+}
@@ -0,0 +1,76 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	setupStateKey  = `SOFTWARE\Microsoft\Windows\CurrentVersion\Setup\State`
+	systemSetupKey = `SYSTEM\Setup`
+)
+
+// GetProvisioningInventory reports the state of Windows setup, which runs
+// the sysprep specialize pass on first boot.
+func GetProvisioningInventory(ctx context.Context) *ProvisioningInventory {
+	imageState, setupInProgress, err := getSetupState()
+	if err != nil {
+		clog.Errorf(ctx, "Error reading the Windows setup state: %v", err)
+		return nil
+	}
+
+	windir := os.Getenv("SystemRoot")
+	if windir == "" {
+		windir = `C:\Windows`
+	}
+	// The specialize pass logs to UnattendGC, OOBE to Panther itself.
+	var setupErrors []byte
+	for _, log := range []string{`Panther\UnattendGC\setuperr.log`, `Panther\setuperr.log`} {
+		data, err := os.ReadFile(filepath.Join(windir, log))
+		if err != nil {
+			continue
+		}
+		setupErrors = append(setupErrors, data...)
+		setupErrors = append(setupErrors, '\n')
+	}
+	return &ProvisioningInventory{Sysprep: newSysprepStatus(imageState, setupInProgress, setupErrors)}
+}
+
+func getSetupState() (string, bool, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, setupStateKey, registry.QUERY_VALUE)
+	if err != nil {
+		return "", false, err
+	}
+	defer k.Close()
+	imageState, _, err := k.GetStringValue("ImageState")
+	if err != nil {
+		return "", false, err
+	}
+
+	sk, err := registry.OpenKey(registry.LOCAL_MACHINE, systemSetupKey, registry.QUERY_VALUE)
+	if err != nil {
+		return imageState, false, err
+	}
+	defer sk.Close()
+	inProgress, _, _ := sk.GetIntegerValue("SystemSetupInProgress")
+	oobe, _, _ := sk.GetIntegerValue("OOBEInProgress")
+	return imageState, inProgress != 0 || oobe != 0, nil
+}
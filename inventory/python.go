@@ -0,0 +1,137 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// PythonInventory lists the Python distributions installed globally with
+// pip, so that vulnerable libraries are visible alongside OS packages.
+type PythonInventory struct {
+	Packages []*PythonPackage `json:"packages,omitempty"`
+}
+
+// PythonPackage is a single installed Python distribution.
+type PythonPackage struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Location string `json:"location,omitempty"`
+}
+
+var (
+	// pipCommands are the pip binaries that may manage a global site-packages,
+	// distro and locally built interpreters usually keep separate ones.
+	pipCommands = []string{"/usr/bin/pip3", "/usr/bin/pip", "/usr/local/bin/pip3", "/usr/local/bin/pip"}
+
+	pipListTimeout = 30 * time.Second
+
+	// pipList runs `pip list` with --verbose, which adds the location of each
+	// distribution to the JSON output.
+	pipList = func(ctx context.Context, pip string) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(ctx, pipListTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, pip, "list", "--format=json", "--verbose", "--disable-pip-version-check", "--no-input")
+		cmd.Env = append(os.Environ(), "PIP_NO_COLOR=1")
+		stdout, _, err := runner.Run(ctx, cmd)
+		return stdout, err
+	}
+
+	// resolvePip resolves symlinks so that pip and pip3 pointing at the same
+	// binary are only run once.
+	resolvePip = func(pip string) (string, error) {
+		return filepath.EvalSymlinks(pip)
+	}
+)
+
+type pipListEntry struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Location string `json:"location"`
+}
+
+// GetPythonInventory reports the distributions of every global pip found on
+// the system, duplicates reported by more than one pip are listed once.
+func GetPythonInventory(ctx context.Context) *PythonInventory {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	type key struct{ name, version, location string }
+	seen := map[key]bool{}
+	ran := map[string]bool{}
+	inv := &PythonInventory{}
+	for _, pip := range pipCommands {
+		path, err := resolvePip(pip)
+		if err != nil {
+			continue
+		}
+		if ran[path] {
+			continue
+		}
+		ran[path] = true
+		out, err := pipList(ctx, path)
+		if err != nil {
+			clog.Debugf(ctx, "Error running %s list: %v", pip, err)
+			continue
+		}
+		pkgs, err := parsePipList(out)
+		if err != nil {
+			clog.Debugf(ctx, "Error parsing %s list output: %v", pip, err)
+			continue
+		}
+		for _, p := range pkgs {
+			k := key{p.Name, p.Version, p.Location}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			inv.Packages = append(inv.Packages, p)
+		}
+	}
+	if len(inv.Packages) == 0 {
+		return nil
+	}
+	sort.SliceStable(inv.Packages, func(i, j int) bool {
+		if inv.Packages[i].Name != inv.Packages[j].Name {
+			return inv.Packages[i].Name < inv.Packages[j].Name
+		}
+		return inv.Packages[i].Location < inv.Packages[j].Location
+	})
+	return inv
+}
+
+func parsePipList(out []byte) ([]*PythonPackage, error) {
+	var entries []pipListEntry
+	if err := json.Unmarshal(out, &entries); err != nil {
+		return nil, err
+	}
+	var pkgs []*PythonPackage
+	for _, e := range entries {
+		if e.Name == "" {
+			continue
+		}
+		pkgs = append(pkgs, &PythonPackage{Name: e.Name, Version: e.Version, Location: e.Location})
+	}
+	return pkgs, nil
+}
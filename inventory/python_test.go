@@ -0,0 +1,93 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestGetPythonInventory(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("python inventory is only collected on linux")
+	}
+	oldCommands, oldList, oldResolve := pipCommands, pipList, resolvePip
+	defer func() { pipCommands, pipList, resolvePip = oldCommands, oldList, oldResolve }()
+
+	pipCommands = []string{"/usr/bin/pip3", "/usr/bin/pip", "/usr/local/bin/pip3", "/usr/local/bin/pip"}
+	resolvePip = func(pip string) (string, error) {
+		switch pip {
+		case "/usr/bin/pip":
+			return "/usr/bin/pip3", nil
+		case "/usr/local/bin/pip":
+			return "", errors.New("not found")
+		}
+		return pip, nil
+	}
+	var ran []string
+	outputs := map[string]string{
+		"/usr/bin/pip3":       `[{"name": "requests", "version": "2.22.0", "location": "/usr/lib/python3/dist-packages", "installer": ""}, {"name": "PyYAML", "version": "5.3.1", "location": "/usr/lib/python3/dist-packages"}]`,
+		"/usr/local/bin/pip3": `[{"name": "requests", "version": "2.31.0", "location": "/usr/local/lib/python3.11/site-packages", "installer": "pip"}, {"name": "PyYAML", "version": "5.3.1", "location": "/usr/lib/python3/dist-packages"}]`,
+	}
+	pipList = func(_ context.Context, pip string) ([]byte, error) {
+		ran = append(ran, pip)
+		return []byte(outputs[pip]), nil
+	}
+
+	want := &PythonInventory{Packages: []*PythonPackage{
+		{Name: "PyYAML", Version: "5.3.1", Location: "/usr/lib/python3/dist-packages"},
+		{Name: "requests", Version: "2.22.0", Location: "/usr/lib/python3/dist-packages"},
+		{Name: "requests", Version: "2.31.0", Location: "/usr/local/lib/python3.11/site-packages"},
+	}}
+	if got := GetPythonInventory(context.Background()); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPythonInventory() = %+v, want %+v", got, want)
+	}
+	if want := []string{"/usr/bin/pip3", "/usr/local/bin/pip3"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran pip %q, want %q", ran, want)
+	}
+
+	pipList = func(context.Context, string) ([]byte, error) { return nil, errors.New("exit status 1") }
+	if got := GetPythonInventory(context.Background()); got != nil {
+		t.Errorf("GetPythonInventory() with failing pip = %+v, want nil", got)
+	}
+}
+
+func TestParsePipList(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    []*PythonPackage
+		wantErr bool
+	}{
+		{"empty", "[]", nil, false},
+		{"no location", `[{"name": "six", "version": "1.16.0"}]`, []*PythonPackage{{Name: "six", Version: "1.16.0"}}, false},
+		{"skips nameless", `[{"name": "", "version": "1.0"}, {"name": "six", "version": "1.16.0", "location": "/usr/lib/python3/dist-packages"}]`, []*PythonPackage{{Name: "six", Version: "1.16.0", Location: "/usr/lib/python3/dist-packages"}}, false},
+		{"bad json", "WARNING: pip is being invoked by an old script wrapper", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePipList([]byte(tt.out))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePipList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePipList() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// RepositoryInventory lists the package repositories configured on the host.
+type RepositoryInventory struct {
+	Repositories []*Repository `json:"repositories,omitempty"`
+}
+
+// Repository is a single configured package repository.
+type Repository struct {
+	// ID identifies the repository across reports, it is of the form
+	// "<manager>:<file>:<name or url>".
+	ID      string `json:"id"`
+	Manager string `json:"manager"`
+	Name    string `json:"name,omitempty"`
+	URL     string `json:"url,omitempty"`
+	File    string `json:"file"`
+	Enabled bool   `json:"enabled"`
+	// Managed is set if the repository was written by the agent.
+	Managed bool `json:"managed"`
+}
+
+var (
+	aptSourcesList = "/etc/apt/sources.list"
+	aptRepoDir     = agentconfig.AptRepoDir()
+	yumRepoDir     = agentconfig.YumRepoDir()
+	zypperRepoDir  = agentconfig.ZypperRepoDir()
+	googetRepoDir  = agentconfig.GooGetRepoDir()
+)
+
+type repoParser func(r io.Reader) ([]*Repository, error)
+
+type repoFile struct {
+	manager, path string
+	parse         repoParser
+}
+
+// GetRepositories returns the package repositories configured for apt, yum,
+// zypper and googet, repositories written by the agent are marked managed.
+func GetRepositories(ctx context.Context) *RepositoryInventory {
+	var files []repoFile
+	add := func(manager, glob string, parse repoParser) {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			clog.Debugf(ctx, "Error listing repo files %q: %v", glob, err)
+			return
+		}
+		for _, m := range matches {
+			files = append(files, repoFile{manager, m, parse})
+		}
+	}
+	add("apt", aptSourcesList, parseAptList)
+	add("apt", filepath.Join(aptRepoDir, "*.list"), parseAptList)
+	add("apt", filepath.Join(aptRepoDir, "*.sources"), parseDeb822)
+	add("yum", filepath.Join(yumRepoDir, "*.repo"), parseRepoINI)
+	add("zypper", filepath.Join(zypperRepoDir, "*.repo"), parseRepoINI)
+	add("googet", filepath.Join(googetRepoDir, "*.repo"), parseGooGetRepo)
+
+	inv := &RepositoryInventory{}
+	for _, f := range files {
+		repos, err := readRepoFile(f.path, f.parse)
+		if err != nil {
+			clog.Warningf(ctx, "Error reading repo file %q: %v", f.path, err)
+			continue
+		}
+		managed := isManagedRepoFile(f.manager, f.path)
+		for _, r := range repos {
+			r.Manager = f.manager
+			r.File = f.path
+			r.Managed = managed
+			// Repository URLs may carry credentials.
+			r.URL = util.StripURLCredentials(r.URL)
+			key := r.Name
+			if key == "" {
+				key = r.URL
+			}
+			r.ID = strings.Join([]string{r.Manager, r.File, key}, ":")
+		}
+		inv.Repositories = append(inv.Repositories, repos...)
+	}
+	if len(inv.Repositories) == 0 {
+		return nil
+	}
+	return inv
+}
+
+func readRepoFile(path string, parse repoParser) ([]*Repository, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parse(f)
+}
+
+// isManagedRepoFile reports whether path was written by the agent, either
+// for an OS policy or a guest policy.
+func isManagedRepoFile(manager, path string) bool {
+	var formats, policyFiles []string
+	switch manager {
+	case "apt":
+		formats = []string{agentconfig.AptRepoFormat(), agentconfig.AptSourcesFormat()}
+		policyFiles = []string{agentconfig.AptRepoFilePath(), agentconfig.AptSourcesFilePath()}
+	case "yum":
+		formats, policyFiles = []string{agentconfig.YumRepoFormat()}, []string{agentconfig.YumRepoFilePath()}
+	case "zypper":
+		formats, policyFiles = []string{agentconfig.ZypperRepoFormat()}, []string{agentconfig.ZypperRepoFilePath()}
+	case "googet":
+		formats, policyFiles = []string{agentconfig.GooGetRepoFormat()}, []string{agentconfig.GooGetRepoFilePath()}
+	default:
+		return false
+	}
+	base := filepath.Base(path)
+	for _, f := range policyFiles {
+		if base == filepath.Base(f) {
+			return true
+		}
+	}
+	for _, format := range formats {
+		if ok, _ := filepath.Match(filepath.Base(fmt.Sprintf(format, "*")), base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAptList parses one-line style sources.list entries, commented out
+// entries are reported as disabled.
+func parseAptList(r io.Reader) ([]*Repository, error) {
+	var repos []*Repository
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+		enabled := true
+		if strings.HasPrefix(ln, "#") {
+			enabled = false
+			ln = strings.TrimSpace(strings.TrimLeft(ln, "#"))
+		}
+		fields := strings.Fields(ln)
+		if len(fields) < 3 || (fields[0] != "deb" && fields[0] != "deb-src") {
+			continue
+		}
+		fields = fields[1:]
+		if strings.HasPrefix(fields[0], "[") {
+			for len(fields) > 0 && !strings.HasSuffix(fields[0], "]") {
+				fields = fields[1:]
+			}
+			if len(fields) < 3 {
+				continue
+			}
+			fields = fields[1:]
+		}
+		repos = append(repos, &Repository{URL: fields[0], Name: strings.Join(fields[1:], " "), Enabled: enabled})
+	}
+	return repos, scanner.Err()
+}
+
+// parseDeb822 parses deb822 style .sources files.
+func parseDeb822(r io.Reader) ([]*Repository, error) {
+	var repos []*Repository
+	stanza := map[string]string{}
+	flush := func() {
+		if len(stanza) == 0 {
+			return
+		}
+		enabled := !isFalse(stanza["enabled"])
+		name := strings.TrimSpace(stanza["suites"] + " " + stanza["components"])
+		for _, uri := range strings.Fields(stanza["uris"]) {
+			repos = append(repos, &Repository{URL: uri, Name: name, Enabled: enabled})
+		}
+		stanza = map[string]string{}
+	}
+
+	var last string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ln := scanner.Text()
+		switch {
+		case strings.TrimSpace(ln) == "":
+			flush()
+		case strings.HasPrefix(ln, "#"):
+		case strings.HasPrefix(ln, " ") || strings.HasPrefix(ln, "\t"):
+			if last != "" {
+				stanza[last] += " " + strings.TrimSpace(ln)
+			}
+		default:
+			key, value, ok := strings.Cut(ln, ":")
+			if !ok {
+				continue
+			}
+			last = strings.ToLower(strings.TrimSpace(key))
+			stanza[last] = strings.TrimSpace(value)
+		}
+	}
+	flush()
+	return repos, scanner.Err()
+}
+
+// parseRepoINI parses yum and zypper .repo files.
+func parseRepoINI(r io.Reader) ([]*Repository, error) {
+	var repos []*Repository
+	var cur *Repository
+	var last string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ln := scanner.Text()
+		trimmed := strings.TrimSpace(ln)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			cur = &Repository{Name: trimmed[1 : len(trimmed)-1], Enabled: true}
+			repos = append(repos, cur)
+			last = ""
+		case cur == nil:
+		case strings.HasPrefix(ln, " ") || strings.HasPrefix(ln, "\t"):
+			// Continuation of a multi value key such as baseurl.
+			if last == "baseurl" && cur.URL == "" {
+				cur.URL = trimmed
+			}
+		default:
+			key, value, ok := strings.Cut(trimmed, "=")
+			if !ok {
+				continue
+			}
+			last = strings.ToLower(strings.TrimSpace(key))
+			value = strings.TrimSpace(value)
+			switch last {
+			case "enabled":
+				cur.Enabled = !isFalse(value)
+			case "baseurl", "mirrorlist", "metalink":
+				if cur.URL == "" {
+					cur.URL, _, _ = strings.Cut(value, " ")
+				}
+			}
+		}
+	}
+	return repos, scanner.Err()
+}
+
+// parseGooGetRepo parses googet .repo files.
+func parseGooGetRepo(r io.Reader) ([]*Repository, error) {
+	var repos []*Repository
+	var cur *Repository
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(ln, "- ") {
+			cur = &Repository{Enabled: true}
+			repos = append(repos, cur)
+			ln = strings.TrimSpace(strings.TrimPrefix(ln, "- "))
+		}
+		if cur == nil {
+			continue
+		}
+		key, value, ok := strings.Cut(ln, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "name":
+			cur.Name = strings.TrimSpace(value)
+		case "url":
+			cur.URL = strings.TrimSpace(value)
+		}
+	}
+	return repos, scanner.Err()
+}
+
+func isFalse(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "0", "no", "false", "off":
+		return true
+	}
+	return false
+}
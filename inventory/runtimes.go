@@ -0,0 +1,165 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// RuntimeInventory lists the versions of commonly audited system libraries
+// and language runtimes, taken directly from the binaries so that questions
+// like "which hosts still run OpenSSL 1.1" don't need package correlation.
+type RuntimeInventory struct {
+	Runtimes []*RuntimeVersion `json:"runtimes,omitempty"`
+}
+
+// RuntimeVersion is the version of a single library or runtime.
+type RuntimeVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type runtimeProbe struct {
+	name string
+	cmd  string
+	args []string
+	re   *regexp.Regexp
+}
+
+var (
+	runtimeProbes = []runtimeProbe{
+		// glibc 2.31
+		{"glibc", "getconf", []string{"GNU_LIBC_VERSION"}, regexp.MustCompile(`glibc (\S+)`)},
+		// OpenSSL 1.1.1f  31 Mar 2020
+		{"openssl", "openssl", []string{"version"}, regexp.MustCompile(`OpenSSL (\S+)`)},
+		// Python 3.8.10
+		{"python3", "python3", []string{"--version"}, regexp.MustCompile(`Python (\S+)`)},
+		// openjdk version "11.0.20" 2023-07-18
+		{"java", "java", []string{"-version"}, regexp.MustCompile(`version "([^"]+)"`)},
+	}
+
+	// runtimeProbeTimeout is the max time a single version command may run,
+	// a cold JVM can take a few seconds to start.
+	runtimeProbeTimeout = 10 * time.Second
+
+	errNotInstalled = errors.New("not installed")
+
+	runtimeCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return nil, errNotInstalled
+		}
+		ctx, cancel := context.WithTimeout(ctx, runtimeProbeTimeout)
+		defer cancel()
+		stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, path, args...))
+		// java -version writes to stderr.
+		return append(stdout, stderr...), err
+	}
+
+	lookJava = func() (string, error) {
+		path, err := exec.LookPath("java")
+		if err != nil {
+			return "", err
+		}
+		return filepath.EvalSymlinks(path)
+	}
+)
+
+// javaReleaseVersion reads the version of the java runtime on the PATH from
+// the release file of its JDK or JRE, so that no JVM has to be started.
+func javaReleaseVersion() string {
+	java, err := lookJava()
+	if err != nil {
+		return ""
+	}
+	// <home>/bin/java, or <jdk>/jre/bin/java for Java 8 JREs inside a JDK.
+	home := filepath.Dir(filepath.Dir(java))
+	for _, dir := range []string{home, filepath.Dir(home)} {
+		data, err := os.ReadFile(filepath.Join(dir, "release"))
+		if err != nil {
+			continue
+		}
+		if v := parseJavaRelease(data); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseJavaRelease returns JAVA_VERSION from a release file.
+func parseJavaRelease(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "JAVA_VERSION="); ok {
+			return strings.Trim(v, `"`)
+		}
+	}
+	return ""
+}
+
+// GetRuntimeInventory reports the installed glibc, OpenSSL, Python and Java
+// versions, runtimes that are not installed are omitted.
+func GetRuntimeInventory(ctx context.Context) *RuntimeInventory {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	inv := &RuntimeInventory{}
+	for _, p := range runtimeProbes {
+		if p.name == "java" {
+			if v := javaReleaseVersion(); v != "" {
+				inv.Runtimes = append(inv.Runtimes, &RuntimeVersion{Name: p.name, Version: v})
+				continue
+			}
+		}
+		out, err := runtimeCommand(ctx, p.cmd, p.args...)
+		if err == errNotInstalled {
+			continue
+		}
+		if err != nil {
+			clog.Debugf(ctx, "Error getting %s version: %v, output: %s", p.name, err, out)
+			continue
+		}
+		v := parseRuntimeVersion(p.re, out)
+		if v == "" {
+			clog.Debugf(ctx, "Unable to parse %s version from %q", p.name, out)
+			continue
+		}
+		inv.Runtimes = append(inv.Runtimes, &RuntimeVersion{Name: p.name, Version: v})
+	}
+	if len(inv.Runtimes) == 0 {
+		return nil
+	}
+	return inv
+}
+
+func parseRuntimeVersion(re *regexp.Regexp, out []byte) string {
+	m := re.FindSubmatch(out)
+	if m == nil {
+		return ""
+	}
+	return string(m[1])
+}
@@ -0,0 +1,106 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestGetRuntimeInventory(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("runtime inventory is only collected on linux")
+	}
+	outputs := map[string]string{
+		"getconf": "glibc 2.31\n",
+		"openssl": "OpenSSL 1.1.1f  31 Mar 2020\n",
+		"java":    "openjdk version \"11.0.20\" 2023-07-18\nOpenJDK Runtime Environment (build 11.0.20+8)\n",
+	}
+	old, oldLook := runtimeCommand, lookJava
+	defer func() { runtimeCommand, lookJava = old, oldLook }()
+	lookJava = func() (string, error) { return "", errNotInstalled }
+	runtimeCommand = func(_ context.Context, name string, _ ...string) ([]byte, error) {
+		if name == "python3" {
+			return nil, errNotInstalled
+		}
+		out, ok := outputs[name]
+		if !ok {
+			return nil, errors.New("unexpected command")
+		}
+		return []byte(out), nil
+	}
+
+	want := &RuntimeInventory{Runtimes: []*RuntimeVersion{
+		{Name: "glibc", Version: "2.31"},
+		{Name: "openssl", Version: "1.1.1f"},
+		{Name: "java", Version: "11.0.20"},
+	}}
+	if got := GetRuntimeInventory(context.Background()); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRuntimeInventory() = %+v, want %+v", got, want)
+	}
+}
+
+func TestJavaReleaseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		release string
+		java    string
+	}{
+		{"jdk", "release", "bin/java"},
+		{"jre in jdk", "release", "jre/bin/java"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, tt.release), []byte("IMPLEMENTOR=\"Eclipse Adoptium\"\nJAVA_VERSION=\"17.0.8\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		oldLook := lookJava
+		lookJava = func() (string, error) { return filepath.Join(dir, tt.java), nil }
+		got := javaReleaseVersion()
+		lookJava = oldLook
+		if got != "17.0.8" {
+			t.Errorf("%s: javaReleaseVersion() = %q, want %q", tt.name, got, "17.0.8")
+		}
+	}
+}
+
+func TestParseRuntimeVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want string
+	}{
+		{"openssl", "OpenSSL 3.0.2 15 Mar 2022 (Library: OpenSSL 3.0.2 15 Mar 2022)", "3.0.2"},
+		{"python3", "Python 3.11.2", "3.11.2"},
+		{"java", "java version \"1.8.0_392\"", "1.8.0_392"},
+		{"glibc", "unexpected", ""},
+	}
+	for _, tt := range tests {
+		var re = runtimeProbes[0].re
+		for _, p := range runtimeProbes {
+			if p.name == tt.name {
+				re = p.re
+			}
+		}
+		if got := parseRuntimeVersion(re, []byte(tt.out)); got != tt.want {
+			t.Errorf("%s: parseRuntimeVersion(%q) = %q, want %q", tt.name, tt.out, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// PackageVerification lists the files of installed packages that no longer
+// match their package, as reported by rpm --verify and debsums. Packages
+// are verified a time budget at a time, so a full pass may span several
+// inventory runs.
+type PackageVerification struct {
+	Findings []*packages.VerifyFinding `json:"findings,omitempty"`
+	// Verified is the number of installed packages with results, out of
+	// Total verifiable packages.
+	Verified int `json:"verified"`
+	Total    int `json:"total"`
+	// Omitted is the number of findings left out of Findings to keep the
+	// report bounded.
+	Omitted int `json:"omitted,omitempty"`
+	// LastCompleted is when a pass over all packages last finished.
+	LastCompleted string `json:"lastCompleted,omitempty"`
+}
+
+var (
+	verifyPackage = func(ctx context.Context, manager, name string) ([]*packages.VerifyFinding, error) {
+		if manager == "rpm" {
+			return packages.VerifyRPMPackage(ctx, name)
+		}
+		return packages.VerifyDebPackage(ctx, name)
+	}
+
+	verifyNow = time.Now
+
+	packageVerifier = &verifier{}
+)
+
+const (
+	// maxPackageFindings is the number of findings kept per package, a
+	// package with many changed files does not crowd out the others.
+	maxPackageFindings = 100
+	// maxVerifyFindings is the number of findings reported.
+	maxVerifyFindings = 1000
+)
+
+// verifier keeps verification results across inventory runs, resuming
+// where the previous run's budget ran out.
+type verifier struct {
+	mx            sync.Mutex
+	cursor        string
+	results       map[string]verifyResult
+	lastCompleted time.Time
+}
+
+// verifyResult holds the findings kept for a package and how many more
+// were dropped.
+type verifyResult struct {
+	findings []*packages.VerifyFinding
+	omitted  int
+}
+
+type verifyTarget struct {
+	manager, name string
+}
+
+func (t verifyTarget) key() string {
+	return t.manager + ":" + t.name
+}
+
+// verifyTargets lists the installed packages that can be verified, sorted
+// by key.
+func verifyTargets(installed *packages.Packages) []verifyTarget {
+	if installed == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var targets []verifyTarget
+	add := func(manager string, pkgs []*packages.PkgInfo) {
+		for _, p := range pkgs {
+			t := verifyTarget{manager, p.Name}
+			// Multilib packages are installed once per architecture.
+			if seen[t.key()] {
+				continue
+			}
+			seen[t.key()] = true
+			targets = append(targets, t)
+		}
+	}
+	if packages.RPMExists {
+		add("rpm", installed.Rpm)
+	}
+	if packages.DebsumsExists {
+		add("deb", installed.Deb)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].key() < targets[j].key() })
+	return targets
+}
+
+// run verifies packages after the cursor until the budget runs out or a
+// pass completes. A package that alone takes longer than the budget is
+// skipped, so the cursor never stalls on it.
+func (v *verifier) run(ctx context.Context, targets []verifyTarget, budget time.Duration) *PackageVerification {
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	// Drop results of packages that were removed.
+	installed := map[string]bool{}
+	for _, t := range targets {
+		installed[t.key()] = true
+	}
+	for k := range v.results {
+		if !installed[k] {
+			delete(v.results, k)
+		}
+	}
+	if v.results == nil {
+		v.results = map[string]verifyResult{}
+	}
+
+	start := sort.Search(len(targets), func(i int) bool { return targets[i].key() > v.cursor })
+	deadline := verifyNow().Add(budget)
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+	for i, t := range targets[start:] {
+		if !verifyNow().Before(deadline) {
+			break
+		}
+		findings, err := verifyPackage(ctx, t.manager, t.name)
+		if err != nil {
+			if ctx.Err() != nil {
+				if i > 0 {
+					break
+				}
+				// Retrying with the same budget would not finish either.
+				clog.Warningf(ctx, "Verifying %s package %q takes longer than the verification budget of %s, skipping it.", t.manager, t.name, budget)
+				v.cursor = t.key()
+				break
+			}
+			clog.Debugf(ctx, "Error verifying %s package %q: %v", t.manager, t.name, err)
+		} else {
+			var r verifyResult
+			if len(findings) > maxPackageFindings {
+				r.omitted = len(findings) - maxPackageFindings
+				findings = findings[:maxPackageFindings]
+			}
+			r.findings = findings
+			v.results[t.key()] = r
+		}
+		v.cursor = t.key()
+	}
+	if len(targets) > 0 && v.cursor >= targets[len(targets)-1].key() {
+		v.cursor = ""
+		v.lastCompleted = verifyNow()
+	}
+
+	pv := &PackageVerification{Verified: len(v.results), Total: len(targets)}
+	for _, t := range targets {
+		r := v.results[t.key()]
+		findings := r.findings
+		pv.Omitted += r.omitted
+		if n := maxVerifyFindings - len(pv.Findings); len(findings) > n {
+			pv.Omitted += len(findings) - n
+			findings = findings[:n]
+		}
+		pv.Findings = append(pv.Findings, findings...)
+	}
+	if !v.lastCompleted.IsZero() {
+		pv.LastCompleted = v.lastCompleted.UTC().Format(time.RFC3339)
+	}
+	return pv
+}
+
+// getPackageVerification verifies installed packages when a verification
+// budget is configured.
+func getPackageVerification(ctx context.Context, installed *packages.Packages) *PackageVerification {
+	budget := agentconfig.PackageVerificationBudget()
+	if budget <= 0 {
+		return nil
+	}
+	targets := verifyTargets(installed)
+	if len(targets) == 0 {
+		return nil
+	}
+	pv := packageVerifier.run(ctx, targets, budget)
+	for _, f := range pv.Findings {
+		if !f.Config {
+			clog.Warningf(ctx, "File %q of package %q does not match the package: %s.", f.Path, f.Package, f.Problem)
+		}
+	}
+	return pv
+}
@@ -0,0 +1,137 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+func TestVerifierRun(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func(context.Context, string, string) ([]*packages.VerifyFinding, error), n func() time.Time) {
+		verifyPackage, verifyNow = f, n
+	}(verifyPackage, verifyNow)
+
+	// Each package takes a minute to verify.
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	verifyNow = func() time.Time { return now }
+	var verified []string
+	verifyPackage = func(ctx context.Context, manager, name string) ([]*packages.VerifyFinding, error) {
+		now = now.Add(time.Minute)
+		verified = append(verified, name)
+		switch name {
+		case "bash":
+			return []*packages.VerifyFinding{{Package: name, Path: "/usr/bin/bash", Problem: "..5......"}}, nil
+		case "broken":
+			return nil, errors.New("error")
+		}
+		return nil, nil
+	}
+
+	targets := []verifyTarget{{"rpm", "bash"}, {"rpm", "broken"}, {"rpm", "glibc"}}
+	v := &verifier{}
+
+	got := v.run(ctx, targets, 2*time.Minute)
+	want := &PackageVerification{
+		Findings: []*packages.VerifyFinding{{Package: "bash", Path: "/usr/bin/bash", Problem: "..5......"}},
+		Verified: 1,
+		Total:    3,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("first run = %+v, want %+v", got, want)
+	}
+
+	// The next run resumes after the last package and completes the pass.
+	got = v.run(ctx, targets, 2*time.Minute)
+	want.Verified = 2
+	want.LastCompleted = "2024-01-01T00:03:00Z"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("second run = %+v, want %+v", got, want)
+	}
+	if wantVerified := []string{"bash", "broken", "glibc"}; !reflect.DeepEqual(verified, wantVerified) {
+		t.Errorf("verified %q, want %q", verified, wantVerified)
+	}
+
+	// Results of removed packages are dropped and the next pass starts over.
+	verified = nil
+	got = v.run(ctx, targets[1:], time.Minute)
+	want = &PackageVerification{Verified: 1, Total: 2, LastCompleted: "2024-01-01T00:03:00Z"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("third run = %+v, want %+v", got, want)
+	}
+	if wantVerified := []string{"broken"}; !reflect.DeepEqual(verified, wantVerified) {
+		t.Errorf("verified %q, want %q", verified, wantVerified)
+	}
+}
+
+func TestVerifierRunOversized(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func(context.Context, string, string) ([]*packages.VerifyFinding, error)) {
+		verifyPackage = f
+	}(verifyPackage)
+
+	var many []*packages.VerifyFinding
+	for i := 0; i < maxPackageFindings+50; i++ {
+		many = append(many, &packages.VerifyFinding{Package: "many", Problem: "missing"})
+	}
+	var verified []string
+	verifyPackage = func(ctx context.Context, manager, name string) ([]*packages.VerifyFinding, error) {
+		verified = append(verified, name)
+		if name == "huge" {
+			// Never finishes within the budget.
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return many, nil
+	}
+
+	targets := []verifyTarget{{"rpm", "huge"}, {"rpm", "many"}}
+	v := &verifier{}
+
+	// The first run is spent on huge, which is then skipped.
+	if got := v.run(ctx, targets, 10*time.Millisecond); got.Verified != 0 {
+		t.Errorf("first run verified %d packages, want 0", got.Verified)
+	}
+	got := v.run(ctx, targets, time.Minute)
+	if wantVerified := []string{"huge", "many"}; !reflect.DeepEqual(verified, wantVerified) {
+		t.Errorf("verified %q, want %q", verified, wantVerified)
+	}
+	if got.LastCompleted == "" {
+		t.Error("second run did not complete the pass")
+	}
+	if len(got.Findings) != maxPackageFindings || got.Omitted != 50 {
+		t.Errorf("got %d findings, %d omitted, want %d, 50", len(got.Findings), got.Omitted, maxPackageFindings)
+	}
+}
+
+func TestVerifyTargets(t *testing.T) {
+	defer func(r, d bool) { packages.RPMExists, packages.DebsumsExists = r, d }(packages.RPMExists, packages.DebsumsExists)
+	packages.RPMExists, packages.DebsumsExists = true, false
+
+	installed := &packages.Packages{
+		Rpm: []*packages.PkgInfo{{Name: "glibc", Arch: "x86_64"}, {Name: "bash"}, {Name: "glibc", Arch: "i686"}},
+		Deb: []*packages.PkgInfo{{Name: "bash"}},
+	}
+	want := []verifyTarget{{"rpm", "bash"}, {"rpm", "glibc"}}
+	if got := verifyTargets(installed); !reflect.DeepEqual(got, want) {
+		t.Errorf("verifyTargets() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WindowsInventory describes the patch level and runtimes of a Windows
+// instance, details auditors commonly require alongside the package list.
+type WindowsInventory struct {
+	// Build is the OS build and update build revision, e.g. "17763.5329".
+	Build          string           `json:"build,omitempty"`
+	DisplayVersion string           `json:"displayVersion,omitempty"`
+	DotNet         []*DotNetRuntime `json:"dotNet,omitempty"`
+	PowerShell     []string         `json:"powerShell,omitempty"`
+}
+
+// DotNetRuntime is an installed .NET Framework or .NET (Core) runtime.
+type DotNetRuntime struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// dotNetCoreRuntimes are the shared framework names under the dotnet
+// install's "shared" directory.
+var dotNetCoreRuntimes = []string{"Microsoft.NETCore.App", "Microsoft.AspNetCore.App", "Microsoft.WindowsDesktop.App"}
+
+// dotNetFrameworkReleases maps the minimum NDP\v4\Full Release value to a
+// .NET Framework version, newest first.
+// https://learn.microsoft.com/en-us/dotnet/framework/migration-guide/how-to-determine-which-versions-are-installed
+var dotNetFrameworkReleases = []struct {
+	release uint64
+	version string
+}{
+	{533320, "4.8.1"},
+	{528040, "4.8"},
+	{461808, "4.7.2"},
+	{461308, "4.7.1"},
+	{460798, "4.7"},
+	{394802, "4.6.2"},
+	{394254, "4.6.1"},
+	{393295, "4.6"},
+	{379893, "4.5.2"},
+	{378675, "4.5.1"},
+	{378389, "4.5"},
+}
+
+func dotNetFrameworkVersion(release uint64) string {
+	for _, r := range dotNetFrameworkReleases {
+		if release >= r.release {
+			return r.version
+		}
+	}
+	return ""
+}
+
+func windowsBuild(build string, ubr uint64) string {
+	if build == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s.%d", build, ubr)
+}
+
+// getDotNetCoreRuntimes lists the .NET (Core) runtimes installed in the
+// dotnet install directory.
+func getDotNetCoreRuntimes(dotnetDir string) []*DotNetRuntime {
+	var runtimes []*DotNetRuntime
+	for _, name := range dotNetCoreRuntimes {
+		fis, err := ioutil.ReadDir(filepath.Join(dotnetDir, "shared", name))
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			if fi.IsDir() {
+				runtimes = append(runtimes, &DotNetRuntime{Name: name, Version: fi.Name()})
+			}
+		}
+	}
+	return runtimes
+}
+
+func sortDotNetRuntimes(runtimes []*DotNetRuntime) {
+	sort.SliceStable(runtimes, func(i, j int) bool {
+		if runtimes[i].Name != runtimes[j].Name {
+			return runtimes[i].Name < runtimes[j].Name
+		}
+		return dotNetVersionLess(runtimes[i].Version, runtimes[j].Version)
+	})
+}
+
+// dotNetVersionLess compares runtime versions such as "8.0.11" numerically,
+// a prerelease like "9.0.0-preview.1" sorts before its release.
+func dotNetVersionLess(a, b string) bool {
+	a, aPre, _ := strings.Cut(a, "-")
+	b, bPre, _ := strings.Cut(b, "-")
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	if aPre == "" || bPre == "" {
+		return aPre != "" && bPre == ""
+	}
+	return aPre < bPre
+}
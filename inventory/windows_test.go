@@ -0,0 +1,85 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDotNetFrameworkVersion(t *testing.T) {
+	tests := []struct {
+		release uint64
+		want    string
+	}{
+		{0, ""},
+		{378389, "4.5"},
+		{394806, "4.6.2"},
+		{461814, "4.7.2"},
+		{528449, "4.8"},
+		{533325, "4.8.1"},
+	}
+	for _, tt := range tests {
+		if got := dotNetFrameworkVersion(tt.release); got != tt.want {
+			t.Errorf("dotNetFrameworkVersion(%d) = %q, want %q", tt.release, got, tt.want)
+		}
+	}
+}
+
+func TestWindowsBuild(t *testing.T) {
+	if got := windowsBuild("17763", 5329); got != "17763.5329" {
+		t.Errorf("windowsBuild() = %q, want %q", got, "17763.5329")
+	}
+	if got := windowsBuild("", 5329); got != "" {
+		t.Errorf("windowsBuild() = %q, want empty", got)
+	}
+}
+
+func TestGetDotNetCoreRuntimes(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{
+		"shared/Microsoft.NETCore.App/8.0.1",
+		"shared/Microsoft.NETCore.App/6.0.25",
+		"shared/Microsoft.NETCore.App/10.0.0",
+		"shared/Microsoft.NETCore.App/8.0.11",
+		"shared/Microsoft.NETCore.App/10.0.0-rc.1",
+		"shared/Microsoft.AspNetCore.App/8.0.1",
+		"shared/Unknown.App/1.0.0",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := getDotNetCoreRuntimes(dir)
+	sortDotNetRuntimes(got)
+	want := []*DotNetRuntime{
+		{Name: "Microsoft.AspNetCore.App", Version: "8.0.1"},
+		{Name: "Microsoft.NETCore.App", Version: "6.0.25"},
+		{Name: "Microsoft.NETCore.App", Version: "8.0.1"},
+		{Name: "Microsoft.NETCore.App", Version: "8.0.11"},
+		{Name: "Microsoft.NETCore.App", Version: "10.0.0-rc.1"},
+		{Name: "Microsoft.NETCore.App", Version: "10.0.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getDotNetCoreRuntimes() = %+v, want %+v", got, want)
+	}
+
+	if got := getDotNetCoreRuntimes(filepath.Join(dir, "missing")); got != nil {
+		t.Errorf("getDotNetCoreRuntimes(missing) = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,147 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	currentVersionKey = `SOFTWARE\Microsoft\Windows NT\CurrentVersion`
+	ndpKey            = `SOFTWARE\Microsoft\NET Framework Setup\NDP`
+	powerShellKey     = `SOFTWARE\Microsoft\PowerShell`
+	powerShellCoreKey = `SOFTWARE\Microsoft\PowerShellCore\InstalledVersions`
+)
+
+// GetWindowsInventory reports the OS build, .NET and PowerShell versions.
+func GetWindowsInventory(ctx context.Context) *WindowsInventory {
+	wi := &WindowsInventory{}
+	if err := getOSBuild(wi); err != nil {
+		clog.Errorf(ctx, "Error reading Windows build information: %v", err)
+	}
+
+	wi.DotNet = getDotNetFrameworks(ctx)
+	programFiles := os.Getenv("ProgramFiles")
+	if programFiles == "" {
+		programFiles = `C:\Program Files`
+	}
+	wi.DotNet = append(wi.DotNet, getDotNetCoreRuntimes(filepath.Join(programFiles, "dotnet"))...)
+	sortDotNetRuntimes(wi.DotNet)
+
+	wi.PowerShell = getPowerShellVersions(ctx)
+	return wi
+}
+
+func getOSBuild(wi *WindowsInventory) error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, currentVersionKey, registry.QUERY_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	build, _, err := k.GetStringValue("CurrentBuildNumber")
+	if err != nil {
+		return err
+	}
+	// UBR is missing on builds that predate Windows 10 / Server 2016.
+	ubr, _, _ := k.GetIntegerValue("UBR")
+	wi.Build = windowsBuild(build, ubr)
+
+	displayVersion, _, _ := k.GetStringValue("DisplayVersion")
+	releaseID, _, _ := k.GetStringValue("ReleaseId")
+	wi.DisplayVersion = osinfo.WindowsDisplayVersion(displayVersion, releaseID)
+	return nil
+}
+
+func getDotNetFrameworks(ctx context.Context) []*DotNetRuntime {
+	var runtimes []*DotNetRuntime
+	// Versions prior to 4 each have their own key with an Install flag.
+	for _, v := range []string{"v2.0.50727", "v3.0", "v3.5"} {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, ndpKey+`\`+v, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		if install, _, err := k.GetIntegerValue("Install"); err == nil && install == 1 {
+			version, _, _ := k.GetStringValue("Version")
+			runtimes = append(runtimes, &DotNetRuntime{Name: ".NET Framework", Version: version})
+		}
+		k.Close()
+	}
+
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, ndpKey+`\v4\Full`, registry.QUERY_VALUE)
+	if err != nil {
+		if err != registry.ErrNotExist {
+			clog.Debugf(ctx, "Error opening .NET Framework 4 key: %v", err)
+		}
+		return runtimes
+	}
+	defer k.Close()
+	version, _, _ := k.GetStringValue("Version")
+	// Release identifies 4.5 and later, where Version stays at 4.x.y.
+	if release, _, err := k.GetIntegerValue("Release"); err == nil {
+		if v := dotNetFrameworkVersion(release); v != "" {
+			version = v
+		}
+	}
+	if version != "" {
+		runtimes = append(runtimes, &DotNetRuntime{Name: ".NET Framework", Version: version})
+	}
+	return runtimes
+}
+
+func getPowerShellVersions(ctx context.Context) []string {
+	var versions []string
+	// Windows PowerShell 1.0/2.0 register under 1, 3.0 and later under 3.
+	for _, engine := range []string{"1", "3"} {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, powerShellKey+`\`+engine+`\PowerShellEngine`, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		if v, _, err := k.GetStringValue("PowerShellVersion"); err == nil {
+			versions = append(versions, v)
+		}
+		k.Close()
+	}
+
+	// PowerShell 7+ registers each install under its own GUID.
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, powerShellCoreKey, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return versions
+	}
+	defer k.Close()
+	names, err := k.ReadSubKeyNames(0)
+	if err != nil {
+		clog.Debugf(ctx, "Error reading PowerShell installs: %v", err)
+		return versions
+	}
+	for _, name := range names {
+		sk, err := registry.OpenKey(k, name, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		if v, _, err := sk.GetStringValue("SemanticVersion"); err == nil && strings.TrimSpace(v) != "" {
+			versions = append(versions, v)
+		}
+		sk.Close()
+	}
+	return versions
+}
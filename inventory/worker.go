@@ -0,0 +1,87 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// WorkerArg is the agent argument that runs the inventory worker, which
+// writes the output of Collect to stdout as JSON.
+const WorkerArg = "inventoryworker"
+
+var (
+	// workerTimeout is the max time the inventory worker may run.
+	workerTimeout = 30 * time.Minute
+	// workerMaxOutput is the max size of the inventory worker's output.
+	workerMaxOutput = 64 * 1024 * 1024
+
+	workerCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, name, args...)
+	}
+	workerGeteuid = os.Geteuid
+)
+
+// RunWorker is the inventory worker, it writes the output of Collect to w as
+// JSON. It refuses to run as root, where it would parse package manager
+// output with full privileges.
+func RunWorker(ctx context.Context, w io.Writer) error {
+	if workerGeteuid() == 0 {
+		return errors.New("the inventory worker must not run as root")
+	}
+	return json.NewEncoder(w).Encode(Collect(ctx))
+}
+
+// GetFromWorker generates inventory data like Get, but collects everything
+// that does not require root in a child process running as user. This keeps
+// the parsing of package manager output out of the privileged agent.
+func GetFromWorker(ctx context.Context, user string) (*InstanceInventory, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, workerTimeout)
+	defer cancel()
+
+	cmd := workerCommand(ctx, exe, WorkerArg)
+	if err := dropPrivileges(cmd, user); err != nil {
+		return nil, err
+	}
+	stdout := &limitedBuffer{max: workerMaxOutput}
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error running inventory worker as %q: %v, stderr: %q", user, err, stderr.String())
+	}
+	if stdout.overflow {
+		return nil, fmt.Errorf("inventory worker output exceeds %d bytes", workerMaxOutput)
+	}
+
+	var inv InstanceInventory
+	if err := json.Unmarshal(stdout.Bytes(), &inv); err != nil {
+		return nil, fmt.Errorf("error parsing inventory worker output: %v", err)
+	}
+	addPrivileged(ctx, &inv)
+	return &inv, nil
+}
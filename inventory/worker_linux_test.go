@@ -0,0 +1,75 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"testing"
+)
+
+func TestGetFromWorker(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running the inventory worker as another user requires root")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("no nobody user")
+	}
+	old := workerCommand
+	defer func() { workerCommand = old }()
+	workerCommand = func(ctx context.Context, _ string, _ ...string) *exec.Cmd {
+		// The worker runs as nobody, so id reports its uid.
+		return exec.CommandContext(ctx, "/bin/sh", "-c", `printf '{"Hostname":"%s","ShortName":"debian"}' "$(id -un)"`)
+	}
+
+	inv, err := GetFromWorker(context.Background(), "nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Hostname != "nobody" || inv.ShortName != "debian" {
+		t.Errorf("GetFromWorker() = %+v, want Hostname nobody and ShortName debian", inv)
+	}
+
+	workerCommand = func(ctx context.Context, _ string, _ ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "/bin/sh", "-c", "echo failed >&2; exit 1")
+	}
+	if _, err := GetFromWorker(context.Background(), "nobody"); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("GetFromWorker() error = %v, want worker stderr", err)
+	}
+}
+
+func TestRunWorkerRejectsRoot(t *testing.T) {
+	old := workerGeteuid
+	defer func() { workerGeteuid = old }()
+	workerGeteuid = func() int { return 0 }
+
+	var buf bytes.Buffer
+	if err := RunWorker(context.Background(), &buf); err == nil || !strings.Contains(err.Error(), "root") {
+		t.Errorf("RunWorker() as root error = %v, want refusal", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("RunWorker() as root wrote %q, want nothing", buf.String())
+	}
+}
+
+func TestGetFromWorkerRejectsRoot(t *testing.T) {
+	if _, err := GetFromWorker(context.Background(), "root"); err == nil || !strings.Contains(err.Error(), "is root") {
+		t.Errorf("GetFromWorker(root) error = %v, want root to be rejected", err)
+	}
+}
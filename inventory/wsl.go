@@ -0,0 +1,106 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+// WSLInventory lists the Windows Subsystem for Linux distributions registered
+// on a Windows host, so that developer workstations report the Linux
+// environments running on them.
+type WSLInventory struct {
+	Distributions []*WSLDistribution `json:"distributions,omitempty"`
+}
+
+// WSLDistribution is a WSL distribution registered by a single user.
+type WSLDistribution struct {
+	Name string `json:"name"`
+	// User is the SID of the user the distribution is registered for.
+	User    string `json:"user"`
+	Version int    `json:"version"`
+	State   string `json:"state"`
+	// Default is set for the user's default distribution.
+	Default bool `json:"default,omitempty"`
+	// Packages are the deb packages installed in a WSL 1 distribution, they
+	// are only listed if osconfig-wsl-package-inventory is enabled.
+	Packages []*packages.PkgInfo `json:"packages,omitempty"`
+	// PackagesError is set if the distribution's packages could not be read.
+	PackagesError string `json:"packagesError,omitempty"`
+
+	basePath string
+}
+
+// wslStates are the values of the State registry value of a distribution.
+var wslStates = map[uint64]string{
+	1: "INSTALLED",
+	2: "INSTALLING",
+	3: "UNINSTALLING",
+	4: "CONVERTING",
+}
+
+func wslState(state uint64) string {
+	if s, ok := wslStates[state]; ok {
+		return s
+	}
+	return "UNKNOWN"
+}
+
+// wslVersion returns the WSL version of a distribution, older builds don't
+// write the Version value and only set the WSL 2 flag.
+func wslVersion(version, flags uint64) int {
+	switch {
+	case version != 0:
+		return int(version)
+	case flags&0x8 != 0:
+		return 2
+	default:
+		return 1
+	}
+}
+
+var wslPackages = packages.DpkgStatusPackagesUnder
+
+// addWSLPackages reads the dpkg database of each installed WSL 1
+// distribution from its rootfs directory, distributions that don't use dpkg
+// report an error instead. WSL 2 distributions are stored in a virtual disk
+// that is only shared with the session of the user running them, so
+// LocalSystem can't read their packages and they are skipped.
+func addWSLPackages(ctx context.Context, dists []*WSLDistribution) {
+	for _, d := range dists {
+		if d.State != "INSTALLED" || d.Version != 1 {
+			continue
+		}
+		pkgs, err := wslPackages(ctx, filepath.Join(d.basePath, "rootfs"))
+		if err != nil {
+			d.PackagesError = err.Error()
+			continue
+		}
+		d.Packages = pkgs
+	}
+}
+
+func sortWSLDistributions(dists []*WSLDistribution) {
+	sort.SliceStable(dists, func(i, j int) bool {
+		if dists[i].User != dists[j].User {
+			return dists[i].User < dists[j].User
+		}
+		return dists[i].Name < dists[j].Name
+	})
+}
@@ -1,4 +1,4 @@
-//  Copyright 2019 Google Inc. All Rights Reserved.
+//  Copyright 2024 Google Inc. All Rights Reserved.
 //
 //  Licensed under the Apache License, Version 2.0 (the "License");
 //  you may not use this file except in compliance with the License.
@@ -15,21 +15,16 @@
 //go:build !windows
 // +build !windows
 
-package packages
+package inventory
 
 import "context"
 
-// InstallMSIPackage is a linux stub function.
-func InstallMSIPackage(_ context.Context, _ string, _ []string) error {
+// GetWSLInventory is a linux stub function.
+func GetWSLInventory(_ context.Context) *WSLInventory {
 	return nil
 }
 
-// MSIInfo is a linux stub function.
-func MSIInfo(_ string) (string, string, error) {
-	return "", "", nil
-}
-
-// MSIInstalled is a linux stub function.
-func MSIInstalled(_ string) (bool, error) {
-	return false, nil
+// GetWSLPackages is a linux stub function.
+func GetWSLPackages(_ context.Context) []*WSLDistribution {
+	return nil
 }
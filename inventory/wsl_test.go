@@ -0,0 +1,104 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+func TestWSLVersion(t *testing.T) {
+	tests := []struct {
+		version, flags uint64
+		want           int
+	}{
+		{0, 0, 1},
+		{1, 0x7, 1},
+		{2, 0xf, 2},
+		{0, 0xf, 2},
+		{0, 0x7, 1},
+	}
+	for _, tt := range tests {
+		if got := wslVersion(tt.version, tt.flags); got != tt.want {
+			t.Errorf("wslVersion(%d, %#x) = %d, want %d", tt.version, tt.flags, got, tt.want)
+		}
+	}
+	if got := wslState(1); got != "INSTALLED" {
+		t.Errorf("wslState(1) = %q, want INSTALLED", got)
+	}
+	if got := wslState(9); got != "UNKNOWN" {
+		t.Errorf("wslState(9) = %q, want UNKNOWN", got)
+	}
+}
+
+func TestAddWSLPackages(t *testing.T) {
+	old := wslPackages
+	defer func() { wslPackages = old }()
+	var roots []string
+	wslPackages = func(_ context.Context, root string) ([]*packages.PkgInfo, error) {
+		roots = append(roots, root)
+		if root == filepath.Join("base", "rootfs") {
+			return []*packages.PkgInfo{{Name: "bash", Arch: "x86_64", Version: "5.1-6"}}, nil
+		}
+		return nil, errors.New("no dpkg database")
+	}
+
+	dists := []*WSLDistribution{
+		{Name: "Ubuntu", Version: 1, State: "INSTALLED", basePath: "base"},
+		{Name: "Alpine", Version: 1, State: "INSTALLED", basePath: "alpine"},
+		{Name: "Debian", Version: 2, State: "INSTALLED"},
+		{Name: "Fedora", Version: 1, State: "INSTALLING"},
+	}
+	addWSLPackages(context.Background(), dists)
+
+	// WSL 2 distributions can't be read by LocalSystem.
+	wantRoots := []string{filepath.Join("base", "rootfs"), filepath.Join("alpine", "rootfs")}
+	if !reflect.DeepEqual(roots, wantRoots) {
+		t.Errorf("read packages from %q, want %q", roots, wantRoots)
+	}
+	if len(dists[0].Packages) != 1 || dists[0].PackagesError != "" {
+		t.Errorf("Ubuntu: Packages = %v, PackagesError = %q, want 1 package and no error", dists[0].Packages, dists[0].PackagesError)
+	}
+	if dists[1].Packages != nil || dists[1].PackagesError != "no dpkg database" {
+		t.Errorf("Alpine: Packages = %v, PackagesError = %q, want no packages and an error", dists[1].Packages, dists[1].PackagesError)
+	}
+	for _, d := range dists[2:] {
+		if d.Packages != nil || d.PackagesError != "" {
+			t.Errorf("%s: Packages = %v, PackagesError = %q, want it skipped", d.Name, d.Packages, d.PackagesError)
+		}
+	}
+}
+
+func TestSortWSLDistributions(t *testing.T) {
+	dists := []*WSLDistribution{
+		{Name: "Ubuntu", User: "S-1-5-21-2"},
+		{Name: "Ubuntu", User: "S-1-5-21-1"},
+		{Name: "Debian", User: "S-1-5-21-2"},
+	}
+	sortWSLDistributions(dists)
+	var got []string
+	for _, d := range dists {
+		got = append(got, d.User+"/"+d.Name)
+	}
+	want := []string{"S-1-5-21-1/Ubuntu", "S-1-5-21-2/Debian", "S-1-5-21-2/Ubuntu"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sortWSLDistributions() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package inventory
+
+import (
+	"context"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"golang.org/x/sys/windows/registry"
+)
+
+const lxssKey = `Software\Microsoft\Windows\CurrentVersion\Lxss`
+
+// GetWSLInventory lists the WSL distributions registered by each user whose
+// registry hive is loaded, which is the case for users that are logged on.
+// Distributions are registered per user, wsl.exe can't list them for
+// LocalSystem.
+func GetWSLInventory(ctx context.Context) *WSLInventory {
+	dists := listWSLDistributions(ctx)
+	if len(dists) == 0 {
+		return nil
+	}
+	if agentconfig.WSLPackageInventoryEnabled() {
+		addWSLPackages(ctx, dists)
+	}
+	return &WSLInventory{Distributions: dists}
+}
+
+// GetWSLPackages lists the WSL distributions with the packages installed in
+// them, whether or not osconfig-wsl-package-inventory is enabled.
+func GetWSLPackages(ctx context.Context) []*WSLDistribution {
+	dists := listWSLDistributions(ctx)
+	addWSLPackages(ctx, dists)
+	return dists
+}
+
+func listWSLDistributions(ctx context.Context) []*WSLDistribution {
+	k, err := registry.OpenKey(registry.USERS, "", registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		clog.Errorf(ctx, "Error opening HKEY_USERS: %v", err)
+		return nil
+	}
+	sids, err := k.ReadSubKeyNames(-1)
+	k.Close()
+	if err != nil {
+		clog.Errorf(ctx, "Error listing HKEY_USERS: %v", err)
+		return nil
+	}
+
+	var dists []*WSLDistribution
+	for _, sid := range sids {
+		if sid == ".DEFAULT" || strings.HasSuffix(sid, "_Classes") {
+			continue
+		}
+		dists = append(dists, getWSLDistributions(ctx, sid)...)
+	}
+	sortWSLDistributions(dists)
+	return dists
+}
+
+func getWSLDistributions(ctx context.Context, sid string) []*WSLDistribution {
+	k, err := registry.OpenKey(registry.USERS, sid+`\`+lxssKey, registry.QUERY_VALUE|registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		// WSL has not been used by this user.
+		return nil
+	}
+	defer k.Close()
+
+	defaultID, _, _ := k.GetStringValue("DefaultDistribution")
+	ids, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		clog.Errorf(ctx, "Error listing WSL distributions of %s: %v", sid, err)
+		return nil
+	}
+
+	var dists []*WSLDistribution
+	for _, id := range ids {
+		dk, err := registry.OpenKey(k, id, registry.QUERY_VALUE)
+		if err != nil {
+			clog.Errorf(ctx, "Error opening WSL distribution %s of %s: %v", id, sid, err)
+			continue
+		}
+		name, _, err := dk.GetStringValue("DistributionName")
+		if err != nil {
+			dk.Close()
+			continue
+		}
+		basePath, _, _ := dk.GetStringValue("BasePath")
+		version, _, _ := dk.GetIntegerValue("Version")
+		flags, _, _ := dk.GetIntegerValue("Flags")
+		state, _, _ := dk.GetIntegerValue("State")
+		dk.Close()
+
+		dists = append(dists, &WSLDistribution{
+			Name:     name,
+			User:     sid,
+			Version:  wslVersion(version, flags),
+			State:    wslState(state),
+			Default:  strings.EqualFold(id, defaultID),
+			basePath: strings.TrimPrefix(basePath, `\\?\`),
+		})
+	}
+	return dists
+}
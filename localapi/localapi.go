@@ -0,0 +1,306 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package localapi serves a local control API on a unix socket so other
+// agents on the host can reuse the OS Config agent's package parsers.
+package localapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/progress"
+)
+
+var (
+	debPkgInfo        = packages.DebPkgInfo
+	rpmPkgInfo        = packages.RPMPkgInfo
+	installedPackages = packages.GetInstalledPackages
+	activeOperations  = progress.Active
+	logSettings       = clog.CurrentSettings
+	setLogSettings    = clog.SetSettings
+
+	// installedMx serializes installed package lookups, each one runs every
+	// package manager on the system.
+	installedMx sync.Mutex
+
+	requestTimeout = 5 * time.Minute
+)
+
+// InspectRequest is the body of a POST to /v1/packages:inspect.
+type InspectRequest struct {
+	// Path is the package file to inspect.
+	Path string `json:"path"`
+	// Type is "deb" or "rpm", if empty it is taken from the file extension.
+	Type string `json:"type,omitempty"`
+}
+
+// InstalledResponse is returned by GET /v1/packages/installed, packages
+// are grouped by the manager that reported them.
+type InstalledResponse map[string][]*packages.PkgInfo
+
+// OperationsResponse is returned by GET /v1/operations.
+type OperationsResponse struct {
+	Operations []progress.Operation `json:"operations"`
+}
+
+// DebugRequest is the body of a PUT to /v1/debug.
+type DebugRequest struct {
+	clog.Settings
+	// TTL is how long the settings apply, as a Go duration like "30m". If
+	// empty the settings apply until cleared or the agent restarts.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// DebugResponse is returned by /v1/debug.
+type DebugResponse struct {
+	// Settings are the runtime log settings, nil if none are in effect.
+	Settings *clog.Settings `json:"settings,omitempty"`
+	// MetadataDebug is the debug setting from metadata, used when no
+	// runtime settings are in effect.
+	MetadataDebug bool `json:"metadataDebug"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler returns the local API HTTP handler.
+func Handler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/packages:inspect", func(w http.ResponseWriter, r *http.Request) {
+		handleInspect(ctx, w, r)
+	})
+	mux.HandleFunc("/v1/packages/installed", func(w http.ResponseWriter, r *http.Request) {
+		handleInstalled(ctx, w, r)
+	})
+	mux.HandleFunc("/v1/operations", handleOperations)
+	mux.HandleFunc("/v1/debug", func(w http.ResponseWriter, r *http.Request) {
+		handleDebug(ctx, w, r)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+func handleInspect(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	var req InspectRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error decoding request: %v", err))
+		return
+	}
+	if !filepath.IsAbs(req.Path) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("path must be absolute: %q", req.Path))
+		return
+	}
+	typ := strings.ToLower(req.Type)
+	if typ == "" {
+		typ = strings.TrimPrefix(strings.ToLower(filepath.Ext(req.Path)), ".")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	var info *packages.PkgInfo
+	var err error
+	switch typ {
+	case "deb":
+		info, err = debPkgInfo(ctx, req.Path)
+	case "rpm":
+		info, err = rpmPkgInfo(ctx, req.Path)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported package type %q", typ))
+		return
+	}
+	if err != nil {
+		clog.Debugf(ctx, "Local API error inspecting %q: %v", req.Path, err)
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func handleInstalled(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	names := r.URL.Query()["name"]
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	installedMx.Lock()
+	pkgs, err := installedPackages(ctx)
+	installedMx.Unlock()
+	if pkgs == nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil {
+		// Partial results are still useful, other managers may have succeeded.
+		clog.Debugf(ctx, "Local API error listing installed packages: %v", err)
+	}
+	writeJSON(w, http.StatusOK, filterInstalled(pkgs, names))
+}
+
+func handleOperations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, OperationsResponse{Operations: activeOperations()})
+}
+
+// handleDebug reports and changes the log settings without waiting for the
+// next metadata change: GET returns them, PUT replaces them and DELETE
+// reverts to the metadata setting.
+func handleDebug(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req DebugRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("error decoding request: %v", err))
+			return
+		}
+		s := req.Settings
+		s.Expires = nil
+		if req.TTL != "" {
+			ttl, err := time.ParseDuration(req.TTL)
+			if err != nil || ttl <= 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl %q", req.TTL))
+				return
+			}
+			expires := time.Now().Add(ttl)
+			s.Expires = &expires
+		}
+		if err := setLogSettings(&s); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		clog.Infof(ctx, "Log settings changed through local API: debug=%t, traceSampleRate=%v, subsystems=%v, ttl=%q.", s.Debug, s.TraceSampleRate, s.Subsystems, req.TTL)
+	case http.MethodDelete:
+		setLogSettings(nil)
+		clog.Infof(ctx, "Log settings reset through local API.")
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, DebugResponse{Settings: logSettings(), MetadataDebug: clog.DebugEnabled()})
+}
+
+// filterInstalled groups the installed packages by manager, keeping only
+// packages in names if any are given.
+func filterInstalled(pkgs *packages.Packages, names []string) InstalledResponse {
+	want := map[string]bool{}
+	for _, n := range names {
+		want[n] = true
+	}
+	resp := InstalledResponse{}
+	for manager, list := range map[string][]*packages.PkgInfo{
+		"deb":     pkgs.Deb,
+		"rpm":     pkgs.Rpm,
+		"cos":     pkgs.COS,
+		"gem":     pkgs.Gem,
+		"pip":     pkgs.Pip,
+		"googet":  pkgs.GooGet,
+		"apk":     pkgs.Apk,
+		"pacman":  pkgs.Pacman,
+		"portage": pkgs.Portage,
+		"flatpak": pkgs.Flatpak,
+		"brew":    pkgs.Brew,
+		"npm":     pkgs.Npm,
+		"winget":  pkgs.Winget,
+	} {
+		for _, p := range list {
+			if len(want) == 0 || want[p.Name] {
+				resp[manager] = append(resp[manager], p)
+			}
+		}
+	}
+	return resp
+}
+
+// Server serves the local API on a unix socket.
+type Server struct {
+	path string
+	srv  *http.Server
+	done chan struct{}
+}
+
+// Listen starts serving the local API on the unix socket at path, only the
+// socket owner may connect.
+func Listen(ctx context.Context, path string) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating socket directory: %v", err)
+	}
+	// Remove a socket left behind by an agent that did not shut down cleanly.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error removing stale socket: %v", err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %q: %v", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error setting socket permissions: %v", err)
+	}
+
+	s := &Server{
+		path: path,
+		srv:  &http.Server{Handler: Handler(ctx), ReadHeaderTimeout: 10 * time.Second},
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		if err := s.srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			clog.Errorf(ctx, "Local API server error: %v", err)
+		}
+	}()
+	clog.Infof(ctx, "Serving local API on %q.", path)
+	return s, nil
+}
+
+// Close stops the server and removes its socket.
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	<-s.done
+	if rerr := os.Remove(s.path); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+		err = rerr
+	}
+	return err
+}
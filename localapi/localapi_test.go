@@ -0,0 +1,197 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package localapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/progress"
+)
+
+func TestInspect(t *testing.T) {
+	debPkgInfo = func(_ context.Context, path string) (*packages.PkgInfo, error) {
+		return &packages.PkgInfo{Name: "deb-pkg", Version: "1.0", Arch: "x86_64"}, nil
+	}
+	rpmPkgInfo = func(_ context.Context, path string) (*packages.PkgInfo, error) {
+		return nil, errors.New("bad rpm")
+	}
+	defer func() { debPkgInfo, rpmPkgInfo = packages.DebPkgInfo, packages.RPMPkgInfo }()
+
+	tests := []struct {
+		desc     string
+		method   string
+		body     string
+		wantCode int
+		want     string
+	}{
+		{"deb by extension", http.MethodPost, `{"path":"/tmp/foo.deb"}`, http.StatusOK, `{"Name":"deb-pkg","Arch":"x86_64","RawArch":"","Version":"1.0","Source":{"Name":"","Version":""}}`},
+		{"rpm by type", http.MethodPost, `{"path":"/tmp/foo","type":"RPM"}`, http.StatusUnprocessableEntity, `{"error":"bad rpm"}`},
+		{"relative path", http.MethodPost, `{"path":"foo.deb"}`, http.StatusBadRequest, `{"error":"path must be absolute: \"foo.deb\""}`},
+		{"unknown type", http.MethodPost, `{"path":"/tmp/foo.msi"}`, http.StatusBadRequest, `{"error":"unsupported package type \"msi\""}`},
+		{"bad body", http.MethodPost, `{`, http.StatusBadRequest, `{"error":"error decoding request: unexpected EOF"}`},
+		{"wrong method", http.MethodGet, ``, http.StatusMethodNotAllowed, `{"error":"method GET not allowed"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/v1/packages:inspect", strings.NewReader(tt.body))
+			Handler(context.Background()).ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterInstalled(t *testing.T) {
+	pkgs := &packages.Packages{
+		Deb:    []*packages.PkgInfo{{Name: "bash"}, {Name: "curl"}},
+		Rpm:    []*packages.PkgInfo{{Name: "curl"}},
+		GooGet: []*packages.PkgInfo{{Name: "googet"}},
+	}
+	got := filterInstalled(pkgs, []string{"curl"})
+	want := InstalledResponse{"deb": {{Name: "curl"}}, "rpm": {{Name: "curl"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filterInstalled() = %v, want %v", got, want)
+	}
+	if got := filterInstalled(pkgs, nil); len(got["deb"]) != 2 || len(got["googet"]) != 1 {
+		t.Errorf("filterInstalled() without names = %v, want all packages", got)
+	}
+}
+
+func TestOperations(t *testing.T) {
+	started := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	activeOperations = func() []progress.Operation {
+		return []progress.Operation{{ID: "apt-1", Manager: "apt", Phase: "applying", Percent: 50, Started: started, Updated: started}}
+	}
+	defer func() { activeOperations = progress.Active }()
+
+	rec := httptest.NewRecorder()
+	Handler(context.Background()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/operations", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	want := `{"operations":[{"id":"apt-1","manager":"apt","phase":"applying","percent":50,"started":"2024-05-01T10:00:00Z","updated":"2024-05-01T10:00:00Z"}]}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	Handler(context.Background()).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/operations", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestListen(t *testing.T) {
+	installedPackages = func(context.Context) (*packages.Packages, error) {
+		return &packages.Packages{Deb: []*packages.PkgInfo{{Name: "bash"}}}, errors.New("rpm failed")
+	}
+	defer func() { installedPackages = packages.GetInstalledPackages }()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	s, err := Listen(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://localapi/v1/packages/installed?name=bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var got InstalledResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got["deb"]) != 1 || got["deb"][0].Name != "bash" {
+		t.Errorf("installed = %v, want bash", got)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	var set *clog.Settings
+	setLogSettings = func(s *clog.Settings) error {
+		if s != nil {
+			if err := s.Validate(); err != nil {
+				return err
+			}
+		}
+		set = s
+		return nil
+	}
+	logSettings = func() *clog.Settings { return set }
+	defer func() { setLogSettings, logSettings = clog.SetSettings, clog.CurrentSettings }()
+
+	tests := []struct {
+		desc     string
+		method   string
+		body     string
+		wantCode int
+		want     string
+	}{
+		{"get without settings", http.MethodGet, ``, http.StatusOK, `{"metadataDebug":false}`},
+		{"enable debug", http.MethodPut, `{"debug":true,"traceSampleRate":0.5}`, http.StatusOK, `{"settings":{"debug":true,"traceSampleRate":0.5},"metadataDebug":false}`},
+		{"subsystem level", http.MethodPut, `{"subsystems":{"patch":"debug"}}`, http.StatusOK, `{"settings":{"debug":false,"subsystems":{"patch":"debug"}},"metadataDebug":false}`},
+		{"get settings", http.MethodGet, ``, http.StatusOK, `{"settings":{"debug":false,"subsystems":{"patch":"debug"}},"metadataDebug":false}`},
+		{"bad level", http.MethodPut, `{"subsystems":{"patch":"loud"}}`, http.StatusBadRequest, `{"error":"unknown level \"loud\" for subsystem \"patch\""}`},
+		{"bad ttl", http.MethodPut, `{"debug":true,"ttl":"soon"}`, http.StatusBadRequest, `{"error":"invalid ttl \"soon\""}`},
+		{"reset", http.MethodDelete, ``, http.StatusOK, `{"metadataDebug":false}`},
+		{"wrong method", http.MethodPost, `{}`, http.StatusMethodNotAllowed, `{"error":"method POST not allowed"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/v1/debug", strings.NewReader(tt.body))
+			Handler(context.Background()).ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	Handler(context.Background()).ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/v1/debug", strings.NewReader(`{"debug":true,"ttl":"10m"}`)))
+	if set == nil || set.Expires == nil || time.Until(*set.Expires) <= 0 {
+		t.Errorf("settings after ttl request = %+v, want an expiry in the future", set)
+	}
+}
@@ -0,0 +1,385 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package logfile implements a local log file writer with size and time
+// based rotation, compression and retention.
+package logfile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupTimeFormat = "20060102T150405.000"
+	// rotateRetryDelay is how long to wait before retrying a failed
+	// rotation, the log file keeps being written to meanwhile.
+	rotateRetryDelay = time.Minute
+)
+
+var (
+	now    = time.Now
+	rename = os.Rename
+	// created is replaced in tests, the creation time of a file can not be
+	// set.
+	created = fileCreated
+)
+
+// Options control when a log file is rotated and how many rotated files
+// are kept. Zero values disable the corresponding limit.
+type Options struct {
+	// MaxSize is the size in bytes after which the file is rotated.
+	MaxSize int64
+	// Interval is the age after which the file is rotated.
+	Interval time.Duration
+	// MaxBackups is the number of rotated files to keep.
+	MaxBackups int
+	// MaxAge is how long rotated files are kept.
+	MaxAge time.Duration
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// DefaultOptions are used when no rotation settings are configured.
+var DefaultOptions = Options{
+	MaxSize:    10 << 20,
+	Interval:   24 * time.Hour,
+	MaxBackups: 5,
+	MaxAge:     7 * 24 * time.Hour,
+	Compress:   true,
+}
+
+// Writer is an io.WriteCloser that appends to a file and rotates it
+// according to its Options.
+type Writer struct {
+	path string
+
+	mu     sync.Mutex
+	opts   Options
+	f      *os.File
+	size   int64
+	opened time.Time
+	closed bool
+	// rotateFailed is when the last rotation failed, zero if it did not.
+	rotateFailed time.Time
+
+	// millMu serializes compressing and pruning backups, which run outside
+	// mu so a rotation does not stall other writers.
+	millMu sync.Mutex
+}
+
+// Open opens or creates the log file at path.
+func Open(path string, opts Options) (*Writer, error) {
+	w := &Writer{path: path, opts: opts}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return fmt.Errorf("error creating log directory: %v", err)
+	}
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	w.f = f
+	w.size = fi.Size()
+	// The age of an existing file counts from when it was created, so
+	// restarting the agent does not delay its rotation. A new file may
+	// inherit the creation time of the file it replaces on Windows.
+	w.opened = now()
+	if w.size > 0 {
+		if t := created(w.path, fi); t.Before(w.opened) {
+			w.opened = t
+		}
+	}
+	return nil
+}
+
+// SetOptions replaces the rotation options, they take effect on the next
+// write.
+func (w *Writer) SetOptions(opts Options) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.opts = opts
+}
+
+// Write writes p to the log file, rotating it first if p would exceed
+// MaxSize or the file is older than Interval.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	if err := w.reopen(); err != nil {
+		w.mu.Unlock()
+		return 0, err
+	}
+	var backup string
+	var rerr error
+	if w.shouldRotate(int64(len(p))) {
+		backup, rerr = w.rotate()
+		// A failed rotation keeps writing to the current file if it is
+		// still open.
+		if w.f == nil {
+			w.mu.Unlock()
+			return 0, rerr
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	opts := w.opts
+	w.mu.Unlock()
+
+	if backup != "" {
+		w.mill(backup, opts)
+	}
+	if err == nil {
+		err = rerr
+	}
+	return n, err
+}
+
+// reopen opens the log file again if a failed rotation left it closed.
+// w.mu must be held.
+func (w *Writer) reopen() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.f != nil {
+		return nil
+	}
+	return w.open()
+}
+
+func (w *Writer) shouldRotate(n int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if !w.rotateFailed.IsZero() && now().Sub(w.rotateFailed) < rotateRetryDelay {
+		return false
+	}
+	if w.opts.MaxSize > 0 && w.size+n > w.opts.MaxSize {
+		return true
+	}
+	return w.opts.Interval > 0 && now().Sub(w.opened) >= w.opts.Interval
+}
+
+// Rotate closes the current log file, moves it aside and opens a new one.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	if err := w.reopen(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	backup, err := w.rotate()
+	opts := w.opts
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	w.mill(backup, opts)
+	return nil
+}
+
+// rotate moves the log file aside and opens a new one, returning the name
+// of the backup. If the file can not be moved, for example because another
+// process has it open on Windows, the current file is opened again and the
+// rotation is retried after rotateRetryDelay. If no file could be opened
+// w.f is left nil and the next write opens it again. w.mu must be held.
+func (w *Writer) rotate() (string, error) {
+	err := w.f.Close()
+	w.f = nil
+	if err != nil {
+		w.rotateFailed = now()
+		return "", fmt.Errorf("error closing log file: %v", err)
+	}
+
+	backup := w.backupName()
+	if err := rename(w.path, backup); err != nil {
+		w.rotateFailed = now()
+		if oerr := w.open(); oerr != nil {
+			return "", fmt.Errorf("error rotating log file: %v, %v", err, oerr)
+		}
+		return "", fmt.Errorf("error rotating log file: %v", err)
+	}
+	w.rotateFailed = time.Time{}
+	if err := w.open(); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// mill compresses a new backup and prunes old ones. It is called without
+// w.mu held, so other writers continue while a backup is compressed.
+func (w *Writer) mill(backup string, opts Options) {
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+	if opts.Compress {
+		// A failed compression leaves the uncompressed backup in place.
+		compress(backup)
+	}
+	w.prune(opts)
+}
+
+// backupName returns the name to rotate the log file to. Rotations within
+// the same millisecond get an increasing sequence suffix so ordering is
+// preserved even after older backups have been pruned.
+func (w *Writer) backupName() string {
+	t := now()
+	name := w.path + "." + t.Format(backupTimeFormat)
+	matches, _ := filepath.Glob(name + "*")
+	seq := 0
+	for _, m := range matches {
+		if b, ok := parseBackup(w.path, m); ok && b.seq >= seq {
+			seq = b.seq + 1
+		}
+	}
+	if len(matches) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, max(seq, 1))
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	// Keep the modification time so retention is based on the log contents.
+	if fi, err := src.Stat(); err == nil {
+		os.Chtimes(dst.Name(), fi.ModTime(), fi.ModTime())
+	}
+	// Windows does not allow removing open files.
+	src.Close()
+	return os.Remove(path)
+}
+
+// Backups returns the rotated log files, newest first.
+func (w *Writer) Backups() ([]string, error) {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup
+	for _, m := range matches {
+		if b, ok := parseBackup(w.path, m); ok {
+			backups = append(backups, b)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].rotated.Equal(backups[j].rotated) {
+			return backups[i].rotated.After(backups[j].rotated)
+		}
+		return backups[i].seq > backups[j].seq
+	})
+	var paths []string
+	for _, b := range backups {
+		paths = append(paths, b.path)
+	}
+	return paths, nil
+}
+
+type backup struct {
+	path    string
+	rotated time.Time
+	seq     int
+}
+
+// parseBackup parses names created by backupName, with or without the
+// compression suffix.
+func parseBackup(base, path string) (backup, bool) {
+	ts := strings.TrimSuffix(strings.TrimPrefix(path, base+"."), ".gz")
+	b := backup{path: path}
+	if i := strings.LastIndex(ts, "-"); i != -1 {
+		seq, err := strconv.Atoi(ts[i+1:])
+		if err != nil {
+			return b, false
+		}
+		b.seq = seq
+		ts = ts[:i]
+	}
+	t, err := time.Parse(backupTimeFormat, ts)
+	if err != nil {
+		return b, false
+	}
+	b.rotated = t
+	return b, true
+}
+
+func (w *Writer) prune(opts Options) {
+	backups, err := w.Backups()
+	if err != nil {
+		return
+	}
+	for i, b := range backups {
+		if opts.MaxBackups > 0 && i >= opts.MaxBackups {
+			os.Remove(b)
+			continue
+		}
+		if opts.MaxAge <= 0 {
+			continue
+		}
+		if fi, err := os.Stat(b); err == nil && now().Sub(fi.ModTime()) > opts.MaxAge {
+			os.Remove(b)
+		}
+	}
+}
+
+// Close closes the log file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
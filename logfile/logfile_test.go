@@ -0,0 +1,287 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package logfile
+
+import (
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setNow(t *testing.T, tm time.Time) {
+	t.Helper()
+	old := now
+	now = func() time.Time { return tm }
+	t.Cleanup(func() { now = old })
+}
+
+func readGzip(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestWriterRotatesOnSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "agent.log")
+	setNow(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	w, err := Open(path, Options{MaxSize: 12, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, s := range []string{"12345\n", "6789\n", "abc\n"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "abc\n" {
+		t.Errorf("current log = %q, want %q", b, "abc\n")
+	}
+	backups, err := w.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".gz") {
+		t.Fatalf("backups = %q, want one compressed backup", backups)
+	}
+	if got := readGzip(t, backups[0]); got != "12345\n6789\n" {
+		t.Errorf("backup = %q, want %q", got, "12345\n6789\n")
+	}
+}
+
+func TestWriterRotatesOnInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	setNow(t, start)
+	w, err := Open(path, Options{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("old\n"))
+	setNow(t, start.Add(30*time.Minute))
+	w.Write([]byte("still old\n"))
+	setNow(t, start.Add(time.Hour))
+	w.Write([]byte("new\n"))
+
+	backups, err := w.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("backups = %q, want 1", backups)
+	}
+	b, err := ioutil.ReadFile(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "old\nstill old\n" {
+		t.Errorf("backup = %q, want %q", b, "old\nstill old\n")
+	}
+}
+
+func TestWriterRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	setNow(t, start)
+	w, err := Open(path, Options{MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Rotations within the same millisecond must not overwrite each other.
+	for i := 0; i < 4; i++ {
+		w.Write([]byte("line\n"))
+		if err := w.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := w.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{path + ".20240102T030405.000-3", path + ".20240102T030405.000-2"}
+	if strings.Join(backups, ",") != strings.Join(want, ",") {
+		t.Errorf("backups = %q, want %q", backups, want)
+	}
+
+	// Backups last written more than MaxAge ago are removed.
+	old := start.Add(-48 * time.Hour)
+	if err := os.Chtimes(want[1], old, old); err != nil {
+		t.Fatal(err)
+	}
+	w.SetOptions(Options{MaxBackups: 2, MaxAge: 24 * time.Hour})
+	w.Write([]byte("line\n"))
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	backups, err = w.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{path + ".20240102T030405.000-4", path + ".20240102T030405.000-3"}
+	if strings.Join(backups, ",") != strings.Join(want, ",") {
+		t.Errorf("backups = %q, want %q", backups, want)
+	}
+}
+
+func TestWriterCompressesOutsideLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	w, err := Open(path, Options{MaxSize: 8, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Hold up compression of the backup the rotation creates.
+	w.millMu.Lock()
+	w.Write([]byte("1234567\n"))
+	rotated := make(chan struct{})
+	go func() {
+		w.Write([]byte("new\n"))
+		close(rotated)
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for b, _ := ioutil.ReadFile(path); string(b) != "new\n"; b, _ = ioutil.ReadFile(path) {
+		if time.Now().After(deadline) {
+			t.Fatal("log file was not rotated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Other writers must not wait for the compression.
+	written := make(chan struct{})
+	go func() {
+		w.Write([]byte("x\n"))
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Write blocked while a backup was being compressed")
+	}
+
+	w.millMu.Unlock()
+	<-rotated
+	backups, err := w.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".gz") {
+		t.Errorf("backups = %q, want one compressed backup", backups)
+	}
+}
+
+func TestWriterClosed(t *testing.T) {
+	w, err := Open(filepath.Join(t.TempDir(), "agent.log"), DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Error("expected error writing to closed writer")
+	}
+}
+
+func TestWriterRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	setNow(t, start)
+	w, err := Open(path, Options{MaxSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	oldRename := rename
+	defer func() { rename = oldRename }()
+	rename = func(string, string) error { return errors.New("sharing violation") }
+
+	// A failed rotation keeps writing to the current file.
+	w.Write([]byte("1234567\n"))
+	if _, err := w.Write([]byte("a\n")); err == nil {
+		t.Error("expected an error for the failed rotation")
+	}
+	if _, err := w.Write([]byte("b\n")); err != nil {
+		t.Errorf("Write after a failed rotation: %v", err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "1234567\na\nb\n" {
+		t.Errorf("current log = %q, want %q", b, "1234567\na\nb\n")
+	}
+
+	// The rotation is retried once rotateRetryDelay has passed.
+	rename = oldRename
+	setNow(t, start.Add(rotateRetryDelay))
+	if _, err := w.Write([]byte("c\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "c\n" {
+		t.Errorf("current log = %q, want %q", b, "c\n")
+	}
+	if backups, err := w.Backups(); err != nil || len(backups) != 1 {
+		t.Errorf("backups = %q, %v, want 1", backups, err)
+	}
+}
+
+func TestWriterIntervalAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	oldCreated := created
+	defer func() { created = oldCreated }()
+	created = func(string, os.FileInfo) time.Time { return start }
+
+	// Reopening a file created longer than Interval ago rotates it on the
+	// next write.
+	setNow(t, start.Add(2*time.Hour))
+	w, err := Open(path, Options{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Write([]byte("new\n"))
+	if b, _ := ioutil.ReadFile(path); string(b) != "new\n" {
+		t.Errorf("current log = %q, want %q", b, "new\n")
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 
@@ -34,7 +35,17 @@ import (
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/agentendpoint"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/crashloop"
+	"github.com/GoogleCloudPlatform/osconfig/crashreport"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
+	"github.com/GoogleCloudPlatform/osconfig/inventory"
+	"github.com/GoogleCloudPlatform/osconfig/localapi"
+	"github.com/GoogleCloudPlatform/osconfig/logfile"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"github.com/GoogleCloudPlatform/osconfig/policies"
+	"github.com/GoogleCloudPlatform/osconfig/preflight"
+	"github.com/GoogleCloudPlatform/osconfig/privhelper"
+	"github.com/GoogleCloudPlatform/osconfig/statereset"
 	"github.com/GoogleCloudPlatform/osconfig/tasker"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 	"github.com/tarm/serial"
@@ -76,26 +87,79 @@ func (s *serialPort) Write(b []byte) (int, error) {
 
 var deferredFuncs []func()
 
+// logFile is the rotating local log file, nil unless the log_file flag is set.
+var logFile *logfile.Writer
+
+// localAPI is the local control API server, nil unless
+// osconfig-local-api-enabled is set.
+var (
+	localAPI   *localapi.Server
+	localAPIMx sync.Mutex
+)
+
+// syncLocalAPI starts or stops the local control API to match the current
+// config.
+func syncLocalAPI(ctx context.Context) {
+	localAPIMx.Lock()
+	defer localAPIMx.Unlock()
+	switch {
+	case agentconfig.LocalAPIEnabled() && localAPI == nil:
+		s, err := localapi.Listen(ctx, agentconfig.LocalAPISocket())
+		if err != nil {
+			clog.Errorf(ctx, "Error starting local API: %v", err)
+			return
+		}
+		localAPI = s
+	case !agentconfig.LocalAPIEnabled() && localAPI != nil:
+		localAPI.Close()
+		localAPI = nil
+	}
+}
+
+func closeLocalAPI() {
+	localAPIMx.Lock()
+	defer localAPIMx.Unlock()
+	if localAPI != nil {
+		localAPI.Close()
+		localAPI = nil
+	}
+}
+
+func closeLogFile() {
+	if logFile != nil {
+		logFile.Close()
+	}
+}
+
 // RegisterAgent is a blocking call, the RPC itself has retry logic baked in
 // with jitter and backoff up to a total of 10 minutes.
 // If client creation or register agent (after retries) fail we then wait for
 // 5 minutes and try again.
 func registerAgent(ctx context.Context) {
 	for {
-		if client, err := agentendpoint.NewClient(ctx); err != nil {
+		if err := registerAgentOnce(ctx); err != nil {
 			logger.Errorf(err.Error())
-		} else if err := client.RegisterAgent(ctx); err != nil {
-			logger.Errorf(err.Error())
-			client.Close()
 		} else {
 			// RegisterAgent completed successfully.
-			client.Close()
 			return
 		}
-		time.Sleep(5 * time.Minute)
+		select {
+		case <-time.After(5 * time.Minute):
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
+func registerAgentOnce(ctx context.Context) error {
+	client, release, err := agentendpoint.SharedClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return client.RegisterAgent(ctx)
+}
+
 func run(ctx context.Context) {
 	// Setup logging.
 	opts := logger.LogOpts{LoggerName: "OSConfigAgent", UserAgent: agentconfig.UserAgent(), DisableLocalLogging: agentconfig.DisableLocalLogging()}
@@ -112,8 +176,18 @@ func run(ctx context.Context) {
 		logger.Fatalf("Error parsing metadata, agent cannot start: %v", err.Error())
 	}
 	opts.Debug = agentconfig.Debug()
-	clog.DebugEnabled = agentconfig.Debug()
+	clog.SetDebugEnabled(agentconfig.Debug())
+	opts.Writers = append(opts.Writers, crashreport.LogWriter)
 	opts.ProjectName = agentconfig.ProjectID()
+	if path := agentconfig.LogFile(); path != "" {
+		w, err := logfile.Open(path, agentconfig.LogRotation())
+		if err != nil {
+			fmt.Printf("Error opening log file: %v", err)
+			os.Exit(1)
+		}
+		logFile = w
+		opts.Writers = append(opts.Writers, w)
+	}
 
 	if err := logger.Init(ctx, opts); err != nil {
 		fmt.Printf("Error initializing logger: %v", err)
@@ -141,41 +215,77 @@ func run(ctx context.Context) {
 		}
 	})
 
-	deferredFuncs = append(deferredFuncs, logger.Close, func() { clog.Infof(ctx, "OSConfig Agent (version %s) shutting down.", agentconfig.Version()) })
+	integrity.Enable()
 
-	obtainLock()
+	deferredFuncs = append(deferredFuncs, crashloop.Stop, closeLocalAPI, agentendpoint.CloseSharedClients, logger.Close, closeLogFile, func() { clog.Infof(ctx, "OSConfig Agent (version %s) shutting down.", agentconfig.Version()) })
+
+	if err := obtainLock(); err != nil {
+		clog.Errorf(ctx, "%v", err)
+		for _, f := range deferredFuncs {
+			f()
+		}
+		os.Exit(exitCode(err))
+	}
 
 	// obtainLock adds functions to clear the lock at close.
 	logger.DeferredFatalFuncs = append(logger.DeferredFatalFuncs, deferredFuncs...)
 
-	clog.Infof(ctx, "OSConfig Agent (version %s) started.", agentconfig.Version())
+	// A cloned disk carries the state of the instance it was taken from,
+	// reset it before anything reads it. This needs the lock, so a second
+	// agent instance can not reset the state of the running one.
+	if _, err := statereset.Check(ctx); err != nil {
+		clog.Errorf(ctx, "Error checking for a cloned instance: %v", err)
+	}
 
-	// Call RegisterAgent at least once every day, on start calling
-	// of RegisterAgent is handled in the service loop.
-	go func() {
-		c := time.Tick(24 * time.Hour)
-		for range c {
-			if agentconfig.TaskNotificationEnabled() || agentconfig.GuestPoliciesEnabled() {
-				registerAgent(ctx)
-			}
+	crashloop.Init(ctx)
+	crashreport.SetCoreDump(agentconfig.CrashCoreDump())
+	crashreport.ReportPending(ctx, agentconfig.CrashReportUpload())
+	defer crashreport.Recover(ctx, "agent")
+
+	clog.Infof(ctx, "OSConfig Agent (version %s) started.", agentconfig.Version())
+	if agentconfig.PrivilegeHelper() {
+		exe, err := os.Executable()
+		if err != nil {
+			logger.Fatalf("Error finding the agent executable for the privilege helper: %v", err)
 		}
-	}()
+		privhelper.Enable(exe)
+	}
+	packages.SetOperationTimeouts(agentconfig.PackageTimeouts())
+	packages.SetGooGetRetries(agentconfig.GooGetRetries())
+	packages.SetRPMDBDirect(agentconfig.RPMDBDirect())
+	packages.SetQueryCacheTTL(agentconfig.PackageQueryCacheTTL())
+	packages.SetQueryConcurrency(agentconfig.PackageQueryConcurrency())
+	packages.SetRepositoryLookup(agentconfig.PackageRepositories())
+	agentendpoint.CheckReboot(ctx)
 
 	switch action := flag.Arg(0); action {
 	case "", "run", "noservice":
+		// Diagnose connectivity in the background, this does not block startup.
+		go func() {
+			defer crashreport.Recover(ctx, "preflight")
+			preflight.RunAndLog(ctx)
+		}()
 		runServiceLoop(ctx)
 	case "inventory", "osinventory":
-		client, err := agentendpoint.NewClient(ctx)
+		client, release, err := agentendpoint.SharedClient(ctx)
 		if err != nil {
-			logger.Fatalf(err.Error())
+			clog.Errorf(ctx, "%v", err)
+			exitStatus = exitConnectivity
+			return
 		}
 		tasker.Enqueue(ctx, "Report OSInventory", func() {
-			client.ReportInventory(ctx)
+			if err := client.ReportInventory(ctx); err != nil {
+				exitStatus = exitCode(&connectivityError{err})
+			}
 		})
 		tasker.Close()
+		release()
 		return
 	case "gp", "policies", "guestpolicies", "ospackage":
-		policies.Run(ctx)
+		if err := policies.RunOnce(ctx); err != nil {
+			clog.Errorf(ctx, "Guest policy run did not complete successfully: %v", err)
+			exitStatus = exitCode(err)
+		}
 		tasker.Close()
 		return
 	case "w", "waitfortasknotification", "ospatch":
@@ -193,19 +303,31 @@ func run(ctx context.Context) {
 }
 
 func runTaskLoop(ctx context.Context, c chan struct{}) {
+	defer crashreport.Recover(ctx, "task loop")
 	var taskNotificationClient *agentendpoint.Client
 	var err error
 	for {
 		// Set debug logging settings so that customers don't need to restart the agent.
-		logger.SetDebugLogging(agentconfig.Debug())
-		clog.DebugEnabled = agentconfig.Debug()
+		clog.SetDebugEnabled(agentconfig.Debug())
+		crashreport.SetCoreDump(agentconfig.CrashCoreDump())
+		if logFile != nil {
+			logFile.SetOptions(agentconfig.LogRotation())
+		}
+		syncLocalAPI(ctx)
+		packages.SetOperationTimeouts(agentconfig.PackageTimeouts())
+		packages.SetGooGetRetries(agentconfig.GooGetRetries())
+		packages.SetRPMDBDirect(agentconfig.RPMDBDirect())
+		packages.SetQueryCacheTTL(agentconfig.PackageQueryCacheTTL())
+		packages.SetQueryConcurrency(agentconfig.PackageQueryConcurrency())
+		packages.SetRepositoryLookup(agentconfig.PackageRepositories())
 		if agentconfig.TaskNotificationEnabled() && taskNotificationClient == nil {
 			// Call RegisterAgent now since we just either started running or were just enabled.
 			// This call is blocking until successful as we can't continue unless register agent has completed.
 			registerAgent(ctx)
 		}
 		if agentconfig.TaskNotificationEnabled() && (taskNotificationClient == nil || taskNotificationClient.Closed()) {
-			// Start WaitForTaskNotification if we need to.
+			// Start WaitForTaskNotification if we need to. This client is
+			// not a SharedClient, closing it stops the stream.
 			taskNotificationClient, err = agentendpoint.NewClient(ctx)
 			if err != nil {
 				clog.Errorf(ctx, err.Error())
@@ -240,9 +362,16 @@ func runTaskLoop(ctx context.Context, c chan struct{}) {
 }
 
 // Runs internal functions that need to run on an interval.
+// All internal periodics share a single ticker to keep the idle agent footprint small.
 func runInternalPeriodics(ctx context.Context) {
+	defer crashreport.Recover(ctx, "internal periodics")
 	ticker := time.NewTicker(10 * time.Minute)
 	defer ticker.Stop()
+	lastRegister := time.Now()
+	// registering holds a token while a registration retries in the
+	// background, registerAgent may retry for a long time and must not
+	// hold up the other periodics.
+	registering := make(chan struct{}, 1)
 	for {
 		if _, err := os.Stat(agentconfig.RestartFile()); err == nil {
 			clog.Infof(ctx, "Restart required marker file exists, beginning agent shutdown, waiting for tasks to complete.")
@@ -254,9 +383,29 @@ func runInternalPeriodics(ctx context.Context) {
 			os.Exit(2)
 		}
 
+		// Call RegisterAgent at least once every day, on start calling
+		// of RegisterAgent is handled in the task loop.
+		if time.Since(lastRegister) >= 24*time.Hour {
+			if agentconfig.TaskNotificationEnabled() || agentconfig.GuestPoliciesEnabled() {
+				select {
+				case registering <- struct{}{}:
+					go func() {
+						defer func() { <-registering }()
+						defer crashreport.Recover(ctx, "agent registration")
+						registerAgent(ctx)
+					}()
+				default:
+					clog.Debugf(ctx, "Previous agent registration still retrying, skipping.")
+				}
+			}
+			lastRegister = time.Now()
+		}
+
 		select {
 		case <-ticker.C:
 			continue
+		case <-agentendpoint.RestartRequested():
+			continue
 		case <-ctx.Done():
 			return
 		}
@@ -277,7 +426,8 @@ func runServiceLoop(ctx context.Context) {
 	ticker := time.NewTicker(agentconfig.SvcPollInterval())
 	defer ticker.Stop()
 	// First inventory run will be somewhere between 3 and 5 min.
-	firstInventory := time.After(time.Duration(rand.Intn(120)+180) * time.Second)
+	firstInventory := time.NewTimer(time.Duration(rand.Intn(120)+180) * time.Second)
+	defer firstInventory.Stop()
 	ranFirstInventory := false
 	for {
 		if agentconfig.GuestPoliciesEnabled() {
@@ -291,7 +441,7 @@ func runServiceLoop(ctx context.Context) {
 				// always fire first.
 				select {
 				case <-ticker.C:
-				case <-firstInventory:
+				case <-firstInventory.C:
 				case <-ctx.Done():
 					return
 				}
@@ -300,12 +450,13 @@ func runServiceLoop(ctx context.Context) {
 
 			// This should always run after ospackage.SetConfig.
 			tasker.Enqueue(ctx, "Report OSInventory", func() {
-				client, err := agentendpoint.NewClient(ctx)
+				client, release, err := agentendpoint.SharedClient(ctx)
 				if err != nil {
 					logger.Errorf(err.Error())
+					return
 				}
+				defer release()
 				client.ReportInventory(ctx)
-				client.Close()
 			})
 		}
 
@@ -340,13 +491,101 @@ func main() {
 	switch action := flag.Arg(0); action {
 	// wuaupdates just runs the packages.WUAUpdates function and returns it's output
 	// as JSON on stdout. This avoids memory issues with the WUA api since this is
-	// called often for Windows inventory runs.
+	// called often for Windows inventory runs. The optional second argument is
+	// the packages.WUAQueryOptions as JSON.
 	case "wuaupdates":
-		if err := wuaUpdates(ctx, flag.Arg(1)); err != nil {
+		if err := wuaUpdates(ctx, flag.Arg(1), flag.Arg(2)); err != nil {
 			fmt.Fprint(os.Stderr, err)
-			os.Exit(1)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitSuccess)
+	// simulate evaluates an OS policy file against this host read-only, or
+	// against a recorded host profile, it exits exitNonCompliant if any
+	// resource would be changed or is in error. Resources that could not be
+	// evaluated offline do not count.
+	case "simulate":
+		if err := simulate(ctx, flag.Arg(1), flag.Arg(2)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+		os.Exit(exitSuccess)
+	// export-profile records a host profile for an OS policy file for use
+	// with simulate.
+	case "export-profile":
+		if err := exportProfile(ctx, flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitSuccess)
+	// boot-parameters checks or enforces kernel boot parameters, it is run
+	// by an ExecResource and exits with the ExecResource codes.
+	case "boot-parameters":
+		os.Exit(bootParameters(ctx, flag.Args()[1:], os.Stdout, os.Stderr))
+	// guestattribute sets or waits on a guest attribute for use in scripts.
+	case "guestattribute":
+		if err := guestAttribute(ctx, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
 		}
-		os.Exit(0)
+		os.Exit(exitSuccess)
+	// inventoryworker collects inventory as an unprivileged child of the
+	// agent and writes it to stdout as JSON.
+	case inventory.WorkerArg:
+		if err := inventory.RunWorker(ctx, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitSuccess)
+	// generate-profile writes an AppArmor or seccomp profile, a systemd
+	// syscall filter drop-in, or WDAC rule hints, for the agent.
+	case "generate-profile":
+		if err := generateProfile(ctx, os.Stdout, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitSuccess)
+	// supportbundle collects the agent state files for troubleshooting.
+	case "supportbundle":
+		if err := supportBundle(ctx, flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitSuccess)
+	// query prints the matching local inventory and compliance history, or
+	// runs an SQL query against it in builds with the sqlite tag.
+	case "query":
+		if err := queryHistory(ctx, os.Stdout, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitSuccess)
+	// explain prints the OS policy resources that manage a file or package.
+	case "explain":
+		if err := explain(os.Stdout, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitSuccess)
+	// privhelper runs a command that needs root for an agent running as an
+	// unprivileged user, it is run by the agent through sudo.
+	case privhelper.Arg:
+		os.Exit(privhelper.Main(flag.Args()[1:], os.Stderr))
+	// reset-state archives the per-instance agent state, for use before
+	// imaging a disk.
+	case "reset-state":
+		if err := resetState(ctx, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitSuccess)
+	// version prints the agent version, with --json the build, enabled
+	// features, package manager backends and supported protocols.
+	case "version":
+		if err := printVersion(ctx, os.Stdout, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitSuccess)
 	case "", "run":
 		runService(ctx)
 	default:
@@ -356,4 +595,7 @@ func main() {
 	for _, f := range deferredFuncs {
 		f()
 	}
+	if exitStatus != exitSuccess {
+		os.Exit(exitStatus)
+	}
 }
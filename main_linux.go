@@ -17,29 +17,28 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"syscall"
 	"time"
-
-	"github.com/GoogleCloudPlatform/guest-logging-go/logger"
 )
 
 func runService(ctx context.Context) {
 	run(ctx)
 }
 
-func obtainLock() {
+func obtainLock() error {
 	lockFile := "/run/lock/osconfig_agent.lock"
 
 	err := os.Mkdir(filepath.Dir(lockFile), 1777)
 	if err != nil && !os.IsExist(err) {
-		logger.Fatalf("Cannot obtain agent lock: %v", err)
+		return fmt.Errorf("cannot obtain agent lock: %v", err)
 	}
 
 	f, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil && !os.IsExist(err) {
-		logger.Fatalf("Cannot obtain agent lock: %v", err)
+		return fmt.Errorf("cannot obtain agent lock: %v", err)
 	}
 
 	c := make(chan error)
@@ -49,15 +48,16 @@ func obtainLock() {
 	select {
 	case err := <-c:
 		if err != nil {
-			logger.Fatalf("Cannot obtain agent lock, is the agent already running? Error: %v", err)
+			return fmt.Errorf("cannot obtain agent lock, is the agent already running? Error: %v", err)
 		}
 	case <-time.After(time.Second):
-		logger.Fatalf("OSConfig agent lock already held, is the agent already running?")
+		return errLockHeld
 	}
 
 	deferredFuncs = append(deferredFuncs, func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN); f.Close(); os.Remove(lockFile) })
+	return nil
 }
 
-func wuaUpdates(ctx context.Context, _ string) error {
+func wuaUpdates(ctx context.Context, _, _ string) error {
 	return errors.New("wuaUpdates not implemented on linux")
 }
@@ -76,23 +76,24 @@ func unlockFileEx(hFile uintptr, nNumberOfBytesToLockLow, nNumberOfBytesToLockHi
 	return nil
 }
 
-func obtainLock() {
+func obtainLock() error {
 	lockFile := filepath.Join(agentconfig.GetCacheDirWindows(), "lock")
 
 	err := os.MkdirAll(filepath.Dir(lockFile), 0755)
 	if err != nil && !os.IsExist(err) {
-		logger.Fatalf("Cannot obtain agent lock: %v", err)
+		return fmt.Errorf("cannot obtain agent lock: %v", err)
 	}
 	f, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil && !os.IsExist(err) {
-		logger.Fatalf("Cannot obtain agent lock: %v", err)
+		return fmt.Errorf("cannot obtain agent lock: %v", err)
 	}
 
 	if err := lockFileEx(f.Fd(), LOCKFILE_EXCLUSIVE_LOCK|LOCKFILE_FAIL_IMMEDIATELY, 1, 0, &syscall.Overlapped{}); err != nil {
-		logger.Fatalf("OSConfig agent lock already held, is the agent already running?")
+		return errLockHeld
 	}
 
 	deferredFuncs = append(deferredFuncs, func() { unlockFileEx(f.Fd(), 1, 0, &syscall.Overlapped{}); f.Close(); os.Remove(lockFile) })
+	return nil
 }
 
 type service struct {
@@ -135,8 +136,14 @@ func runService(ctx context.Context) {
 	}
 }
 
-func wuaUpdates(ctx context.Context, query string) error {
-	updts, err := packages.WUAUpdates(ctx, query)
+func wuaUpdates(ctx context.Context, query, opts string) error {
+	var o packages.WUAQueryOptions
+	if opts != "" {
+		if err := json.Unmarshal([]byte(opts), &o); err != nil {
+			return fmt.Errorf("error parsing WUA query options: %v", err)
+		}
+	}
+	updts, err := packages.WUAUpdates(ctx, query, o)
 	if err != nil {
 		return err
 	}
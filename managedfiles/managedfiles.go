@@ -0,0 +1,212 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package managedfiles keeps a registry of the checksums of files written by
+// the agent so that changes made to them outside of the agent can be
+// detected and reported.
+package managedfiles
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
+)
+
+// Tamper reasons.
+const (
+	Modified = "modified"
+	Deleted  = "deleted"
+)
+
+var (
+	registryFile = agentconfig.ManagedFilesRegistry
+	mx           sync.Mutex
+)
+
+type entry struct {
+	SHA256  string    `json:"sha256"`
+	Written time.Time `json:"written"`
+}
+
+// TamperedFile is a managed file that was changed outside of the agent.
+type TamperedFile struct {
+	Path    string    `json:"path"`
+	Reason  string    `json:"reason"`
+	Written time.Time `json:"written"`
+}
+
+func load() (map[string]entry, error) {
+	reg := map[string]entry{}
+	data, err := integrity.ReadFile(registryFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return reg, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &reg); err != nil {
+		return nil, fmt.Errorf("error parsing managed files registry: %v", err)
+	}
+	return reg, nil
+}
+
+func save(reg map[string]entry) error {
+	data, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return integrity.WriteFile(registryFile(), data, 0600)
+}
+
+// update applies f to the registry, it is only saved if f reports a change.
+func update(ctx context.Context, f func(map[string]entry) bool) {
+	mx.Lock()
+	defer mx.Unlock()
+	reg, err := load()
+	if err != nil {
+		clog.Warningf(ctx, "Error loading managed files registry, resetting it: %v", err)
+		reg = map[string]entry{}
+	}
+	if !f(reg) {
+		return
+	}
+	if err := save(reg); err != nil {
+		clog.Warningf(ctx, "Error saving managed files registry: %v", err)
+	}
+}
+
+func checksum(r io.Reader) string {
+	h := sha256.New()
+	io.Copy(h, r)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return checksum(f), nil
+}
+
+// Record registers the current contents of paths as written by the agent.
+func Record(ctx context.Context, paths ...string) {
+	update(ctx, func(reg map[string]entry) bool {
+		changed := false
+		for _, path := range paths {
+			sum, err := fileChecksum(path)
+			if err != nil {
+				clog.Debugf(ctx, "Error recording managed file %q: %v", path, err)
+				continue
+			}
+			reg[path] = entry{SHA256: sum, Written: time.Now().UTC()}
+			changed = true
+		}
+		return changed
+	})
+}
+
+// Forget removes paths from the registry, this is used when the agent
+// removes a file it previously wrote.
+func Forget(ctx context.Context, paths ...string) {
+	update(ctx, func(reg map[string]entry) bool {
+		changed := false
+		for _, path := range paths {
+			if _, ok := reg[path]; ok {
+				delete(reg, path)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+func verify(path string, e entry) *TamperedFile {
+	sum, err := fileChecksum(path)
+	switch {
+	case os.IsNotExist(err):
+		return &TamperedFile{Path: path, Reason: Deleted, Written: e.Written}
+	case err != nil:
+		// Unreadable files are not reported, the error is not a change.
+		return nil
+	case sum != e.SHA256:
+		return &TamperedFile{Path: path, Reason: Modified, Written: e.Written}
+	}
+	return nil
+}
+
+// CheckFile reports whether path, if it is a managed file, was changed
+// outside of the agent since it was last written. This is called before the
+// agent rewrites a file so tampering that the agent is about to correct is
+// still logged.
+func CheckFile(ctx context.Context, path string) *TamperedFile {
+	mx.Lock()
+	reg, err := load()
+	mx.Unlock()
+	if err != nil {
+		return nil
+	}
+	e, ok := reg[path]
+	if !ok {
+		return nil
+	}
+	if t := verify(path, e); t != nil {
+		clog.Warningf(ctx, "Managed file %q was %s outside of the OS Config agent.", t.Path, t.Reason)
+		return t
+	}
+	return nil
+}
+
+// Written returns when the agent last wrote path, ok is false if path is not
+// a managed file.
+func Written(path string) (written time.Time, ok bool, err error) {
+	mx.Lock()
+	reg, err := load()
+	mx.Unlock()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	e, ok := reg[path]
+	return e.Written, ok, nil
+}
+
+// Check returns the managed files that were changed outside of the agent.
+func Check(ctx context.Context) ([]*TamperedFile, error) {
+	mx.Lock()
+	reg, err := load()
+	mx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var tampered []*TamperedFile
+	for path, e := range reg {
+		if t := verify(path, e); t != nil {
+			tampered = append(tampered, t)
+		}
+	}
+	sort.Slice(tampered, func(i, j int) bool { return tampered[i].Path < tampered[j].Path })
+	return tampered, nil
+}
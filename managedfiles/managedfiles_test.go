@@ -0,0 +1,88 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package managedfiles
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	oldRegistry := registryFile
+	defer func() { registryFile = oldRegistry }()
+	registryFile = func() string { return filepath.Join(dir, "registry.json") }
+
+	modified := filepath.Join(dir, "modified.repo")
+	deleted := filepath.Join(dir, "deleted.repo")
+	untouched := filepath.Join(dir, "untouched.repo")
+	forgotten := filepath.Join(dir, "forgotten.repo")
+	for _, p := range []string{modified, deleted, untouched, forgotten} {
+		if err := ioutil.WriteFile(p, []byte("contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Record(ctx, modified, deleted, untouched, forgotten, filepath.Join(dir, "missing"))
+	Forget(ctx, forgotten)
+
+	if written, ok, err := Written(untouched); err != nil || !ok || time.Since(written) > time.Minute {
+		t.Errorf("Written(%q) = %v, %v, %v, want recent time", untouched, written, ok, err)
+	}
+	if _, ok, err := Written(forgotten); err != nil || ok {
+		t.Errorf("Written(%q) = %v, %v, want not managed", forgotten, ok, err)
+	}
+
+	if got := CheckFile(ctx, modified); got != nil {
+		t.Errorf("CheckFile(%q) = %+v, want nil", modified, got)
+	}
+
+	if err := ioutil.WriteFile(modified, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(forgotten, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Check(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{deleted: Deleted, modified: Modified}
+	if len(got) != len(want) {
+		t.Fatalf("Check() returned %d files, want %d: %+v", len(got), len(want), got)
+	}
+	for _, f := range got {
+		if want[f.Path] != f.Reason {
+			t.Errorf("file %q reason %q, want %q", f.Path, f.Reason, want[f.Path])
+		}
+	}
+	if got := CheckFile(ctx, modified); got == nil || got.Reason != Modified {
+		t.Errorf("CheckFile(%q) = %+v, want modified", modified, got)
+	}
+
+	// Rewriting a file updates its checksum.
+	Record(ctx, modified)
+	if got := CheckFile(ctx, modified); got != nil {
+		t.Errorf("CheckFile(%q) after Record = %+v, want nil", modified, got)
+	}
+}
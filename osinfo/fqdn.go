@@ -0,0 +1,70 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package osinfo
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	lookupHost  = net.DefaultResolver.LookupHost
+	lookupAddr  = net.DefaultResolver.LookupAddr
+	lookupCNAME = net.DefaultResolver.LookupCNAME
+
+	fqdnTimeout = 5 * time.Second
+)
+
+// ShortHostname returns the first label of hostname.
+func ShortHostname(hostname string) string {
+	short, _, _ := strings.Cut(hostname, ".")
+	return short
+}
+
+// FQDN resolves the fully qualified domain name of hostname, like
+// hostname -f it prefers the names the host's addresses resolve back to,
+// which includes /etc/hosts entries. hostname is returned if it is already
+// qualified or can't be resolved.
+func FQDN(ctx context.Context, hostname string) string {
+	if hostname == "" || strings.Contains(hostname, ".") {
+		return strings.TrimSuffix(hostname, ".")
+	}
+	ctx, cancel := context.WithTimeout(ctx, fqdnTimeout)
+	defer cancel()
+
+	if addrs, err := lookupHost(ctx, hostname); err == nil {
+		for _, addr := range addrs {
+			names, err := lookupAddr(ctx, addr)
+			if err != nil {
+				continue
+			}
+			for _, n := range names {
+				n = strings.TrimSuffix(n, ".")
+				// Other names may share a loopback address.
+				if strings.Contains(n, ".") && strings.EqualFold(ShortHostname(n), hostname) {
+					return n
+				}
+			}
+		}
+	}
+	if cname, err := lookupCNAME(ctx, hostname); err == nil {
+		if cname = strings.TrimSuffix(cname, "."); strings.Contains(cname, ".") {
+			return cname
+		}
+	}
+	return hostname
+}
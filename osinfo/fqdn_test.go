@@ -0,0 +1,83 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package osinfo
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFQDN(t *testing.T) {
+	defer func(h, a func(context.Context, string) ([]string, error), c func(context.Context, string) (string, error)) {
+		lookupHost, lookupAddr, lookupCNAME = h, a, c
+	}(lookupHost, lookupAddr, lookupCNAME)
+
+	errNotFound := errors.New("not found")
+	tests := []struct {
+		name     string
+		hostname string
+		addrs    map[string][]string
+		names    map[string][]string
+		cname    string
+		want     string
+	}{
+		{"Qualified", "inst.example.com.", nil, nil, "", "inst.example.com"},
+		{"Empty", "", nil, nil, "", ""},
+		{
+			"ReverseLookup",
+			"inst",
+			map[string][]string{"inst": {"127.0.0.1", "10.128.0.2"}},
+			map[string][]string{"127.0.0.1": {"localhost", "other.example.com"}, "10.128.0.2": {"inst.us-central1-a.c.project.internal.", "inst"}},
+			"",
+			"inst.us-central1-a.c.project.internal",
+		},
+		{"CNAME", "inst", nil, nil, "inst.example.com.", "inst.example.com"},
+		{"Unresolved", "inst", nil, nil, "", "inst"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookupHost = func(_ context.Context, host string) ([]string, error) {
+				if a, ok := tt.addrs[host]; ok {
+					return a, nil
+				}
+				return nil, errNotFound
+			}
+			lookupAddr = func(_ context.Context, addr string) ([]string, error) {
+				if n, ok := tt.names[addr]; ok {
+					return n, nil
+				}
+				return nil, errNotFound
+			}
+			lookupCNAME = func(_ context.Context, host string) (string, error) {
+				if tt.cname == "" {
+					return "", errNotFound
+				}
+				return tt.cname, nil
+			}
+			if got := FQDN(context.Background(), tt.hostname); got != tt.want {
+				t.Errorf("FQDN(%q) = %q, want %q", tt.hostname, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortHostname(t *testing.T) {
+	for in, want := range map[string]string{"inst": "inst", "inst.example.com": "inst", "": ""} {
+		if got := ShortHostname(in); got != want {
+			t.Errorf("ShortHostname(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package osinfo
+
+import "strings"
+
+// Normalized architecture names returned by NormalizeArchitecture.
+const (
+	ArchX86_64  = "x86_64"
+	ArchX86_32  = "x86_32"
+	ArchAarch64 = "aarch64"
+	ArchArmv7   = "armv7l"
+	ArchPPC64LE = "ppc64le"
+	ArchS390X   = "s390x"
+	// ArchAll is used for architecture independent packages.
+	ArchAll = "all"
+)
+
+// architectures maps the names used by package managers, kernels, Windows
+// and the Go runtime to a single name. Keys are lower case.
+var architectures = map[string]string{
+	"x86_64":  ArchX86_64,
+	"amd64":   ArchX86_64,
+	"x64":     ArchX86_64,
+	"x86-64":  ArchX86_64,
+	"64-bit":  ArchX86_64,
+	"x86_32":  ArchX86_32,
+	"x86":     ArchX86_32,
+	"386":     ArchX86_32,
+	"i386":    ArchX86_32,
+	"i486":    ArchX86_32,
+	"i586":    ArchX86_32,
+	"i686":    ArchX86_32,
+	"32-bit":  ArchX86_32,
+	"aarch64": ArchAarch64,
+	"arm64":   ArchAarch64,
+	"armv7l":  ArchArmv7,
+	"armv7hl": ArchArmv7,
+	"armhf":   ArchArmv7,
+	"ppc64le": ArchPPC64LE,
+	"ppc64el": ArchPPC64LE,
+	"s390x":   ArchS390X,
+	"noarch":  ArchAll,
+	"all":     ArchAll,
+	"any":     ArchAll,
+}
+
+// NormalizeArchitecture returns the standard name of arch so the same
+// machine architecture compares equal whichever backend reported it, for
+// example "amd64" (dpkg, Go) and "x86_64" (rpm, uname) are both "x86_64".
+// Unknown architectures are returned unchanged. It is only for comparisons,
+// reported values keep the names from Architecture.
+func NormalizeArchitecture(arch string) string {
+	if a, ok := architectures[strings.ToLower(strings.TrimSpace(arch))]; ok {
+		return a
+	}
+	return arch
+}
+
+// windowsReleaseIDs maps the ReleaseId registry values that do not match
+// the display version of the release. Every release since 20H2 reports a
+// ReleaseId of 2009.
+var windowsReleaseIDs = map[string]string{
+	"2009": "20H2",
+}
+
+// WindowsDisplayVersion returns the display version of a Windows release,
+// for example "22H2", from the DisplayVersion and ReleaseId registry
+// values. DisplayVersion replaced ReleaseId in 20H2, older releases only
+// have a numeric ReleaseId such as "1909".
+func WindowsDisplayVersion(displayVersion, releaseID string) string {
+	if v := strings.ToUpper(strings.TrimSpace(displayVersion)); v != "" {
+		return v
+	}
+	releaseID = strings.TrimSpace(releaseID)
+	if v, ok := windowsReleaseIDs[releaseID]; ok {
+		return v
+	}
+	return releaseID
+}
+
+// windows11Build is the first Windows 11 build number.
+const windows11Build = 22000
+
+// WindowsProductName corrects the ProductName registry value of Windows 11,
+// which still reads Windows 10, using the build number.
+func WindowsProductName(productName string, build int) string {
+	if build >= windows11Build && strings.HasPrefix(productName, "Windows 10") {
+		return "Windows 11" + strings.TrimPrefix(productName, "Windows 10")
+	}
+	return productName
+}
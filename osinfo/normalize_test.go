@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package osinfo
+
+import "testing"
+
+func TestNormalizeArchitecture(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"x86_64", ArchX86_64},
+		{"amd64", ArchX86_64},
+		{"AMD64", ArchX86_64},
+		{"x64", ArchX86_64},
+		{"x86-64", ArchX86_64},
+		{"64-bit", ArchX86_64},
+		{"x86_32", ArchX86_32},
+		{"x86", ArchX86_32},
+		{"386", ArchX86_32},
+		{"i386", ArchX86_32},
+		{"i486", ArchX86_32},
+		{"i586", ArchX86_32},
+		{"i686", ArchX86_32},
+		{"32-bit", ArchX86_32},
+		{"aarch64", ArchAarch64},
+		{"arm64", ArchAarch64},
+		{"ARM64", ArchAarch64},
+		{"armv7l", ArchArmv7},
+		{"armv7hl", ArchArmv7},
+		{"armhf", ArchArmv7},
+		{"ppc64le", ArchPPC64LE},
+		{"ppc64el", ArchPPC64LE},
+		{"s390x", ArchS390X},
+		{"noarch", ArchAll},
+		{"all", ArchAll},
+		{"any", ArchAll},
+		{" amd64\n", ArchX86_64},
+		{"riscv64", "riscv64"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeArchitecture(tt.in); got != tt.want {
+			t.Errorf("NormalizeArchitecture(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		// Normalizing is idempotent.
+		if got := NormalizeArchitecture(tt.want); got != tt.want {
+			t.Errorf("NormalizeArchitecture(%q) = %q, want it unchanged", tt.want, got)
+		}
+	}
+}
+
+func TestArchitecture(t *testing.T) {
+	// Reported values keep the names consumers already depend on.
+	tests := []struct {
+		in, want string
+	}{
+		{"amd64", "x86_64"},
+		{"64-bit", "x86_64"},
+		{"i686", "x86_32"},
+		{"noarch", "all"},
+		{"arm64", "arm64"},
+		{"any", "any"},
+		{"x86_64", "x86_64"},
+	}
+	for _, tt := range tests {
+		if got := Architecture(tt.in); got != tt.want {
+			t.Errorf("Architecture(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWindowsDisplayVersion(t *testing.T) {
+	tests := []struct {
+		displayVersion, releaseID, want string
+	}{
+		{"22H2", "2009", "22H2"},
+		{"23h2", "", "23H2"},
+		{"", "2009", "20H2"},
+		{"", "1909", "1909"},
+		{"", " 1607 ", "1607"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := WindowsDisplayVersion(tt.displayVersion, tt.releaseID); got != tt.want {
+			t.Errorf("WindowsDisplayVersion(%q, %q) = %q, want %q", tt.displayVersion, tt.releaseID, got, tt.want)
+		}
+	}
+}
+
+func TestWindowsProductName(t *testing.T) {
+	tests := []struct {
+		productName string
+		build       int
+		want        string
+	}{
+		{"Windows 10 Enterprise", 19045, "Windows 10 Enterprise"},
+		{"Windows 10 Enterprise", 22000, "Windows 11 Enterprise"},
+		{"Windows 10 Pro", 22631, "Windows 11 Pro"},
+		{"Windows 11 Pro", 26100, "Windows 11 Pro"},
+		{"Windows Server 2022 Datacenter", 20348, "Windows Server 2022 Datacenter"},
+		{"Windows Server 2025 Datacenter", 26100, "Windows Server 2025 Datacenter"},
+	}
+	for _, tt := range tests {
+		if got := WindowsProductName(tt.productName, tt.build); got != tt.want {
+			t.Errorf("WindowsProductName(%q, %d) = %q, want %q", tt.productName, tt.build, got, tt.want)
+		}
+	}
+}
@@ -20,6 +20,8 @@ const (
 	Linux = "linux"
 	// Windows is the default shortname used for Windows system.
 	Windows = "windows"
+	// MacOS is the shortname used for macOS systems.
+	MacOS = "macos"
 )
 
 // OSInfo describes an operating system.
@@ -27,7 +29,9 @@ type OSInfo struct {
 	Hostname, LongName, ShortName, Version, KernelVersion, KernelRelease, Architecture string
 }
 
-// Architecture attempts to standardize architecture naming.
+// Architecture attempts to standardize architecture naming. It is used for
+// the values reported in inventory, which consumers depend on, compare
+// architectures with NormalizeArchitecture instead.
 func Architecture(arch string) string {
 	switch arch {
 	case "amd64", "64-bit":
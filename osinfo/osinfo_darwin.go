@@ -0,0 +1,77 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package osinfo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var swVers = func() ([]byte, error) {
+	return exec.Command("/usr/bin/sw_vers").Output()
+}
+
+// parseSwVers parses the output of sw_vers.
+func parseSwVers(data []byte) *OSInfo {
+	/*
+	   ProductName:		macOS
+	   ProductVersion:		14.2.1
+	   BuildVersion:		23C71
+	*/
+	oi := &OSInfo{ShortName: MacOS}
+	var name string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.TrimSpace(k) {
+		case "ProductName":
+			name = v
+		case "ProductVersion":
+			oi.Version = v
+		}
+	}
+	oi.LongName = strings.TrimSpace(name + " " + oi.Version)
+	return oi
+}
+
+// Get reports OSInfo.
+func Get() (*OSInfo, error) {
+	out, err := swVers()
+	if err != nil {
+		return &OSInfo{ShortName: MacOS}, fmt.Errorf("sw_vers error: %v", err)
+	}
+	oi := parseSwVers(out)
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return oi, fmt.Errorf("unix.Uname error: %v", err)
+	}
+	// unix.Utsname Fields are fixed size byte arrays so we need to trim any trailing null characters.
+	oi.Hostname = string(bytes.TrimRight(uts.Nodename[:], "\x00"))
+	oi.Architecture = Architecture(string(bytes.TrimRight(uts.Machine[:], "\x00")))
+	oi.KernelVersion = string(bytes.TrimRight(uts.Version[:], "\x00"))
+	oi.KernelRelease = string(bytes.TrimRight(uts.Release[:], "\x00"))
+
+	return oi, nil
+}
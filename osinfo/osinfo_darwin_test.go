@@ -0,0 +1,28 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package osinfo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSwVers(t *testing.T) {
+	data := []byte("ProductName:\t\tmacOS\nProductVersion:\t\t14.2.1\nBuildVersion:\t\t23C71\n")
+	want := &OSInfo{ShortName: MacOS, LongName: "macOS 14.2.1", Version: "14.2.1"}
+	if got := parseSwVers(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSwVers() = %+v, want %+v", got, want)
+	}
+}
@@ -33,6 +33,7 @@ const (
 	osRelease = "/etc/os-release"
 	oRelease  = "/etc/oracle-release"
 	rhRelease = "/etc/redhat-release"
+	gRelease  = "/etc/gentoo-release"
 )
 
 func parseOsRelease(releaseDetails string) *OSInfo {
@@ -64,7 +65,7 @@ func parseOsRelease(releaseDetails string) *OSInfo {
 }
 
 func parseEnterpriseRelease(releaseDetails string) *OSInfo {
-	rel := releaseDetails
+	rel := strings.TrimSpace(releaseDetails)
 
 	var sn string
 	switch {
@@ -74,6 +75,8 @@ func parseEnterpriseRelease(releaseDetails string) *OSInfo {
 		sn = "rhel"
 	case strings.Contains(rel, "Oracle"):
 		sn = "ol"
+	case strings.Contains(rel, "Gentoo"):
+		sn = "gentoo"
 	}
 
 	return &OSInfo{
@@ -99,6 +102,9 @@ func Get() (*OSInfo, error) {
 	case util.Exists(rhRelease):
 		releaseFile = rhRelease
 		parseReleaseFunc = parseEnterpriseRelease
+	case util.Exists(gRelease):
+		releaseFile = gRelease
+		parseReleaseFunc = parseEnterpriseRelease
 	}
 
 	b, err := ioutil.ReadFile(releaseFile)
@@ -108,6 +114,14 @@ func Get() (*OSInfo, error) {
 		oi = parseReleaseFunc(string(b))
 	}
 
+	// Gentoo is rolling, older baselayouts have no VERSION_ID in
+	// os-release, the baselayout version is in gentoo-release.
+	if oi.ShortName == "gentoo" && oi.Version == "" {
+		if b, err := ioutil.ReadFile(gRelease); err == nil {
+			oi.Version = parseEnterpriseRelease(string(b)).Version
+		}
+	}
+
 	var uts unix.Utsname
 	if err := unix.Uname(&uts); err != nil {
 		return oi, fmt.Errorf("unix.Uname error: %v", err)
@@ -117,3 +117,25 @@ func TestGetDistributionInfoRedHatRelease(t *testing.T) {
 }
 
 //TODO: add test case for oracle release system
+
+// gentoo-release
+// baselayout version of a gentoo system
+func TestGetDistributionInfoGentooRelease(t *testing.T) {
+	fcontent := "Gentoo Base System release 2.15\n"
+	di := parseEnterpriseRelease(fcontent)
+	tests := []struct {
+		expectation string
+		actual      string
+		errMsg      string
+	}{
+		{"Gentoo Base System 2.15", di.LongName, "unexpected long name"},
+		{"gentoo", di.ShortName, "unexpected short name"},
+		{"2.15", di.Version, "unexpected version id"},
+	}
+
+	for _, v := range tests {
+		if v.actual != v.expectation {
+			t.Errorf("%s! expected(%s); got(%s)", v.errMsg, v.expectation, v.actual)
+		}
+	}
+}
@@ -27,6 +27,7 @@ type aptGetUpgradeOpts struct {
 	excludes          []*Exclude
 	upgradeType       packages.AptUpgradeType
 	dryrun            bool
+	spaceCheck        DiskSpaceCheck
 }
 
 // AptGetUpgradeOption is an option for apt-get update.
@@ -60,6 +61,28 @@ func AptGetDryRun(dryrun bool) AptGetUpgradeOption {
 	}
 }
 
+// AptGetDiskSpaceCheck runs check with the packages to upgrade before
+// installing them.
+func AptGetDiskSpaceCheck(check DiskSpaceCheck) AptGetUpgradeOption {
+	return func(args *aptGetUpgradeOpts) {
+		args.spaceCheck = check
+	}
+}
+
+// splitHeldBack separates the updates apt holds back from the ones it will
+// install.
+func splitHeldBack(pkgs []*packages.PkgInfo) ([]*packages.PkgInfo, []*packages.PkgInfo) {
+	var upgrade, heldBack []*packages.PkgInfo
+	for _, pkg := range pkgs {
+		if pkg.HeldBack != "" {
+			heldBack = append(heldBack, pkg)
+			continue
+		}
+		upgrade = append(upgrade, pkg)
+	}
+	return upgrade, heldBack
+}
+
 // RunAptGetUpgrade runs apt-get upgrade.
 func RunAptGetUpgrade(ctx context.Context, opts ...AptGetUpgradeOption) error {
 	aptOpts := &aptGetUpgradeOpts{
@@ -73,7 +96,7 @@ func RunAptGetUpgrade(ctx context.Context, opts ...AptGetUpgradeOption) error {
 		opt(aptOpts)
 	}
 
-	pkgs, err := packages.AptUpdates(ctx, packages.AptGetUpgradeType(aptOpts.upgradeType), packages.AptGetUpgradeShowNew(true))
+	pkgs, err := packages.AptUpdates(ctx, packages.AptGetUpgradeType(aptOpts.upgradeType), packages.AptGetUpgradeShowNew(true), packages.AptGetUpgradeShowHeldBack(true))
 	if err != nil {
 		return err
 	}
@@ -82,6 +105,9 @@ func RunAptGetUpgrade(ctx context.Context, opts ...AptGetUpgradeOption) error {
 	if err != nil {
 		return err
 	}
+	var heldBack []*packages.PkgInfo
+	fPkgs, heldBack = splitHeldBack(fPkgs)
+	logHeldBack(ctx, heldBack)
 	if len(fPkgs) == 0 {
 		clog.Infof(ctx, "No packages to update.")
 		return nil
@@ -97,6 +123,11 @@ func RunAptGetUpgrade(ctx context.Context, opts ...AptGetUpgradeOption) error {
 		clog.Infof(ctx, "Running in dryrun mode, not updating %s", msg)
 		return nil
 	}
+	if aptOpts.spaceCheck != nil {
+		if err := aptOpts.spaceCheck(ctx, pkgNames); err != nil {
+			return err
+		}
+	}
 
 	ops := opsToReport{
 		packages: fPkgs,
@@ -0,0 +1,36 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package ospatch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+func TestSplitHeldBack(t *testing.T) {
+	curl := &packages.PkgInfo{Name: "curl", Version: "7.81.0-1ubuntu1.16"}
+	libc := &packages.PkgInfo{Name: "libc6", Version: "2.35-0ubuntu3.7", HeldBack: packages.AptHeldBackPhased}
+	nginx := &packages.PkgInfo{Name: "nginx", Version: "1.18.0-6ubuntu14.4", HeldBack: packages.AptHeldBackPin}
+
+	upgrade, heldBack := splitHeldBack([]*packages.PkgInfo{curl, libc, nginx})
+	if want := []*packages.PkgInfo{curl}; !reflect.DeepEqual(upgrade, want) {
+		t.Errorf("splitHeldBack() upgrade = %v, want %v", upgrade, want)
+	}
+	if want := []*packages.PkgInfo{libc, nginx}; !reflect.DeepEqual(heldBack, want) {
+		t.Errorf("splitHeldBack() held back = %v, want %v", heldBack, want)
+	}
+}
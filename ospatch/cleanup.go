@@ -0,0 +1,104 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package ospatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// Post patch cleanup steps.
+const (
+	// CleanupAutoremove removes no longer needed apt dependencies.
+	CleanupAutoremove = "autoremove"
+	// CleanupClean removes cached package files and metadata.
+	CleanupClean = "clean"
+	// CleanupComponents removes superseded Windows components.
+	CleanupComponents = "component-cleanup"
+)
+
+type cleanupFunc func(ctx context.Context) ([]byte, error)
+
+// cleanupSteps returns the commands for a cleanup step that apply to this
+// system.
+var cleanupSteps = func(step string) []cleanupFunc {
+	var fs []cleanupFunc
+	switch step {
+	case CleanupAutoremove:
+		if packages.AptExists {
+			fs = append(fs, packages.AptAutoremove)
+		}
+	case CleanupClean:
+		if packages.AptExists {
+			fs = append(fs, packages.AptClean)
+		}
+		if packages.YumExists {
+			fs = append(fs, packages.YumClean)
+		}
+		if packages.ZypperExists {
+			fs = append(fs, packages.ZypperClean)
+		}
+	case CleanupComponents:
+		if packages.MSIExists {
+			fs = append(fs, packages.DISMComponentCleanup)
+		}
+	}
+	return fs
+}
+
+var freeSpace = util.FreeSpace
+
+// RunPostPatchCleanup runs the given cleanup steps and returns the disk
+// space reclaimed on the system drive. Steps that do not apply to this
+// system are skipped, all steps are attempted even if one fails.
+func RunPostPatchCleanup(ctx context.Context, steps []string) (int64, error) {
+	before, err := freeSpace(systemDrive)
+	if err != nil {
+		clog.Debugf(ctx, "Error getting free space on %q: %v", systemDrive, err)
+	}
+
+	var errs []string
+	for _, step := range steps {
+		switch step {
+		case CleanupAutoremove, CleanupClean, CleanupComponents:
+		default:
+			errs = append(errs, fmt.Sprintf("unknown post patch cleanup step %q", step))
+			continue
+		}
+		for _, f := range cleanupSteps(step) {
+			clog.Debugf(ctx, "Running post patch cleanup step %q.", step)
+			if _, err := f(ctx); err != nil {
+				errs = append(errs, fmt.Sprintf("post patch cleanup step %q: %v", step, err))
+			}
+		}
+	}
+
+	var reclaimed int64
+	if after, aerr := freeSpace(systemDrive); err == nil && aerr == nil && after > before {
+		reclaimed = int64(after - before)
+	}
+	clog.Infof(clog.WithLabels(ctx, map[string]string{"bytes_reclaimed": fmt.Sprint(reclaimed)}), "Post patch cleanup reclaimed %d bytes.", reclaimed)
+
+	if errs != nil {
+		return reclaimed, errors.New(strings.Join(errs, ",\n"))
+	}
+	return reclaimed, nil
+}
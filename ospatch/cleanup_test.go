@@ -0,0 +1,67 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package ospatch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunPostPatchCleanup(t *testing.T) {
+	ctx := context.Background()
+	oldSteps, oldFreeSpace := cleanupSteps, freeSpace
+	defer func() { cleanupSteps, freeSpace = oldSteps, oldFreeSpace }()
+
+	var free uint64 = 1000
+	freeSpace = func(string) (uint64, error) { return free, nil }
+	var ran []string
+	cleanupSteps = func(step string) []cleanupFunc {
+		return []cleanupFunc{func(context.Context) ([]byte, error) {
+			ran = append(ran, step)
+			free += 500
+			if step == CleanupComponents {
+				return nil, errors.New("dism failed")
+			}
+			return nil, nil
+		}}
+	}
+
+	reclaimed, err := RunPostPatchCleanup(ctx, []string{CleanupAutoremove, "bogus", CleanupClean})
+	if err == nil || !strings.Contains(err.Error(), `"bogus"`) {
+		t.Errorf("expected unknown step error, got: %v", err)
+	}
+	if reclaimed != 1000 {
+		t.Errorf("reclaimed = %d, want 1000", reclaimed)
+	}
+	if want := []string{CleanupAutoremove, CleanupClean}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+
+	// Failing steps do not stop later steps.
+	ran = nil
+	reclaimed, err = RunPostPatchCleanup(ctx, []string{CleanupComponents, CleanupClean})
+	if err == nil || !strings.Contains(err.Error(), "dism failed") {
+		t.Errorf("expected step error, got: %v", err)
+	}
+	if want := []string{CleanupComponents, CleanupClean}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+	if reclaimed != 1000 {
+		t.Errorf("reclaimed = %d, want 1000", reclaimed)
+	}
+}
@@ -0,0 +1,177 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package ospatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// DiskSpaceCheck verifies that there is enough disk space to install pkgs.
+type DiskSpaceCheck func(ctx context.Context, pkgs []string) error
+
+// minSystemDriveFree is the free space required on the system drive before
+// Windows updates are installed.
+var minSystemDriveFree uint64 = 2 << 30
+
+// SystemDriveSpaceCheck verifies that the system drive has enough free space
+// to install Windows updates.
+func SystemDriveSpaceCheck() error {
+	return util.CheckDiskSpace(map[string]uint64{systemDrive: minSystemDriveFree})
+}
+
+var distroEOLFile = agentconfig.DistroEOLFile()
+
+// distroEOL describes a distro release that has reached end of life and
+// whose repositories have been moved to an archive.
+type distroEOL struct {
+	ShortName string `json:"shortName"`
+	// Version is the major version of the release.
+	Version string    `json:"version"`
+	EOL     time.Time `json:"eol"`
+	Hint    string    `json:"hint,omitempty"`
+}
+
+type distroEOLTable struct {
+	Releases []*distroEOL `json:"releases"`
+}
+
+// loadDistroEOLs reads the distro EOL table, nil is returned if the file
+// does not exist.
+func loadDistroEOLs(path string) ([]*distroEOL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var t distroEOLTable
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	for i, r := range t.Releases {
+		if r.ShortName == "" || r.Version == "" || r.EOL.IsZero() {
+			return nil, fmt.Errorf("error parsing %s: release %d: shortName, version and eol are required", path, i)
+		}
+	}
+	return t.Releases, nil
+}
+
+// repoGonePatterns are package manager output fragments that indicate that
+// a repository no longer exists, as opposed to a transient failure.
+var repoGonePatterns = []string{
+	"does not have a Release file",
+	"no longer has a Release file",
+	"404  Not Found",
+	"404 Not Found",
+	"HTTP Error 404",
+	"Cannot find a valid baseurl for repo",
+	"Failed to download metadata for repo",
+	"Cannot retrieve repository metadata",
+	"Valid metadata not found at specified URL",
+}
+
+// ReposUnavailableError indicates that the configured package repositories
+// no longer exist, this is usually caused by an end of life distro and will
+// not resolve by retrying.
+type ReposUnavailableError struct {
+	Reason string
+	Hint   string
+}
+
+func (e *ReposUnavailableError) Error() string {
+	msg := "package repositories are unavailable: " + e.Reason
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	return msg
+}
+
+// IsReposUnavailable reports whether err is a ReposUnavailableError.
+func IsReposUnavailable(err error) bool {
+	var e *ReposUnavailableError
+	return errors.As(err, &e)
+}
+
+func majorVersion(version string) string {
+	major, _, _ := strings.Cut(version, ".")
+	return major
+}
+
+// checkDistroEOL returns the EOL hint for oi if eols lists its release as
+// past end of life.
+func checkDistroEOL(eols []*distroEOL, oi *osinfo.OSInfo, now time.Time) (string, bool) {
+	for _, e := range eols {
+		if e.ShortName != oi.ShortName || e.Version != majorVersion(oi.Version) || now.Before(e.EOL) {
+			continue
+		}
+		hint := e.Hint
+		if hint == "" {
+			hint = fmt.Sprintf("%s %s reached end of life on %s", oi.ShortName, e.Version, e.EOL.Format("2006-01-02"))
+		}
+		return hint, true
+	}
+	return "", false
+}
+
+// ClassifyRepoError returns err as a ReposUnavailableError with hint if the
+// package manager output in err shows that the repositories no longer
+// exist, otherwise err is returned as is.
+func ClassifyRepoError(err error, hint string) error {
+	if err == nil {
+		return nil
+	}
+	for _, p := range repoGonePatterns {
+		if strings.Contains(err.Error(), p) {
+			return &ReposUnavailableError{Reason: err.Error(), Hint: hint}
+		}
+	}
+	return err
+}
+
+// DistroEOLHint logs a warning and returns a hint if the distro EOL file
+// lists this system's release as past end of life. It is advisory, patching
+// goes ahead either way.
+func DistroEOLHint(ctx context.Context) string {
+	eols, err := loadDistroEOLs(distroEOLFile)
+	if err != nil {
+		clog.Warningf(ctx, "Error loading the distro EOL table: %v", err)
+		return ""
+	}
+	if len(eols) == 0 {
+		return ""
+	}
+	oi, err := osinfo.Get()
+	if err != nil {
+		clog.Debugf(ctx, "Error getting osinfo for EOL check: %v", err)
+		return ""
+	}
+	hint, eol := checkDistroEOL(eols, oi, time.Now())
+	if eol {
+		clog.Warningf(ctx, "%s %s has reached end of life: %s.", oi.LongName, oi.Version, hint)
+	}
+	return hint
+}
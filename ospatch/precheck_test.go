@@ -0,0 +1,108 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package ospatch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+)
+
+func TestLoadDistroEOLs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "distro_eol.json")
+	if eols, err := loadDistroEOLs(path); eols != nil || err != nil {
+		t.Errorf("loadDistroEOLs() of a missing file = %v, %v, want nil, nil", eols, err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"releases": [{"shortName": "centos", "version": "8", "eol": "2021-12-31T00:00:00Z", "hint": "moved to vault.centos.org"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	eols, err := loadDistroEOLs(path)
+	if err != nil {
+		t.Fatalf("loadDistroEOLs(): unexpected error: %v", err)
+	}
+	want := []*distroEOL{{ShortName: "centos", Version: "8", EOL: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Hint: "moved to vault.centos.org"}}
+	if !reflect.DeepEqual(eols, want) {
+		t.Errorf("loadDistroEOLs() = %+v, want %+v", eols, want)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"releases": [{"shortName": "centos", "version": "8"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadDistroEOLs(path); err == nil {
+		t.Error("expected an error for a release without an eol")
+	}
+}
+
+func TestCheckDistroEOL(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	eols := []*distroEOL{
+		{ShortName: "centos", Version: "7", EOL: time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC), Hint: "moved to vault.centos.org"},
+		{ShortName: "centos", Version: "8", EOL: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), Hint: "moved to vault.centos.org"},
+		{ShortName: "debian", Version: "9", EOL: time.Date(2022, 6, 30, 0, 0, 0, 0, time.UTC)},
+		{ShortName: "debian", Version: "10", EOL: time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)},
+	}
+	var tests = []struct {
+		shortName, version string
+		wantEOL            bool
+	}{
+		{"centos", "8.5.2111", true},
+		{"centos", "7.9.2009", false},
+		{"debian", "9.13", true},
+		{"debian", "10", false},
+		{"debian", "12", false},
+		{"ubuntu", "20.04", false},
+	}
+	for _, tt := range tests {
+		hint, eol := checkDistroEOL(eols, &osinfo.OSInfo{ShortName: tt.shortName, Version: tt.version}, now)
+		if eol != tt.wantEOL {
+			t.Errorf("checkDistroEOL(%s %s) = %t, want %t", tt.shortName, tt.version, eol, tt.wantEOL)
+		}
+		if eol && hint == "" {
+			t.Errorf("checkDistroEOL(%s %s) returned empty hint", tt.shortName, tt.version)
+		}
+	}
+}
+
+func TestClassifyRepoError(t *testing.T) {
+	if err := ClassifyRepoError(nil, ""); err != nil {
+		t.Errorf("expected nil error, got: %v", err)
+	}
+
+	generic := errors.New("Could not get lock /var/lib/apt/lists/lock")
+	if err := ClassifyRepoError(generic, ""); err != generic || IsReposUnavailable(err) {
+		t.Errorf("expected generic error to be unchanged, got: %v", err)
+	}
+
+	gone := errors.New(`E: The repository 'http://deb.debian.org/debian stretch Release' does not have a Release file.`)
+	err := ClassifyRepoError(gone, "moved to archive")
+	if !IsReposUnavailable(err) {
+		t.Fatalf("expected ReposUnavailableError, got: %v", err)
+	}
+	if !IsReposUnavailable(fmt.Errorf("wrapped: %w", err)) {
+		t.Error("expected wrapped error to be a ReposUnavailableError")
+	}
+	if !strings.Contains(err.Error(), "moved to archive") {
+		t.Errorf("expected hint in error, got: %v", err)
+	}
+}
@@ -75,6 +75,29 @@ func logSuccess(ctx context.Context, ops opsToReport) {
 	clog.Infof(clog.WithLabels(ctx, repLabels), msg)
 }
 
+// logVersionLocked logs the packages that are not updated because of a
+// versionlock entry, for the purpose of patch report.
+func logVersionLocked(ctx context.Context, locked []string) {
+	if len(locked) == 0 {
+		return
+	}
+	clog.Infof(clog.WithLabels(ctx, repLabels), "Skipped %d version locked packages: %s", len(locked), strings.Join(locked, ", "))
+}
+
+// logHeldBack logs the updates apt holds back because of pinning or phased
+// updates, for the purpose of patch report. These are not failures, apt is
+// configured not to install them yet.
+func logHeldBack(ctx context.Context, pkgs []*packages.PkgInfo) {
+	if len(pkgs) == 0 {
+		return
+	}
+	var held []string
+	for _, pkg := range pkgs {
+		held = append(held, fmt.Sprintf("%s %s (%s)", pkg.Name, pkg.Version, pkg.HeldBack))
+	}
+	clog.Infof(clog.WithLabels(ctx, repLabels), "Skipped %d updates held back by apt: %s", len(held), strings.Join(held, ", "))
+}
+
 // logFailure logs the failure of patching the packages in pkgs caused by err,
 // for the purpose of patch report.
 func logFailure(ctx context.Context, ops opsToReport, err error) {
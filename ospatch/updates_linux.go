@@ -30,6 +30,15 @@ import (
 
 // SystemRebootRequired checks whether a system reboot is required.
 func SystemRebootRequired(ctx context.Context) (bool, error) {
+	if packages.TransactionalUpdateExists {
+		root, err := packages.TransactionalPendingSnapshot(ctx)
+		if err != nil {
+			clog.Debugf(ctx, "Error checking for a pending transactional-update snapshot: %v", err)
+		} else if root != "" {
+			clog.Debugf(ctx, "Snapshot %q is pending activation, indicating a reboot is required.", root)
+			return true, nil
+		}
+	}
 	if packages.AptExists {
 		clog.Debugf(ctx, "Checking if reboot required by looking at /var/run/reboot-required.")
 		data, err := ioutil.ReadFile("/var/run/reboot-required")
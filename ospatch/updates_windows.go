@@ -20,7 +20,6 @@ package ospatch
 import (
 	"context"
 	"fmt"
-	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
@@ -70,160 +69,27 @@ func SystemRebootRequired(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func checkFilters(ctx context.Context, updt *packages.IUpdate, kbExcludes, classFilter, exclusive_patches []string) (ok bool, err error) {
-	title, err := updt.GetProperty("Title")
-	if err != nil {
-		return false, fmt.Errorf(`updt.GetProperty("Title"): %v`, err)
-	}
-	defer title.Clear()
-
-	defer func() {
-		if ok == true {
-			clog.Debugf(ctx, "Update %q not excluded by any filters.", title.ToString())
-		}
-	}()
-
-	kbArticleIDsRaw, err := updt.GetProperty("KBArticleIDs")
-	if err != nil {
-		return false, fmt.Errorf(`updt.GetProperty("KBArticleIDs"): %v`, err)
-	}
-	defer kbArticleIDsRaw.Clear()
-
-	kbArticleIDs := kbArticleIDsRaw.ToIDispatch()
-	defer kbArticleIDs.Release()
-
-	kbArticleIDsCount, err := packages.GetCount(kbArticleIDs)
-	if err != nil {
-		return false, err
-	}
-
-	if len(exclusive_patches) > 0 {
-		for i := 0; i < int(kbArticleIDsCount); i++ {
-			kbRaw, err := kbArticleIDs.GetProperty("Item", i)
-			if err != nil {
-				return false, err
-			}
-			defer kbRaw.Clear()
-			for _, e := range exclusive_patches {
-				if e == kbRaw.ToString() {
-					// until now we have only seen at most 1 kbarticles
-					// in a patch update. So, if we get a match, we just
-					// install the update
-					return true, nil
-				}
-			}
-		}
-		// since there are exclusive_patches to be installed,
-		// other fields like excludes, classfilter are void
-		return false, nil
-	}
-
-	if len(kbExcludes) > 0 {
-		for i := 0; i < int(kbArticleIDsCount); i++ {
-			kbRaw, err := kbArticleIDs.GetProperty("Item", i)
-			if err != nil {
-				return false, err
-			}
-			defer kbRaw.Clear()
-			for _, e := range kbExcludes {
-				// kbArticleIDs is just the IDs, but users are used to using the KB prefix.
-				if strings.TrimLeft(e, "KkBb") == kbRaw.ToString() {
-					clog.Debugf(ctx, "Update %q (%s) matched exclude filter", title.ToString(), kbRaw.ToString())
-					return false, nil
-				}
-			}
-		}
-	}
-
-	if len(classFilter) == 0 {
-		return true, nil
-	}
-
-	categoriesRaw, err := updt.GetProperty("Categories")
-	if err != nil {
-		return false, fmt.Errorf(`updt.GetProperty("Categories"): %v`, err)
-	}
-	defer categoriesRaw.Clear()
-
-	categories := categoriesRaw.ToIDispatch()
-	defer categories.Release()
-
-	categoriesCount, err := packages.GetCount(categories)
-	if err != nil {
-		return false, err
-	}
-
-	for i := 0; i < int(categoriesCount); i++ {
-		catRaw, err := categories.GetProperty("Item", i)
-		if err != nil {
-			return false, fmt.Errorf(`categories.GetProperty("Item", i): %v`, err)
-		}
-		defer catRaw.Clear()
-
-		cat := catRaw.ToIDispatch()
-		defer cat.Release()
-
-		catIdRaw, err := cat.GetProperty("CategoryID")
-		if err != nil {
-			return false, fmt.Errorf(`cat.GetProperty("CategoryID"): %v`, err)
-		}
-		defer catIdRaw.Clear()
-
-		for _, c := range classFilter {
-			if c == catIdRaw.ToString() {
-				return true, nil
-			}
-		}
-	}
-
-	clog.Debugf(ctx, "Update %q not found in classification filter", title.ToString())
-	return false, nil
-}
-
-// GetWUAUpdates gets WUA updates based on optional classFilter and kbExcludes.
+// GetWUAUpdates gets WUA updates based on optional classFilter and kbExcludes,
+// exclusive patches ignore both.
 func GetWUAUpdates(ctx context.Context, session *packages.IUpdateSession, classFilter, kbExcludes, exclusivePatches []string) (*packages.IUpdateCollection, error) {
-	// Search for all not installed updates but filter out ones that will be installed after a reboot.
-	filter := "IsInstalled=0 AND RebootRequired=0"
+	opts := packages.WUAQueryOptions{Classifications: classFilter, ExcludeKBs: kbExcludes}
+	if len(exclusivePatches) > 0 {
+		opts = packages.WUAQueryOptions{IncludeKBs: exclusivePatches}
+	}
+	// Search for all not installed updates but filter out ones that will be
+	// installed after a reboot. The classification filter is part of the WUA
+	// query so that updates in other classifications are never enumerated.
+	filter := packages.WUAQuery("IsInstalled=0 AND RebootRequired=0", opts.Classifications)
 	clog.Debugf(ctx, "Searching for WUA updates with query %q", filter)
 	updts, err := session.GetWUAUpdateCollection(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("GetWUAUpdateCollection error: %v", err)
 	}
-	if len(classFilter) == 0 && len(kbExcludes) == 0 && len(exclusivePatches) == 0 {
+	if !opts.FiltersResults() {
 		return updts, nil
 	}
 	defer updts.Release()
 
-	count, err := updts.Count()
-	if err != nil {
-		return nil, err
-	}
-	clog.Debugf(ctx, "Found %d total updates avaiable (pre filter).", count)
-
-	newUpdts, err := packages.NewUpdateCollection()
-	if err != nil {
-		return nil, err
-	}
-
-	clog.Debugf(ctx, "Using filters: Excludes: %q, Classifications: %q, ExclusivePatches: %q", kbExcludes, classFilter, exclusivePatches)
-	for i := 0; i < int(count); i++ {
-		updt, err := updts.Item(i)
-		if err != nil {
-			return nil, err
-		}
-
-		ok, err := checkFilters(ctx, updt, kbExcludes, classFilter, exclusivePatches)
-		if err != nil {
-			return nil, err
-		}
-		if !ok {
-			continue
-		}
-
-		if err := newUpdts.Add(updt); err != nil {
-			return nil, err
-		}
-	}
-
-	return newUpdts, nil
+	clog.Debugf(ctx, "Using filters: Excludes: %q, ExclusivePatches: %q", kbExcludes, exclusivePatches)
+	return updts.Filter(opts)
 }
@@ -17,6 +17,7 @@ package ospatch
 import (
 	"context"
 	"fmt"
+	"slices"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
@@ -35,6 +36,7 @@ type yumUpdateOpts struct {
 	security          bool
 	minimal           bool
 	dryrun            bool
+	spaceCheck        DiskSpaceCheck
 }
 
 // YumUpdateOption is an option for yum update.
@@ -78,6 +80,14 @@ func YumDryRun(dryrun bool) YumUpdateOption {
 	}
 }
 
+// YumDiskSpaceCheck runs check with the packages to update before
+// installing them.
+func YumDiskSpaceCheck(check DiskSpaceCheck) YumUpdateOption {
+	return func(args *yumUpdateOpts) {
+		args.spaceCheck = check
+	}
+}
+
 // fullPackageName returns the package name with architecture if present.
 func fullPackageName(pkgInfo *packages.PkgInfo) string {
 	pkgName := pkgInfo.Name
@@ -87,6 +97,36 @@ func fullPackageName(pkgInfo *packages.PkgInfo) string {
 	return pkgName
 }
 
+// versionLockedPackages removes the packages locked by a versionlock entry
+// from pkgs. Yum already leaves locked packages out of the update list, so
+// locked also holds the locked exclusive packages, or every locked package
+// if there are none, as those are the ones that will not be updated.
+func versionLockedPackages(pkgs []*packages.PkgInfo, locks []*packages.VersionLock, exclusivePackages []string) (unlocked []*packages.PkgInfo, locked []string) {
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			locked = append(locked, name)
+		}
+	}
+	for _, pkg := range pkgs {
+		if packages.VersionLocked(locks, pkg.Name) {
+			add(pkg.Name)
+			continue
+		}
+		unlocked = append(unlocked, pkg)
+	}
+	for _, lock := range locks {
+		if lock.Exclude {
+			continue
+		}
+		if len(exclusivePackages) == 0 || slices.Contains(exclusivePackages, lock.Name) {
+			add(lock.Name)
+		}
+	}
+	return unlocked, locked
+}
+
 // RunYumUpdate runs yum update.
 func RunYumUpdate(ctx context.Context, opts ...YumUpdateOption) error {
 	yumOpts := &yumUpdateOpts{
@@ -110,6 +150,15 @@ func RunYumUpdate(ctx context.Context, opts ...YumUpdateOption) error {
 	if err != nil {
 		return err
 	}
+
+	locks, err := packages.YumVersionLocks(ctx)
+	if err != nil {
+		clog.Warningf(ctx, "Error listing yum versionlock entries, continuing without them: %v", err)
+	}
+	var locked []string
+	fPkgs, locked = versionLockedPackages(fPkgs, locks, yumOpts.exclusivePackages)
+	logVersionLocked(ctx, locked)
+
 	if len(fPkgs) == 0 {
 		clog.Infof(ctx, "No packages to update.")
 		return nil
@@ -125,6 +174,11 @@ func RunYumUpdate(ctx context.Context, opts ...YumUpdateOption) error {
 		clog.Infof(ctx, "Running in dryrun mode, not updating %s", msg)
 		return nil
 	}
+	if yumOpts.spaceCheck != nil {
+		if err := yumOpts.spaceCheck(ctx, pkgNames); err != nil {
+			return err
+		}
+	}
 	ops := opsToReport{
 		packages: fPkgs,
 	}
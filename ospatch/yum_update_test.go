@@ -18,6 +18,7 @@ import (
 	"context"
 	"os"
 	"os/exec"
+	"reflect"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/osconfig/packages"
@@ -103,3 +104,34 @@ func TestRunYumUpdateWithSecurityWithExclusives(t *testing.T) {
 		t.Errorf("did not expect error: %+v", err)
 	}
 }
+
+func TestVersionLockedPackages(t *testing.T) {
+	pkgs := []*packages.PkgInfo{{Name: "foo"}, {Name: "bar"}}
+	locks := []*packages.VersionLock{
+		{Name: "bar", Entry: "bar-0:1.0-1.el9.*"},
+		{Name: "kernel", Entry: "kernel-0:5.14.0-362.el9.*"},
+		{Name: "foo", Entry: "!foo-0:2.0-1.el9.*", Exclude: true},
+	}
+
+	tests := []struct {
+		name              string
+		exclusivePackages []string
+		wantUnlocked      []*packages.PkgInfo
+		wantLocked        []string
+	}{
+		{"AllPackages", nil, []*packages.PkgInfo{{Name: "foo"}}, []string{"bar", "kernel"}},
+		{"ExclusivePackages", []string{"foo", "kernel"}, []*packages.PkgInfo{{Name: "foo"}}, []string{"bar", "kernel"}},
+		{"ExclusiveUnlocked", []string{"foo"}, []*packages.PkgInfo{{Name: "foo"}}, []string{"bar"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unlocked, locked := versionLockedPackages(pkgs, locks, tt.exclusivePackages)
+			if !reflect.DeepEqual(unlocked, tt.wantUnlocked) {
+				t.Errorf("unlocked = %v, want %v", unlocked, tt.wantUnlocked)
+			}
+			if !reflect.DeepEqual(locked, tt.wantLocked) {
+				t.Errorf("locked = %v, want %v", locked, tt.wantLocked)
+			}
+		})
+	}
+}
@@ -36,6 +36,7 @@ type zypperPatchOpts struct {
 	withOptional     bool
 	withUpdate       bool
 	dryrun           bool
+	spaceCheck       DiskSpaceCheck
 }
 
 // ZypperPatchOption is an option for zypper patch.
@@ -96,6 +97,14 @@ func ZypperUpdateDryrun(dryrun bool) ZypperPatchOption {
 	}
 }
 
+// ZypperDiskSpaceCheck returns a ZypperUpdateOption that runs check with the
+// patches and packages to install before installing them.
+func ZypperDiskSpaceCheck(check DiskSpaceCheck) ZypperPatchOption {
+	return func(args *zypperPatchOpts) {
+		args.spaceCheck = check
+	}
+}
+
 // RunZypperPatch runs zypper patch.
 func RunZypperPatch(ctx context.Context, opts ...ZypperPatchOption) error {
 	zOpts := &zypperPatchOpts{
@@ -173,6 +182,18 @@ func RunZypperPatch(ctx context.Context, opts ...ZypperPatchOption) error {
 	if zOpts.dryrun {
 		return nil
 	}
+	if zOpts.spaceCheck != nil {
+		var names []string
+		for _, patch := range fPatches {
+			names = append(names, "patch:"+patch.Name)
+		}
+		for _, pkg := range fpkgs {
+			names = append(names, "package:"+pkg.Name)
+		}
+		if err := zOpts.spaceCheck(ctx, names); err != nil {
+			return err
+		}
+	}
 	err = packages.ZypperInstall(ctx, fPatches, fpkgs)
 	if err == nil {
 		logSuccess(ctx, ops)
@@ -0,0 +1,126 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	apk string
+
+	apkUpdateArgs     = []string{"update", "--quiet"}
+	apkInstalledArgs  = []string{"list", "--installed"}
+	apkUpgradableArgs = []string{"list", "--upgradable"}
+	apkInstallArgs    = []string{"add", "--upgrade"}
+	apkRemoveArgs     = []string{"del"}
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		apk = "/sbin/apk"
+	}
+	ApkExists = util.Exists(apk)
+}
+
+// InstallApkPackages installs apk packages, already installed packages
+// are upgraded.
+func InstallApkPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, apk, append(apkInstallArgs, pkgs...))
+	return err
+}
+
+// RemoveApkPackages removes apk packages.
+func RemoveApkPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, apk, append(apkRemoveArgs, pkgs...))
+	return err
+}
+
+// splitApkNameVersion splits "name-version-rN" into name and version,
+// names may contain dashes but versions only contain the release dash.
+func splitApkNameVersion(s string) (string, string, bool) {
+	rel := strings.LastIndex(s, "-")
+	if rel <= 0 {
+		return "", "", false
+	}
+	ver := strings.LastIndex(s[:rel], "-")
+	if ver <= 0 {
+		return "", "", false
+	}
+	return s[:ver], s[ver+1:], true
+}
+
+// parseApkList parses the output of apk list.
+func parseApkList(ctx context.Context, data []byte) []*PkgInfo {
+	/*
+	   musl-1.2.4-r2 x86_64 {musl} (MIT) [installed]
+	   busybox-1.36.1-r5 x86_64 {busybox} (GPL-2.0-only) [upgradable from: busybox-1.36.1-r2]
+	*/
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+
+	var pkgs []*PkgInfo
+	for _, ln := range lines {
+		fields := strings.Fields(string(ln))
+		if len(fields) < 3 || !strings.HasPrefix(fields[2], "{") {
+			clog.Debugf(ctx, "%q does not represent an apk package", ln)
+			continue
+		}
+		name, version, ok := splitApkNameVersion(fields[0])
+		if !ok {
+			clog.Debugf(ctx, "%q does not represent an apk package", ln)
+			continue
+		}
+		pkgs = append(pkgs, &PkgInfo{
+			Name:    name,
+			Arch:    osinfo.Architecture(fields[1]),
+			RawArch: fields[1],
+			Version: version,
+			Source:  Source{Name: strings.Trim(fields[2], "{}")},
+		})
+	}
+	return pkgs
+}
+
+// InstalledApkPackages queries for all installed apk packages.
+func InstalledApkPackages(ctx context.Context) ([]*PkgInfo, error) {
+	out, err := run(ctx, apk, apkInstalledArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseApkList(ctx, out), nil
+}
+
+// ApkUpdates refreshes the package indexes and queries for all available
+// apk updates.
+func ApkUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	if _, err := run(ctx, apk, apkUpdateArgs); err != nil {
+		return nil, fmt.Errorf("error updating apk indexes: %v", err)
+	}
+	out, err := run(ctx, apk, apkUpgradableArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseApkList(ctx, out), nil
+}
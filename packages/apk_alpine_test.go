@@ -0,0 +1,121 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestInstallApkPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(apk, append(apkInstallArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := InstallApkPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("Could not install package")).Times(1)
+	if err := InstallApkPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestRemoveApkPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(apk, append(apkRemoveArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := RemoveApkPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("Could not remove package")).Times(1)
+	if err := RemoveApkPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestParseApkList(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []*PkgInfo
+	}{
+		{
+			"Installed",
+			[]byte("musl-1.2.4-r2 x86_64 {musl} (MIT) [installed]\nca-certificates-bundle-20230506-r0 x86_64 {ca-certificates} (MPL-2.0 AND MIT) [installed]"),
+			[]*PkgInfo{
+				{Name: "musl", Arch: "x86_64", RawArch: "x86_64", Version: "1.2.4-r2", Source: Source{Name: "musl"}},
+				{Name: "ca-certificates-bundle", Arch: "x86_64", RawArch: "x86_64", Version: "20230506-r0", Source: Source{Name: "ca-certificates"}},
+			},
+		},
+		{
+			"Upgradable",
+			[]byte("busybox-1.36.1-r5 aarch64 {busybox} (GPL-2.0-only) [upgradable from: busybox-1.36.1-r2]"),
+			[]*PkgInfo{{Name: "busybox", Arch: "aarch64", RawArch: "aarch64", Version: "1.36.1-r5", Source: Source{Name: "busybox"}}},
+		},
+		{"NoPackages", []byte("nothing here"), nil},
+		{"nil", nil, nil},
+		{"NoRelease", []byte("foo x86_64 {foo} (MIT) [installed]"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseApkList(testCtx, tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseApkList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApkUpdates(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	updateCmd := utilmocks.EqCmd(exec.Command(apk, apkUpdateArgs...))
+	listCmd := utilmocks.EqCmd(exec.Command(apk, apkUpgradableArgs...))
+
+	first := mockCommandRunner.EXPECT().Run(testCtx, updateCmd).Return(nil, nil, nil).Times(1)
+	mockCommandRunner.EXPECT().Run(testCtx, listCmd).After(first).Return([]byte("busybox-1.36.1-r5 x86_64 {busybox} (GPL-2.0-only) [upgradable from: busybox-1.36.1-r2]"), nil, nil).Times(1)
+	got, err := ApkUpdates(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*PkgInfo{{Name: "busybox", Arch: "x86_64", RawArch: "x86_64", Version: "1.36.1-r5", Source: Source{Name: "busybox"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ApkUpdates() = %v, want %v", got, want)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, updateCmd).Return(nil, []byte("stderr"), errors.New("network error")).Times(1)
+	if _, err := ApkUpdates(testCtx); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
@@ -26,6 +26,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/progress"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
@@ -34,6 +35,7 @@ var (
 	dpkgQuery string
 	dpkgDeb   string
 	aptGet    string
+	aptMark   string
 
 	dpkgInstallArgs          = []string{"--install"}
 	dpkgPackageFieldsMapping = map[string]string{
@@ -41,16 +43,25 @@ var (
 		"architecture":   "${Architecture}",
 		"version":        "${Version}",
 		"status":         "${db:Status-Status}",
+		"want":           "${db:Status-Want}",
 		"source_name":    "${source:Package}",
 		"source_version": "${source:Version}",
+		"installed_size": "${Installed-Size}",
 	}
 
 	dpkgQueryArgs     = []string{"-W", "-f", formatFieldsMappingToFormattingString(dpkgPackageFieldsMapping)}
 	dpkgRepairArgs    = []string{"--configure", "-a"}
-	aptGetInstallArgs = []string{"install", "-y"}
+	aptGetInstallArgs = []string{"install", "-y", "-o", "APT::Status-Fd=1"}
 	aptGetRemoveArgs  = []string{"remove", "-y"}
 	aptGetUpdateArgs  = []string{"update"}
 
+	aptGetAutoremoveArgs = []string{"autoremove", "--purge", "-y"}
+	aptGetCleanArgs      = []string{"clean"}
+
+	aptMarkHoldArgs     = []string{"hold"}
+	aptMarkUnholdArgs   = []string{"unhold"}
+	aptMarkShowholdArgs = []string{"showhold"}
+
 	aptGetUpgradeCmd     = "upgrade"
 	aptGetFullUpgradeCmd = "full-upgrade"
 	aptGetDistUpgradeCmd = "dist-upgrade"
@@ -66,6 +77,7 @@ func init() {
 		dpkgQuery = "/usr/bin/dpkg-query"
 		dpkgDeb = "/usr/bin/dpkg-deb"
 		aptGet = "/usr/bin/apt-get"
+		aptMark = "/usr/bin/apt-mark"
 	}
 	AptExists = util.Exists(aptGet)
 	DpkgExists = util.Exists(dpkg)
@@ -87,6 +99,7 @@ const (
 type aptGetUpgradeOpts struct {
 	upgradeType     AptUpgradeType
 	showNew         bool
+	showHeldBack    bool
 	allowDowngrades bool
 }
 
@@ -217,13 +230,18 @@ func DebPkgInfo(ctx context.Context, path string) (*PkgInfo, error) {
 	return parseDpkgDeb(out)
 }
 
-// InstallAptPackages installs apt packages.
-func InstallAptPackages(ctx context.Context, pkgs []string) error {
+// InstallAptPackages installs apt packages, APT::Status-Fd has apt-get
+// report its progress on stdout alongside the usual output.
+func InstallAptPackages(ctx context.Context, pkgs []string) (err error) {
+	defer InvalidateQueryCache()
 	args := append(aptGetInstallArgs, pkgs...)
+	ctx, op := startOperation(ctx, "apt", pkgs, progress.ParseAptStatus)
+	defer func() { err = op.done(err) }()
 	cmdModifiers := []cmdModifier{
 		func(cmd *exec.Cmd) {
 			cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
 		},
+		op.setOutput,
 	}
 	stdout, stderr, err := runAptGetWithDowngradeRetrial(ctx, args, cmdModifiers)
 	if err != nil {
@@ -232,13 +250,14 @@ func InstallAptPackages(ctx context.Context, pkgs []string) error {
 		}
 	}
 	if err != nil {
-		err = fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", aptGet, args, err, stdout, stderr)
+		err = newCommandError(aptGet, args, stdout, stderr, err)
 	}
 	return err
 }
 
 // RemoveAptPackages removes apt packages.
 func RemoveAptPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
 	args := append(aptGetRemoveArgs, pkgs...)
 	cmdModifiers := []cmdModifier{
 		func(cmd *exec.Cmd) {
@@ -252,11 +271,40 @@ func RemoveAptPackages(ctx context.Context, pkgs []string) error {
 		}
 	}
 	if err != nil {
-		err = fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", aptGet, args, err, stdout, stderr)
+		err = newCommandError(aptGet, args, stdout, stderr, err)
 	}
 	return err
 }
 
+// AptHold marks apt packages as held so they are not upgraded or removed.
+func AptHold(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, aptMark, append(aptMarkHoldArgs, pkgs...))
+	return err
+}
+
+// AptUnhold removes the hold from apt packages.
+func AptUnhold(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, aptMark, append(aptMarkUnholdArgs, pkgs...))
+	return err
+}
+
+// AptHeldPackages returns the names of the held apt packages.
+func AptHeldPackages(ctx context.Context) ([]string, error) {
+	out, err := run(ctx, aptMark, aptMarkShowholdArgs)
+	if err != nil {
+		return nil, err
+	}
+	var held []string
+	for _, ln := range strings.Split(string(out), "\n") {
+		if name := strings.TrimSpace(ln); name != "" {
+			held = append(held, name)
+		}
+	}
+	return held, nil
+}
+
 func parseAptUpdates(ctx context.Context, data []byte, showNew bool) []*PkgInfo {
 	/*
 		Inst libldap-common [2.4.45+dfsg-1ubuntu1.2] (2.4.45+dfsg-1ubuntu1.3 Ubuntu:18.04/bionic-updates, Ubuntu:18.04/bionic-security [all])
@@ -301,8 +349,12 @@ func parseAptUpdates(ctx context.Context, data []byte, showNew bool) []*PkgInfo
 }
 
 // AptUpdates returns all the packages that will be installed when running
-// apt-get [dist-|full-]upgrade.
+// apt-get [dist-|full-]upgrade. With AptGetUpgradeShowHeldBack the updates
+// apt holds back because of pinning or phased updates are returned as well,
+// these have HeldBack set.
 func AptUpdates(ctx context.Context, opts ...AptGetUpgradeOption) ([]*PkgInfo, error) {
+	ctx, cancel := withOperationTimeout(ctx, "apt", OpResolve)
+	defer cancel()
 	aptOpts := &aptGetUpgradeOpts{
 		upgradeType:     AptGetUpgrade,
 		showNew:         false,
@@ -338,11 +390,23 @@ func AptUpdates(ctx context.Context, opts ...AptGetUpgradeOption) ([]*PkgInfo, e
 		return nil, err
 	}
 
-	return parseAptUpdates(ctx, out, aptOpts.showNew), nil
+	pkgs := parseAptUpdates(ctx, out, aptOpts.showNew)
+	if !aptOpts.showHeldBack {
+		return pkgs, nil
+	}
+	held, err := aptHeldBack(ctx, args, pkgs, aptOpts.showNew)
+	if err != nil {
+		clog.Warningf(ctx, "Error looking for apt updates held back by pinning or phased updates: %v", err)
+		return pkgs, nil
+	}
+	return append(pkgs, held...), nil
 }
 
 // AptUpdate runs apt-get update.
 func AptUpdate(ctx context.Context) ([]byte, error) {
+	defer InvalidateQueryCache()
+	ctx, cancel := withOperationTimeout(ctx, "apt", OpRefresh)
+	defer cancel()
 	stdout, _, err := runAptGet(ctx, aptGetUpdateArgs, []cmdModifier{
 		func(cmd *exec.Cmd) {
 			cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
@@ -351,17 +415,46 @@ func AptUpdate(ctx context.Context) ([]byte, error) {
 	return stdout, err
 }
 
-// InstalledDebPackages queries for all installed deb packages.
+// AptAutoremove runs apt-get autoremove --purge, removing packages that
+// were installed as dependencies and are no longer needed.
+func AptAutoremove(ctx context.Context) ([]byte, error) {
+	defer InvalidateQueryCache()
+	stdout, stderr, err := runAptGet(ctx, aptGetAutoremoveArgs, []cmdModifier{
+		func(cmd *exec.Cmd) {
+			cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		},
+	})
+	if err != nil {
+		err = newCommandError(aptGet, aptGetAutoremoveArgs, stdout, stderr, err)
+	}
+	return stdout, err
+}
+
+// AptClean runs apt-get clean, removing downloaded package files.
+func AptClean(ctx context.Context) ([]byte, error) {
+	return run(ctx, aptGet, aptGetCleanArgs)
+}
+
+// InstalledDebPackages queries for all installed deb packages. The dpkg
+// database is read directly, dpkg-query is used if that fails.
 func InstalledDebPackages(ctx context.Context) ([]*PkgInfo, error) {
+	pkgs, err := dpkgStatusPackages(ctx)
+	if err == nil {
+		return pkgs, nil
+	}
+	clog.Debugf(ctx, "Error reading the dpkg database, falling back to dpkg-query: %v", err)
+
 	out, err := run(ctx, dpkgQuery, dpkgQueryArgs)
 	if err != nil {
 		return nil, err
 	}
 
-	return parseInstalledDebPackages(ctx, out), nil
+	return parseInstalledDebPackages(ctx, out, dpkgInfoDir), nil
 }
 
-func parseInstalledDebPackages(ctx context.Context, data []byte) []*PkgInfo {
+// parseInstalledDebPackages parses dpkg-query output, install times are
+// read from the package file lists in infoDir unless it is empty.
+func parseInstalledDebPackages(ctx context.Context, data []byte, infoDir string) []*PkgInfo {
 	/*
 		Each line contains an entry in a json format, keep in mind that whole output is not valid json.
 
@@ -384,6 +477,10 @@ func parseInstalledDebPackages(ctx context.Context, data []byte) []*PkgInfo {
 		if dpkg.Status != "installed" {
 			continue
 		}
+		pkg.Held = dpkg.Want == "hold"
+		if infoDir != "" {
+			pkg.InstallTime = dpkgInstallTime(infoDir, dpkg.Package, dpkg.Architecture)
+		}
 
 		result = append(result, pkg)
 	}
@@ -393,6 +490,7 @@ func parseInstalledDebPackages(ctx context.Context, data []byte) []*PkgInfo {
 
 // DpkgInstall installs a deb package.
 func DpkgInstall(ctx context.Context, path string) error {
+	defer InvalidateQueryCache()
 	_, err := run(ctx, dpkg, append(dpkgInstallArgs, path))
 	return err
 }
@@ -16,10 +16,13 @@ package packages
 import (
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"slices"
 	"testing"
+	"time"
 
 	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
 	"github.com/golang/mock/gomock"
@@ -112,7 +115,7 @@ func TestInstallAptPackages(t *testing.T) {
 				},
 			},
 			expectedError: errors.New("error running /usr/bin/apt-get with args" +
-				" [\"install\" \"-y\" \"pkg1\" \"pkg2\"]:" +
+				" [\"install\" \"-y\" \"-o\" \"APT::Status-Fd=1\" \"pkg1\" \"pkg2\"]:" +
 				" unexpected error, stdout: \"stdout\", stderr: \"stderr\""),
 		},
 		{
@@ -143,7 +146,7 @@ func TestInstallAptPackages(t *testing.T) {
 				},
 			},
 			expectedError: errors.New("error running /usr/bin/apt-get with args" +
-				" [\"install\" \"-y\" \"pkg1\" \"pkg2\"]:" +
+				" [\"install\" \"-y\" \"-o\" \"APT::Status-Fd=1\" \"pkg1\" \"pkg2\"]:" +
 				" unexpected error, stdout: \"stdout\", stderr: \"stderr\""),
 		},
 	}
@@ -159,7 +162,7 @@ func TestInstallAptPackages(t *testing.T) {
 			setExpectations(mockCommandRunner, tt.expectedCommandsChain)
 
 			err := InstallAptPackages(testCtx, tt.pkgs)
-			if !reflect.DeepEqual(err, tt.expectedError) {
+			if formatError(err) != formatError(tt.expectedError) {
 				t.Errorf("InstallAptPackages: unexpected error, expect %q, got %q", formatError(tt.expectedError), formatError(err))
 			}
 		})
@@ -337,6 +340,47 @@ func TestAptUpdates(t *testing.T) {
 			},
 			expectedError: nil,
 		},
+		{
+			name: "Show held back updates",
+			args: []AptGetUpgradeOption{AptGetUpgradeShowHeldBack(true)},
+			expectedCommandsChain: []expectedCommand{
+				{
+					cmd:    exec.Command(aptGet, aptGetUpdateArgs...),
+					envs:   []string{"DEBIAN_FRONTEND=noninteractive"},
+					stdout: []byte("stdout"),
+					stderr: []byte(""),
+					err:    nil,
+				},
+				{
+					cmd:    exec.Command(aptGet, append(slices.Clone(aptGetUpgradableArgs), aptGetUpgradeCmd)...),
+					envs:   []string{"DEBIAN_FRONTEND=noninteractive"},
+					stdout: []byte("Inst google-cloud-sdk [245.0.0-0] (246.0.0-0 cloud-sdk-stretch:cloud-sdk-stretch [amd64])"),
+					stderr: []byte(""),
+					err:    nil,
+				},
+				{
+					cmd:  exec.Command(aptGet, append(append(slices.Clone(aptGetUnrestrictedArgs), aptGetUpgradableArgs...), aptGetUpgradeCmd)...),
+					envs: []string{"DEBIAN_FRONTEND=noninteractive"},
+					stdout: []byte(
+						"Inst google-cloud-sdk [245.0.0-0] (246.0.0-0 cloud-sdk-stretch:cloud-sdk-stretch [amd64])\n" +
+							"Inst libc6 [2.35-0ubuntu3.6] (2.35-0ubuntu3.7 Ubuntu:22.04/jammy-updates [amd64])\n" +
+							"Inst nginx [1.18.0-6ubuntu14.3] (1.18.0-6ubuntu14.4 Ubuntu:22.04/jammy-updates [amd64])"),
+					stderr: []byte(""),
+					err:    nil,
+				},
+				{
+					cmd: exec.Command(aptCache, "policy", "libc6", "nginx"),
+					stdout: []byte("libc6:\n  Installed: 2.35-0ubuntu3.6\n  Candidate: 2.35-0ubuntu3.7\n  Version table:\n     2.35-0ubuntu3.7 500 (phased 10%)\n" +
+						"nginx:\n  Installed: 1.18.0-6ubuntu14.3\n  Candidate: 1.18.0-6ubuntu14.3\n  Version table:\n     1.18.0-6ubuntu14.4 500\n"),
+				},
+			},
+			expectedResults: []*PkgInfo{
+				{Name: "google-cloud-sdk", Arch: "x86_64", Version: "246.0.0-0"},
+				{Name: "libc6", Arch: "x86_64", Version: "2.35-0ubuntu3.7", HeldBack: AptHeldBackPhased},
+				{Name: "nginx", Arch: "x86_64", Version: "1.18.0-6ubuntu14.4", HeldBack: AptHeldBackPin},
+			},
+			expectedError: nil,
+		},
 		{
 			name: "Add --allow-downgrades when specific error provided.",
 			args: nil,
@@ -501,7 +545,7 @@ func TestRemoveAptPackages(t *testing.T) {
 			setExpectations(mockCommandRunner, tt.expectedCommandsChain)
 
 			err := RemoveAptPackages(testCtx, tt.pkgs)
-			if !reflect.DeepEqual(err, tt.expectedError) {
+			if formatError(err) != formatError(tt.expectedError) {
 				t.Errorf("RemoveAptPackages: unexpected error, expect %q, got %q", formatError(tt.expectedError), formatError(err))
 			}
 		})
@@ -515,9 +559,23 @@ func TestInstalledDebPackages(t *testing.T) {
 	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
 	runner = mockCommandRunner
 
+	// Without a dpkg database dpkg-query is used.
+	oldStatusFile, oldInfoDir := dpkgStatusFile, dpkgInfoDir
+	defer func() { dpkgStatusFile, dpkgInfoDir = oldStatusFile, oldInfoDir }()
+	dpkgStatusFile = filepath.Join(t.TempDir(), "status")
+	dpkgInfoDir = t.TempDir()
+	installed := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	list := filepath.Join(dpkgInfoDir, "git.list")
+	if err := os.WriteFile(list, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(list, installed, installed); err != nil {
+		t.Fatal(err)
+	}
+
 	//Successfully returns result
 	dpkgQueryCmd := utilmocks.EqCmd(exec.Command(dpkgQuery, dpkgQueryArgs...))
-	stdout := []byte(`{"package":"git","architecture":"amd64","version":"1:2.25.1-1ubuntu3.12","status":"installed","source_name":"git","source_version":"1:2.25.1-1ubuntu3.12"}`)
+	stdout := []byte(`{"package":"git","architecture":"amd64","version":"1:2.25.1-1ubuntu3.12","status":"installed","source_name":"git","source_version":"1:2.25.1-1ubuntu3.12","installed_size":"36934"}`)
 	stderr := []byte("stderr")
 	mockCommandRunner.EXPECT().Run(testCtx, dpkgQueryCmd).Return(stdout, stderr, nil).Times(1)
 
@@ -526,7 +584,7 @@ func TestInstalledDebPackages(t *testing.T) {
 		t.Errorf("InstalledDebPackages(): got unexpected error: %v", err)
 	}
 
-	want := []*PkgInfo{{Name: "git", Arch: "x86_64", Version: "1:2.25.1-1ubuntu3.12", Source: Source{Name: "git", Version: "1:2.25.1-1ubuntu3.12"}}}
+	want := []*PkgInfo{{Name: "git", Arch: "x86_64", Version: "1:2.25.1-1ubuntu3.12", Source: Source{Name: "git", Version: "1:2.25.1-1ubuntu3.12"}, InstallTime: &installed, Size: 36934 * 1024}}
 	if !reflect.DeepEqual(result, want) {
 		t.Errorf("InstalledDebPackages() = %v, want %v", result, want)
 	}
@@ -570,6 +628,15 @@ func TestParseInstalledDebpackages(t *testing.T) {
 				`{"package":"python3-gi","architecture":"amd64","version":"3.36.0-1","status":"installed","source_name":"pygobject","source_version":"3.36.0-1"}`),
 			want: []*PkgInfo{{Name: "python3-gi", Arch: "x86_64", Version: "3.36.0-1", Source: Source{Name: "pygobject", Version: "3.36.0-1"}}},
 		},
+		{
+			name: "Held packages",
+			input: []byte("" +
+				`{"package":"python3-gi","architecture":"amd64","version":"3.36.0-1","status":"installed","want":"hold","source_name":"pygobject","source_version":"3.36.0-1"}` + "\n" +
+				`{"package":"man-db","architecture":"amd64","version":"2.9.1-1","status":"installed","want":"install","source_name":"man-db","source_version":"2.9.1-1"}`),
+			want: []*PkgInfo{
+				{Name: "python3-gi", Arch: "x86_64", Version: "3.36.0-1", Source: Source{Name: "pygobject", Version: "3.36.0-1"}, Held: true},
+				{Name: "man-db", Arch: "x86_64", Version: "2.9.1-1", Source: Source{Name: "man-db", Version: "2.9.1-1"}}},
+		},
 		{
 			name: "Skip entries that have status other than 'installed'",
 			input: []byte("" +
@@ -581,13 +648,42 @@ func TestParseInstalledDebpackages(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := parseInstalledDebPackages(testCtx, tt.input); !reflect.DeepEqual(got, tt.want) {
+			if got := parseInstalledDebPackages(testCtx, tt.input, ""); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("parseInstalledDebPackages() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestAptHold(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	holdCmd := utilmocks.EqCmd(exec.Command(aptMark, "hold", "pkg1", "pkg2"))
+	mockCommandRunner.EXPECT().Run(testCtx, holdCmd).Return([]byte("pkg1 set on hold."), nil, nil).Times(1)
+	if err := AptHold(testCtx, []string{"pkg1", "pkg2"}); err != nil {
+		t.Errorf("AptHold(): unexpected error: %v", err)
+	}
+
+	unholdCmd := utilmocks.EqCmd(exec.Command(aptMark, "unhold", "pkg1"))
+	mockCommandRunner.EXPECT().Run(testCtx, unholdCmd).Return(nil, []byte("stderr"), errors.New("error")).Times(1)
+	if err := AptUnhold(testCtx, []string{"pkg1"}); err == nil {
+		t.Errorf("AptUnhold(): did not get expected error")
+	}
+
+	showholdCmd := utilmocks.EqCmd(exec.Command(aptMark, aptMarkShowholdArgs...))
+	mockCommandRunner.EXPECT().Run(testCtx, showholdCmd).Return([]byte("pkg1\npkg2\n"), nil, nil).Times(1)
+	held, err := AptHeldPackages(testCtx)
+	if err != nil {
+		t.Fatalf("AptHeldPackages(): unexpected error: %v", err)
+	}
+	if want := []string{"pkg1", "pkg2"}; !reflect.DeepEqual(held, want) {
+		t.Errorf("AptHeldPackages() = %q, want %q", held, want)
+	}
+}
+
 func TestParseAptUpdates(t *testing.T) {
 	normalCase := `
 Inst libldap-common [2.4.45+dfsg-1ubuntu1.2] (2.4.45+dfsg-1ubuntu1.3 Ubuntu:18.04/bionic-updates, Ubuntu:18.04/bionic-security [all])
@@ -0,0 +1,165 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Reasons apt holds back an available update, set in PkgInfo.HeldBack.
+const (
+	// AptHeldBackPin is set for updates an apt preferences pin keeps from
+	// being the candidate version.
+	AptHeldBackPin = "pin"
+	// AptHeldBackPhased is set for updates deferred by Ubuntu phased
+	// updates, they are rolled out to a growing share of machines.
+	AptHeldBackPhased = "phased"
+)
+
+// aptGetUnrestrictedArgs make an apt-get simulation ignore pinning and
+// phased updates. apt silently skips preferences files that do not exist.
+var aptGetUnrestrictedArgs = []string{
+	"-o", "APT::Get::Always-Include-Phased-Updates=true",
+	"-o", "Dir::Etc::preferences=/nonexistent",
+	"-o", "Dir::Etc::preferencesparts=/nonexistent",
+}
+
+// AptGetUpgradeShowHeldBack returns a AptGetUpgradeOption that indicates
+// whether updates held back by pinning or phased updates should be returned,
+// with HeldBack set to the reason.
+func AptGetUpgradeShowHeldBack(showHeldBack bool) AptGetUpgradeOption {
+	return func(args *aptGetUpgradeOpts) {
+		args.showHeldBack = showHeldBack
+	}
+}
+
+// aptHeldBack returns the updates that the upgrade simulated with args
+// leaves out because of pinning or phased updates. These are the updates a
+// simulation ignoring both would install, classified with apt-cache policy.
+func aptHeldBack(ctx context.Context, args []string, updates []*PkgInfo, showNew bool) ([]*PkgInfo, error) {
+	out, _, err := runAptGetWithDowngradeRetrial(ctx, append(append([]string(nil), aptGetUnrestrictedArgs...), args...), []cmdModifier{
+		func(cmd *exec.Cmd) {
+			cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	upgrading := make(map[string]bool, len(updates))
+	for _, pkg := range updates {
+		upgrading[pkg.Name] = true
+	}
+	var blocked []*PkgInfo
+	var names []string
+	for _, pkg := range parseAptUpdates(ctx, out, showNew) {
+		if !upgrading[pkg.Name] {
+			blocked = append(blocked, pkg)
+			names = append(names, pkg.Name)
+		}
+	}
+	if len(blocked) == 0 {
+		return nil, nil
+	}
+
+	out, err = run(ctx, aptCache, append(aptCachePolicyArgs, names...))
+	if err != nil {
+		return nil, err
+	}
+	policies := parseAptCandidates(out)
+	var held []*PkgInfo
+	for _, pkg := range blocked {
+		p, ok := policies[pkg.Name]
+		switch {
+		case !ok:
+			continue
+		case p.candidate == pkg.Version && p.phased:
+			pkg.HeldBack = AptHeldBackPhased
+		case p.candidate != pkg.Version:
+			pkg.HeldBack = AptHeldBackPin
+		default:
+			// Kept back for another reason, such as apt-get upgrade not
+			// installing new dependencies, which is not reported here.
+			continue
+		}
+		held = append(held, pkg)
+	}
+	return held, nil
+}
+
+type aptCandidate struct {
+	candidate string
+	// phased is set if the candidate version is a phased update.
+	phased bool
+}
+
+// parseAptCandidates returns the candidate version of each package in
+// apt-cache policy output.
+func parseAptCandidates(data []byte) map[string]*aptCandidate {
+	/*
+		libc6:
+		  Installed: 2.35-0ubuntu3.6
+		  Candidate: 2.35-0ubuntu3.7
+		  Version table:
+		     2.35-0ubuntu3.7 500 (phased 10%)
+		        500 http://archive.ubuntu.com/ubuntu jammy-updates/main amd64 Packages
+		 *** 2.35-0ubuntu3.6 100
+		        100 /var/lib/dpkg/status
+		google-cloud-cli:
+		  Installed: 470.0.0-0
+		  Candidate: 470.0.0-0
+		  Package pin: 470.0.0-0
+		  Version table:
+		     471.0.0-0 1001
+		        500 https://packages.cloud.google.com/apt cloud-sdk/main amd64 Packages
+		 *** 470.0.0-0 1001
+		        100 /var/lib/dpkg/status
+	*/
+	candidates := map[string]*aptCandidate{}
+	var cur *aptCandidate
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' {
+			cur = &aptCandidate{}
+			candidates[strings.TrimSuffix(line, ":")] = cur
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "***"))
+		switch {
+		case len(fields) == 2 && fields[0] == "Candidate:":
+			cur.candidate = fields[1]
+		case len(fields) >= 2 && fields[0] == cur.candidate:
+			// A version table entry has a numeric priority after the
+			// version, source lines have a path or URL.
+			if _, err := strconv.Atoi(fields[1]); err == nil && strings.Contains(line, "(phased ") {
+				cur.phased = true
+			}
+		}
+	}
+	return candidates
+}
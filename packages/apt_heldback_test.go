@@ -0,0 +1,56 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseAptCandidates(t *testing.T) {
+	data := []byte(`libc6:
+  Installed: 2.35-0ubuntu3.6
+  Candidate: 2.35-0ubuntu3.7
+  Version table:
+     2.35-0ubuntu3.7 500 (phased 10%)
+        500 http://archive.ubuntu.com/ubuntu jammy-updates/main amd64 Packages
+ *** 2.35-0ubuntu3.6 100
+        100 /var/lib/dpkg/status
+google-cloud-cli:
+  Installed: 470.0.0-0
+  Candidate: 470.0.0-0
+  Package pin: 470.0.0-0
+  Version table:
+     471.0.0-0 1001
+        500 https://packages.cloud.google.com/apt cloud-sdk/main amd64 Packages
+ *** 470.0.0-0 1001
+        100 /var/lib/dpkg/status
+curl:
+  Installed: 7.81.0-1ubuntu1.15
+  Candidate: 7.81.0-1ubuntu1.16
+  Version table:
+     7.81.0-1ubuntu1.16 500
+        500 http://archive.ubuntu.com/ubuntu jammy-updates/main amd64 Packages
+`)
+	want := map[string]*aptCandidate{
+		"libc6":            {candidate: "2.35-0ubuntu3.7", phased: true},
+		"google-cloud-cli": {candidate: "470.0.0-0"},
+		"curl":             {candidate: "7.81.0-1ubuntu1.16"},
+	}
+	if diff := cmp.Diff(want, parseAptCandidates(data), cmp.AllowUnexported(aptCandidate{})); diff != "" {
+		t.Errorf("parseAptCandidates() (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,154 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// Fixture sizes are modelled on the largest inventories seen in the field,
+// a package mirror host with every Debian package installed.
+const (
+	benchDpkgEntries = 50000
+	benchRPMEntries  = 20000
+	benchAptUpgrades = 10000
+)
+
+// dpkgFixture returns dpkg-query output with n installed packages, one in
+// every 100 of them held.
+func dpkgFixture(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		want := "install"
+		if i%100 == 0 {
+			want = "hold"
+		}
+		fmt.Fprintf(&buf, `{"package":"package-%d","architecture":"amd64","version":"1:%d.%d.%d-1ubuntu%d","status":"installed","want":"%s","source_name":"source-%d","source_version":"%d.%d.%d-1"}`+"\n",
+			i, i%7, i%13, i%29, i%3, want, i/4, i%7, i%13, i%29)
+	}
+	return buf.Bytes()
+}
+
+// rpmFixture returns rpmquery output with n installed packages.
+func rpmFixture(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `{"architecture":"x86_64","package":"package-%d","source_name":"package-%d-%d.%d-%d.el9.src.rpm","version":"%d.%d-%d.el9"}`+"\n",
+			i, i, i%7, i%13, i%5, i%7, i%13, i%5)
+	}
+	return buf.Bytes()
+}
+
+// aptUpgradeFixture returns apt-get upgrade simulation output with n
+// upgraded packages, one in every 10 of them newly installed.
+func aptUpgradeFixture(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("Reading package lists...\nBuilding dependency tree...\nReading state information...\nCalculating upgrade...\n")
+	for i := 0; i < n; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(&buf, "Inst package-%d (%d.%d-1 Debian:12.5/stable [amd64])\n", i, i%7, i%13)
+			continue
+		}
+		fmt.Fprintf(&buf, "Inst package-%d [%d.%d-1] (%d.%d-2 Debian-Security:12/stable-security [amd64])\n", i, i%7, i%13, i%7, i%13)
+	}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "Conf package-%d (%d.%d-2 Debian-Security:12/stable-security [amd64])\n", i, i%7, i%13)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkParseInstalledDebPackages(b *testing.B) {
+	data := dpkgFixture(benchDpkgEntries)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseInstalledDebPackages(testCtx, data, "")
+	}
+}
+
+func BenchmarkParseInstalledRPMPackages(b *testing.B) {
+	data := rpmFixture(benchRPMEntries)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseInstalledRPMPackages(testCtx, data)
+	}
+}
+
+func BenchmarkParseAptUpdates(b *testing.B) {
+	data := aptUpgradeFixture(benchAptUpgrades)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseAptUpdates(testCtx, data, true)
+	}
+}
+
+// allocsPerEntry runs f once and returns the heap allocations and bytes it
+// made divided by entries.
+func allocsPerEntry(entries int, f func()) (allocs, allocBytes float64) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	// Warm up so one time initialisation is not counted.
+	f()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return float64(after.Mallocs-before.Mallocs) / float64(entries), float64(after.TotalAlloc-before.TotalAlloc) / float64(entries)
+}
+
+// TestParserAllocationBudgets fails when a parser starts allocating
+// noticeably more per entry on the large fixtures than it does today, the
+// budgets leave about 50% headroom over the measured values. Run the
+// benchmarks in this file to look at CPU time.
+func TestParserAllocationBudgets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large fixture allocation budgets in short mode")
+	}
+	if raceEnabled {
+		t.Skip("skipping allocation budgets, the race detector allocates")
+	}
+	dpkg := dpkgFixture(benchDpkgEntries)
+	rpm := rpmFixture(benchRPMEntries)
+	apt := aptUpgradeFixture(benchAptUpgrades)
+
+	tests := []struct {
+		name                string
+		entries             int
+		f                   func()
+		maxAllocs, maxBytes float64
+	}{
+		{"parseInstalledDebPackages", benchDpkgEntries, func() { parseInstalledDebPackages(testCtx, dpkg, "") }, 8, 540},
+		{"parseInstalledRPMPackages", benchRPMEntries, func() { parseInstalledRPMPackages(testCtx, rpm) }, 7, 550},
+		{"parseAptUpdates", benchAptUpgrades, func() { parseAptUpdates(testCtx, apt, true) }, 9, 760},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocs, allocBytes := allocsPerEntry(tt.entries, tt.f)
+			if allocs > tt.maxAllocs {
+				t.Errorf("%s made %.2f allocations per entry, budget is %.0f", tt.name, allocs, tt.maxAllocs)
+			}
+			if allocBytes > tt.maxBytes {
+				t.Errorf("%s allocated %.0f bytes per entry, budget is %.0f", tt.name, allocBytes, tt.maxBytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	brew string
+
+	// The default prefixes, on macOS these are Apple silicon then Intel.
+	brewPaths = map[string][]string{
+		"linux":  {"/home/linuxbrew/.linuxbrew/bin/brew"},
+		"darwin": {"/opt/homebrew/bin/brew", "/usr/local/bin/brew"},
+	}[runtime.GOOS]
+
+	brewInstalledArgs = []string{"info", "--json=v2", "--installed"}
+	brewOutdatedArgs  = []string{"outdated", "--json=v2"}
+	brewInstallArgs   = []string{"install"}
+	brewRemoveArgs    = []string{"uninstall"}
+
+	// Updating the taps is left to the owner of the installation.
+	brewEnv = []string{"HOMEBREW_NO_AUTO_UPDATE=1", "HOMEBREW_NO_ANALYTICS=1", "HOMEBREW_NO_ENV_HINTS=1"}
+)
+
+func init() {
+	for _, p := range brewPaths {
+		if util.Exists(p) {
+			brew = p
+			break
+		}
+	}
+	BrewExists = util.Exists(brew)
+}
+
+// runBrew runs brew as the owner of the installation, Homebrew refuses to
+// run as root.
+func runBrew(ctx context.Context, args []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, brew, args...)
+	cmd.Env = append(os.Environ(), brewEnv...)
+	if err := brewAsOwner(cmd); err != nil {
+		return nil, err
+	}
+	stdout, stderr, err := runner.Run(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", brew, args, err, stdout, stderr)
+	}
+	return stdout, nil
+}
+
+// InstallBrewPackages installs Homebrew formulae or casks.
+func InstallBrewPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := runBrew(ctx, append(brewInstallArgs, pkgs...))
+	return err
+}
+
+// RemoveBrewPackages uninstalls Homebrew formulae or casks.
+func RemoveBrewPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := runBrew(ctx, append(brewRemoveArgs, pkgs...))
+	return err
+}
+
+type brewInfo struct {
+	Formulae []struct {
+		Name      string `json:"name"`
+		Installed []struct {
+			Version string `json:"version"`
+		} `json:"installed"`
+	} `json:"formulae"`
+	Casks []struct {
+		Token     string `json:"token"`
+		Installed string `json:"installed"`
+	} `json:"casks"`
+}
+
+// parseBrewInfo parses the output of brew info --json=v2 --installed.
+func parseBrewInfo(data []byte) ([]*PkgInfo, error) {
+	var info brewInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("error parsing brew info output: %v", err)
+	}
+	var pkgs []*PkgInfo
+	for _, f := range info.Formulae {
+		// Several versions may be installed, the last is linked.
+		if len(f.Installed) == 0 {
+			continue
+		}
+		pkgs = append(pkgs, &PkgInfo{Name: f.Name, Arch: noarch, Version: f.Installed[len(f.Installed)-1].Version})
+	}
+	for _, c := range info.Casks {
+		pkgs = append(pkgs, &PkgInfo{Name: c.Token, Arch: noarch, Version: c.Installed})
+	}
+	return pkgs, nil
+}
+
+type brewOutdated struct {
+	Name           string `json:"name"`
+	CurrentVersion string `json:"current_version"`
+	Pinned         bool   `json:"pinned"`
+}
+
+// parseBrewOutdated parses the output of brew outdated --json=v2.
+func parseBrewOutdated(data []byte) ([]*PkgInfo, error) {
+	var outdated struct {
+		Formulae []brewOutdated `json:"formulae"`
+		Casks    []brewOutdated `json:"casks"`
+	}
+	if err := json.Unmarshal(data, &outdated); err != nil {
+		return nil, fmt.Errorf("error parsing brew outdated output: %v", err)
+	}
+	var pkgs []*PkgInfo
+	for _, o := range append(outdated.Formulae, outdated.Casks...) {
+		// Pinned formulae are never upgraded.
+		if o.Pinned {
+			continue
+		}
+		pkgs = append(pkgs, &PkgInfo{Name: o.Name, Arch: noarch, Version: o.CurrentVersion})
+	}
+	return pkgs, nil
+}
+
+// InstalledBrewPackages queries for all installed Homebrew formulae and
+// casks.
+func InstalledBrewPackages(ctx context.Context) ([]*PkgInfo, error) {
+	out, err := runBrew(ctx, brewInstalledArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseBrewInfo(out)
+}
+
+// BrewUpdates queries for all outdated Homebrew formulae and casks, as of
+// the last brew update.
+func BrewUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	out, err := runBrew(ctx, brewOutdatedArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseBrewOutdated(out)
+}
@@ -0,0 +1,131 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+// setTestBrew points brew at a file that is not owned by root.
+func setTestBrew(t *testing.T) {
+	td := t.TempDir()
+	old := brew
+	t.Cleanup(func() { brew = old })
+	brew = filepath.Join(td, "brew")
+	if err := ioutil.WriteFile(brew, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if os.Geteuid() == 0 {
+		if err := os.Chown(brew, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func brewCmd(t *testing.T, args ...string) *exec.Cmd {
+	cmd := exec.Command(brew, args...)
+	cmd.Env = append(os.Environ(), brewEnv...)
+	if err := brewAsOwner(cmd); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestInstallBrewPackages(t *testing.T) {
+	setTestBrew(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(brewCmd(t, append(brewInstallArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := InstallBrewPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("Could not install package")).Times(1)
+	if err := InstallBrewPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestRemoveBrewPackages(t *testing.T) {
+	setTestBrew(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(brewCmd(t, append(brewRemoveArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := RemoveBrewPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseBrewInfo(t *testing.T) {
+	data := []byte(`{"formulae":[{"name":"jq","installed":[{"version":"1.6"},{"version":"1.7.1"}]},{"name":"gone","installed":[]}],"casks":[{"token":"firefox","installed":"121.0"}]}`)
+	want := []*PkgInfo{
+		{Name: "jq", Arch: noarch, Version: "1.7.1"},
+		{Name: "firefox", Arch: noarch, Version: "121.0"},
+	}
+	got, err := parseBrewInfo(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBrewInfo() = %v, want %v", got, want)
+	}
+
+	if _, err := parseBrewInfo([]byte("Error: not json")); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestBrewUpdates(t *testing.T) {
+	setTestBrew(t)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(brewCmd(t, brewOutdatedArgs...))
+
+	out := []byte(`{"formulae":[{"name":"jq","installed_versions":["1.6"],"current_version":"1.7.1","pinned":false},{"name":"node","installed_versions":["18.0.0"],"current_version":"21.5.0","pinned":true}],"casks":[{"name":"firefox","installed_versions":["120.0"],"current_version":"121.0"}]}`)
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(out, nil, nil).Times(1)
+	got, err := BrewUpdates(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*PkgInfo{
+		{Name: "jq", Arch: noarch, Version: "1.7.1"},
+		{Name: "firefox", Arch: noarch, Version: "121.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BrewUpdates() = %v, want %v", got, want)
+	}
+}
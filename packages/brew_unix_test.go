@@ -0,0 +1,45 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package packages
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestBrewAsOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("brew only switches users when run as root")
+	}
+	setTestBrew(t)
+	cmd := exec.Command(brew)
+	if err := brewAsOwner(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Credential == nil || cmd.SysProcAttr.Credential.Uid != 65534 {
+		t.Errorf("brewAsOwner() did not set the owner credential: %+v", cmd.SysProcAttr)
+	}
+
+	if err := os.Chown(brew, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := brewAsOwner(exec.Command(brew)); err == nil {
+		t.Errorf("did not get expected error for a root owned brew")
+	}
+}
@@ -0,0 +1,326 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/external"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+)
+
+// Helpers for downloading updates from the Microsoft Update Catalog, this is
+// used for updates Windows Update will not offer, for example superseded
+// updates.
+
+var (
+	catalogSearchURL   = "https://www.catalog.update.microsoft.com/Search.aspx"
+	catalogDownloadURL = "https://www.catalog.update.microsoft.com/DownloadDialog.aspx"
+	catalogClient      = &http.Client{}
+
+	catalogResultRE     = regexp.MustCompile(`(?s)<a id=["']([0-9a-fA-F-]{36})_link["'][^>]*>(.*?)</a>`)
+	catalogFileURLRE    = regexp.MustCompile(`downloadInformation\[\d+\]\.files\[(\d+)\]\.url\s*=\s*'([^']+)'`)
+	catalogFileDigestRE = regexp.MustCompile(`downloadInformation\[\d+\]\.files\[(\d+)\]\.digest\s*=\s*'([^']+)'`)
+	// Catalog package file names end in the hex SHA1 of the file.
+	catalogFileSHA1RE = regexp.MustCompile(`(?i)_([0-9a-f]{40})\.(?:msu|cab)$`)
+	catalogServerRE   = regexp.MustCompile(`Windows Server (\d{4})( R2)?`)
+	catalogClientRE   = regexp.MustCompile(`Windows (10|11)\b`)
+)
+
+// CatalogUpdate is a Microsoft Update Catalog search result.
+type CatalogUpdate struct {
+	ID, Title string
+}
+
+// CatalogFile is a file belonging to a Microsoft Update Catalog update.
+type CatalogFile struct {
+	URL string
+	// SHA1 is the digest published by the catalog, it may be empty.
+	SHA1 []byte
+}
+
+// CatalogKB returns the KB id searched for by a Microsoft Update Catalog
+// search URL such as
+// https://www.catalog.update.microsoft.com/Search.aspx?q=KB5005565, an
+// empty string is returned for any other URL.
+func CatalogKB(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || !strings.EqualFold(u.Host, "www.catalog.update.microsoft.com") || !strings.EqualFold(path.Base(u.Path), "Search.aspx") {
+		return ""
+	}
+	return KBFromFileName(u.Query().Get("q"))
+}
+
+// CatalogFilter selects the Microsoft Update Catalog updates that apply to a
+// system by their title.
+type CatalogFilter struct {
+	// Products name the product of the system, updates whose title contains
+	// all of them are preferred.
+	Products []string
+	// Arch names the architecture of the system, the title of a selected
+	// update must contain it.
+	Arch string
+}
+
+// NewCatalogFilter returns the CatalogFilter for a system with the given
+// product name, display version, build number and GOARCH.
+func NewCatalogFilter(productName, displayVersion string, build int, goarch string) CatalogFilter {
+	var filters CatalogFilter
+	productName = osinfo.WindowsProductName(productName, build)
+	switch m := catalogServerRE.FindStringSubmatch(productName); {
+	case m != nil:
+		// Windows Server 2022 and later are listed by version.
+		if year, _ := strconv.Atoi(m[1]); year >= 2022 && displayVersion != "" {
+			filters.Products = append(filters.Products, "Microsoft server operating system version "+displayVersion)
+		} else {
+			filters.Products = append(filters.Products, "Windows Server "+m[1]+m[2])
+		}
+	case catalogClientRE.MatchString(productName):
+		product := "Windows " + catalogClientRE.FindStringSubmatch(productName)[1]
+		if displayVersion != "" {
+			product += " Version " + displayVersion
+		}
+		filters.Products = append(filters.Products, product)
+	}
+	switch osinfo.NormalizeArchitecture(goarch) {
+	case osinfo.ArchX86_64:
+		filters.Arch = "x64-based"
+	case osinfo.ArchAarch64:
+		filters.Arch = "ARM64-based"
+	case osinfo.ArchX86_32:
+		filters.Arch = "x86-based"
+	}
+	return filters
+}
+
+func searchCatalog(ctx context.Context, kb string) ([]CatalogUpdate, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", catalogSearchURL+"?q="+url.QueryEscape(kb), nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := doCatalogRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var updates []CatalogUpdate
+	for _, m := range catalogResultRE.FindAllSubmatch(body, -1) {
+		title := strings.Join(strings.Fields(html.UnescapeString(string(m[2]))), " ")
+		updates = append(updates, CatalogUpdate{ID: string(m[1]), Title: title})
+	}
+	return updates, nil
+}
+
+func catalogFiles(ctx context.Context, id string) ([]CatalogFile, error) {
+	ids, err := json.Marshal([]map[string]any{{"size": 0, "languages": "", "uidInfo": id, "updateID": id}})
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"updateIDs": {string(ids)}}
+	req, err := http.NewRequestWithContext(ctx, "POST", catalogDownloadURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := doCatalogRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[int]*CatalogFile{}
+	for _, m := range catalogFileURLRE.FindAllSubmatch(body, -1) {
+		i, _ := strconv.Atoi(string(m[1]))
+		files[i] = &CatalogFile{URL: string(m[2])}
+	}
+	for _, m := range catalogFileDigestRE.FindAllSubmatch(body, -1) {
+		i, _ := strconv.Atoi(string(m[1]))
+		if f, ok := files[i]; ok {
+			if f.SHA1, err = base64.StdEncoding.DecodeString(string(m[2])); err != nil {
+				return nil, fmt.Errorf("invalid digest %q for %s: %v", m[2], f.URL, err)
+			}
+		}
+	}
+	var idx []int
+	for i := range files {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	var ret []CatalogFile
+	for _, i := range idx {
+		ret = append(ret, *files[i])
+	}
+	return ret, nil
+}
+
+func doCatalogRequest(req *http.Request) ([]byte, error) {
+	resp, err := catalogClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code %q for request \"%s %s\"", resp.Status, req.Method, req.URL.String())
+	}
+	return body, nil
+}
+
+// selectCatalogUpdate returns the update for kb that applies to the system
+// described by filters. Candidates are the updates naming kb and the
+// architecture, a result listed more than once counts once and dynamic
+// (setup) updates are ignored. If several candidates remain the one naming
+// the product is picked.
+func selectCatalogUpdate(updates []CatalogUpdate, kb string, filters CatalogFilter) (CatalogUpdate, error) {
+	kbRE, err := regexp.Compile(`(?i)\b` + regexp.QuoteMeta(kb) + `\b`)
+	if err != nil {
+		return CatalogUpdate{}, err
+	}
+	arch := strings.ToLower(filters.Arch)
+	seen := map[string]bool{}
+	var candidates, matches []CatalogUpdate
+	for _, u := range updates {
+		title := strings.ToLower(u.Title)
+		if seen[strings.ToLower(u.ID)] || !kbRE.MatchString(title) || !strings.Contains(title, arch) || strings.Contains(title, "dynamic") {
+			continue
+		}
+		seen[strings.ToLower(u.ID)] = true
+		candidates = append(candidates, u)
+
+		match := true
+		for _, p := range filters.Products {
+			match = match && strings.Contains(title, strings.ToLower(p))
+		}
+		if match {
+			matches = append(matches, u)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		if len(candidates) == 0 {
+			return CatalogUpdate{}, fmt.Errorf("no Microsoft Update Catalog update for %s matches %q", kb, filters.Arch)
+		}
+		matches = candidates
+	}
+	var titles []string
+	for _, m := range matches {
+		titles = append(titles, m.Title)
+	}
+	return CatalogUpdate{}, fmt.Errorf("%d Microsoft Update Catalog updates for %s match %q %q: %q", len(matches), kb, filters.Products, filters.Arch, titles)
+}
+
+// verifyCatalogFile checks the SHA1 of a downloaded file against the catalog
+// digest and the digest in its file name, at least one must be present.
+func verifyCatalogFile(f CatalogFile, sum []byte) error {
+	var want [][]byte
+	if len(f.SHA1) > 0 {
+		want = append(want, f.SHA1)
+	}
+	if m := catalogFileSHA1RE.FindStringSubmatch(f.URL); m != nil {
+		h, _ := hex.DecodeString(m[1])
+		want = append(want, h)
+	}
+	if len(want) == 0 {
+		return fmt.Errorf("no digest published for %s, not installing it", f.URL)
+	}
+	for _, w := range want {
+		if !bytes.Equal(w, sum) {
+			return fmt.Errorf("SHA1 of %s is %x, want %x", f.URL, sum, w)
+		}
+	}
+	return nil
+}
+
+func downloadCatalogFile(ctx context.Context, f CatalogFile, dir string) (string, error) {
+	name := path.Base(f.URL)
+	if isUpdate, _ := IsUpdatePackage(name); !isUpdate {
+		return "", fmt.Errorf("%s is not an .msu or .cab update package", f.URL)
+	}
+	r, err := external.FetchRemoteObjectHTTP(ctx, catalogClient, f.URL)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	dst := filepath.Join(dir, name)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	_, err = io.Copy(io.MultiWriter(out, h), r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = verifyCatalogFile(f, h.Sum(nil))
+	}
+	if err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return dst, nil
+}
+
+func downloadCatalogUpdate(ctx context.Context, kb string, filters CatalogFilter, dir string) ([]string, error) {
+	updates, err := searchCatalog(ctx, kb)
+	if err != nil {
+		return nil, fmt.Errorf("error searching the Microsoft Update Catalog for %s: %v", kb, err)
+	}
+	update, err := selectCatalogUpdate(updates, kb, filters)
+	if err != nil {
+		return nil, err
+	}
+	files, err := catalogFiles(ctx, update.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing files of %q: %v", update.Title, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files listed for %q", update.Title)
+	}
+	var paths []string
+	for _, f := range files {
+		p, err := downloadCatalogFile(ctx, f, dir)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
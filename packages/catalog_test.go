@@ -0,0 +1,177 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCatalogKB(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"https://www.catalog.update.microsoft.com/Search.aspx?q=KB5005565", "KB5005565"},
+		{"https://www.catalog.update.microsoft.com/Search.aspx?q=kb5005565", "KB5005565"},
+		{"https://www.catalog.update.microsoft.com/Search.aspx?q=cumulative", ""},
+		{"https://example.com/Search.aspx?q=KB5005565", ""},
+		{"https://example.com/windows10.0-kb5005565-x64.msu", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := CatalogKB(tt.uri); got != tt.want {
+			t.Errorf("CatalogKB(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestNewCatalogFilter(t *testing.T) {
+	tests := []struct {
+		product, displayVersion string
+		build                   int
+		goarch                  string
+		want                    CatalogFilter
+	}{
+		{"Windows Server 2019 Datacenter", "1809", 17763, "amd64", CatalogFilter{[]string{"Windows Server 2019"}, "x64-based"}},
+		{"Windows Server 2012 R2 Datacenter", "", 9600, "amd64", CatalogFilter{[]string{"Windows Server 2012 R2"}, "x64-based"}},
+		{"Windows Server 2022 Datacenter", "21H2", 20348, "amd64", CatalogFilter{[]string{"Microsoft server operating system version 21H2"}, "x64-based"}},
+		{"Windows 10 Pro", "22H2", 19045, "386", CatalogFilter{[]string{"Windows 10 Version 22H2"}, "x86-based"}},
+		{"Windows 10 Enterprise", "23H2", 22631, "arm64", CatalogFilter{[]string{"Windows 11 Version 23H2"}, "ARM64-based"}},
+	}
+	for _, tt := range tests {
+		if got := NewCatalogFilter(tt.product, tt.displayVersion, tt.build, tt.goarch); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NewCatalogFilter(%q, %q, %d, %q) = %+v, want %+v", tt.product, tt.displayVersion, tt.build, tt.goarch, got, tt.want)
+		}
+	}
+}
+
+func TestSelectCatalogUpdate(t *testing.T) {
+	updates := []CatalogUpdate{
+		{"1", "2021-09 Cumulative Update for Windows Server 2019 for x64-based Systems (KB5005568)"},
+		{"2", "2021-09 Cumulative Update for Windows 10 Version 1809 for x64-based Systems (KB5005568)"},
+		{"3", "2021-09 Cumulative Update for Windows 10 Version 1809 for ARM64-based Systems (KB5005568)"},
+	}
+	server2019 := CatalogFilter{[]string{"Windows Server 2019"}, "x64-based"}
+	got, err := selectCatalogUpdate(updates, "KB5005568", server2019)
+	if err != nil || got.ID != "1" {
+		t.Errorf("selectCatalogUpdate() = %+v, %v, want update 1", got, err)
+	}
+	// A result listed twice and dynamic updates do not make the match
+	// ambiguous.
+	more := append(updates, updates[0],
+		CatalogUpdate{"4", "2021-09 Dynamic Cumulative Update for Windows Server 2019 for x64-based Systems (KB5005568)"})
+	if got, err := selectCatalogUpdate(more, "KB5005568", server2019); err != nil || got.ID != "1" {
+		t.Errorf("selectCatalogUpdate() = %+v, %v, want update 1", got, err)
+	}
+	// The architecture alone is enough when it leaves a single update.
+	if got, err := selectCatalogUpdate(updates, "KB5005568", CatalogFilter{Arch: "ARM64-based"}); err != nil || got.ID != "3" {
+		t.Errorf("selectCatalogUpdate() = %+v, %v, want update 3", got, err)
+	}
+	if _, err := selectCatalogUpdate(updates, "KB5005568", CatalogFilter{[]string{"Windows 11"}, "x64-based"}); err == nil {
+		t.Error("expected an error for an ambiguous match")
+	}
+	if _, err := selectCatalogUpdate(updates, "KB500556", server2019); err == nil {
+		t.Error("expected an error for a KB that is only a prefix of the title KB")
+	}
+	if _, err := selectCatalogUpdate(updates, "KB5005568", CatalogFilter{Arch: "x86-based"}); err == nil {
+		t.Error("expected an error when nothing matches")
+	}
+}
+
+func TestDownloadCatalogUpdate(t *testing.T) {
+	content := []byte("msu content")
+	sum := sha1.Sum(content)
+	name := fmt.Sprintf("windows10.0-kb5005568-x64_%x.msu", sum)
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Search.aspx":
+			if q := r.URL.Query().Get("q"); q != "KB5005568" {
+				t.Errorf("unexpected search %q", q)
+			}
+			fmt.Fprint(w, `<tr><td><a id="8e9a8a7a-7c4f-4b2e-a0b1-1d0a3f7b2c11_link" href="javascript:void(0);" onclick='goToDetails("8e9a8a7a-7c4f-4b2e-a0b1-1d0a3f7b2c11");'>
+				2021-09 Cumulative Update for Windows Server 2019 for x64-based Systems (KB5005568)
+			</a></td></tr>
+			<tr><td><a id="0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0_link" href="javascript:void(0);">
+				2021-09 Cumulative Update for Windows Server 2019 for ARM64-based Systems (KB5005568)
+			</a></td></tr>`)
+		case "/DownloadDialog.aspx":
+			if err := r.ParseForm(); err != nil || !strings.Contains(r.Form.Get("updateIDs"), "8e9a8a7a-7c4f-4b2e-a0b1-1d0a3f7b2c11") {
+				t.Errorf("unexpected download dialog request %q: %v", r.Form.Get("updateIDs"), err)
+			}
+			fmt.Fprintf(w, "downloadInformation[0].files[0].url = '%s/d/%s';\ndownloadInformation[0].files[0].digest = '%s';\n", srv.URL, name, digest)
+		case "/d/" + name:
+			w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(s, d string) { catalogSearchURL, catalogDownloadURL = s, d }(catalogSearchURL, catalogDownloadURL)
+	catalogSearchURL = srv.URL + "/Search.aspx"
+	catalogDownloadURL = srv.URL + "/DownloadDialog.aspx"
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths, err := downloadCatalogUpdate(context.Background(), "KB5005568", CatalogFilter{[]string{"Windows Server 2019"}, "x64-based"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, name)}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("downloadCatalogUpdate() = %q, want %q", paths, want)
+	}
+	if got, err := ioutil.ReadFile(paths[0]); err != nil || string(got) != string(content) {
+		t.Errorf("downloaded %q, %v, want %q", got, err, content)
+	}
+
+	// A corrupted download is removed and not returned.
+	content = []byte("tampered")
+	if _, err := downloadCatalogUpdate(context.Background(), "KB5005568", CatalogFilter{[]string{"Windows Server 2019"}, "x64-based"}, dir); err == nil {
+		t.Error("expected a SHA1 mismatch error")
+	}
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("expected the corrupted download to be removed, got %v", err)
+	}
+}
+
+func TestVerifyCatalogFile(t *testing.T) {
+	sum := sha1.Sum([]byte("x"))
+	if err := verifyCatalogFile(CatalogFile{URL: "http://example.com/update.msu"}, sum[:]); err == nil {
+		t.Error("expected an error when no digest is published")
+	}
+	if err := verifyCatalogFile(CatalogFile{URL: "http://example.com/update.msu", SHA1: sum[:]}, sum[:]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := verifyCatalogFile(CatalogFile{URL: fmt.Sprintf("http://example.com/kb1-x64_%x.msu", sha1.Sum([]byte("y"))), SHA1: sum[:]}, sum[:]); err == nil {
+		t.Error("expected an error when the file name digest does not match")
+	}
+}
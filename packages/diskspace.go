@@ -0,0 +1,60 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	// installHeadroom is the free space kept on the package cache and
+	// install root filesystems, package managers need room for scripts and
+	// logs.
+	installHeadroom uint64 = 100 << 20
+	// bootFreeSpace is the free space needed on /boot to install a kernel.
+	bootFreeSpace uint64 = 100 << 20
+
+	packageCacheDir = "/var/cache"
+	installRootDir  = "/usr"
+	bootDir         = "/boot"
+)
+
+func isKernelPackage(name string) bool {
+	name = strings.TrimPrefix(name, "package:")
+	return strings.HasPrefix(name, "kernel") || strings.HasPrefix(name, "linux-image")
+}
+
+// CheckInstallSpace returns an util.InsufficientDiskSpaceError if the
+// package cache and install root filesystems do not have installHeadroom
+// free, or for kernel packages in pkgs if /boot does not have room for a
+// kernel. apt, yum and zypper check the size of the transaction itself when
+// it runs, so no extra resolve pass is made to estimate it.
+func CheckInstallSpace(ctx context.Context, pkgs []string) error {
+	if !AptExists && !YumExists && !ZypperExists {
+		return nil
+	}
+	if err := util.CheckDiskSpaceHeadroom(map[string]uint64{packageCacheDir: 0, installRootDir: 0}, installHeadroom); err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		if isKernelPackage(pkg) {
+			return util.CheckDiskSpace(map[string]uint64{bootDir: bootFreeSpace})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+func TestCheckInstallSpace(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	oldApt, oldHeadroom, oldBoot := AptExists, installHeadroom, bootFreeSpace
+	oldCache, oldRoot, oldBootDir := packageCacheDir, installRootDir, bootDir
+	defer func() {
+		AptExists, installHeadroom, bootFreeSpace = oldApt, oldHeadroom, oldBoot
+		packageCacheDir, installRootDir, bootDir = oldCache, oldRoot, oldBootDir
+	}()
+	AptExists = true
+	packageCacheDir, installRootDir, bootDir = dir, dir, dir
+
+	installHeadroom, bootFreeSpace = 0, 1<<62
+	if err := CheckInstallSpace(ctx, []string{"foo"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := CheckInstallSpace(ctx, []string{"foo", "linux-image-amd64"}); !util.IsInsufficientDiskSpace(err) {
+		t.Errorf("expected insufficient disk space on /boot for a kernel, got: %v", err)
+	}
+
+	// The headroom is needed once, not for each path on a filesystem.
+	free, err := util.FreeSpace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	installHeadroom = free / 2 * 3 / 2
+	if err := CheckInstallSpace(ctx, []string{"foo"}); err != nil {
+		t.Errorf("unexpected error with headroom %d of %d free: %v", installHeadroom, free, err)
+	}
+	installHeadroom = 1 << 62
+	if err := CheckInstallSpace(ctx, []string{"foo"}); !util.IsInsufficientDiskSpace(err) {
+		t.Errorf("expected insufficient disk space, got: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	dnf string
+
+	// dnfModulesDir holds the module state dnf keeps on the host, reading it
+	// does not need repository metadata or network access.
+	dnfModulesDir = "/etc/dnf/modules.d"
+
+	dnfModuleEnableArgs   = []string{"module", "enable", "--assumeyes"}
+	dnfModuleSwitchToArgs = []string{"module", "switch-to", "--assumeyes"}
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		dnf = "/usr/bin/dnf"
+	}
+	DnfExists = util.Exists(dnf)
+}
+
+// parseDnfModuleState parses a dnf module state file, modules with no
+// stream, that is modules that were reset, are skipped.
+func parseDnfModuleState(data []byte) []*DnfModule {
+	/*
+	   [nodejs]
+	   name=nodejs
+	   stream=18
+	   profiles=common
+	   state=enabled
+	*/
+	var mods []*DnfModule
+	var cur *DnfModule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") || strings.HasPrefix(ln, ";") {
+			continue
+		}
+		if strings.HasPrefix(ln, "[") && strings.HasSuffix(ln, "]") {
+			cur = &DnfModule{Name: strings.Trim(ln, "[]")}
+			mods = append(mods, cur)
+			continue
+		}
+		k, v, ok := strings.Cut(ln, "=")
+		if !ok || cur == nil {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.TrimSpace(k) {
+		case "name":
+			cur.Name = v
+		case "stream":
+			cur.Stream = v
+		case "state":
+			cur.State = v
+		case "profiles":
+			for _, p := range strings.Split(v, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					cur.Profiles = append(cur.Profiles, p)
+				}
+			}
+		}
+	}
+
+	var ret []*DnfModule
+	for _, m := range mods {
+		if m.Stream != "" || m.State == "disabled" {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// DnfModuleStreams lists the enabled and disabled dnf module streams.
+func DnfModuleStreams(ctx context.Context) ([]*DnfModule, error) {
+	files, err := ioutil.ReadDir(dnfModulesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var mods []*DnfModule
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".module" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dnfModulesDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, parseDnfModuleState(data)...)
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].Name < mods[j].Name })
+	return mods, nil
+}
+
+// EnableDnfModuleStream enables a module stream, this fails if a different
+// stream of the module is already enabled.
+func EnableDnfModuleStream(ctx context.Context, name, stream string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, dnf, append(dnfModuleEnableArgs, name+":"+stream))
+	return err
+}
+
+// SwitchDnfModuleStream switches a module to stream and syncs its installed
+// packages to the new stream, switch-to needs dnf 4.6 or later (EL 8.5+).
+func SwitchDnfModuleStream(ctx context.Context, name, stream string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, dnf, append(dnfModuleSwitchToArgs, name+":"+stream))
+	return err
+}
@@ -0,0 +1,119 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseDnfModuleState(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []*DnfModule
+	}{
+		{
+			"Enabled",
+			[]byte("[nodejs]\nname=nodejs\nstream=18\nprofiles=common, development\nstate=enabled\n"),
+			[]*DnfModule{{Name: "nodejs", Stream: "18", State: "enabled", Profiles: []string{"common", "development"}}},
+		},
+		{
+			"DisabledAndReset",
+			[]byte("[mysql]\nname=mysql\nstream=\nprofiles=\nstate=disabled\n\n[php]\nname=php\nstream=\nprofiles=\nstate=\n"),
+			[]*DnfModule{{Name: "mysql", State: "disabled"}},
+		},
+		{"Empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDnfModuleState(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDnfModuleState() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDnfModuleStreams(t *testing.T) {
+	old := dnfModulesDir
+	defer func() { dnfModulesDir = old }()
+	dnfModulesDir = t.TempDir()
+	for name, content := range map[string]string{
+		"postgresql.module": "[postgresql]\nname=postgresql\nstream=13\nprofiles=server\nstate=enabled\n",
+		"nodejs.module":     "[nodejs]\nname=nodejs\nstream=18\nprofiles=\nstate=enabled\n",
+		"README":            "not a module",
+	} {
+		if err := os.WriteFile(filepath.Join(dnfModulesDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := DnfModuleStreams(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*DnfModule{
+		{Name: "nodejs", Stream: "18", State: "enabled"},
+		{Name: "postgresql", Stream: "13", State: "enabled", Profiles: []string{"server"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DnfModuleStreams() = %+v, want %+v", got, want)
+	}
+
+	dnfModulesDir = filepath.Join(dnfModulesDir, "missing")
+	if got, err := DnfModuleStreams(testCtx); err != nil || got != nil {
+		t.Errorf("DnfModuleStreams() with no module directory = %+v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestEnableDnfModuleStream(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(dnf, append(dnfModuleEnableArgs, "nodejs:18")...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), nil, nil).Times(1)
+	if err := EnableDnfModuleStream(testCtx, "nodejs", "18"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, []byte("stderr"), errors.New("conflicting stream")).Times(1)
+	if err := EnableDnfModuleStream(testCtx, "nodejs", "18"); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestSwitchDnfModuleStream(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(dnf, append(dnfModuleSwitchToArgs, "nodejs:20")...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), nil, nil).Times(1)
+	if err := SwitchDnfModuleStream(testCtx, "nodejs", "20"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+var (
+	// dpkgStatusFile is the dpkg database of installed packages.
+	dpkgStatusFile = "/var/lib/dpkg/status"
+	// dpkgUpdatesDir holds dpkg journal entries that have not yet been merged
+	// into dpkgStatusFile, dpkg-query applies them on top of it.
+	dpkgUpdatesDir = "/var/lib/dpkg/updates"
+	// dpkgInfoDir holds the file lists of installed packages, dpkg rewrites
+	// a package's list when it installs or upgrades it.
+	dpkgInfoDir = "/var/lib/dpkg/info"
+)
+
+// dpkgStatusPackages reads the installed packages from the dpkg database
+// files, this gives the same result as dpkgQueryArgs without running
+// dpkg-query.
+func dpkgStatusPackages(ctx context.Context) ([]*PkgInfo, error) {
+	return readDpkgStatus(ctx, dpkgStatusFile, dpkgUpdatesDir)
+}
+
+// DpkgStatusPackagesUnder reads the installed packages from the dpkg
+// database of the file system mounted at root, such as the root file system
+// of a container or WSL distribution.
+func DpkgStatusPackagesUnder(ctx context.Context, root string) ([]*PkgInfo, error) {
+	return readDpkgStatus(ctx, filepath.Join(root, filepath.FromSlash(dpkgStatusFile)), filepath.Join(root, filepath.FromSlash(dpkgUpdatesDir)))
+}
+
+func readDpkgStatus(ctx context.Context, statusFile, updatesDir string) ([]*PkgInfo, error) {
+	data, err := os.ReadFile(statusFile)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseDpkgStatus(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", statusFile, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no packages in %s", statusFile)
+	}
+
+	// Apply the journal in order, a later entry for the same package replaces
+	// the earlier one.
+	index := map[string]int{}
+	for i, e := range entries {
+		index[e.Package+":"+e.Architecture] = i
+	}
+	updates, err := dpkgUpdates(updatesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range updates {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := parseDpkgStatus(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", name, err)
+		}
+		for _, e := range updated {
+			key := e.Package + ":" + e.Architecture
+			if i, ok := index[key]; ok {
+				entries[i] = e
+				continue
+			}
+			index[key] = len(entries)
+			entries = append(entries, e)
+		}
+	}
+	// dpkg-query lists packages by name.
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Package != entries[j].Package {
+			return entries[i].Package < entries[j].Package
+		}
+		return entries[i].Architecture < entries[j].Architecture
+	})
+
+	infoDir := filepath.Join(filepath.Dir(statusFile), "info")
+	var result []*PkgInfo
+	for _, e := range entries {
+		if e.Status != "installed" {
+			continue
+		}
+		pkg := pkgInfoFromPackageMetadata(e)
+		pkg.Held = e.Want == "hold"
+		pkg.InstallTime = dpkgInstallTime(infoDir, e.Package, e.Architecture)
+		result = append(result, pkg)
+	}
+	clog.Debugf(ctx, "Read %d installed deb packages from %s.", len(result), statusFile)
+	return result, nil
+}
+
+// dpkgUpdates returns the journal files in dir in the order dpkg applies
+// them. Only files named with digits are journal entries.
+func dpkgUpdates(dir string) ([]string, error) {
+	des, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	nums := map[string]int{}
+	var names []string
+	for _, de := range des {
+		if strings.Trim(de.Name(), "0123456789") != "" {
+			continue
+		}
+		n, err := strconv.Atoi(de.Name())
+		if err != nil {
+			continue
+		}
+		nums[de.Name()] = n
+		names = append(names, de.Name())
+	}
+	sort.Slice(names, func(i, j int) bool { return nums[names[i]] < nums[names[j]] })
+	for i, name := range names {
+		names[i] = filepath.Join(dir, name)
+	}
+	return names, nil
+}
+
+// dpkgInstallTime returns when the package was last installed or upgraded,
+// dpkg does not record it so the modification time of the package's file
+// list is used. Packages that can be co-installed for several architectures
+// name the list after the package and architecture.
+func dpkgInstallTime(infoDir, name, arch string) *time.Time {
+	for _, list := range []string{name + ":" + arch + ".list", name + ".list"} {
+		fi, err := os.Stat(filepath.Join(infoDir, list))
+		if err == nil {
+			t := fi.ModTime().UTC()
+			return &t
+		}
+	}
+	return nil
+}
+
+// parseDpkgStatus parses dpkg database stanzas into the fields dpkgQueryArgs
+// asks dpkg-query for.
+func parseDpkgStatus(data []byte) ([]packageMetadata, error) {
+	/*
+		Stanzas are separated by blank lines, continuation lines start with
+		a space or tab.
+
+		Package: git
+		Status: install ok installed
+		Priority: optional
+		Architecture: amd64
+		Source: git (1:2.25.1-1ubuntu3)
+		Version: 1:2.25.1-1ubuntu3.12
+		Description: fast, scalable, distributed revision control system
+		 Git is popular version control system designed to handle very large
+	*/
+	var entries []packageMetadata
+	var cur packageMetadata
+	var source string
+	inStanza := false
+	flush := func() error {
+		if !inStanza {
+			return nil
+		}
+		inStanza = false
+		if cur.Package == "" {
+			return errors.New("stanza without a Package field")
+		}
+		cur.SourceName, cur.SourceVersion = cur.Package, cur.Version
+		if source != "" {
+			name, version, _ := strings.Cut(source, " ")
+			cur.SourceName = name
+			if v := strings.TrimSpace(version); strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")") {
+				cur.SourceVersion = strings.TrimSpace(v[1 : len(v)-1])
+			}
+		}
+		entries = append(entries, cur)
+		cur, source = packageMetadata{}, ""
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		inStanza = true
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		field, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			return nil, fmt.Errorf("malformed line %q", line)
+		}
+		value = bytes.TrimSpace(value)
+		switch string(field) {
+		case "Package":
+			cur.Package = string(value)
+		case "Architecture":
+			cur.Architecture = string(value)
+		case "Version":
+			cur.Version = string(value)
+		case "Source":
+			source = string(value)
+		case "Installed-Size":
+			cur.InstalledSize = string(value)
+		case "Status":
+			// Status is "want error-flag status".
+			f := strings.Fields(string(value))
+			if len(f) != 3 {
+				return nil, fmt.Errorf("malformed Status %q", value)
+			}
+			cur.Want, cur.Status = f[0], f[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
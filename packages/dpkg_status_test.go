@@ -0,0 +1,179 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testDpkgStatus = `Package: man-db
+Status: install ok installed
+Priority: standard
+Installed-Size: 2850
+Architecture: amd64
+Version: 2.9.1-1
+Description: tools for reading manual pages
+ This package provides the man command.
+ .
+ It also provides apropos.
+
+Package: python3-gi
+Status: hold ok installed
+Architecture: amd64
+Source: pygobject
+Version: 3.36.0-1
+
+Package: git
+Status: install ok installed
+Architecture: amd64
+Source: git (1:2.25.1-1ubuntu3)
+Version: 1:2.25.1-1ubuntu3.12
+
+Package: libc6
+Status: install ok installed
+Architecture: i386
+Multi-Arch: same
+Version: 2.31-0ubuntu9
+
+Package: old
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+`
+
+func TestParseDpkgStatus(t *testing.T) {
+	got, err := parseDpkgStatus([]byte(testDpkgStatus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []packageMetadata{
+		{Package: "man-db", Architecture: "amd64", Version: "2.9.1-1", Status: "installed", Want: "install", SourceName: "man-db", SourceVersion: "2.9.1-1", InstalledSize: "2850"},
+		{Package: "python3-gi", Architecture: "amd64", Version: "3.36.0-1", Status: "installed", Want: "hold", SourceName: "pygobject", SourceVersion: "3.36.0-1"},
+		{Package: "git", Architecture: "amd64", Version: "1:2.25.1-1ubuntu3.12", Status: "installed", Want: "install", SourceName: "git", SourceVersion: "1:2.25.1-1ubuntu3"},
+		{Package: "libc6", Architecture: "i386", Version: "2.31-0ubuntu9", Status: "installed", Want: "install", SourceName: "libc6", SourceVersion: "2.31-0ubuntu9"},
+		{Package: "old", Architecture: "amd64", Version: "1.0", Status: "config-files", Want: "deinstall", SourceName: "old", SourceVersion: "1.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDpkgStatus() = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"Status: install ok installed\n", "Package: foo\nnot a field\n", "Package: foo\nStatus: installed\n"} {
+		if _, err := parseDpkgStatus([]byte(bad)); err == nil {
+			t.Errorf("parseDpkgStatus(%q) did not return an error", bad)
+		}
+	}
+}
+
+func TestDpkgStatusPackages(t *testing.T) {
+	oldStatusFile, oldUpdatesDir := dpkgStatusFile, dpkgUpdatesDir
+	defer func() { dpkgStatusFile, dpkgUpdatesDir = oldStatusFile, oldUpdatesDir }()
+	dir := t.TempDir()
+	dpkgStatusFile = filepath.Join(dir, "status")
+	dpkgUpdatesDir = filepath.Join(dir, "updates")
+	if err := os.WriteFile(dpkgStatusFile, []byte(testDpkgStatus), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*PkgInfo{
+		{Name: "git", Arch: "x86_64", Version: "1:2.25.1-1ubuntu3.12", Source: Source{Name: "git", Version: "1:2.25.1-1ubuntu3"}},
+		{Name: "libc6", Arch: "x86_32", Version: "2.31-0ubuntu9", Source: Source{Name: "libc6", Version: "2.31-0ubuntu9"}},
+		{Name: "man-db", Arch: "x86_64", Version: "2.9.1-1", Source: Source{Name: "man-db", Version: "2.9.1-1"}, Size: 2850 * 1024},
+		{Name: "python3-gi", Arch: "x86_64", Version: "3.36.0-1", Source: Source{Name: "pygobject", Version: "3.36.0-1"}, Held: true},
+	}
+	got, err := dpkgStatusPackages(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dpkgStatusPackages() = %v, want %v", got, want)
+	}
+
+	// Journal entries are applied in order on top of the status file.
+	if err := os.Mkdir(dpkgUpdatesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	updates := map[string]string{
+		"0002":  "Package: git\nStatus: install ok installed\nArchitecture: amd64\nVersion: 1:2.25.1-1ubuntu3.13\n",
+		"0010":  "Package: git\nStatus: deinstall ok config-files\nArchitecture: amd64\nVersion: 1:2.25.1-1ubuntu3.13\n",
+		"0001":  "Package: curl\nStatus: install ok installed\nArchitecture: amd64\nVersion: 7.68.0-1\n",
+		"tmp.i": "Package: ignored\nStatus: install ok installed\nArchitecture: amd64\nVersion: 1\n",
+	}
+	for name, data := range updates {
+		if err := os.WriteFile(filepath.Join(dpkgUpdatesDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = append([]*PkgInfo{{Name: "curl", Arch: "x86_64", Version: "7.68.0-1", Source: Source{Name: "curl", Version: "7.68.0-1"}}}, want[1:]...)
+	got, err = dpkgStatusPackages(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dpkgStatusPackages() with updates = %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(dpkgStatusFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dpkgStatusPackages(testCtx); err == nil {
+		t.Error("dpkgStatusPackages() with an empty status file did not return an error")
+	}
+}
+
+func TestDpkgStatusPackagesUnder(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "var", "lib", "dpkg")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "status"), []byte(testDpkgStatus), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The install time is the modification time of the package's file list,
+	// multi-arch packages name it after the architecture too.
+	installed := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	list := filepath.Join(dir, "info", "libc6:i386.list")
+	if err := os.MkdirAll(filepath.Dir(list), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(list, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(list, installed, installed); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DpkgStatusPackagesUnder(testCtx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || got[0].Name != "git" {
+		t.Fatalf("DpkgStatusPackagesUnder() = %v, want the 4 installed packages starting with git", got)
+	}
+	if got[0].InstallTime != nil {
+		t.Errorf("git InstallTime = %v, want nil without a file list", got[0].InstallTime)
+	}
+	if got[1].InstallTime == nil || !got[1].InstallTime.Equal(installed) {
+		t.Errorf("libc6 InstallTime = %v, want %v", got[1].InstallTime, installed)
+	}
+
+	if _, err := DpkgStatusPackagesUnder(testCtx, t.TempDir()); err == nil {
+		t.Error("DpkgStatusPackagesUnder() without a dpkg database did not return an error")
+	}
+}
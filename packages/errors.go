@@ -0,0 +1,222 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrorKind classifies a package manager failure.
+type ErrorKind string
+
+// Package manager failure kinds.
+const (
+	// ErrorUnknown is a failure that matched no other kind.
+	ErrorUnknown ErrorKind = "unknown"
+	// ErrorLockHeld is returned when another process holds the package
+	// manager lock, retrying later usually succeeds.
+	ErrorLockHeld ErrorKind = "lock_held"
+	// ErrorNetwork is returned when a repository could not be reached.
+	ErrorNetwork ErrorKind = "network"
+	// ErrorNotFound is returned when a package does not exist in any of the
+	// configured repositories.
+	ErrorNotFound ErrorKind = "not_found"
+	// ErrorDependencyConflict is returned when the requested change can not
+	// be resolved against the installed packages.
+	ErrorDependencyConflict ErrorKind = "dependency_conflict"
+)
+
+// maxStderrExcerpt is the maximum size in bytes of CommandError.Excerpt.
+const maxStderrExcerpt = 256
+
+// errorPatterns are the error messages of each package manager, keyed by
+// the base name of its command, for each failure kind. They are matched case
+// insensitively against the command error output in the order of errorKinds.
+// Only messages specific to a failure are listed, generic words that can
+// show up in package names or descriptions would misclassify failures.
+var errorPatterns = map[string]map[ErrorKind][]string{
+	"apt-get": {
+		ErrorLockHeld: {
+			"could not get lock /var/lib/",
+			"unable to acquire the dpkg frontend lock",
+			"unable to lock the administration directory",
+		},
+		ErrorNetwork: {
+			"temporary failure resolving '",
+			"could not resolve '",
+			"failed to fetch http",
+			"could not connect to ",
+		},
+		ErrorNotFound: {
+			"e: unable to locate package ",
+			"has no installation candidate",
+		},
+		ErrorDependencyConflict: {
+			"you have held broken packages",
+			"e: unmet dependencies",
+		},
+	},
+	"yum": yumErrorPatterns,
+	"dnf": yumErrorPatterns,
+	"zypper": {
+		ErrorNetwork: {
+			"download (curl) error for '",
+			"timeout exceeded when accessing '",
+		},
+		ErrorDependencyConflict: {
+			"problem: nothing provides ",
+			"problem: the to be installed ",
+		},
+	},
+	// GooGet prints the errors of the Go HTTP client.
+	"googet.exe": {
+		ErrorNetwork: {
+			": i/o timeout",
+			"net/http: tls handshake timeout",
+			": connection reset by peer",
+			": no such host",
+			": connection refused",
+		},
+	},
+}
+
+// yumErrorPatterns are shared by yum and dnf, yum is dnf on newer
+// distributions.
+var yumErrorPatterns = map[ErrorKind][]string{
+	ErrorLockHeld: {
+		"existing lock /var/run/yum.pid",
+		"waiting for process with pid ",
+	},
+	ErrorNetwork: {
+		"cannot find a valid baseurl for repo",
+		"failed to download metadata for repo",
+		"curl error (",
+	},
+	ErrorNotFound: {
+		"no match for argument: ",
+	},
+	ErrorDependencyConflict: {
+		"but none of the providers can be installed",
+		"conflicting requests",
+		"- nothing provides ",
+	},
+}
+
+var errorKinds = []ErrorKind{ErrorLockHeld, ErrorNetwork, ErrorNotFound, ErrorDependencyConflict}
+
+// stdoutErrors are the package managers that print their errors to stdout,
+// the stdout of other package managers is not classified as it lists
+// packages and their descriptions.
+var stdoutErrors = map[string]bool{
+	"zypper": true,
+}
+
+// errorExitCodes are exit codes that identify a failure kind on their own.
+var errorExitCodes = map[string]map[int]ErrorKind{
+	// https://en.opensuse.org/SDB:Zypper_manual#EXIT_CODES
+	"zypper": {
+		7:   ErrorLockHeld,
+		104: ErrorNotFound,
+	},
+	// ERROR_INSTALL_ALREADY_RUNNING
+	"msiexec.exe": {
+		1618: ErrorLockHeld,
+	},
+}
+
+// CommandError is a failed package manager command.
+type CommandError struct {
+	Cmd  string
+	Args []string
+	// ExitCode is the exit code of the command, or -1 if it did not exit.
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	Kind     ErrorKind
+	Err      error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("error running %s with args %q: %v, stdout: %q, stderr: %q", e.Cmd, e.Args, e.Err, e.Stdout, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Excerpt returns the last lines of the command error output, at most
+// maxStderrExcerpt bytes, falling back to stdout as some package managers
+// write their errors there.
+func (e *CommandError) Excerpt() string {
+	out := bytes.TrimSpace(e.Stderr)
+	if len(out) == 0 {
+		out = bytes.TrimSpace(e.Stdout)
+	}
+	if len(out) > maxStderrExcerpt {
+		out = out[len(out)-maxStderrExcerpt:]
+		if i := bytes.IndexByte(out, '\n'); i != -1 && i < len(out)-1 {
+			out = out[i+1:]
+		}
+	}
+	return string(out)
+}
+
+func newCommandError(cmd string, args []string, stdout, stderr []byte, err error) *CommandError {
+	e := &CommandError{Cmd: cmd, Args: args, ExitCode: -1, Stdout: stdout, Stderr: stderr, Err: err}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		e.ExitCode = exitErr.ExitCode()
+	}
+	e.Kind = classifyError(filepath.Base(cmd), e.ExitCode, stdout, stderr)
+	return e
+}
+
+func classifyError(manager string, exitCode int, stdout, stderr []byte) ErrorKind {
+	if k, ok := errorExitCodes[manager][exitCode]; ok {
+		return k
+	}
+	patterns, ok := errorPatterns[manager]
+	if !ok {
+		return ErrorUnknown
+	}
+	out := string(stderr)
+	if stdoutErrors[manager] {
+		out += "\n" + string(stdout)
+	}
+	out = strings.ToLower(out)
+	for _, k := range errorKinds {
+		for _, p := range patterns[k] {
+			if strings.Contains(out, p) {
+				return k
+			}
+		}
+	}
+	return ErrorUnknown
+}
+
+// ErrorKindOf returns the kind of the package manager failure in err's
+// chain, or "" if err does not wrap a CommandError.
+func ErrorKindOf(err error) ErrorKind {
+	var e *CommandError
+	if !errors.As(err, &e) {
+		return ""
+	}
+	return e.Kind
+}
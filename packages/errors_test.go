@@ -0,0 +1,111 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		manager  string
+		exitCode int
+		stdout   string
+		stderr   string
+		want     ErrorKind
+	}{
+		{"apt lock", "apt-get", 100, "", "E: Could not get lock /var/lib/dpkg/lock-frontend. It is held by process 1234 (apt-get)", ErrorLockHeld},
+		{"apt network", "apt-get", 100, "", "E: Failed to fetch http://deb.debian.org/debian/pool/main/n/nginx.deb  Temporary failure resolving 'deb.debian.org'", ErrorNetwork},
+		{"apt not found", "apt-get", 100, "", "E: Unable to locate package nginx2", ErrorNotFound},
+		{"apt conflict", "apt-get", 100, "The following packages have unmet dependencies:\n nginx : Depends: libssl3 but it is not going to be installed", "E: Unable to correct problems, you have held broken packages.", ErrorDependencyConflict},
+		{"yum lock", "yum", 1, "", "Existing lock /var/run/yum.pid: another copy is running as pid 1234.", ErrorLockHeld},
+		{"dnf not found", "dnf", 1, "", "Error: Unable to find a match: nginx2\nNo match for argument: nginx2", ErrorNotFound},
+		{"dnf conflict", "dnf", 1, "", "Error:\n Problem: package foo-1.0 requires bar > 2, but none of the providers can be installed", ErrorDependencyConflict},
+		{"zypper lock exit code", "zypper", 7, "", "System management is locked by the application with pid 1234 (zypper).", ErrorLockHeld},
+		{"zypper not found exit code", "zypper", 104, "'nginx2' not found in package names. Trying capabilities.", "", ErrorNotFound},
+		{"zypper conflict", "zypper", 4, "Problem: nothing provides 'libfoo' needed by the to be installed bar", "", ErrorDependencyConflict},
+		{"googet network", "googet.exe", 1, "", `Get "https://packages.cloud.google.com/repo/index": dial tcp: lookup packages.cloud.google.com: no such host`, ErrorNetwork},
+		{"msiexec already running", "msiexec.exe", 1618, "", "", ErrorLockHeld},
+		// Go HTTP client errors are only matched for GooGet.
+		{"apt no such host", "apt-get", 100, "", "E: no such host in the hosts list", ErrorUnknown},
+		// Package lists and descriptions on stdout are not classified.
+		{"apt stdout", "apt-get", 100, "The following NEW packages will be installed:\n  nothing-provides conflicting-requests\nE: Unable to locate package", "E: Sub-process /usr/bin/dpkg returned an error code (1)", ErrorUnknown},
+		{"yum stdout", "yum", 1, "No match for argument: foo\nProblem: foo conflicts with bar", "Error: Transaction check error", ErrorUnknown},
+		{"generic words", "dnf", 1, "", "Error: Problem in the post-install scriptlet, package requires: restart, connection refused", ErrorUnknown},
+		{"unknown manager", "pip", 1, "", "Could not resolve 'pypi.org', connection timed out", ErrorUnknown},
+		{"unknown", "googet.exe", 1, "", "something went wrong", ErrorUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.manager, tt.exitCode, []byte(tt.stdout), []byte(tt.stderr)); got != tt.want {
+				t.Errorf("classifyError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	// An exit code from a real process.
+	exitErr := exec.Command("/bin/sh", "-c", "exit 100").Run()
+	if exitErr == nil {
+		t.Skip("no shell to produce an exit code")
+	}
+	stderr := "Existing lock /var/run/yum.pid: another copy is running as pid 1234."
+	expectedCmd := utilmocks.EqCmd(exec.Command(yum, append(yumRemoveArgs, "nginx")...))
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte(stderr), exitErr).Times(1)
+
+	err := fmt.Errorf("wrapped: %w", RemoveYumPackages(testCtx, []string{"nginx"}))
+	var cerr *CommandError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("RemoveYumPackages() error %v is not a CommandError", err)
+	}
+	if cerr.ExitCode != 100 || cerr.Kind != ErrorLockHeld || ErrorKindOf(err) != ErrorLockHeld {
+		t.Errorf("unexpected CommandError exit code %d, kind %q", cerr.ExitCode, cerr.Kind)
+	}
+	if cerr.Excerpt() != stderr {
+		t.Errorf("Excerpt() = %q, want %q", cerr.Excerpt(), stderr)
+	}
+	if !strings.HasPrefix(cerr.Error(), "error running "+yum+" with args") {
+		t.Errorf("unexpected error message: %q", cerr.Error())
+	}
+	if ErrorKindOf(errors.New("other")) != "" {
+		t.Error("ErrorKindOf() of a non package manager error should be empty")
+	}
+}
+
+func TestCommandErrorExcerpt(t *testing.T) {
+	long := strings.Repeat("progress line\n", 50) + "E: the actual error"
+	e := &CommandError{Stderr: []byte(long)}
+	if got := e.Excerpt(); len(got) > maxStderrExcerpt || !strings.HasSuffix(got, "E: the actual error") || strings.HasPrefix(got, "ine") {
+		t.Errorf("Excerpt() = %q", got)
+	}
+	e = &CommandError{Stdout: []byte("Error: from stdout\n")}
+	if got := e.Excerpt(); got != "Error: from stdout" {
+		t.Errorf("Excerpt() = %q, want stdout fallback", got)
+	}
+}
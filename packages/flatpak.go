@@ -0,0 +1,99 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	flatpak string
+
+	// Only the system installation is managed, user installations belong
+	// to their users.
+	flatpakInstalledArgs = []string{"list", "--system", "--columns=application,version,branch,arch"}
+	flatpakInstallArgs   = []string{"install", "--system", "--noninteractive", "--assumeyes"}
+	flatpakRemoveArgs    = []string{"uninstall", "--system", "--noninteractive", "--assumeyes"}
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		flatpak = "/usr/bin/flatpak"
+	}
+	FlatpakExists = util.Exists(flatpak)
+}
+
+// InstallFlatpakPackages installs Flatpak applications or runtimes by ID,
+// for example org.mozilla.firefox, from the configured remotes.
+func InstallFlatpakPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, flatpak, append(flatpakInstallArgs, pkgs...))
+	return err
+}
+
+// RemoveFlatpakPackages removes Flatpak applications or runtimes by ID.
+func RemoveFlatpakPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, flatpak, append(flatpakRemoveArgs, pkgs...))
+	return err
+}
+
+// parseFlatpakList parses the tab separated output of flatpak list.
+func parseFlatpakList(ctx context.Context, data []byte) []*PkgInfo {
+	/*
+	   org.mozilla.firefox	128.0.3	stable	x86_64
+	   org.freedesktop.Platform		23.08	x86_64
+	*/
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+
+	var pkgs []*PkgInfo
+	for _, ln := range lines {
+		fields := strings.Split(string(ln), "\t")
+		if len(fields) != 4 || fields[0] == "" {
+			clog.Debugf(ctx, "%q does not represent a flatpak", ln)
+			continue
+		}
+		// Runtimes often have no version, the branch identifies them.
+		version := strings.TrimSpace(fields[1])
+		if version == "" {
+			version = strings.TrimSpace(fields[2])
+		}
+		arch := strings.TrimSpace(fields[3])
+		pkgs = append(pkgs, &PkgInfo{
+			Name:    strings.TrimSpace(fields[0]),
+			Arch:    osinfo.Architecture(arch),
+			RawArch: arch,
+			Version: version,
+		})
+	}
+	return pkgs
+}
+
+// InstalledFlatpakPackages queries for all Flatpak applications and
+// runtimes in the system installation.
+func InstalledFlatpakPackages(ctx context.Context) ([]*PkgInfo, error) {
+	out, err := run(ctx, flatpak, flatpakInstalledArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseFlatpakList(ctx, out), nil
+}
@@ -0,0 +1,113 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestInstallFlatpakPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(flatpak, append(flatpakInstallArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := InstallFlatpakPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("Could not install package")).Times(1)
+	if err := InstallFlatpakPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestRemoveFlatpakPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(flatpak, append(flatpakRemoveArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := RemoveFlatpakPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("Could not remove package")).Times(1)
+	if err := RemoveFlatpakPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestParseFlatpakList(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []*PkgInfo
+	}{
+		{
+			"AppsAndRuntimes",
+			[]byte("org.mozilla.firefox\t128.0.3\tstable\tx86_64\norg.freedesktop.Platform\t\t23.08\tx86_64\n"),
+			[]*PkgInfo{
+				{Name: "org.mozilla.firefox", Arch: "x86_64", RawArch: "x86_64", Version: "128.0.3"},
+				{Name: "org.freedesktop.Platform", Arch: "x86_64", RawArch: "x86_64", Version: "23.08"},
+			},
+		},
+		{"NoPackages", []byte("nothing here"), nil},
+		{"nil", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseFlatpakList(testCtx, tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFlatpakList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstalledFlatpakPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(flatpak, flatpakInstalledArgs...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("org.mozilla.firefox\t128.0.3\tstable\taarch64"), nil, nil).Times(1)
+	got, err := InstalledFlatpakPackages(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*PkgInfo{{Name: "org.mozilla.firefox", Arch: "aarch64", RawArch: "aarch64", Version: "128.0.3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InstalledFlatpakPackages() = %v, want %v", got, want)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, []byte("stderr"), errors.New("error")).Times(1)
+	if _, err := InstalledFlatpakPackages(testCtx); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
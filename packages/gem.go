@@ -16,92 +16,128 @@ package packages
 
 import (
 	"context"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
 var (
-	gem string
+	gem      string
+	gemPaths []string
 
 	gemListArgs        = []string{"list", "--local"}
 	gemOutdatedArgs    = []string{"outdated", "--local"}
 	gemListTimeout     = 15 * time.Second
 	gemOutdatedTimeout = 15 * time.Second
+
+	// gemLineRE matches a gem and its versions, e.g. "foo (1.2.3, 1.2.4)".
+	gemLineRE = regexp.MustCompile(`^(\S+) \((.+)\)$`)
+	// gemOutdatedRE matches an available update, e.g. "foo (1.2.8 < 1.3.2)".
+	gemOutdatedRE = regexp.MustCompile(`^(\S+) \(\S+ < (\S+)\)$`)
 )
 
 func init() {
-	if runtime.GOOS != "windows" {
-		gem = "/usr/bin/gem"
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// Distribution rubies install to /usr/bin, rubies built from source
+	// to /usr/local/bin.
+	gemPaths = []string{"/usr/bin/gem", "/usr/local/bin/gem"}
+	gem = gemPaths[0]
+	for _, p := range gemPaths {
+		if util.Exists(p) {
+			gem = p
+			break
+		}
 	}
 	GemExists = util.Exists(gem)
 }
 
-// GemUpdates queries for all available gem updates.
-func GemUpdates(ctx context.Context) ([]*PkgInfo, error) {
-	stdout, err := runWithDeadline(ctx, gemOutdatedTimeout, gem, gemOutdatedArgs)
-	if err != nil {
-		return nil, err
-	}
+// parseGemOutdated parses the output of gem outdated.
+func parseGemOutdated(ctx context.Context, data []byte) []*PkgInfo {
 	/*
 	   foo (1.2.8 < 1.3.2)
 	   bar (1.0.0 < 1.1.2)
 	   ...
 	*/
-
-	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
-	if len(lines) == 0 {
-		return nil, nil
-	}
-
 	var pkgs []*PkgInfo
-	for _, ln := range lines {
-		pkg := strings.Fields(ln)
-		if len(pkg) != 4 {
+	for _, ln := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		m := gemOutdatedRE.FindStringSubmatch(strings.TrimSpace(ln))
+		if m == nil {
 			clog.Debugf(ctx, "%q does not represent a gem update\n", ln)
 			continue
 		}
-		ver := strings.Trim(pkg[3], ")")
-		pkgs = append(pkgs, &PkgInfo{Name: pkg[0], Arch: noarch, Version: ver})
+		pkgs = append(pkgs, &PkgInfo{Name: m[1], Arch: noarch, Version: m[2]})
 	}
-	return pkgs, nil
+	return pkgs
 }
 
-// InstalledGemPackages queries for all installed gem packages.
-func InstalledGemPackages(ctx context.Context) ([]*PkgInfo, error) {
-	stdout, err := runWithDeadline(ctx, gemListTimeout, gem, gemListArgs)
+// GemUpdates queries for all available gem updates.
+func GemUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	stdout, err := runWithDeadline(ctx, gemOutdatedTimeout, gem, gemOutdatedArgs)
 	if err != nil {
 		return nil, err
 	}
+	return parseGemOutdated(ctx, stdout), nil
+}
 
+// parseGemList parses the output of gem list, every installed version of a
+// gem is reported as its own package.
+func parseGemList(ctx context.Context, data []byte) []*PkgInfo {
 	/*
 
 	   *** LOCAL GEMS ***
 
+	   bundler (2.4.10, default: 2.3.26)
 	   foo (1.2.3, 1.2.4)
-	   bar (1.2.3)
+	   nokogiri (1.15.4 x86_64-linux)
 	   ...
 	*/
-	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
-
-	if len(lines) == 0 {
-		clog.Debugf(ctx, "No gems installed.")
-		return nil, nil
-	}
-
 	var pkgs []*PkgInfo
-	for _, ln := range lines[2:] {
-		pkg := strings.Fields(ln)
-		if len(pkg) < 2 {
+	for _, ln := range strings.Split(string(data), "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || strings.HasPrefix(ln, "***") {
+			continue
+		}
+		m := gemLineRE.FindStringSubmatch(ln)
+		if m == nil {
 			clog.Debugf(ctx, "'%s' does not represent a gem", ln)
 			continue
 		}
-		for _, ver := range strings.Split(strings.Trim(pkg[1], "()"), ", ") {
-			pkgs = append(pkgs, &PkgInfo{Name: pkg[0], Arch: noarch, Version: ver})
+		for _, ver := range strings.Split(m[2], ", ") {
+			// Default gems ship with ruby itself.
+			ver = strings.TrimPrefix(ver, "default: ")
+			// Gems with native extensions list their platform, e.g.
+			// "1.15.4 x86_64-linux".
+			pkg := &PkgInfo{Name: m[1], Arch: noarch}
+			if v, platform, ok := strings.Cut(ver, " "); ok {
+				ver = v
+				pkg.RawArch = platform
+				cpu, _, _ := strings.Cut(platform, "-")
+				pkg.Arch = osinfo.Architecture(cpu)
+			}
+			pkg.Version = ver
+			pkgs = append(pkgs, pkg)
 		}
 	}
+	return pkgs
+}
+
+// InstalledGemPackages queries for all installed gem packages.
+func InstalledGemPackages(ctx context.Context) ([]*PkgInfo, error) {
+	stdout, err := runWithDeadline(ctx, gemListTimeout, gem, gemListArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := parseGemList(ctx, stdout)
+	if len(pkgs) == 0 {
+		clog.Debugf(ctx, "No gems installed.")
+	}
 	return pkgs, nil
 }
@@ -0,0 +1,105 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseGemList(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []*PkgInfo
+	}{
+		{
+			"Gems",
+			[]byte("\n*** LOCAL GEMS ***\n\nfoo (1.2.3, 1.2.4)\nbar (1.2.3)\n"),
+			[]*PkgInfo{
+				{Name: "foo", Arch: noarch, Version: "1.2.3"},
+				{Name: "foo", Arch: noarch, Version: "1.2.4"},
+				{Name: "bar", Arch: noarch, Version: "1.2.3"},
+			},
+		},
+		{
+			"DefaultGems",
+			[]byte("bundler (2.4.10, default: 2.3.26)\njson (default: 2.6.3)\n"),
+			[]*PkgInfo{
+				{Name: "bundler", Arch: noarch, Version: "2.4.10"},
+				{Name: "bundler", Arch: noarch, Version: "2.3.26"},
+				{Name: "json", Arch: noarch, Version: "2.6.3"},
+			},
+		},
+		{
+			"Platforms",
+			[]byte("nokogiri (1.15.4 x86_64-linux, 1.14.0 aarch64-linux)\nffi (1.15.5 java)\n"),
+			[]*PkgInfo{
+				{Name: "nokogiri", Arch: "x86_64", RawArch: "x86_64-linux", Version: "1.15.4"},
+				{Name: "nokogiri", Arch: "aarch64", RawArch: "aarch64-linux", Version: "1.14.0"},
+				{Name: "ffi", Arch: "java", RawArch: "java", Version: "1.15.5"},
+			},
+		},
+		{"NoGems", []byte("\n*** LOCAL GEMS ***\n\n"), nil},
+		{"Garbage", []byte("something unexpected\n"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseGemList(testCtx, tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGemList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGemOutdated(t *testing.T) {
+	data := []byte("foo (1.2.8 < 1.3.2)\nbar (1.0.0 < 1.1.2)\nsomething we dont understand\n")
+	want := []*PkgInfo{
+		{Name: "foo", Arch: noarch, Version: "1.3.2"},
+		{Name: "bar", Arch: noarch, Version: "1.1.2"},
+	}
+	if got := parseGemOutdated(testCtx, data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGemOutdated() = %v, want %v", got, want)
+	}
+}
+
+func TestInstalledGemPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(gem, gemListArgs...))
+
+	mockCommandRunner.EXPECT().Run(gomock.Any(), expectedCmd).Return([]byte("\n*** LOCAL GEMS ***\n\nrake (13.0.6)\n"), nil, nil).Times(1)
+	got, err := InstalledGemPackages(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*PkgInfo{{Name: "rake", Arch: noarch, Version: "13.0.6"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InstalledGemPackages() = %v, want %v", got, want)
+	}
+
+	mockCommandRunner.EXPECT().Run(gomock.Any(), expectedCmd).Return(nil, []byte("stderr"), errors.New("error")).Times(1)
+	if _, err := InstalledGemPackages(testCtx); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
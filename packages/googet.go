@@ -17,24 +17,42 @@ package packages
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
 var (
 	googet string
+	// googetStateFile is where GooGet records the installed packages and
+	// the repositories they came from. GooGet versions that keep this in a
+	// database instead do not have it.
+	googetStateFile string
 
 	googetUpdateQueryArgs    = []string{"update"}
 	googetInstalledQueryArgs = []string{"installed"}
 	googetInstallArgs        = []string{"-noconfirm", "install"}
 	googetRemoveArgs         = []string{"-noconfirm", "remove"}
+
+	// googetRetries is how often a GooGet command that failed with a
+	// transient error is retried, set with SetGooGetRetries.
+	googetRetries int32 = 2
+	// googetRetryDelay is the wait before the first retry, it doubles with
+	// every further retry.
+	googetRetryDelay = 10 * time.Second
 )
 
 func init() {
 	googet = filepath.Join(os.Getenv("GooGetRoot"), "googet.exe")
+	googetStateFile = filepath.Join(os.Getenv("GooGetRoot"), "googet.state")
 	GooGetExists = util.Exists(googet)
 }
 
@@ -63,9 +81,55 @@ func parseGooGetUpdates(data []byte) []*PkgInfo {
 	return pkgs
 }
 
+// SetGooGetRetries sets how often GooGet commands that fail because a
+// repository could not be reached, or queries that time out, are retried.
+func SetGooGetRetries(retries int) {
+	if retries < 0 {
+		retries = 0
+	}
+	atomic.StoreInt32(&googetRetries, int32(retries))
+}
+
+// googetTransient reports whether a failed GooGet command of class may
+// succeed if retried. Only queries are retried after a timeout, killing
+// GooGet during an install or removal leaves its installer running.
+func googetTransient(err error, class string) bool {
+	switch ErrorKindOf(err) {
+	case ErrorNetwork, ErrorLockHeld:
+		return true
+	}
+	return class == OpResolve && errors.Is(err, context.DeadlineExceeded)
+}
+
+// runGooGet runs googet bounded by the googet timeout of class, retrying
+// transient failures with backoff. Flaky repos can leave googet hanging, the
+// timeout stops the attempt so that a query can be retried.
+func runGooGet(ctx context.Context, class string, args []string) ([]byte, error) {
+	retries := int(atomic.LoadInt32(&googetRetries))
+	delay := googetRetryDelay
+	for attempt := 1; ; attempt++ {
+		opCtx, cancel := withOperationTimeout(ctx, "googet", class)
+		out, err := run(opCtx, googet, args)
+		if err != nil && opCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = fmt.Errorf("googet %s exceeded its %s timeout: %w", class, operationTimeout("googet", class), context.DeadlineExceeded)
+		}
+		cancel()
+		if err == nil || attempt > retries || ctx.Err() != nil || !googetTransient(err, class) {
+			return out, err
+		}
+		clog.Warningf(ctx, "GooGet command failed (attempt %d of %d), retrying in %s: %v", attempt, retries+1, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
 // GooGetUpdates queries for all available googet updates.
 func GooGetUpdates(ctx context.Context) ([]*PkgInfo, error) {
-	out, err := run(ctx, googet, googetUpdateQueryArgs)
+	out, err := runGooGet(ctx, OpResolve, googetUpdateQueryArgs)
 	if err != nil {
 		return nil, err
 	}
@@ -75,13 +139,15 @@ func GooGetUpdates(ctx context.Context) ([]*PkgInfo, error) {
 
 // InstallGooGetPackages installs GooGet packages.
 func InstallGooGetPackages(ctx context.Context, pkgs []string) error {
-	_, err := run(ctx, googet, append(googetInstallArgs, pkgs...))
+	defer InvalidateQueryCache()
+	_, err := runGooGet(ctx, OpInstall, append(googetInstallArgs, pkgs...))
 	return err
 }
 
 // RemoveGooGetPackages installs GooGet packages.
 func RemoveGooGetPackages(ctx context.Context, pkgs []string) error {
-	_, err := run(ctx, googet, append(googetRemoveArgs, pkgs...))
+	defer InvalidateQueryCache()
+	_, err := runGooGet(ctx, OpInstall, append(googetRemoveArgs, pkgs...))
 	return err
 }
 
@@ -113,10 +179,42 @@ func parseInstalledGooGetPackages(data []byte) []*PkgInfo {
 
 // InstalledGooGetPackages queries for all installed googet packages.
 func InstalledGooGetPackages(ctx context.Context) ([]*PkgInfo, error) {
-	out, err := run(ctx, googet, googetInstalledQueryArgs)
+	out, err := runGooGet(ctx, OpResolve, googetInstalledQueryArgs)
 	if err != nil {
 		return nil, err
 	}
 
-	return parseInstalledGooGetPackages(out), nil
+	pkgs := parseInstalledGooGetPackages(out)
+	if err := addGooGetRepositories(pkgs); err != nil {
+		clog.Debugf(ctx, "Error reading the GooGet repositories of installed packages: %v", err)
+	}
+	return pkgs, nil
+}
+
+// addGooGetRepositories sets the repository of the installed packages from
+// the GooGet state file.
+func addGooGetRepositories(pkgs []*PkgInfo) error {
+	data, err := os.ReadFile(googetStateFile)
+	if err != nil {
+		return err
+	}
+	var state []struct {
+		SourceRepo  string
+		PackageSpec *struct{ Name, Version, Arch string }
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("error parsing %s: %v", googetStateFile, err)
+	}
+	repos := map[string]string{}
+	for _, s := range state {
+		if s.PackageSpec != nil && s.SourceRepo != "" {
+			repos[s.PackageSpec.Name+"."+s.PackageSpec.Arch+" "+s.PackageSpec.Version] = s.SourceRepo
+		}
+	}
+	for _, pkg := range pkgs {
+		if repo, ok := repos[pkg.Name+"."+pkg.Arch+" "+pkg.Version]; ok {
+			pkg.Repository = repo
+		}
+	}
+	return nil
 }
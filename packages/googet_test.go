@@ -15,10 +15,15 @@
 package packages
 
 import (
+	"context"
 	"errors"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
 	"github.com/golang/mock/gomock"
@@ -101,6 +106,27 @@ func TestInstalledGooGetPackages(t *testing.T) {
 		t.Errorf("InstalledGooGetPackages() = %v, want %v", ret, want)
 	}
 
+	// The repository comes from the state file.
+	oldStateFile := googetStateFile
+	defer func() { googetStateFile = oldStateFile }()
+	googetStateFile = filepath.Join(t.TempDir(), "googet.state")
+	state := `[{"SourceRepo":"https://packages.cloud.google.com/yuck/repos/google-compute-engine-stable","PackageSpec":{"Name":"foo","Version":"1.2.3@4","Arch":"x86_64"}},{"SourceRepo":"","PackageSpec":{"Name":"bar","Version":"1@1","Arch":"noarch"}}]`
+	if err := os.WriteFile(googetStateFile, []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("foo.x86_64 1.2.3@4\nbar.noarch 1@1"), []byte("stderr"), nil).Times(1)
+	ret, err = InstalledGooGetPackages(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []*PkgInfo{
+		{Name: "foo", Arch: "x86_64", Version: "1.2.3@4", Repository: "https://packages.cloud.google.com/yuck/repos/google-compute-engine-stable"},
+		{Name: "bar", Arch: "noarch", Version: "1@1"},
+	}
+	if !reflect.DeepEqual(ret, want) {
+		t.Errorf("InstalledGooGetPackages() with a state file = %v, want %v", ret, want)
+	}
+
 	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, nil, errors.New("bad error")).Times(1)
 	if _, err := InstalledGooGetPackages(testCtx); err == nil {
 		t.Errorf("did not get expected error")
@@ -151,3 +177,54 @@ func TestGooGetUpdates(t *testing.T) {
 		t.Errorf("did not get expected error")
 	}
 }
+
+func TestGooGetRetries(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	oldDelay := googetRetryDelay
+	defer func() { googetRetryDelay = oldDelay; SetGooGetRetries(2); SetOperationTimeouts(nil) }()
+	googetRetryDelay = 0
+	expectedCmd := utilmocks.EqCmd(exec.Command(googet, append(googetInstallArgs, pkgs...)...))
+	networkErr := []byte(`Get "https://packages.cloud.google.com/repo/index": dial tcp: lookup packages.cloud.google.com: no such host`)
+
+	// Network errors are retried.
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, networkErr, errors.New("exit status 1")),
+		mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), nil, nil),
+	)
+	if err := InstallGooGetPackages(testCtx, pkgs); err != nil {
+		t.Errorf("InstallGooGetPackages() with a transient error: %v", err)
+	}
+
+	// Retries are limited.
+	SetGooGetRetries(1)
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, networkErr, errors.New("exit status 1")).Times(2)
+	if err := InstallGooGetPackages(testCtx, pkgs); ErrorKindOf(err) != ErrorNetwork {
+		t.Errorf("InstallGooGetPackages() = %v, want a network error", err)
+	}
+
+	// A query that hangs is stopped by its timeout and retried.
+	SetOperationTimeouts(map[string]time.Duration{"googet.resolve": time.Millisecond, "googet.install": time.Millisecond})
+	hang := func(ctx context.Context, _ *exec.Cmd) ([]byte, []byte, error) {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+	updateCmd := utilmocks.EqCmd(exec.Command(googet, googetUpdateQueryArgs...))
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(gomock.Any(), updateCmd).DoAndReturn(hang),
+		mockCommandRunner.EXPECT().Run(gomock.Any(), updateCmd).Return([]byte("stdout"), nil, nil),
+	)
+	if _, err := GooGetUpdates(testCtx); err != nil {
+		t.Errorf("GooGetUpdates() after a timeout: %v", err)
+	}
+
+	// An install that hangs is not retried, its installer may still be
+	// running.
+	mockCommandRunner.EXPECT().Run(gomock.Any(), expectedCmd).DoAndReturn(hang)
+	if err := InstallGooGetPackages(testCtx, pkgs); err == nil || !strings.Contains(err.Error(), "googet install exceeded its 1ms timeout") {
+		t.Errorf("InstallGooGetPackages() = %v, want a timeout error", err)
+	}
+}
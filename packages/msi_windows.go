@@ -235,6 +235,7 @@ func MSIInstalled(productCode string) (bool, error) {
 
 // InstallMSIPackage installs an msi package.
 func InstallMSIPackage(ctx context.Context, path string, args []string) error {
+	defer InvalidateQueryCache()
 	setUIMode()
 
 	args = append(msiInstallArgs, args...)
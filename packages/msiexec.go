@@ -0,0 +1,125 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+var (
+	msiexec string
+
+	msiexecInstallArgs   = []string{"/i"}
+	msiexecUninstallArgs = []string{"/x"}
+	// msiexecQuietArgs run msiexec without UI and suppress the reboot, a
+	// required reboot is reported through the exit code instead.
+	msiexecQuietArgs = []string{"/qn", "/norestart"}
+
+	// msiexecSuccessCodes are ERROR_SUCCESS, ERROR_SUCCESS_REBOOT_INITIATED
+	// and ERROR_SUCCESS_REBOOT_REQUIRED.
+	msiexecSuccessCodes = []int{0, 1641, 3010}
+)
+
+func init() {
+	if runtime.GOOS == "windows" {
+		msiexec = filepath.Join(os.Getenv("SystemRoot"), `System32\msiexec.exe`)
+	}
+}
+
+// MSIOptions are the options of an MSI install or uninstall.
+type MSIOptions struct {
+	// Properties are public property assignments, they are passed to
+	// msiexec as written so values with spaces are quoted the way msiexec
+	// expects, such as `INSTALLDIR="C:\Program Files\Foo"`.
+	Properties []string
+	// Transforms are the paths of .mst transforms applied on install.
+	Transforms []string
+	// SuccessCodes are the msiexec exit codes treated as success, 0, 1641
+	// and 3010 if none are set.
+	SuccessCodes []int
+}
+
+// MSIInstall installs the MSI package at path with msiexec.
+func MSIInstall(ctx context.Context, path string, opts MSIOptions) error {
+	defer InvalidateQueryCache()
+	args := append(append([]string{}, msiexecInstallArgs...), quoteMSIArg(path))
+	args = append(args, msiexecQuietArgs...)
+	if len(opts.Transforms) > 0 {
+		args = append(args, "TRANSFORMS="+quoteMSIArg(strings.Join(opts.Transforms, ";")))
+	}
+	args = append(args, opts.Properties...)
+	clog.Infof(ctx, "Installing msi package %q with command line %q.", path, args)
+	return runMSIExec(ctx, args, opts.SuccessCodes)
+}
+
+// MSIUninstall uninstalls an MSI package with msiexec, target is the
+// ProductCode or the path of the package. Transforms are not applied on
+// uninstall.
+func MSIUninstall(ctx context.Context, target string, opts MSIOptions) error {
+	defer InvalidateQueryCache()
+	args := append(append([]string{}, msiexecUninstallArgs...), quoteMSIArg(target))
+	args = append(args, msiexecQuietArgs...)
+	args = append(args, opts.Properties...)
+	clog.Infof(ctx, "Uninstalling msi package %q with command line %q.", target, args)
+	return runMSIExec(ctx, args, opts.SuccessCodes)
+}
+
+// quoteMSIArg quotes s the way msiexec parses its command line, which
+// differs from the quoting exec.Command uses.
+func quoteMSIArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// msiexecCmdLine returns the msiexec command line for args, which are
+// already quoted for msiexec.
+func msiexecCmdLine(args []string) string {
+	return strings.Join(append([]string{quoteMSIArg(msiexec)}, args...), " ")
+}
+
+// runMSIExec runs msiexec with args, which are already quoted for msiexec
+// and are passed on its command line as they are.
+func runMSIExec(ctx context.Context, args []string, successCodes []int) error {
+	if len(successCodes) == 0 {
+		successCodes = msiexecSuccessCodes
+	}
+	stdout, stderr, err := runner.Run(ctx, msiexecCommand(ctx, args))
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		for _, code := range successCodes {
+			if exitErr.ExitCode() != code {
+				continue
+			}
+			if code == 1641 || code == 3010 {
+				clog.Infof(ctx, "msiexec exited with %d, a reboot is required to complete the operation.", code)
+			}
+			return nil
+		}
+	}
+	return newCommandError(msiexec, args, stdout, stderr, err)
+}
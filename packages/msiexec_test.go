@@ -0,0 +1,84 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"os/exec"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestMSIInstall(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	// Properties are passed as written, paths are quoted for msiexec.
+	opts := MSIOptions{Properties: []string{`INSTALLDIR="C:\Program Files\Foo"`, "REBOOT=ReallySuppress"}, Transforms: []string{`C:\a.mst`, `C:\my mst\b.mst`}}
+	args := []string{"/i", `"C:\my pkgs\foo.msi"`, "/qn", "/norestart", `TRANSFORMS="C:\a.mst;C:\my mst\b.mst"`, `INSTALLDIR="C:\Program Files\Foo"`, "REBOOT=ReallySuppress"}
+	expectedCmd := utilmocks.EqCmd(exec.Command(msiexec, args...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, nil, nil).Times(1)
+	if err := MSIInstall(testCtx, `C:\my pkgs\foo.msi`, opts); err != nil {
+		t.Errorf("MSIInstall(): %v", err)
+	}
+
+	// Exit codes above 255 cannot be produced here, the defaults are
+	// checked with a failing code.
+	errExit1 := exec.Command("/bin/sh", "-c", "exit 1").Run()
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, nil, errExit1).Times(1)
+	if err := MSIInstall(testCtx, `C:\my pkgs\foo.msi`, opts); err == nil {
+		t.Error("MSIInstall() with exit code 1 did not return an error")
+	}
+}
+
+func TestMSIExecCmdLine(t *testing.T) {
+	defer func(old string) { msiexec = old }(msiexec)
+	msiexec = `C:\Windows\System32\msiexec.exe`
+
+	args := []string{"/i", quoteMSIArg(`C:\my pkgs\foo.msi`), "/qn", `INSTALLDIR="C:\Program Files\Foo"`, "NAME=" + quoteMSIArg(`say "hi"`)}
+	want := `C:\Windows\System32\msiexec.exe /i "C:\my pkgs\foo.msi" /qn INSTALLDIR="C:\Program Files\Foo" NAME="say ""hi"""`
+	if got := msiexecCmdLine(args); got != want {
+		t.Errorf("msiexecCmdLine(%q) = %s, want %s", args, got, want)
+	}
+}
+
+func TestMSIUninstall(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	// Transforms only apply on install.
+	opts := MSIOptions{Transforms: []string{`C:\a.mst`}, SuccessCodes: []int{0, 69}}
+	expectedCmd := utilmocks.EqCmd(exec.Command(msiexec, "/x", "{PRODUCT-CODE}", "/qn", "/norestart"))
+
+	errExit69 := exec.Command("/bin/sh", "-c", "exit 69").Run()
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, nil, errExit69).Times(1)
+	if err := MSIUninstall(testCtx, "{PRODUCT-CODE}", opts); err != nil {
+		t.Errorf("MSIUninstall() with an allowed exit code: %v", err)
+	}
+
+	errExit2 := exec.Command("/bin/sh", "-c", "exit 2").Run()
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, nil, errExit2).Times(1)
+	if err := MSIUninstall(testCtx, "{PRODUCT-CODE}", opts); err == nil {
+		t.Error("MSIUninstall() with exit code 2 did not return an error")
+	}
+}
@@ -0,0 +1,30 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// msiexecCommand returns an msiexec command with args passed on the command
+// line as they are, exec.Command would quote them in a way msiexec can't
+// parse.
+func msiexecCommand(ctx context.Context, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, msiexec)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: msiexecCmdLine(args)}
+	return cmd
+}
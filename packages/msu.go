@@ -0,0 +1,47 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+var kbRE = regexp.MustCompile(`(?i)kb(\d+)`)
+
+// IsUpdatePackage reports whether name refers to an .msu or .cab Windows
+// update package, and whether it is a cab.
+func IsUpdatePackage(name string) (isUpdate, isCAB bool) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".msu":
+		return true, false
+	case ".cab":
+		return true, true
+	}
+	return false, false
+}
+
+// KBFromFileName extracts the KB article id (e.g. "KB5005565") from an
+// update package file name, an empty string is returned if there is none.
+// Microsoft Update Catalog packages are named like
+// windows10.0-kb5005565-x64_<hash>.msu.
+func KBFromFileName(name string) string {
+	m := kbRE.FindStringSubmatch(path.Base(strings.ReplaceAll(name, `\`, "/")))
+	if m == nil {
+		return ""
+	}
+	return "KB" + m[1]
+}
@@ -0,0 +1,52 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import "testing"
+
+func TestKBFromFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"windows10.0-kb5005565-x64_5ee2f2e7c3a6b4f1.msu", "KB5005565"},
+		{`C:\updates\Windows6.1-KB2999226-x64.msu`, "KB2999226"},
+		{"gs://bucket/kb890830/update.cab", ""},
+		{"update.cab", ""},
+	}
+	for _, tt := range tests {
+		if got := KBFromFileName(tt.name); got != tt.want {
+			t.Errorf("KBFromFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsUpdatePackage(t *testing.T) {
+	tests := []struct {
+		name          string
+		update, isCAB bool
+	}{
+		{"foo.msu", true, false},
+		{"FOO.CAB", true, true},
+		{"foo.msi", false, false},
+		{"foo", false, false},
+	}
+	for _, tt := range tests {
+		update, isCAB := IsUpdatePackage(tt.name)
+		if update != tt.update || isCAB != tt.isCAB {
+			t.Errorf("IsUpdatePackage(%q) = (%t, %t), want (%t, %t)", tt.name, update, isCAB, tt.update, tt.isCAB)
+		}
+	}
+}
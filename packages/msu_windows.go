@@ -0,0 +1,120 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"golang.org/x/sys/windows/registry"
+)
+
+var (
+	wusa = filepath.Join(os.Getenv("SystemRoot"), `System32\wusa.exe`)
+	dism = filepath.Join(os.Getenv("SystemRoot"), `System32\dism.exe`)
+
+	wusaInstallArgs = []string{"/quiet", "/norestart"}
+	dismInstallArgs = []string{"/online", "/add-package", "/quiet", "/norestart"}
+	dismCleanupArgs = []string{"/online", "/cleanup-image", "/startcomponentcleanup", "/quiet"}
+)
+
+const (
+	// ERROR_SUCCESS_REBOOT_REQUIRED
+	exitRebootRequired = 3010
+	// WU_S_REBOOT_REQUIRED, returned by wusa.
+	exitWURebootRequired = 0x240005
+	// WU_S_ALREADY_INSTALLED, returned by wusa.
+	exitWUAlreadyInstalled = 0x240006
+)
+
+func runUpdateInstaller(ctx context.Context, cmd string, args []string) error {
+	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, cmd, args...))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case exitRebootRequired, exitWURebootRequired, exitWUAlreadyInstalled:
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", cmd, args, err, stdout, stderr)
+	}
+	return nil
+}
+
+// InstallMSUPackage installs an .msu update package using wusa. Reboots are
+// suppressed, a pending reboot is not treated as an error.
+func InstallMSUPackage(ctx context.Context, path string) error {
+	defer InvalidateQueryCache()
+	return runUpdateInstaller(ctx, wusa, append([]string{path}, wusaInstallArgs...))
+}
+
+// InstallCABPackage installs a .cab update package using DISM. Reboots are
+// suppressed, a pending reboot is not treated as an error.
+func InstallCABPackage(ctx context.Context, path string) error {
+	defer InvalidateQueryCache()
+	return runUpdateInstaller(ctx, dism, append(append([]string{}, dismInstallArgs...), "/packagepath:"+path))
+}
+
+// DISMComponentCleanup runs DISM /StartComponentCleanup, removing superseded
+// versions of components from the component store.
+func DISMComponentCleanup(ctx context.Context) ([]byte, error) {
+	return run(ctx, dism, dismCleanupArgs)
+}
+
+// HotFixInstalled reports whether the update with the given KB id is
+// installed according to Win32_QuickFixEngineering.
+func HotFixInstalled(ctx context.Context, kb string) (bool, error) {
+	qfe, err := QuickFixEngineering(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, q := range qfe {
+		if strings.EqualFold(q.HotFixID, kb) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DownloadCatalogUpdate downloads the update packages for KB kb that apply to
+// this system from the Microsoft Update Catalog into dir, and returns their
+// paths in install order. Each file is checked against its published SHA1.
+func DownloadCatalogUpdate(ctx context.Context, kb, dir string) ([]string, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion`, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	productName, _, err := k.GetStringValue("ProductName")
+	if err != nil {
+		return nil, err
+	}
+	displayVersion, _, _ := k.GetStringValue("DisplayVersion")
+	releaseID, _, _ := k.GetStringValue("ReleaseId")
+	displayVersion = osinfo.WindowsDisplayVersion(displayVersion, releaseID)
+	buildNumber, _, _ := k.GetStringValue("CurrentBuildNumber")
+	build, _ := strconv.Atoi(buildNumber)
+
+	return downloadCatalogUpdate(ctx, kb, NewCatalogFilter(productName, displayVersion, build, runtime.GOARCH), dir)
+}
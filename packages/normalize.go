@@ -0,0 +1,106 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import "sort"
+
+// Normalize sorts every package list so reports do not depend on the order
+// package managers list packages in, and sets stable item IDs so consumers
+// can diff reports by ID. IDs are of the form "<manager>:<name>:<arch>".
+// Packages are identified by manager, name and
+// architecture; the version is only added to the ID when several versions
+// of a package are installed, such as kernels.
+func (p *Packages) Normalize() {
+	if p == nil {
+		return
+	}
+	for kind, pkgs := range map[string][]*PkgInfo{
+		"yum":     p.Yum,
+		"rpm":     p.Rpm,
+		"apt":     p.Apt,
+		"deb":     p.Deb,
+		"zypper":  p.Zypper,
+		"cos":     p.COS,
+		"gem":     p.Gem,
+		"pip":     p.Pip,
+		"googet":  p.GooGet,
+		"apk":     p.Apk,
+		"pacman":  p.Pacman,
+		"portage": p.Portage,
+		"flatpak": p.Flatpak,
+		"brew":    p.Brew,
+		"npm":     p.Npm,
+		"winget":  p.Winget,
+	} {
+		normalizePkgInfos(kind, pkgs)
+	}
+
+	// Zypper patch names are unique, they serve as the ID.
+	sort.SliceStable(p.ZypperPatches, func(i, j int) bool { return p.ZypperPatches[i].Name < p.ZypperPatches[j].Name })
+	sort.SliceStable(p.DnfModules, func(i, j int) bool { return p.DnfModules[i].Name < p.DnfModules[j].Name })
+
+	sort.SliceStable(p.WUA, func(i, j int) bool {
+		if p.WUA[i].UpdateID != p.WUA[j].UpdateID {
+			return p.WUA[i].UpdateID < p.WUA[j].UpdateID
+		}
+		return p.WUA[i].RevisionNumber < p.WUA[j].RevisionNumber
+	})
+	for _, pkg := range p.WUA {
+		pkg.ID = "wua:" + pkg.UpdateID
+	}
+
+	sort.SliceStable(p.QFE, func(i, j int) bool { return p.QFE[i].HotFixID < p.QFE[j].HotFixID })
+	for _, pkg := range p.QFE {
+		pkg.ID = "qfe:" + pkg.HotFixID
+	}
+
+	sort.SliceStable(p.WindowsApplication, func(i, j int) bool {
+		a, b := p.WindowsApplication[i], p.WindowsApplication[j]
+		if a.DisplayName != b.DisplayName {
+			return a.DisplayName < b.DisplayName
+		}
+		return a.DisplayVersion < b.DisplayVersion
+	})
+}
+
+func normalizePkgInfos(kind string, pkgs []*PkgInfo) {
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		a, b := pkgs[i], pkgs[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Arch != b.Arch {
+			return a.Arch < b.Arch
+		}
+		return a.Version < b.Version
+	})
+	count := map[string]int{}
+	for _, pkg := range pkgs {
+		count[pkgID(kind, pkg)]++
+	}
+	for _, pkg := range pkgs {
+		pkg.ID = pkgID(kind, pkg)
+		if count[pkg.ID] > 1 {
+			pkg.ID += ":" + pkg.Version
+		}
+	}
+}
+
+func pkgID(kind string, pkg *PkgInfo) string {
+	if pkg.Arch == "" {
+		return kind + ":" + pkg.Name
+	}
+	return kind + ":" + pkg.Name + ":" + pkg.Arch
+}
@@ -0,0 +1,61 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	p := &Packages{
+		Rpm: []*PkgInfo{
+			{Name: "kernel", Arch: "x86_64", Version: "5.14.0-2"},
+			{Name: "bash", Arch: "x86_64", Version: "5.1"},
+			{Name: "kernel", Arch: "x86_64", Version: "5.14.0-1"},
+			{Name: "bash", Arch: "i686", Version: "5.1"},
+		},
+		Gem:           []*PkgInfo{{Name: "rake", Version: "13.0"}},
+		QFE:           []*QFEPackage{{HotFixID: "KB2"}, {HotFixID: "KB1"}},
+		WUA:           []*WUAPackage{{UpdateID: "b"}, {UpdateID: "a", RevisionNumber: 2}, {UpdateID: "a", RevisionNumber: 1}},
+		ZypperPatches: []*ZypperPatch{{Name: "SUSE-2"}, {Name: "SUSE-1"}},
+	}
+	p.Normalize()
+
+	var rpmIDs []string
+	for _, pkg := range p.Rpm {
+		rpmIDs = append(rpmIDs, pkg.ID)
+	}
+	wantRpm := []string{"rpm:bash:i686", "rpm:bash:x86_64", "rpm:kernel:x86_64:5.14.0-1", "rpm:kernel:x86_64:5.14.0-2"}
+	if !reflect.DeepEqual(rpmIDs, wantRpm) {
+		t.Errorf("rpm IDs = %q, want %q", rpmIDs, wantRpm)
+	}
+	if p.Gem[0].ID != "gem:rake" {
+		t.Errorf("gem ID = %q, want %q", p.Gem[0].ID, "gem:rake")
+	}
+	if p.QFE[0].ID != "qfe:KB1" || p.QFE[1].ID != "qfe:KB2" {
+		t.Errorf("unexpected QFE order: %+v, %+v", p.QFE[0], p.QFE[1])
+	}
+	if p.WUA[0].RevisionNumber != 1 || p.WUA[1].RevisionNumber != 2 || p.WUA[2].ID != "wua:b" {
+		t.Errorf("unexpected WUA order: %+v, %+v, %+v", p.WUA[0], p.WUA[1], p.WUA[2])
+	}
+	if p.ZypperPatches[0].Name != "SUSE-1" {
+		t.Errorf("unexpected zypper patch order: %+v", p.ZypperPatches)
+	}
+
+	// Nil packages are left alone.
+	var nilPkgs *Packages
+	nilPkgs.Normalize()
+}
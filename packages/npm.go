@@ -0,0 +1,101 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	npm      string
+	npmPaths []string
+
+	// Only the top level global packages are listed, the full dependency
+	// tree of a large global install can take minutes to walk.
+	npmListArgs    = []string{"ls", "--global", "--json", "--depth=0"}
+	npmListTimeout = 30 * time.Second
+)
+
+func init() {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// Distribution packages install to /usr/bin, the nodejs.org tarballs to
+	// /usr/local/bin.
+	npmPaths = []string{"/usr/bin/npm", "/usr/local/bin/npm"}
+	for _, p := range npmPaths {
+		if util.Exists(p) {
+			npm = p
+			break
+		}
+	}
+	NpmExists = npm != ""
+}
+
+type npmList struct {
+	Dependencies map[string]struct {
+		Version string `json:"version"`
+	} `json:"dependencies"`
+}
+
+// parseNpmList parses the output of npm ls --global --json, dependencies
+// without a version are missing or invalid and are skipped.
+func parseNpmList(data []byte) ([]*PkgInfo, error) {
+	/*
+	   {
+	     "name": "lib",
+	     "dependencies": {
+	       "npm": {"version": "10.8.2", "overridden": false},
+	       "@angular/cli": {"version": "18.1.3", "overridden": false}
+	     }
+	   }
+	*/
+	var l npmList
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, err
+	}
+	var pkgs []*PkgInfo
+	for name, dep := range l.Dependencies {
+		if dep.Version == "" {
+			continue
+		}
+		pkgs = append(pkgs, &PkgInfo{Name: name, Arch: noarch, Version: dep.Version})
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	return pkgs, nil
+}
+
+// InstalledNpmPackages queries for all globally installed npm packages.
+func InstalledNpmPackages(ctx context.Context) ([]*PkgInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, npmListTimeout)
+	defer cancel()
+	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, npm, npmListArgs...))
+	// npm ls exits 1 when the tree has problems, such as a missing peer
+	// dependency, but still lists it.
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(stdout) > 0) {
+		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", npm, npmListArgs, err, stdout, stderr)
+	}
+	return parseNpmList(stdout)
+}
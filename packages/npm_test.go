@@ -0,0 +1,103 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseNpmList(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []*PkgInfo
+		wantErr bool
+	}{
+		{
+			"Packages",
+			[]byte(`{"name": "lib", "dependencies": {"npm": {"version": "10.8.2", "overridden": false}, "@angular/cli": {"version": "18.1.3", "overridden": false}}}`),
+			[]*PkgInfo{
+				{Name: "@angular/cli", Arch: noarch, Version: "18.1.3"},
+				{Name: "npm", Arch: noarch, Version: "10.8.2"},
+			},
+			false,
+		},
+		{
+			"MissingDependency",
+			[]byte(`{"problems": ["missing: typescript@5"], "dependencies": {"typescript": {"required": "5", "missing": true}, "yarn": {"version": "1.22.22"}}}`),
+			[]*PkgInfo{{Name: "yarn", Arch: noarch, Version: "1.22.22"}},
+			false,
+		},
+		{"NoPackages", []byte(`{"name": "lib"}`), nil, false},
+		{"NotJSON", []byte("npm ERR! code ENOENT"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNpmList(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNpmList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseNpmList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstalledNpmPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	oldNpm := npm
+	defer func() { npm = oldNpm }()
+	npm = "/usr/bin/npm"
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(npm, npmListArgs...))
+	out := []byte(`{"dependencies": {"yarn": {"version": "1.22.22"}}}`)
+	want := []*PkgInfo{{Name: "yarn", Arch: noarch, Version: "1.22.22"}}
+
+	mockCommandRunner.EXPECT().Run(gomock.Any(), expectedCmd).Return(out, nil, nil).Times(1)
+	got, err := InstalledNpmPackages(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InstalledNpmPackages() = %v, want %v", got, want)
+	}
+
+	// Problems in the tree exit 1 but still list the packages.
+	errExit1 := exec.Command("/bin/bash", "-c", "exit 1").Run()
+	mockCommandRunner.EXPECT().Run(gomock.Any(), expectedCmd).Return(out, []byte("npm ERR! missing"), errExit1).Times(1)
+	got, err = InstalledNpmPackages(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InstalledNpmPackages() = %v, want %v", got, want)
+	}
+
+	mockCommandRunner.EXPECT().Run(gomock.Any(), expectedCmd).Return(nil, []byte("stderr"), errors.New("error")).Times(1)
+	if _, err := InstalledNpmPackages(testCtx); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
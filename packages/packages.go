@@ -20,7 +20,9 @@ import (
 	"context"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -40,6 +42,9 @@ var (
 	YumExists bool
 	// ZypperExists indicates whether zypper is installed.
 	ZypperExists bool
+	// TransactionalUpdateExists indicates whether transactional-update is
+	// installed, zypper changes on a read-only root are made with it.
+	TransactionalUpdateExists bool
 	// RPMExists indicates whether rpm is installed.
 	RPMExists bool
 	// RPMQueryExists indicates whether rpmquery is installed.
@@ -54,6 +59,24 @@ var (
 	GooGetExists bool
 	// MSIExists indicates whether MSIs can be installed.
 	MSIExists bool
+	// ApkExists indicates whether apk is installed.
+	ApkExists bool
+	// PacmanExists indicates whether pacman is installed.
+	PacmanExists bool
+	// DebsumsExists indicates whether debsums is installed.
+	DebsumsExists bool
+	// FlatpakExists indicates whether flatpak is installed.
+	FlatpakExists bool
+	// BrewExists indicates whether Homebrew is installed.
+	BrewExists bool
+	// NpmExists indicates whether npm is installed.
+	NpmExists bool
+	// WingetExists indicates whether winget is installed.
+	WingetExists bool
+	// DnfExists indicates whether dnf is installed.
+	DnfExists bool
+	// PortageExists indicates whether Portage is installed.
+	PortageExists bool
 
 	noarch = osinfo.Architecture("noarch")
 
@@ -70,10 +93,18 @@ type Packages struct {
 	Deb                []*PkgInfo            `json:"deb,omitempty"`
 	Zypper             []*PkgInfo            `json:"zypper,omitempty"`
 	ZypperPatches      []*ZypperPatch        `json:"zypperPatches,omitempty"`
+	DnfModules         []*DnfModule          `json:"dnfModules,omitempty"`
 	COS                []*PkgInfo            `json:"cos,omitempty"`
 	Gem                []*PkgInfo            `json:"gem,omitempty"`
 	Pip                []*PkgInfo            `json:"pip,omitempty"`
 	GooGet             []*PkgInfo            `json:"googet,omitempty"`
+	Apk                []*PkgInfo            `json:"apk,omitempty"`
+	Pacman             []*PkgInfo            `json:"pacman,omitempty"`
+	Portage            []*PkgInfo            `json:"portage,omitempty"`
+	Flatpak            []*PkgInfo            `json:"flatpak,omitempty"`
+	Brew               []*PkgInfo            `json:"brew,omitempty"`
+	Npm                []*PkgInfo            `json:"npm,omitempty"`
+	Winget             []*PkgInfo            `json:"winget,omitempty"`
 	WUA                []*WUAPackage         `json:"wua,omitempty"`
 	QFE                []*QFEPackage         `json:"qfe,omitempty"`
 	WindowsApplication []*WindowsApplication `json:"-"`
@@ -84,6 +115,27 @@ type PkgInfo struct {
 	Name, Arch, RawArch, Version string
 
 	Source Source
+
+	// Held is set for packages the package manager will not upgrade or
+	// remove, such as apt packages marked with apt-mark hold.
+	Held bool `json:",omitempty"`
+	// HeldBack is why an available update will not be installed, such as
+	// AptHeldBackPin or AptHeldBackPhased. It is only set on updates.
+	HeldBack string `json:",omitempty"`
+
+	// ID identifies the package across reports, it is set by
+	// Packages.Normalize.
+	ID string `json:",omitempty"`
+
+	// InstallTime is when the package was installed or last upgraded, it
+	// is nil if the package manager does not record it.
+	InstallTime *time.Time `json:",omitempty"`
+	// Size is the installed size of the package in bytes, 0 if unknown.
+	Size int64 `json:",omitempty"`
+	// Repository is the repository the package was installed from, such as
+	// "updates" for dnf or "http://deb.debian.org/debian bookworm/main" for
+	// apt. It is empty if unknown or the package was installed from a file.
+	Repository string `json:",omitempty"`
 }
 
 // Source represents source package from which binary package was built.
@@ -100,6 +152,13 @@ type ZypperPatch struct {
 	Name, Category, Severity, Summary string
 }
 
+// DnfModule describes the state of a dnf module, Stream is the enabled
+// stream and State is enabled or disabled.
+type DnfModule struct {
+	Name, Stream, State string
+	Profiles            []string
+}
+
 // WUAPackage describes a Windows Update Agent package.
 type WUAPackage struct {
 	LastDeploymentChangeTime time.Time
@@ -112,11 +171,14 @@ type WUAPackage struct {
 	MoreInfoURLs             []string
 	CategoryIDs              []string
 	RevisionNumber           int32
+	ID                       string `json:",omitempty"`
 }
 
 // QFEPackage describes a Windows Quick Fix Engineering package.
 type QFEPackage struct {
 	Caption, Description, HotFixID, InstalledOn string
+
+	ID string `json:",omitempty"`
 }
 
 // WindowsApplication describes a Windows Application.
@@ -128,10 +190,26 @@ type WindowsApplication struct {
 	HelpLink       string
 }
 
+// Binaries returns the paths of the package manager binaries the agent may
+// run on this OS, whether or not they are installed.
+func Binaries() []string {
+	var bins []string
+	candidates := []string{aptGet, aptMark, dpkg, dpkgQuery, dpkgDeb, yum, zypper, transactionalUpdate, snapper, rpm, rpmquery, pip, googet, apk, pacman, checkupdates, debsums, flatpak, dnf, emerge}
+	candidates = append(candidates, brewPaths...)
+	candidates = append(candidates, npmPaths...)
+	candidates = append(candidates, gemPaths...)
+	for _, b := range candidates {
+		if filepath.IsAbs(b) {
+			bins = append(bins, b)
+		}
+	}
+	return bins
+}
+
 func run(ctx context.Context, cmd string, args []string) ([]byte, error) {
 	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, cmd, args...))
 	if err != nil {
-		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", cmd, args, err, stdout, stderr)
+		return nil, newCommandError(cmd, args, stdout, stderr, err)
 	}
 	return stdout, nil
 }
@@ -167,12 +245,18 @@ type packageMetadata struct {
 	Architecture  string `json:"architecture"`
 	Version       string `json:"version"`
 	Status        string `json:"status"`
+	Want          string `json:"want"`
 	SourceName    string `json:"source_name"`
 	SourceVersion string `json:"source_version"`
+	// InstallTime is in seconds since the epoch.
+	InstallTime string `json:"install_time"`
+	// Size is in bytes, InstalledSize in KiB as dpkg records it.
+	Size          string `json:"size"`
+	InstalledSize string `json:"installed_size"`
 }
 
 func pkgInfoFromPackageMetadata(pm packageMetadata) *PkgInfo {
-	return &PkgInfo{
+	pkg := &PkgInfo{
 		Name:    pm.Package,
 		Arch:    osinfo.Architecture(pm.Architecture),
 		Version: pm.Version,
@@ -181,6 +265,36 @@ func pkgInfoFromPackageMetadata(pm packageMetadata) *PkgInfo {
 			Version: pm.SourceVersion,
 		},
 	}
+	// Fields the package manager does not record are reported as "(none)"
+	// or left empty, they are skipped.
+	if sec := parseCount(pm.InstallTime); sec > 0 {
+		pkg.InstallTime = unixTime(sec)
+	}
+	if size := parseCount(pm.Size); size > 0 {
+		pkg.Size = size
+	} else if kib := parseCount(pm.InstalledSize); kib > 0 {
+		pkg.Size = kib * 1024
+	}
+	return pkg
+}
+
+// parseCount parses a non-negative decimal, it returns 0 for anything else.
+// Most packages leave some of these fields empty, checking first avoids
+// allocating a parse error for each of them.
+func parseCount(s string) int64 {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func unixTime(sec int64) *time.Time {
+	t := time.Unix(sec, 0).UTC()
+	return &t
 }
 
 type ptyRunner struct{}
@@ -202,3 +316,10 @@ func SetCommandRunner(commandRunner util.CommandRunner) {
 func SetPtyCommandRunner(commandRunner util.CommandRunner) {
 	ptyrunner = commandRunner
 }
+
+// WrapCommandRunners replaces the commandRunner and pty commandRunner with
+// the result of calling wrap on each.
+func WrapCommandRunners(wrap func(util.CommandRunner) util.CommandRunner) {
+	runner = wrap(runner)
+	ptyrunner = wrap(ptyrunner)
+}
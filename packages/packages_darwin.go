@@ -0,0 +1,111 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// Backends returns the package managers the agent supports on macOS and
+// whether each is installed.
+func Backends() map[string]bool {
+	return map[string]bool{
+		"brew": BrewExists,
+		"gem":  GemExists,
+		"pip":  PipExists,
+		"npm":  NpmExists,
+	}
+}
+
+// getPackageUpdates gets all available Homebrew, gem and pip updates.
+func getPackageUpdates(ctx context.Context) (*Packages, error) {
+	var providers []provider
+	if BrewExists {
+		providers = append(providers, provider{name: "brew", run: func(ctx context.Context, pkgs *Packages) error {
+			brew, err := BrewUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting brew updates: %v", err)
+			}
+			pkgs.Brew = brew
+			return nil
+		}})
+	}
+	if GemExists {
+		providers = append(providers, provider{name: "gem", logOnly: true, run: func(ctx context.Context, pkgs *Packages) error {
+			gem, err := GemUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting gem updates: %v", err)
+			}
+			pkgs.Gem = gem
+			return nil
+		}})
+	}
+	if PipExists {
+		providers = append(providers, provider{name: "pip", logOnly: true, run: func(ctx context.Context, pkgs *Packages) error {
+			pip, err := PipUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting pip updates: %v", err)
+			}
+			pkgs.Pip = pip
+			return nil
+		}})
+	}
+	return queryProviders(ctx, providers)
+}
+
+// getInstalledPackages gets all installed Homebrew formulae and casks and
+// gem and pip packages.
+func getInstalledPackages(ctx context.Context) (*Packages, error) {
+	var providers []provider
+	if BrewExists {
+		providers = append(providers, provider{name: "brew", run: func(ctx context.Context, pkgs *Packages) error {
+			brew, err := InstalledBrewPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed brew packages: %v", err)
+			}
+			pkgs.Brew = brew
+			return nil
+		}})
+	}
+	if GemExists {
+		providers = append(providers, provider{name: "gem", logOnly: true, run: func(ctx context.Context, pkgs *Packages) error {
+			gem, err := InstalledGemPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed gem packages: %v", err)
+			}
+			pkgs.Gem = gem
+			return nil
+		}})
+	}
+	if PipExists {
+		providers = append(providers, provider{name: "pip", logOnly: true, run: func(ctx context.Context, pkgs *Packages) error {
+			pip, err := InstalledPipPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed pip packages: %v", err)
+			}
+			pkgs.Pip = pip
+			return nil
+		}})
+	}
+	return queryProviders(ctx, providers)
+}
+
+// runWithPty is only needed to parse yum output, yum does not run on macOS.
+func runWithPty(_ *exec.Cmd) ([]byte, []byte, error) {
+	return nil, nil, errors.New("running commands with a pty is not supported on macOS")
+}
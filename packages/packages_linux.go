@@ -17,147 +17,259 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
-
-	"github.com/GoogleCloudPlatform/osconfig/clog"
 )
 
-// GetPackageUpdates gets all available package updates from any known
+// Backends returns the package managers the agent supports on Linux and
+// whether each is installed.
+func Backends() map[string]bool {
+	return map[string]bool{
+		"apt":     AptExists,
+		"dpkg":    DpkgExists,
+		"yum":     YumExists,
+		"dnf":     DnfExists,
+		"zypper":  ZypperExists,
+		"rpm":     RPMExists,
+		"cos":     COSPkgInfoExists,
+		"apk":     ApkExists,
+		"pacman":  PacmanExists,
+		"portage": PortageExists,
+		"flatpak": FlatpakExists,
+		"brew":    BrewExists,
+		"gem":     GemExists,
+		"pip":     PipExists,
+		"npm":     NpmExists,
+	}
+}
+
+// getPackageUpdates gets all available package updates from any known
 // installed package manager.
-func GetPackageUpdates(ctx context.Context) (*Packages, error) {
-	pkgs := Packages{}
-	var errs []string
+func getPackageUpdates(ctx context.Context) (*Packages, error) {
+	var providers []provider
 	if AptExists {
-		apt, err := AptUpdates(ctx, AptGetUpgradeType(AptGetFullUpgrade), AptGetUpgradeShowNew(false))
-		if err != nil {
-			msg := fmt.Sprintf("error getting apt updates: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
+		providers = append(providers, provider{name: "apt", run: func(ctx context.Context, pkgs *Packages) error {
+			apt, err := AptUpdates(ctx, AptGetUpgradeType(AptGetFullUpgrade), AptGetUpgradeShowNew(false))
+			if err != nil {
+				return fmt.Errorf("error getting apt updates: %v", err)
+			}
 			pkgs.Apt = apt
-		}
+			return nil
+		}})
 	}
 	if YumExists {
-		yum, err := YumUpdates(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error getting yum updates: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
+		providers = append(providers, provider{name: "yum", run: func(ctx context.Context, pkgs *Packages) error {
+			yum, err := YumUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting yum updates: %v", err)
+			}
 			pkgs.Yum = yum
-		}
+			return nil
+		}})
 	}
 	if ZypperExists {
-		zypper, err := ZypperUpdates(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error getting zypper updates: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
-			pkgs.Zypper = zypper
-		}
-		zypperPatches, err := ZypperPatches(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error getting zypper available patches: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
-			pkgs.ZypperPatches = zypperPatches
-		}
+		// Both queries take the zypp lock, so they share a provider.
+		providers = append(providers, provider{name: "zypper", run: func(ctx context.Context, pkgs *Packages) error {
+			var errs []error
+			if zypper, err := ZypperUpdates(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("error getting zypper updates: %v", err))
+			} else {
+				pkgs.Zypper = zypper
+			}
+			if zypperPatches, err := ZypperPatches(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("error getting zypper available patches: %v", err))
+			} else {
+				pkgs.ZypperPatches = zypperPatches
+			}
+			return errors.Join(errs...)
+		}})
+	}
+	if ApkExists {
+		providers = append(providers, provider{name: "apk", run: func(ctx context.Context, pkgs *Packages) error {
+			apk, err := ApkUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting apk updates: %v", err)
+			}
+			pkgs.Apk = apk
+			return nil
+		}})
+	}
+	if PacmanExists {
+		providers = append(providers, provider{name: "pacman", run: func(ctx context.Context, pkgs *Packages) error {
+			pacman, err := PacmanUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting pacman updates: %v", err)
+			}
+			pkgs.Pacman = pacman
+			return nil
+		}})
+	}
+	if PortageExists {
+		providers = append(providers, provider{name: "portage", run: func(ctx context.Context, pkgs *Packages) error {
+			portage, err := PortageUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting portage updates: %v", err)
+			}
+			pkgs.Portage = portage
+			return nil
+		}})
+	}
+	if BrewExists {
+		providers = append(providers, provider{name: "brew", run: func(ctx context.Context, pkgs *Packages) error {
+			brew, err := BrewUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting brew updates: %v", err)
+			}
+			pkgs.Brew = brew
+			return nil
+		}})
 	}
 	if GemExists {
-		gem, err := GemUpdates(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error getting gem updates: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-		} else {
+		providers = append(providers, provider{name: "gem", logOnly: true, run: func(ctx context.Context, pkgs *Packages) error {
+			gem, err := GemUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting gem updates: %v", err)
+			}
 			pkgs.Gem = gem
-		}
+			return nil
+		}})
 	}
 	if PipExists {
-		pip, err := PipUpdates(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error getting pip updates: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-		} else {
+		providers = append(providers, provider{name: "pip", logOnly: true, run: func(ctx context.Context, pkgs *Packages) error {
+			pip, err := PipUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting pip updates: %v", err)
+			}
 			pkgs.Pip = pip
-		}
+			return nil
+		}})
 	}
-
-	var err error
-	if len(errs) != 0 {
-		err = errors.New(strings.Join(errs, "\n"))
-	}
-	return &pkgs, err
+	return queryProviders(ctx, providers)
 }
 
-// GetInstalledPackages gets all installed packages from any known installed
+// getInstalledPackages gets all installed packages from any known installed
 // package manager.
-func GetInstalledPackages(ctx context.Context) (*Packages, error) {
-	pkgs := &Packages{}
-	var errs []string
+func getInstalledPackages(ctx context.Context) (*Packages, error) {
+	var providers []provider
 	if RPMQueryExists {
-		rpm, err := InstalledRPMPackages(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error listing installed rpm packages: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
+		providers = append(providers, provider{name: "rpm", run: func(ctx context.Context, pkgs *Packages) error {
+			rpm, err := InstalledRPMPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed rpm packages: %v", err)
+			}
 			pkgs.Rpm = rpm
-		}
+			return nil
+		}})
 	}
 	if ZypperExists {
-		zypperPatches, err := ZypperInstalledPatches(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error getting zypper installed patches: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
+		providers = append(providers, provider{name: "zypper", run: func(ctx context.Context, pkgs *Packages) error {
+			zypperPatches, err := ZypperInstalledPatches(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting zypper installed patches: %v", err)
+			}
 			pkgs.ZypperPatches = zypperPatches
-		}
+			return nil
+		}})
+	}
+	if DnfExists {
+		providers = append(providers, provider{name: "dnf", run: func(ctx context.Context, pkgs *Packages) error {
+			modules, err := DnfModuleStreams(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing dnf module streams: %v", err)
+			}
+			pkgs.DnfModules = modules
+			return nil
+		}})
 	}
 	if DpkgQueryExists {
-		deb, err := InstalledDebPackages(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error listing installed deb packages: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
+		providers = append(providers, provider{name: "deb", run: func(ctx context.Context, pkgs *Packages) error {
+			deb, err := InstalledDebPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed deb packages: %v", err)
+			}
 			pkgs.Deb = deb
-		}
+			return nil
+		}})
 	}
 	if COSPkgInfoExists {
-		cos, err := InstalledCOSPackages()
-		if err != nil {
-			msg := fmt.Sprintf("error listing installed COS packages: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
+		providers = append(providers, provider{name: "cos", run: func(ctx context.Context, pkgs *Packages) error {
+			cos, err := InstalledCOSPackages()
+			if err != nil {
+				return fmt.Errorf("error listing installed COS packages: %v", err)
+			}
 			pkgs.COS = cos
-		}
+			return nil
+		}})
+	}
+	if ApkExists {
+		providers = append(providers, provider{name: "apk", run: func(ctx context.Context, pkgs *Packages) error {
+			apk, err := InstalledApkPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed apk packages: %v", err)
+			}
+			pkgs.Apk = apk
+			return nil
+		}})
+	}
+	if PacmanExists {
+		providers = append(providers, provider{name: "pacman", run: func(ctx context.Context, pkgs *Packages) error {
+			pacman, err := InstalledPacmanPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed pacman packages: %v", err)
+			}
+			pkgs.Pacman = pacman
+			return nil
+		}})
+	}
+	if PortageExists {
+		providers = append(providers, provider{name: "portage", run: func(ctx context.Context, pkgs *Packages) error {
+			portage, err := InstalledPortagePackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed portage packages: %v", err)
+			}
+			pkgs.Portage = portage
+			return nil
+		}})
+	}
+	if FlatpakExists {
+		providers = append(providers, provider{name: "flatpak", run: func(ctx context.Context, pkgs *Packages) error {
+			flatpak, err := InstalledFlatpakPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed flatpak packages: %v", err)
+			}
+			pkgs.Flatpak = flatpak
+			return nil
+		}})
+	}
+	if BrewExists {
+		providers = append(providers, provider{name: "brew", run: func(ctx context.Context, pkgs *Packages) error {
+			brew, err := InstalledBrewPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed brew packages: %v", err)
+			}
+			pkgs.Brew = brew
+			return nil
+		}})
 	}
 	if GemExists {
-		gem, err := InstalledGemPackages(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error listing installed gem packages: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-		} else {
+		providers = append(providers, provider{name: "gem", logOnly: true, run: func(ctx context.Context, pkgs *Packages) error {
+			gem, err := InstalledGemPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed gem packages: %v", err)
+			}
 			pkgs.Gem = gem
-		}
+			return nil
+		}})
 	}
 	if PipExists {
-		pip, err := InstalledPipPackages(ctx)
-		if err != nil {
-			msg := fmt.Sprintf("error listing installed pip packages: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-		} else {
+		providers = append(providers, provider{name: "pip", logOnly: true, run: func(ctx context.Context, pkgs *Packages) error {
+			pip, err := InstalledPipPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed pip packages: %v", err)
+			}
 			pkgs.Pip = pip
-		}
-	}
-
-	var err error
-	if len(errs) != 0 {
-		err = errors.New(strings.Join(errs, "\n"))
+			return nil
+		}})
 	}
+	pkgs, err := queryProviders(ctx, providers)
+	addRepositories(ctx, pkgs)
 	return pkgs, err
 }
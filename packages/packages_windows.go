@@ -16,11 +16,9 @@ package packages
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/util"
@@ -39,16 +37,39 @@ func coInitializeEx() error {
 	return nil
 }
 
+// wuaUpdates searches WUA for each classification in opts, or every
+// classification if none are set, in parallel. Updates always belong to one
+// of the classifications so the merged result matches a single search, but
+// a search on an old image no longer waits on the slowest classification
+// before the next one starts.
+func wuaUpdates(ctx context.Context, query string, opts WUAQueryOptions) ([]*WUAPackage, error) {
+	classifications := opts.Classifications
+	if len(classifications) == 0 {
+		classifications = wuaClassifications
+	}
+	return searchWUAClassifications(ctx, classifications, func(ctx context.Context, c string) ([]*WUAPackage, error) {
+		o := opts
+		o.Classifications = []string{c}
+		return wuaUpdatesProcess(ctx, query, o)
+	})
+}
+
 // In order to work around memory issues with the WUA library we spawn a
-// new process for these inventory queries.
-func wuaUpdates(ctx context.Context, query string) ([]*WUAPackage, error) {
+// new process for these inventory queries. The options are passed to it as
+// JSON, it adds the classifications to the query and skips updates dropped
+// by the KB and severity filters before they are read.
+func wuaUpdatesProcess(ctx context.Context, query string, opts WUAQueryOptions) ([]*WUAPackage, error) {
 	exe, err := os.Executable()
 	if err != nil {
 		return nil, err
 	}
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	var wua []*WUAPackage
-	stdout, stderr, err := runner.Run(ctx, exec.Command(exe, "wuaupdates", query))
+	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, exe, "wuaupdates", query, string(data)))
 	if err != nil {
 		return nil, fmt.Errorf("error running agent to query for WUA updates, err: %v, stderr: %q ", err, stderr)
 	}
@@ -59,85 +80,106 @@ func wuaUpdates(ctx context.Context, query string) ([]*WUAPackage, error) {
 	return wua, nil
 }
 
-// GetPackageUpdates gets available package updates GooGet as well as any
-// available updates from Windows Update Agent.
-func GetPackageUpdates(ctx context.Context) (*Packages, error) {
-	var pkgs Packages
-	var errs []string
+// Backends returns the package managers the agent supports on Windows and
+// whether each is installed, Windows Update and QFE are always available.
+func Backends() map[string]bool {
+	return map[string]bool{
+		"googet": GooGetExists,
+		"msi":    MSIExists,
+		"winget": WingetExists,
+		"wua":    true,
+		"qfe":    true,
+		"msu":    util.Exists(wusa),
+		"cab":    util.Exists(dism),
+	}
+}
 
+// getPackageUpdates gets available package updates from GooGet and winget
+// as well as any available updates from Windows Update Agent.
+func getPackageUpdates(ctx context.Context) (*Packages, error) {
+	var providers []provider
 	if GooGetExists {
-		if googet, err := GooGetUpdates(ctx); err != nil {
-			msg := fmt.Sprintf("error listing googet updates: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
+		providers = append(providers, provider{name: "googet", run: func(ctx context.Context, pkgs *Packages) error {
+			googet, err := GooGetUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing googet updates: %v", err)
+			}
 			pkgs.GooGet = googet
-		}
-	}
-
-	clog.Debugf(ctx, "Searching for available WUA updates.")
-
-	if wua, err := wuaUpdates(ctx, "IsInstalled=0"); err != nil {
-		msg := fmt.Sprintf("error listing installed Windows updates: %v", err)
-		clog.Debugf(ctx, "Error: %s", msg)
-		errs = append(errs, msg)
-	} else {
-		pkgs.WUA = wua
+			return nil
+		}})
 	}
-
-	var err error
-	if len(errs) != 0 {
-		err = errors.New(strings.Join(errs, "\n"))
+	if WingetExists {
+		providers = append(providers, provider{name: "winget", run: func(ctx context.Context, pkgs *Packages) error {
+			winget, err := WingetUpdates(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing winget updates: %v", err)
+			}
+			pkgs.Winget = winget
+			return nil
+		}})
 	}
-	return &pkgs, err
+	providers = append(providers, provider{name: "wua", run: func(ctx context.Context, pkgs *Packages) error {
+		clog.Debugf(ctx, "Searching for available WUA updates.")
+		wua, err := wuaUpdates(ctx, "IsInstalled=0", WUAQueryOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing installed Windows updates: %v", err)
+		}
+		pkgs.WUA = wua
+		return nil
+	}})
+	return queryProviders(ctx, providers)
 }
 
-// GetInstalledPackages gets all installed GooGet packages and Windows updates.
+// getInstalledPackages gets all installed GooGet and winget packages and
+// Windows updates.
 // Windows updates are read from Windows Update Agent and Win32_QuickFixEngineering.
-func GetInstalledPackages(ctx context.Context) (*Packages, error) {
-	var pkgs Packages
-	var errs []string
-
+func getInstalledPackages(ctx context.Context) (*Packages, error) {
+	var providers []provider
 	if util.Exists(googet) {
-		if googet, err := InstalledGooGetPackages(ctx); err != nil {
-			msg := fmt.Sprintf("error listing installed googet packages: %v", err)
-			clog.Debugf(ctx, "Error: %s", msg)
-			errs = append(errs, msg)
-		} else {
+		providers = append(providers, provider{name: "googet", run: func(ctx context.Context, pkgs *Packages) error {
+			googet, err := InstalledGooGetPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed googet packages: %v", err)
+			}
 			pkgs.GooGet = googet
-		}
+			return nil
+		}})
 	}
-
-	clog.Debugf(ctx, "Searching for installed WUA updates.")
-
-	if wua, err := wuaUpdates(ctx, "IsInstalled=1"); err != nil {
-		msg := fmt.Sprintf("error listing installed Windows updates: %v", err)
-		clog.Debugf(ctx, "Error: %s", msg)
-		errs = append(errs, msg)
-	} else {
-		pkgs.WUA = wua
+	if WingetExists {
+		providers = append(providers, provider{name: "winget", run: func(ctx context.Context, pkgs *Packages) error {
+			winget, err := InstalledWingetPackages(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing installed winget packages: %v", err)
+			}
+			pkgs.Winget = winget
+			return nil
+		}})
 	}
-
-	if qfe, err := QuickFixEngineering(ctx); err != nil {
-		msg := fmt.Sprintf("error listing installed QuickFixEngineering updates: %v", err)
-		clog.Debugf(ctx, "Error: %s", msg)
-		errs = append(errs, msg)
-	} else {
+	providers = append(providers, provider{name: "wua", run: func(ctx context.Context, pkgs *Packages) error {
+		clog.Debugf(ctx, "Searching for installed WUA updates.")
+		wua, err := wuaUpdates(ctx, "IsInstalled=1", WUAQueryOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing installed Windows updates: %v", err)
+		}
+		pkgs.WUA = wua
+		return nil
+	}})
+	providers = append(providers, provider{name: "qfe", run: func(ctx context.Context, pkgs *Packages) error {
+		qfe, err := QuickFixEngineering(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing installed QuickFixEngineering updates: %v", err)
+		}
 		pkgs.QFE = qfe
-	}
-
-	clog.Debugf(ctx, "Listing Windows Applications.")
-	if windowsApplications, err := GetWindowsApplications(ctx); err != nil {
-		msg := fmt.Sprintf("error listing installed Windows Applications: %v", err)
-		clog.Debugf(ctx, "Error: %s", msg)
-		errs = append(errs, msg)
-	} else {
+		return nil
+	}})
+	providers = append(providers, provider{name: "apps", run: func(ctx context.Context, pkgs *Packages) error {
+		clog.Debugf(ctx, "Listing Windows Applications.")
+		windowsApplications, err := GetWindowsApplications(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing installed Windows Applications: %v", err)
+		}
 		pkgs.WindowsApplication = windowsApplications
-	}
-
-	var err error
-	if len(errs) != 0 {
-		err = errors.New(strings.Join(errs, "\n"))
-	}
-	return &pkgs, err
+		return nil
+	}})
+	return queryProviders(ctx, providers)
 }
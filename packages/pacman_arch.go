@@ -0,0 +1,219 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	pacman       string
+	checkupdates string
+
+	pacmanInstalledArgs  = []string{"--query", "--info"}
+	pacmanUpgradableArgs = []string{"--query", "--upgrades"}
+	pacmanInstallArgs    = []string{"--sync", "--refresh", "--needed", "--noconfirm"}
+	pacmanRemoveArgs     = []string{"--remove", "--noconfirm"}
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		pacman = "/usr/bin/pacman"
+		checkupdates = "/usr/bin/checkupdates"
+	}
+	PacmanExists = util.Exists(pacman)
+}
+
+// InstallPacmanPackages installs pacman packages, packages that are
+// already up to date are skipped. The sync databases are refreshed first so
+// packages are installed at the versions PacmanUpdates reports.
+func InstallPacmanPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, pacman, append(pacmanInstallArgs, pkgs...))
+	return err
+}
+
+// RemovePacmanPackages removes pacman packages.
+func RemovePacmanPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, pacman, append(pacmanRemoveArgs, pkgs...))
+	return err
+}
+
+// runPacmanQuery runs a query in the C locale, pacman translates the
+// field names of its output.
+func runPacmanQuery(ctx context.Context, cmd string, args []string) ([]byte, []byte, error) {
+	c := exec.CommandContext(ctx, cmd, args...)
+	c.Env = append(os.Environ(), "LC_ALL=C")
+	return runner.Run(ctx, c)
+}
+
+// parsePacmanInfo parses the output of pacman --query --info.
+func parsePacmanInfo(ctx context.Context, data []byte) []*PkgInfo {
+	/*
+	   Name            : bash
+	   Version         : 5.2.026-2
+	   Description     : The GNU Bourne Again shell
+	   Architecture    : x86_64
+	   ...
+
+	   Name            : glibc
+	   ...
+	*/
+	var pkgs []*PkgInfo
+	var pkg *PkgInfo
+	add := func() {
+		if pkg == nil {
+			return
+		}
+		if pkg.Name == "" || pkg.Version == "" {
+			clog.Debugf(ctx, "%v does not represent a pacman package", pkg)
+		} else {
+			if pkg.Source.Name == "" {
+				pkg.Source.Name = pkg.Name
+			}
+			pkgs = append(pkgs, pkg)
+		}
+		pkg = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		ln := scanner.Text()
+		if strings.TrimSpace(ln) == "" {
+			add()
+			continue
+		}
+		// Continuation lines of multi value fields are indented.
+		if strings.HasPrefix(ln, " ") {
+			continue
+		}
+		key, value, ok := strings.Cut(ln, ":")
+		if !ok {
+			continue
+		}
+		if pkg == nil {
+			pkg = &PkgInfo{}
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Name":
+			pkg.Name = value
+		case "Version":
+			pkg.Version = value
+		case "Architecture":
+			pkg.Arch = osinfo.Architecture(value)
+			pkg.RawArch = value
+		case "Base":
+			pkg.Source.Name = value
+		}
+	}
+	add()
+	return pkgs
+}
+
+// parsePacmanUpgrades parses the output of checkupdates or
+// pacman --query --upgrades, returning the new version of each package.
+func parsePacmanUpgrades(ctx context.Context, data []byte) map[string]string {
+	/*
+	   bash 5.2.026-2 -> 5.2.032-1
+	   linux 6.9.7.arch1-1 -> 6.10.arch1-1 [ignored]
+	*/
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+
+	upgrades := map[string]string{}
+	for _, ln := range lines {
+		fields := strings.Fields(string(ln))
+		if len(fields) < 4 || fields[2] != "->" {
+			clog.Debugf(ctx, "%q does not represent a pacman upgrade", ln)
+			continue
+		}
+		// Ignored packages are never upgraded.
+		if len(fields) > 4 && fields[4] == "[ignored]" {
+			continue
+		}
+		upgrades[fields[0]] = fields[3]
+	}
+	return upgrades
+}
+
+// InstalledPacmanPackages queries for all installed pacman packages.
+func InstalledPacmanPackages(ctx context.Context) ([]*PkgInfo, error) {
+	stdout, stderr, err := runPacmanQuery(ctx, pacman, pacmanInstalledArgs)
+	if err != nil {
+		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", pacman, pacmanInstalledArgs, err, stdout, stderr)
+	}
+	return parsePacmanInfo(ctx, stdout), nil
+}
+
+// PacmanUpdates queries for all available pacman updates. checkupdates,
+// from pacman-contrib, is used when present as it checks against fresh
+// package databases without syncing the system ones, which would leave the
+// system in a partially upgraded state. Otherwise updates are reported
+// against the last synced databases.
+func PacmanUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	cmd, args := pacman, pacmanUpgradableArgs
+	// checkupdates exits 2 and pacman exits 1 when there are no updates.
+	noUpdates := 1
+	if util.Exists(checkupdates) {
+		cmd, args, noUpdates = checkupdates, nil, 2
+	}
+	stdout, stderr, err := runPacmanQuery(ctx, cmd, args)
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == noUpdates && len(bytes.TrimSpace(stdout)) == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", cmd, args, err, stdout, stderr)
+	}
+	upgrades := parsePacmanUpgrades(ctx, stdout)
+	if len(upgrades) == 0 {
+		return nil, nil
+	}
+
+	// Neither command reports the architecture, take it from the installed
+	// package. An update that is already installed, for example one pacman
+	// --query --upgrades found before the install refreshed the databases,
+	// is left out so the result converges with the installed state.
+	installed, err := InstalledPacmanPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []*PkgInfo
+	for _, pkg := range installed {
+		ver, ok := upgrades[pkg.Name]
+		if !ok || ver == pkg.Version {
+			continue
+		}
+		pkgs = append(pkgs, &PkgInfo{
+			Name:    pkg.Name,
+			Arch:    pkg.Arch,
+			RawArch: pkg.RawArch,
+			Version: ver,
+			Source:  Source{Name: pkg.Source.Name},
+		})
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,191 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+var pacmanInfo = []byte(`Name            : bash
+Version         : 5.2.026-2
+Description     : The GNU Bourne Again shell
+Architecture    : x86_64
+Optional Deps   : bash-completion: for tab completion
+                  which: for type command
+Install Reason  : Installed as a dependency for another package
+
+Name            : linux-firmware-whence
+Version         : 20240703.9b6b0b4-1
+Base            : linux-firmware
+Architecture    : any
+`)
+
+func pacmanQueryCmd(cmd string, args ...string) *exec.Cmd {
+	c := exec.Command(cmd, args...)
+	c.Env = append(os.Environ(), "LC_ALL=C")
+	return c
+}
+
+func TestInstallPacmanPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(pacman, append(pacmanInstallArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := InstallPacmanPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("Could not install package")).Times(1)
+	if err := InstallPacmanPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestRemovePacmanPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(pacman, append(pacmanRemoveArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := RemovePacmanPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("Could not remove package")).Times(1)
+	if err := RemovePacmanPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestParsePacmanInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []*PkgInfo
+	}{
+		{
+			"Installed",
+			pacmanInfo,
+			[]*PkgInfo{
+				{Name: "bash", Arch: "x86_64", RawArch: "x86_64", Version: "5.2.026-2", Source: Source{Name: "bash"}},
+				{Name: "linux-firmware-whence", Arch: "any", RawArch: "any", Version: "20240703.9b6b0b4-1", Source: Source{Name: "linux-firmware"}},
+			},
+		},
+		{"NoPackages", []byte("nothing here"), nil},
+		{"nil", nil, nil},
+		{"NoVersion", []byte("Name : foo\nArchitecture : x86_64"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePacmanInfo(testCtx, tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePacmanInfo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePacmanUpgrades(t *testing.T) {
+	data := []byte("bash 5.2.026-2 -> 5.2.032-1\nlinux 6.9.7.arch1-1 -> 6.10.arch1-1 [ignored]\nnot an upgrade")
+	want := map[string]string{"bash": "5.2.032-1"}
+	if got := parsePacmanUpgrades(testCtx, data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePacmanUpgrades() = %v, want %v", got, want)
+	}
+}
+
+func TestPacmanUpdates(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	td, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	defer func(c string) { checkupdates = c }(checkupdates)
+
+	// linux-firmware-whence is already at the reported version.
+	upgrades := []byte("bash 5.2.026-2 -> 5.2.032-1\nlinux-firmware-whence 20240602.1-1 -> 20240703.9b6b0b4-1")
+	infoCmd := utilmocks.EqCmd(pacmanQueryCmd(pacman, pacmanInstalledArgs...))
+	want := []*PkgInfo{{Name: "bash", Arch: "x86_64", RawArch: "x86_64", Version: "5.2.032-1", Source: Source{Name: "bash"}}}
+
+	t.Run("Pacman", func(t *testing.T) {
+		checkupdates = filepath.Join(td, "missing")
+		upgradesCmd := utilmocks.EqCmd(pacmanQueryCmd(pacman, pacmanUpgradableArgs...))
+
+		first := mockCommandRunner.EXPECT().Run(testCtx, upgradesCmd).Return(upgrades, nil, nil).Times(1)
+		mockCommandRunner.EXPECT().Run(testCtx, infoCmd).After(first).Return(pacmanInfo, nil, nil).Times(1)
+		got, err := PacmanUpdates(testCtx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PacmanUpdates() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Checkupdates", func(t *testing.T) {
+		checkupdates = filepath.Join(td, "checkupdates")
+		if err := ioutil.WriteFile(checkupdates, nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+		upgradesCmd := utilmocks.EqCmd(pacmanQueryCmd(checkupdates))
+
+		first := mockCommandRunner.EXPECT().Run(testCtx, upgradesCmd).Return(upgrades, nil, nil).Times(1)
+		mockCommandRunner.EXPECT().Run(testCtx, infoCmd).After(first).Return(pacmanInfo, nil, nil).Times(1)
+		got, err := PacmanUpdates(testCtx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PacmanUpdates() = %v, want %v", got, want)
+		}
+
+		// checkupdates exits 2 when there are no updates.
+		errExit2 := exec.Command("/bin/bash", "-c", "exit 2").Run()
+		mockCommandRunner.EXPECT().Run(testCtx, upgradesCmd).Return(nil, nil, errExit2).Times(1)
+		got, err = PacmanUpdates(testCtx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != nil {
+			t.Errorf("PacmanUpdates() = %v, want nil", got)
+		}
+
+		errExit1 := exec.Command("/bin/bash", "-c", "exit 1").Run()
+		mockCommandRunner.EXPECT().Run(testCtx, upgradesCmd).Return(nil, []byte("stderr"), errExit1).Times(1)
+		if _, err := PacmanUpdates(testCtx); err == nil {
+			t.Errorf("did not get expected error")
+		}
+	})
+}
@@ -0,0 +1,225 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	emerge string
+
+	// portageDBDir is the Portage database of installed packages, one
+	// category/package-version directory per package.
+	portageDBDir = "/var/db/pkg"
+
+	// --verbose adds the slot to the atoms in the merge list.
+	portagePretendArgs  = []string{"--ask=n", "--color=n", "--nospinner", "--verbose", "--pretend", "--update", "--deep", "--newuse", "@world"}
+	portageInstallArgs  = []string{"--ask=n", "--color=n", "--nospinner", "--quiet-build=y", "--update"}
+	portageDeselectArgs = []string{"--ask=n", "--color=n", "--nospinner", "--deselect"}
+	portageDepcleanArgs = []string{"--ask=n", "--color=n", "--nospinner", "--depclean"}
+
+	// Matches the version suffix of a Portage package, including its
+	// revision, e.g. "-1.2.3_rc1-r2".
+	portageVersionRE = regexp.MustCompile(`-(\d+(\.\d+)*[a-z]?(_(alpha|beta|pre|rc|p)\d*)*(-r\d+)?)$`)
+	// Matches the merge list lines of emerge --pretend, e.g.
+	// "[ebuild     U  ] sys-apps/portage-3.0.63-r1:0::gentoo [3.0.57:0::gentoo] USE=...".
+	portageMergeRE = regexp.MustCompile(`^\[(?:ebuild|binary)\s+([^\]]*)\]\s+(\S+)`)
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		emerge = "/usr/bin/emerge"
+	}
+	PortageExists = util.Exists(emerge)
+}
+
+// InstallPortagePackages installs Portage packages, packages that are
+// already up to date are skipped.
+func InstallPortagePackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, emerge, append(portageInstallArgs, pkgs...))
+	return err
+}
+
+// RemovePortagePackages removes Portage packages the way Portage does it,
+// they are removed from the world set and then depcleaned, so packages
+// other packages still depend on are kept.
+func RemovePortagePackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	if _, err := run(ctx, emerge, append(portageDeselectArgs, pkgs...)); err != nil {
+		return err
+	}
+	_, err := run(ctx, emerge, append(portageDepcleanArgs, pkgs...))
+	return err
+}
+
+// portageName returns the name a package in slot is reported with, packages
+// outside the default slot 0 are reported as category/package:slot atoms so
+// that slots installed side by side stay apart. A sub-slot is dropped.
+func portageName(name, slot string) string {
+	slot, _, _ = strings.Cut(strings.TrimSpace(slot), "/")
+	if slot == "" || slot == "0" {
+		return name
+	}
+	return name + ":" + slot
+}
+
+// splitPortageAtom splits a category/package-version atom, with an optional
+// :slot and ::repository suffix, into the category/package name, slot and
+// version.
+func splitPortageAtom(atom string) (string, string, string, bool) {
+	atom, _, _ = strings.Cut(atom, "::")
+	atom, slot, _ := strings.Cut(atom, ":")
+	m := portageVersionRE.FindStringSubmatchIndex(atom)
+	if m == nil || !strings.Contains(atom[:m[0]], "/") {
+		return "", "", "", false
+	}
+	return atom[:m[0]], slot, atom[m[2]:m[3]], true
+}
+
+// portageArch returns the architecture from a CHOST such as
+// x86_64-pc-linux-gnu.
+func portageArch(chost string) (string, string) {
+	raw, _, _ := strings.Cut(strings.TrimSpace(chost), "-")
+	if raw == "" {
+		return "", ""
+	}
+	return osinfo.Architecture(raw), raw
+}
+
+// readPortageDB lists the packages in the Portage database at dir.
+func readPortageDB(ctx context.Context, dir string) ([]*PkgInfo, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	entries, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []*PkgInfo
+	for _, e := range entries {
+		// Merges in progress are staged in -MERGING- directories.
+		if strings.HasPrefix(filepath.Base(e), "-MERGING-") {
+			continue
+		}
+		atom := filepath.Base(filepath.Dir(e)) + "/" + filepath.Base(e)
+		name, _, version, ok := splitPortageAtom(atom)
+		if !ok {
+			clog.Debugf(ctx, "%q does not represent a Portage package", atom)
+			continue
+		}
+		// The SLOT file is missing only for packages merged before slots.
+		slot, _ := os.ReadFile(filepath.Join(e, "SLOT"))
+		pkg := &PkgInfo{Name: portageName(name, string(slot)), Version: version, Source: Source{Name: name}}
+		if chost, err := os.ReadFile(filepath.Join(e, "CHOST")); err == nil {
+			pkg.Arch, pkg.RawArch = portageArch(string(chost))
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// parsePortagePretend parses the merge list of emerge --pretend, returning
+// the new version of each installed package that would be updated keyed by
+// the name the package is reported with.
+func parsePortagePretend(ctx context.Context, data []byte) map[string]string {
+	/*
+	   These are the packages that would be merged, in order:
+
+	   Calculating dependencies... done!
+	   [ebuild     U  ] sys-apps/portage-3.0.63-r1:0::gentoo [3.0.57:0::gentoo] USE="..." 0 KiB
+	   [ebuild     U  ] dev-lang/python-3.12.4:3.12::gentoo [3.12.3:3.12::gentoo] USE="..." 0 KiB
+	   [ebuild  N     ] dev-libs/libfoo-1.0:0::gentoo  0 KiB
+	   [ebuild   R    ] app-editors/vim-9.1.0:0::gentoo  USE="-X%" 0 KiB
+	*/
+	upgrades := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		m := portageMergeRE.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		// New installs and rebuilds are not updates of an installed package.
+		if !strings.Contains(m[1], "U") {
+			continue
+		}
+		name, slot, version, ok := splitPortageAtom(m[2])
+		if !ok {
+			clog.Debugf(ctx, "%q does not represent a Portage package", m[2])
+			continue
+		}
+		upgrades[portageName(name, slot)] = version
+	}
+	return upgrades
+}
+
+// InstalledPortagePackages lists the installed Portage packages, names are
+// category/package atoms with the slot of packages outside slot 0.
+func InstalledPortagePackages(ctx context.Context) ([]*PkgInfo, error) {
+	pkgs, err := readPortageDB(ctx, portageDBDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Portage database %s: %v", portageDBDir, err)
+	}
+	return pkgs, nil
+}
+
+// PortageUpdates queries for the Portage packages emerge would update with
+// emerge --update --deep --newuse @world. The package tree is not synced.
+func PortageUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	stdout, err := run(ctx, emerge, portagePretendArgs)
+	if err != nil {
+		return nil, err
+	}
+	upgrades := parsePortagePretend(ctx, stdout)
+	if len(upgrades) == 0 {
+		return nil, nil
+	}
+
+	// The merge list does not include the architecture, take it from the
+	// installed package.
+	installed, err := InstalledPortagePackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []*PkgInfo
+	for _, pkg := range installed {
+		ver, ok := upgrades[pkg.Name]
+		if !ok {
+			continue
+		}
+		pkgs = append(pkgs, &PkgInfo{
+			Name:    pkg.Name,
+			Arch:    pkg.Arch,
+			RawArch: pkg.RawArch,
+			Version: ver,
+			Source:  Source{Name: pkg.Source.Name},
+		})
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,205 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+var portagePretend = []byte(`
+These are the packages that would be merged, in order:
+
+Calculating dependencies... done!
+[ebuild     U  ] sys-apps/portage-3.0.63-r1:0::gentoo [3.0.57:0::gentoo] USE="(ipc) native-extensions" 1,123 KiB
+[ebuild     U  ] dev-lang/python-3.11.9:3.11::gentoo [3.11.8:3.11::gentoo] USE="ssl" 19,614 KiB
+[ebuild  N     ] dev-libs/libfoo-1.0:0/1::gentoo  0 KiB
+[ebuild   R    ] app-editors/vim-9.1.0:0::gentoo  USE="-X%" 0 KiB
+[binary     U  ] app-shells/bash-5.2_p26:0::gentoo [5.2_p21-r1:0::gentoo] 1,700 KiB
+
+Total: 5 packages (3 upgrades, 1 new, 1 reinstall), Size of downloads: 2,823 KiB
+`)
+
+func writePortageDB(t *testing.T) string {
+	dir := t.TempDir()
+	for atom, files := range map[string]map[string]string{
+		"app-shells/bash-5.2_p21-r1":  {"CHOST": "x86_64-pc-linux-gnu\n", "SLOT": "0\n"},
+		"sys-apps/portage-3.0.57":     {"CHOST": "x86_64-pc-linux-gnu\n"},
+		"dev-lang/python-3.11.8":      {"CHOST": "x86_64-pc-linux-gnu\n", "SLOT": "3.11/3.11\n"},
+		"dev-lang/python-3.12.3":      {"SLOT": "3.12/3.12\n"},
+		"sys-apps/-MERGING-coreutils": {},
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(atom))
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range files {
+			if err := os.WriteFile(filepath.Join(p, name), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func TestInstallPortagePackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(emerge, append(portageInstallArgs, pkgs...)...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := InstallPortagePackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("error")).Times(1)
+	if err := InstallPortagePackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestRemovePortagePackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	deselectCmd := utilmocks.EqCmd(exec.Command(emerge, append(portageDeselectArgs, pkgs...)...))
+	depcleanCmd := utilmocks.EqCmd(exec.Command(emerge, append(portageDepcleanArgs, pkgs...)...))
+
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(testCtx, deselectCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1),
+		mockCommandRunner.EXPECT().Run(testCtx, depcleanCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1),
+	)
+	if err := RemovePortagePackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Nothing is depcleaned when the packages could not be deselected.
+	mockCommandRunner.EXPECT().Run(testCtx, deselectCmd).Return(nil, []byte("stderr"), errors.New("error")).Times(1)
+	if err := RemovePortagePackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestSplitPortageAtom(t *testing.T) {
+	tests := []struct {
+		atom, name, slot, version string
+		ok                        bool
+	}{
+		{"sys-apps/portage-3.0.63-r1::gentoo", "sys-apps/portage", "", "3.0.63-r1", true},
+		{"sys-apps/portage-3.0.63-r1:0::gentoo", "sys-apps/portage", "0", "3.0.63-r1", true},
+		{"dev-lang/python-3.12.4:3.12/3.12::gentoo", "dev-lang/python", "3.12/3.12", "3.12.4", true},
+		{"app-shells/bash-5.2_p26", "app-shells/bash", "", "5.2_p26", true},
+		{"dev-libs/libpcre2-10.43", "dev-libs/libpcre2", "", "10.43", true},
+		{"x11-libs/gtk+-3.24.41-r1", "x11-libs/gtk+", "", "3.24.41-r1", true},
+		{"media-libs/libsdl2-2.30.3_rc1_p2", "media-libs/libsdl2", "", "2.30.3_rc1_p2", true},
+		{"sys-kernel/gentoo-sources-6.6.30", "sys-kernel/gentoo-sources", "", "6.6.30", true},
+		{"dev-vcs/git-9999", "dev-vcs/git", "", "9999", true},
+		{"portage-3.0.63", "", "", "", false},
+		{"sys-apps/portage", "", "", "", false},
+	}
+	for _, tt := range tests {
+		name, slot, version, ok := splitPortageAtom(tt.atom)
+		if name != tt.name || slot != tt.slot || version != tt.version || ok != tt.ok {
+			t.Errorf("splitPortageAtom(%q) = (%q, %q, %q, %v), want (%q, %q, %q, %v)", tt.atom, name, slot, version, ok, tt.name, tt.slot, tt.version, tt.ok)
+		}
+	}
+}
+
+func TestPortageName(t *testing.T) {
+	for _, tt := range []struct{ slot, want string }{
+		{"", "dev-lang/python"},
+		{"0\n", "dev-lang/python"},
+		{"0/1.2", "dev-lang/python"},
+		{"3.12/3.12\n", "dev-lang/python:3.12"},
+	} {
+		if got := portageName("dev-lang/python", tt.slot); got != tt.want {
+			t.Errorf("portageName(%q) = %q, want %q", tt.slot, got, tt.want)
+		}
+	}
+}
+
+func TestParsePortagePretend(t *testing.T) {
+	want := map[string]string{"sys-apps/portage": "3.0.63-r1", "dev-lang/python:3.11": "3.11.9", "app-shells/bash": "5.2_p26"}
+	if got := parsePortagePretend(testCtx, portagePretend); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePortagePretend() = %v, want %v", got, want)
+	}
+}
+
+func TestInstalledPortagePackages(t *testing.T) {
+	defer func(d string) { portageDBDir = d }(portageDBDir)
+	portageDBDir = writePortageDB(t)
+
+	got, err := InstalledPortagePackages(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*PkgInfo{
+		{Name: "app-shells/bash", Arch: "x86_64", RawArch: "x86_64", Version: "5.2_p21-r1", Source: Source{Name: "app-shells/bash"}},
+		{Name: "dev-lang/python:3.11", Arch: "x86_64", RawArch: "x86_64", Version: "3.11.8", Source: Source{Name: "dev-lang/python"}},
+		{Name: "dev-lang/python:3.12", Version: "3.12.3", Source: Source{Name: "dev-lang/python"}},
+		{Name: "sys-apps/portage", Arch: "x86_64", RawArch: "x86_64", Version: "3.0.57", Source: Source{Name: "sys-apps/portage"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InstalledPortagePackages() = %v, want %v", got, want)
+	}
+
+	portageDBDir = filepath.Join(t.TempDir(), "missing")
+	if _, err := InstalledPortagePackages(testCtx); err == nil {
+		t.Errorf("InstalledPortagePackages() with a missing database did not return an error")
+	}
+}
+
+func TestPortageUpdates(t *testing.T) {
+	defer func(d string) { portageDBDir = d }(portageDBDir)
+	portageDBDir = writePortageDB(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(emerge, portagePretendArgs...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(portagePretend, nil, nil).Times(1)
+	got, err := PortageUpdates(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*PkgInfo{
+		{Name: "app-shells/bash", Arch: "x86_64", RawArch: "x86_64", Version: "5.2_p26", Source: Source{Name: "app-shells/bash"}},
+		{Name: "dev-lang/python:3.11", Arch: "x86_64", RawArch: "x86_64", Version: "3.11.9", Source: Source{Name: "dev-lang/python"}},
+		{Name: "sys-apps/portage", Arch: "x86_64", RawArch: "x86_64", Version: "3.0.63-r1", Source: Source{Name: "sys-apps/portage"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PortageUpdates() = %v, want %v", got, want)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, []byte("emerge: there are no ebuilds to satisfy"), errors.New("exit status 1")).Times(1)
+	if _, err := PortageUpdates(testCtx); err == nil {
+		t.Errorf("PortageUpdates() did not return the emerge error")
+	}
+}
@@ -0,0 +1,79 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/progress"
+)
+
+// operation is a package manager command whose progress is tracked and
+// whose phases are bound by their operation timeouts.
+type operation struct {
+	tracker  *progress.Tracker
+	watchdog *phaseWatchdog
+	w        io.Writer
+}
+
+// startOperation starts tracking an operation of manager on pkgs, commands
+// must be created with the returned context and modified by setOutput so
+// their output is fed through parse.
+func startOperation(ctx context.Context, manager string, pkgs []string, parse progress.Parser) (context.Context, *operation) {
+	ctx, watchdog := newPhaseWatchdog(ctx, manager)
+	op := &operation{
+		tracker:  progress.Start(ctx, manager, strings.Join(pkgs, " ")),
+		watchdog: watchdog,
+	}
+	op.w = op.tracker.Writer(func(line string) (float64, string, bool) {
+		percent, phase, ok := parse(line)
+		if ok {
+			watchdog.phase(phase)
+		}
+		return percent, phase, ok
+	})
+	return ctx, op
+}
+
+// setOutput is a cmdModifier sending the output of cmd to the operation.
+func (o *operation) setOutput(cmd *exec.Cmd) {
+	cmd.Stdout = o.w
+}
+
+// done ends the operation, err is annotated if a phase timed out.
+func (o *operation) done(err error) error {
+	o.tracker.Done()
+	return o.watchdog.stop(err)
+}
+
+// runWithProgress is like run for commands operating on pkgs, their
+// progress is tracked while they run.
+func runWithProgress(ctx context.Context, cmd string, args, pkgs []string, parse progress.Parser) (_ []byte, err error) {
+	ctx, op := startOperation(ctx, filepath.Base(cmd), pkgs, parse)
+	defer func() { err = op.done(err) }()
+	args = append(slices.Clone(args), pkgs...)
+	c := exec.CommandContext(ctx, cmd, args...)
+	op.setOutput(c)
+	stdout, stderr, err := runner.Run(ctx, c)
+	if err != nil {
+		return nil, newCommandError(cmd, args, stdout, stderr, err)
+	}
+	return stdout, nil
+}
@@ -0,0 +1,104 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/crashreport"
+)
+
+var (
+	// queryConcurrency is the max number of package managers queried at
+	// once, set with SetQueryConcurrency.
+	queryConcurrency int32 = 4
+	// defaultQueryTimeout bounds the query of a package manager that has no
+	// resolve timeout set with SetOperationTimeouts.
+	defaultQueryTimeout = time.Hour
+)
+
+// SetQueryConcurrency sets how many package managers are queried at once
+// when listing installed packages and available updates, values below 1
+// query them one at a time.
+func SetQueryConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	atomic.StoreInt32(&queryConcurrency, int32(n))
+}
+
+// provider queries a single package manager.
+type provider struct {
+	// name is the manager whose resolve timeout bounds the query.
+	name string
+	// run stores the packages it finds in pkgs. Providers run concurrently
+	// so each must only set its own fields.
+	run func(ctx context.Context, pkgs *Packages) error
+	// logOnly providers only log their errors, they are left out of the
+	// returned error.
+	logOnly bool
+}
+
+// queryProviders runs the providers, up to queryConcurrency at a time, so
+// that independent package managers are queried in parallel. Errors are
+// returned in the order of providers.
+func queryProviders(ctx context.Context, providers []provider) (*Packages, error) {
+	pkgs := &Packages{}
+	errs := make([]error, len(providers))
+
+	sem := make(chan struct{}, atomic.LoadInt32(&queryConcurrency))
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		wg.Add(1)
+		go func(i int, p provider) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			defer crashreport.Recover(ctx, p.name+" package query")
+
+			timeout := operationTimeout(p.name, OpResolve)
+			if timeout <= 0 {
+				timeout = defaultQueryTimeout
+			}
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			errs[i] = p.run(ctx, pkgs)
+			clog.Debugf(ctx, "Queried %s packages in %s.", p.name, time.Since(start).Round(time.Millisecond))
+		}(i, p)
+	}
+	wg.Wait()
+
+	var msgs []string
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		clog.Debugf(ctx, "Error: %s", err)
+		if !providers[i].logOnly {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) != 0 {
+		return pkgs, errors.New(strings.Join(msgs, "\n"))
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,79 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueryProviders(t *testing.T) {
+	defer SetQueryConcurrency(int(atomic.LoadInt32(&queryConcurrency)))
+	SetQueryConcurrency(2)
+	SetOperationTimeouts(map[string]time.Duration{"slow.resolve": 10 * time.Millisecond})
+	defer SetOperationTimeouts(nil)
+
+	var running, peak int32
+	track := func() func() {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return func() { atomic.AddInt32(&running, -1) }
+	}
+
+	providers := []provider{
+		{name: "apt", run: func(ctx context.Context, pkgs *Packages) error {
+			defer track()()
+			pkgs.Apt = []*PkgInfo{{Name: "bash"}}
+			return nil
+		}},
+		{name: "slow", run: func(ctx context.Context, pkgs *Packages) error {
+			defer track()()
+			<-ctx.Done()
+			return errors.New("error getting slow updates: " + ctx.Err().Error())
+		}},
+		{name: "pip", logOnly: true, run: func(ctx context.Context, pkgs *Packages) error {
+			defer track()()
+			return errors.New("error getting pip updates")
+		}},
+		{name: "gem", run: func(ctx context.Context, pkgs *Packages) error {
+			defer track()()
+			return errors.New("error getting gem updates")
+		}},
+	}
+	pkgs, err := queryProviders(context.Background(), providers)
+	if len(pkgs.Apt) != 1 {
+		t.Errorf("Apt = %v, want the apt provider's packages", pkgs.Apt)
+	}
+	want := "error getting slow updates: context deadline exceeded\nerror getting gem updates"
+	if err == nil || err.Error() != want {
+		t.Errorf("queryProviders() error = %v, want %q", err, want)
+	}
+	if peak != 2 {
+		t.Errorf("peak concurrency = %d, want 2", peak)
+	}
+
+	if _, err := queryProviders(context.Background(), providers[:1]); err != nil {
+		t.Errorf("queryProviders() with no failures error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,129 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// Inventory, OS policy checks and patch pre-checks often list installed
+// packages and available updates within seconds of each other. The results
+// of GetInstalledPackages and GetPackageUpdates are reused for the query
+// cache TTL, any function in this package that changes packages
+// invalidates them.
+
+var (
+	queryCacheMx  sync.Mutex
+	queryCacheTTL = 2 * time.Minute
+	// queryCacheGen is incremented on every invalidation so a query that
+	// was running while packages changed is not cached.
+	queryCacheGen  uint64
+	installedCache cachedQuery
+	updatesCache   cachedQuery
+
+	cacheNow = time.Now
+)
+
+type cachedQuery struct {
+	pkgs *Packages
+	at   time.Time
+	gen  uint64
+}
+
+// SetQueryCacheTTL sets how long query results are reused, zero disables
+// caching.
+func SetQueryCacheTTL(ttl time.Duration) {
+	queryCacheMx.Lock()
+	defer queryCacheMx.Unlock()
+	queryCacheTTL = ttl
+}
+
+// InvalidateQueryCache drops cached query results. Functions in this
+// package that change packages call it, callers that change packages by
+// other means, such as running scripts, should too.
+func InvalidateQueryCache() {
+	queryCacheMx.Lock()
+	defer queryCacheMx.Unlock()
+	queryCacheGen++
+	installedCache, updatesCache = cachedQuery{}, cachedQuery{}
+}
+
+// cached returns a copy of the result of query from c, running query if
+// there is no current result. Results with errors are not cached.
+func cached(ctx context.Context, c *cachedQuery, name string, query func(context.Context) (*Packages, error)) (*Packages, error) {
+	queryCacheMx.Lock()
+	if queryCacheTTL > 0 && c.pkgs != nil && c.gen == queryCacheGen && cacheNow().Sub(c.at) < queryCacheTTL {
+		pkgs, at := c.pkgs.clone(), c.at
+		queryCacheMx.Unlock()
+		clog.Debugf(ctx, "Using %s cached at %s.", name, at.Format(time.RFC3339))
+		return pkgs, nil
+	}
+	gen := queryCacheGen
+	queryCacheMx.Unlock()
+
+	pkgs, err := query(ctx)
+	if err != nil || pkgs == nil {
+		return pkgs, err
+	}
+	queryCacheMx.Lock()
+	defer queryCacheMx.Unlock()
+	if queryCacheTTL > 0 && gen == queryCacheGen {
+		*c = cachedQuery{pkgs: pkgs, at: cacheNow(), gen: gen}
+		return pkgs.clone(), nil
+	}
+	return pkgs, nil
+}
+
+// clone returns a copy of p, the packages are copied as callers such as
+// Normalize modify them.
+func (p *Packages) clone() *Packages {
+	c := *p
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() != reflect.Slice || f.IsNil() {
+			continue
+		}
+		s := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+		for j := 0; j < f.Len(); j++ {
+			e := f.Index(j)
+			if e.Kind() == reflect.Ptr && !e.IsNil() {
+				n := reflect.New(e.Type().Elem())
+				n.Elem().Set(e.Elem())
+				e = n
+			}
+			s.Index(j).Set(e)
+		}
+		f.Set(s)
+	}
+	return &c
+}
+
+// GetInstalledPackages gets all installed packages from any known installed
+// package manager.
+func GetInstalledPackages(ctx context.Context) (*Packages, error) {
+	return cached(ctx, &installedCache, "installed packages", getInstalledPackages)
+}
+
+// GetPackageUpdates gets all available package updates from any known
+// installed package manager.
+func GetPackageUpdates(ctx context.Context) (*Packages, error) {
+	return cached(ctx, &updatesCache, "package updates", getPackageUpdates)
+}
@@ -0,0 +1,106 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryCache(t *testing.T) {
+	oldTTL, oldNow := queryCacheTTL, cacheNow
+	defer func() { queryCacheTTL, cacheNow = oldTTL, oldNow; InvalidateQueryCache() }()
+	InvalidateQueryCache()
+	queryCacheTTL = time.Minute
+	now := time.Now()
+	cacheNow = func() time.Time { return now }
+
+	var c cachedQuery
+	calls := 0
+	var queryErr error
+	query := func(context.Context) (*Packages, error) {
+		calls++
+		return &Packages{Deb: []*PkgInfo{{Name: "git", Version: "1"}}}, queryErr
+	}
+	want := &Packages{Deb: []*PkgInfo{{Name: "git", Version: "1"}}}
+
+	got, err := cached(testCtx, &c, "test", query)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("cached() = %v, %v, want %v, nil", got, err, want)
+	}
+	// Callers may modify the result without changing the cache.
+	got.Deb[0].ID = "changed"
+	got.Deb = nil
+
+	now = now.Add(30 * time.Second)
+	got, err = cached(testCtx, &c, "test", query)
+	if err != nil || !reflect.DeepEqual(got, want) || calls != 1 {
+		t.Errorf("cached() within the TTL = %v, %v after %d queries, want %v, nil after 1", got, err, calls, want)
+	}
+
+	now = now.Add(time.Minute)
+	cached(testCtx, &c, "test", query)
+	if calls != 2 {
+		t.Errorf("cached() after the TTL ran %d queries, want 2", calls)
+	}
+
+	InvalidateQueryCache()
+	cached(testCtx, &installedCache, "test", query)
+	cached(testCtx, &installedCache, "test", query)
+	if calls != 3 {
+		t.Errorf("cached() after InvalidateQueryCache ran %d queries, want 3", calls)
+	}
+
+	// Errors are not cached.
+	InvalidateQueryCache()
+	queryErr = errors.New("error")
+	cached(testCtx, &installedCache, "test", query)
+	if _, err := cached(testCtx, &installedCache, "test", query); err == nil || calls != 5 {
+		t.Errorf("cached() with an error ran %d queries and returned %v, want 5 and an error", calls, err)
+	}
+
+	// A zero TTL disables caching.
+	queryErr = nil
+	SetQueryCacheTTL(0)
+	cached(testCtx, &installedCache, "test", query)
+	cached(testCtx, &installedCache, "test", query)
+	if calls != 7 {
+		t.Errorf("cached() with caching disabled ran %d queries, want 7", calls)
+	}
+}
+
+func TestQueryCacheInvalidatedDuringQuery(t *testing.T) {
+	oldTTL := queryCacheTTL
+	defer func() { queryCacheTTL = oldTTL; InvalidateQueryCache() }()
+	InvalidateQueryCache()
+	queryCacheTTL = time.Minute
+
+	var c cachedQuery
+	calls := 0
+	query := func(context.Context) (*Packages, error) {
+		calls++
+		// Packages change while the query runs.
+		InvalidateQueryCache()
+		return &Packages{}, nil
+	}
+	cached(testCtx, &c, "test", query)
+	cached(testCtx, &c, "test", query)
+	if calls != 2 {
+		t.Errorf("cached() ran %d queries, want a result from before an invalidation not to be cached", calls)
+	}
+}
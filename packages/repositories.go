@@ -0,0 +1,339 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// RepositoryLookupEnv enables SetRepositoryLookup in child processes of the
+// agent, such as the inventory worker, that do not load the agent config.
+const RepositoryLookupEnv = "OSCONFIG_PACKAGE_REPOSITORIES"
+
+var (
+	aptCache string
+	yumdb    string
+
+	aptCachePolicyArgs = []string{"policy"}
+	// Repositories are disabled so dnf neither refreshes nor loads their
+	// metadata, which fails for users other than root. Installed packages
+	// record the repository they came from.
+	dnfRepoqueryInstalledArgs = []string{"repoquery", "--installed", "--cacheonly", "--disablerepo=*", "--quiet", "--queryformat", "%{name}-%{evr}|%{from_repo}\n"}
+	yumdbFromRepoArgs         = []string{"get", "from_repo"}
+	zypperSearchInstalledArgs = []string{"--quiet", "--non-interactive", "--no-refresh", "search", "--installed-only", "--details", "--type", "package"}
+
+	repositoryLookup atomic.Bool
+
+	// repoCache holds the repositories looked up so far, keyed by
+	// repoCacheKey, "" for packages that did not come from a repository.
+	// The repository of an installed package version does not change, so
+	// lookups only run when packages that are not cached are installed.
+	repoCache   = map[string]string{}
+	repoCacheMx sync.Mutex
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		aptCache = "/usr/bin/apt-cache"
+		yumdb = "/usr/bin/yumdb"
+	}
+	repositoryLookup.Store(os.Getenv(RepositoryLookupEnv) == "1")
+}
+
+// SetRepositoryLookup sets whether the repository installed deb and rpm
+// packages came from is looked up, the lookup runs another package manager
+// command after the installed packages are listed.
+func SetRepositoryLookup(enabled bool) {
+	repositoryLookup.Store(enabled)
+}
+
+// RepositoryLookup reports whether the repository of installed packages is
+// looked up.
+func RepositoryLookup() bool {
+	return repositoryLookup.Load()
+}
+
+// addRepositories sets the repository installed deb and rpm packages came
+// from if the lookup is enabled. It runs after the queries in
+// getInstalledPackages instead of alongside them because dnf and zypper
+// take the locks the package queries take.
+func addRepositories(ctx context.Context, pkgs *Packages) {
+	if !repositoryLookup.Load() {
+		return
+	}
+	repoCacheMx.Lock()
+	defer repoCacheMx.Unlock()
+
+	if missing := uncachedRepos("deb", pkgs.Deb); len(missing) > 0 && util.Exists(aptCache) {
+		repos, err := aptRepositories(ctx, missing)
+		if err != nil {
+			clog.Debugf(ctx, "Error looking up apt repositories: %v", err)
+		}
+		cacheRepos("deb", missing, repos, func(pkg *PkgInfo) string { return pkg.Name + "=" + pkg.Version })
+	}
+	if missing := uncachedRepos("rpm", pkgs.Rpm); len(missing) > 0 {
+		var lookup func(context.Context) (map[string]string, error)
+		switch {
+		case DnfExists:
+			lookup = dnfRepositories
+		case ZypperExists:
+			lookup = zypperRepositories
+		case YumExists && util.Exists(yumdb):
+			lookup = yumRepositories
+		}
+		if lookup != nil {
+			repos, err := lookup(ctx)
+			if err != nil {
+				clog.Debugf(ctx, "Error looking up rpm repositories: %v", err)
+			}
+			cacheRepos("rpm", missing, repos, func(pkg *PkgInfo) string { return rpmRepoKey(pkg.Name, pkg.Version) })
+		}
+	}
+
+	// Drop packages that are no longer installed.
+	cache := map[string]string{}
+	for manager, list := range map[string][]*PkgInfo{"deb": pkgs.Deb, "rpm": pkgs.Rpm} {
+		for _, pkg := range list {
+			key := repoCacheKey(manager, pkg)
+			if repo, ok := repoCache[key]; ok {
+				cache[key] = repo
+				pkg.Repository = repo
+			}
+		}
+	}
+	repoCache = cache
+}
+
+func repoCacheKey(manager string, pkg *PkgInfo) string {
+	return manager + ":" + pkg.Name + ":" + pkg.Version
+}
+
+// uncachedRepos returns the packages whose repository is not cached.
+func uncachedRepos(manager string, pkgs []*PkgInfo) []*PkgInfo {
+	var missing []*PkgInfo
+	for _, pkg := range pkgs {
+		if _, ok := repoCache[repoCacheKey(manager, pkg)]; !ok {
+			missing = append(missing, pkg)
+		}
+	}
+	return missing
+}
+
+// cacheRepos caches the repositories of pkgs found in repos, keyed by key,
+// packages that are not found are cached without a repository so they are
+// not looked up again. A failed lookup is not retried until packages change
+// either.
+func cacheRepos(manager string, pkgs []*PkgInfo, repos map[string]string, key func(*PkgInfo) string) {
+	for _, pkg := range pkgs {
+		repoCache[repoCacheKey(manager, pkg)] = repos[key(pkg)]
+	}
+}
+
+// rpmRepoKey identifies an installed rpm by name, version and release, the
+// epoch is dropped as not every tool prints it.
+func rpmRepoKey(name, version string) string {
+	if _, v, ok := strings.Cut(version, ":"); ok {
+		version = v
+	}
+	return name + "-" + version
+}
+
+// localRepo reports whether repo names a package that was installed from a
+// file or is otherwise not tied to a repository.
+func localRepo(repo string) bool {
+	switch repo {
+	case "", "System", "commandline", "installed", "<unknown>", "(System Packages)":
+		return true
+	}
+	return false
+}
+
+// aptRepositories returns the first source apt lists for the installed
+// version of the deb packages, keyed by "name=version".
+func aptRepositories(ctx context.Context, pkgs []*PkgInfo) (map[string]string, error) {
+	names := make([]string, 0, len(pkgs))
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		if !seen[pkg.Name] {
+			seen[pkg.Name] = true
+			names = append(names, pkg.Name)
+		}
+	}
+	out, err := run(ctx, aptCache, append(aptCachePolicyArgs, names...))
+	if err != nil {
+		return nil, err
+	}
+	return parseAptCachePolicy(out), nil
+}
+
+// parseAptCachePolicy returns the repository of each installed package
+// keyed by "name=version".
+func parseAptCachePolicy(data []byte) map[string]string {
+	/*
+		git:
+		  Installed: 1:2.25.1-1ubuntu3.12
+		  Candidate: 1:2.25.1-1ubuntu3.12
+		  Version table:
+		 *** 1:2.25.1-1ubuntu3.12 500
+		        500 http://archive.ubuntu.com/ubuntu focal-updates/main amd64 Packages
+		        100 /var/lib/dpkg/status
+		     1:2.25.1-1ubuntu3 500
+		        500 http://archive.ubuntu.com/ubuntu focal/main amd64 Packages
+	*/
+	repos := map[string]string{}
+	var name, installed string
+	inInstalled := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' {
+			name, installed, inInstalled = strings.TrimSuffix(line, ":"), "", false
+			continue
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[0] == "Installed:":
+			installed = fields[1]
+		case fields[0] == "***":
+			inInstalled = true
+		case len(fields) == 2 && !strings.HasSuffix(fields[0], ":"):
+			// Another version of the version table.
+			inInstalled = false
+		case inInstalled && len(fields) >= 3:
+			// /var/lib/dpkg/status, listed for every installed version, has
+			// no suite and is skipped.
+			key := name + "=" + installed
+			if _, ok := repos[key]; !ok && installed != "" {
+				repos[key] = fields[1] + " " + fields[2]
+			}
+		}
+	}
+	return repos
+}
+
+// dnfRepositories returns the repository of each installed rpm keyed by
+// rpmRepoKey.
+func dnfRepositories(ctx context.Context) (map[string]string, error) {
+	out, err := run(ctx, dnf, dnfRepoqueryInstalledArgs)
+	if err != nil {
+		return nil, err
+	}
+	/*
+		bash-5.1.8-6.el9|baseos
+		openssl-1:3.0.7-27.el9|@System
+		google-osconfig-agent-20240320.00-g1.el9|google-compute-engine
+	*/
+	repos := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		nevr, repo, ok := strings.Cut(strings.TrimSpace(line), "|")
+		if !ok {
+			continue
+		}
+		repo = strings.TrimPrefix(repo, "@")
+		if localRepo(repo) {
+			continue
+		}
+		// rpmRepoKey drops the epoch, which dnf puts after the name.
+		if i := strings.LastIndex(nevr, ":"); i >= 0 {
+			if j := strings.LastIndex(nevr[:i], "-"); j >= 0 {
+				nevr = nevr[:j+1] + nevr[i+1:]
+			}
+		}
+		repos[nevr] = repo
+	}
+	return repos, nil
+}
+
+// yumRepositories returns the repository of each installed rpm keyed by
+// rpmRepoKey, for hosts with yum but not dnf.
+func yumRepositories(ctx context.Context) (map[string]string, error) {
+	out, err := run(ctx, yumdb, yumdbFromRepoArgs)
+	if err != nil {
+		return nil, err
+	}
+	/*
+		bash-4.2.46-34.el7.x86_64
+		     from_repo = base
+		kernel-3.10.0-1160.el7.x86_64
+		     from_repo = anaconda
+	*/
+	repos := map[string]string{}
+	var nvr string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			// Drop the architecture.
+			nvr = line
+			if i := strings.LastIndex(nvr, "."); i >= 0 {
+				nvr = nvr[:i]
+			}
+			continue
+		}
+		key, repo, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "from_repo" || nvr == "" {
+			continue
+		}
+		if repo = strings.TrimSpace(repo); !localRepo(repo) {
+			repos[nvr] = repo
+		}
+	}
+	return repos, nil
+}
+
+// zypperRepositories returns the repository of each installed rpm keyed by
+// rpmRepoKey.
+func zypperRepositories(ctx context.Context) (map[string]string, error) {
+	out, err := run(ctx, zypper, zypperSearchInstalledArgs)
+	if err != nil {
+		return nil, err
+	}
+	/*
+		S  | Name | Type    | Version    | Arch   | Repository
+		---+------+---------+------------+--------+------------------------------------
+		i+ | bash | package | 4.4-9.10.1 | x86_64 | SLE-Module-Basesystem15-SP4-Updates
+		i  | foo  | package | 1.0-1      | noarch | (System Packages)
+	*/
+	repos := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 6 || strings.TrimSpace(fields[2]) != "package" {
+			continue
+		}
+		repo := strings.TrimSpace(fields[5])
+		if localRepo(repo) {
+			continue
+		}
+		key := rpmRepoKey(strings.TrimSpace(fields[1]), strings.TrimSpace(fields[3]))
+		if _, ok := repos[key]; !ok {
+			repos[key] = repo
+		}
+	}
+	return repos, nil
+}
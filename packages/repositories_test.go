@@ -0,0 +1,145 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestAptRepositories(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	out := []byte(`git:
+  Installed: 1:2.25.1-1ubuntu3.12
+  Candidate: 1:2.25.1-1ubuntu3.12
+  Version table:
+ *** 1:2.25.1-1ubuntu3.12 500
+        500 http://archive.ubuntu.com/ubuntu focal-updates/main amd64 Packages
+        500 http://security.ubuntu.com/ubuntu focal-security/main amd64 Packages
+        100 /var/lib/dpkg/status
+     1:2.25.1-1ubuntu3 500
+        500 http://archive.ubuntu.com/ubuntu focal/main amd64 Packages
+local:
+  Installed: 1.0
+  Candidate: 1.0
+  Version table:
+ *** 1.0 100
+        100 /var/lib/dpkg/status
+`)
+	newPkgs := func() *Packages {
+		return &Packages{Deb: []*PkgInfo{
+			{Name: "git", Arch: "x86_64", Version: "1:2.25.1-1ubuntu3.12"},
+			{Name: "local", Arch: "all", Version: "1.0"},
+		}}
+	}
+	oldAptCache := aptCache
+	defer func() {
+		aptCache = oldAptCache
+		SetRepositoryLookup(false)
+		repoCache = map[string]string{}
+	}()
+	// aptCache only has to exist.
+	aptCache = "/bin/sh"
+
+	// The lookup is opt-in.
+	SetRepositoryLookup(false)
+	addRepositories(testCtx, newPkgs())
+
+	SetRepositoryLookup(true)
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(aptCache, "policy", "git", "local"))).Return(out, nil, nil).Times(1)
+	// The second lookup is served from the cache.
+	for i := 0; i < 2; i++ {
+		pkgs := newPkgs()
+		addRepositories(testCtx, pkgs)
+		if got, want := pkgs.Deb[0].Repository, "http://archive.ubuntu.com/ubuntu focal-updates/main"; got != want {
+			t.Errorf("git Repository = %q, want %q", got, want)
+		}
+		if got := pkgs.Deb[1].Repository; got != "" {
+			t.Errorf("local Repository = %q, want it empty", got)
+		}
+	}
+
+	// Only a newly installed version is looked up.
+	pkgs := newPkgs()
+	pkgs.Deb[1].Version = "1.1"
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(aptCache, "policy", "local"))).Return(nil, nil, nil).Times(1)
+	addRepositories(testCtx, pkgs)
+	if got, want := pkgs.Deb[0].Repository, "http://archive.ubuntu.com/ubuntu focal-updates/main"; got != want {
+		t.Errorf("git Repository = %q, want %q", got, want)
+	}
+}
+
+func TestRPMRepositories(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	tests := []struct {
+		name  string
+		query func() (map[string]string, error)
+		cmd   *exec.Cmd
+		out   string
+	}{
+		{
+			name:  "dnf",
+			query: func() (map[string]string, error) { return dnfRepositories(testCtx) },
+			cmd:   exec.Command(dnf, dnfRepoqueryInstalledArgs...),
+			out:   "bash-5.1.8-6.el9|baseos\nopenssl-1:3.0.7-27.el9|@appstream\nlocal-1.0-1|@commandline\nsystem-1.0-1|@System\n",
+		},
+		{
+			name:  "yum",
+			query: func() (map[string]string, error) { return yumRepositories(testCtx) },
+			cmd:   exec.Command(yumdb, yumdbFromRepoArgs...),
+			out:   "bash-5.1.8-6.el9.x86_64\n     from_repo = baseos\nopenssl-3.0.7-27.el9.x86_64\n     from_repo = appstream\nlocal-1.0-1.noarch\n     from_repo = installed\n",
+		},
+		{
+			name:  "zypper",
+			query: func() (map[string]string, error) { return zypperRepositories(testCtx) },
+			cmd:   exec.Command(zypper, zypperSearchInstalledArgs...),
+			out: `S  | Name    | Type    | Version        | Arch   | Repository
+---+---------+---------+----------------+--------+-------------------
+i+ | bash    | package | 5.1.8-6.el9    | x86_64 | baseos
+i+ | openssl | package | 1:3.0.7-27.el9 | x86_64 | appstream
+i  | local   | package | 1.0-1          | noarch | (System Packages)
+`,
+		},
+	}
+	want := map[string]string{"bash-5.1.8-6.el9": "baseos", "openssl-3.0.7-27.el9": "appstream"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(tt.cmd)).Return([]byte(tt.out), nil, nil).Times(1)
+			got, err := tt.query()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("repositories = %v, want %v", got, want)
+			}
+		})
+	}
+
+	if got, want := rpmRepoKey("openssl", "1:3.0.7-27.el9"), "openssl-3.0.7-27.el9"; got != want {
+		t.Errorf("rpmRepoKey() = %q, want %q", got, want)
+	}
+}
@@ -19,9 +19,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"runtime"
+	"strconv"
+	"sync/atomic"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages/rpmdb"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
@@ -35,10 +39,14 @@ var (
 		// %|EPOCH?{%{EPOCH}:}:{}| == if EPOCH then prepend "%{EPOCH}:" to version.
 		"version":     "%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}",
 		"source_name": "%{SOURCERPM}",
+		// INSTALLTIME is "(none)" for rpm files that are not installed.
+		"install_time": "%{INSTALLTIME}",
+		"size":         "%{LONGSIZE}",
 	}
 
-	rpmInstallArgs = []string{"--upgrade", "--replacepkgs", "-v"}
-	rpmqueryArgs   = []string{"--queryformat", formatFieldsMappingToFormattingString(rpmqueryFields)}
+	rpmInstallArgs   = []string{"--upgrade", "--replacepkgs", "-v"}
+	rpmImportKeyArgs = []string{"--import"}
+	rpmqueryArgs     = []string{"--queryformat", formatFieldsMappingToFormattingString(rpmqueryFields)}
 
 	rpmqueryInstalledArgs = append(rpmqueryArgs, "-a")
 	rpmqueryRPMArgs       = append(rpmqueryArgs, "-p")
@@ -51,6 +59,7 @@ func init() {
 	}
 	RPMQueryExists = util.Exists(rpmquery)
 	RPMExists = util.Exists(rpm)
+	rpmDBDirect.Store(os.Getenv(RPMDBDirectEnv) == "1")
 }
 
 func parseInstalledRPMPackages(ctx context.Context, data []byte) []*PkgInfo {
@@ -78,8 +87,60 @@ func parseInstalledRPMPackages(ctx context.Context, data []byte) []*PkgInfo {
 	return result
 }
 
+// RPMDBDirectEnv enables SetRPMDBDirect in child processes of the agent,
+// such as the inventory worker, that do not load the agent config.
+const RPMDBDirectEnv = "OSCONFIG_RPMDB_DIRECT"
+
+var (
+	rpmDBDirect atomic.Bool
+	// rpmDBInstalled reads the installed packages from the rpm database.
+	rpmDBInstalled = rpmdb.Installed
+)
+
+// SetRPMDBDirect sets whether InstalledRPMPackages reads the rpm database
+// files itself instead of running rpmquery, which is much faster on hosts
+// with thousands of packages.
+func SetRPMDBDirect(enabled bool) {
+	rpmDBDirect.Store(enabled)
+}
+
+// RPMDBDirect reports whether InstalledRPMPackages reads the rpm database
+// files itself.
+func RPMDBDirect() bool {
+	return rpmDBDirect.Load()
+}
+
+// installedRPMDBPackages reads the installed packages from the rpm database
+// files, they are reported exactly as rpmquery reports them.
+func installedRPMDBPackages() ([]*PkgInfo, error) {
+	pkgs, err := rpmDBInstalled()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*PkgInfo, 0, len(pkgs))
+	for _, p := range pkgs {
+		result = append(result, pkgInfoFromPackageMetadata(packageMetadata{
+			Package:      p.Name,
+			Architecture: p.Arch,
+			Version:      p.EVR(),
+			SourceName:   p.SourceRPM,
+			InstallTime:  strconv.FormatInt(p.InstallTime, 10),
+			Size:         strconv.FormatInt(p.Size, 10),
+		}))
+	}
+	return result, nil
+}
+
 // InstalledRPMPackages queries for all installed rpm packages.
 func InstalledRPMPackages(ctx context.Context) ([]*PkgInfo, error) {
+	if rpmDBDirect.Load() {
+		pkgs, err := installedRPMDBPackages()
+		if err == nil {
+			return pkgs, nil
+		}
+		clog.Debugf(ctx, "Error reading the rpm database, falling back to rpmquery: %v", err)
+	}
+
 	out, err := run(ctx, rpmquery, rpmqueryInstalledArgs)
 	if err != nil {
 		return nil, err
@@ -90,10 +151,17 @@ func InstalledRPMPackages(ctx context.Context) ([]*PkgInfo, error) {
 
 // RPMInstall installs an rpm packages.
 func RPMInstall(ctx context.Context, path string) error {
+	defer InvalidateQueryCache()
 	_, err := run(ctx, rpm, append(rpmInstallArgs, path))
 	return err
 }
 
+// RPMImportKeys imports GPG keys, from local paths or URLs, into the rpm database.
+func RPMImportKeys(ctx context.Context, keys []string) error {
+	_, err := run(ctx, rpm, append(rpmImportKeyArgs, keys...))
+	return err
+}
+
 // RPMPkgInfo gets PkgInfo from a rpm package.
 func RPMPkgInfo(ctx context.Context, path string) (*PkgInfo, error) {
 	out, err := run(ctx, rpmquery, append(rpmqueryRPMArgs, path))
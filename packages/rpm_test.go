@@ -20,6 +20,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/osconfig/packages/rpmdb"
 	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
 	"github.com/golang/mock/gomock"
 )
@@ -40,6 +41,16 @@ func TestParseInstalledRPMPackages(t *testing.T) {
 				{Name: "golang-src", Arch: "all", Version: "1.22.3-1.el9", Source: Source{Name: "golang-1.22.3-1.el9.src.rpm"}},
 			},
 		},
+		{
+			name: "Install time and size",
+			data: []byte("" +
+				`{"architecture":"x86_64","install_time":"1700000000","package":"gcc","size":"84803744","source_name":"gcc-11.4.1-3.el9.src.rpm","version":"11.4.1-3.el9"}` + "\n" +
+				`{"architecture":"x86_64","install_time":"(none)","package":"local","size":"(none)","source_name":"local-1.0-1.src.rpm","version":"1.0-1"}`),
+			want: []*PkgInfo{
+				{Name: "gcc", Arch: "x86_64", Version: "11.4.1-3.el9", Source: Source{Name: "gcc-11.4.1-3.el9.src.rpm"}, InstallTime: unixTime(1700000000), Size: 84803744},
+				{Name: "local", Arch: "x86_64", Version: "1.0-1", Source: Source{Name: "local-1.0-1.src.rpm"}},
+			},
+		},
 		{
 			name: "No valid pacakges",
 			data: []byte("nothing here"),
@@ -105,7 +116,7 @@ func TestInstalledRPMPackages(t *testing.T) {
 			},
 			},
 			expectedResults: nil,
-			expectedError:   errors.New("error running /usr/bin/rpmquery with args [\"--queryformat\" \"\\\\{\\\"architecture\\\":\\\"%{ARCH}\\\",\\\"package\\\":\\\"%{NAME}\\\",\\\"source_name\\\":\\\"%{SOURCERPM}\\\",\\\"version\\\":\\\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\\\"\\\\}\\n\" \"-a\"]: unexpected error, stdout: \"stdout\", stderr: \"stderr\""),
+			expectedError:   errors.New("error running /usr/bin/rpmquery with args [\"--queryformat\" \"\\\\{\\\"architecture\\\":\\\"%{ARCH}\\\",\\\"install_time\\\":\\\"%{INSTALLTIME}\\\",\\\"package\\\":\\\"%{NAME}\\\",\\\"size\\\":\\\"%{LONGSIZE}\\\",\\\"source_name\\\":\\\"%{SOURCERPM}\\\",\\\"version\\\":\\\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\\\"\\\\}\\n\" \"-a\"]: unexpected error, stdout: \"stdout\", stderr: \"stderr\""),
 		},
 	}
 
@@ -120,7 +131,7 @@ func TestInstalledRPMPackages(t *testing.T) {
 			setExpectations(mockCommandRunner, tt.expectedCommandsChain)
 
 			results, err := InstalledRPMPackages(testCtx)
-			if !reflect.DeepEqual(err, tt.expectedError) {
+			if formatError(err) != formatError(tt.expectedError) {
 				t.Errorf("InstalledRPMPackages: unexpected error, expect %q, got %q", formatError(tt.expectedError), formatError(err))
 			}
 
@@ -131,6 +142,47 @@ func TestInstalledRPMPackages(t *testing.T) {
 	}
 }
 
+func TestInstalledRPMPackagesRPMDB(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	oldInstalled := rpmDBInstalled
+	defer func() { rpmDBInstalled = oldInstalled }()
+	SetRPMDBDirect(true)
+	defer SetRPMDBDirect(false)
+
+	rpmDBInstalled = func() ([]*rpmdb.Package, error) {
+		return []*rpmdb.Package{
+			{Name: "gcc", Version: "11.4.1", Release: "3.el9", Arch: "x86_64", SourceRPM: "gcc-11.4.1-3.el9.src.rpm"},
+			{Name: "openssl", Epoch: "1", Version: "3.0.7", Release: "27.el9", Arch: "noarch", SourceRPM: "openssl-3.0.7-27.el9.src.rpm", InstallTime: 1700000000, Size: 7738634},
+		}, nil
+	}
+	want := []*PkgInfo{
+		{Name: "gcc", Arch: "x86_64", Version: "11.4.1-3.el9", Source: Source{Name: "gcc-11.4.1-3.el9.src.rpm"}},
+		{Name: "openssl", Arch: "all", Version: "1:3.0.7-27.el9", Source: Source{Name: "openssl-3.0.7-27.el9.src.rpm"}, InstallTime: unixTime(1700000000), Size: 7738634},
+	}
+	got, err := InstalledRPMPackages(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InstalledRPMPackages() = %v, want %v", got, want)
+	}
+
+	// rpmquery is used if the database can not be read.
+	rpmDBInstalled = func() ([]*rpmdb.Package, error) { return nil, rpmdb.ErrNotFound }
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(rpmquery, rpmqueryInstalledArgs...))).Return([]byte(`{"architecture":"x86_64","package":"gcc","source_name":"gcc-11.4.1-3.el9.src.rpm","version":"11.4.1-3.el9"}`), nil, nil).Times(1)
+	got, err = InstalledRPMPackages(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("InstalledRPMPackages() = %v, want %v", got, want[:1])
+	}
+}
+
 func TestRPMPkgInfo(t *testing.T) {
 	tests := []struct {
 		name string
@@ -208,7 +260,7 @@ func TestRPMPkgInfo(t *testing.T) {
 				},
 			},
 			expectedResult: nil,
-			expectedError:  errors.New("error running /usr/bin/rpmquery with args [\"--queryformat\" \"\\\\{\\\"architecture\\\":\\\"%{ARCH}\\\",\\\"package\\\":\\\"%{NAME}\\\",\\\"source_name\\\":\\\"%{SOURCERPM}\\\",\\\"version\\\":\\\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\\\"\\\\}\\n\" \"-p\" \"/tmp/gcc.rpm\"]: unexpected error, stdout: \"stdout\", stderr: \"stderr\""),
+			expectedError:  errors.New("error running /usr/bin/rpmquery with args [\"--queryformat\" \"\\\\{\\\"architecture\\\":\\\"%{ARCH}\\\",\\\"install_time\\\":\\\"%{INSTALLTIME}\\\",\\\"package\\\":\\\"%{NAME}\\\",\\\"size\\\":\\\"%{LONGSIZE}\\\",\\\"source_name\\\":\\\"%{SOURCERPM}\\\",\\\"version\\\":\\\"%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\\\"\\\\}\\n\" \"-p\" \"/tmp/gcc.rpm\"]: unexpected error, stdout: \"stdout\", stderr: \"stderr\""),
 		},
 	}
 
@@ -223,7 +275,7 @@ func TestRPMPkgInfo(t *testing.T) {
 			setExpectations(mockCommandRunner, tt.expectedCommandsChain)
 
 			result, err := RPMPkgInfo(testCtx, tt.path)
-			if !reflect.DeepEqual(err, tt.expectedError) {
+			if formatError(err) != formatError(tt.expectedError) {
 				t.Errorf("RPMPkgInfo: unexpected error, expect %q, got %q", formatError(tt.expectedError), formatError(err))
 			}
 
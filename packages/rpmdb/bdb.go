@@ -0,0 +1,176 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package rpmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The Berkeley DB hash database layout, from db's dbinc/db_page.h. Pages
+// are in the byte order of the host that created the database.
+const (
+	bdbHashMagic   = 0x061561
+	bdbMetaChksum  = 0x01
+	bdbPageHeader  = 26
+	bdbHashOffPage = 12
+
+	bdbPageHashUnsorted = 2
+	bdbPageOverflow     = 7
+	bdbPageHash         = 13
+
+	bdbKeyData = 1
+	bdbOffPage = 3
+)
+
+// readBdb returns the header blobs of the packages in a Berkeley DB hash
+// Packages database.
+func readBdb(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	meta := make([]byte, 72)
+	if _, err := f.ReadAt(meta, 0); err != nil {
+		return nil, fmt.Errorf("error reading metadata: %v", err)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(meta[12:16]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(meta[12:16]) != bdbHashMagic {
+			return nil, fmt.Errorf("not a Berkeley DB hash database")
+		}
+	}
+	if meta[24] != 0 {
+		return nil, fmt.Errorf("encrypted databases are not supported")
+	}
+	if meta[26]&bdbMetaChksum != 0 {
+		return nil, fmt.Errorf("databases with page checksums are not supported")
+	}
+	pageSize := order.Uint32(meta[20:24])
+	if pageSize < 512 || pageSize > 65536 {
+		return nil, fmt.Errorf("bad page size %d", pageSize)
+	}
+	lastPage := order.Uint32(meta[32:36])
+
+	b := &bdb{r: f, order: order, pageSize: pageSize}
+	var blobs [][]byte
+	for pgno := uint32(1); pgno <= lastPage; pgno++ {
+		page, err := b.page(pgno)
+		if err != nil {
+			return nil, err
+		}
+		if t := page[25]; t != bdbPageHash && t != bdbPageHashUnsorted {
+			continue
+		}
+		values, err := b.hashValues(page)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %v", pgno, err)
+		}
+		blobs = append(blobs, values...)
+	}
+	return blobs, nil
+}
+
+type bdb struct {
+	r        io.ReaderAt
+	order    binary.ByteOrder
+	pageSize uint32
+}
+
+func (b *bdb) page(pgno uint32) ([]byte, error) {
+	page := make([]byte, b.pageSize)
+	if _, err := b.r.ReadAt(page, int64(pgno)*int64(b.pageSize)); err != nil {
+		return nil, fmt.Errorf("error reading page %d: %v", pgno, err)
+	}
+	return page, nil
+}
+
+// hashValues returns the values on a hash page. Entries alternate between
+// keys and values and are stored from the end of the page, so an entry
+// ends where the one before it starts.
+func (b *bdb) hashValues(page []byte) ([][]byte, error) {
+	entries := int(b.order.Uint16(page[20:22]))
+	if bdbPageHeader+2*entries > len(page) {
+		return nil, fmt.Errorf("bad entry count %d", entries)
+	}
+	offset := func(i int) int {
+		return int(b.order.Uint16(page[bdbPageHeader+2*i:]))
+	}
+
+	var values [][]byte
+	for i := 1; i < entries; i += 2 {
+		start, end := offset(i), offset(i-1)
+		if start >= end || end > len(page) {
+			return nil, fmt.Errorf("bad offset of entry %d", i)
+		}
+		entry := page[start:end]
+		switch entry[0] {
+		case bdbKeyData:
+			// Only the package counter under key 0 is small enough to be
+			// stored on the page, it is not a package.
+			if len(entry)-1 <= 4 {
+				continue
+			}
+			values = append(values, append([]byte(nil), entry[1:]...))
+		case bdbOffPage:
+			if len(entry) < bdbHashOffPage {
+				return nil, fmt.Errorf("short off page entry %d", i)
+			}
+			v, err := b.overflow(b.order.Uint32(entry[4:8]), b.order.Uint32(entry[8:12]))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		default:
+			return nil, fmt.Errorf("unsupported entry type %d", entry[0])
+		}
+	}
+	return values, nil
+}
+
+// overflow reads a value of length bytes from the overflow pages starting
+// at pgno.
+func (b *bdb) overflow(pgno, length uint32) ([]byte, error) {
+	if length > maxHeaderSize {
+		return nil, fmt.Errorf("overflow value of %d bytes", length)
+	}
+	value := make([]byte, 0, length)
+	for pgno != 0 && uint32(len(value)) < length {
+		page, err := b.page(pgno)
+		if err != nil {
+			return nil, err
+		}
+		if page[25] != bdbPageOverflow {
+			return nil, fmt.Errorf("page %d is not an overflow page", pgno)
+		}
+		// For overflow pages the free area offset is the length of the
+		// data on the page.
+		n := uint32(b.order.Uint16(page[22:24]))
+		if n == 0 || bdbPageHeader+n > b.pageSize {
+			return nil, fmt.Errorf("bad data length %d on overflow page %d", n, pgno)
+		}
+		value = append(value, page[bdbPageHeader:bdbPageHeader+n]...)
+		pgno = b.order.Uint32(page[16:20])
+	}
+	if uint32(len(value)) != length {
+		return nil, fmt.Errorf("overflow value is %d bytes, want %d", len(value), length)
+	}
+	return value, nil
+}
@@ -0,0 +1,142 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// Header tags and types, from rpm's rpmtag.h.
+const (
+	tagName        = 1000
+	tagVersion     = 1001
+	tagRelease     = 1002
+	tagEpoch       = 1003
+	tagInstallTime = 1008
+	tagSize        = 1009
+	tagArch        = 1022
+	tagSourceRPM   = 1044
+	tagLongSize    = 5009
+
+	typeInt32       = 4
+	typeInt64       = 5
+	typeString      = 6
+	typeI18NString  = 9
+	headerEntrySize = 16
+	// maxHeaderEntries bounds the index so a corrupt header does not cause
+	// a huge allocation, rpm itself allows 0xffff.
+	maxHeaderEntries = 0xffff
+	// maxHeaderSize bounds the length of a header blob taken from the
+	// database before it is allocated. Real headers are at most a few MB,
+	// a larger length means the database is corrupt or was being written.
+	maxHeaderSize = 64 << 20
+)
+
+// none is what rpm prints for a tag a package does not have, packages are
+// reported the same way rpmquery reports them.
+const none = "(none)"
+
+// parseHeader parses a header blob as stored in the database, that is the
+// header without its leading magic.
+func parseHeader(blob []byte) (*Package, error) {
+	if len(blob) < 8 {
+		return nil, fmt.Errorf("header of %d bytes is too short", len(blob))
+	}
+	il := binary.BigEndian.Uint32(blob[0:4])
+	dl := binary.BigEndian.Uint32(blob[4:8])
+	if il > maxHeaderEntries {
+		return nil, fmt.Errorf("header has %d entries", il)
+	}
+	dataStart := 8 + uint64(il)*headerEntrySize
+	if dataStart+uint64(dl) > uint64(len(blob)) {
+		return nil, fmt.Errorf("header of %d bytes is shorter than its %d entries and %d bytes of data", len(blob), il, dl)
+	}
+	data := blob[dataStart : dataStart+uint64(dl)]
+
+	pkg := &Package{Name: none, Version: none, Release: none, Arch: none, SourceRPM: none}
+	for i := uint32(0); i < il; i++ {
+		e := blob[8+i*headerEntrySize:]
+		tag := binary.BigEndian.Uint32(e[0:4])
+		typ := binary.BigEndian.Uint32(e[4:8])
+		off := binary.BigEndian.Uint32(e[8:12])
+		if off >= uint32(len(data)) {
+			continue
+		}
+		switch tag {
+		case tagName, tagVersion, tagRelease, tagArch, tagSourceRPM:
+			if typ != typeString && typ != typeI18NString {
+				continue
+			}
+			s, err := headerString(data[off:])
+			if err != nil {
+				return nil, fmt.Errorf("tag %d: %v", tag, err)
+			}
+			switch tag {
+			case tagName:
+				pkg.Name = s
+			case tagVersion:
+				pkg.Version = s
+			case tagRelease:
+				pkg.Release = s
+			case tagArch:
+				pkg.Arch = s
+			case tagSourceRPM:
+				pkg.SourceRPM = s
+			}
+		case tagEpoch:
+			if typ != typeInt32 || len(data[off:]) < 4 {
+				continue
+			}
+			pkg.Epoch = strconv.FormatUint(uint64(binary.BigEndian.Uint32(data[off:])), 10)
+		case tagInstallTime, tagSize:
+			if typ != typeInt32 || len(data[off:]) < 4 {
+				continue
+			}
+			v := int64(binary.BigEndian.Uint32(data[off:]))
+			if tag == tagInstallTime {
+				pkg.InstallTime = v
+			} else if pkg.Size == 0 {
+				pkg.Size = v
+			}
+		case tagLongSize:
+			// Set for packages of 4GiB or more instead of the 32 bit size.
+			if typ != typeInt64 || len(data[off:]) < 8 {
+				continue
+			}
+			pkg.Size = int64(binary.BigEndian.Uint64(data[off:]))
+		}
+	}
+	return pkg, nil
+}
+
+func headerString(b []byte) (string, error) {
+	end := bytes.IndexByte(b, 0)
+	if end < 0 {
+		return "", fmt.Errorf("string is not terminated")
+	}
+	return string(b[:end]), nil
+}
+
+// EVR returns the version of pkg as rpmquery formats it, with the epoch
+// only if the package has one.
+func (p *Package) EVR() string {
+	if p.Epoch != "" {
+		return p.Epoch + ":" + p.Version + "-" + p.Release
+	}
+	return p.Version + "-" + p.Release
+}
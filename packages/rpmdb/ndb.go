@@ -0,0 +1,106 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package rpmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// The ndb package database layout, from rpm's lib/backend/ndb/rpmpkg.c. All
+// values are little endian.
+const (
+	ndbMagic      = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic  = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic  = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+	ndbVersion    = 0
+	ndbPageSize   = 4096
+	ndbHeaderSize = 32
+	ndbSlotSize   = 16
+	ndbBlkSize    = 16
+	ndbBlobHead   = 16
+	// ndbMaxSlotPages bounds the slot pages read from a corrupt header.
+	ndbMaxSlotPages = 4096
+)
+
+// readNdb returns the header blobs of the packages in an ndb Packages.db.
+func readNdb(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hdr := make([]byte, ndbHeaderSize)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("error reading header: %v", err)
+	}
+	if magic := binary.LittleEndian.Uint32(hdr[0:4]); magic != ndbMagic {
+		return nil, fmt.Errorf("bad magic %#x", magic)
+	}
+	if v := binary.LittleEndian.Uint32(hdr[4:8]); v != ndbVersion {
+		return nil, fmt.Errorf("unsupported version %d", v)
+	}
+	slotPages := binary.LittleEndian.Uint32(hdr[12:16])
+	if slotPages == 0 || slotPages > ndbMaxSlotPages {
+		return nil, fmt.Errorf("bad slot page count %d", slotPages)
+	}
+
+	// The header takes the place of the first two slots.
+	slots := make([]byte, slotPages*ndbPageSize-ndbHeaderSize)
+	if _, err := f.ReadAt(slots, ndbHeaderSize); err != nil {
+		return nil, fmt.Errorf("error reading slots: %v", err)
+	}
+
+	var blobs [][]byte
+	for off := 0; off < len(slots); off += ndbSlotSize {
+		slot := slots[off : off+ndbSlotSize]
+		if magic := binary.LittleEndian.Uint32(slot[0:4]); magic != ndbSlotMagic {
+			return nil, fmt.Errorf("bad slot magic %#x", magic)
+		}
+		pkgIndex := binary.LittleEndian.Uint32(slot[4:8])
+		if pkgIndex == 0 {
+			// An unused slot.
+			continue
+		}
+		blkOffset := int64(binary.LittleEndian.Uint32(slot[8:12])) * ndbBlkSize
+
+		head := make([]byte, ndbBlobHead)
+		if _, err := f.ReadAt(head, blkOffset); err != nil {
+			return nil, fmt.Errorf("error reading blob of package %d: %v", pkgIndex, err)
+		}
+		if magic := binary.LittleEndian.Uint32(head[0:4]); magic != ndbBlobMagic {
+			return nil, fmt.Errorf("bad blob magic %#x for package %d", magic, pkgIndex)
+		}
+		if idx := binary.LittleEndian.Uint32(head[4:8]); idx != pkgIndex {
+			return nil, fmt.Errorf("blob of package %d belongs to package %d", pkgIndex, idx)
+		}
+		blobLen := binary.LittleEndian.Uint32(head[12:16])
+		blkCount := int64(binary.LittleEndian.Uint32(slot[12:16]))
+		if int64(blobLen) > blkCount*ndbBlkSize {
+			return nil, fmt.Errorf("blob of package %d is longer than its %d blocks", pkgIndex, blkCount)
+		}
+		if blobLen > maxHeaderSize {
+			return nil, fmt.Errorf("blob of package %d is %d bytes", pkgIndex, blobLen)
+		}
+		blob := make([]byte, blobLen)
+		if _, err := f.ReadAt(blob, blkOffset+ndbBlobHead); err != nil {
+			return nil, fmt.Errorf("error reading blob of package %d: %v", pkgIndex, err)
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, nil
+}
@@ -0,0 +1,116 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package rpmdb reads the installed packages from the rpm database files
+// without running rpm. It supports the sqlite (EL9, Fedora), ndb (SUSE) and
+// Berkeley DB hash (EL7, EL8) backends.
+package rpmdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Dirs are the directories searched for the rpm database, in order.
+var Dirs = []string{"/var/lib/rpm", "/usr/lib/sysimage/rpm"}
+
+// ErrNotFound is returned when no rpm database is found.
+var ErrNotFound = errors.New("no rpm database found")
+
+// Package is an installed package.
+type Package struct {
+	Name    string
+	Epoch   string
+	Version string
+	Release string
+	Arch    string
+	// SourceRPM is the file name of the source rpm.
+	SourceRPM string
+	// InstallTime is when the package was installed as a Unix time, Size
+	// is its installed size in bytes, both are 0 if the header has none.
+	InstallTime int64
+	Size        int64
+}
+
+// backends are the database files and their readers. Only one is in use on
+// a system, but the sqlite database is preferred as a left over database
+// from before a conversion may still be present.
+var backends = []struct {
+	file string
+	read func(string) ([][]byte, error)
+}{
+	{"rpmdb.sqlite", readSqlite},
+	{"Packages.db", readNdb},
+	{"Packages", readBdb},
+}
+
+// readAttempts is how many times a database that changed while it was read
+// is read again before giving up.
+var readAttempts = 3
+
+// Installed returns the packages in the first rpm database found in Dirs.
+func Installed() ([]*Package, error) {
+	for _, dir := range Dirs {
+		for _, b := range backends {
+			path := filepath.Join(dir, b.file)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			return read(path, b.read)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// read reads the database at path. The database is read without taking
+// rpm's lock, so a read that overlaps a transaction can see a mix of old
+// and new pages. The file is compared before and after the read and a read
+// that raced with a write is retried.
+func read(path string, readBlobs func(string) ([][]byte, error)) ([]*Package, error) {
+	var blobs [][]byte
+	for attempt := 1; ; attempt++ {
+		before, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		blobs, err = readBlobs(path)
+		after, serr := os.Stat(path)
+		if serr == nil && unchanged(before, after) {
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %v", path, err)
+			}
+			break
+		}
+		if attempt >= readAttempts {
+			return nil, fmt.Errorf("%s changed while it was read", path)
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+	pkgs := make([]*Package, 0, len(blobs))
+	for _, blob := range blobs {
+		pkg, err := parseHeader(blob)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing package header in %s: %v", path, err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+func unchanged(before, after os.FileInfo) bool {
+	return os.SameFile(before, after) && before.Size() == after.Size() && before.ModTime().Equal(after.ModTime())
+}
@@ -0,0 +1,366 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package rpmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testHeader encodes pkg as a header blob, tags pkg does not set are left
+// out.
+func testHeader(pkg *Package) []byte {
+	type entry struct{ tag, typ, off uint32 }
+	var entries []entry
+	var data []byte
+	str := func(tag uint32, s string) {
+		if s == "" || s == none {
+			return
+		}
+		entries = append(entries, entry{tag, typeString, uint32(len(data))})
+		data = append(append(data, s...), 0)
+	}
+	str(tagName, pkg.Name)
+	str(tagVersion, pkg.Version)
+	str(tagRelease, pkg.Release)
+	if pkg.Epoch != "" {
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+		var epoch uint32
+		fmt.Sscan(pkg.Epoch, &epoch)
+		entries = append(entries, entry{tagEpoch, typeInt32, uint32(len(data))})
+		data = binary.BigEndian.AppendUint32(data, epoch)
+	}
+	align := func(n int) {
+		for len(data)%n != 0 {
+			data = append(data, 0)
+		}
+	}
+	if pkg.InstallTime != 0 {
+		align(4)
+		entries = append(entries, entry{tagInstallTime, typeInt32, uint32(len(data))})
+		data = binary.BigEndian.AppendUint32(data, uint32(pkg.InstallTime))
+	}
+	if pkg.Size != 0 && pkg.Size < 1<<32 {
+		align(4)
+		entries = append(entries, entry{tagSize, typeInt32, uint32(len(data))})
+		data = binary.BigEndian.AppendUint32(data, uint32(pkg.Size))
+	}
+	str(tagArch, pkg.Arch)
+	str(tagSourceRPM, pkg.SourceRPM)
+	if pkg.Size >= 1<<32 {
+		align(8)
+		entries = append(entries, entry{tagLongSize, typeInt64, uint32(len(data))})
+		data = binary.BigEndian.AppendUint64(data, uint64(pkg.Size))
+	}
+
+	blob := binary.BigEndian.AppendUint32(nil, uint32(len(entries)))
+	blob = binary.BigEndian.AppendUint32(blob, uint32(len(data)))
+	for _, e := range entries {
+		blob = binary.BigEndian.AppendUint32(blob, e.tag)
+		blob = binary.BigEndian.AppendUint32(blob, e.typ)
+		blob = binary.BigEndian.AppendUint32(blob, e.off)
+		blob = binary.BigEndian.AppendUint32(blob, 1)
+	}
+	return append(blob, data...)
+}
+
+var testPackages = []*Package{
+	{Name: "bash", Version: "5.1.8", Release: "6.el9", Arch: "x86_64", SourceRPM: "bash-5.1.8-6.el9.src.rpm"},
+	{Name: "openssl", Epoch: "1", Version: "3.0.7", Release: "27.el9", Arch: "x86_64", SourceRPM: "openssl-3.0.7-27.el9.src.rpm"},
+	{Name: "gpg-pubkey", Version: "fd431d51", Release: "4ae0493b", Arch: none, SourceRPM: none},
+}
+
+func TestParseHeader(t *testing.T) {
+	withSizes := []*Package{
+		{Name: "bash", Version: "5.1.8", Release: "6.el9", Arch: "x86_64", SourceRPM: "bash-5.1.8-6.el9.src.rpm", InstallTime: 1700000000, Size: 7738634},
+		{Name: "huge", Version: "1.0", Release: "1.el9", Arch: "x86_64", SourceRPM: "huge-1.0-1.el9.src.rpm", Size: 5 << 30},
+	}
+	for _, want := range append(testPackages, withSizes...) {
+		got, err := parseHeader(testHeader(want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("parseHeader() = %+v, want %+v", got, want)
+		}
+	}
+	if got, want := testPackages[1].EVR(), "1:3.0.7-27.el9"; got != want {
+		t.Errorf("EVR() = %q, want %q", got, want)
+	}
+	if got, want := testPackages[0].EVR(), "5.1.8-6.el9"; got != want {
+		t.Errorf("EVR() = %q, want %q", got, want)
+	}
+
+	blob := testHeader(testPackages[0])
+	for _, bad := range [][]byte{nil, blob[:7], blob[:len(blob)-1]} {
+		if _, err := parseHeader(bad); err == nil {
+			t.Errorf("parseHeader(%d bytes) did not return an error", len(bad))
+		}
+	}
+}
+
+func TestReadSqlite(t *testing.T) {
+	pkgs, err := read("testdata/rpmdb.sqlite", readSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture, built with sqlite using rpm's schema and 1k pages so it
+	// has interior and overflow pages, holds the test packages, a package
+	// with a 3000 byte description and 40 small packages.
+	if len(pkgs) != 44 {
+		t.Fatalf("read %d packages, want 44", len(pkgs))
+	}
+	if !reflect.DeepEqual(pkgs[:3], testPackages) {
+		t.Errorf("first packages = %+v, want %+v", pkgs[:3], testPackages)
+	}
+	if pkgs[3].Name != "big" || pkgs[3].SourceRPM != "big-1.0-1.el9.src.rpm" {
+		t.Errorf("package with overflow pages = %+v", pkgs[3])
+	}
+	if last := pkgs[43]; last.Name != "pkg-39" || last.EVR() != "1.39-1.el9" {
+		t.Errorf("last package = %+v", last)
+	}
+
+	// Changes still in the write ahead log are not in the file.
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/rpmdb.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "rpmdb.sqlite")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+"-wal", []byte("wal"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSqlite(path); err == nil || !strings.Contains(err.Error(), "write ahead log") {
+		t.Errorf("readSqlite() with a write ahead log error = %v", err)
+	}
+}
+
+func TestReadNdb(t *testing.T) {
+	// One slot page, followed by the blobs.
+	db := make([]byte, ndbPageSize)
+	le := binary.LittleEndian
+	le.PutUint32(db[0:], ndbMagic)
+	le.PutUint32(db[12:], 1)
+	for off := ndbHeaderSize; off < ndbPageSize; off += ndbSlotSize {
+		le.PutUint32(db[off:], ndbSlotMagic)
+	}
+	for i, pkg := range testPackages {
+		blob := testHeader(pkg)
+		blkOffset := len(db) / ndbBlkSize
+		head := make([]byte, ndbBlobHead)
+		le.PutUint32(head[0:], ndbBlobMagic)
+		le.PutUint32(head[4:], uint32(i+1))
+		le.PutUint32(head[12:], uint32(len(blob)))
+		db = append(append(db, head...), blob...)
+		// Leave room for the blob tail and pad to whole blocks.
+		db = append(db, make([]byte, 12)...)
+		db = append(db, make([]byte, (ndbBlkSize-len(db)%ndbBlkSize)%ndbBlkSize)...)
+		// Leave the first slot after the header unused.
+		slot := db[ndbHeaderSize+(i+1)*ndbSlotSize:]
+		le.PutUint32(slot[4:], uint32(i+1))
+		le.PutUint32(slot[8:], uint32(blkOffset))
+		le.PutUint32(slot[12:], uint32((len(db)/ndbBlkSize)-blkOffset))
+	}
+	path := filepath.Join(t.TempDir(), "Packages.db")
+	if err := os.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, err := read(path, readNdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pkgs, testPackages) {
+		t.Errorf("read() = %+v, want %+v", pkgs, testPackages)
+	}
+
+	// A corrupt blob length must be refused before it is allocated.
+	slot := db[ndbHeaderSize+ndbSlotSize:]
+	head := db[int(le.Uint32(slot[8:]))*ndbBlkSize:]
+	le.PutUint32(slot[12:], 0xffffffff)
+	le.PutUint32(head[12:], 0xfffffff0)
+	if err := os.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readNdb(path); err == nil || !strings.Contains(err.Error(), "bytes") {
+		t.Errorf("readNdb() with an oversized blob error = %v, want a size error", err)
+	}
+
+	le.PutUint32(db[0:], 0)
+	if err := os.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readNdb(path); err == nil {
+		t.Error("readNdb() with a bad magic did not return an error")
+	}
+}
+
+func TestReadChangedDatabase(t *testing.T) {
+	oldAttempts := readAttempts
+	defer func() { readAttempts = oldAttempts }()
+	readAttempts = 2
+
+	path := filepath.Join(t.TempDir(), "Packages.db")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	blob := testHeader(testPackages[0])
+
+	// A write during the first read is retried.
+	var calls int
+	pkgs, err := read(path, func(string) ([][]byte, error) {
+		calls++
+		if calls == 1 {
+			if err := os.WriteFile(path, []byte("new contents"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			return nil, fmt.Errorf("torn read")
+		}
+		return [][]byte{blob}, nil
+	})
+	if err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	if calls != 2 || !reflect.DeepEqual(pkgs, testPackages[:1]) {
+		t.Errorf("read() = %+v after %d reads, want %+v after 2", pkgs, calls, testPackages[:1])
+	}
+
+	// A database that keeps changing is an error.
+	calls = 0
+	_, err = read(path, func(string) ([][]byte, error) {
+		calls++
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", calls)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return [][]byte{blob}, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("read() of a changing database error = %v, want a changed error", err)
+	}
+	if calls != readAttempts {
+		t.Errorf("read() read the database %d times, want %d", calls, readAttempts)
+	}
+}
+
+func TestReadBdb(t *testing.T) {
+	const pageSize = 512
+	for _, order := range []interface {
+		binary.ByteOrder
+		binary.AppendByteOrder
+	}{binary.LittleEndian, binary.BigEndian} {
+		t.Run(fmt.Sprint(order), func(t *testing.T) {
+			// Page 0 is the metadata, page 1 the hash page and the rest
+			// overflow pages.
+			pages := [][]byte{make([]byte, pageSize), make([]byte, pageSize)}
+			meta := pages[0]
+			order.PutUint32(meta[12:], bdbHashMagic)
+			order.PutUint32(meta[20:], pageSize)
+
+			type item struct {
+				data    []byte
+				offPage bool
+			}
+			// The package counter under key 0 is stored on the page, the
+			// first package is small enough to be too.
+			items := []item{{data: []byte{0, 0, 0, 0}}, {data: []byte{3, 0, 0, 0}}}
+			for i, pkg := range testPackages {
+				blob := testHeader(pkg)
+				items = append(items, item{data: order.AppendUint32(nil, uint32(i+1))}, item{data: blob, offPage: i > 0})
+			}
+
+			hash := pages[1]
+			hash[25] = bdbPageHash
+			order.PutUint16(hash[20:], uint16(len(items)))
+			end := pageSize
+			for i, it := range items {
+				var entry []byte
+				if it.offPage {
+					first := uint32(len(pages))
+					for rest := it.data; len(rest) > 0; {
+						n := len(rest)
+						if n > pageSize-bdbPageHeader {
+							n = pageSize - bdbPageHeader
+						}
+						page := make([]byte, pageSize)
+						page[25] = bdbPageOverflow
+						order.PutUint16(page[22:], uint16(n))
+						copy(page[bdbPageHeader:], rest[:n])
+						rest = rest[n:]
+						if len(rest) > 0 {
+							order.PutUint32(page[16:], uint32(len(pages)+1))
+						}
+						pages = append(pages, page)
+					}
+					entry = make([]byte, bdbHashOffPage)
+					entry[0] = bdbOffPage
+					order.PutUint32(entry[4:], first)
+					order.PutUint32(entry[8:], uint32(len(it.data)))
+				} else {
+					entry = append([]byte{bdbKeyData}, it.data...)
+				}
+				end -= len(entry)
+				copy(hash[end:], entry)
+				order.PutUint16(hash[bdbPageHeader+2*i:], uint16(end))
+			}
+			order.PutUint32(meta[32:], uint32(len(pages)-1))
+
+			path := filepath.Join(t.TempDir(), "Packages")
+			var db []byte
+			for _, p := range pages {
+				db = append(db, p...)
+			}
+			if err := os.WriteFile(path, db, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := read(path, readBdb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(pkgs, testPackages) {
+				t.Errorf("read() = %+v, want %+v", pkgs, testPackages)
+			}
+		})
+	}
+}
+
+func TestInstalled(t *testing.T) {
+	oldDirs := Dirs
+	defer func() { Dirs = oldDirs }()
+
+	Dirs = []string{t.TempDir()}
+	if _, err := Installed(); err != ErrNotFound {
+		t.Errorf("Installed() without a database error = %v, want %v", err, ErrNotFound)
+	}
+
+	Dirs = append(Dirs, "testdata")
+	pkgs, err := Installed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 44 {
+		t.Errorf("Installed() returned %d packages, want 44", len(pkgs))
+	}
+}
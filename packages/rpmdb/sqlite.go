@@ -0,0 +1,337 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package rpmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The sqlite file format, from https://www.sqlite.org/fileformat.html.
+const (
+	sqliteMagic      = "SQLite format 3\x00"
+	sqliteHeaderSize = 100
+	sqliteUTF8       = 1
+
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+
+	// sqliteMaxDepth bounds the b-tree depth so a corrupt database with a
+	// loop in it does not recurse forever.
+	sqliteMaxDepth = 32
+
+	// sqlitePackagesTable is the table rpm stores header blobs in, its
+	// schema is (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL).
+	sqlitePackagesTable = "Packages"
+)
+
+// readSqlite returns the header blobs of the packages in an rpmdb.sqlite.
+// The database is read as a file, committed transactions still in the
+// write ahead log would be missed so such a database is refused.
+func readSqlite(path string) ([][]byte, error) {
+	if fi, err := os.Stat(path + "-wal"); err == nil && fi.Size() > 0 {
+		return nil, fmt.Errorf("database has changes in its write ahead log")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hdr := make([]byte, sqliteHeaderSize)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("error reading header: %v", err)
+	}
+	if string(hdr[:len(sqliteMagic)]) != sqliteMagic {
+		return nil, fmt.Errorf("not a sqlite database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(hdr[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("bad page size %d", pageSize)
+	}
+	if enc := binary.BigEndian.Uint32(hdr[56:60]); enc != sqliteUTF8 {
+		return nil, fmt.Errorf("unsupported text encoding %d", enc)
+	}
+	db := &sqliteDB{r: f, pageSize: pageSize, usable: pageSize - int(hdr[20])}
+
+	root, err := db.tableRoot(sqlitePackagesTable)
+	if err != nil {
+		return nil, err
+	}
+	var blobs [][]byte
+	err = db.walkTable(root, 0, func(payload []byte) error {
+		cols, err := parseRecord(payload)
+		if err != nil {
+			return err
+		}
+		if len(cols) < 2 || !cols[1].isBlob() {
+			return fmt.Errorf("unexpected %s row", sqlitePackagesTable)
+		}
+		blobs = append(blobs, cols[1].value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+type sqliteDB struct {
+	r        io.ReaderAt
+	pageSize int
+	usable   int
+}
+
+// page reads page n, pages are numbered from 1.
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	if n == 0 {
+		return nil, fmt.Errorf("bad page number 0")
+	}
+	page := make([]byte, db.pageSize)
+	if _, err := db.r.ReadAt(page, int64(n-1)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("error reading page %d: %v", n, err)
+	}
+	return page, nil
+}
+
+// tableRoot returns the root page of table from the schema table.
+func (db *sqliteDB) tableRoot(table string) (uint32, error) {
+	var root int64
+	err := db.walkTable(1, 0, func(payload []byte) error {
+		// sqlite_schema(type, name, tbl_name, rootpage, sql)
+		cols, err := parseRecord(payload)
+		if err != nil {
+			return err
+		}
+		if len(cols) < 4 || string(cols[0].value) != "table" || string(cols[1].value) != table {
+			return nil
+		}
+		root, err = cols[3].int()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if root <= 0 {
+		return 0, fmt.Errorf("no %s table", table)
+	}
+	return uint32(root), nil
+}
+
+// walkTable calls fn with the payload of every row of the table b-tree
+// rooted at page n.
+func (db *sqliteDB) walkTable(n uint32, depth int, fn func([]byte) error) error {
+	if depth > sqliteMaxDepth {
+		return fmt.Errorf("b-tree is deeper than %d pages", sqliteMaxDepth)
+	}
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	hdr := 0
+	if n == 1 {
+		hdr = sqliteHeaderSize
+	}
+	cells := int(binary.BigEndian.Uint16(page[hdr+3 : hdr+5]))
+	cell := func(ptrs, i int) ([]byte, error) {
+		p := ptrs + 2*i
+		if p+2 > len(page) {
+			return nil, fmt.Errorf("bad cell count %d on page %d", cells, n)
+		}
+		off := int(binary.BigEndian.Uint16(page[p : p+2]))
+		if off < ptrs || off >= db.usable {
+			return nil, fmt.Errorf("bad cell offset %d on page %d", off, n)
+		}
+		return page[off:db.usable], nil
+	}
+
+	switch page[hdr] {
+	case sqliteLeafTable:
+		for i := 0; i < cells; i++ {
+			c, err := cell(hdr+8, i)
+			if err != nil {
+				return err
+			}
+			size, l1 := varint(c)
+			_, l2 := varint(c[l1:])
+			if l1 == 0 || l2 == 0 {
+				return fmt.Errorf("bad cell %d on page %d", i, n)
+			}
+			payload, err := db.payload(c[l1+l2:], size)
+			if err != nil {
+				return fmt.Errorf("cell %d on page %d: %v", i, n, err)
+			}
+			if err := fn(payload); err != nil {
+				return err
+			}
+		}
+	case sqliteInteriorTable:
+		for i := 0; i < cells; i++ {
+			c, err := cell(hdr+12, i)
+			if err != nil {
+				return err
+			}
+			if len(c) < 4 {
+				return fmt.Errorf("bad cell %d on page %d", i, n)
+			}
+			if err := db.walkTable(binary.BigEndian.Uint32(c[0:4]), depth+1, fn); err != nil {
+				return err
+			}
+		}
+		if err := db.walkTable(binary.BigEndian.Uint32(page[hdr+8:hdr+12]), depth+1, fn); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("page %d is not a table b-tree page", n)
+	}
+	return nil
+}
+
+// payload returns the size bytes of a cell payload, part of which is on
+// the page in local and the rest in a chain of overflow pages.
+func (db *sqliteDB) payload(local []byte, size uint64) ([]byte, error) {
+	u := uint64(db.usable)
+	maxLocal := u - 35
+	if size <= maxLocal {
+		if uint64(len(local)) < size {
+			return nil, fmt.Errorf("payload of %d bytes overruns the page", size)
+		}
+		return local[:size], nil
+	}
+	minLocal := (u-12)*32/255 - 23
+	onPage := minLocal + (size-minLocal)%(u-4)
+	if onPage > maxLocal {
+		onPage = minLocal
+	}
+	if uint64(len(local)) < onPage+4 {
+		return nil, fmt.Errorf("payload of %d bytes overruns the page", size)
+	}
+	if size > maxHeaderSize {
+		return nil, fmt.Errorf("payload of %d bytes", size)
+	}
+
+	out := make([]byte, 0, size)
+	out = append(out, local[:onPage]...)
+	next := binary.BigEndian.Uint32(local[onPage : onPage+4])
+	for uint64(len(out)) < size {
+		if next == 0 {
+			return nil, fmt.Errorf("overflow chain ends after %d of %d bytes", len(out), size)
+		}
+		page, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		n := size - uint64(len(out))
+		if n > u-4 {
+			n = u - 4
+		}
+		out = append(out, page[4:4+n]...)
+		next = binary.BigEndian.Uint32(page[0:4])
+	}
+	return out, nil
+}
+
+// varint decodes a sqlite variable length integer, it returns a length of
+// 0 if b is too short.
+func varint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// column is a value in a record and its serial type.
+type column struct {
+	serialType uint64
+	value      []byte
+}
+
+func (c column) isBlob() bool { return c.serialType >= 12 && c.serialType%2 == 0 }
+
+func (c column) int() (int64, error) {
+	switch c.serialType {
+	case 1, 2, 3, 4, 5, 6:
+		var v int64
+		for i, b := range c.value {
+			if i == 0 {
+				v = int64(int8(b))
+				continue
+			}
+			v = v<<8 | int64(b)
+		}
+		return v, nil
+	case 8:
+		return 0, nil
+	case 9:
+		return 1, nil
+	}
+	return 0, fmt.Errorf("serial type %d is not an integer", c.serialType)
+}
+
+// serialTypeSize returns the length of a value of serial type t.
+func serialTypeSize(t uint64) (uint64, error) {
+	switch {
+	case t <= 4:
+		return t, nil
+	case t == 5:
+		return 6, nil
+	case t == 6, t == 7:
+		return 8, nil
+	case t == 8, t == 9:
+		return 0, nil
+	case t >= 12:
+		return (t - 12) / 2, nil
+	}
+	return 0, fmt.Errorf("reserved serial type %d", t)
+}
+
+// parseRecord splits a record into its columns.
+func parseRecord(rec []byte) ([]column, error) {
+	hdrLen, n := varint(rec)
+	if n == 0 || hdrLen > uint64(len(rec)) {
+		return nil, fmt.Errorf("bad record header")
+	}
+	var cols []column
+	body := hdrLen
+	for pos := uint64(n); pos < hdrLen; {
+		t, n := varint(rec[pos:hdrLen])
+		if n == 0 {
+			return nil, fmt.Errorf("bad record header")
+		}
+		pos += uint64(n)
+		size, err := serialTypeSize(t)
+		if err != nil {
+			return nil, err
+		}
+		if body+size > uint64(len(rec)) {
+			return nil, fmt.Errorf("record column overruns the record")
+		}
+		cols = append(cols, column{serialType: t, value: rec[body : body+size]})
+		body += size
+	}
+	return cols, nil
+}
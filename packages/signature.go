@@ -0,0 +1,218 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// SignatureOptions are the signature verification options of deb and rpm
+// package artifacts.
+type SignatureOptions struct {
+	// Verify requires a valid signature before the package is installed.
+	Verify bool
+	// Keyrings are the keys the signature is verified against, the system
+	// keyrings are used if none are set.
+	Keyrings []string
+}
+
+var (
+	// debSystemKeyrings are the keyrings deb package signatures are verified
+	// against when no keyring is set. Only the debsig origin keyrings are
+	// used, the apt keys are trusted to sign repositories, not packages.
+	debSystemKeyrings = []string{
+		"/usr/share/debsig/keyrings/*/*.gpg",
+	}
+
+	// maxDebSignatureSize bounds the _gpgorigin member that is read into
+	// memory.
+	maxDebSignatureSize int64 = 64 * 1024
+
+	rpmCheckSigArgs = []string{"--checksig"}
+)
+
+// errUnsigned is returned for a package that carries no signature.
+var errUnsigned = errors.New("package is not signed")
+
+func readKeyring(path string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if el, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data)); err == nil {
+		return el, nil
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+type debMember struct {
+	name         string
+	offset, size int64
+}
+
+// debMembers lists the members of the ar archive of a deb package in order,
+// their contents are not read.
+func debMembers(r io.ReaderAt, size int64) ([]debMember, error) {
+	magic := make([]byte, 8)
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != "!<arch>\n" {
+		return nil, errors.New("not a deb package")
+	}
+	var members []debMember
+	hdr := make([]byte, 60)
+	for off := int64(8); off < size; {
+		if _, err := r.ReadAt(hdr, off); err != nil {
+			return nil, fmt.Errorf("error reading deb package: %v", err)
+		}
+		m := debMember{name: strings.TrimSuffix(strings.TrimSpace(string(hdr[:16])), "/"), offset: off + 60}
+		var err error
+		m.size, err = strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || m.size < 0 || m.offset+m.size > size || string(hdr[58:60]) != "`\n" {
+			return nil, errors.New("invalid deb package member header")
+		}
+		members = append(members, m)
+		// Members are padded to an even size.
+		off = m.offset + m.size + m.size%2
+	}
+	return members, nil
+}
+
+// VerifyDebSignature verifies the debsigs origin signature of the deb
+// package at path against keyrings, or the system keyrings if none are set.
+// The origin signature is a detached signature over the debian-binary,
+// control and data members of the package.
+func VerifyDebSignature(path string, keyrings []string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	members, err := debMembers(f, fi.Size())
+	if err != nil {
+		return err
+	}
+	// The signed members are streamed from the file, they can be too large
+	// to hold in memory.
+	var sig []byte
+	var signed []*io.SectionReader
+	for _, m := range members {
+		switch {
+		case m.name == "_gpgorigin":
+			if m.size > maxDebSignatureSize {
+				return fmt.Errorf("package signature greater than %dK", maxDebSignatureSize/1024)
+			}
+			sig = make([]byte, m.size)
+			if _, err := f.ReadAt(sig, m.offset); err != nil {
+				return fmt.Errorf("error reading package signature: %v", err)
+			}
+		case m.name == "debian-binary", strings.HasPrefix(m.name, "control.tar"), strings.HasPrefix(m.name, "data.tar"):
+			signed = append(signed, io.NewSectionReader(f, m.offset, m.size))
+		}
+	}
+	if sig == nil {
+		return errUnsigned
+	}
+	// signedData returns the signed members from the start, the signature is
+	// checked in both armored and binary form.
+	signedData := func() io.Reader {
+		readers := make([]io.Reader, len(signed))
+		for i, r := range signed {
+			readers[i] = io.NewSectionReader(r, 0, r.Size())
+		}
+		return io.MultiReader(readers...)
+	}
+
+	system := len(keyrings) == 0
+	if system {
+		for _, pattern := range debSystemKeyrings {
+			matches, _ := filepath.Glob(pattern)
+			keyrings = append(keyrings, matches...)
+		}
+	}
+	var keys openpgp.EntityList
+	for _, k := range keyrings {
+		el, err := readKeyring(k)
+		if err != nil {
+			// An unreadable system keyring does not prevent verification
+			// against the others.
+			if system {
+				continue
+			}
+			return fmt.Errorf("error reading keyring %q: %v", k, err)
+		}
+		keys = append(keys, el...)
+	}
+	if len(keys) == 0 {
+		return errors.New("no keys to verify the package signature against")
+	}
+
+	if _, err := openpgp.CheckArmoredDetachedSignature(keys, signedData(), bytes.NewReader(sig)); err == nil {
+		return nil
+	}
+	if _, err := openpgp.CheckDetachedSignature(keys, signedData(), bytes.NewReader(sig)); err != nil {
+		return fmt.Errorf("invalid package signature: %v", err)
+	}
+	return nil
+}
+
+// VerifyRPMSignature verifies the signature of the rpm package at path with
+// rpm. Without keyrings the keys imported into the rpm database are used,
+// otherwise only the armored keys in keyrings, imported into a temporary
+// rpm database.
+func VerifyRPMSignature(ctx context.Context, path string, keyrings []string) error {
+	args := rpmCheckSigArgs
+	if len(keyrings) > 0 {
+		dbPath, err := os.MkdirTemp("", "osconfig_rpmdb_")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dbPath)
+		if _, err := run(ctx, rpm, append([]string{"--dbpath", dbPath, "--import"}, keyrings...)); err != nil {
+			return err
+		}
+		args = append([]string{"--dbpath", dbPath}, rpmCheckSigArgs...)
+	}
+	out, err := run(ctx, rpm, append(args, path))
+	if err != nil {
+		return fmt.Errorf("invalid package signature: %v", err)
+	}
+	return parseRPMCheckSig(out)
+}
+
+// parseRPMCheckSig checks the output of rpm --checksig for a verified
+// signature, rpm exits 0 for packages that only carry digests.
+func parseRPMCheckSig(out []byte) error {
+	s := strings.ToLower(string(out))
+	switch {
+	case strings.Contains(s, "not ok"):
+		return fmt.Errorf("invalid package signature: %s", bytes.TrimSpace(out))
+	case strings.Contains(s, "signatures ok"), strings.Contains(s, " pgp "), strings.Contains(s, " gpg "):
+		return nil
+	}
+	return errUnsigned
+}
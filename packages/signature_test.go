@@ -0,0 +1,203 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func writeAr(t *testing.T, path string, names []string, members map[string][]byte) {
+	t.Helper()
+	var b bytes.Buffer
+	b.WriteString("!<arch>\n")
+	for _, name := range names {
+		data := members[name]
+		fmt.Fprintf(&b, "%-16s%-12s%-6s%-6s%-8s%-10d`\n", name, "0", "0", "0", "100644", len(data))
+		b.Write(data)
+		if len(data)%2 == 1 {
+			b.WriteByte('\n')
+		}
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeKeyring(t *testing.T, path string, e *openpgp.Entity) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := armor.Encode(f, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyDebSignature(t *testing.T) {
+	dir := t.TempDir()
+	signer, err := openpgp.NewEntity("signer", "", "signer@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerKeyring := filepath.Join(dir, "signer.asc")
+	writeKeyring(t, signerKeyring, signer)
+	otherKeyring := filepath.Join(dir, "other.asc")
+	writeKeyring(t, otherKeyring, other)
+
+	names := []string{"debian-binary", "control.tar.xz", "data.tar.xz"}
+	members := map[string][]byte{
+		"debian-binary":  []byte("2.0\n"),
+		"control.tar.xz": []byte("control"),
+		"data.tar.xz":    []byte("data"),
+	}
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, signer, bytes.NewReader([]byte("2.0\ncontroldata")), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := filepath.Join(dir, "unsigned.deb")
+	writeAr(t, unsigned, names, members)
+
+	members["_gpgorigin"] = sig.Bytes()
+	signed := filepath.Join(dir, "signed.deb")
+	writeAr(t, signed, append(names, "_gpgorigin"), members)
+
+	members["data.tar.xz"] = []byte("tampered")
+	tampered := filepath.Join(dir, "tampered.deb")
+	writeAr(t, tampered, append(names, "_gpgorigin"), members)
+
+	tests := []struct {
+		name     string
+		path     string
+		keyrings []string
+		wantErr  bool
+	}{
+		{"valid", signed, []string{signerKeyring}, false},
+		{"valid with several keyrings", signed, []string{otherKeyring, signerKeyring}, false},
+		{"wrong key", signed, []string{otherKeyring}, true},
+		{"tampered", tampered, []string{signerKeyring}, true},
+		{"unsigned", unsigned, []string{signerKeyring}, true},
+		{"missing keyring", signed, []string{filepath.Join(dir, "missing.asc")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyDebSignature(tt.path, tt.keyrings); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyDebSignature() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := VerifyDebSignature(unsigned, []string{signerKeyring}); err != errUnsigned {
+		t.Errorf("VerifyDebSignature() on an unsigned package = %v, want %v", err, errUnsigned)
+	}
+
+	// A member that claims to extend past the end of the file.
+	data, err := os.ReadFile(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.deb")
+	if err := os.WriteFile(truncated, data[:len(data)-10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDebSignature(truncated, []string{signerKeyring}); err == nil || !strings.Contains(err.Error(), "invalid deb package member header") {
+		t.Errorf("VerifyDebSignature() on a truncated package = %v, want invalid header", err)
+	}
+
+	oldMax := maxDebSignatureSize
+	maxDebSignatureSize = 16
+	if err := VerifyDebSignature(signed, []string{signerKeyring}); err == nil || !strings.Contains(err.Error(), "signature greater than") {
+		t.Errorf("VerifyDebSignature() with an oversized signature = %v, want a size error", err)
+	}
+	maxDebSignatureSize = oldMax
+
+	// Without keyrings only the debsig keyrings are trusted.
+	oldSystem := debSystemKeyrings
+	defer func() { debSystemKeyrings = oldSystem }()
+	debSystemKeyrings = []string{filepath.Join(dir, "debsig", "*", "*.gpg")}
+	if err := VerifyDebSignature(signed, nil); err == nil || !strings.Contains(err.Error(), "no keys") {
+		t.Errorf("VerifyDebSignature() without debsig keyrings = %v, want no keys error", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "debsig", "signer"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeKeyring(t, filepath.Join(dir, "debsig", "signer", "signer.gpg"), signer)
+	if err := VerifyDebSignature(signed, nil); err != nil {
+		t.Errorf("VerifyDebSignature() with a debsig keyring = %v, want nil", err)
+	}
+}
+
+func TestParseRPMCheckSig(t *testing.T) {
+	tests := []struct {
+		out     string
+		wantErr bool
+	}{
+		{"/tmp/foo.rpm: digests signatures OK\n", false},
+		{"/tmp/foo.rpm: rsa sha1 (md5) pgp md5 OK\n", false},
+		{"/tmp/foo.rpm: digests SIGNATURES NOT OK\n", true},
+		{"/tmp/foo.rpm: digests OK\n", true},
+		{"/tmp/foo.rpm: sha1 md5 OK\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			if err := parseRPMCheckSig([]byte(tt.out)); (err != nil) != tt.wantErr {
+				t.Errorf("parseRPMCheckSig(%q) error = %v, wantErr %t", tt.out, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRPMSignature(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	SetCommandRunner(mockCommandRunner)
+
+	expectedCmd := exec.CommandContext(testCtx, rpm, "--checksig", "/tmp/foo.rpm")
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(expectedCmd)).Return([]byte("/tmp/foo.rpm: digests signatures OK\n"), []byte(""), nil).Times(1)
+	if err := VerifyRPMSignature(testCtx, "/tmp/foo.rpm", nil); err != nil {
+		t.Errorf("VerifyRPMSignature(): %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(expectedCmd)).Return([]byte("/tmp/foo.rpm: digests OK\n"), []byte(""), nil).Times(1)
+	if err := VerifyRPMSignature(testCtx, "/tmp/foo.rpm", nil); err == nil {
+		t.Error("VerifyRPMSignature() on an unsigned package did not return an error")
+	}
+}
@@ -0,0 +1,63 @@
+//  Copyright 2019 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package packages
+
+import (
+	"context"
+	"errors"
+)
+
+// InstallMSIPackage is a linux stub function.
+func InstallMSIPackage(_ context.Context, _ string, _ []string) error {
+	return nil
+}
+
+// MSIInfo is a linux stub function.
+func MSIInfo(_ string) (string, string, error) {
+	return "", "", nil
+}
+
+// MSIInstalled is a linux stub function.
+func MSIInstalled(_ string) (bool, error) {
+	return false, nil
+}
+
+// InstallMSUPackage is a linux stub function.
+func InstallMSUPackage(_ context.Context, _ string) error {
+	return nil
+}
+
+// InstallCABPackage is a linux stub function.
+func InstallCABPackage(_ context.Context, _ string) error {
+	return nil
+}
+
+// HotFixInstalled is a linux stub function.
+func HotFixInstalled(_ context.Context, _ string) (bool, error) {
+	return false, nil
+}
+
+// DISMComponentCleanup is a linux stub function.
+func DISMComponentCleanup(_ context.Context) ([]byte, error) {
+	return nil, nil
+}
+
+// DownloadCatalogUpdate is a linux stub function.
+func DownloadCatalogUpdate(_ context.Context, _, _ string) ([]string, error) {
+	return nil, errors.New("the Microsoft Update Catalog is only supported on Windows")
+}
@@ -0,0 +1,154 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/progress"
+)
+
+// Operation classes that can be given their own timeout with
+// SetOperationTimeouts.
+const (
+	// OpRefresh is a repository metadata refresh, e.g. apt-get update.
+	OpRefresh = "refresh"
+	// OpResolve is dependency resolution, including listing updates and the
+	// start of an install before anything is downloaded.
+	OpResolve = "resolve"
+	// OpDownload is retrieving packages during an install.
+	OpDownload = "download"
+	// OpInstall is applying a transaction once packages are downloaded.
+	OpInstall = "install"
+)
+
+var (
+	opTimeoutsMx sync.RWMutex
+	opTimeouts   map[string]time.Duration
+)
+
+// SetOperationTimeouts sets the timeouts of apt, yum, zypper and googet
+// operations. Keys are either an operation class, e.g. "download", or a
+// manager and class, e.g. "apt.download", which takes precedence. Classes
+// without a timeout are only bound by the caller's context. GooGet list
+// commands use the resolve timeout, installs and removals the install
+// timeout. The resolve timeout of a manager also bounds its query when
+// listing installed packages and updates.
+func SetOperationTimeouts(timeouts map[string]time.Duration) {
+	opTimeoutsMx.Lock()
+	defer opTimeoutsMx.Unlock()
+	opTimeouts = timeouts
+}
+
+func operationTimeout(manager, class string) time.Duration {
+	opTimeoutsMx.RLock()
+	defer opTimeoutsMx.RUnlock()
+	if d, ok := opTimeouts[manager+"."+class]; ok {
+		return d
+	}
+	return opTimeouts[class]
+}
+
+// withOperationTimeout bounds ctx by the timeout of class for manager.
+func withOperationTimeout(ctx context.Context, manager, class string) (context.Context, context.CancelFunc) {
+	if d := operationTimeout(manager, class); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return ctx, func() {}
+}
+
+// phaseClass maps a progress phase to the operation class timing it.
+func phaseClass(phase string) string {
+	switch phase {
+	case progress.PhaseDownloading:
+		return OpDownload
+	case progress.PhaseApplying, progress.PhaseVerifying:
+		return OpInstall
+	}
+	return OpResolve
+}
+
+// phaseWatchdog cancels a command that spends longer than its timeout in
+// the current phase. Each phase gets a fresh timer so a slow mirror only
+// counts against the download timeout, and a transaction that has started
+// applying is only stopped by the install timeout.
+type phaseWatchdog struct {
+	manager string
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	class   string
+	timer   *time.Timer
+	expired string
+}
+
+func newPhaseWatchdog(ctx context.Context, manager string) (context.Context, *phaseWatchdog) {
+	w := &phaseWatchdog{manager: manager, cancel: func() {}}
+	for _, class := range []string{OpResolve, OpDownload, OpInstall} {
+		if operationTimeout(manager, class) > 0 {
+			ctx, w.cancel = context.WithCancel(ctx)
+			break
+		}
+	}
+	w.enter(OpResolve)
+	return ctx, w
+}
+
+// phase records that the command reported phase.
+func (w *phaseWatchdog) phase(phase string) {
+	w.enter(phaseClass(phase))
+}
+
+func (w *phaseWatchdog) enter(class string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if class == w.class || w.expired != "" {
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.class = class
+	d := operationTimeout(w.manager, class)
+	if d <= 0 {
+		return
+	}
+	w.timer = time.AfterFunc(d, func() {
+		w.mu.Lock()
+		if w.class == class {
+			w.expired = fmt.Sprintf("%s %s phase exceeded its %s timeout", w.manager, class, d)
+		}
+		w.mu.Unlock()
+		w.cancel()
+	})
+}
+
+// stop releases the watchdog, err is annotated if a phase timed out.
+func (w *phaseWatchdog) stop(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.cancel()
+	if err != nil && w.expired != "" {
+		return fmt.Errorf("%s: %w", w.expired, err)
+	}
+	return err
+}
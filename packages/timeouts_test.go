@@ -0,0 +1,78 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/progress"
+)
+
+func TestOperationTimeout(t *testing.T) {
+	SetOperationTimeouts(map[string]time.Duration{"download": time.Minute, "apt.download": time.Hour})
+	defer SetOperationTimeouts(nil)
+
+	if got := operationTimeout("apt", OpDownload); got != time.Hour {
+		t.Errorf("apt download timeout = %v, want %v", got, time.Hour)
+	}
+	if got := operationTimeout("yum", OpDownload); got != time.Minute {
+		t.Errorf("yum download timeout = %v, want %v", got, time.Minute)
+	}
+	if got := operationTimeout("yum", OpInstall); got != 0 {
+		t.Errorf("yum install timeout = %v, want 0", got)
+	}
+	if ctx, cancel := withOperationTimeout(testCtx, "yum", OpInstall); ctx != testCtx {
+		t.Errorf("withOperationTimeout without a timeout should return the same context")
+	} else {
+		cancel()
+	}
+}
+
+func TestPhaseWatchdog(t *testing.T) {
+	ctx, w := newPhaseWatchdog(testCtx, "apt")
+	if ctx != testCtx {
+		t.Errorf("newPhaseWatchdog without timeouts should return the same context")
+	}
+	if err := w.stop(nil); err != nil {
+		t.Errorf("stop() = %v, want nil", err)
+	}
+
+	SetOperationTimeouts(map[string]time.Duration{"download": 50 * time.Millisecond, "install": time.Hour})
+	defer SetOperationTimeouts(nil)
+
+	// Moving on to the install phase stops the download timer.
+	ctx, w = newPhaseWatchdog(testCtx, "apt")
+	w.phase(progress.PhaseDownloading)
+	w.phase(progress.PhaseApplying)
+	time.Sleep(100 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Errorf("context canceled after leaving the download phase: %v", ctx.Err())
+	}
+	w.stop(nil)
+
+	ctx, w = newPhaseWatchdog(testCtx, "apt")
+	w.phase(progress.PhaseDownloading)
+	<-ctx.Done()
+	err := w.stop(errors.New("signal: killed"))
+	if err == nil || !strings.Contains(err.Error(), "apt download phase exceeded its 50ms timeout") {
+		t.Errorf("stop() = %v, want download timeout error", err)
+	}
+	if err := w.stop(nil); err != nil {
+		t.Errorf("stop(nil) = %v, want nil", err)
+	}
+}
@@ -0,0 +1,188 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+var (
+	yumHistoryArgs = []string{"history", "list"}
+	// zypper has no transaction ids, every change is appended to its
+	// history log instead.
+	zyppHistoryFile = "/var/log/zypp/history"
+	// maxZyppHistoryEntries bounds the entries kept for a single operation.
+	maxZyppHistoryEntries = 500
+
+	yumHistoryRow = regexp.MustCompile(`^\s*(\d+)\s*\|(.*)$`)
+)
+
+// Transaction is a package manager transaction made by an operation, it
+// can be inspected or undone with the package manager's own tools, for yum
+// and dnf with `yum history info|undo ID`.
+type Transaction struct {
+	Manager string `json:"manager"`
+	ID      string `json:"id,omitempty"`
+	// Summary is the yum history row of the transaction.
+	Summary string `json:"summary,omitempty"`
+	// Entries are the zypper history log entries written by the operation.
+	Entries []string `json:"entries,omitempty"`
+}
+
+// TransactionMark records the package manager history position before an
+// operation, Transactions returns the transactions made since.
+type TransactionMark struct {
+	yumID      int
+	yumOK      bool
+	zyppOffset int64
+	zyppOK     bool
+}
+
+// parseYumHistory parses the output of yum history list, newest first.
+func parseYumHistory(data []byte) []*Transaction {
+	/*
+	   ID     | Command line             | Date and time    | Action(s)      | Altered
+	   -------------------------------------------------------------------------------
+	        5 | install -y nginx         | 2024-05-01 10:00 | Install        |    3
+	        4 | update -y                | 2024-04-30 09:12 | I, U           |   12 EE
+	*/
+	var txs []*Transaction
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		m := yumHistoryRow.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		fields := strings.Split(m[2], "|")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		txs = append(txs, &Transaction{Manager: "yum", ID: m[1], Summary: strings.Join(fields, " | ")})
+	}
+	return txs
+}
+
+func yumHistory(ctx context.Context) ([]*Transaction, error) {
+	out, err := run(ctx, yum, yumHistoryArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseYumHistory(out), nil
+}
+
+// MarkTransactions records the current yum transaction id and zypper
+// history position.
+func MarkTransactions(ctx context.Context) *TransactionMark {
+	m := &TransactionMark{}
+	if YumExists {
+		txs, err := yumHistory(ctx)
+		if err != nil {
+			clog.Debugf(ctx, "Error reading yum history: %v", err)
+		} else {
+			m.yumOK = true
+			if len(txs) > 0 {
+				m.yumID, _ = strconv.Atoi(txs[0].ID)
+			}
+		}
+	}
+	if ZypperExists {
+		fi, err := os.Stat(zyppHistoryFile)
+		switch {
+		case err == nil:
+			m.zyppOffset, m.zyppOK = fi.Size(), true
+		case os.IsNotExist(err):
+			m.zyppOK = true
+		default:
+			clog.Debugf(ctx, "Error reading zypper history: %v", err)
+		}
+	}
+	return m
+}
+
+// readZyppHistory returns the entries written to the zypper history log
+// after offset, comment lines are skipped.
+func readZyppHistory(offset int64) ([]string, error) {
+	f, err := os.Open(zyppHistoryFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// The log was rotated.
+	if fi.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		ln := strings.TrimSpace(scanner.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		if len(entries) == maxZyppHistoryEntries {
+			break
+		}
+		entries = append(entries, ln)
+	}
+	return entries, scanner.Err()
+}
+
+// Transactions returns the transactions made since m was taken, oldest
+// first.
+func (m *TransactionMark) Transactions(ctx context.Context) []*Transaction {
+	if m == nil {
+		return nil
+	}
+	var txs []*Transaction
+	if m.yumOK {
+		all, err := yumHistory(ctx)
+		if err != nil {
+			clog.Debugf(ctx, "Error reading yum history: %v", err)
+		}
+		for i := len(all) - 1; i >= 0; i-- {
+			if id, _ := strconv.Atoi(all[i].ID); id > m.yumID {
+				txs = append(txs, all[i])
+			}
+		}
+	}
+	if m.zyppOK {
+		entries, err := readZyppHistory(m.zyppOffset)
+		if err != nil {
+			clog.Debugf(ctx, "Error reading zypper history: %v", err)
+		}
+		if len(entries) > 0 {
+			txs = append(txs, &Transaction{Manager: "zypper", Entries: entries})
+		}
+	}
+	return txs
+}
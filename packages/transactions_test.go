@@ -0,0 +1,90 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseYumHistory(t *testing.T) {
+	data := []byte(`Loaded plugins: fastestmirror
+ID     | Command line             | Date and time    | Action(s)      | Altered
+-------------------------------------------------------------------------------
+     5 | install -y nginx         | 2024-05-01 10:00 | Install        |    3
+     4 | update -y                | 2024-04-30 09:12 | I, U           |   12 EE
+history list
+`)
+	want := []*Transaction{
+		{Manager: "yum", ID: "5", Summary: "install -y nginx | 2024-05-01 10:00 | Install | 3"},
+		{Manager: "yum", ID: "4", Summary: "update -y | 2024-04-30 09:12 | I, U | 12 EE"},
+	}
+	if got := parseYumHistory(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseYumHistory() = %+v, want %+v", got, want)
+	}
+	if got := parseYumHistory([]byte("No transactions\n")); got != nil {
+		t.Errorf("parseYumHistory() = %+v, want nil", got)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	oldYum, oldZypper, oldFile := YumExists, ZypperExists, zyppHistoryFile
+	defer func() { YumExists, ZypperExists, zyppHistoryFile = oldYum, oldZypper, oldFile }()
+	YumExists, ZypperExists = true, true
+	zyppHistoryFile = filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(zyppHistoryFile, []byte("# 2024-04-30 09:00:00 zypper -n up\n2024-04-30 09:00:01|install|vim|9.0-1|x86_64||repo-oss|abc|\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(yum, yumHistoryArgs...))
+	before := []byte("ID | Command line | Date and time | Action(s) | Altered\n---\n 4 | update -y | 2024-04-30 09:12 | I, U | 12\n")
+	after := []byte("ID | Command line | Date and time | Action(s) | Altered\n---\n 6 | install bar | 2024-05-01 10:01 | Install | 1\n 5 | install foo | 2024-05-01 10:00 | Install | 1\n 4 | update -y | 2024-04-30 09:12 | I, U | 12\n")
+
+	first := mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(before, nil, nil).Times(1)
+	mark := MarkTransactions(testCtx)
+
+	f, err := os.OpenFile(zyppHistoryFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("# 2024-05-01 10:02:00 zypper -n in nginx\n2024-05-01 10:02:01|install|nginx|1.21-1|x86_64||repo-oss|def|\n")
+	f.Close()
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(after, nil, nil).After(first).Times(1)
+	want := []*Transaction{
+		{Manager: "yum", ID: "5", Summary: "install foo | 2024-05-01 10:00 | Install | 1"},
+		{Manager: "yum", ID: "6", Summary: "install bar | 2024-05-01 10:01 | Install | 1"},
+		{Manager: "zypper", Entries: []string{"2024-05-01 10:02:01|install|nginx|1.21-1|x86_64||repo-oss|def|"}},
+	}
+	if got := mark.Transactions(testCtx); !reflect.DeepEqual(got, want) {
+		t.Errorf("Transactions() = %+v, want %+v", got, want)
+	}
+
+	var nilMark *TransactionMark
+	if got := nilMark.Transactions(testCtx); got != nil {
+		t.Errorf("nil mark Transactions() = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,146 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	debsums string
+
+	// Modification times change without the content changing, so they are
+	// not reported.
+	rpmVerifyArgs = []string{"--verify", "--nomtime"}
+	debsumsArgs   = []string{"--all", "--changed"}
+
+	// debsums: missing file /usr/bin/foo (from foo package)
+	debsumsMissingRE = regexp.MustCompile(`^debsums: missing file (/.+) \(from \S+ package\)$`)
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		debsums = "/usr/bin/debsums"
+	}
+	DebsumsExists = util.Exists(debsums)
+}
+
+// VerifyFinding describes a file of an installed package that no longer
+// matches the package.
+type VerifyFinding struct {
+	Package string `json:"package"`
+	Path    string `json:"path"`
+	// Config is set for configuration files, which are expected to be
+	// changed by administrators.
+	Config bool `json:"config,omitempty"`
+	// Problem is "missing", "modified" or, for rpm, the verify flags such
+	// as "S.5......".
+	Problem string `json:"problem"`
+}
+
+// runVerify runs a verify command, these exit non zero when a file does
+// not match so only failing to run the command is an error.
+func runVerify(ctx context.Context, cmd string, args []string) ([]byte, []byte, error) {
+	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, cmd, args...))
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok || ctx.Err() != nil {
+			return nil, nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", cmd, args, err, stdout, stderr)
+		}
+	}
+	return stdout, stderr, nil
+}
+
+// parseRPMVerify parses the output of rpm --verify for a single package.
+func parseRPMVerify(name string, data []byte) []*VerifyFinding {
+	/*
+	   S.5......  c /etc/ssh/sshd_config
+	   ..5......    /usr/bin/ssh
+	   missing     /usr/share/doc/openssh/README
+	*/
+	var findings []*VerifyFinding
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		ln := scanner.Text()
+		i := strings.Index(ln, " /")
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(ln[:i])
+		if len(fields) == 0 {
+			continue
+		}
+		findings = append(findings, &VerifyFinding{
+			Package: name,
+			Path:    ln[i+1:],
+			Config:  len(fields) > 1 && fields[1] == "c",
+			Problem: fields[0],
+		})
+	}
+	return findings
+}
+
+// VerifyRPMPackage compares the files of an installed rpm package with the
+// package database.
+func VerifyRPMPackage(ctx context.Context, name string) ([]*VerifyFinding, error) {
+	stdout, _, err := runVerify(ctx, rpm, append(rpmVerifyArgs, name))
+	if err != nil {
+		return nil, err
+	}
+	return parseRPMVerify(name, stdout), nil
+}
+
+// parseDebsums parses the output of debsums --changed for a single package,
+// changed files are printed to stdout and missing files to stderr.
+func parseDebsums(name string, stdout, stderr []byte) []*VerifyFinding {
+	var findings []*VerifyFinding
+	for _, ln := range strings.Split(string(stdout), "\n") {
+		ln = strings.TrimSpace(ln)
+		if !strings.HasPrefix(ln, "/") {
+			continue
+		}
+		findings = append(findings, &VerifyFinding{Package: name, Path: ln, Problem: "modified"})
+	}
+	for _, ln := range strings.Split(string(stderr), "\n") {
+		m := debsumsMissingRE.FindStringSubmatch(strings.TrimSpace(ln))
+		if m == nil {
+			continue
+		}
+		findings = append(findings, &VerifyFinding{Package: name, Path: m[1], Problem: "missing"})
+	}
+	// debsums does not say which files are conffiles, they all live in /etc.
+	for _, f := range findings {
+		f.Config = strings.HasPrefix(f.Path, "/etc/")
+	}
+	return findings
+}
+
+// VerifyDebPackage compares the files of an installed deb package with
+// their recorded checksums.
+func VerifyDebPackage(ctx context.Context, name string) ([]*VerifyFinding, error) {
+	stdout, stderr, err := runVerify(ctx, debsums, append(debsumsArgs, name))
+	if err != nil {
+		return nil, err
+	}
+	return parseDebsums(name, stdout, stderr), nil
+}
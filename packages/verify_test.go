@@ -0,0 +1,106 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseRPMVerify(t *testing.T) {
+	data := []byte("S.5......  c /etc/ssh/sshd_config\n..5......    /usr/bin/ssh\nmissing     /usr/share/doc/openssh/README file\nUnsatisfied dependencies for openssh: foo")
+	want := []*VerifyFinding{
+		{Package: "openssh", Path: "/etc/ssh/sshd_config", Config: true, Problem: "S.5......"},
+		{Package: "openssh", Path: "/usr/bin/ssh", Problem: "..5......"},
+		{Package: "openssh", Path: "/usr/share/doc/openssh/README file", Problem: "missing"},
+	}
+	if got := parseRPMVerify("openssh", data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRPMVerify() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseDebsums(t *testing.T) {
+	stdout := []byte("/etc/ssh/sshd_config\n/usr/sbin/sshd\n")
+	stderr := []byte("debsums: missing file /usr/share/doc/openssh-server/README (from openssh-server package)\nsomething else\n")
+	want := []*VerifyFinding{
+		{Package: "openssh-server", Path: "/etc/ssh/sshd_config", Config: true, Problem: "modified"},
+		{Package: "openssh-server", Path: "/usr/sbin/sshd", Problem: "modified"},
+		{Package: "openssh-server", Path: "/usr/share/doc/openssh-server/README", Problem: "missing"},
+	}
+	if got := parseDebsums("openssh-server", stdout, stderr); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDebsums() = %+v, want %+v", got, want)
+	}
+}
+
+func TestVerifyRPMPackage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(rpm, append(rpmVerifyArgs, "openssh")...))
+
+	// rpm --verify exits 1 when a file does not match.
+	errExit1 := exec.Command("/bin/bash", "-c", "exit 1").Run()
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("..5......    /usr/bin/ssh"), nil, errExit1).Times(1)
+	got, err := VerifyRPMPackage(testCtx, "openssh")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*VerifyFinding{{Package: "openssh", Path: "/usr/bin/ssh", Problem: "..5......"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VerifyRPMPackage() = %+v, want %+v", got, want)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(nil, nil, errors.New("not found")).Times(1)
+	if _, err := VerifyRPMPackage(testCtx, "openssh"); err == nil {
+		t.Errorf("did not get expected error")
+	}
+
+	// A command killed by the context is an error, its output is partial.
+	ctx, cancel := context.WithCancel(testCtx)
+	cancel()
+	mockCommandRunner.EXPECT().Run(ctx, gomock.Any()).Return(nil, nil, errExit1).Times(1)
+	if _, err := VerifyRPMPackage(ctx, "openssh"); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestVerifyDebPackage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(debsums, append(debsumsArgs, "openssh-server")...))
+
+	// debsums exits 2 when a file does not match.
+	errExit2 := exec.Command("/bin/bash", "-c", "exit 2").Run()
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("/usr/sbin/sshd\n"), nil, errExit2).Times(1)
+	got, err := VerifyDebPackage(testCtx, "openssh-server")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*VerifyFinding{{Package: "openssh-server", Path: "/usr/sbin/sshd", Problem: "modified"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VerifyDebPackage() = %+v, want %+v", got, want)
+	}
+}
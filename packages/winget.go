@@ -0,0 +1,269 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+	"golang.org/x/text/width"
+)
+
+var (
+	winget string
+
+	// winget has no machine readable output, the tables it prints are
+	// parsed instead. Source agreements are accepted so that a first run as
+	// SYSTEM does not stop at the msstore prompt.
+	wingetListArgs    = []string{"list", "--accept-source-agreements", "--disable-interactivity"}
+	wingetUpgradeArgs = []string{"upgrade", "--accept-source-agreements", "--disable-interactivity"}
+	wingetInstallArgs = []string{"install", "--exact", "--silent", "--accept-package-agreements", "--accept-source-agreements", "--disable-interactivity", "--id"}
+	wingetUpdateArgs  = []string{"upgrade", "--exact", "--silent", "--accept-package-agreements", "--accept-source-agreements", "--disable-interactivity", "--id"}
+	wingetRemoveArgs  = []string{"uninstall", "--exact", "--silent", "--accept-source-agreements", "--disable-interactivity", "--id"}
+
+	// wingetNoChangeCodes are the HRESULTs winget exits with when a package
+	// is already in the requested state.
+	wingetNoChangeCodes = []uint32{
+		0x8A15002B, // APPINSTALLER_CLI_ERROR_UPDATE_NOT_APPLICABLE
+		0x8A150061, // APPINSTALLER_CLI_ERROR_PACKAGE_ALREADY_INSTALLED
+	}
+)
+
+func init() {
+	if runtime.GOOS != "windows" {
+		return
+	}
+	winget = findWinget()
+	WingetExists = winget != ""
+}
+
+// findWinget returns the newest winget installed with App Installer. The
+// WindowsApps alias in the user profile does not exist for SYSTEM, so the
+// package directory is searched instead.
+func findWinget() string {
+	matches, _ := filepath.Glob(filepath.Join(os.Getenv("ProgramFiles"), "WindowsApps", "Microsoft.DesktopAppInstaller_*_x64__8wekyb3d8bbwe", "winget.exe"))
+	sortWingetDirs(matches)
+	for i := len(matches) - 1; i >= 0; i-- {
+		if util.Exists(matches[i]) {
+			return matches[i]
+		}
+	}
+	return ""
+}
+
+// sortWingetDirs sorts App Installer paths by the package version in their
+// directory name, oldest first.
+func sortWingetDirs(paths []string) {
+	sort.SliceStable(paths, func(i, j int) bool {
+		return wingetVersionLess(wingetDirVersion(paths[i]), wingetDirVersion(paths[j]))
+	})
+}
+
+// wingetDirVersion returns the version of a
+// Microsoft.DesktopAppInstaller_<version>_x64__<publisher> directory.
+func wingetDirVersion(path string) string {
+	parts := strings.Split(filepath.Base(filepath.Dir(path)), "_")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// wingetVersionLess compares dotted numeric versions.
+func wingetVersionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// wingetCells splits s into terminal cells. winget aligns its columns by
+// display width, wide characters take two cells, the second one is 0.
+func wingetCells(s string) []rune {
+	cells := make([]rune, 0, len(s))
+	for _, r := range s {
+		cells = append(cells, r)
+		if k := width.LookupRune(r).Kind(); k == width.EastAsianWide || k == width.EastAsianFullwidth {
+			cells = append(cells, 0)
+		}
+	}
+	return cells
+}
+
+// wingetColumns returns the start of each column of a table header, in
+// cells.
+func wingetColumns(header []rune) []int {
+	var cols []int
+	for i, r := range header {
+		if !unicode.IsSpace(r) && r != 0 && (i == 0 || unicode.IsSpace(header[i-1])) {
+			cols = append(cols, i)
+		}
+	}
+	return cols
+}
+
+func wingetField(row []rune, cols []int, i int) string {
+	if i >= len(cols) || cols[i] >= len(row) {
+		return ""
+	}
+	end := len(row)
+	if i+1 < len(cols) && cols[i+1] < end {
+		end = cols[i+1]
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(row[cols[i]:end]), "\x00", ""))
+}
+
+// parseWingetTable parses the output of winget list or winget upgrade. The
+// header names are localized so columns are found by position: Name, Id,
+// Version, then Available only if there are 5 columns. For upgrade the
+// available version is reported, for list the installed one. Apps that
+// winget only found in Add/Remove Programs or as MSIX packages have no
+// winget id and are skipped, they are reported as Windows applications.
+func parseWingetTable(data []byte, available bool) []*PkgInfo {
+	/*
+	   Name                Id                  Version      Available Source
+	   ---------------------------------------------------------------------
+	   Git                 Git.Git             2.42.0.2     2.43.0    winget
+	   Microsoft Edge      Microsoft.Edge      119.0.2151.58          winget
+	   Windows Subsystem … MSIX\MicrosoftCorp… 2.0.9.0
+	   2 upgrades available.
+	*/
+	var pkgs []*PkgInfo
+	var header []rune
+	var cols []int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// Progress spinners are drawn with carriage returns.
+		ln := scanner.Text()
+		if i := strings.LastIndex(ln, "\r"); i != -1 {
+			ln = ln[i+1:]
+		}
+		row := wingetCells(strings.TrimRight(ln, " "))
+		if cols == nil {
+			if len(row) > 0 && strings.Trim(string(row), "-") == "" {
+				cols = wingetColumns(header)
+			} else {
+				header = row
+			}
+			continue
+		}
+		if len(row) == 0 {
+			break
+		}
+		if len(cols) < 3 {
+			return nil
+		}
+		id := wingetField(row, cols, 1)
+		ver := wingetField(row, cols, 2)
+		if available {
+			if len(cols) < 5 {
+				continue
+			}
+			ver = wingetField(row, cols, 3)
+		}
+		// Truncated ids can't be used to manage the package.
+		if id == "" || ver == "" || strings.Contains(id, "…") || strings.HasPrefix(id, `ARP\`) || strings.HasPrefix(id, `MSIX\`) {
+			continue
+		}
+		// Versions winget could not determine exactly, like "< 1.2".
+		ver = strings.TrimSpace(strings.TrimLeft(ver, "<>"))
+		pkgs = append(pkgs, &PkgInfo{Name: id, Arch: noarch, Version: ver})
+	}
+	return pkgs
+}
+
+// InstalledWingetPackages queries for all packages installed from a winget
+// source.
+func InstalledWingetPackages(ctx context.Context) ([]*PkgInfo, error) {
+	out, err := run(ctx, winget, wingetListArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseWingetTable(out, false), nil
+}
+
+// WingetUpdates queries for all available winget updates.
+func WingetUpdates(ctx context.Context) ([]*PkgInfo, error) {
+	out, err := run(ctx, winget, wingetUpgradeArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseWingetTable(out, true), nil
+}
+
+// wingetEach runs winget once per package id, winget only manages one
+// package per call. A package that is already in the requested state is not
+// an error.
+func wingetEach(ctx context.Context, args []string, ids []string) error {
+	for _, id := range ids {
+		if _, err := run(ctx, winget, append(append([]string{}, args...), id)); err != nil && !wingetNoChange(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func wingetNoChange(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	code := uint32(exitErr.ExitCode())
+	for _, c := range wingetNoChangeCodes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// InstallWingetPackages installs winget packages by id.
+func InstallWingetPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	return wingetEach(ctx, wingetInstallArgs, pkgs)
+}
+
+// UpdateWingetPackages upgrades installed winget packages by id.
+func UpdateWingetPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	return wingetEach(ctx, wingetUpdateArgs, pkgs)
+}
+
+// RemoveWingetPackages uninstalls winget packages by id.
+func RemoveWingetPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	return wingetEach(ctx, wingetRemoveArgs, pkgs)
+}
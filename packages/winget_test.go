@@ -0,0 +1,156 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+var wingetListOutput = []byte("   - \r   \\ \r" +
+	"Name                               Id                                 Version          Available Source\n" +
+	"-------------------------------------------------------------------------------------------------------\n" +
+	"Git                                Git.Git                            2.42.0.2         2.43.0    winget\n" +
+	"Microsoft Edge                     Microsoft.Edge                     119.0.2151.58              winget\n" +
+	"Python 3.11.4 (64-bit)             Python.Python.3.11                 < 3.11.4                   winget\n" +
+	"Windows Subsystem for Linux        MSIX\\MicrosoftCorporationII.Wind… 2.0.9.0\n" +
+	"Google Cloud SDK                   ARP\\Machine\\X86\\Google Cloud SDK  452.0.1\n" +
+	"\n" +
+	"1 upgrades available.\n")
+
+func TestParseWingetTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		available bool
+		want      []*PkgInfo
+	}{
+		{
+			"Installed",
+			wingetListOutput,
+			false,
+			[]*PkgInfo{
+				{Name: "Git.Git", Arch: "all", Version: "2.42.0.2"},
+				{Name: "Microsoft.Edge", Arch: "all", Version: "119.0.2151.58"},
+				{Name: "Python.Python.3.11", Arch: "all", Version: "3.11.4"},
+			},
+		},
+		{
+			"Upgrades",
+			wingetListOutput,
+			true,
+			[]*PkgInfo{{Name: "Git.Git", Arch: "all", Version: "2.43.0"}},
+		},
+		{
+			"NoAvailableColumn",
+			[]byte("Name  Id       Version Source\n-----------------------------\nGit   Git.Git  2.42.0  winget\n"),
+			true,
+			nil,
+		},
+		{"NoPackages", []byte("No installed package found matching input criteria.\n"), false, nil},
+		{"nil", nil, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseWingetTable(tt.data, tt.available); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseWingetTable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWingetTableWide(t *testing.T) {
+	// winget pads by display width, each CJK character takes two columns.
+	data := []byte("名前         ID               バージョン\n" +
+		"---------------------------------------\n" +
+		"秀丸エディタ Hidemaru.Editor  9.25\n" +
+		"Git          Git.Git          2.42.0\n")
+	want := []*PkgInfo{
+		{Name: "Hidemaru.Editor", Arch: "all", Version: "9.25"},
+		{Name: "Git.Git", Arch: "all", Version: "2.42.0"},
+	}
+	if got := parseWingetTable(data, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseWingetTable() = %v, want %v", got, want)
+	}
+}
+
+func TestSortWingetDirs(t *testing.T) {
+	dir := filepath.Join("WindowsApps", "Microsoft.DesktopAppInstaller_%s_x64__8wekyb3d8bbwe", "winget.exe")
+	paths := []string{fmt.Sprintf(dir, "1.10.1.0"), fmt.Sprintf(dir, "1.9.25180.0"), fmt.Sprintf(dir, "1.21.3482.0")}
+	sortWingetDirs(paths)
+	want := []string{fmt.Sprintf(dir, "1.9.25180.0"), fmt.Sprintf(dir, "1.10.1.0"), fmt.Sprintf(dir, "1.21.3482.0")}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("sortWingetDirs() = %q, want %q", paths, want)
+	}
+}
+
+func TestInstallWingetPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	// One call per package.
+	first := mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(winget, append(wingetInstallArgs, "Git.Git")...))).Return([]byte("stdout"), nil, nil).Times(1)
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(winget, append(wingetInstallArgs, "Microsoft.Edge")...))).Return([]byte("stdout"), nil, nil).After(first).Times(1)
+	if err := InstallWingetPackages(testCtx, []string{"Git.Git", "Microsoft.Edge"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(winget, append(wingetInstallArgs, "Git.Git")...))).Return(nil, []byte("stderr"), errors.New("Could not install package")).Times(1)
+	if err := InstallWingetPackages(testCtx, []string{"Git.Git", "Microsoft.Edge"}); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestRemoveWingetPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(winget, append(wingetRemoveArgs, "Git.Git")...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return([]byte("stdout"), nil, nil).Times(1)
+	if err := RemoveWingetPackages(testCtx, []string{"Git.Git"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWingetUpdates(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(winget, wingetUpgradeArgs...))
+
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(wingetListOutput, nil, nil).Times(1)
+	got, err := WingetUpdates(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []*PkgInfo{{Name: "Git.Git", Arch: "all", Version: "2.43.0"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WingetUpdates() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,234 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/GoogleCloudPlatform/osconfig/crashreport"
+)
+
+// wuaClassifications are the Windows Update classification category IDs,
+// every update belongs to one of these classifications.
+// https://learn.microsoft.com/en-us/previous-versions/windows/desktop/ff357803(v=vs.85)
+var wuaClassifications = []string{
+	"E6CF1350-C01B-414D-A61F-263D14D133B4", // Critical Updates
+	"0FA1201D-4330-4FA8-8AE9-B877473B6441", // Security Updates
+	"E0789628-CE08-4437-BE74-2495B842F43B", // Definition Updates
+	"EBFC1FC5-71A4-4F7B-9ACA-3B9A503104A0", // Drivers
+	"B54E7D24-7ADD-428F-8B75-90A396FA584F", // Feature Packs
+	"68C5B0A3-D1A6-4553-AE49-01D3A7827828", // Service Packs
+	"B4832BD8-E735-4761-8DAF-37F882276DAB", // Tools
+	"28BC880E-0592-4CBF-8F95-C79B17911D5F", // Update Rollups
+	"CD5FFD1E-E932-4E3A-BF74-18BF0B1BBD83", // Updates
+	"3689BDC8-B205-4AF4-8D4A-A63924C5E9D5", // Upgrades
+}
+
+// wuaSearchConcurrency is the max number of WUA searches run at once, each
+// search runs in its own agent process.
+var wuaSearchConcurrency int32 = 3
+
+// WUAQueryOptions filter a Windows Update Agent search. Classifications are
+// added to the WUA query, the WUA query language has no KB or severity
+// criteria so those filters are applied to each result before the rest of
+// the update is read.
+type WUAQueryOptions struct {
+	// Classifications are the classification category IDs to search, all
+	// classifications are searched if none are set.
+	Classifications []string `json:",omitempty"`
+	// IncludeKBs limits the results to updates with one of these KB
+	// article IDs, with or without the KB prefix.
+	IncludeKBs []string `json:",omitempty"`
+	// ExcludeKBs drops updates with any of these KB article IDs.
+	ExcludeKBs []string `json:",omitempty"`
+	// Severities limits the results to updates with one of these MSRC
+	// severities, such as "Critical" or "Important". Updates without a
+	// severity are dropped when it is set.
+	Severities []string `json:",omitempty"`
+}
+
+// WUAQuery restricts a WUA search query to updates in any of the
+// classifications. WUA only allows "or" at the top level of a query, so
+// every top level clause of the query is repeated for each classification.
+func WUAQuery(query string, classifications []string) string {
+	if len(classifications) == 0 {
+		return query
+	}
+	var clauses []string
+	for _, q := range splitWUAQuery(query) {
+		for _, c := range classifications {
+			clauses = append(clauses, fmt.Sprintf("(%s) and CategoryIDs contains '%s'", q, c))
+		}
+	}
+	return strings.Join(clauses, " or ")
+}
+
+// searchWUAClassifications runs search once for each classification, up to
+// wuaSearchConcurrency at a time, so a slow classification does not hold up
+// the others. The results are merged in the order of classifications and an
+// update in more than one classification is only returned once. If any
+// search fails the remaining searches are canceled and the errors returned,
+// a partial result is never returned.
+func searchWUAClassifications(ctx context.Context, classifications []string, search func(context.Context, string) ([]*WUAPackage, error)) ([]*WUAPackage, error) {
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([][]*WUAPackage, len(classifications))
+	errs := make([]error, len(classifications))
+
+	sem := make(chan struct{}, atomic.LoadInt32(&wuaSearchConcurrency))
+	var wg sync.WaitGroup
+	for i, c := range classifications {
+		wg.Add(1)
+		go func(i int, c string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			defer crashreport.Recover(ctx, "WUA search")
+
+			if searchCtx.Err() != nil {
+				return
+			}
+			pkgs, err := search(searchCtx, c)
+			// Searches canceled because another search failed are not
+			// errors of their own.
+			if err != nil && (searchCtx.Err() == nil || ctx.Err() != nil) {
+				errs[i] = fmt.Errorf("error searching WUA classification %s: %v", c, err)
+				cancel()
+			}
+			results[i] = pkgs
+		}(i, c)
+	}
+	wg.Wait()
+
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) != 0 {
+		return nil, errors.New(strings.Join(msgs, "\n"))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dedupeWUAPackages(results), nil
+}
+
+// dedupeWUAPackages merges WUA search results, an update returned by more
+// than one search is only returned once, as the newest revision seen.
+func dedupeWUAPackages(results [][]*WUAPackage) []*WUAPackage {
+	index := map[string]int{}
+	var pkgs []*WUAPackage
+	for _, result := range results {
+		for _, pkg := range result {
+			i, ok := index[pkg.UpdateID]
+			if !ok {
+				index[pkg.UpdateID] = len(pkgs)
+				pkgs = append(pkgs, pkg)
+				continue
+			}
+			if pkg.RevisionNumber > pkgs[i].RevisionNumber {
+				pkgs[i] = pkg
+			}
+		}
+	}
+	return pkgs
+}
+
+// splitWUAQuery splits a WUA search query at its top level "or" operators,
+// ignoring any in parentheses or quoted values.
+func splitWUAQuery(query string) []string {
+	var clauses []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && len(query)-i >= 4 && strings.EqualFold(query[i:i+4], " or "):
+			clauses = append(clauses, strings.TrimSpace(query[start:i]))
+			start = i + 4
+			i += 3
+		}
+	}
+	return append(clauses, strings.TrimSpace(query[start:]))
+}
+
+// FiltersResults reports whether o has filters that are applied to search
+// results rather than the WUA query.
+func (o WUAQueryOptions) FiltersResults() bool {
+	return len(o.IncludeKBs) > 0 || len(o.ExcludeKBs) > 0 || len(o.Severities) > 0
+}
+
+// match reports whether an update with the KB article IDs and MSRC
+// severity passes the KB and severity filters of o.
+func (o WUAQueryOptions) match(kbs []string, severity string) bool {
+	if len(o.Severities) > 0 && !containsFold(o.Severities, severity) {
+		return false
+	}
+	for _, kb := range kbs {
+		if containsKB(o.ExcludeKBs, kb) {
+			return false
+		}
+	}
+	if len(o.IncludeKBs) == 0 {
+		return true
+	}
+	for _, kb := range kbs {
+		if containsKB(o.IncludeKBs, kb) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsKB reports whether kbs contains the KB article ID kb, WUA
+// reports KB article IDs without the KB prefix users usually write.
+func containsKB(kbs []string, kb string) bool {
+	for _, k := range kbs {
+		if trimKB(k) == trimKB(kb) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimKB removes the case insensitive KB prefix of a KB article ID.
+func trimKB(kb string) string {
+	if len(kb) >= 2 && strings.EqualFold(kb[:2], "KB") {
+		return kb[2:]
+	}
+	return kb
+}
+
+func containsFold(ss []string, s string) bool {
+	for _, v := range ss {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWUAQuery(t *testing.T) {
+	tests := []struct {
+		query           string
+		classifications []string
+		want            string
+	}{
+		{"IsInstalled=0", nil, "IsInstalled=0"},
+		{"IsInstalled=0", []string{"a", "b"}, "(IsInstalled=0) and CategoryIDs contains 'a' or (IsInstalled=0) and CategoryIDs contains 'b'"},
+		// Every top level clause is restricted to the classifications.
+		{"IsInstalled=0 and Type='Software' or IsHidden=1", []string{"a"}, "(IsInstalled=0 and Type='Software') and CategoryIDs contains 'a' or (IsHidden=1) and CategoryIDs contains 'a'"},
+		{"(IsInstalled=0 or IsHidden=1) and Type='a or b'", []string{"a"}, "((IsInstalled=0 or IsHidden=1) and Type='a or b') and CategoryIDs contains 'a'"},
+	}
+	for _, tt := range tests {
+		if got := WUAQuery(tt.query, tt.classifications); got != tt.want {
+			t.Errorf("WUAQuery(%q, %q) = %q, want %q", tt.query, tt.classifications, got, tt.want)
+		}
+	}
+}
+
+func TestWUAQueryOptionsMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     WUAQueryOptions
+		kbs      []string
+		severity string
+		want     bool
+	}{
+		{"no filters", WUAQueryOptions{}, []string{"5005565"}, "", true},
+		{"excluded", WUAQueryOptions{ExcludeKBs: []string{"KB5005565"}}, []string{"5005565"}, "", false},
+		{"not excluded", WUAQueryOptions{ExcludeKBs: []string{"KB123"}}, []string{"5005565"}, "", true},
+		{"included", WUAQueryOptions{IncludeKBs: []string{"kb5005565"}}, []string{"5005565"}, "", true},
+		{"not included", WUAQueryOptions{IncludeKBs: []string{"KB123"}}, []string{"5005565"}, "", false},
+		// Only the KB prefix is ignored.
+		{"prefix only", WUAQueryOptions{ExcludeKBs: []string{"KBB123"}}, []string{"123"}, "", true},
+		{"severity", WUAQueryOptions{Severities: []string{"critical", "Important"}}, nil, "Critical", true},
+		{"other severity", WUAQueryOptions{Severities: []string{"Critical"}}, nil, "Moderate", false},
+		{"no severity", WUAQueryOptions{Severities: []string{"Critical"}}, nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.match(tt.kbs, tt.severity); got != tt.want {
+				t.Errorf("match(%q, %q) = %t, want %t", tt.kbs, tt.severity, got, tt.want)
+			}
+			if got := tt.opts.FiltersResults(); got != (tt.name != "no filters") {
+				t.Errorf("FiltersResults() = %t", got)
+			}
+		})
+	}
+}
+
+func TestSearchWUAClassifications(t *testing.T) {
+	a := &WUAPackage{UpdateID: "a", RevisionNumber: 1}
+	a2 := &WUAPackage{UpdateID: "a", RevisionNumber: 2}
+	b := &WUAPackage{UpdateID: "b", RevisionNumber: 1}
+	results := map[string][]*WUAPackage{"1": {a, b}, "2": nil, "3": {b, a2}}
+
+	var running, max int32
+	got, err := searchWUAClassifications(context.Background(), []string{"1", "2", "3", "4"}, func(ctx context.Context, c string) ([]*WUAPackage, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		return results[c], nil
+	})
+	if err != nil {
+		t.Fatalf("searchWUAClassifications() error: %v", err)
+	}
+	if want := []*WUAPackage{a2, b}; !reflect.DeepEqual(got, want) {
+		t.Errorf("searchWUAClassifications() = %v, want %v", got, want)
+	}
+	if max > wuaSearchConcurrency {
+		t.Errorf("%d searches ran at once, want at most %d", max, wuaSearchConcurrency)
+	}
+}
+
+func TestSearchWUAClassificationsError(t *testing.T) {
+	got, err := searchWUAClassifications(context.Background(), []string{"1", "2"}, func(ctx context.Context, c string) ([]*WUAPackage, error) {
+		if c == "2" {
+			return nil, errors.New("search failed")
+		}
+		return []*WUAPackage{{UpdateID: "a"}}, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "search failed") {
+		t.Errorf("searchWUAClassifications() error = %v, want the search error", err)
+	}
+	if got != nil {
+		t.Errorf("searchWUAClassifications() = %v, want no partial result", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := searchWUAClassifications(ctx, []string{"1"}, func(ctx context.Context, c string) ([]*WUAPackage, error) {
+		return nil, nil
+	}); err == nil {
+		t.Error("searchWUAClassifications() with a canceled context returned no error")
+	}
+}
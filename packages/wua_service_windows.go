@@ -0,0 +1,141 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	ole "github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// MicrosoftUpdateServiceID is the WUA service ID of Microsoft Update, which
+// also offers updates for other Microsoft products such as SQL Server and
+// Office.
+const MicrosoftUpdateServiceID = "7971f918-a847-4430-9279-4a52d1efe18d"
+
+// AddService2 flags.
+// https://learn.microsoft.com/en-us/windows/win32/api/wuapi/ne-wuapi-addserviceflag
+const (
+	asfAllowPendingRegistration = 0x1
+	asfAllowOnlineRegistration  = 0x2
+	asfRegisterServiceWithAU    = 0x4
+)
+
+// withServiceManager runs f with a Microsoft.Update.ServiceManager, it holds
+// the WUA session lock like NewUpdateSession.
+func withServiceManager(f func(sm *ole.IDispatch) error) error {
+	wuaSession.Lock()
+	defer wuaSession.Unlock()
+	if err := coInitializeEx(); err != nil {
+		return err
+	}
+	defer ole.CoUninitialize()
+
+	unknown, err := oleutil.CreateObject("Microsoft.Update.ServiceManager")
+	if err != nil {
+		return fmt.Errorf(`oleutil.CreateObject("Microsoft.Update.ServiceManager"): %v`, err)
+	}
+	defer unknown.Release()
+	sm, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return fmt.Errorf(`error creating Dispatch object from Microsoft.Update.ServiceManager connection: %v`, err)
+	}
+	defer sm.Release()
+	if _, err := sm.PutProperty("ClientApplicationID", "google_osconfig_agent"); err != nil {
+		return fmt.Errorf(`IUpdateServiceManager.PutProperty("ClientApplicationID"): %v`, err)
+	}
+	return f(sm)
+}
+
+// serviceRegisteredWithAU reports whether the service with id is registered
+// with Automatic Updates, the service WUA searches by default.
+func serviceRegisteredWithAU(sm *ole.IDispatch, id string) (bool, error) {
+	servicesRaw, err := sm.GetProperty("Services")
+	if err != nil {
+		return false, fmt.Errorf(`IUpdateServiceManager.GetProperty("Services"): %v`, err)
+	}
+	defer servicesRaw.Clear()
+	services := servicesRaw.ToIDispatch()
+
+	count, err := GetCount(services)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < int(count); i++ {
+		registered, ok, err := itemRegisteredWithAU(services, i, id)
+		if err != nil || ok {
+			return registered, err
+		}
+	}
+	return false, nil
+}
+
+// itemRegisteredWithAU reports whether item i of services is registered
+// with Automatic Updates, ok is false if the item is not the service with
+// id.
+func itemRegisteredWithAU(services *ole.IDispatch, i int, id string) (registered, ok bool, err error) {
+	serviceRaw, err := services.GetProperty("Item", i)
+	if err != nil {
+		return false, false, fmt.Errorf(`services.GetProperty("Item", %d): %v`, i, err)
+	}
+	defer serviceRaw.Clear()
+	service := serviceRaw.ToIDispatch()
+
+	serviceID, err := service.GetProperty("ServiceID")
+	if err != nil {
+		return false, false, fmt.Errorf(`IUpdateService.GetProperty("ServiceID"): %v`, err)
+	}
+	defer serviceID.Clear()
+	if !strings.EqualFold(serviceID.ToString(), id) {
+		return false, false, nil
+	}
+
+	registeredRaw, err := service.GetProperty("IsRegisteredWithAU")
+	if err != nil {
+		return false, false, fmt.Errorf(`IUpdateService.GetProperty("IsRegisteredWithAU"): %v`, err)
+	}
+	defer registeredRaw.Clear()
+	registered, _ = registeredRaw.Value().(bool)
+	return registered, true, nil
+}
+
+// SetMicrosoftUpdate registers Microsoft Update with WUA, or removes it, so
+// that searches include, or no longer include, updates for other Microsoft
+// products. Nothing is changed if it is already in the requested state.
+func SetMicrosoftUpdate(ctx context.Context, register bool) error {
+	return withServiceManager(func(sm *ole.IDispatch) error {
+		registered, err := serviceRegisteredWithAU(sm, MicrosoftUpdateServiceID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case register && !registered:
+			clog.Infof(ctx, "Registering the Microsoft Update service with Windows Update Agent.")
+			if _, err := sm.CallMethod("AddService2", MicrosoftUpdateServiceID, asfAllowPendingRegistration|asfAllowOnlineRegistration|asfRegisterServiceWithAU, ""); err != nil {
+				return fmt.Errorf("error registering the Microsoft Update service: %v"+GetScodeString(ctx, err), err)
+			}
+		case !register && registered:
+			clog.Infof(ctx, "Removing the Microsoft Update service from Windows Update Agent.")
+			if _, err := sm.CallMethod("RemoveService", MicrosoftUpdateServiceID); err != nil {
+				return fmt.Errorf("error removing the Microsoft Update service: %v"+GetScodeString(ctx, err), err)
+			}
+		}
+		return nil
+	})
+}
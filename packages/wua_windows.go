@@ -70,6 +70,7 @@ func (s *IUpdateSession) Close() {
 
 // InstallWUAUpdate install a WIndows update.
 func (s *IUpdateSession) InstallWUAUpdate(ctx context.Context, updt *IUpdate) error {
+	defer InvalidateQueryCache()
 	title, err := updt.GetProperty("Title")
 	if err != nil {
 		return fmt.Errorf(`updt.GetProperty("Title"): %v`, err)
@@ -271,6 +272,66 @@ func (u *IUpdate) moreInfoURLs() ([]string, error) {
 	return ss, nil
 }
 
+// matchPkg reports whether an update passes the KB and severity filters of
+// opts, only the properties the filters need are read.
+func (c *IUpdateCollection) matchPkg(item int, opts WUAQueryOptions) (bool, error) {
+	updt, err := c.Item(item)
+	if err != nil {
+		return false, err
+	}
+	defer updt.Release()
+
+	kbArticleIDs, err := updt.kbaIDs()
+	if err != nil {
+		return false, err
+	}
+
+	var severity string
+	if len(opts.Severities) > 0 {
+		severityRaw, err := updt.GetProperty("MsrcSeverity")
+		if err != nil {
+			return false, fmt.Errorf(`updt.GetProperty("MsrcSeverity"): %v`, err)
+		}
+		defer severityRaw.Clear()
+		severity = severityRaw.ToString()
+	}
+	return opts.match(kbArticleIDs, severity), nil
+}
+
+// Filter returns a new collection with the updates of c that pass the KB
+// and severity filters of opts.
+func (c *IUpdateCollection) Filter(opts WUAQueryOptions) (*IUpdateCollection, error) {
+	count, err := c.Count()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered, err := NewUpdateCollection()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(count); i++ {
+		ok, err := c.matchPkg(i, opts)
+		if err != nil {
+			filtered.Release()
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		updt, err := c.Item(i)
+		if err != nil {
+			filtered.Release()
+			return nil, err
+		}
+		if err := filtered.Add(updt); err != nil {
+			filtered.Release()
+			return nil, err
+		}
+	}
+	return filtered, nil
+}
+
 func (c *IUpdateCollection) extractPkg(item int) (*WUAPackage, error) {
 	updt, err := c.Item(item)
 	if err != nil {
@@ -349,8 +410,11 @@ func (c *IUpdateCollection) extractPkg(item int) (*WUAPackage, error) {
 	}, nil
 }
 
-// WUAUpdates queries the Windows Update Agent API searcher with the provided query.
-func WUAUpdates(ctx context.Context, query string) ([]WUAPackage, error) {
+// WUAUpdates queries the Windows Update Agent API searcher with the provided
+// query and opts. Updates dropped by the KB and severity filters of opts
+// are skipped before the rest of their properties are read.
+func WUAUpdates(ctx context.Context, query string, opts WUAQueryOptions) ([]WUAPackage, error) {
+	query = WUAQuery(query, opts.Classifications)
 	session, err := NewUpdateSession()
 	if err != nil {
 		return nil, fmt.Errorf("error creating NewUpdateSession: %v", err)
@@ -374,6 +438,15 @@ func WUAUpdates(ctx context.Context, query string) ([]WUAPackage, error) {
 
 	var packages []WUAPackage
 	for i := 0; i < int(updtCnt); i++ {
+		if opts.FiltersResults() {
+			ok, err := updts.matchPkg(i, opts)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
 		pkg, err := updts.extractPkg(i)
 		if err != nil {
 			return nil, err
@@ -406,6 +479,7 @@ func (s *IUpdateSession) DownloadWUAUpdateCollection(ctx context.Context, update
 
 // InstallWUAUpdateCollection installs all updates in a IUpdateCollection
 func (s *IUpdateSession) InstallWUAUpdateCollection(ctx context.Context, updates *IUpdateCollection) error {
+	defer InvalidateQueryCache()
 	// returns IUpdateInstallersession *ole.IDispatch,
 	// https://docs.microsoft.com/en-us/windows/desktop/api/wuapi/nf-wuapi-iupdatesession-createupdateinstaller
 	installerRaw, err := s.CallMethod("CreateUpdateInstaller")
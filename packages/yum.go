@@ -26,6 +26,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/progress"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
@@ -37,6 +38,7 @@ var (
 	yumCheckUpdateArgs       = []string{"check-update", "--assumeyes"}
 	yumListUpdatesArgs       = []string{"update", "--assumeno", "--cacheonly", "--color=never"}
 	yumListUpdateMinimalArgs = []string{"update-minimal", "--assumeno", "--cacheonly", "--color=never"}
+	yumCleanArgs             = []string{"clean", "all"}
 )
 
 func init() {
@@ -72,12 +74,14 @@ func YumUpdateMinimal(minimal bool) YumUpdateOption {
 
 // InstallYumPackages installs yum packages.
 func InstallYumPackages(ctx context.Context, pkgs []string) error {
-	_, err := run(ctx, yum, append(yumInstallArgs, pkgs...))
+	defer InvalidateQueryCache()
+	_, err := runWithProgress(ctx, yum, yumInstallArgs, pkgs, progress.ParseDnf)
 	return err
 }
 
 // RemoveYumPackages removes yum packages.
 func RemoveYumPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
 	_, err := run(ctx, yum, append(yumRemoveArgs, pkgs...))
 	return err
 }
@@ -155,8 +159,15 @@ func getYumTXFile(data []byte) string {
 	return ""
 }
 
+// YumClean runs yum clean all, removing cached package files and metadata.
+func YumClean(ctx context.Context) ([]byte, error) {
+	return run(ctx, yum, yumCleanArgs)
+}
+
 // YumUpdates queries for all available yum updates.
 func YumUpdates(ctx context.Context, opts ...YumUpdateOption) ([]*PkgInfo, error) {
+	ctx, cancel := withOperationTimeout(ctx, "yum", OpResolve)
+	defer cancel()
 	// We just use check-update to ensure all repo keys are synced as we run
 	// update with --assumeno.
 	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, yum, yumCheckUpdateArgs...))
@@ -172,7 +183,7 @@ func YumUpdates(ctx context.Context, opts ...YumUpdateOption) ([]*PkgInfo, error
 
 	// Since we don't get good error codes from 'yum update' exit now if there is an issue.
 	if err != nil {
-		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", yum, yumCheckUpdateArgs, err, stdout, stderr)
+		return nil, newCommandError(yum, yumCheckUpdateArgs, stdout, stderr, err)
 	}
 
 	return listAndParseYumPackages(ctx, opts...)
@@ -198,7 +209,7 @@ func listAndParseYumPackages(ctx context.Context, opts ...YumUpdateOption) ([]*P
 
 	stdout, stderr, err := ptyrunner.Run(ctx, exec.CommandContext(ctx, yum, args...))
 	if err != nil {
-		return nil, fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", yum, args, err, stdout, stderr)
+		return nil, newCommandError(yum, args, stdout, stderr, err)
 	}
 	if stdout == nil {
 		return nil, nil
@@ -0,0 +1,123 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+var (
+	// yumVersionLockConfs are the versionlock plugin configs for yum and
+	// dnf, the plugin is only queried if one of them exists.
+	yumVersionLockConfs = []string{"/etc/yum/pluginconf.d/versionlock.conf", "/etc/dnf/plugins/versionlock.conf"}
+
+	yumVersionLockListArgs   = []string{"--quiet", "versionlock", "list"}
+	yumVersionLockAddArgs    = []string{"--quiet", "versionlock", "add"}
+	yumVersionLockDeleteArgs = []string{"--quiet", "versionlock", "delete"}
+
+	// yum prints entries as [!]EPOCH:NAME-VERSION-RELEASE.ARCH and dnf as
+	// [!]NAME-EPOCH:VERSION-RELEASE.ARCH, any part after the name may be a
+	// glob.
+	yumVersionLockRE = regexp.MustCompile(`^(!)?(?:\d+:)?(\S+)-[^\s-]+-[^\s-]+$`)
+)
+
+// VersionLock is a yum or dnf versionlock entry.
+type VersionLock struct {
+	// Name is the package name the entry applies to.
+	Name string
+	// Entry is the entry as listed by the versionlock plugin.
+	Entry string
+	// Exclude is set for entries that exclude a version instead of locking
+	// the package to it.
+	Exclude bool
+}
+
+// YumVersionLockSupported reports whether the yum or dnf versionlock plugin
+// is installed.
+func YumVersionLockSupported() bool {
+	for _, conf := range yumVersionLockConfs {
+		if util.Exists(conf) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseYumVersionLocks(data []byte) []*VersionLock {
+	/*
+		Loaded plugins: fastestmirror, versionlock
+		0:bash-4.2.46-34.el7.*
+		!0:kernel-3.10.0-1160.el7.*
+		versionlock list done
+
+		or for dnf:
+
+		bash-0:5.1.8-6.el9.*
+		!kernel-0:5.14.0-362.el9.*
+	*/
+	var locks []*VersionLock
+	for _, ln := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		entry := strings.TrimSpace(string(ln))
+		m := yumVersionLockRE.FindStringSubmatch(entry)
+		if m == nil {
+			continue
+		}
+		locks = append(locks, &VersionLock{Name: m[2], Entry: entry, Exclude: m[1] != ""})
+	}
+	return locks
+}
+
+// YumVersionLocks lists the versionlock entries, it returns no entries if
+// the versionlock plugin is not installed.
+func YumVersionLocks(ctx context.Context) ([]*VersionLock, error) {
+	if !YumVersionLockSupported() {
+		return nil, nil
+	}
+	out, err := run(ctx, yum, yumVersionLockListArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseYumVersionLocks(out), nil
+}
+
+// YumVersionLockAdd locks pkgs to their installed versions.
+func YumVersionLockAdd(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, yum, append(yumVersionLockAddArgs, pkgs...))
+	return err
+}
+
+// YumVersionLockDelete removes the versionlock entries for pkgs.
+func YumVersionLockDelete(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, yum, append(yumVersionLockDeleteArgs, pkgs...))
+	return err
+}
+
+// VersionLocked reports whether a package named name is locked to its
+// version, exclude entries only block specific versions and are ignored.
+func VersionLocked(locks []*VersionLock, name string) bool {
+	for _, l := range locks {
+		if !l.Exclude && l.Name == name {
+			return true
+		}
+	}
+	return false
+}
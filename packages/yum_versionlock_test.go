@@ -0,0 +1,99 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package packages
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	utilmocks "github.com/GoogleCloudPlatform/osconfig/util/mocks"
+	"github.com/golang/mock/gomock"
+)
+
+func TestParseYumVersionLocks(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []*VersionLock
+	}{
+		{
+			"yum",
+			[]byte("Loaded plugins: fastestmirror, versionlock\n0:bash-4.2.46-34.el7.*\n!0:kernel-3.10.0-1160.el7.*\nversionlock list done\n"),
+			[]*VersionLock{
+				{Name: "bash", Entry: "0:bash-4.2.46-34.el7.*"},
+				{Name: "kernel", Entry: "!0:kernel-3.10.0-1160.el7.*", Exclude: true},
+			},
+		},
+		{
+			"dnf",
+			[]byte("Last metadata expiration check: 0:12:01 ago on Mon 01 Jan 2024.\nbash-0:5.1.8-6.el9.*\npython3-dnf-plugin-versionlock-0:4.3.0-13.el9.*\n"),
+			[]*VersionLock{
+				{Name: "bash", Entry: "bash-0:5.1.8-6.el9.*"},
+				{Name: "python3-dnf-plugin-versionlock", Entry: "python3-dnf-plugin-versionlock-0:4.3.0-13.el9.*"},
+			},
+		},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseYumVersionLocks(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYumVersionLocks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYumVersionLocks(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	oldConfs := yumVersionLockConfs
+	defer func() { yumVersionLockConfs = oldConfs }()
+	conf := filepath.Join(t.TempDir(), "versionlock.conf")
+	yumVersionLockConfs = []string{conf}
+
+	// Without the plugin nothing is run.
+	locks, err := YumVersionLocks(testCtx)
+	if err != nil || locks != nil {
+		t.Fatalf("YumVersionLocks() = %v, %v, want no entries", locks, err)
+	}
+
+	if err := os.WriteFile(conf, []byte("[main]\nenabled = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(yum, yumVersionLockListArgs...))).Return([]byte("bash-0:5.1.8-6.el9.*\n"), nil, nil).Times(1)
+	locks, err = YumVersionLocks(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VersionLocked(locks, "bash") || VersionLocked(locks, "kernel") {
+		t.Errorf("YumVersionLocks() = %v, want only bash locked", locks)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(yum, append(yumVersionLockAddArgs, "foo")...))).Times(1)
+	if err := YumVersionLockAdd(testCtx, []string{"foo"}); err != nil {
+		t.Errorf("YumVersionLockAdd: unexpected error: %v", err)
+	}
+	mockCommandRunner.EXPECT().Run(testCtx, utilmocks.EqCmd(exec.Command(yum, append(yumVersionLockDeleteArgs, "foo")...))).Times(1)
+	if err := YumVersionLockDelete(testCtx, []string{"foo"}); err != nil {
+		t.Errorf("YumVersionLockDelete: unexpected error: %v", err)
+	}
+}
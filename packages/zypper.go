@@ -17,6 +17,7 @@ package packages
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"fmt"
 	"os/exec"
 	"regexp"
@@ -26,11 +27,14 @@ import (
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/progress"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 )
 
 var (
-	zypper string
+	zypper              string
+	transactionalUpdate string
+	snapper             string
 
 	// zypperInstallArgs is zypper command to install patches, packages
 	zypperInstallArgs     = []string{"--gpg-auto-import-keys", "--non-interactive", "install", "--auto-agree-with-licenses"}
@@ -38,13 +42,24 @@ var (
 	zypperListUpdatesArgs = []string{"--gpg-auto-import-keys", "-q", "list-updates"}
 	zypperListPatchesArgs = []string{"--gpg-auto-import-keys", "-q", "list-patches"}
 	zypperPatchInfoArgs   = []string{"info", "-t", "patch"}
+	zypperCleanArgs       = []string{"--non-interactive", "clean", "--all"}
+
+	// transactionalUpdateInstallArgs continue from the newest snapshot so that
+	// changes still waiting for a reboot are kept.
+	transactionalUpdateInstallArgs = []string{"--non-interactive", "--continue", "pkg", "install"}
+	transactionalUpdateRemoveArgs  = []string{"--non-interactive", "--continue", "pkg", "remove"}
+	snapperListArgs                = []string{"--no-dbus", "--csv", "list", "--columns", "number,default,active"}
+	snapshotRPMNamesArgs           = []string{"--queryformat", "%{NAME}\n", "-a"}
 )
 
 func init() {
 	if runtime.GOOS != "windows" {
 		zypper = "/usr/bin/zypper"
+		transactionalUpdate = "/usr/sbin/transactional-update"
+		snapper = "/usr/bin/snapper"
 	}
 	ZypperExists = util.Exists(zypper)
+	TransactionalUpdateExists = util.Exists(transactionalUpdate)
 }
 
 type zypperListPatchOpts struct {
@@ -57,6 +72,46 @@ type zypperListPatchOpts struct {
 // ZypperListOption is patch list options
 type ZypperListOption func(opts *zypperListPatchOpts)
 
+// filtered reports whether a category or severity filter is set.
+func (o *zypperListPatchOpts) filtered() bool {
+	return len(o.categories)+len(o.severities) > 0
+}
+
+// matches reports whether patch passes the category and severity filters,
+// zypper compares both case insensitively.
+func (o *zypperListPatchOpts) matches(patch *ZypperPatch) bool {
+	return matchesAnyFold(o.categories, patch.Category) && matchesAnyFold(o.severities, patch.Severity)
+}
+
+func matchesAnyFold(want []string, s string) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, w := range want {
+		if strings.EqualFold(w, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func newZypperListPatchOpts(opts []ZypperListOption) *zypperListPatchOpts {
+	zOpts := &zypperListPatchOpts{}
+	for _, opt := range opts {
+		opt(zOpts)
+	}
+	return zOpts
+}
+
+// ZypperListSecurityPatches is zypper list option to only list patches in
+// the security category, with any of the given severities if there are any.
+func ZypperListSecurityPatches(severities ...string) ZypperListOption {
+	return func(args *zypperListPatchOpts) {
+		args.categories = []string{"security"}
+		args.severities = severities
+	}
+}
+
 // ZypperListPatchCategories is zypper list option to provide category filter
 func ZypperListPatchCategories(categories []string) ZypperListOption {
 	return func(args *zypperListPatchOpts) {
@@ -87,12 +142,87 @@ func ZypperListPatchAll(all bool) ZypperListOption {
 
 // InstallZypperPackages Installs zypper packages
 func InstallZypperPackages(ctx context.Context, pkgs []string) error {
-	_, err := run(ctx, zypper, append(zypperInstallArgs, pkgs...))
+	defer InvalidateQueryCache()
+	_, err := runWithProgress(ctx, zypper, zypperInstallArgs, pkgs, progress.ParseZypper)
+	return err
+}
+
+// TransactionalInstallZypperPackages installs zypper packages with
+// transactional-update, for systems with a read-only root. The packages are
+// installed into a new snapshot that becomes active on the next boot.
+func TransactionalInstallZypperPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, transactionalUpdate, append(transactionalUpdateInstallArgs, pkgs...))
 	return err
 }
 
+// TransactionalRemoveZypperPackages removes zypper packages with
+// transactional-update, the packages remain installed until the next boot.
+func TransactionalRemoveZypperPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
+	_, err := run(ctx, transactionalUpdate, append(transactionalUpdateRemoveArgs, pkgs...))
+	return err
+}
+
+// TransactionalPendingSnapshot returns the root of the snapshot that
+// transactional-update set as the default for the next boot, or "" if the
+// running snapshot is the default.
+func TransactionalPendingSnapshot(ctx context.Context) (string, error) {
+	out, err := run(ctx, snapper, snapperListArgs)
+	if err != nil {
+		return "", err
+	}
+	return parseSnapperPending(out)
+}
+
+func parseSnapperPending(data []byte) (string, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return "", fmt.Errorf("error parsing snapper output: %v", err)
+	}
+	if len(records) == 0 {
+		return "", nil
+	}
+	cols := map[string]int{}
+	for i, name := range records[0] {
+		cols[name] = i
+	}
+	number, nok := cols["number"]
+	def, dok := cols["default"]
+	active, aok := cols["active"]
+	if !nok || !dok || !aok {
+		return "", fmt.Errorf("unexpected snapper output header: %q", records[0])
+	}
+	for _, r := range records[1:] {
+		if r[def] == "yes" && r[active] != "yes" {
+			return fmt.Sprintf("/.snapshots/%s/snapshot", r[number]), nil
+		}
+	}
+	return "", nil
+}
+
+// TransactionalPendingPackages returns the root of the snapshot waiting for
+// the next boot, see TransactionalPendingSnapshot, and the names of the
+// packages installed in it. The root is "" if no snapshot is pending.
+func TransactionalPendingPackages(ctx context.Context) (string, map[string]struct{}, error) {
+	root, err := TransactionalPendingSnapshot(ctx)
+	if err != nil || root == "" {
+		return "", nil, err
+	}
+	out, err := run(ctx, rpmquery, append([]string{"--root", root}, snapshotRPMNamesArgs...))
+	if err != nil {
+		return "", nil, err
+	}
+	names := map[string]struct{}{}
+	for _, name := range strings.Fields(string(out)) {
+		names[name] = struct{}{}
+	}
+	return root, names, nil
+}
+
 // ZypperInstall installs zypper patches and packages
-func ZypperInstall(ctx context.Context, patches []*ZypperPatch, pkgs []*PkgInfo) error {
+func ZypperInstall(ctx context.Context, patches []*ZypperPatch, pkgs []*PkgInfo) (err error) {
+	defer InvalidateQueryCache()
 	args := zypperInstallArgs
 
 	// https://www.mankier.com/8/zypper#Concepts-Package_Types use patch install
@@ -104,16 +234,18 @@ func ZypperInstall(ctx context.Context, patches []*ZypperPatch, pkgs []*PkgInfo)
 		args = append(args, "package:"+pkg.Name)
 	}
 
-	stdout, stderr, err := runner.Run(ctx, exec.CommandContext(ctx, zypper, args...))
+	ctx, op := startOperation(ctx, "zypper", args[len(zypperInstallArgs):], progress.ParseZypper)
+	defer func() { err = op.done(err) }()
+	cmd := exec.CommandContext(ctx, zypper, args...)
+	op.setOutput(cmd)
+	stdout, stderr, err := runner.Run(ctx, cmd)
 	// https://en.opensuse.org/SDB:Zypper_manual#EXIT_CODES
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			// ZYPPER_EXIT_INF_REBOOT_NEEDED
-			if exitErr.ExitCode() == 102 {
-				err = nil
-			}
+		// ZYPPER_EXIT_INF_REBOOT_NEEDED
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 102 {
+			err = nil
 		} else {
-			err = fmt.Errorf("error running %s with args %q: %v, stdout: %q, stderr: %q", zypper, args, err, stdout, stderr)
+			err = newCommandError(zypper, args, stdout, stderr, err)
 		}
 	}
 
@@ -122,6 +254,7 @@ func ZypperInstall(ctx context.Context, patches []*ZypperPatch, pkgs []*PkgInfo)
 
 // RemoveZypperPackages installed Zypper packages.
 func RemoveZypperPackages(ctx context.Context, pkgs []string) error {
+	defer InvalidateQueryCache()
 	_, err := run(ctx, zypper, append(zypperRemoveArgs, pkgs...))
 	return err
 }
@@ -151,13 +284,47 @@ func parseZypperUpdates(data []byte) []*PkgInfo {
 	return pkgs
 }
 
-// ZypperUpdates queries for all available zypper updates.
-func ZypperUpdates(ctx context.Context) ([]*PkgInfo, error) {
+// ZypperClean runs zypper clean --all, removing cached package files and metadata.
+func ZypperClean(ctx context.Context) ([]byte, error) {
+	return run(ctx, zypper, zypperCleanArgs)
+}
+
+// ZypperUpdates queries for all available zypper updates. If a category or
+// severity option is given only updates of packages in matching patches are
+// returned.
+func ZypperUpdates(ctx context.Context, opts ...ZypperListOption) ([]*PkgInfo, error) {
+	zOpts := newZypperListPatchOpts(opts)
+	var inPatch map[string][]string
+	if zOpts.filtered() {
+		patches, err := ZypperPatches(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if len(patches) == 0 {
+			return nil, nil
+		}
+		if inPatch, err = ZypperPackagesInPatch(ctx, patches); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := withOperationTimeout(ctx, "zypper", OpResolve)
+	defer cancel()
 	out, err := run(ctx, zypper, zypperListUpdatesArgs)
 	if err != nil {
 		return nil, err
 	}
-	return parseZypperUpdates(out), nil
+	pkgs := parseZypperUpdates(out)
+	if inPatch == nil {
+		return pkgs, nil
+	}
+	var filtered []*PkgInfo
+	for _, pkg := range pkgs {
+		if _, ok := inPatch[pkg.Name]; ok {
+			filtered = append(filtered, pkg)
+		}
+	}
+	return filtered, nil
 }
 
 func parseZypperPatches(ctx context.Context, data []byte) ([]*ZypperPatch, []*ZypperPatch) {
@@ -215,16 +382,9 @@ func parseZypperPatch(tableLine []byte) (*ZypperPatch, string, error) {
 }
 
 func zypperPatches(ctx context.Context, opts ...ZypperListOption) ([]byte, error) {
-	zOpts := &zypperListPatchOpts{
-		categories:   nil,
-		severities:   nil,
-		withOptional: false,
-		all:          false,
-	}
-
-	for _, opt := range opts {
-		opt(zOpts)
-	}
+	ctx, cancel := withOperationTimeout(ctx, "zypper", OpResolve)
+	defer cancel()
+	zOpts := newZypperListPatchOpts(opts)
 
 	args := zypperListPatchesArgs
 	for _, c := range zOpts.categories {
@@ -242,7 +402,7 @@ func zypperPatches(ctx context.Context, opts ...ZypperListOption) ([]byte, error
 	//  As per zypper's current implementation,
 	// --all is ignored if we have any filters on any other
 	// field.
-	if zOpts.all || (len(zOpts.severities)+len(zOpts.categories)) <= 0 {
+	if zOpts.all || !zOpts.filtered() {
 		args = append(args, "--all")
 	}
 
@@ -256,7 +416,7 @@ func ZypperPatches(ctx context.Context, opts ...ZypperListOption) ([]*ZypperPatc
 		return nil, err
 	}
 	_, patches := parseZypperPatches(ctx, out)
-	return patches, nil
+	return filterZypperPatches(patches, newZypperListPatchOpts(opts)), nil
 }
 
 // ZypperInstalledPatches queries for all installed zypper patches.
@@ -266,7 +426,23 @@ func ZypperInstalledPatches(ctx context.Context, opts ...ZypperListOption) ([]*Z
 		return nil, err
 	}
 	patches, _ := parseZypperPatches(ctx, out)
-	return patches, nil
+	return filterZypperPatches(patches, newZypperListPatchOpts(opts)), nil
+}
+
+// filterZypperPatches drops patches that don't match the category and
+// severity filters. zypper already filters when listing, this makes sure
+// callers only get matching patches whatever the zypper version.
+func filterZypperPatches(patches []*ZypperPatch, zOpts *zypperListPatchOpts) []*ZypperPatch {
+	if !zOpts.filtered() {
+		return patches
+	}
+	var filtered []*ZypperPatch
+	for _, p := range patches {
+		if zOpts.matches(p) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
 }
 
 func zypperPatchInfo(ctx context.Context, patches []string) ([]byte, error) {
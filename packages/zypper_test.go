@@ -64,6 +64,61 @@ func TestRemoveZypper(t *testing.T) {
 	}
 }
 
+func TestTransactionalZypper(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	installCmd := utilmocks.EqCmd(exec.Command(transactionalUpdate, append(transactionalUpdateInstallArgs, pkgs...)...))
+	mockCommandRunner.EXPECT().Run(testCtx, installCmd).Return([]byte("stdout"), []byte("stderr"), nil).Times(1)
+	if err := TransactionalInstallZypperPackages(testCtx, pkgs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	removeCmd := utilmocks.EqCmd(exec.Command(transactionalUpdate, append(transactionalUpdateRemoveArgs, pkgs...)...))
+	mockCommandRunner.EXPECT().Run(testCtx, removeCmd).Return([]byte("stdout"), []byte("stderr"), errors.New("error")).Times(1)
+	if err := TransactionalRemoveZypperPackages(testCtx, pkgs); err == nil {
+		t.Errorf("did not get expected error")
+	}
+}
+
+func TestTransactionalPendingPackages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+
+	snapperCmd := utilmocks.EqCmd(exec.Command(snapper, snapperListArgs...))
+	mockCommandRunner.EXPECT().Run(testCtx, snapperCmd).Return([]byte("number,default,active\n0,no,no\n1,yes,yes\n"), []byte("stderr"), nil).Times(1)
+	root, names, err := TransactionalPendingPackages(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if root != "" || names != nil {
+		t.Errorf("TransactionalPendingPackages() = %q, %v, want no pending snapshot", root, names)
+	}
+
+	rpmCmd := utilmocks.EqCmd(exec.Command(rpmquery, append([]string{"--root", "/.snapshots/3/snapshot"}, snapshotRPMNamesArgs...)...))
+	mockCommandRunner.EXPECT().Run(testCtx, snapperCmd).Return([]byte("active,number,default\nyes,1,no\nno,3,yes\n"), []byte("stderr"), nil).Times(1)
+	mockCommandRunner.EXPECT().Run(testCtx, rpmCmd).Return([]byte("foo\nbar\n"), []byte("stderr"), nil).Times(1)
+	root, names, err = TransactionalPendingPackages(testCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]struct{}{"foo": {}, "bar": {}}
+	if root != "/.snapshots/3/snapshot" || !reflect.DeepEqual(names, want) {
+		t.Errorf("TransactionalPendingPackages() = %q, %v, want %q, %v", root, names, "/.snapshots/3/snapshot", want)
+	}
+
+	mockCommandRunner.EXPECT().Run(testCtx, snapperCmd).Return([]byte("number,userdata\n1,\n"), []byte("stderr"), nil).Times(1)
+	if _, _, err := TransactionalPendingPackages(testCtx); err == nil {
+		t.Error("did not get expected error for unexpected snapper columns")
+	}
+}
+
 func TestParseZypperUpdates(t *testing.T) {
 	normalCase := `S | Repository          | Name                   | Current Version | Available Version | Arch
 --+---------------------+------------------------+-----------------+-------------------+-------
@@ -194,6 +249,77 @@ func TestZypperPatches(t *testing.T) {
 	}
 }
 
+func TestZypperPatchesFiltered(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	expectedCmd := utilmocks.EqCmd(exec.Command(zypper, append(zypperListPatchesArgs, "--category=security", "--severity=critical", "--severity=important")...))
+
+	data := []byte(`SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1206 | security    | important | ---         | needed     | Security update for bzip2
+SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1221 | security    | moderate  | ---         | needed     | Security update for libxslt
+SLE-Module-Basesystem15-SP1-Updates | SUSE-SLE-Module-Basesystem-15-SP1-2019-1258 | recommended | important | ---         | needed     | Recommended update for postfix`)
+	mockCommandRunner.EXPECT().Run(testCtx, expectedCmd).Return(data, []byte("stderr"), nil).Times(1)
+	ret, err := ZypperPatches(testCtx, ZypperListSecurityPatches("critical", "important"))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	want := []*ZypperPatch{{"SUSE-SLE-Module-Basesystem-15-SP1-2019-1206", "security", "important", "Security update for bzip2"}}
+	if !reflect.DeepEqual(ret, want) {
+		t.Errorf("ZypperPatches() = %v, want %v", ret, want)
+	}
+}
+
+func TestZypperUpdatesFiltered(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockCommandRunner := utilmocks.NewMockCommandRunner(mockCtrl)
+	runner = mockCommandRunner
+	listPatchesCmd := utilmocks.EqCmd(exec.Command(zypper, append(zypperListPatchesArgs, "--category=Security")...))
+	patchInfoCmd := utilmocks.EqCmd(exec.Command(zypper, append(zypperPatchInfoArgs, "SUSE-SLE-SERVER-12-SP4-2019-2974")...))
+	listUpdatesCmd := utilmocks.EqCmd(exec.Command(zypper, zypperListUpdatesArgs...))
+
+	patches := []byte("SLES12-SP4-Updates | SUSE-SLE-SERVER-12-SP4-2019-2974 | security | important | --- | needed | Security update for irqbalance")
+	info := []byte(`Information for patch SUSE-SLE-SERVER-12-SP4-2019-2974:
+-------------------------------------------------------
+Repository  : SLES12-SP4-Updates
+Name        : SUSE-SLE-SERVER-12-SP4-2019-2974
+Status      : needed
+Category    : security
+Severity    : important
+Provides    : patch:SUSE-SLE-SERVER-12-SP4-2019-2974 = 1
+Conflicts   : [2]
+    irqbalance.src < 1.1.0-9.3.1
+    irqbalance.x86_64 < 1.1.0-9.3.1
+`)
+	updates := []byte(`v | SLES12-SP4-Updates | irqbalance | 1.1.0-9.1 | 1.1.0-9.3.1 | x86_64
+v | SLES12-SP4-Updates | at         | 3.1.14-7.3 | 3.1.14-8.3.1 | x86_64`)
+	gomock.InOrder(
+		mockCommandRunner.EXPECT().Run(testCtx, listPatchesCmd).Return(patches, nil, nil).Times(1),
+		mockCommandRunner.EXPECT().Run(testCtx, patchInfoCmd).Return(info, nil, nil).Times(1),
+		mockCommandRunner.EXPECT().Run(testCtx, listUpdatesCmd).Return(updates, nil, nil).Times(1),
+	)
+	ret, err := ZypperUpdates(testCtx, ZypperListPatchCategories([]string{"Security"}))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	want := []*PkgInfo{{Name: "irqbalance", Arch: "x86_64", Version: "1.1.0-9.3.1"}}
+	if !reflect.DeepEqual(ret, want) {
+		t.Errorf("ZypperUpdates() = %v, want %v", ret, want)
+	}
+
+	// No matching patches means no updates, without listing them.
+	mockCommandRunner.EXPECT().Run(testCtx, listPatchesCmd).Return(nil, nil, nil).Times(1)
+	ret, err = ZypperUpdates(testCtx, ZypperListPatchCategories([]string{"Security"}))
+	if err != nil || ret != nil {
+		t.Errorf("ZypperUpdates() = %v, %v, want nil, nil", ret, err)
+	}
+}
+
 func TestZypperInstalledPatches(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
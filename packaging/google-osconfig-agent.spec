@@ -53,6 +53,8 @@ install -d %{buildroot}%{_unitdir}
 install -d %{buildroot}%{_presetdir}
 install -p -m 0644 %{name}.service %{buildroot}%{_unitdir}
 install -p -m 0644 90-%{name}.preset %{buildroot}%{_presetdir}/90-%{name}.preset
+install -d %{buildroot}/etc/apparmor.d
+install -p -m 0644 %{name}.apparmor %{buildroot}/etc/apparmor.d/google_osconfig_agent
 %endif
 
 %files
@@ -64,6 +66,7 @@ install -p -m 0644 90-%{name}.preset %{buildroot}%{_presetdir}/90-%{name}.preset
 %else
 %{_unitdir}/%{name}.service
 %{_presetdir}/90-%{name}.preset
+%config(noreplace) /etc/apparmor.d/google_osconfig_agent
 %endif
 
 %post
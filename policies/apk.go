@@ -0,0 +1,78 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
+)
+
+// apkChanges applies packages with the ANY manager using apk, guest
+// policies have no apk specific packages or repositories.
+func apkChanges(ctx context.Context, apkInstalled, apkRemoved, apkUpdated []*agentendpointpb.Package) error {
+	var err error
+	var errs []string
+
+	var installed []*packages.PkgInfo
+	if len(apkInstalled) > 0 || len(apkUpdated) > 0 || len(apkRemoved) > 0 {
+		installed, err = packages.InstalledApkPackages(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	var updates []*packages.PkgInfo
+	if len(apkUpdated) > 0 {
+		updates, err = packages.ApkUpdates(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	changes := getNecessaryChanges(installed, updates, apkInstalled, apkRemoved, apkUpdated)
+
+	if changes.packagesToInstall != nil {
+		clog.Infof(ctx, "Installing packages %s", changes.packagesToInstall)
+		if err := packages.InstallApkPackages(ctx, changes.packagesToInstall); err != nil {
+			errs = append(errs, fmt.Sprintf("error installing apk packages: %v", err))
+		}
+	}
+
+	if changes.packagesToUpgrade != nil {
+		clog.Infof(ctx, "Upgrading packages %s", changes.packagesToUpgrade)
+		if err := packages.InstallApkPackages(ctx, changes.packagesToUpgrade); err != nil {
+			errs = append(errs, fmt.Sprintf("error upgrading apk packages: %v", err))
+		}
+	}
+
+	if changes.packagesToRemove != nil {
+		clog.Infof(ctx, "Removing packages %s", changes.packagesToRemove)
+		if err := packages.RemoveApkPackages(ctx, changes.packagesToRemove); err != nil {
+			errs = append(errs, fmt.Sprintf("error removing apk packages: %v", err))
+		}
+	}
+
+	if errs == nil {
+		return nil
+	}
+	return errors.New(strings.Join(errs, ",\n"))
+}
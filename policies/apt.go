@@ -17,15 +17,19 @@ package policies
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/managedfiles"
 	"github.com/GoogleCloudPlatform/osconfig/osinfo"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"golang.org/x/crypto/openpgp"
@@ -39,7 +43,12 @@ var debArchiveTypeMap = map[agentendpointpb.AptRepository_ArchiveType]string{
 	agentendpointpb.AptRepository_DEB_SRC: "deb-src",
 }
 
-const aptGPGFile = "/etc/apt/trusted.gpg.d/osconfig_agent_managed.gpg"
+var (
+	aptGPGFile = "/etc/apt/trusted.gpg.d/osconfig_agent_managed.gpg"
+	// aptKeyringGlob matches the keyrings of single repositories in the
+	// deb822 repo file, they are named after the checksum of the key URL.
+	aptKeyringGlob = "/etc/apt/keyrings/osconfig_agent_managed_*.gpg"
+)
 
 func isArmoredGPGKey(keyData []byte) bool {
 	var buf bytes.Buffer
@@ -131,7 +140,104 @@ func getAptRepoLine(repo *agentendpointpb.AptRepository, useSignedBy bool) strin
 	return line
 }
 
-func aptRepositories(ctx context.Context, repos []*agentendpointpb.AptRepository, repoFile string) error {
+// removeStale removes files written for the apt repo format that is not
+// used, so apt does not see the repositories twice.
+func removeStale(ctx context.Context, paths ...string) {
+	for _, p := range paths {
+		err := os.Remove(p)
+		switch {
+		case err == nil:
+			clog.Infof(ctx, "Removed %s, it is not used by the apt repo format.", p)
+		case !os.IsNotExist(err):
+			clog.Errorf(ctx, "Error removing %s: %v", p, err)
+			continue
+		}
+		managedfiles.Forget(ctx, p)
+	}
+}
+
+// aptKeyring is the keyring of the repositories with the given key.
+func aptKeyring(key string) string {
+	return strings.Replace(aptKeyringGlob, "*", fmt.Sprintf("%x", sha256.Sum256([]byte(key)))[:10], 1)
+}
+
+// aptSourcesRepositories writes repos to a deb822 repo file, each repository
+// trusts only the key it was configured with. The one-line repo file
+// listFile and the keys it trusts are removed.
+func aptSourcesRepositories(ctx context.Context, repos []*agentendpointpb.AptRepository, repoFile, listFile string) error {
+	/*
+		# Repo file managed by Google OSConfig agent
+
+		Types: deb
+		URIs: http://repo1-url/
+		Suites: repo1
+		Components: main
+		Signed-By: /etc/apt/keyrings/osconfig_agent_managed_<checksum>.gpg
+	*/
+	var buf bytes.Buffer
+	buf.WriteString("# Repo file managed by Google OSConfig agent\n")
+	keyrings := map[string]bool{}
+	for _, repo := range repos {
+		archiveType, ok := debArchiveTypeMap[repo.ArchiveType]
+		if !ok {
+			archiveType = "deb"
+		}
+		fmt.Fprintf(&buf, "\nTypes: %s\nURIs: %s\nSuites: %s\n", archiveType, repo.Uri, repo.Distribution)
+		if len(repo.Components) > 0 {
+			fmt.Fprintf(&buf, "Components: %s\n", strings.Join(repo.Components, " "))
+		}
+		key := repo.GetGpgKey()
+		if key == "" {
+			continue
+		}
+		keyring := aptKeyring(key)
+		fmt.Fprintf(&buf, "Signed-By: %s\n", keyring)
+		if keyrings[keyring] {
+			continue
+		}
+		keyrings[keyring] = true
+
+		// A keyring that can not be fetched is left as it is, the
+		// repository keeps working with the key fetched before.
+		entityList, err := getAptGPGKey(key)
+		if err != nil {
+			clog.Errorf(ctx, "Error fetching gpg key %q: %v", key, err)
+			continue
+		}
+		var kbuf bytes.Buffer
+		for _, e := range entityList {
+			if err := e.Serialize(&kbuf); err != nil {
+				clog.Errorf(ctx, "Error serializing gpg key: %v", err)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(keyring), 0755); err != nil {
+			clog.Errorf(ctx, "Error creating keyring directory: %v", err)
+			continue
+		}
+		if err := writeIfChanged(ctx, kbuf.Bytes(), keyring); err != nil {
+			clog.Errorf(ctx, "Error writing gpg key: %v", err)
+		}
+	}
+
+	if err := writeIfChanged(ctx, buf.Bytes(), repoFile); err != nil {
+		return err
+	}
+
+	stale := []string{listFile, aptGPGFile}
+	matches, _ := filepath.Glob(aptKeyringGlob)
+	for _, m := range matches {
+		if !keyrings[m] {
+			stale = append(stale, m)
+		}
+	}
+	removeStale(ctx, stale...)
+	return nil
+}
+
+// aptRepositories writes repos to a one-line repo file, the keys of all
+// repositories are added to one keyring. The deb822 repo file sourcesFile,
+// if set, and its keyrings are removed.
+func aptRepositories(ctx context.Context, repos []*agentendpointpb.AptRepository, repoFile, sourcesFile string) error {
 	var es []*openpgp.Entity
 	var keys []string
 	for _, repo := range repos {
@@ -187,7 +293,14 @@ func aptRepositories(ctx context.Context, repos []*agentendpointpb.AptRepository
 		buf.WriteString(line + "\n")
 	}
 
-	return writeIfChanged(ctx, buf.Bytes(), repoFile)
+	if err := writeIfChanged(ctx, buf.Bytes(), repoFile); err != nil {
+		return err
+	}
+	if sourcesFile != "" {
+		stale, _ := filepath.Glob(aptKeyringGlob)
+		removeStale(ctx, append(stale, sourcesFile)...)
+	}
+	return nil
 }
 
 func aptChanges(ctx context.Context, aptInstalled, aptRemoved, aptUpdated []*agentendpointpb.Package) error {
@@ -15,14 +15,19 @@
 package policies
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"golang.org/x/crypto/openpgp"
+
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
 )
 
@@ -34,7 +39,7 @@ func runAptRepositories(ctx context.Context, repos []*agentendpointpb.AptReposit
 	defer os.RemoveAll(td)
 	testRepo := filepath.Join(td, "testRepo")
 
-	if err := aptRepositories(ctx, repos, testRepo); err != nil {
+	if err := aptRepositories(ctx, repos, testRepo, ""); err != nil {
 		return "", fmt.Errorf("error running aptRepositories: %v", err)
 	}
 
@@ -136,3 +141,63 @@ func TestUseSignedBy(t *testing.T) {
 		}
 	}
 }
+
+func TestAptSourcesRepositories(t *testing.T) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key bytes.Buffer
+	if err := entity.Serialize(&key); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(key.Bytes())
+	}))
+	defer ts.Close()
+
+	td := t.TempDir()
+	oldGPGFile, oldKeyringGlob := aptGPGFile, aptKeyringGlob
+	defer func() { aptGPGFile, aptKeyringGlob = oldGPGFile, oldKeyringGlob }()
+	aptGPGFile = filepath.Join(td, "trusted.gpg.d", "osconfig_agent_managed.gpg")
+	aptKeyringGlob = filepath.Join(td, "keyrings", "osconfig_agent_managed_*.gpg")
+	repoFile := filepath.Join(td, "google_osconfig_managed.sources")
+	listFile := filepath.Join(td, "google_osconfig_managed.list")
+	staleKeyring := strings.Replace(aptKeyringGlob, "*", "stale", 1)
+	for _, f := range []string{aptGPGFile, listFile, staleKeyring} {
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repos := []*agentendpointpb.AptRepository{
+		{Uri: "http://repo1-url/", Distribution: "distribution", Components: []string{"component1"}, GpgKey: ts.URL},
+		{Uri: "http://repo2-url/", Distribution: "distribution", Components: []string{"component1", "component2"}, ArchiveType: agentendpointpb.AptRepository_DEB_SRC},
+	}
+	if err := aptSourcesRepositories(context.Background(), repos, repoFile, listFile); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := aptKeyring(ts.URL)
+	want := "# Repo file managed by Google OSConfig agent\n" +
+		"\nTypes: deb\nURIs: http://repo1-url/\nSuites: distribution\nComponents: component1\nSigned-By: " + keyring + "\n" +
+		"\nTypes: deb-src\nURIs: http://repo2-url/\nSuites: distribution\nComponents: component1 component2\n"
+	got, err := os.ReadFile(repoFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("repo file:\n%q\nwant:\n%q", got, want)
+	}
+	if got, err := os.ReadFile(keyring); err != nil || !bytes.Equal(got, key.Bytes()) {
+		t.Errorf("keyring = %q, %v, want the repository key", got, err)
+	}
+	for _, f := range []string{aptGPGFile, listFile, staleKeyring} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", f)
+		}
+	}
+}
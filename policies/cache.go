@@ -0,0 +1,175 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package policies
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
+	"github.com/GoogleCloudPlatform/osconfig/packages/rpmdb"
+	"github.com/GoogleCloudPlatform/osconfig/policies/recipes"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
+)
+
+var (
+	cacheFile = agentconfig.GuestPolicyCacheFile
+	// maxCacheAge is how long an unchanged guest policy may go without
+	// being applied, changes the state stamp does not see, such as packages
+	// installed by hand with winget, are undone after at most this long.
+	maxCacheAge = 24 * time.Hour
+	cacheNow    = time.Now
+	stateFiles  = localStateFiles
+)
+
+// policyCache records the last guest policy that was applied without
+// errors and a stamp of the local state it left behind. The lookup API has
+// no conditional request, so the policy is still looked up every run, but
+// applying it again is skipped while neither the policy nor the local state
+// has changed.
+type policyCache struct {
+	PolicyHash string    `json:"policyHash"`
+	StateStamp string    `json:"stateStamp"`
+	AppliedAt  time.Time `json:"appliedAt"`
+}
+
+// googetDefaultRoot is where GooGet keeps its database if GooGetRoot is not
+// set.
+const googetDefaultRoot = `C:\ProgramData\GooGet`
+
+func googetDB() string {
+	root := os.Getenv("GooGetRoot")
+	if root == "" {
+		root = googetDefaultRoot
+	}
+	return filepath.Join(root, "googet.db")
+}
+
+// localStateFiles are the files a guest policy run changes: the managed
+// repo files, the package databases and the recipe database.
+func localStateFiles() []string {
+	files := []string{
+		agentconfig.AptRepoFilePath(),
+		agentconfig.YumRepoFilePath(),
+		agentconfig.ZypperRepoFilePath(),
+		agentconfig.GooGetRepoFilePath(),
+		recipes.DBFile(),
+		"/var/lib/dpkg/status",
+		"/lib/apk/db/installed",
+		"/var/lib/pacman/local",
+		"/var/db/pkg",
+		googetDB(),
+	}
+	for _, dir := range rpmdb.Dirs {
+		for _, f := range []string{"rpmdb.sqlite", "Packages.db", "Packages"} {
+			files = append(files, filepath.Join(dir, f))
+		}
+	}
+	return files
+}
+
+// stateStamp summarizes the size and modification time of each state file.
+func stateStamp(files []string) string {
+	h := sha256.New()
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			fmt.Fprintf(h, "%s missing\n", f)
+			continue
+		}
+		fmt.Fprintf(h, "%s %d %d\n", f, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// updates reports whether egp keeps any package or recipe updated, the
+// state stamp does not see new versions in the repositories so these are
+// applied every run.
+func updates(egp *agentendpointpb.EffectiveGuestPolicy) bool {
+	for _, sp := range egp.GetPackages() {
+		if sp.GetPackage().GetDesiredState() == agentendpointpb.DesiredState_UPDATED {
+			return true
+		}
+	}
+	for _, sr := range egp.GetSoftwareRecipes() {
+		if sr.GetSoftwareRecipe().GetDesiredState() == agentendpointpb.DesiredState_UPDATED {
+			return true
+		}
+	}
+	return false
+}
+
+// unchanged reports whether egp was applied before and the local state has
+// not changed since. Policies that keep anything updated are never
+// unchanged.
+func unchanged(ctx context.Context, path string, egp *agentendpointpb.EffectiveGuestPolicy) bool {
+	if updates(egp) {
+		return false
+	}
+	data, err := integrity.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			clog.Warningf(ctx, "Error reading guest policy cache: %v", err)
+		}
+		return false
+	}
+	var c policyCache
+	if err := json.Unmarshal(data, &c); err != nil {
+		clog.Warningf(ctx, "Error parsing guest policy cache %q, ignoring: %v", path, err)
+		return false
+	}
+	hash, err := policyHash(egp)
+	if err != nil {
+		return false
+	}
+	if c.PolicyHash != hash || c.StateStamp != stateStamp(stateFiles()) {
+		return false
+	}
+	if age := cacheNow().Sub(c.AppliedAt); age < 0 || age > maxCacheAge {
+		return false
+	}
+	return true
+}
+
+// saveCache records that egp was applied without errors.
+func saveCache(ctx context.Context, path string, egp *agentendpointpb.EffectiveGuestPolicy) {
+	hash, err := policyHash(egp)
+	if err != nil {
+		clog.Debugf(ctx, "Error hashing effective guest policy, not caching: %v", err)
+		return
+	}
+	data, err := json.Marshal(&policyCache{PolicyHash: hash, StateStamp: stateStamp(stateFiles()), AppliedAt: cacheNow()})
+	if err != nil {
+		return
+	}
+	if err := integrity.WriteFile(path, data, 0600); err != nil {
+		clog.Warningf(ctx, "Error writing guest policy cache: %v", err)
+	}
+}
+
+// clearCache removes the cache so the next run applies the policy.
+func clearCache(ctx context.Context, path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		clog.Warningf(ctx, "Error removing guest policy cache: %v", err)
+	}
+}
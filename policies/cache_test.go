@@ -0,0 +1,109 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package policies
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
+)
+
+func TestPolicyCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "guest_policy.cache")
+	dbFile := filepath.Join(dir, "status")
+	if err := os.WriteFile(dbFile, []byte("Package: foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldStateFiles, oldNow := stateFiles, cacheNow
+	defer func() { stateFiles, cacheNow = oldStateFiles, oldNow }()
+	stateFiles = func() []string { return []string{dbFile, filepath.Join(dir, "missing")} }
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cacheNow = func() time.Time { return now }
+
+	egp := &agentendpointpb.EffectiveGuestPolicy{
+		Packages: []*agentendpointpb.EffectiveGuestPolicy_SourcedPackage{
+			{Source: "policy", Package: &agentendpointpb.Package{Name: "foo"}},
+		},
+	}
+	if unchanged(ctx, path, egp) {
+		t.Error("unchanged() without a cache = true, want false")
+	}
+
+	saveCache(ctx, path, egp)
+	if !unchanged(ctx, path, egp) {
+		t.Error("unchanged() after saveCache = false, want true")
+	}
+
+	other := &agentendpointpb.EffectiveGuestPolicy{
+		Packages: []*agentendpointpb.EffectiveGuestPolicy_SourcedPackage{
+			{Source: "policy", Package: &agentendpointpb.Package{Name: "bar"}},
+		},
+	}
+	if unchanged(ctx, path, other) {
+		t.Error("unchanged() for a different policy = true, want false")
+	}
+
+	// A package database change outside of the agent.
+	if err := os.WriteFile(dbFile, []byte("Package: foo\nPackage: bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if unchanged(ctx, path, egp) {
+		t.Error("unchanged() after the local state changed = true, want false")
+	}
+
+	saveCache(ctx, path, egp)
+	now = now.Add(maxCacheAge + time.Minute)
+	if unchanged(ctx, path, egp) {
+		t.Error("unchanged() for an expired cache = true, want false")
+	}
+
+	// Policies that keep a package updated are applied every run, new
+	// versions in the repositories do not change the state stamp.
+	updated := &agentendpointpb.EffectiveGuestPolicy{
+		Packages: []*agentendpointpb.EffectiveGuestPolicy_SourcedPackage{
+			{Source: "policy", Package: &agentendpointpb.Package{Name: "foo", DesiredState: agentendpointpb.DesiredState_UPDATED}},
+		},
+	}
+	now = now.Add(time.Minute)
+	saveCache(ctx, path, updated)
+	if unchanged(ctx, path, updated) {
+		t.Error("unchanged() for a policy that updates packages = true, want false")
+	}
+
+	clearCache(ctx, path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cache still exists after clearCache: %v", err)
+	}
+}
+
+func TestGooGetDB(t *testing.T) {
+	// The database is never looked up relative to the working directory.
+	t.Setenv("GooGetRoot", "")
+	if got, want := googetDB(), filepath.Join(googetDefaultRoot, "googet.db"); got != want {
+		t.Errorf("googetDB() without GooGetRoot = %q, want %q", got, want)
+	}
+
+	root := t.TempDir()
+	t.Setenv("GooGetRoot", root)
+	if got, want := googetDB(), filepath.Join(root, "googet.db"); got != want {
+		t.Errorf("googetDB() with GooGetRoot = %q, want %q", got, want)
+	}
+}
@@ -119,6 +119,13 @@ func equalSlices(got []string, want []string) bool {
 	return reflect.DeepEqual(got, want)
 }
 
+func TestNotInstalled(t *testing.T) {
+	got := notInstalled(createPackages("googet", "Git.Git", "Microsoft.Edge"), createPkgInfos("googet", "other"))
+	if want := createPackages("Git.Git", "Microsoft.Edge"); !reflect.DeepEqual(got, want) {
+		t.Errorf("notInstalled() = %v, want %v", got, want)
+	}
+}
+
 func createPkgInfos(names ...string) []*packages.PkgInfo {
 	var res []*packages.PkgInfo
 	for _, n := range names {
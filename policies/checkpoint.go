@@ -0,0 +1,139 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package policies
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
+	"google.golang.org/protobuf/proto"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
+)
+
+// checkpoint records the steps of a guest policy run that have completed so
+// that a run interrupted by an agent restart can resume where it left off
+// instead of repeating package manager operations. Progress is only reused
+// if the effective policy has not changed since it was recorded and the
+// checkpoint is not older than checkpointMaxAge, past that the host may
+// have changed and every step runs again.
+type checkpoint struct {
+	Hash string          `json:"hash"`
+	Done map[string]bool `json:"done"`
+	// Updated is when a step was last recorded.
+	Updated time.Time `json:"updated"`
+
+	path string
+	// failed and succeeded count the steps of this run, steps completed
+	// before a restart count as succeeded.
+	failed, succeeded int
+}
+
+var (
+	checkpointMaxAge = 6 * time.Hour
+	checkpointNow    = time.Now
+)
+
+func policyHash(egp *agentendpointpb.EffectiveGuestPolicy) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(egp)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// loadCheckpoint reads the checkpoint at path for egp, if the stored
+// checkpoint is for a different policy a fresh one is returned.
+func loadCheckpoint(ctx context.Context, path string, egp *agentendpointpb.EffectiveGuestPolicy) *checkpoint {
+	cp := &checkpoint{Done: map[string]bool{}, path: path}
+	hash, err := policyHash(egp)
+	if err != nil {
+		clog.Debugf(ctx, "Error hashing effective guest policy, not checkpointing: %v", err)
+		cp.path = ""
+		return cp
+	}
+	cp.Hash = hash
+
+	data, err := integrity.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			clog.Warningf(ctx, "Error reading guest policy checkpoint: %v", err)
+		}
+		return cp
+	}
+	var stored checkpoint
+	if err := json.Unmarshal(data, &stored); err != nil {
+		clog.Warningf(ctx, "Error parsing guest policy checkpoint %q, ignoring: %v", path, err)
+		return cp
+	}
+	if stored.Hash != hash || len(stored.Done) == 0 {
+		return cp
+	}
+	if age := checkpointNow().Sub(stored.Updated); stored.Updated.IsZero() || age > checkpointMaxAge || age < 0 {
+		clog.Infof(ctx, "Ignoring guest policy checkpoint last updated at %s, running all steps.", stored.Updated.Format(time.RFC3339))
+		return cp
+	}
+	clog.Infof(ctx, "Resuming interrupted guest policy run, %d step(s) already completed.", len(stored.Done))
+	cp.Done = stored.Done
+	return cp
+}
+
+func (c *checkpoint) save() error {
+	if c.path == "" {
+		return nil
+	}
+	c.Updated = checkpointNow().UTC()
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return integrity.WriteFile(c.path, data, 0600)
+}
+
+// step runs f unless step has already completed, on success step is recorded
+// as complete. Failed and succeeded steps are counted.
+func (c *checkpoint) step(ctx context.Context, step string, f func() error) error {
+	if c.Done[step] {
+		clog.Debugf(ctx, "Skipping guest policy step %q, completed before restart.", step)
+		c.succeeded++
+		return nil
+	}
+	if err := f(); err != nil {
+		c.failed++
+		return err
+	}
+	c.succeeded++
+	c.Done[step] = true
+	if err := c.save(); err != nil {
+		clog.Warningf(ctx, "Error writing guest policy checkpoint: %v", err)
+	}
+	return nil
+}
+
+// clear removes the checkpoint once a run has finished.
+func (c *checkpoint) clear(ctx context.Context) {
+	if c.path == "" {
+		return
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		clog.Warningf(ctx, "Error removing guest policy checkpoint: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package policies
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	egp := &agentendpointpb.EffectiveGuestPolicy{
+		Packages: []*agentendpointpb.EffectiveGuestPolicy_SourcedPackage{
+			{Source: "policy", Package: &agentendpointpb.Package{Name: "foo"}},
+		},
+	}
+
+	var ran []string
+	step := func(name string, err error) func() error {
+		return func() error {
+			ran = append(ran, name)
+			return err
+		}
+	}
+
+	cp := loadCheckpoint(ctx, path, egp)
+	cp.step(ctx, "apt-repos", step("apt-repos", nil))
+	cp.step(ctx, "apt-changes", step("apt-changes", errors.New("interrupted")))
+	if cp.failed != 1 || cp.succeeded != 1 {
+		t.Errorf("failed = %d, succeeded = %d, want 1, 1", cp.failed, cp.succeeded)
+	}
+
+	// Simulate a restart, only the failed step should run again.
+	ran = nil
+	cp = loadCheckpoint(ctx, path, egp)
+	cp.step(ctx, "apt-repos", step("apt-repos", nil))
+	cp.step(ctx, "apt-changes", step("apt-changes", nil))
+	if want := []string{"apt-changes"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+	// Steps completed before the restart count as succeeded.
+	if cp.failed != 0 || cp.succeeded != 2 {
+		t.Errorf("after restart failed = %d, succeeded = %d, want 0, 2", cp.failed, cp.succeeded)
+	}
+
+	// A changed policy starts from scratch.
+	ran = nil
+	changed := &agentendpointpb.EffectiveGuestPolicy{
+		Packages: []*agentendpointpb.EffectiveGuestPolicy_SourcedPackage{
+			{Source: "policy", Package: &agentendpointpb.Package{Name: "bar"}},
+		},
+	}
+	cp = loadCheckpoint(ctx, path, changed)
+	cp.step(ctx, "apt-repos", step("apt-repos", nil))
+	if want := []string{"apt-repos"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+
+	// A stale checkpoint starts from scratch.
+	oldNow := checkpointNow
+	defer func() { checkpointNow = oldNow }()
+	checkpointNow = func() time.Time { return oldNow().Add(checkpointMaxAge + time.Minute) }
+	ran = nil
+	cp = loadCheckpoint(ctx, path, changed)
+	cp.step(ctx, "apt-repos", step("apt-repos", nil))
+	if want := []string{"apt-repos"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q for a stale checkpoint, want %q", ran, want)
+	}
+
+	cp.clear(ctx)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected checkpoint to be removed, got: %v", err)
+	}
+}
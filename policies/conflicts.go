@@ -0,0 +1,233 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package policies
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/agentendpoint"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
+)
+
+// When a guest policy and an OS policy in enforcement mode manage the same
+// package or repository differently each run would undo the other. OS
+// policies win: the conflicting guest policy entries are not applied and
+// the conflicts are written to the conflict report after each guest policy
+// run.
+
+var conflictsFile = filepath.Join(agentconfig.CacheDir(), "osconfig_policy_conflicts.json")
+
+// osPolicyPrecedence is why the OS policy wins a conflict.
+const osPolicyPrecedence = "OS policy assignments take precedence over guest policies"
+
+var managedResources = agentendpoint.ManagedResources
+
+type conflictReport struct {
+	CheckedAt time.Time   `json:"checkedAt"`
+	Conflicts []*conflict `json:"conflicts"`
+}
+
+type conflict struct {
+	// Kind is package or repository.
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Manager string `json:"manager"`
+
+	// GuestPolicy is the guest policy the skipped entry comes from, empty
+	// for the local config.
+	GuestPolicy string `json:"guestPolicy,omitempty"`
+	// GuestState is the guest policy desired state, repository URL or apt
+	// repository components.
+	GuestState string `json:"guestState"`
+
+	OSPolicyAssignment string `json:"osPolicyAssignment"`
+	OSPolicyID         string `json:"osPolicyId"`
+	OSPolicyResourceID string `json:"osPolicyResourceId"`
+	// OSPolicyState is the OS policy desired state, repository URL or apt
+	// repository components.
+	OSPolicyState string `json:"osPolicyState"`
+
+	Winner string `json:"winner"`
+	Reason string `json:"reason"`
+}
+
+func newConflict(kind, name, manager, guestPolicy, guestState string, m *agentendpoint.ManagedResource, osState string) *conflict {
+	return &conflict{
+		Kind:               kind,
+		Name:               name,
+		Manager:            manager,
+		GuestPolicy:        guestPolicy,
+		GuestState:         guestState,
+		OSPolicyAssignment: m.Assignment,
+		OSPolicyID:         m.PolicyID,
+		OSPolicyResourceID: m.ResourceID,
+		OSPolicyState:      osState,
+		Winner:             "osPolicy",
+		Reason:             osPolicyPrecedence,
+	}
+}
+
+// guestManagers are the OS policy package managers each guest policy
+// package manager applies to.
+var guestManagers = map[agentendpointpb.Package_Manager][]string{
+	agentendpointpb.Package_MANAGER_UNSPECIFIED: {"apt", "yum", "zypper", "googet"},
+	agentendpointpb.Package_ANY:                 {"apt", "yum", "zypper", "googet"},
+	agentendpointpb.Package_APT:                 {"apt"},
+	agentendpointpb.Package_YUM:                 {"yum"},
+	agentendpointpb.Package_ZYPPER:              {"zypper"},
+	agentendpointpb.Package_GOO:                 {"googet"},
+}
+
+func appliesTo(m agentendpointpb.Package_Manager, manager string) bool {
+	for _, gm := range guestManagers[m] {
+		if gm == manager {
+			return true
+		}
+	}
+	return false
+}
+
+// guestRemoves reports whether the guest policy desired state removes the
+// package.
+func guestRemoves(s agentendpointpb.DesiredState) bool {
+	return s == agentendpointpb.DesiredState_REMOVED
+}
+
+// guestRepository returns the repository r in the form the effective policy
+// file records OS policy repositories, see agentendpoint.ManagedResource.
+func guestRepository(r *agentendpointpb.PackageRepository) (manager, name, url, components string) {
+	switch {
+	case r.GetApt() != nil:
+		apt := r.GetApt()
+		archive := "deb"
+		if apt.GetArchiveType() == agentendpointpb.AptRepository_DEB_SRC {
+			archive = "deb-src"
+		}
+		return "apt", archive + " " + apt.GetDistribution(), util.StripURLCredentials(apt.GetUri()), strings.Join(apt.GetComponents(), " ")
+	case r.GetYum() != nil:
+		return "yum", r.GetYum().GetId(), util.StripURLCredentials(r.GetYum().GetBaseUrl()), ""
+	case r.GetZypper() != nil:
+		return "zypper", r.GetZypper().GetId(), util.StripURLCredentials(r.GetZypper().GetBaseUrl()), ""
+	case r.GetGoo() != nil:
+		return "googet", r.GetGoo().GetName(), util.StripURLCredentials(r.GetGoo().GetUrl()), ""
+	}
+	return "", "", "", ""
+}
+
+// resolveConflicts removes the packages and repositories from egp that
+// conflict with managed OS policy resources and returns the conflicts.
+// A package conflicts if one side installs it and the other removes it, a
+// repository if both use the same id with different URLs. Apt repositories
+// have no id, they conflict if both use the same URL and distribution with
+// different components.
+func resolveConflicts(egp *agentendpointpb.EffectiveGuestPolicy, managed []*agentendpoint.ManagedResource) []*conflict {
+	var conflicts []*conflict
+
+	var pkgs []*agentendpointpb.EffectiveGuestPolicy_SourcedPackage
+	for _, sp := range egp.GetPackages() {
+		pkg := sp.GetPackage()
+		var c *conflict
+		for _, m := range managed {
+			if m.Package == "" || m.Package != pkg.GetName() || !appliesTo(pkg.GetManager(), m.Manager) {
+				continue
+			}
+			if guestRemoves(pkg.GetDesiredState()) != (m.DesiredState == "REMOVED") {
+				c = newConflict("package", pkg.GetName(), m.Manager, sp.GetSource(), pkg.GetDesiredState().String(), m, m.DesiredState)
+				break
+			}
+		}
+		if c != nil {
+			conflicts = append(conflicts, c)
+			continue
+		}
+		pkgs = append(pkgs, sp)
+	}
+	egp.Packages = pkgs
+
+	var repos []*agentendpointpb.EffectiveGuestPolicy_SourcedPackageRepository
+	for _, sr := range egp.GetPackageRepositories() {
+		manager, name, url, components := guestRepository(sr.GetPackageRepository())
+		var c *conflict
+		for _, m := range managed {
+			if name == "" || m.Repository != name || m.Manager != manager {
+				continue
+			}
+			if manager == "apt" {
+				if m.RepositoryURL == url && m.Components != components {
+					c = newConflict("repository", url+" "+name, manager, sr.GetSource(), components, m, m.Components)
+					break
+				}
+				continue
+			}
+			if m.RepositoryURL != url {
+				c = newConflict("repository", name, manager, sr.GetSource(), url, m, m.RepositoryURL)
+				break
+			}
+		}
+		if c != nil {
+			conflicts = append(conflicts, c)
+			continue
+		}
+		repos = append(repos, sr)
+	}
+	egp.PackageRepositories = repos
+
+	return conflicts
+}
+
+// checkConflicts removes the entries of egp that conflict with OS policies
+// and writes the conflict report.
+func checkConflicts(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy) {
+	managed, err := managedResources()
+	if err != nil {
+		clog.Warningf(ctx, "Error reading OS policy resources, not checking for conflicts: %v", err)
+		return
+	}
+	conflicts := resolveConflicts(egp, managed)
+	for _, c := range conflicts {
+		clog.Warningf(ctx, "Skipping %s %q from guest policy %q (%s), it conflicts with OS policy %q resource %q (%s): %s.",
+			c.Kind, c.Name, c.GuestPolicy, c.GuestState, c.OSPolicyID, c.OSPolicyResourceID, c.OSPolicyState, c.Reason)
+	}
+	writeConflictReport(ctx, conflicts, time.Now())
+}
+
+// writeConflictReport writes the conflicts found by the last guest policy
+// run, an empty list clears the previous report.
+func writeConflictReport(ctx context.Context, conflicts []*conflict, now time.Time) {
+	if conflicts == nil {
+		conflicts = []*conflict{}
+	}
+	data, err := json.MarshalIndent(&conflictReport{CheckedAt: now.UTC(), Conflicts: conflicts}, "", "  ")
+	if err != nil {
+		clog.Warningf(ctx, "Error marshaling policy conflict report: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(conflictsFile), 0755); err != nil {
+		clog.Warningf(ctx, "Error writing policy conflict report: %v", err)
+		return
+	}
+	if err := util.AtomicWrite(conflictsFile, data, 0600); err != nil {
+		clog.Warningf(ctx, "Error writing policy conflict report: %v", err)
+	}
+}
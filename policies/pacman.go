@@ -0,0 +1,78 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
+)
+
+// pacmanChanges applies packages with the ANY manager using pacman, guest
+// policies have no pacman specific packages or repositories.
+func pacmanChanges(ctx context.Context, pacmanInstalled, pacmanRemoved, pacmanUpdated []*agentendpointpb.Package) error {
+	var err error
+	var errs []string
+
+	var installed []*packages.PkgInfo
+	if len(pacmanInstalled) > 0 || len(pacmanUpdated) > 0 || len(pacmanRemoved) > 0 {
+		installed, err = packages.InstalledPacmanPackages(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	var updates []*packages.PkgInfo
+	if len(pacmanUpdated) > 0 {
+		updates, err = packages.PacmanUpdates(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	changes := getNecessaryChanges(installed, updates, pacmanInstalled, pacmanRemoved, pacmanUpdated)
+
+	if changes.packagesToInstall != nil {
+		clog.Infof(ctx, "Installing packages %s", changes.packagesToInstall)
+		if err := packages.InstallPacmanPackages(ctx, changes.packagesToInstall); err != nil {
+			errs = append(errs, fmt.Sprintf("error installing pacman packages: %v", err))
+		}
+	}
+
+	if changes.packagesToUpgrade != nil {
+		clog.Infof(ctx, "Upgrading packages %s", changes.packagesToUpgrade)
+		if err := packages.InstallPacmanPackages(ctx, changes.packagesToUpgrade); err != nil {
+			errs = append(errs, fmt.Sprintf("error upgrading pacman packages: %v", err))
+		}
+	}
+
+	if changes.packagesToRemove != nil {
+		clog.Infof(ctx, "Removing packages %s", changes.packagesToRemove)
+		if err := packages.RemovePacmanPackages(ctx, changes.packagesToRemove); err != nil {
+			errs = append(errs, fmt.Sprintf("error removing pacman packages: %v", err))
+		}
+	}
+
+	if errs == nil {
+		return nil
+	}
+	return errors.New(strings.Join(errs, ",\n"))
+}
@@ -19,6 +19,8 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"errors"
+	"fmt"
 	"hash"
 	"io"
 	"os"
@@ -26,7 +28,11 @@ import (
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/agentendpoint"
+	"github.com/GoogleCloudPlatform/osconfig/changefreeze"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/crashloop"
+	"github.com/GoogleCloudPlatform/osconfig/history"
+	"github.com/GoogleCloudPlatform/osconfig/managedfiles"
 	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"github.com/GoogleCloudPlatform/osconfig/policies/recipes"
 	"github.com/GoogleCloudPlatform/osconfig/retryutil"
@@ -36,17 +42,58 @@ import (
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
 )
 
-func run(ctx context.Context) {
+var checkpointFile = agentconfig.GuestPolicyCheckpointFile
+
+// ErrLookupFailed is returned by RunOnce if the effective guest policies could
+// not be looked up, any local config is still applied.
+var ErrLookupFailed = errors.New("error looking up effective guest policies")
+
+// PartialFailureError is returned by RunOnce if some guest policy steps failed.
+type PartialFailureError struct {
+	Failed int
+}
+
+func (e *PartialFailureError) Error() string {
+	return fmt.Sprintf("%d guest policy step(s) failed", e.Failed)
+}
+
+// FailureError is returned by RunOnce if every guest policy step failed.
+type FailureError struct {
+	Failed int
+}
+
+func (e *FailureError) Error() string {
+	return fmt.Sprintf("all %d guest policy step(s) failed", e.Failed)
+}
+
+func run(ctx context.Context) error {
+	ctx = clog.WithSubsystem(ctx, "policies")
+	end, err := crashloop.Begin(ctx, "guest policies")
+	if err != nil {
+		clog.Errorf(ctx, "Skipping guest policies: %v", err)
+		return err
+	}
+	defer end()
+
+	// Guest policies have no state to report, the whole run is skipped.
+	if err := changefreeze.Check(ctx); err != nil {
+		clog.Warningf(ctx, "Skipped guest policies: %v", err)
+		return nil
+	}
+
 	var resp *agentendpointpb.EffectiveGuestPolicy
+	var lookupErr error
 
-	client, err := agentendpoint.NewBetaClient(ctx)
+	client, release, err := agentendpoint.SharedBetaClient(ctx)
 	if err != nil {
-		clog.Errorf(ctx, "agentendpoint.NewBetaClient Error: %v", err)
+		clog.Errorf(ctx, "agentendpoint.SharedBetaClient Error: %v", err)
+		lookupErr = fmt.Errorf("%w: %v", ErrLookupFailed, err)
 	} else {
-		defer client.Close()
 		resp, err = client.LookupEffectiveGuestPolicies(ctx)
+		release()
 		if err != nil {
 			clog.Errorf(ctx, "Error running LookupEffectiveGuestPolicies: %v", err)
+			lookupErr = fmt.Errorf("%w: %v", ErrLookupFailed, err)
 		}
 	}
 
@@ -56,10 +103,32 @@ func run(ctx context.Context) {
 	}
 
 	effective := mergeConfigs(local, resp)
+	checkConflicts(ctx, effective)
 
+	if lookupErr == nil && unchanged(ctx, cacheFile(), effective) {
+		clog.Infof(ctx, "Effective guest policy and local state are unchanged since the last run, not applying it again.")
+		return nil
+	}
+	// An interrupted run must not leave a cache behind.
+	clearCache(ctx, cacheFile())
+
+	cp := loadCheckpoint(ctx, checkpointFile(), effective)
 	// We don't check the error from setConfig or installRecipes as all errors are already logged.
-	setConfig(ctx, effective)
-	installRecipes(ctx, effective)
+	setConfig(ctx, effective, cp)
+	installRecipes(ctx, effective, cp)
+	cp.clear(ctx)
+
+	if lookupErr != nil {
+		return lookupErr
+	}
+	if cp.failed > 0 && cp.succeeded == 0 {
+		return &FailureError{Failed: cp.failed}
+	}
+	if cp.failed > 0 {
+		return &PartialFailureError{Failed: cp.failed}
+	}
+	saveCache(ctx, cacheFile(), effective)
+	return nil
 }
 
 // Run looks up osconfigs and applies them using tasker.Enqueue.
@@ -67,10 +136,29 @@ func Run(ctx context.Context) {
 	tasker.Enqueue(ctx, "Run GuestPolicies", func() { run(ctx) })
 }
 
-func installRecipes(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy) error {
+// RunOnce looks up osconfigs and applies them using tasker.Enqueue, waiting
+// for the run to complete. Errors from individual steps are logged, an
+// ErrLookupFailed, FailureError or PartialFailureError is returned to
+// summarize the run.
+func RunOnce(ctx context.Context) error {
+	var err error
+	done := make(chan struct{})
+	tasker.Enqueue(ctx, "Run GuestPolicies", func() {
+		err = run(ctx)
+		close(done)
+	})
+	<-done
+	return err
+}
+
+func installRecipes(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy, cp *checkpoint) error {
+	if len(egp.GetSoftwareRecipes()) > 0 {
+		// Recipe steps may change packages by running scripts.
+		defer packages.InvalidateQueryCache()
+	}
 	for _, recipe := range egp.GetSoftwareRecipes() {
 		if r := recipe.GetSoftwareRecipe(); r != nil {
-			if err := recipes.InstallRecipe(ctx, r); err != nil {
+			if err := cp.step(ctx, "recipe:"+r.GetName(), func() error { return recipes.InstallRecipe(ctx, r) }); err != nil {
 				clog.Errorf(ctx, "Error installing recipe: %v", err)
 			}
 		}
@@ -78,7 +166,7 @@ func installRecipes(ctx context.Context, egp *agentendpointpb.EffectiveGuestPoli
 	return nil
 }
 
-func setConfig(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy) {
+func setConfig(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy, cp *checkpoint) {
 	var aptRepos []*agentendpointpb.AptRepository
 	var yumRepos []*agentendpointpb.YumRepository
 	var zypperRepos []*agentendpointpb.ZypperRepository
@@ -106,6 +194,10 @@ func setConfig(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy) {
 	var aptInstallPkgs, aptRemovePkgs, aptUpdatePkgs []*agentendpointpb.Package
 	var yumInstallPkgs, yumRemovePkgs, yumUpdatePkgs []*agentendpointpb.Package
 	var zypperInstallPkgs, zypperRemovePkgs, zypperUpdatePkgs []*agentendpointpb.Package
+	var apkInstallPkgs, apkRemovePkgs, apkUpdatePkgs []*agentendpointpb.Package
+	var pacmanInstallPkgs, pacmanRemovePkgs, pacmanUpdatePkgs []*agentendpointpb.Package
+	var portageInstallPkgs, portageRemovePkgs, portageUpdatePkgs []*agentendpointpb.Package
+	var wingetInstallPkgs, wingetRemovePkgs, wingetUpdatePkgs []*agentendpointpb.Package
 	for _, pkg := range egp.GetPackages() {
 		switch pkg.GetPackage().GetManager() {
 		case agentendpointpb.Package_ANY, agentendpointpb.Package_MANAGER_UNSPECIFIED:
@@ -115,16 +207,28 @@ func setConfig(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy) {
 				aptInstallPkgs = append(aptInstallPkgs, pkg.GetPackage())
 				yumInstallPkgs = append(yumInstallPkgs, pkg.GetPackage())
 				zypperInstallPkgs = append(zypperInstallPkgs, pkg.GetPackage())
+				apkInstallPkgs = append(apkInstallPkgs, pkg.GetPackage())
+				pacmanInstallPkgs = append(pacmanInstallPkgs, pkg.GetPackage())
+				portageInstallPkgs = append(portageInstallPkgs, pkg.GetPackage())
+				wingetInstallPkgs = append(wingetInstallPkgs, pkg.GetPackage())
 			case agentendpointpb.DesiredState_REMOVED:
 				gooRemovePkgs = append(gooRemovePkgs, pkg.GetPackage())
 				aptRemovePkgs = append(aptRemovePkgs, pkg.GetPackage())
 				yumRemovePkgs = append(yumRemovePkgs, pkg.GetPackage())
 				zypperRemovePkgs = append(zypperRemovePkgs, pkg.GetPackage())
+				apkRemovePkgs = append(apkRemovePkgs, pkg.GetPackage())
+				pacmanRemovePkgs = append(pacmanRemovePkgs, pkg.GetPackage())
+				portageRemovePkgs = append(portageRemovePkgs, pkg.GetPackage())
+				wingetRemovePkgs = append(wingetRemovePkgs, pkg.GetPackage())
 			case agentendpointpb.DesiredState_UPDATED:
 				gooUpdatePkgs = append(gooUpdatePkgs, pkg.GetPackage())
 				aptUpdatePkgs = append(aptUpdatePkgs, pkg.GetPackage())
 				yumUpdatePkgs = append(yumUpdatePkgs, pkg.GetPackage())
 				zypperUpdatePkgs = append(zypperUpdatePkgs, pkg.GetPackage())
+				apkUpdatePkgs = append(apkUpdatePkgs, pkg.GetPackage())
+				pacmanUpdatePkgs = append(pacmanUpdatePkgs, pkg.GetPackage())
+				portageUpdatePkgs = append(portageUpdatePkgs, pkg.GetPackage())
+				wingetUpdatePkgs = append(wingetUpdatePkgs, pkg.GetPackage())
 			}
 		case agentendpointpb.Package_GOO:
 			switch pkg.GetPackage().GetDesiredState() {
@@ -166,49 +270,143 @@ func setConfig(ctx context.Context, egp *agentendpointpb.EffectiveGuestPolicy) {
 		}
 	}
 
+	if len(yumInstallPkgs)+len(yumRemovePkgs)+len(yumUpdatePkgs)+len(zypperInstallPkgs)+len(zypperRemovePkgs)+len(zypperUpdatePkgs) > 0 {
+		mark := packages.MarkTransactions(ctx)
+		defer func() { recordTransactions(ctx, mark.Transactions(ctx)) }()
+	}
+
 	if packages.GooGetExists {
-		if err := googetRepositories(ctx, gooRepos, agentconfig.GooGetRepoFilePath()); err != nil {
+		if err := cp.step(ctx, "googet-repos", func() error {
+			return googetRepositories(ctx, gooRepos, agentconfig.GooGetRepoFilePath())
+		}); err != nil {
 			clog.Errorf(ctx, "Error writing googet repo file: %v", err)
 		}
-		if err := retryutil.RetryFunc(ctx, 1*time.Minute, "Applying googet changes", func() error {
-			return googetChanges(ctx, gooInstallPkgs, gooRemovePkgs, gooUpdatePkgs)
+		if err := cp.step(ctx, "googet-changes", func() error {
+			return retryutil.RetryFunc(ctx, 1*time.Minute, "Applying googet changes", func() error {
+				return googetChanges(ctx, gooInstallPkgs, gooRemovePkgs, gooUpdatePkgs)
+			})
 		}); err != nil {
 			clog.Errorf(ctx, "Error performing googet changes: %v", err)
 		}
 	}
 
 	if packages.AptExists {
-		if err := aptRepositories(ctx, aptRepos, agentconfig.AptRepoFilePath()); err != nil {
+		if err := cp.step(ctx, "apt-repos", func() error {
+			if agentconfig.AptDeb822() {
+				return aptSourcesRepositories(ctx, aptRepos, agentconfig.AptSourcesFilePath(), agentconfig.AptRepoFilePath())
+			}
+			return aptRepositories(ctx, aptRepos, agentconfig.AptRepoFilePath(), agentconfig.AptSourcesFilePath())
+		}); err != nil {
 			clog.Errorf(ctx, "Error writing apt repo file: %v", err)
 		}
-		if err := retryutil.RetryFunc(ctx, 1*time.Minute, "Applying apt changes", func() error {
-			return aptChanges(ctx, aptInstallPkgs, aptRemovePkgs, aptUpdatePkgs)
+		if err := cp.step(ctx, "apt-changes", func() error {
+			return retryutil.RetryFunc(ctx, 1*time.Minute, "Applying apt changes", func() error {
+				return aptChanges(ctx, aptInstallPkgs, aptRemovePkgs, aptUpdatePkgs)
+			})
 		}); err != nil {
 			clog.Errorf(ctx, "Error performing apt changes: %v", err)
 		}
 	}
 
 	if packages.YumExists {
-		if err := yumRepositories(ctx, yumRepos, agentconfig.YumRepoFilePath()); err != nil {
+		if err := cp.step(ctx, "yum-repos", func() error {
+			return yumRepositories(ctx, yumRepos, agentconfig.YumRepoFilePath())
+		}); err != nil {
 			clog.Errorf(ctx, "Error writing yum repo file: %v", err)
 		}
-		if err := retryutil.RetryFunc(ctx, 1*time.Minute, "Applying yum changes", func() error {
-			return yumChanges(ctx, yumInstallPkgs, yumRemovePkgs, yumUpdatePkgs)
+		if err := cp.step(ctx, "yum-changes", func() error {
+			return retryutil.RetryFunc(ctx, 1*time.Minute, "Applying yum changes", func() error {
+				return yumChanges(ctx, yumInstallPkgs, yumRemovePkgs, yumUpdatePkgs)
+			})
 		}); err != nil {
 			clog.Errorf(ctx, "Error performing yum changes: %v", err)
 		}
 	}
 
 	if packages.ZypperExists {
-		if err := zypperRepositories(ctx, zypperRepos, agentconfig.ZypperRepoFilePath()); err != nil {
+		if err := cp.step(ctx, "zypper-repos", func() error {
+			return zypperRepositories(ctx, zypperRepos, agentconfig.ZypperRepoFilePath())
+		}); err != nil {
 			clog.Errorf(ctx, "Error writing zypper repo file: %v", err)
 		}
-		if err := retryutil.RetryFunc(ctx, 1*time.Minute, "Applying zypper changes.", func() error {
-			return zypperChanges(ctx, zypperInstallPkgs, zypperRemovePkgs, zypperUpdatePkgs)
+		if err := cp.step(ctx, "zypper-changes", func() error {
+			return retryutil.RetryFunc(ctx, 1*time.Minute, "Applying zypper changes.", func() error {
+				return zypperChanges(ctx, zypperInstallPkgs, zypperRemovePkgs, zypperUpdatePkgs)
+			})
 		}); err != nil {
 			clog.Errorf(ctx, "Error performing zypper changes: %v", err)
 		}
 	}
+
+	if packages.ApkExists {
+		if err := cp.step(ctx, "apk-changes", func() error {
+			return retryutil.RetryFunc(ctx, 1*time.Minute, "Applying apk changes", func() error {
+				return apkChanges(ctx, apkInstallPkgs, apkRemovePkgs, apkUpdatePkgs)
+			})
+		}); err != nil {
+			clog.Errorf(ctx, "Error performing apk changes: %v", err)
+		}
+	}
+
+	if packages.PacmanExists {
+		if err := cp.step(ctx, "pacman-changes", func() error {
+			return retryutil.RetryFunc(ctx, 1*time.Minute, "Applying pacman changes", func() error {
+				return pacmanChanges(ctx, pacmanInstallPkgs, pacmanRemovePkgs, pacmanUpdatePkgs)
+			})
+		}); err != nil {
+			clog.Errorf(ctx, "Error performing pacman changes: %v", err)
+		}
+	}
+
+	if packages.PortageExists {
+		if err := cp.step(ctx, "portage-changes", func() error {
+			return retryutil.RetryFunc(ctx, 1*time.Minute, "Applying portage changes", func() error {
+				return portageChanges(ctx, portageInstallPkgs, portageRemovePkgs, portageUpdatePkgs)
+			})
+		}); err != nil {
+			clog.Errorf(ctx, "Error performing portage changes: %v", err)
+		}
+	}
+
+	// winget only manages packages with the ANY manager. With GooGet present
+	// it gets the ones GooGet did not install, so the same package is not
+	// installed twice.
+	if packages.WingetExists {
+		if packages.GooGetExists {
+			installed, err := packages.InstalledGooGetPackages(ctx)
+			if err != nil {
+				clog.Errorf(ctx, "Error listing installed googet packages: %v", err)
+			}
+			wingetInstallPkgs = notInstalled(wingetInstallPkgs, installed)
+			wingetUpdatePkgs = notInstalled(wingetUpdatePkgs, installed)
+		}
+		if err := cp.step(ctx, "winget-changes", func() error {
+			return retryutil.RetryFunc(ctx, 1*time.Minute, "Applying winget changes", func() error {
+				return wingetChanges(ctx, wingetInstallPkgs, wingetRemovePkgs, wingetUpdatePkgs)
+			})
+		}); err != nil {
+			clog.Errorf(ctx, "Error performing winget changes: %v", err)
+		}
+	}
+}
+
+// transactionRecord is the local history record of the package manager
+// transactions made by a guest policy run.
+type transactionRecord struct {
+	TaskType     string                  `json:"taskType"`
+	Transactions []*packages.Transaction `json:"transactions"`
+}
+
+func recordTransactions(ctx context.Context, txs []*packages.Transaction) {
+	if len(txs) == 0 {
+		return
+	}
+	for _, tx := range txs {
+		if tx.ID != "" {
+			clog.Infof(ctx, "Packages changed in %s transaction %s.", tx.Manager, tx.ID)
+		}
+	}
+	history.Add(ctx, history.Transaction, &transactionRecord{TaskType: "GuestPolicies", Transactions: txs})
 }
 
 func checksum(r io.Reader) hash.Hash {
@@ -232,6 +430,11 @@ func writeIfChanged(ctx context.Context, content []byte, path string) error {
 		}
 	}
 
+	managedfiles.CheckFile(ctx, path)
 	clog.Infof(ctx, "Writing repo file %s with updated contents", path)
-	return util.AtomicWrite(path, content, 0644)
+	if err := util.AtomicWrite(path, content, 0644); err != nil {
+		return err
+	}
+	managedfiles.Record(ctx, path)
+	return nil
 }
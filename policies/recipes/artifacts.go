@@ -24,13 +24,26 @@ import (
 	"path/filepath"
 
 	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
 	"github.com/GoogleCloudPlatform/osconfig/external"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
 	"github.com/GoogleCloudPlatform/osconfig/util"
 
 	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
 )
 
+var (
+	verifyDebSignature = packages.VerifyDebSignature
+	verifyRPMSignature = packages.VerifyRPMSignature
+	// artifactSignature is the signature verification required of deb and
+	// rpm artifacts.
+	artifactSignature = func() packages.SignatureOptions {
+		verify, keyrings := agentconfig.PackageSignatures()
+		return packages.SignatureOptions{Verify: verify, Keyrings: keyrings}
+	}
+)
+
 // fetchArtifacts takes in a slice of artifacts and downloads them into the specified directory,
 // Returns a map of artifact names to their new locations on the local disk.
 func fetchArtifacts(ctx context.Context, artifacts []*agentendpointpb.SoftwareRecipe_Artifact, directory string) (map[string]string, error) {
@@ -88,10 +101,29 @@ func fetchArtifact(ctx context.Context, artifact *agentendpointpb.SoftwareRecipe
 	if _, err := util.AtomicWriteFileStream(reader, checksum, localPath, 0600); err != nil {
 		return "", fmt.Errorf("Error downloading stream: %v", err)
 	}
+	// Only package artifacts carry signatures, scripts and archives are
+	// covered by their checksum.
+	if signature := artifactSignature(); signature.Verify && (extension == ".deb" || extension == ".rpm") {
+		if err := verifyArtifact(ctx, localPath, extension, signature.Keyrings); err != nil {
+			return "", fmt.Errorf("error verifying the signature of artifact %q: %v", artifact.Id, err)
+		}
+	}
 
 	return localPath, nil
 }
 
+// verifyArtifact verifies the signature of a deb or rpm artifact, the
+// artifact type is taken from its extension.
+func verifyArtifact(ctx context.Context, path, extension string, keyrings []string) error {
+	switch extension {
+	case ".deb":
+		return verifyDebSignature(path, keyrings)
+	case ".rpm":
+		return verifyRPMSignature(ctx, path, keyrings)
+	}
+	return fmt.Errorf("signatures can only be verified for deb and rpm artifacts, not %q", extension)
+}
+
 func getHTTPArtifact(ctx context.Context, client *http.Client, uri url.URL) (io.ReadCloser, error) {
 	if !isSupportedURL(uri) {
 		return nil, fmt.Errorf("error, unsupported protocol scheme %s", uri.Scheme)
@@ -45,3 +45,23 @@ func TestRecipesgetStoragePathWitouthExtension(t *testing.T) {
 		t.Errorf("Expected(%s); got(%s)", expect, localpath)
 	}
 }
+
+func TestVerifyArtifact(t *testing.T) {
+	oldVerifyDebSignature := verifyDebSignature
+	defer func() { verifyDebSignature = oldVerifyDebSignature }()
+	var verified string
+	verifyDebSignature = func(path string, keyrings []string) error {
+		verified = path
+		return nil
+	}
+
+	if err := verifyArtifact(context.Background(), "/tmp/artifact-id-1.deb", ".deb", nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if verified != "/tmp/artifact-id-1.deb" {
+		t.Errorf("Expected the deb signature of /tmp/artifact-id-1.deb to be verified; got(%q)", verified)
+	}
+	if err := verifyArtifact(context.Background(), "/tmp/artifact-id-1.txt", ".txt", nil); err == nil {
+		t.Error("Expected error for an artifact that is not a deb or rpm package")
+	}
+}
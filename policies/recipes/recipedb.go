@@ -15,12 +15,16 @@
 package recipes
 
 import (
+	"context"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
 	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/integrity"
 )
 
 var (
@@ -32,19 +36,19 @@ var (
 // RecipeDB represents local state of installed recipes.
 type RecipeDB map[string]Recipe
 
-// newRecipeDB instantiates a recipeDB.
-func newRecipeDB() (RecipeDB, error) {
+// newRecipeDB instantiates a recipeDB. A recipeDB that was modified outside
+// of the agent is discarded so that recipes are reinstalled.
+func newRecipeDB(ctx context.Context) (RecipeDB, error) {
 	db := make(RecipeDB)
-	f, err := os.Open(filepath.Join(getDbDir(), dbFileName))
+	bytes, err := integrity.ReadFile(filepath.Join(getDbDir(), dbFileName))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return db, nil
 		}
-		return nil, err
-	}
-	defer f.Close()
-	bytes, err := ioutil.ReadAll(f)
-	if err != nil {
+		if errors.Is(err, integrity.ErrTampered) {
+			clog.Errorf(ctx, "Discarding recipe database: %v", err)
+			return db, nil
+		}
 		return nil, err
 	}
 	var recipelist []Recipe
@@ -84,21 +88,12 @@ func (db RecipeDB) addRecipe(name, version string, success bool) error {
 	if err := os.MkdirAll(dbDir, 0755); err != nil {
 		return err
 	}
+	return integrity.WriteFile(filepath.Join(dbDir, dbFileName), dbBytes, 0600)
+}
 
-	f, err := ioutil.TempFile(dbDir, dbFileName+"_*")
-	if err != nil {
-		return err
-	}
-
-	if _, err := f.Write(dbBytes); err != nil {
-		f.Close()
-		return err
-	}
-	if err := f.Close(); err != nil {
-		return err
-	}
-
-	return os.Rename(f.Name(), filepath.Join(dbDir, dbFileName))
+// DBFile is the location of the recipe database.
+func DBFile() string {
+	return filepath.Join(getDbDir(), dbFileName)
 }
 
 func getDbDir() string {
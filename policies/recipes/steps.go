@@ -46,6 +46,8 @@ var extensionMap = map[agentendpointpb.SoftwareRecipe_Step_RunScript_Interpreter
 	agentendpointpb.SoftwareRecipe_Step_RunScript_POWERSHELL:              ".ps1",
 }
 
+var msiInstall = packages.MSIInstall
+
 func stepCopyFile(step *agentendpointpb.SoftwareRecipe_Step_CopyFile, artifacts map[string]string, runEnvs []string, stepDir string) error {
 	dest, err := util.NormPath(step.Destination)
 	if err != nil {
@@ -379,11 +381,16 @@ func stepInstallMsi(ctx context.Context, step *agentendpointpb.SoftwareRecipe_St
 	if !ok {
 		return fmt.Errorf("%q not found in artifact map", artifact)
 	}
-	args := step.Flags
-	if len(args) == 0 {
-		args = []string{"/i", "/qn", "/norestart"}
+	// Flags that only set properties are passed to packages.MSIInstall,
+	// any other flags replace the msiexec command line.
+	if onlyMSIProperties(step.Flags) {
+		var codes []int
+		for _, c := range step.AllowedExitCodes {
+			codes = append(codes, int(c))
+		}
+		return msiInstall(ctx, path, packages.MSIOptions{Properties: step.Flags, SuccessCodes: codes})
 	}
-	args = append(args, path)
+	args := append(step.Flags, path)
 
 	exitCodes := step.AllowedExitCodes
 	if len(exitCodes) == 0 {
@@ -392,6 +399,17 @@ func stepInstallMsi(ctx context.Context, step *agentendpointpb.SoftwareRecipe_St
 	return executeCommand(ctx, "C:\\Windows\\System32\\msiexec.exe", args, stepDir, runEnvs, exitCodes)
 }
 
+// onlyMSIProperties reports whether flags are all msiexec property
+// assignments, such as "INSTALLDIR=C:\Foo" or "TRANSFORMS=site.mst".
+func onlyMSIProperties(flags []string) bool {
+	for _, f := range flags {
+		if strings.HasPrefix(f, "/") || strings.HasPrefix(f, "-") || !strings.Contains(f, "=") {
+			return false
+		}
+	}
+	return true
+}
+
 func stepInstallDpkg(ctx context.Context, step *agentendpointpb.SoftwareRecipe_Step_InstallDpkg, artifacts map[string]string) error {
 	if !packages.DpkgExists {
 		return fmt.Errorf("dpkg does not exist on system")
@@ -481,7 +499,11 @@ func stepRunScript(ctx context.Context, step *agentendpointpb.SoftwareRecipe_Ste
 
 func executeCommand(ctx context.Context, cmd string, args []string, workDir string, runEnvs []string, allowedExitCodes []int32) error {
 	cmdObj := exec.Command(cmd, args...)
-	cmdObj.Dir = workDir
+	dir, err := util.WorkingDir(workDir)
+	if err != nil {
+		return err
+	}
+	cmdObj.Dir = dir
 	defaultEnv, err := createDefaultEnvironment()
 	if err != nil {
 		return fmt.Errorf("error creating default environment: %v", err)
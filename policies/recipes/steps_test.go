@@ -0,0 +1,35 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package recipes
+
+import "testing"
+
+func TestOnlyMSIProperties(t *testing.T) {
+	tests := []struct {
+		flags []string
+		want  bool
+	}{
+		{nil, true},
+		{[]string{`INSTALLDIR=C:\Foo`, "TRANSFORMS=site.mst"}, true},
+		{[]string{"/i", "/qn"}, false},
+		{[]string{`INSTALLDIR=C:\Foo`, "/l*v", `C:\log.txt`}, false},
+		{[]string{"-quiet"}, false},
+	}
+	for _, tt := range tests {
+		if got := onlyMSIProperties(tt.flags); got != tt.want {
+			t.Errorf("onlyMSIProperties(%q) = %t, want %t", tt.flags, got, tt.want)
+		}
+	}
+}
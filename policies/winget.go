@@ -0,0 +1,94 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+
+	agentendpointpb "google.golang.org/genproto/googleapis/cloud/osconfig/agentendpoint/v1beta"
+)
+
+// notInstalled returns the packages in pkgs that are not in installed.
+func notInstalled(pkgs []*agentendpointpb.Package, installed []*packages.PkgInfo) []*agentendpointpb.Package {
+	names := map[string]bool{}
+	for _, pkg := range installed {
+		names[pkg.Name] = true
+	}
+	var ret []*agentendpointpb.Package
+	for _, pkg := range pkgs {
+		if !names[pkg.GetName()] {
+			ret = append(ret, pkg)
+		}
+	}
+	return ret
+}
+
+// wingetChanges applies packages with the ANY manager using winget, package
+// names are winget package ids. Guest policies have no winget specific
+// packages or repositories.
+func wingetChanges(ctx context.Context, wingetInstalled, wingetRemoved, wingetUpdated []*agentendpointpb.Package) error {
+	var err error
+	var errs []string
+
+	var installed []*packages.PkgInfo
+	if len(wingetInstalled) > 0 || len(wingetUpdated) > 0 || len(wingetRemoved) > 0 {
+		installed, err = packages.InstalledWingetPackages(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	var updates []*packages.PkgInfo
+	if len(wingetUpdated) > 0 {
+		updates, err = packages.WingetUpdates(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	changes := getNecessaryChanges(installed, updates, wingetInstalled, wingetRemoved, wingetUpdated)
+
+	if changes.packagesToInstall != nil {
+		clog.Infof(ctx, "Installing packages %s", changes.packagesToInstall)
+		if err := packages.InstallWingetPackages(ctx, changes.packagesToInstall); err != nil {
+			errs = append(errs, fmt.Sprintf("error installing winget packages: %v", err))
+		}
+	}
+
+	if changes.packagesToUpgrade != nil {
+		clog.Infof(ctx, "Upgrading packages %s", changes.packagesToUpgrade)
+		if err := packages.UpdateWingetPackages(ctx, changes.packagesToUpgrade); err != nil {
+			errs = append(errs, fmt.Sprintf("error upgrading winget packages: %v", err))
+		}
+	}
+
+	if changes.packagesToRemove != nil {
+		clog.Infof(ctx, "Removing packages %s", changes.packagesToRemove)
+		if err := packages.RemoveWingetPackages(ctx, changes.packagesToRemove); err != nil {
+			errs = append(errs, fmt.Sprintf("error removing winget packages: %v", err))
+		}
+	}
+
+	if errs == nil {
+		return nil
+	}
+	return errors.New(strings.Join(errs, ",\n"))
+}
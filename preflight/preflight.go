@@ -0,0 +1,322 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package preflight runs connectivity checks at agent startup so that an
+// agent that is not reporting can be diagnosed from a single log block.
+package preflight
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// Check categories.
+const (
+	CategoryMetadata   = "metadata"
+	CategoryAuth       = "auth"
+	CategoryDNS        = "dns"
+	CategoryTLS        = "tls"
+	CategoryRepository = "repository"
+)
+
+// hints are the actionable next steps logged for a failed category.
+var hints = map[string]string{
+	CategoryMetadata:   "ensure the instance has an active network and a route to the metadata server (169.254.169.254)",
+	CategoryAuth:       "ensure the instance can request identity tokens from the metadata server",
+	CategoryDNS:        "check the DNS settings and that googleapis.com resolves, Private Google Access may be required",
+	CategoryTLS:        "check that egress to port 443, or to the configured proxy, is allowed and that no proxy intercepts TLS",
+	CategoryRepository: "check that the package repository is reachable from the instance",
+}
+
+// Result is the outcome of a single pre-flight check.
+type Result struct {
+	Category string
+	Target   string
+	Latency  time.Duration
+	Err      error
+}
+
+var (
+	checkTimeout = 10 * time.Second
+	// maxRepoHosts limits how many repository hosts are checked.
+	maxRepoHosts = 10
+
+	lookupHost = net.DefaultResolver.LookupHost
+	// proxyFor returns the proxy the agent and package managers use for
+	// addr, nil for a direct connection.
+	proxyFor = func(scheme, addr string) (*url.URL, error) {
+		return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+	}
+	dialTLS = func(ctx context.Context, addr string) error {
+		conn, err := dial(ctx, "https", addr)
+		if err != nil {
+			return err
+		}
+		host, _ := splitEndpoint(addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host})
+		defer tc.Close()
+		return tc.HandshakeContext(ctx)
+	}
+	dialTCP = func(ctx context.Context, scheme, addr string) error {
+		conn, err := dial(ctx, scheme, addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	idToken  = agentconfig.IDToken
+	repoURLs = managedRepoURLs
+
+	urlRE = regexp.MustCompile(`(?i)\bhttps?://[^\s"']+`)
+)
+
+func timed(ctx context.Context, category, target string, f func(ctx context.Context) error) *Result {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	start := time.Now()
+	err := f(ctx)
+	return &Result{Category: category, Target: target, Latency: time.Since(start), Err: err}
+}
+
+// dial connects to addr the way a client of scheme would, through the proxy
+// configured in the environment if there is one. HTTPS connections are
+// tunneled with CONNECT, for HTTP the proxy itself is the peer.
+func dial(ctx context.Context, scheme, addr string) (net.Conn, error) {
+	proxy, err := proxyFor(scheme, addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy configuration: %v", err)
+	}
+	d := &net.Dialer{}
+	if proxy == nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	proxyPort := proxy.Port()
+	if proxyPort == "" {
+		proxyPort = "80"
+		if strings.EqualFold(proxy.Scheme, "https") {
+			proxyPort = "443"
+		}
+	}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(proxy.Hostname(), proxyPort))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to proxy %s: %v", proxy.Host, err)
+	}
+	if strings.EqualFold(proxy.Scheme, "https") {
+		tc := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			tc.Close()
+			return nil, fmt.Errorf("error connecting to proxy %s: %v", proxy.Host, err)
+		}
+		conn = tc
+	}
+	if scheme != "https" {
+		return conn, nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if u := proxy.User; u != nil {
+		p, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+p)))
+	}
+	br := bufio.NewReader(conn)
+	resp, err := func() (*http.Response, error) {
+		if err := req.Write(conn); err != nil {
+			return nil, err
+		}
+		return http.ReadResponse(br, req)
+	}()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting through proxy %s: %v", proxy.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused the connection to %s: %s", proxy.Host, addr, resp.Status)
+	}
+	return conn, nil
+}
+
+func checkMetadata(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+agentconfig.MetadataHost()+"/computeMetadata/v1/instance/id", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %q", resp.Status)
+	}
+	return nil
+}
+
+// splitEndpoint returns the host and port of an endpoint, the port defaults
+// to 443.
+func splitEndpoint(endpoint string) (string, string) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return endpoint, "443"
+	}
+	return host, port
+}
+
+// managedRepoURLs returns the URLs referenced by the osconfig managed repo
+// files.
+func managedRepoURLs() []string {
+	var files []string
+	for _, format := range []string{agentconfig.AptRepoFormat(), agentconfig.AptSourcesFormat(), agentconfig.YumRepoFormat(), agentconfig.ZypperRepoFormat(), agentconfig.GooGetRepoFormat()} {
+		matches, _ := filepath.Glob(fmt.Sprintf(format, "*"))
+		files = append(files, matches...)
+	}
+	files = append(files, agentconfig.AptRepoFilePath(), agentconfig.AptSourcesFilePath(), agentconfig.YumRepoFilePath(), agentconfig.ZypperRepoFilePath(), agentconfig.GooGetRepoFilePath())
+
+	var urls []string
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		urls = append(urls, urlRE.FindAllString(string(data), -1)...)
+	}
+	return urls
+}
+
+// repoHosts returns the unique scheme, host and port of urls.
+func repoHosts(urls []string) []*url.URL {
+	seen := map[string]bool{}
+	var hosts []*url.URL
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Hostname() == "" {
+			continue
+		}
+		scheme := strings.ToLower(parsed.Scheme)
+		port := parsed.Port()
+		if port == "" {
+			port = "80"
+			if scheme == "https" {
+				port = "443"
+			}
+		}
+		h := &url.URL{Scheme: scheme, Host: net.JoinHostPort(parsed.Hostname(), port)}
+		if !seen[h.String()] {
+			seen[h.String()] = true
+			hosts = append(hosts, h)
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].String() < hosts[j].String() })
+	if len(hosts) > maxRepoHosts {
+		hosts = hosts[:maxRepoHosts]
+	}
+	return hosts
+}
+
+// Run runs the connectivity checks: metadata server, identity token,
+// agentendpoint DNS and TLS, and the hosts of the managed package
+// repositories. Connections go through the proxy configured in the
+// environment, like those of the agent and the package managers.
+func Run(ctx context.Context) []*Result {
+	var results []*Result
+	results = append(results, timed(ctx, CategoryMetadata, agentconfig.MetadataHost(), checkMetadata))
+	results = append(results, timed(ctx, CategoryAuth, "identity token", func(context.Context) error {
+		_, err := idToken()
+		return err
+	}))
+
+	host, port := splitEndpoint(agentconfig.SvcEndpoint())
+	// Behind a proxy only the proxy host is resolved locally.
+	dnsHost := host
+	if proxy, err := proxyFor("https", net.JoinHostPort(host, port)); err == nil && proxy != nil {
+		dnsHost = proxy.Hostname()
+	}
+	dns := timed(ctx, CategoryDNS, dnsHost, func(ctx context.Context) error {
+		_, err := lookupHost(ctx, dnsHost)
+		return err
+	})
+	results = append(results, dns)
+	if dns.Err == nil {
+		results = append(results, timed(ctx, CategoryTLS, net.JoinHostPort(host, port), func(ctx context.Context) error {
+			return dialTLS(ctx, net.JoinHostPort(host, port))
+		}))
+	}
+
+	for _, h := range repoHosts(repoURLs()) {
+		results = append(results, timed(ctx, CategoryRepository, h.Host, func(ctx context.Context) error {
+			return dialTCP(ctx, h.Scheme, h.Host)
+		}))
+	}
+	return results
+}
+
+// Format renders results as a table, with a hint for each failed category.
+func Format(results []*Result) string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CATEGORY\tTARGET\tRESULT\tLATENCY\tERROR")
+	failed := map[string]bool{}
+	var order []string
+	for _, r := range results {
+		status, msg := "OK", ""
+		if r.Err != nil {
+			status, msg = "FAIL", r.Err.Error()
+			if !failed[r.Category] {
+				failed[r.Category] = true
+				order = append(order, r.Category)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Category, r.Target, status, r.Latency.Round(time.Millisecond), msg)
+	}
+	tw.Flush()
+	for _, c := range order {
+		fmt.Fprintf(&b, "%s: %s\n", c, hints[c])
+	}
+	return b.String()
+}
+
+// RunAndLog runs the checks and logs the result matrix as a single entry.
+func RunAndLog(ctx context.Context) {
+	results := Run(ctx)
+	for _, r := range results {
+		if r.Err != nil {
+			clog.Warningf(ctx, "Network pre-flight checks failed:\n%s", Format(results))
+			return
+		}
+	}
+	clog.Infof(ctx, "Network pre-flight checks passed:\n%s", Format(results))
+}
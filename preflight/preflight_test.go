@@ -0,0 +1,176 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package preflight
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRepoHosts(t *testing.T) {
+	urls := []string{
+		"https://packages.cloud.google.com/apt",
+		"https://packages.cloud.google.com/yum/repos/el8",
+		"http://mirror.example.com:8080/centos",
+		"http://deb.debian.org/debian",
+		"file:///mnt/mirror",
+	}
+	want := []string{"http://deb.debian.org:80", "http://mirror.example.com:8080", "https://packages.cloud.google.com:443"}
+	var got []string
+	for _, h := range repoHosts(urls) {
+		got = append(got, h.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("repoHosts() = %q, want %q", got, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer ts.Close()
+	oldHost, hadHost := os.LookupEnv("GCE_METADATA_HOST")
+	os.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	defer func() {
+		if hadHost {
+			os.Setenv("GCE_METADATA_HOST", oldHost)
+		} else {
+			os.Unsetenv("GCE_METADATA_HOST")
+		}
+	}()
+
+	oldLookup, oldTLS, oldTCP, oldToken, oldRepos, oldProxy := lookupHost, dialTLS, dialTCP, idToken, repoURLs, proxyFor
+	defer func() {
+		lookupHost, dialTLS, dialTCP, idToken, repoURLs, proxyFor = oldLookup, oldTLS, oldTCP, oldToken, oldRepos, oldProxy
+	}()
+	proxyFor = func(string, string) (*url.URL, error) { return nil, nil }
+	idToken = func() (string, error) { return "token", nil }
+	lookupHost = func(context.Context, string) ([]string, error) { return []string{"127.0.0.1"}, nil }
+	dialTLS = func(context.Context, string) error { return nil }
+	dialTCP = func(_ context.Context, _, addr string) error {
+		if addr == "bad.example.com:443" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	repoURLs = func() []string { return []string{"https://good.example.com/repo", "https://bad.example.com/repo"} }
+
+	results := Run(ctx)
+	var got []string
+	for _, r := range results {
+		status := "OK"
+		if r.Err != nil {
+			status = "FAIL"
+		}
+		got = append(got, r.Category+" "+status)
+	}
+	want := []string{"metadata OK", "auth OK", "dns OK", "tls OK", "repository FAIL", "repository OK"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Run() = %q, want %q", got, want)
+	}
+
+	out := Format(results)
+	if !strings.Contains(out, "bad.example.com:443") || !strings.Contains(out, "connection refused") || !strings.Contains(out, hints[CategoryRepository]) {
+		t.Errorf("unexpected Format() output:\n%s", out)
+	}
+	if strings.Contains(out, hints[CategoryTLS]) {
+		t.Errorf("hint logged for passing category:\n%s", out)
+	}
+
+	// TLS is not checked if DNS fails.
+	lookupHost = func(context.Context, string) ([]string, error) { return nil, errors.New("no such host") }
+	repoURLs = func() []string { return nil }
+	results = Run(ctx)
+	if len(results) != 3 || results[2].Category != CategoryDNS || results[2].Err == nil {
+		t.Errorf("expected failed DNS check to be last, got: %s", Format(results))
+	}
+
+	// Behind a proxy the proxy host is resolved.
+	var resolved string
+	lookupHost = func(_ context.Context, host string) ([]string, error) {
+		resolved = host
+		return []string{"127.0.0.1"}, nil
+	}
+	proxyFor = func(string, string) (*url.URL, error) { return url.Parse("http://proxy.example.com:3128") }
+	Run(ctx)
+	if resolved != "proxy.example.com" {
+		t.Errorf("resolved %q behind a proxy, want the proxy host", resolved)
+	}
+}
+
+func TestDialProxy(t *testing.T) {
+	ctx := context.Background()
+	var connects []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		connects = append(connects, r.Host)
+		if r.Host == "blocked.example.com:443" || r.Header.Get("Proxy-Authorization") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	old := proxyFor
+	defer func() { proxyFor = old }()
+	proxyFor = func(string, string) (*url.URL, error) {
+		u, err := url.Parse(proxy.URL)
+		u.User = url.UserPassword("user", "pass")
+		return u, err
+	}
+
+	if err := dialTCP(ctx, "https", "repo.example.com:443"); err != nil {
+		t.Errorf("dialTCP() through proxy: %v", err)
+	}
+	if err := dialTCP(ctx, "https", "blocked.example.com:443"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("dialTCP() to a host refused by the proxy = %v, want 403 error", err)
+	}
+	// Plain HTTP repositories are fetched through the proxy without CONNECT.
+	if err := dialTCP(ctx, "http", "mirror.example.com:80"); err != nil {
+		t.Errorf("dialTCP() of an http repository through proxy: %v", err)
+	}
+	want := []string{"repo.example.com:443", "blocked.example.com:443"}
+	if !reflect.DeepEqual(connects, want) {
+		t.Errorf("proxy CONNECT requests = %q, want %q", connects, want)
+	}
+
+	// Without a proxy the host is dialed directly.
+	proxyFor = func(string, string) (*url.URL, error) { return nil, nil }
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	if err := dialTCP(ctx, "https", addr); err == nil {
+		t.Error("expected direct dial to a closed port to fail")
+	}
+}
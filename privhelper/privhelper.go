@@ -0,0 +1,335 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package privhelper lets the agent run as an unprivileged user. Commands
+// that need root are run through sudo as "google_osconfig_agent privhelper",
+// which only runs package manager and reboot commands in the fixed forms
+// listed in commands, so a compromised agent process can not run arbitrary
+// commands as root. Package managers are only ever given package names:
+// installing a local package file or passing arbitrary options would let the
+// caller run its own code as root.
+package privhelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+// Arg is the agent argument that runs the helper.
+const Arg = "privhelper"
+
+// exitDenied is the helper exit code when a command is not run.
+const exitDenied = 126
+
+var (
+	sudo = "/usr/bin/sudo"
+
+	// env is the environment commands are run with, the caller's
+	// environment is not passed on.
+	env = []string{"PATH=/usr/sbin:/usr/bin:/sbin:/bin", "HOME=/root", "LC_ALL=C", "DEBIAN_FRONTEND=noninteractive"}
+
+	// pkgName matches package names, including name=version, name-version,
+	// name.arch and zypper's patch:name forms. It does not match options or
+	// paths.
+	pkgName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.+:~=@-]*$`)
+	// portageAtom matches portage package atoms, which may have a category
+	// and version operators.
+	portageAtom = regexp.MustCompile(`^[<>=~]{0,2}([A-Za-z0-9_][A-Za-z0-9_.+-]*/)?[A-Za-z0-9_][A-Za-z0-9_.+:~@*-]*$`)
+	// packageFileExts are the extensions of the package files the package
+	// managers install when given one in place of a package name. An
+	// operand with one of them is never a package name.
+	packageFileExts = []string{".rpm", ".deb", ".apk", ".ebuild", ".tbz2", ".gpkg.tar", ".flatpak", ".flatpakref"}
+
+	// commands are the commands the helper runs, with the forms each may be
+	// run in. Only root owned system paths are listed, never package
+	// managers that live in user writable locations like Homebrew.
+	commands = map[string][]form{
+		"/bin/systemctl": {{args: []string{"reboot"}}},
+		"/bin/reboot":    {{}},
+		"/bin/shutdown":  {{args: []string{"-r", "-t", "0"}}},
+
+		"/usr/bin/apt-get": {
+			{args: []string{"update"}},
+			{args: []string{"install", "-y", "-o", "APT::Status-Fd=1"}, flags: []string{"--allow-downgrades"}, operands: pkgName},
+			{args: []string{"remove", "-y"}, operands: pkgName},
+			{args: []string{"autoremove", "--purge", "-y"}},
+			{args: []string{"clean"}},
+		},
+		"/usr/bin/apt-mark": {
+			{args: []string{"hold"}, operands: pkgName},
+			{args: []string{"unhold"}, operands: pkgName},
+		},
+		"/usr/bin/dpkg": {{args: []string{"--configure", "-a"}}},
+		"/usr/bin/yum": {
+			{args: []string{"install", "--assumeyes"}, operands: pkgName},
+			{args: []string{"remove", "--assumeyes"}, operands: pkgName},
+			{args: []string{"clean", "all"}},
+			{args: []string{"--quiet", "versionlock", "add"}, operands: pkgName},
+			{args: []string{"--quiet", "versionlock", "delete"}, operands: pkgName},
+		},
+		"/usr/bin/dnf": {
+			{args: []string{"module", "enable", "--assumeyes"}, operands: pkgName},
+			{args: []string{"module", "switch-to", "--assumeyes"}, operands: pkgName},
+		},
+		"/usr/bin/zypper": {
+			{args: []string{"--gpg-auto-import-keys", "--non-interactive", "install", "--auto-agree-with-licenses"}, operands: pkgName},
+			{args: []string{"--non-interactive", "remove"}, operands: pkgName},
+			{args: []string{"--non-interactive", "clean", "--all"}},
+		},
+		"/usr/sbin/transactional-update": {
+			{args: []string{"--non-interactive", "--continue", "pkg", "install"}, operands: pkgName},
+			{args: []string{"--non-interactive", "--continue", "pkg", "remove"}, operands: pkgName},
+		},
+		"/usr/bin/snapper": {{args: []string{"--no-dbus", "--csv", "list", "--columns", "number,default,active"}}},
+		"/sbin/apk": {
+			{args: []string{"update", "--quiet"}},
+			{args: []string{"add", "--upgrade"}, operands: pkgName},
+			{args: []string{"del"}, operands: pkgName},
+		},
+		"/usr/bin/pacman": {
+			{args: []string{"--sync", "--refresh", "--needed", "--noconfirm"}, operands: pkgName},
+			{args: []string{"--remove", "--noconfirm"}, operands: pkgName},
+		},
+		"/usr/bin/emerge": {
+			{args: []string{"--ask=n", "--color=n", "--nospinner", "--quiet-build=y", "--update"}, operands: portageAtom, category: true},
+			{args: []string{"--ask=n", "--color=n", "--nospinner", "--deselect"}, operands: portageAtom, category: true},
+			{args: []string{"--ask=n", "--color=n", "--nospinner", "--depclean"}, operands: portageAtom, category: true},
+		},
+		"/usr/bin/flatpak": {
+			{args: []string{"install", "--system", "--noninteractive", "--assumeyes"}, operands: pkgName},
+			{args: []string{"uninstall", "--system", "--noninteractive", "--assumeyes"}, operands: pkgName},
+		},
+	}
+
+	// agent is the path of the agent executable sudo runs, set by Enable.
+	agent string
+
+	geteuid      = os.Geteuid
+	checkOwner   = ownedByRoot
+	evalSymlinks = filepath.EvalSymlinks
+	execve       = syscall.Exec
+	chdir        = os.Chdir
+
+	// stopDelay is how long a command has to exit after it is sent SIGTERM
+	// before it is killed.
+	stopDelay = 30 * time.Second
+)
+
+// Enable makes commands that need root run through the helper when the
+// agent is not root. agentPath is the agent executable sudo runs. Enable
+// must be called before the agent runs any commands.
+func Enable(agentPath string) {
+	agent = agentPath
+	packages.WrapCommandRunners(func(r util.CommandRunner) util.CommandRunner { return &Runner{Next: r} })
+}
+
+// Needed reports whether commands that need root have to go through the
+// helper.
+func Needed() bool {
+	return agent != "" && geteuid() != 0
+}
+
+// form is an allowed form of a command: its fixed leading arguments,
+// followed in any order by the listed flags and, if operands is set, at
+// least one operand matching it. Operands never contain a / unless category
+// is set, for category/package atoms.
+type form struct {
+	args     []string
+	flags    []string
+	operands *regexp.Regexp
+	category bool
+}
+
+func (f form) matches(args []string) bool {
+	if len(args) < len(f.args) || !slices.Equal(args[:len(f.args)], f.args) {
+		return false
+	}
+	var n int
+	for _, a := range args[len(f.args):] {
+		switch {
+		case slices.Contains(f.flags, a):
+		case f.operands != nil && f.operands.MatchString(a) && !packageFile(a, f.category):
+			n++
+		default:
+			return false
+		}
+	}
+	return f.operands == nil || n > 0
+}
+
+// packageFile reports whether the operand a could be taken as a package
+// file, which the package manager would install as root from a directory
+// the caller controls.
+func packageFile(a string, category bool) bool {
+	if !category && strings.Contains(a, "/") {
+		return true
+	}
+	lower := strings.ToLower(a)
+	for _, ext := range packageFileExts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed returns an error if the helper may not run path with args.
+func allowed(path string, args []string) error {
+	forms, ok := commands[path]
+	if !ok {
+		return fmt.Errorf("%s is not allowed", path)
+	}
+	for _, f := range forms {
+		if f.matches(args) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s with args %q is not allowed", path, args)
+}
+
+// Command returns cmd to be run through the helper if that is needed and
+// the helper may run it, otherwise cmd is returned as is.
+func Command(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	if !Needed() || allowed(cmd.Path, cmd.Args[1:]) != nil {
+		return cmd
+	}
+	args := []string{"-n", agent, Arg, "--", cmd.Path}
+	args = append(args, cmd.Args[1:]...)
+
+	w := exec.CommandContext(ctx, sudo, args...)
+	w.Stdin, w.Stdout, w.Stderr = cmd.Stdin, cmd.Stdout, cmd.Stderr
+	// sudo can not pass on SIGKILL, stop the command with SIGTERM instead.
+	w.Cancel = func() error { return w.Process.Signal(syscall.SIGTERM) }
+	w.WaitDelay = stopDelay
+	return w
+}
+
+// Runner is a CommandRunner that runs commands that need root through the
+// helper.
+type Runner struct {
+	// Next runs the commands.
+	Next util.CommandRunner
+}
+
+// Run runs cmd, through the helper if that is needed.
+func (r *Runner) Run(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
+	return r.Next.Run(ctx, Command(ctx, cmd))
+}
+
+// parseArgs parses the helper arguments: "--" followed by the command to
+// run.
+func parseArgs(args []string) (path string, cmdArgs []string, err error) {
+	if len(args) == 0 || args[0] != "--" {
+		return "", nil, errors.New(`arguments must start with "--"`)
+	}
+	if len(args) < 2 {
+		return "", nil, errors.New("no command")
+	}
+	return args[1], args[2:], nil
+}
+
+// check returns an error if the helper may not run path with args,
+// otherwise it returns the path with symlinks resolved to run.
+func check(path string, args []string) (string, error) {
+	if geteuid() != 0 {
+		return "", errors.New("helper is not running as root")
+	}
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return "", fmt.Errorf("command path %q is not clean and absolute", path)
+	}
+	if err := allowed(path, args); err != nil {
+		return "", err
+	}
+	resolved, err := evalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	// The command and every directory above it must only be writable by
+	// root, otherwise the command could be replaced after it is checked.
+	for p := resolved; ; p = filepath.Dir(p) {
+		if err := checkOwner(p); err != nil {
+			return "", err
+		}
+		if p == filepath.Dir(p) {
+			return resolved, nil
+		}
+	}
+}
+
+// Main runs the helper with the agent arguments that follow Arg. On success
+// it does not return, the helper is replaced by the command. Otherwise it
+// writes the error to stderr and returns the exit code.
+func Main(args []string, stderr io.Writer) int {
+	path, cmdArgs, err := parseArgs(args)
+	var resolved string
+	if err == nil {
+		resolved, err = check(path, cmdArgs)
+	}
+	// sudo keeps the caller's working directory, a relative path the
+	// command resolves must not point into a directory the caller controls.
+	if err == nil {
+		err = chdir("/")
+	}
+	if err == nil {
+		err = execve(resolved, append([]string{path}, cmdArgs...), slices.Clone(env))
+	}
+	fmt.Fprintf(stderr, "%s: %v\n", Arg, err)
+	return exitDenied
+}
+
+// sudoersEscape escapes the characters sudoers treats as special in command
+// arguments.
+var sudoersEscape = strings.NewReplacer(`\`, `\\`, ",", `\,`, ":", `\:`, "=", `\=`)
+
+// Sudoers returns the sudoers rules that let user run the helper from the
+// agent executable at agent, one for each command form. Forms with flags or
+// operands end in a wildcard, the helper checks those arguments itself.
+func Sudoers(user, agent string) string {
+	paths := make([]string, 0, len(commands))
+	for p := range commands {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, p := range paths {
+		for _, f := range commands[p] {
+			fmt.Fprintf(&b, "%s ALL=(root) NOPASSWD: %s %s -- %s", user, agent, Arg, p)
+			for _, a := range f.args {
+				b.WriteString(" " + sudoersEscape.Replace(a))
+			}
+			if len(f.flags) > 0 || f.operands != nil {
+				b.WriteString(" *")
+			} else if len(f.args) == 0 {
+				// An empty argument list only matches no arguments.
+				b.WriteString(` ""`)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
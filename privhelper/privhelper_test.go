@@ -0,0 +1,220 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package privhelper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		desc     string
+		args     []string
+		wantPath string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{"Command", []string{"--", "/usr/bin/apt-get", "install", "-y", "foo"}, "/usr/bin/apt-get", []string{"install", "-y", "foo"}, false},
+		{"Env", []string{"-env", "LD_PRELOAD=/tmp/x.so", "--", "/usr/bin/apt-get"}, "", nil, true},
+		{"NoCommand", []string{"--"}, "", nil, true},
+		{"NoSeparator", []string{"/usr/bin/apt-get"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			path, args, err := parseArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseArgs(%q) err = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if path != tt.wantPath || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("parseArgs(%q) = %q, %q, want %q, %q", tt.args, path, args, tt.wantPath, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	oldEuid, oldOwner, oldEval := geteuid, checkOwner, evalSymlinks
+	defer func() { geteuid, checkOwner, evalSymlinks = oldEuid, oldOwner, oldEval }()
+	euid := 0
+	geteuid = func() int { return euid }
+	checkOwner = func(string) error { return nil }
+	evalSymlinks = func(p string) (string, error) { return p, nil }
+
+	tests := []struct {
+		desc    string
+		path    string
+		args    []string
+		wantErr bool
+	}{
+		{"PackageManager", "/usr/bin/yum", []string{"install", "--assumeyes", "foo", "bar-1.0.x86_64"}, false},
+		{"Flag", "/usr/bin/apt-get", []string{"install", "-y", "-o", "APT::Status-Fd=1", "foo=1.0", "--allow-downgrades"}, false},
+		{"PortageAtom", "/usr/bin/emerge", []string{"--ask=n", "--color=n", "--nospinner", "--quiet-build=y", "--update", "=app-misc/foo-1.0"}, false},
+		{"PortageSlot", "/usr/bin/emerge", []string{"--ask=n", "--color=n", "--nospinner", "--depclean", "dev-lang/python:3.12"}, false},
+		{"PortageUnmerge", "/usr/bin/emerge", []string{"--ask=n", "--color=n", "--nospinner", "--unmerge", "app-misc/foo"}, true},
+		{"NoOperands", "/usr/bin/yum", []string{"install", "--assumeyes"}, true},
+		{"ExtraOption", "/usr/bin/yum", []string{"install", "--assumeyes", "--setopt=pluginpath=/tmp", "foo"}, true},
+		{"LocalFile", "/usr/bin/yum", []string{"install", "--assumeyes", "/tmp/foo.rpm"}, true},
+		{"RelativeRPM", "/usr/bin/yum", []string{"install", "--assumeyes", "evil.rpm"}, true},
+		{"RelativeRPMUpper", "/usr/bin/zypper", []string{"--gpg-auto-import-keys", "--non-interactive", "install", "--auto-agree-with-licenses", "EVIL.RPM"}, true},
+		{"RelativeAPK", "/sbin/apk", []string{"add", "--upgrade", "x.apk"}, true},
+		{"RelativeDeb", "/usr/bin/apt-get", []string{"install", "-y", "-o", "APT::Status-Fd=1", "foo", "evil.deb"}, true},
+		{"RelativeEbuild", "/usr/bin/emerge", []string{"--ask=n", "--color=n", "--nospinner", "--quiet-build=y", "--update", "evil/foo.ebuild"}, true},
+		{"RelativeFlatpakRef", "/usr/bin/flatpak", []string{"install", "--system", "--noninteractive", "--assumeyes", "evil.flatpakref"}, true},
+		{"DpkgInstall", "/usr/bin/dpkg", []string{"--install", "/tmp/foo.deb"}, true},
+		{"RPMEval", "/bin/rpm", []string{"--eval", "%{lua: os.execute('id')}"}, true},
+		{"UserWritable", "/home/linuxbrew/.linuxbrew/bin/brew", []string{"install", "foo"}, true},
+		{"Reboot", "/bin/systemctl", []string{"reboot"}, false},
+		{"RebootNoArgs", "/bin/reboot", nil, false},
+		{"SystemctlOtherArgs", "/bin/systemctl", []string{"start", "foo"}, true},
+		{"NotAllowed", "/bin/sh", []string{"-c", "id"}, true},
+		{"Relative", "yum", nil, true},
+		{"NotClean", "/usr/bin/../bin/yum", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if _, err := check(tt.path, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("check(%q, %q) err = %v, wantErr %v", tt.path, tt.args, err, tt.wantErr)
+			}
+		})
+	}
+
+	yumClean := []string{"clean", "all"}
+	euid = 1000
+	if _, err := check("/usr/bin/yum", yumClean); err == nil {
+		t.Error("check() as non root did not return an error")
+	}
+	euid = 0
+	checkOwner = func(p string) error {
+		if p == "/usr" {
+			return errors.New("writable")
+		}
+		return nil
+	}
+	if _, err := check("/usr/bin/yum", yumClean); err == nil {
+		t.Error("check() with a writable parent directory did not return an error")
+	}
+	checkOwner = func(string) error { return nil }
+	evalSymlinks = func(string) (string, error) { return "/usr/bin/dnf-3", nil }
+	if got, err := check("/usr/bin/yum", yumClean); err != nil || got != "/usr/bin/dnf-3" {
+		t.Errorf("check() for a symlink = %q, %v, want %q, nil", got, err, "/usr/bin/dnf-3")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	oldEuid, oldAgent := geteuid, agent
+	defer func() { geteuid, agent = oldEuid, oldAgent }()
+	geteuid = func() int { return 1000 }
+	agent = "/usr/bin/google_osconfig_agent"
+	ctx := context.Background()
+
+	cmd := exec.Command("/usr/bin/apt-get", "remove", "-y", "foo")
+	cmd.Env = []string{"PATH=/tmp", "DEBIAN_FRONTEND=noninteractive"}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	got := Command(ctx, cmd)
+	want := []string{sudo, "-n", agent, Arg, "--", "/usr/bin/apt-get", "remove", "-y", "foo"}
+	if !reflect.DeepEqual(got.Args, want) {
+		t.Errorf("Command().Args = %q, want %q", got.Args, want)
+	}
+	if got.Stdout != &stdout {
+		t.Error("Command() did not keep Stdout")
+	}
+
+	// Commands the helper does not run are not changed.
+	cmd = exec.Command("/bin/sh", "-c", "id")
+	if got := Command(ctx, cmd); got != cmd {
+		t.Errorf("Command(%q) = %q, want it unchanged", cmd.Args, got.Args)
+	}
+
+	// Nothing is changed when the agent is root.
+	geteuid = func() int { return 0 }
+	cmd = exec.Command("/usr/bin/apt-get", "update")
+	if got := Command(ctx, cmd); got != cmd {
+		t.Errorf("Command(%q) as root = %q, want it unchanged", cmd.Args, got.Args)
+	}
+}
+
+func TestRunMain(t *testing.T) {
+	oldEuid, oldOwner, oldEval, oldExecve, oldChdir := geteuid, checkOwner, evalSymlinks, execve, chdir
+	defer func() {
+		geteuid, checkOwner, evalSymlinks, execve, chdir = oldEuid, oldOwner, oldEval, oldExecve, oldChdir
+	}()
+	geteuid = func() int { return 0 }
+	checkOwner = func(string) error { return nil }
+	evalSymlinks = func(p string) (string, error) { return p, nil }
+	var dir string
+	chdir = func(d string) error {
+		dir = d
+		return nil
+	}
+	var gotArgv, gotEnv []string
+	execve = func(path string, argv, env []string) error {
+		if dir != "/" {
+			t.Errorf("Main() ran the command in %q, want /", dir)
+		}
+		gotArgv, gotEnv = argv, env
+		return errors.New("exec failed")
+	}
+
+	var stderr bytes.Buffer
+	args := []string{"/usr/bin/zypper", "--non-interactive", "remove", "foo"}
+	if code := Main(append([]string{"--"}, args...), &stderr); code != exitDenied {
+		t.Errorf("Main() = %d, want %d", code, exitDenied)
+	}
+	if !reflect.DeepEqual(gotArgv, args) {
+		t.Errorf("Main() ran %q, want %q", gotArgv, args)
+	}
+	if want := env; !reflect.DeepEqual(gotEnv, want) {
+		t.Errorf("Main() env = %q, want %q", gotEnv, want)
+	}
+
+	// A command is not run if the working directory can not be changed.
+	gotArgv = nil
+	chdir = func(string) error { return errors.New("chdir failed") }
+	if Main(append([]string{"--"}, args...), &stderr); gotArgv != nil {
+		t.Errorf("Main() ran %q after chdir failed, want nothing run", gotArgv)
+	}
+
+	stderr.Reset()
+	Main([]string{"--", "/bin/sh", "-c", "id"}, &stderr)
+	if gotArgv != nil {
+		t.Errorf("Main() ran %q, want nothing run", gotArgv)
+	}
+	if !strings.Contains(stderr.String(), "not allowed") {
+		t.Errorf("Main() stderr = %q, want it to contain %q", stderr.String(), "not allowed")
+	}
+}
+
+func TestSudoers(t *testing.T) {
+	got := Sudoers("osconfig", "/usr/bin/google_osconfig_agent")
+	for _, want := range []string{
+		"osconfig ALL=(root) NOPASSWD: /usr/bin/google_osconfig_agent privhelper -- /bin/reboot \"\"\n",
+		"osconfig ALL=(root) NOPASSWD: /usr/bin/google_osconfig_agent privhelper -- /usr/bin/apt-get update\n",
+		"osconfig ALL=(root) NOPASSWD: /usr/bin/google_osconfig_agent privhelper -- /usr/bin/apt-get install -y -o APT\\:\\:Status-Fd\\=1 *\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Sudoers() = %q, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, Arg+" *") {
+		t.Errorf("Sudoers() = %q, want no rule allowing any arguments", got)
+	}
+}
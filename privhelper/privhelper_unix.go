@@ -0,0 +1,41 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package privhelper
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ownedByRoot returns an error if path is not a regular file or directory
+// that only root can modify.
+func ownedByRoot(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || !(fi.Mode().IsRegular() || fi.IsDir()) {
+		return fmt.Errorf("%s is not a regular file or directory", path)
+	}
+	if st.Uid != 0 || fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s may be modified by users other than root", path)
+	}
+	return nil
+}
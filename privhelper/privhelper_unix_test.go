@@ -0,0 +1,121 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package privhelper
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+	"github.com/GoogleCloudPlatform/osconfig/util"
+)
+
+func TestOwnedByRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test needs to create files owned by root")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cmd")
+	if err := os.WriteFile(path, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ownedByRoot(path); err != nil {
+		t.Errorf("ownedByRoot(%q) = %v, want nil", path, err)
+	}
+	if err := os.Chmod(path, 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := ownedByRoot(path); err == nil {
+		t.Errorf("ownedByRoot(%q) for a group writable file did not return an error", path)
+	}
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ownedByRoot(dir); err == nil {
+		t.Errorf("ownedByRoot(%q) for a world writable directory did not return an error", dir)
+	}
+}
+
+// recordingRunner records the commands the packages package runs instead of
+// running them.
+type recordingRunner struct {
+	cmds []*exec.Cmd
+}
+
+func (r *recordingRunner) Run(_ context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
+	r.cmds = append(r.cmds, cmd)
+	return nil, nil, nil
+}
+
+// TestPackageCommandsAllowed runs every call in the packages package that
+// changes the system and checks the helper runs each command it makes, so
+// that a new or changed command is not run without root in non-root mode.
+// Installing package files and importing keys are never allowed.
+func TestPackageCommandsAllowed(t *testing.T) {
+	r := &recordingRunner{}
+	packages.WrapCommandRunners(func(util.CommandRunner) util.CommandRunner { return r })
+	ctx := context.Background()
+	pkgs := []string{"foo"}
+
+	calls := map[string]func() error{
+		"InstallAptPackages":                 func() error { return packages.InstallAptPackages(ctx, pkgs) },
+		"RemoveAptPackages":                  func() error { return packages.RemoveAptPackages(ctx, pkgs) },
+		"AptHold":                            func() error { return packages.AptHold(ctx, pkgs) },
+		"AptUnhold":                          func() error { return packages.AptUnhold(ctx, pkgs) },
+		"AptUpdate":                          func() error { _, err := packages.AptUpdate(ctx); return err },
+		"AptAutoremove":                      func() error { _, err := packages.AptAutoremove(ctx); return err },
+		"AptClean":                           func() error { _, err := packages.AptClean(ctx); return err },
+		"InstallYumPackages":                 func() error { return packages.InstallYumPackages(ctx, pkgs) },
+		"RemoveYumPackages":                  func() error { return packages.RemoveYumPackages(ctx, pkgs) },
+		"YumClean":                           func() error { _, err := packages.YumClean(ctx); return err },
+		"YumVersionLockAdd":                  func() error { return packages.YumVersionLockAdd(ctx, pkgs) },
+		"YumVersionLockDelete":               func() error { return packages.YumVersionLockDelete(ctx, pkgs) },
+		"EnableDnfModuleStream":              func() error { return packages.EnableDnfModuleStream(ctx, "foo", "1") },
+		"SwitchDnfModuleStream":              func() error { return packages.SwitchDnfModuleStream(ctx, "foo", "1") },
+		"InstallZypperPackages":              func() error { return packages.InstallZypperPackages(ctx, pkgs) },
+		"RemoveZypperPackages":               func() error { return packages.RemoveZypperPackages(ctx, pkgs) },
+		"TransactionalInstallZypperPackages": func() error { return packages.TransactionalInstallZypperPackages(ctx, pkgs) },
+		"TransactionalRemoveZypperPackages":  func() error { return packages.TransactionalRemoveZypperPackages(ctx, pkgs) },
+		"ZypperClean":                        func() error { _, err := packages.ZypperClean(ctx); return err },
+		"InstallApkPackages":                 func() error { return packages.InstallApkPackages(ctx, pkgs) },
+		"RemoveApkPackages":                  func() error { return packages.RemoveApkPackages(ctx, pkgs) },
+		"InstallPacmanPackages":              func() error { return packages.InstallPacmanPackages(ctx, pkgs) },
+		"RemovePacmanPackages":               func() error { return packages.RemovePacmanPackages(ctx, pkgs) },
+		"InstallPortagePackages":             func() error { return packages.InstallPortagePackages(ctx, []string{"app-misc/foo"}) },
+		"RemovePortagePackages":              func() error { return packages.RemovePortagePackages(ctx, []string{"app-misc/foo"}) },
+		"InstallFlatpakPackages":             func() error { return packages.InstallFlatpakPackages(ctx, pkgs) },
+		"RemoveFlatpakPackages":              func() error { return packages.RemoveFlatpakPackages(ctx, pkgs) },
+	}
+	for name, call := range calls {
+		r.cmds = nil
+		// Errors from parsing the empty output do not matter, only the
+		// commands run.
+		call()
+		if len(r.cmds) == 0 {
+			t.Errorf("%s ran no commands", name)
+		}
+		for _, cmd := range r.cmds {
+			if err := allowed(cmd.Path, cmd.Args[1:]); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+}
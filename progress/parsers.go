@@ -0,0 +1,122 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package progress
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parser extracts the overall percent complete and the current phase from
+// a single line of package manager output.
+type Parser func(line string) (percent float64, phase string, ok bool)
+
+// Share of the overall progress given to downloading packages, the rest is
+// spent applying them.
+const downloadShare = 30
+
+const (
+	// PhaseDownloading is reported while packages are being retrieved.
+	PhaseDownloading = "downloading"
+	// PhaseApplying is reported while packages are being installed or removed.
+	PhaseApplying = "applying"
+	// PhaseVerifying is reported while the result is being verified.
+	PhaseVerifying = "verifying"
+)
+
+var (
+	// Matches dnf and yum transaction lines, e.g.
+	// "  Upgrading        : bash-5.1.8-6.el9.x86_64        3/10".
+	dnfStepRe = regexp.MustCompile(`^\s*([A-Z][a-z]+)\s*:\s.*\s(\d+)/(\d+)\s*$`)
+	// Matches dnf and yum download lines, e.g.
+	// "(3/10): bash-5.1.8-6.el9.x86_64.rpm   1.7 MB/s | 1.7 MB     00:00".
+	dnfDownloadRe = regexp.MustCompile(`^\((\d+)/(\d+)\):\s`)
+	// Matches zypper download lines, e.g.
+	// "Retrieving package bash-4.4-150400.27.3.2.x86_64 (3/10), 628.5 KiB".
+	zypperDownloadRe = regexp.MustCompile(`^Retrieving(?: package|:) .*\((\d+)/(\d+)\)`)
+	// Matches zypper install lines, e.g.
+	// "(3/10) Installing: bash-4.4-150400.27.3.2.x86_64 ..........[done]".
+	zypperStepRe = regexp.MustCompile(`^\((\d+)/(\d+)\) [A-Z][a-z]+:`)
+)
+
+// fraction parses the n and m of an "n/m" counter into n/m.
+func fraction(n, m string) (float64, bool) {
+	num, err := strconv.Atoi(n)
+	if err != nil {
+		return 0, false
+	}
+	den, err := strconv.Atoi(m)
+	if err != nil || den <= 0 || num > den {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// ParseAptStatus parses the machine readable lines apt-get writes to
+// APT::Status-Fd, e.g. "pmstatus:bash:42.8571:Installing bash (amd64)".
+func ParseAptStatus(line string) (float64, string, bool) {
+	parts := strings.SplitN(line, ":", 4)
+	if len(parts) != 4 {
+		return 0, "", false
+	}
+	pct, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil || pct < 0 || pct > 100 {
+		return 0, "", false
+	}
+	switch parts[0] {
+	case "dlstatus":
+		return pct * downloadShare / 100, PhaseDownloading, true
+	case "pmstatus":
+		return downloadShare + pct*(100-downloadShare)/100, PhaseApplying, true
+	}
+	return 0, "", false
+}
+
+// Share of the apply phase yum and dnf spend on verification.
+const dnfVerifyShare = 10
+
+// ParseDnf parses the transaction output of yum and dnf.
+func ParseDnf(line string) (float64, string, bool) {
+	if m := dnfDownloadRe.FindStringSubmatch(line); m != nil {
+		f, ok := fraction(m[1], m[2])
+		return f * downloadShare, PhaseDownloading, ok
+	}
+	m := dnfStepRe.FindStringSubmatch(line)
+	if m == nil {
+		return 0, "", false
+	}
+	f, ok := fraction(m[2], m[3])
+	if !ok {
+		return 0, "", false
+	}
+	if m[1] == "Verifying" {
+		return 100 - dnfVerifyShare + f*dnfVerifyShare, PhaseVerifying, true
+	}
+	return downloadShare + f*(100-downloadShare-dnfVerifyShare), PhaseApplying, true
+}
+
+// ParseZypper parses the human readable output of zypper install and patch.
+func ParseZypper(line string) (float64, string, bool) {
+	if m := zypperDownloadRe.FindStringSubmatch(line); m != nil {
+		f, ok := fraction(m[1], m[2])
+		return f * downloadShare, PhaseDownloading, ok
+	}
+	if m := zypperStepRe.FindStringSubmatch(line); m != nil {
+		f, ok := fraction(m[1], m[2])
+		return downloadShare + f*(100-downloadShare), PhaseApplying, ok
+	}
+	return 0, "", false
+}
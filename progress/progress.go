@@ -0,0 +1,207 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package progress tracks the progress of long running package operations
+// so it can be reported instead of an opaque running state.
+package progress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+)
+
+// maxLineLength bounds how much of a single output line is buffered while
+// waiting for its end, longer lines are dropped.
+const maxLineLength = 64 * 1024
+
+var (
+	now = time.Now
+
+	mu     sync.Mutex
+	ops    = map[string]*Tracker{}
+	nextID int
+)
+
+// Operation is a snapshot of a tracked operation.
+type Operation struct {
+	ID          string     `json:"id"`
+	TaskID      string     `json:"taskId,omitempty"`
+	Manager     string     `json:"manager"`
+	Description string     `json:"description,omitempty"`
+	Phase       string     `json:"phase,omitempty"`
+	Percent     float64    `json:"percent"`
+	Started     time.Time  `json:"started"`
+	Updated     time.Time  `json:"updated"`
+	ETA         *time.Time `json:"eta,omitempty"`
+}
+
+// Tracker records the progress of a single operation. All methods are safe
+// to call on a nil Tracker.
+type Tracker struct {
+	ctx context.Context
+	mu  sync.Mutex
+	op  Operation
+}
+
+type taskKey struct{}
+
+// WithTask returns a context whose operations are attributed to taskID.
+func WithTask(ctx context.Context, taskID string) context.Context {
+	return context.WithValue(ctx, taskKey{}, taskID)
+}
+
+// Start registers a new operation for manager, it is listed by Active until
+// Done is called.
+func Start(ctx context.Context, manager, description string) *Tracker {
+	taskID, _ := ctx.Value(taskKey{}).(string)
+	t := now()
+
+	mu.Lock()
+	defer mu.Unlock()
+	nextID++
+	tr := &Tracker{
+		ctx: ctx,
+		op: Operation{
+			ID:          fmt.Sprintf("%s-%d", manager, nextID),
+			TaskID:      taskID,
+			Manager:     manager,
+			Description: description,
+			Started:     t,
+			Updated:     t,
+		},
+	}
+	ops[tr.op.ID] = tr
+	clog.Debugf(ctx, "Started %s operation %s: %s", manager, tr.op.ID, description)
+	return tr
+}
+
+// Update records that the operation is percent complete and in phase.
+// Progress never moves backwards, a lower percent only updates the phase.
+func (t *Tracker) Update(percent float64, phase string) {
+	if t == nil {
+		return
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if phase != "" && phase != t.op.Phase {
+		clog.Infof(t.ctx, "%s operation %s: %s (%.0f%%)", t.op.Manager, t.op.ID, phase, max(percent, t.op.Percent))
+		t.op.Phase = phase
+	}
+	t.op.Updated = now()
+	if percent <= t.op.Percent {
+		return
+	}
+	t.op.Percent = percent
+	t.op.ETA = eta(t.op.Started, t.op.Updated, percent)
+}
+
+// eta linearly extrapolates the time the operation will finish.
+func eta(started, updated time.Time, percent float64) *time.Time {
+	if percent <= 0 {
+		return nil
+	}
+	elapsed := updated.Sub(started)
+	remaining := time.Duration(float64(elapsed) * (100 - percent) / percent)
+	e := updated.Add(remaining)
+	return &e
+}
+
+// Done removes the operation from the active list.
+func (t *Tracker) Done() {
+	if t == nil {
+		return
+	}
+	mu.Lock()
+	delete(ops, t.op.ID)
+	mu.Unlock()
+
+	op := t.Operation()
+	clog.Debugf(t.ctx, "Finished %s operation %s in %s", op.Manager, op.ID, now().Sub(op.Started))
+}
+
+// Operation returns a snapshot of the operation.
+func (t *Tracker) Operation() Operation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	op := t.op
+	if op.ETA != nil {
+		e := *op.ETA
+		op.ETA = &e
+	}
+	return op
+}
+
+// Active returns a snapshot of all running operations, oldest first.
+func Active() []Operation {
+	mu.Lock()
+	trackers := make([]*Tracker, 0, len(ops))
+	for _, t := range ops {
+		trackers = append(trackers, t)
+	}
+	mu.Unlock()
+
+	res := make([]Operation, 0, len(trackers))
+	for _, t := range trackers {
+		res = append(res, t.Operation())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].Started.Equal(res[j].Started) {
+			return res[i].Started.Before(res[j].Started)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// Writer returns a writer that feeds each line written to it through
+// parse and records the result.
+func (t *Tracker) Writer(parse Parser) io.Writer {
+	return &lineWriter{t: t, parse: parse}
+}
+
+type lineWriter struct {
+	t     *Tracker
+	parse Parser
+	buf   []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		// Progress bars redraw with a carriage return rather than a newline.
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		if percent, phase, ok := w.parse(string(w.buf[:i])); ok {
+			w.t.Update(percent, phase)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > maxLineLength {
+		w.buf = nil
+	}
+	return len(p), nil
+}
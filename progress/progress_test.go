@@ -0,0 +1,92 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package progress
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	clock := start
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	tr := Start(WithTask(context.Background(), "task-1"), "apt", "bash curl")
+	if got := Active(); len(got) != 1 || got[0].ID != tr.op.ID || got[0].TaskID != "task-1" {
+		t.Fatalf("Active() = %+v, want the started operation", got)
+	}
+
+	clock = start.Add(time.Minute)
+	fmt.Fprint(tr.Writer(ParseAptStatus), "noise\npmstatus:bash:50:Installing bash\rpmstatus:curl:20:Unpack")
+	op := tr.Operation()
+	if op.Percent != 65 || op.Phase != PhaseApplying {
+		t.Errorf("after update: percent=%v phase=%q, want 65 %q", op.Percent, op.Phase, PhaseApplying)
+	}
+	// 65% took a minute, the remaining 35% should take a little over half that.
+	if want := clock.Add(time.Minute * 35 / 65); op.ETA == nil || !op.ETA.Equal(want) {
+		t.Errorf("ETA = %v, want %v", op.ETA, want)
+	}
+
+	tr.Update(10, PhaseVerifying)
+	if op := tr.Operation(); op.Percent != 65 || op.Phase != PhaseVerifying {
+		t.Errorf("after lower update: percent=%v phase=%q, want 65 %q", op.Percent, op.Phase, PhaseVerifying)
+	}
+
+	tr.Done()
+	if got := Active(); len(got) != 0 {
+		t.Errorf("Active() after Done = %+v, want none", got)
+	}
+
+	var nilTracker *Tracker
+	nilTracker.Update(50, PhaseApplying)
+	nilTracker.Done()
+}
+
+func TestParsers(t *testing.T) {
+	tests := []struct {
+		desc      string
+		parse     Parser
+		line      string
+		want      float64
+		wantPhase string
+		wantOK    bool
+	}{
+		{"apt download", ParseAptStatus, "dlstatus:1:50:Retrieving file 1 of 2", 15, PhaseDownloading, true},
+		{"apt install", ParseAptStatus, "pmstatus:bash:100:Installed bash (amd64)", 100, PhaseApplying, true},
+		{"apt error", ParseAptStatus, "pmerror:/var/cache/apt/archives/foo.deb:50:trying to overwrite", 0, "", false},
+		{"apt plain output", ParseAptStatus, "Reading package lists...", 0, "", false},
+		{"dnf download", ParseDnf, "(1/2): bash-5.1.8-6.el9.x86_64.rpm   1.7 MB/s | 1.7 MB     00:00", 15, PhaseDownloading, true},
+		{"dnf upgrade", ParseDnf, "  Upgrading        : bash-5.1.8-6.el9.x86_64        2/4", 60, PhaseApplying, true},
+		{"dnf verify", ParseDnf, "  Verifying        : bash-5.1.8-6.el9.x86_64        4/4", 100, PhaseVerifying, true},
+		{"dnf scriptlet", ParseDnf, "  Running scriptlet: bash-5.1.8-6.el9.x86_64        1/4", 0, "", false},
+		{"dnf bad counter", ParseDnf, "  Upgrading        : bash-5.1.8-6.el9.x86_64        5/4", 0, "", false},
+		{"zypper download", ParseZypper, "Retrieving: bash-4.4-150400.27.3.2.x86_64 (Main Repository) (1/2), 628.5 KiB", 15, PhaseDownloading, true},
+		{"zypper old download", ParseZypper, "Retrieving package bash-4.4-150400.27.3.2.x86_64 (2/2), 628.5 KiB", 30, PhaseDownloading, true},
+		{"zypper install", ParseZypper, "(1/2) Installing: bash-4.4-150400.27.3.2.x86_64 ..........[done]", 65, PhaseApplying, true},
+		{"zypper summary", ParseZypper, "2 packages to upgrade.", 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, phase, ok := tt.parse(tt.line)
+			if got != tt.want || phase != tt.wantPhase || ok != tt.wantOK {
+				t.Errorf("parse(%q) = (%v, %q, %v), want (%v, %q, %v)", tt.line, got, phase, ok, tt.want, tt.wantPhase, tt.wantOK)
+			}
+		})
+	}
+}
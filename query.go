@@ -0,0 +1,102 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/history"
+)
+
+const queryUsage = "usage: query [inventory|compliance] [since=<duration>] [contains=<string>] | query '<select statement>' | query export <file>"
+
+// queryHistory writes the local history records matching args to w, one
+// JSON record per line. History is only recorded when
+// osconfig-history-retention is set.
+//
+// In agents built with the sqlite tag a single SELECT statement argument is
+// run against the records table, see history.SQL, and each row is written
+// as a JSON object. "export <file>" writes the records to a SQLite database.
+func queryHistory(ctx context.Context, w io.Writer, args []string) error {
+	if len(args) == 2 && args[0] == "export" {
+		return history.Export(ctx, args[1])
+	}
+	if len(args) == 1 && isSQL(args[0]) {
+		return querySQL(ctx, w, args[0])
+	}
+
+	var q history.Query
+	for _, arg := range args {
+		switch {
+		case arg == history.Inventory || arg == history.Compliance:
+			q.Kind = arg
+		case strings.HasPrefix(arg, "since="):
+			d, err := time.ParseDuration(strings.TrimPrefix(arg, "since="))
+			if err != nil {
+				return fmt.Errorf("invalid since %q: %v", arg, err)
+			}
+			q.Since = time.Now().Add(-d)
+		case strings.HasPrefix(arg, "contains="):
+			q.Contains = strings.TrimPrefix(arg, "contains=")
+		default:
+			return errors.New(queryUsage)
+		}
+	}
+
+	records, err := history.Read(q)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSQL reports whether a query argument is an SQL statement rather than a
+// filter.
+func isSQL(arg string) bool {
+	f := strings.Fields(strings.ToLower(arg))
+	return len(f) > 1 && (f[0] == "select" || f[0] == "with")
+}
+
+// querySQL runs an SQL query against the history and writes each row to w
+// as a JSON object keyed by column name.
+func querySQL(ctx context.Context, w io.Writer, query string) error {
+	cols, rows, err := history.SQL(ctx, query)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		obj := make(map[string]interface{}, len(cols))
+		for i, c := range cols {
+			obj[c] = row[i]
+		}
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/GoogleCloudPlatform/osconfig/statereset"
+)
+
+// resetState archives the agent's per-instance state, run it before baking
+// an image so instances created from it start with fresh state. The agent
+// must be stopped first.
+func resetState(ctx context.Context, w io.Writer) error {
+	if err := obtainLock(); err != nil {
+		if err == errLockHeld {
+			return errors.New("the agent is running, stop it before resetting its state")
+		}
+		return err
+	}
+	defer func() {
+		for _, f := range deferredFuncs {
+			f()
+		}
+		deferredFuncs = nil
+	}()
+
+	dir, err := statereset.Reset(ctx, "manual")
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		fmt.Fprintln(w, "No agent state to reset.")
+		return nil
+	}
+	fmt.Fprintf(w, "Agent state archived to %s\n", dir)
+	return nil
+}
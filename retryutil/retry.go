@@ -97,11 +97,18 @@ func RetryAPICall(ctx context.Context, maxRetryTime time.Duration, name string,
 
 		ns := RetrySleep(i, extra)
 		tot += ns
-		if tot > maxRetryTime {
+		// A canceled or expired context fails every further attempt.
+		if tot > maxRetryTime || ctx.Err() != nil {
 			return err
 		}
 
 		clog.Warningf(ctx, "Error calling %s, attempt %d, retrying in %s: %v", name, i, ns, err)
-		time.Sleep(ns)
+		t := time.NewTimer(ns)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
 	}
 }
@@ -0,0 +1,81 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/GoogleCloudPlatform/osconfig/config"
+)
+
+// errSimulationChanges is returned when a simulated policy run would change
+// or fail on this host.
+var errSimulationChanges = errors.New("policies are not compliant on this host")
+
+// simulate evaluates the OS policies in path against this host, or against
+// a recorded host profile if profilePath is set, without making any changes
+// and writes the per resource results as JSON to stdout.
+func simulate(ctx context.Context, path, profilePath string) error {
+	policies, err := config.LoadSimulationPolicies(path)
+	if err != nil {
+		return err
+	}
+
+	var results []*config.SimulationResult
+	if profilePath != "" {
+		profile, err := config.LoadHostProfile(profilePath)
+		if err != nil {
+			return err
+		}
+		results = config.SimulateProfile(ctx, policies, profile)
+	} else {
+		results = config.Simulate(ctx, policies)
+	}
+	if err := writeJSON(results); err != nil {
+		return err
+	}
+
+	// Resources a host profile has no data for are reported, but do not make
+	// the policies non-compliant.
+	for _, r := range results {
+		if r.State != config.SimulationCompliant && r.State != config.SimulationNotEvaluated {
+			return errSimulationChanges
+		}
+	}
+	return nil
+}
+
+// exportProfile records a host profile for the OS policies in path and
+// writes it as JSON to stdout.
+func exportProfile(ctx context.Context, path string) error {
+	policies, err := config.LoadSimulationPolicies(path)
+	if err != nil {
+		return err
+	}
+	profile, err := config.NewHostProfile(ctx, policies)
+	if err != nil {
+		return err
+	}
+	return writeJSON(profile)
+}
+
+func writeJSON(v any) error {
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
@@ -0,0 +1,189 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package statereset resets the agent's per-instance state when a disk is
+// imaged and cloned, so a clone does not inherit the installed recipes, task
+// journals, drift counters or cached assignments of the source instance.
+//
+// The instance ID the state belongs to is recorded next to it. When the
+// agent starts on an instance with a different ID the state is moved to an
+// archive directory instead of being deleted, so it can still be inspected.
+// Configuration written by operators, such as the exec policy and inventory
+// plugins, is kept.
+package statereset
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/policies/recipes"
+)
+
+var (
+	stateDir       = agentconfig.CacheDir()
+	instanceIDFile = filepath.Join(agentconfig.CacheDir(), "osconfig_instance_id")
+	archiveDir     = filepath.Join(agentconfig.CacheDir(), "state_archive")
+	// maxArchives is the number of archived states that are kept, the
+	// oldest are removed first.
+	maxArchives = 3
+
+	recipeDBFile = recipes.DBFile
+	instanceID   = agentconfig.ID
+	now          = time.Now
+)
+
+// keep lists the paths in the state directory that are configuration or
+// belong to the running agent rather than to the instance.
+func keep() map[string]bool {
+	k := map[string]bool{
+		archiveDir:                          true,
+		agentconfig.ExecPolicyFile():        true,
+		agentconfig.InventoryPluginDir():    true,
+		agentconfig.ResourceProvidersFile(): true,
+		agentconfig.ChangeFreezeFile():      true,
+		agentconfig.DistroEOLFile():         true,
+		agentconfig.LocalAPISocket():        true,
+		// The Windows agent lock file.
+		filepath.Join(stateDir, "lock"): true,
+		// The apt packages the agent held, the holds are on the cloned disk.
+		filepath.Join(stateDir, "config_apt_holds.json"): true,
+	}
+	if f := agentconfig.LogFile(); f != "" {
+		k[filepath.Clean(f)] = true
+	}
+	return k
+}
+
+// statePaths returns the per-instance state files and directories that
+// exist.
+func statePaths() ([]string, error) {
+	entries, err := ioutil.ReadDir(stateDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	k := keep()
+	var paths []string
+	for _, e := range entries {
+		p := filepath.Join(stateDir, e.Name())
+		if k[p] || e.Mode()&os.ModeSocket != 0 {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	if db := recipeDBFile(); db != "" {
+		if _, err := os.Stat(db); err == nil {
+			paths = append(paths, db)
+		}
+	}
+	return paths, nil
+}
+
+// Reset moves all per-instance state into a new archive directory and
+// returns its path, reason is added to the directory name. No archive is
+// created if there is no state. The recorded
+// instance ID is archived too, the next agent start records the ID of the
+// instance it runs on without resetting again.
+func Reset(ctx context.Context, reason string) (string, error) {
+	paths, err := statePaths()
+	if err != nil {
+		return "", fmt.Errorf("error listing agent state: %v", err)
+	}
+	if len(paths) == 0 {
+		return "", nil
+	}
+	dir := filepath.Join(archiveDir, now().UTC().Format("20060102T150405Z")+"-"+reason)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("error creating state archive: %v", err)
+	}
+	for _, p := range paths {
+		if err := os.Rename(p, filepath.Join(dir, filepath.Base(p))); err != nil {
+			return dir, fmt.Errorf("error archiving %s: %v", p, err)
+		}
+		clog.Debugf(ctx, "Archived agent state %s to %s.", p, dir)
+	}
+	pruneArchives(ctx)
+	return dir, nil
+}
+
+// pruneArchives removes all but the newest maxArchives archives, archive
+// names start with their creation time so they sort by age.
+func pruneArchives(ctx context.Context) {
+	entries, err := ioutil.ReadDir(archiveDir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > maxArchives {
+		if err := os.RemoveAll(filepath.Join(archiveDir, names[0])); err != nil {
+			clog.Warningf(ctx, "Error removing old state archive %s: %v", names[0], err)
+		}
+		names = names[1:]
+	}
+}
+
+func recordInstanceID(id string) error {
+	if err := os.MkdirAll(filepath.Dir(instanceIDFile), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(instanceIDFile, []byte(id+"\n"), 0644)
+}
+
+// Check resets the per-instance state if it was written on an instance with
+// a different instance ID, that is, if this disk was cloned from another
+// instance, and reports whether it did.
+func Check(ctx context.Context) (bool, error) {
+	id := instanceID()
+	if id == "" {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(instanceIDFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+		// State from before instance IDs were recorded, or no state at all,
+		// is assumed to belong to this instance.
+		return false, recordInstanceID(id)
+	}
+	old := strings.TrimSpace(string(data))
+	if old == id {
+		return false, nil
+	}
+	dir, err := Reset(ctx, "instance-"+old)
+	if err != nil {
+		return false, err
+	}
+	clog.Warningf(ctx, "Instance ID changed from %q to %q, this disk was cloned or imaged from another instance.", old, id)
+	if dir != "" {
+		clog.Warningf(ctx, "Per-instance agent state was archived to %s.", dir)
+	}
+	return true, recordInstanceID(id)
+}
@@ -0,0 +1,146 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package statereset
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func setTestState(t *testing.T) string {
+	t.Helper()
+	td := t.TempDir()
+	oldDir, oldID, oldArchive, oldDB, oldInstance, oldNow := stateDir, instanceIDFile, archiveDir, recipeDBFile, instanceID, now
+	t.Cleanup(func() {
+		stateDir, instanceIDFile, archiveDir, recipeDBFile, instanceID, now = oldDir, oldID, oldArchive, oldDB, oldInstance, oldNow
+	})
+	stateDir = filepath.Join(td, "state")
+	instanceIDFile = filepath.Join(stateDir, "osconfig_instance_id")
+	archiveDir = filepath.Join(stateDir, "state_archive")
+	db := filepath.Join(td, "osconfig_recipedb")
+	recipeDBFile = func() string { return db }
+	now = func() time.Time { return time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC) }
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{filepath.Join(stateDir, "osconfig_task.state"), filepath.Join(stateDir, "history.jsonl"), db} {
+		if err := ioutil.WriteFile(f, []byte("state"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return td
+}
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	td := setTestState(t)
+	id := "111"
+	instanceID = func() string { return id }
+
+	// The first run records the instance ID and keeps the state.
+	reset, err := Check(ctx)
+	if err != nil || reset {
+		t.Fatalf("Check() = %t, %v, want false, nil", reset, err)
+	}
+	if got, want := listDir(t, stateDir), []string{"history.jsonl", "osconfig_instance_id", "osconfig_task.state"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("state after first run = %q, want %q", got, want)
+	}
+
+	// Same instance, nothing changes.
+	if reset, err := Check(ctx); err != nil || reset {
+		t.Fatalf("Check() = %t, %v, want false, nil", reset, err)
+	}
+
+	// A clone archives the state, including the recipe database.
+	id = "222"
+	if reset, err := Check(ctx); err != nil || !reset {
+		t.Fatalf("Check() = %t, %v, want true, nil", reset, err)
+	}
+	if got, want := listDir(t, stateDir), []string{"osconfig_instance_id", "state_archive"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("state after clone = %q, want %q", got, want)
+	}
+	archived := filepath.Join(archiveDir, "20240501T100000Z-instance-111")
+	if got, want := listDir(t, archived), []string{"history.jsonl", "osconfig_instance_id", "osconfig_recipedb", "osconfig_task.state"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archived state = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(td, "osconfig_recipedb")); !os.IsNotExist(err) {
+		t.Errorf("recipe database was not archived: %v", err)
+	}
+	data, err := ioutil.ReadFile(instanceIDFile)
+	if err != nil || string(data) != "222\n" {
+		t.Errorf("recorded instance ID = %q, %v, want %q", data, err, "222\n")
+	}
+}
+
+func TestCheckNoInstanceID(t *testing.T) {
+	setTestState(t)
+	instanceID = func() string { return "" }
+	if reset, err := Check(context.Background()); err != nil || reset {
+		t.Fatalf("Check() = %t, %v, want false, nil", reset, err)
+	}
+	if _, err := os.Stat(instanceIDFile); !os.IsNotExist(err) {
+		t.Errorf("instance ID recorded without metadata: %v", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	ctx := context.Background()
+	setTestState(t)
+	maxArchives = 2
+	defer func() { maxArchives = 3 }()
+
+	var dirs []string
+	for i := 0; i < 3; i++ {
+		i := i
+		now = func() time.Time { return time.Date(2024, 5, 1+i, 0, 0, 0, 0, time.UTC) }
+		if err := ioutil.WriteFile(filepath.Join(stateDir, "osconfig_task.state"), []byte("state"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		dir, err := Reset(ctx, "manual")
+		if err != nil {
+			t.Fatalf("Reset() error: %v", err)
+		}
+		dirs = append(dirs, filepath.Base(dir))
+	}
+	if got, want := listDir(t, archiveDir), dirs[1:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("archives = %q, want %q", got, want)
+	}
+
+	// Nothing left to reset.
+	dir, err := Reset(ctx, "manual")
+	if err != nil || dir != "" {
+		t.Errorf("Reset() with no state = %q, %v, want empty", dir, err)
+	}
+}
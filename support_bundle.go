@@ -0,0 +1,149 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/crashloop"
+	"github.com/GoogleCloudPlatform/osconfig/osinfo"
+	"github.com/GoogleCloudPlatform/osconfig/upload"
+)
+
+// maxBundleFileSize is the max size of a single cache file included in a
+// support bundle.
+const maxBundleFileSize = 10 * 1024 * 1024
+
+// bundleFiles are the cache files included in a support bundle. Only state
+// useful for debugging is listed, never key material or files holding
+// credentials.
+var bundleFiles = []string{
+	"osconfig_task.state",
+	"osconfig_recipedb",
+	"guest_policy.checkpoint",
+	"guest_policy.cache",
+	"managed_files.json",
+	"history.jsonl",
+	"osconfig_drift.state",
+	"osconfig_crashloop.state",
+	"osconfig_effective_policies.json",
+	"osconfig_policy_conflicts.json",
+	"config_package_info.cache",
+	"reboot_marker.json",
+	"last_boot_id",
+	"osconfig_instance_id",
+}
+
+type bundleInfo struct {
+	AgentVersion string         `json:"agentVersion"`
+	Created      time.Time      `json:"created"`
+	OSInfo       *osinfo.OSInfo `json:"osInfo"`
+	Skipped      []string       `json:"skipped,omitempty"`
+	// Crashes lists the features that crashed the agent, including
+	// quarantined ones.
+	Crashes []crashloop.Status `json:"crashes,omitempty"`
+}
+
+// supportBundle writes a tar.gz of the agent state files to dest, or to the
+// temp directory if dest is empty, and uploads it if an upload bucket is
+// configured.
+func supportBundle(ctx context.Context, dest string) error {
+	name := fmt.Sprintf("osconfig-support-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	if dest == "" {
+		dest = filepath.Join(os.TempDir(), name)
+	}
+
+	var buf bytes.Buffer
+	if err := writeBundle(ctx, &buf, agentconfig.CacheDir()); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dest, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote support bundle to %s\n", dest)
+
+	// The upload bucket is set in metadata.
+	if err := agentconfig.WatchConfig(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading agent config from metadata, not uploading support bundle: %v\n", err)
+		return nil
+	}
+	if !upload.Enabled() {
+		return nil
+	}
+	url, err := upload.Upload(ctx, upload.SupportBundle, name, &buf)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Uploaded support bundle to %s\n", url)
+	return nil
+}
+
+func writeBundle(ctx context.Context, w io.Writer, cacheDir string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	oi, _ := osinfo.Get()
+	info := &bundleInfo{AgentVersion: agentconfig.Version(), Created: time.Now().UTC(), OSInfo: oi, Crashes: crashloop.Statuses(ctx)}
+
+	for _, name := range bundleFiles {
+		fi, err := os.Lstat(filepath.Join(cacheDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil || !fi.Mode().IsRegular() || fi.Size() > maxBundleFileSize {
+			info.Skipped = append(info.Skipped, name)
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(cacheDir, name))
+		if err != nil {
+			info.Skipped = append(info.Skipped, name)
+			continue
+		}
+		if err := addBundleFile(tw, "cache/"+name, data); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addBundleFile(tw, "info.json", data); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func addBundleFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
 	"github.com/GoogleCloudPlatform/osconfig/clog"
+	"github.com/GoogleCloudPlatform/osconfig/crashreport"
 )
 
 var (
@@ -71,7 +72,7 @@ func tasker(ctx context.Context) {
 				return
 			}
 			clog.Debugf(ctx, "Tasker running %q.", t.name)
-			t.run()
+			runTask(ctx, t)
 			clog.Debugf(ctx, "Finished task %q.", t.name)
 			if agentconfig.FreeOSMemory() {
 				debug.FreeOSMemory()
@@ -79,3 +80,9 @@ func tasker(ctx context.Context) {
 		}
 	}
 }
+
+// runTask runs t, writing a crash report if it panics.
+func runTask(ctx context.Context, t *task) {
+	defer crashreport.Recover(ctx, t.name)
+	t.run()
+}
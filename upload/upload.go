@@ -0,0 +1,81 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package upload uploads agent generated artifacts such as support bundles,
+// patch reports and large exec outputs to the bucket set with
+// osconfig-upload-bucket, using the instance credentials.
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/external"
+)
+
+// Artifact kinds, used as part of the object name.
+const (
+	SupportBundle = "support-bundle"
+	PatchReport   = "patch-report"
+	ExecOutput    = "exec-output"
+)
+
+// ErrNoBucket is returned by Upload if no upload bucket is configured.
+var ErrNoBucket = errors.New("no upload bucket configured, set osconfig-upload-bucket")
+
+var (
+	bucket = agentconfig.UploadBucket
+	now    = time.Now
+
+	uploadObject = func(ctx context.Context, bucket, object string, r io.Reader) error {
+		cl, err := storage.NewClient(ctx)
+		if err != nil {
+			return fmt.Errorf("error creating gcs client: %v", err)
+		}
+		defer cl.Close()
+		return external.UploadGCSObject(ctx, cl, bucket, object, r)
+	}
+)
+
+// Enabled reports whether an upload bucket is configured.
+func Enabled() bool {
+	return bucket() != ""
+}
+
+// objectName names objects by zone, instance, kind and time so that
+// artifacts from a fleet can share one bucket and list in time order, e.g.
+// us-central1-a/my-instance/patch-report/20240102T150405Z-report.json.
+func objectName(kind, name string) string {
+	return path.Join(path.Base(agentconfig.Zone()), agentconfig.Name(), kind, now().UTC().Format("20060102T150405Z")+"-"+name)
+}
+
+// Upload uploads the contents of r as an artifact of the given kind and
+// returns its gs:// URL.
+func Upload(ctx context.Context, kind, name string, r io.Reader) (string, error) {
+	b := bucket()
+	if b == "" {
+		return "", ErrNoBucket
+	}
+	object := objectName(kind, name)
+	if err := uploadObject(ctx, b, object, r); err != nil {
+		return "", fmt.Errorf("error uploading to gs://%s/%s: %v", b, object, err)
+	}
+	return fmt.Sprintf("gs://%s/%s", b, object), nil
+}
@@ -0,0 +1,59 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package upload
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	oldBucket, oldNow, oldUpload := bucket, now, uploadObject
+	defer func() { bucket, now, uploadObject = oldBucket, oldNow, oldUpload }()
+	now = func() time.Time { return time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC) }
+
+	var gotBucket, gotObject, gotData string
+	uploadObject = func(_ context.Context, b, o string, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		gotBucket, gotObject, gotData = b, o, string(data)
+		return err
+	}
+
+	bucket = func() string { return "" }
+	if Enabled() {
+		t.Error("Enabled() = true with no bucket")
+	}
+	if _, err := Upload(ctx, PatchReport, "report.json", bytes.NewReader(nil)); err != ErrNoBucket {
+		t.Errorf("Upload() error = %v, want %v", err, ErrNoBucket)
+	}
+
+	bucket = func() string { return "my-bucket" }
+	url, err := Upload(ctx, PatchReport, "report.json", bytes.NewReader([]byte("data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantObject := "patch-report/20240102T150405Z-report.json"
+	if gotBucket != "my-bucket" || gotObject != wantObject || gotData != "data" {
+		t.Errorf("uploaded (%q, %q, %q), want (%q, %q, %q)", gotBucket, gotObject, gotData, "my-bucket", wantObject, "data")
+	}
+	if want := "gs://my-bucket/" + wantObject; url != want {
+		t.Errorf("Upload() = %q, want %q", url, want)
+	}
+}
@@ -0,0 +1,33 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// bootIDFile holds a random ID the kernel generates on every boot.
+var bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+// BootID returns an ID that changes on every boot.
+func BootID() (string, error) {
+	data, err := ioutil.ReadFile(bootIDFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading boot ID: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,29 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// BootID returns an ID that changes on every boot, the boot time rounded to
+// a minute to absorb clock adjustments.
+func BootID() (string, error) {
+	boot := time.Now().Add(-windows.DurationSinceBoot()).Truncate(time.Minute)
+	return strconv.FormatInt(boot.Unix(), 10), nil
+}
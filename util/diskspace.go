@@ -0,0 +1,101 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// InsufficientDiskSpaceError is returned when a filesystem does not have the
+// free space an operation needs.
+type InsufficientDiskSpaceError struct {
+	Paths []string
+}
+
+func (e *InsufficientDiskSpaceError) Error() string {
+	return "insufficient disk space on " + strings.Join(e.Paths, ",")
+}
+
+// IsInsufficientDiskSpace reports whether err is an InsufficientDiskSpaceError.
+func IsInsufficientDiskSpace(err error) bool {
+	var e *InsufficientDiskSpaceError
+	return errors.As(err, &e)
+}
+
+var (
+	freeSpace    = FreeSpace
+	filesystemID = fsID
+)
+
+// CheckDiskSpace verifies that the filesystems containing each path have at
+// least the required number of free bytes. Requirements for paths on the
+// same filesystem are added up, paths that do not exist are skipped.
+func CheckDiskSpace(required map[string]uint64) error {
+	return CheckDiskSpaceHeadroom(required, 0)
+}
+
+// CheckDiskSpaceHeadroom is CheckDiskSpace with headroom free bytes
+// required on top, once for each filesystem.
+func CheckDiskSpaceHeadroom(required map[string]uint64, headroom uint64) error {
+	var paths []string
+	for p := range required {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	type fs struct {
+		paths    []string
+		required uint64
+	}
+	var order []string
+	filesystems := map[string]*fs{}
+	for _, p := range paths {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			continue
+		}
+		id, err := filesystemID(p)
+		if err != nil {
+			return fmt.Errorf("error checking disk space on %q: %v", p, err)
+		}
+		f, ok := filesystems[id]
+		if !ok {
+			f = &fs{required: headroom}
+			filesystems[id] = f
+			order = append(order, id)
+		}
+		f.paths = append(f.paths, p)
+		f.required += required[p]
+	}
+
+	var low []string
+	for _, id := range order {
+		f := filesystems[id]
+		free, err := freeSpace(f.paths[0])
+		if err != nil {
+			return fmt.Errorf("error checking disk space on %q: %v", f.paths[0], err)
+		}
+		if free < f.required {
+			low = append(low, f.paths...)
+		}
+	}
+	if low != nil {
+		return &InsufficientDiskSpaceError{Paths: low}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckDiskSpace(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	a := filepath.Join(tmpDir, "a")
+	b := filepath.Join(tmpDir, "b")
+	c := filepath.Join(tmpDir, "c")
+	for _, d := range []string{a, b, c} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldFreeSpace, oldFilesystemID := freeSpace, filesystemID
+	defer func() { freeSpace, filesystemID = oldFreeSpace, oldFilesystemID }()
+	freeSpace = func(string) (uint64, error) { return 100, nil }
+	// a and b share a filesystem.
+	filesystemID = func(p string) (string, error) {
+		if p == c {
+			return "c", nil
+		}
+		return "ab", nil
+	}
+
+	tests := []struct {
+		desc     string
+		required map[string]uint64
+		headroom uint64
+		want     []string
+	}{
+		{"enough space", map[string]uint64{a: 50, c: 100}, 0, nil},
+		{"shared filesystem adds up", map[string]uint64{a: 60, b: 60, c: 10}, 0, []string{a, b}},
+		{"missing paths are skipped", map[string]uint64{filepath.Join(tmpDir, "missing"): 1000}, 0, nil},
+		{"not enough space", map[string]uint64{a: 10, c: 101}, 0, []string{c}},
+		{"headroom once per filesystem", map[string]uint64{a: 30, b: 30}, 40, nil},
+		{"not enough headroom", map[string]uint64{a: 30, c: 70}, 40, []string{c}},
+	}
+	for _, tt := range tests {
+		err := CheckDiskSpaceHeadroom(tt.required, tt.headroom)
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		e, ok := err.(*InsufficientDiskSpaceError)
+		if !ok || !IsInsufficientDiskSpace(err) {
+			t.Errorf("%s: expected InsufficientDiskSpaceError, got: %v", tt.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(e.Paths, tt.want) {
+			t.Errorf("%s: got paths %q, want %q", tt.desc, e.Paths, tt.want)
+		}
+	}
+}
+
+type sizedReader struct {
+	*bytes.Reader
+	size int64
+}
+
+func (r *sizedReader) Remain() int64 { return r.size }
+
+func TestAtomicWriteFileStreamDiskSpace(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldFreeSpace := freeSpace
+	defer func() { freeSpace = oldFreeSpace }()
+	freeSpace = func(string) (uint64, error) { return 10, nil }
+
+	path := filepath.Join(tmpDir, "file")
+	if _, err := AtomicWriteFileStream(&sizedReader{bytes.NewReader([]byte("data")), 100}, "", path, 0644); !IsInsufficientDiskSpace(err) {
+		t.Errorf("expected InsufficientDiskSpaceError, got: %v", err)
+	}
+	if Exists(path) {
+		t.Errorf("%q should not have been written", path)
+	}
+	if _, err := AtomicWriteFileStream(&sizedReader{bytes.NewReader([]byte("data")), 4}, "", path, 0644); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+const (
+	extendedPrefix    = `\\?\`
+	extendedUNCPrefix = `\\?\UNC\`
+	devicePrefix      = `\\.\`
+
+	// maxWorkingDir is the longest directory Windows accepts as the current
+	// directory of a process, MAX_PATH less the trailing backslash and NUL.
+	maxWorkingDir = 258
+)
+
+// extendedLengthPath returns the extended-length form of an absolute
+// Windows path, UNC paths (\\server\share\...) take the \\?\UNC\ form.
+// Paths already in extended-length or device form are returned as is.
+func extendedLengthPath(path string) string {
+	if strings.HasPrefix(path, extendedPrefix) || strings.HasPrefix(path, devicePrefix) {
+		return path
+	}
+	path = strings.ReplaceAll(path, "/", `\`)
+	if strings.HasPrefix(path, `\\`) {
+		return extendedUNCPrefix + strings.TrimPrefix(path, `\\`)
+	}
+	return extendedPrefix + path
+}
+
+// stripExtendedLengthPath undoes extendedLengthPath.
+func stripExtendedLengthPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, extendedUNCPrefix):
+		return `\\` + strings.TrimPrefix(path, extendedUNCPrefix)
+	case strings.HasPrefix(path, extendedPrefix):
+		return strings.TrimPrefix(path, extendedPrefix)
+	}
+	return path
+}
+
+// windowsWorkingDir returns dir in the form Windows accepts as a working
+// directory.
+func windowsWorkingDir(dir string) (string, error) {
+	dir = stripExtendedLengthPath(strings.ReplaceAll(dir, "/", `\`))
+	if len(dir) > maxWorkingDir {
+		return "", fmt.Errorf("working directory %q is longer than the %d characters Windows allows", dir, maxWorkingDir)
+	}
+	return dir, nil
+}
+
+// WorkingDir returns dir in a form that can be used as the working
+// directory of a command. Windows rejects extended-length paths there, as
+// returned by NormPath, and does not lift the MAX_PATH limit for it.
+func WorkingDir(dir string) (string, error) {
+	if runtime.GOOS != "windows" || dir == "" {
+		return dir, nil
+	}
+	return windowsWorkingDir(dir)
+}
@@ -0,0 +1,67 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtendedLengthPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{`C:\Program Files\app`, `\\?\C:\Program Files\app`},
+		{`C:/Program Files/app`, `\\?\C:\Program Files\app`},
+		{`\\server\share\dir\file`, `\\?\UNC\server\share\dir\file`},
+		{`//server/share/dir`, `\\?\UNC\server\share\dir`},
+		{`\\?\C:\already\long`, `\\?\C:\already\long`},
+		{`\\?\UNC\server\share`, `\\?\UNC\server\share`},
+		{`\\.\PhysicalDrive0`, `\\.\PhysicalDrive0`},
+	}
+	for _, tt := range tests {
+		got := extendedLengthPath(tt.path)
+		if got != tt.want {
+			t.Errorf("extendedLengthPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+		// Stripping the prefix gives back the original path.
+		if back, want := stripExtendedLengthPath(got), strings.ReplaceAll(stripExtendedLengthPath(tt.path), "/", `\`); back != want {
+			t.Errorf("stripExtendedLengthPath(%q) = %q, want %q", got, back, want)
+		}
+	}
+}
+
+func TestWindowsWorkingDir(t *testing.T) {
+	tests := []struct {
+		dir, want string
+		wantErr   bool
+	}{
+		{`\\?\C:\Windows\Temp\recipe\step00`, `C:\Windows\Temp\recipe\step00`, false},
+		{`\\?\UNC\server\share\recipe`, `\\server\share\recipe`, false},
+		{`C:/Windows/Temp`, `C:\Windows\Temp`, false},
+		{`C:\`, `C:\`, false},
+		{`\\?\C:\` + strings.Repeat(`deep\`, 60), "", true},
+	}
+	for _, tt := range tests {
+		got, err := windowsWorkingDir(tt.dir)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("windowsWorkingDir(%q) error = %v, wantErr %t", tt.dir, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("windowsWorkingDir(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ReadOnlyFilesystemError is returned when a path that needs to be written
+// is on a filesystem that is mounted read-only, such as the root of an
+// immutable image.
+type ReadOnlyFilesystemError struct {
+	Path string
+}
+
+func (e *ReadOnlyFilesystemError) Error() string {
+	return e.Path + " is on a read-only filesystem"
+}
+
+// IsReadOnlyFilesystem reports whether err is a ReadOnlyFilesystemError or a
+// write that failed with EROFS.
+func IsReadOnlyFilesystem(err error) bool {
+	var e *ReadOnlyFilesystemError
+	return errors.As(err, &e) || errors.Is(err, syscall.EROFS)
+}
+
+var readOnly = isReadOnly
+
+// CheckWritable returns a ReadOnlyFilesystemError if the filesystem that
+// holds path, or would hold it once created, is mounted read-only. Paths
+// that do not exist are checked at their nearest existing parent.
+func CheckWritable(path string) error {
+	p := filepath.Clean(path)
+	for {
+		if _, err := os.Lstat(p); err == nil {
+			break
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return nil
+		}
+		p = parent
+	}
+	ro, err := readOnly(p)
+	if err != nil {
+		return err
+	}
+	if ro {
+		return &ReadOnlyFilesystemError{Path: path}
+	}
+	return nil
+}
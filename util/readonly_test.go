@@ -0,0 +1,65 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCheckWritable(t *testing.T) {
+	tmpDir := t.TempDir()
+	ro := filepath.Join(tmpDir, "ro")
+	if err := os.Mkdir(ro, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	oldReadOnly := readOnly
+	defer func() { readOnly = oldReadOnly }()
+	var checked string
+	readOnly = func(p string) (bool, error) {
+		checked = p
+		return p == ro, nil
+	}
+
+	if err := CheckWritable(filepath.Join(tmpDir, "file")); err != nil {
+		t.Errorf("CheckWritable on a writable filesystem: %v", err)
+	}
+	if checked != tmpDir {
+		t.Errorf("CheckWritable checked %q, want the nearest existing parent %q", checked, tmpDir)
+	}
+
+	path := filepath.Join(ro, "missing", "file")
+	err := CheckWritable(path)
+	e, ok := err.(*ReadOnlyFilesystemError)
+	if !ok || !IsReadOnlyFilesystem(err) {
+		t.Fatalf("CheckWritable on a read-only filesystem, expected ReadOnlyFilesystemError, got: %v", err)
+	}
+	if e.Path != path {
+		t.Errorf("ReadOnlyFilesystemError.Path = %q, want %q", e.Path, path)
+	}
+}
+
+func TestIsReadOnlyFilesystem(t *testing.T) {
+	if !IsReadOnlyFilesystem(fmt.Errorf("error writing file: %w", &os.PathError{Op: "open", Path: "/etc/foo", Err: syscall.EROFS})) {
+		t.Error("IsReadOnlyFilesystem(EROFS) = false, want true")
+	}
+	if IsReadOnlyFilesystem(&os.PathError{Op: "open", Path: "/etc/foo", Err: syscall.EACCES}) {
+		t.Error("IsReadOnlyFilesystem(EACCES) = true, want false")
+	}
+}
@@ -0,0 +1,130 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// TxFile is a file written as part of AtomicWriteFiles.
+type TxFile struct {
+	Path    string
+	Content []byte
+	Mode    os.FileMode
+}
+
+// rename is replaced in tests to simulate failures part way through a commit.
+var rename = os.Rename
+
+type stagedFile struct {
+	path, tmp, backup string
+	committed         bool
+}
+
+// AtomicWriteFiles writes files as a single transaction. All files are first
+// staged next to their destination, then each destination is backed up with
+// a hard link and the staged file renamed over it, so a destination always
+// exists. If any step fails the files already committed are rolled back so
+// either all of the files are updated or none are.
+func AtomicWriteFiles(files []TxFile) (err error) {
+	var staged []*stagedFile
+	defer func() {
+		if err != nil {
+			rollback(staged)
+		}
+	}()
+
+	for _, f := range files {
+		path, err := NormPath(f.Path)
+		if err != nil {
+			return err
+		}
+		s := &stagedFile{path: path}
+		staged = append(staged, s)
+		tmp, err := TempFile(filepath.Dir(path), filepath.Base(path), f.Mode)
+		if err != nil {
+			return fmt.Errorf("unable to create temp file: %v", err)
+		}
+		s.tmp = tmp.Name()
+		if _, err := tmp.Write(f.Content); err != nil {
+			tmp.Close()
+			return fmt.Errorf("error staging %q: %v", f.Path, err)
+		}
+		if err := tmp.Close(); err != nil {
+			return fmt.Errorf("error staging %q: %v", f.Path, err)
+		}
+	}
+
+	for _, s := range staged {
+		if _, err := os.Stat(s.path); err == nil {
+			backup := s.tmp + ".bak"
+			if err := linkOrCopy(s.path, backup); err != nil {
+				return fmt.Errorf("error backing up %q: %v", s.path, err)
+			}
+			s.backup = backup
+		}
+		if err := rename(s.tmp, s.path); err != nil {
+			return fmt.Errorf("error committing %q: %v", s.path, err)
+		}
+		s.committed = true
+	}
+
+	for _, s := range staged {
+		if s.backup != "" {
+			os.Remove(s.backup)
+		}
+	}
+	return nil
+}
+
+// linkOrCopy hard links src to dst, copying it if the file system does not
+// support hard links.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, content, fi.Mode().Perm())
+}
+
+// rollback restores the original files replaced by a failed commit and
+// removes any staged files and backups.
+func rollback(staged []*stagedFile) {
+	for i := len(staged) - 1; i >= 0; i-- {
+		s := staged[i]
+		switch {
+		case s.committed && s.backup != "":
+			os.Rename(s.backup, s.path)
+		case s.committed:
+			os.Remove(s.path)
+		default:
+			if s.tmp != "" {
+				os.Remove(s.tmp)
+			}
+			if s.backup != "" {
+				os.Remove(s.backup)
+			}
+		}
+	}
+}
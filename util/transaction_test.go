@@ -0,0 +1,84 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, fi := range fis {
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[fi.Name()] = string(b)
+	}
+	return got
+}
+
+func TestAtomicWriteFiles(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key.gpg")
+	repo := filepath.Join(dir, "repo.list")
+	if err := ioutil.WriteFile(key, []byte("old key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []TxFile{{Path: key, Content: []byte("new key"), Mode: 0644}, {Path: repo, Content: []byte("new repo"), Mode: 0644}}
+
+	// Fail committing the second file, the first must be rolled back.
+	defer func() { rename = os.Rename }()
+	calls := 0
+	rename = func(from, to string) error {
+		calls++
+		// The destination is never missing while files are committed.
+		if _, err := os.Stat(key); err != nil {
+			t.Errorf("%q missing during commit: %v", key, err)
+		}
+		// Commit of key succeeds, commit of repo fails.
+		if calls == 2 {
+			return errors.New("rename failed")
+		}
+		return os.Rename(from, to)
+	}
+	if err := AtomicWriteFiles(files); err == nil {
+		t.Fatal("expected error from AtomicWriteFiles")
+	}
+	want := map[string]string{"key.gpg": "old key"}
+	if got := readDir(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("after failed commit got %q, want %q", got, want)
+	}
+
+	rename = os.Rename
+	if err := AtomicWriteFiles(files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = map[string]string{"key.gpg": "new key", "repo.list": "new repo"}
+	if got := readDir(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("after commit got %q, want %q", got, want)
+	}
+}
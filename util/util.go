@@ -29,6 +29,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/osconfig/clog"
@@ -60,7 +61,7 @@ func NormPath(path string) (string, error) {
 		return path, nil
 	}
 
-	return `\\?\` + strings.ReplaceAll(path, "/", `\`), nil
+	return extendedLengthPath(path), nil
 }
 
 // Exists check for the existence of a file
@@ -82,6 +83,14 @@ func AtomicWriteFileStream(r io.Reader, checksum, path string, mode os.FileMode)
 		return "", err
 	}
 
+	// Readers that know their size, such as GCS readers, are checked against
+	// the free space up front instead of failing part way through.
+	if s, ok := r.(interface{ Remain() int64 }); ok && s.Remain() > 0 {
+		if err := CheckDiskSpace(map[string]uint64{filepath.Dir(path): uint64(s.Remain())}); err != nil {
+			return "", err
+		}
+	}
+
 	tmp, err := TempFile(filepath.Dir(path), filepath.Base(path), mode)
 	if err != nil {
 		return "", fmt.Errorf("unable to create temp file: %v", err)
@@ -122,12 +131,45 @@ type CommandRunner interface {
 // DefaultRunner is a default CommandRunner.
 type DefaultRunner struct{}
 
+var (
+	commandRecorderMx sync.Mutex
+	commandRecorder   func(path string)
+)
+
+// SetCommandRecorder sets a function that is called with the path of each
+// command run by DefaultRunner, nil stops recording.
+func SetCommandRecorder(f func(path string)) {
+	commandRecorderMx.Lock()
+	defer commandRecorderMx.Unlock()
+	commandRecorder = f
+}
+
+func recordCommand(path string) {
+	commandRecorderMx.Lock()
+	defer commandRecorderMx.Unlock()
+	if commandRecorder != nil {
+		commandRecorder(path)
+	}
+}
+
 // Run takes precreated exec.Cmd and returns the stdout and stderr.
+// If cmd.Stdout or cmd.Stderr is already set the output is also copied to
+// it as it is produced, this lets callers follow the progress of long
+// running commands.
 func (r *DefaultRunner) Run(ctx context.Context, cmd *exec.Cmd) ([]byte, []byte, error) {
 	clog.Debugf(ctx, "Running %q with args %q\n", cmd.Path, cmd.Args[1:])
+	recordCommand(cmd.Path)
 	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	if cmd.Stdout != nil {
+		cmd.Stdout = io.MultiWriter(&stdout, cmd.Stdout)
+	} else {
+		cmd.Stdout = &stdout
+	}
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, cmd.Stderr)
+	} else {
+		cmd.Stderr = &stderr
+	}
 	err := cmd.Run()
 	clog.DebugStructured(
 		ctx,
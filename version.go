@@ -0,0 +1,114 @@
+//  Copyright 2024 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/GoogleCloudPlatform/osconfig/agentconfig"
+	"github.com/GoogleCloudPlatform/osconfig/packages"
+)
+
+const versionUsage = "usage: version [--json]"
+
+// protocols are the service and local API versions this agent speaks.
+var protocols = map[string][]string{
+	// v1 serves OS policy and patch tasks, v1beta serves guest policies.
+	"agentendpoint": {"v1", "v1beta"},
+	"localapi":      {"v1"},
+}
+
+// versionInfo describes this agent build and what it supports, it is
+// written by version --json for fleet tooling to compare agents.
+type versionInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commitTime,omitempty"`
+	Modified   bool   `json:"modified,omitempty"`
+	GoVersion  string `json:"goVersion"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	// Features are the agent features and whether they are enabled by the
+	// current config, ConfigError is set if it could not be read.
+	Features    map[string]bool     `json:"features"`
+	ConfigError string              `json:"configError,omitempty"`
+	Backends    map[string]bool     `json:"backends"`
+	Protocols   map[string][]string `json:"protocols"`
+}
+
+func newVersionInfo() *versionInfo {
+	info := &versionInfo{
+		Version:   agentconfig.Version(),
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Backends:  packages.Backends(),
+		Protocols: protocols,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Commit = s.Value
+			case "vcs.time":
+				info.CommitTime = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+
+	// The features are reported with their defaults if the config can't be
+	// read.
+	if err := agentconfig.ReadConfig(); err != nil {
+		info.ConfigError = err.Error()
+	}
+	info.Features = map[string]bool{
+		"guestPolicies":       agentconfig.GuestPoliciesEnabled(),
+		"osInventory":         agentconfig.OSInventoryEnabled(),
+		"tasks":               agentconfig.TaskNotificationEnabled(),
+		"guestAttributes":     agentconfig.GuestAttributesEnabled(),
+		"localApi":            agentconfig.LocalAPIEnabled(),
+		"privilegeHelper":     agentconfig.PrivilegeHelper(),
+		"rpmdbDirect":         agentconfig.RPMDBDirect(),
+		"aptDeb822":           agentconfig.AptDeb822(),
+		"npmInventory":        agentconfig.NpmInventoryEnabled(),
+		"wslPackageInventory": agentconfig.WSLPackageInventoryEnabled(),
+		"crashReportUpload":   agentconfig.CrashReportUpload(),
+	}
+	return info
+}
+
+// printVersion writes the agent version to w, or with --json a versionInfo
+// document.
+func printVersion(ctx context.Context, w io.Writer, args []string) error {
+	switch {
+	case len(args) == 0:
+		_, err := fmt.Fprintln(w, agentconfig.Version())
+		return err
+	case len(args) == 1 && (args[0] == "--json" || args[0] == "-json"):
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(newVersionInfo())
+	default:
+		return errors.New(versionUsage)
+	}
+}